@@ -3,15 +3,20 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"os/signal"
 	"runtime/pprof"
 	"strings"
 	"syscall"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/operable/go-relay/relay"
+	"github.com/operable/go-relay/relay/admin"
 	"github.com/operable/go-relay/relay/config"
+	"github.com/operable/go-relay/relay/journal"
 )
 
 const (
@@ -69,6 +74,15 @@ func configureLogger(config *config.Config) {
 		fallthrough
 	case "stdout":
 		log.SetOutput(os.Stdout)
+	case "journald":
+		hook, err := journal.NewHook()
+		if err != nil {
+			log.SetOutput(os.Stdout)
+			log.Warnf("Failed to connect to journald at %s: %s. Logging to stdout instead.", journal.SocketPath, err)
+			break
+		}
+		log.AddHook(hook)
+		log.SetOutput(ioutil.Discard)
 	default:
 		logFile, err := os.OpenFile(config.LogPath, os.O_WRONLY | os.O_APPEND | os.O_CREATE, 0644)
 		if err != nil {
@@ -94,7 +108,7 @@ func configureLogger(config *config.Config) {
 	}
 }
 
-func tryLoadingConfig(locations []string) config.RawConfig {
+func tryLoadingConfig(locations []string) (config.RawConfig, string) {
 	for _, location := range locations {
 		rawConfig, err := config.LoadConfig(location)
 		if err != nil {
@@ -103,9 +117,9 @@ func tryLoadingConfig(locations []string) config.RawConfig {
 		} else {
 			log.Infof("Using config file '%s'.", location)
 		}
-		return rawConfig
+		return rawConfig, location
 	}
-	return make(config.RawConfig, 0)
+	return make(config.RawConfig, 0), ""
 }
 
 func prepare() *config.Config {
@@ -117,7 +131,7 @@ func prepare() *config.Config {
 		}
 	}
 
-	rawConfig := tryLoadingConfig(locations)
+	rawConfig, configPath := tryLoadingConfig(locations)
 	relayConfig, err := rawConfig.Parse(commanddrivertag)
 	if err != nil {
 		logMessage := ""
@@ -141,12 +155,57 @@ func prepare() *config.Config {
 		return nil
 	}
 	relayConfig.DevMode = *devMode
+	relayConfig.Path = configPath
+	relayConfig.Build = config.BuildInfo{
+		Hash:             buildhash,
+		Tag:              buildtag,
+		Timestamp:        buildstamp,
+		CommandDriverTag: commanddrivertag,
+	}
 	configureLogger(relayConfig)
 	return relayConfig
 }
 
+// fetchSupportBundle downloads a support bundle from a running Relay's
+// admin API. Invoked as `relay support-bundle [output file]`.
+func fetchSupportBundle(relayConfig *config.Config, outputPath string) int {
+	if relayConfig.Admin.Enabled == false {
+		log.Error("Generating a support bundle requires the admin API to be enabled.")
+		return BAD_CONFIG
+	}
+	if outputPath == "" {
+		outputPath = fmt.Sprintf("relay-support-%s.tar.gz", time.Now().UTC().Format("20060102T150405Z"))
+	}
+	resp, err := admin.Get(*relayConfig.Admin, "/support-bundle")
+	if err != nil {
+		log.Errorf("Failed to fetch support bundle from Relay admin API at %s: %s.", relayConfig.Admin.Listen, err)
+		return 1
+	}
+	defer resp.Body.Close()
+	out, err := os.Create(outputPath)
+	if err != nil {
+		log.Errorf("Failed to create %s: %s.", outputPath, err)
+		return 1
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		log.Errorf("Failed to write support bundle to %s: %s.", outputPath, err)
+		return 1
+	}
+	log.Infof("Support bundle written to %s.", outputPath)
+	return 0
+}
+
 func main() {
 	relayConfig := prepare()
+	if flag.Arg(0) == "support-bundle" {
+		os.Exit(fetchSupportBundle(relayConfig, flag.Arg(1)))
+	}
+	if relayConfig.Attested == true && (*cpuprofile != "" || *memprofile != "") {
+		log.Error("Attested mode forbids profiling.")
+		os.Exit(BAD_CONFIG)
+		return
+	}
 	if *cpuprofile != "" {
 		f, err := os.Create(*cpuprofile)
 		if err != nil {
@@ -189,18 +248,37 @@ func main() {
 	interruptChannel := make(chan os.Signal, 1)
 	signal.Notify(interruptChannel, syscall.SIGINT)
 
-	// Handle HUP signals by reopening logfiles
+	// Handle HUP signals by reloading the config file and reopening
+	// logfiles
 	hupChannel := make(chan os.Signal, 1)
 	signal.Notify(hupChannel, syscall.SIGHUP)
 	go func() {
 		for {
 			<-hupChannel
+			if relayConfig.Path != "" {
+				myRelay.Reload()
+			}
 			configureLogger(relayConfig)
 		}
 	}()
 
-	// Wait until we get an interrupt signal
-	<-interruptChannel
+	// USR1 puts Relay into maintenance mode and USR2 takes it out
+	maintenanceChannel := make(chan os.Signal, 1)
+	signal.Notify(maintenanceChannel, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range maintenanceChannel {
+			myRelay.SetMaintenance(sig == syscall.SIGUSR1, "")
+		}
+	}()
+
+	// Wait until we get an interrupt signal or Relay fails
+	var failure error
+	select {
+	case <-interruptChannel:
+	case <-myRelay.StopRequested():
+	case failure = <-myRelay.Failed():
+		log.Error(failure)
+	}
 
 	// Shutdown
 	// Remove signal handler so Ctrl-C works
@@ -209,4 +287,21 @@ func main() {
 	log.Info("Starting shut down.")
 	myRelay.Stop()
 	log.Infof("Relay %s shut down complete.", relayConfig.ID)
+	if failure == relay.ErrRestartRequired {
+		restart()
+	}
+	if failure != nil {
+		os.Exit(1)
+	}
+}
+
+// restart replaces the running process with a fresh copy of Relay
+// started with the same arguments and environment
+func restart() {
+	executable, err := os.Executable()
+	if err == nil {
+		log.Info("Restarting Relay.")
+		err = syscall.Exec(executable, os.Args, os.Environ())
+	}
+	log.Errorf("Failed to restart Relay: %s.", err)
 }
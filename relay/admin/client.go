@@ -0,0 +1,54 @@
+package admin
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/operable/go-relay/relay/config"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+var clientTimeout = time.Duration(2) * time.Minute
+
+// Get issues a GET request for path against a running Relay's admin API
+func Get(adminConfig config.AdminInfo, path string) (*http.Response, error) {
+	transport := &http.Transport{
+		Dial: func(_, _ string) (net.Conn, error) {
+			return net.Dial(adminConfig.Network(), adminConfig.Address())
+		},
+	}
+	scheme := "http"
+	if adminConfig.TLSEnabled() {
+		// The listener is dialed directly from the local config so
+		// the server's certificate needn't match the placeholder host
+		scheme = "https"
+		transport.TLSClientConfig = &tls.Config{
+			InsecureSkipVerify: true,
+		}
+	}
+	client := &http.Client{
+		Timeout:   clientTimeout,
+		Transport: transport,
+	}
+	// The host is ignored since every request is dialed to the admin
+	// API's listener
+	req, err := http.NewRequest("GET", scheme+"://relay-admin"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if adminConfig.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+adminConfig.Token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("Admin API returned status %d: %s", resp.StatusCode, body)
+	}
+	return resp, nil
+}
@@ -0,0 +1,142 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	log "github.com/Sirupsen/logrus"
+	"github.com/operable/go-relay/relay/certs"
+	"github.com/operable/go-relay/relay/config"
+	"net"
+	"net/http"
+	"os"
+)
+
+var errorUnauthorized = errors.New("Missing or invalid admin token")
+
+// Server is Relay's local admin HTTP API. Subsystems register their
+// endpoints with HandleFunc before the server is started.
+type Server struct {
+	config   config.AdminInfo
+	mux      *http.ServeMux
+	listener net.Listener
+	server   *http.Server
+	keyPair  *certs.KeyPair
+}
+
+// NewServer constructs a new admin API server
+func NewServer(adminConfig config.AdminInfo) *Server {
+	return &Server{
+		config: adminConfig,
+		mux:    http.NewServeMux(),
+	}
+}
+
+// HandleFunc registers an endpoint handler
+func (s *Server) HandleFunc(pattern string, handler http.HandlerFunc) {
+	s.mux.HandleFunc(pattern, handler)
+}
+
+// Start opens the admin API's listener and begins serving requests
+// in a goroutine
+func (s *Server) Start() error {
+	if s.config.Network() == "unix" {
+		// Remove stale sockets left behind by a previous Relay process
+		os.Remove(s.config.Address())
+	}
+	listener, err := net.Listen(s.config.Network(), s.config.Address())
+	if err != nil {
+		return err
+	}
+	if s.config.TLSEnabled() {
+		if listener, err = s.listenTLS(listener); err != nil {
+			return err
+		}
+	}
+	s.listener = listener
+	s.server = &http.Server{
+		Handler: s.authenticate(s.mux),
+	}
+	go func() {
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Errorf("Admin API stopped unexpectedly: %s.", err)
+		}
+	}()
+	log.Infof("Admin API listening on %s.", s.config.Listen)
+	return nil
+}
+
+// Stop closes the admin API's listener
+func (s *Server) Stop() {
+	if s.server != nil {
+		s.server.Close()
+	}
+	if s.keyPair != nil {
+		s.keyPair.Stop()
+	}
+}
+
+// authenticate requires requests to carry admin/token as a bearer
+// token when one is configured
+func (s *Server) authenticate(handler http.Handler) http.Handler {
+	if s.config.Token == "" {
+		return handler
+	}
+	expected := []byte("Bearer " + s.config.Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			WriteError(w, http.StatusUnauthorized, errorUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, req)
+	})
+}
+
+// listenTLS wraps listener with TLS using a certificate which is
+// reloaded whenever its files change
+func (s *Server) listenTLS(listener net.Listener) (net.Listener, error) {
+	keyPair, err := certs.NewKeyPair(s.config.TLSCert, s.config.TLSKey)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	if err := keyPair.Watch(); err != nil {
+		log.Warnf("Failed to watch admin API TLS certificate %s: %s. Certificate changes require a restart.",
+			s.config.TLSCert, err)
+	}
+	s.keyPair = keyPair
+	return tls.NewListener(listener, &tls.Config{
+		GetCertificate: keyPair.GetCertificate,
+	}), nil
+}
+
+// WriteJSON writes value to the response as JSON with the given HTTP status
+func WriteJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(value)
+}
+
+// WriteError writes a JSON error response
+func WriteError(w http.ResponseWriter, status int, err error) {
+	WriteJSON(w, status, map[string]string{
+		"error": err.Error(),
+	})
+}
+
+// RequireMethod writes a 405 response and returns false if the request's
+// method doesn't match
+func RequireMethod(w http.ResponseWriter, req *http.Request, method string) bool {
+	if req.Method != method {
+		w.Header().Set("Allow", method)
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{
+			"error": "Method not allowed",
+		})
+		return false
+	}
+	return true
+}
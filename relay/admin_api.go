@@ -0,0 +1,217 @@
+package relay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/operable/go-relay/relay/admin"
+	"github.com/operable/go-relay/relay/config"
+	"github.com/operable/go-relay/relay/history"
+	"github.com/operable/go-relay/relay/messages"
+	"github.com/operable/go-relay/relay/metrics"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+var errorFactsDisabled = errors.New("Host facts are disabled")
+
+// maxConfigPreviewSize limits the size of candidate configs
+const maxConfigPreviewSize = 1 << 20
+
+func (r *cogRelay) registerAdminHandlers() {
+	r.admin.HandleFunc("/facts", r.adminFacts)
+	r.admin.HandleFunc("/read-only", r.adminReadOnly)
+	r.admin.HandleFunc("/maintenance", r.adminMaintenance)
+	r.admin.HandleFunc("/support-bundle", r.adminSupportBundle)
+	r.admin.HandleFunc("/bundles/history", r.adminBundleHistory)
+	r.admin.HandleFunc("/config/hash", r.adminConfigHash)
+	r.admin.HandleFunc("/config/preview", r.adminConfigPreview)
+	r.admin.HandleFunc("/config/reload", r.adminConfigReload)
+	r.admin.HandleFunc("/metrics", r.adminMetrics)
+	r.admin.HandleFunc("/protocol", r.adminProtocol)
+	r.admin.HandleFunc("/state", r.adminState)
+	r.admin.HandleFunc("/bundles", r.adminBundles)
+	r.admin.HandleFunc("/bundles/refresh", r.adminBundleRefresh)
+	r.admin.HandleFunc("/queue", r.adminQueue)
+	r.admin.HandleFunc("/events", r.adminEvents)
+	r.admin.HandleFunc("/dead-letters", r.adminDeadLetters)
+	r.admin.HandleFunc("/dead-letters/requeue", r.adminRequeueDeadLetter)
+	r.admin.HandleFunc("/drain", r.adminDrain)
+	r.admin.HandleFunc("/stop", r.adminStop)
+}
+
+// adminProtocol reports the outcome of the last protocol handshake.
+// cog_protocol_version is 0 until the handshake has finished.
+func (r *cogRelay) adminProtocol(w http.ResponseWriter, req *http.Request) {
+	if !admin.RequireMethod(w, req, "GET") {
+		return
+	}
+	cogVersion, features := r.protocol.get()
+	admin.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"protocol_version":     messages.ProtocolVersion,
+		"cog_protocol_version": cogVersion,
+		"features":             features,
+	})
+}
+
+func (r *cogRelay) adminMetrics(w http.ResponseWriter, req *http.Request) {
+	if !admin.RequireMethod(w, req, "GET") {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	if err := metrics.WriteText(w); err != nil {
+		log.Errorf("Error writing metrics: %s.", err)
+	}
+}
+
+func (r *cogRelay) adminFacts(w http.ResponseWriter, req *http.Request) {
+	if !admin.RequireMethod(w, req, "GET") {
+		return
+	}
+	if r.facts == nil {
+		admin.WriteError(w, http.StatusNotFound, errorFactsDisabled)
+		return
+	}
+	admin.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"gathered_at": r.facts.GatheredAt(),
+		"facts":       r.facts.Current(),
+	})
+}
+
+func (r *cogRelay) adminReadOnly(w http.ResponseWriter, req *http.Request) {
+	if req.Method == "POST" {
+		var update messages.ReadOnly
+		if err := json.NewDecoder(req.Body).Decode(&update); err != nil {
+			admin.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		r.setReadOnly(update.Enabled, update.Message)
+	} else if !admin.RequireMethod(w, req, "GET") {
+		return
+	}
+	enabled, message := r.readOnly.Status()
+	admin.WriteJSON(w, http.StatusOK, messages.ReadOnly{
+		Enabled: enabled,
+		Message: message,
+	})
+}
+
+func (r *cogRelay) adminSupportBundle(w http.ResponseWriter, req *http.Request) {
+	if !admin.RequireMethod(w, req, "GET") {
+		return
+	}
+	name := fmt.Sprintf("relay-support-%s.tar.gz", time.Now().UTC().Format("20060102T150405Z"))
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", name))
+	if err := r.writeSupportBundle(w); err != nil {
+		// Headers have already been sent so the best we can do is log
+		log.Errorf("Failed to generate support bundle: %s.", err)
+	}
+}
+
+// adminBundleHistory returns bundle assignment and announcement
+// snapshots. Accepts optional bundle, kind, and since (RFC 3339)
+// query parameters.
+func (r *cogRelay) adminBundleHistory(w http.ResponseWriter, req *http.Request) {
+	if !admin.RequireMethod(w, req, "GET") {
+		return
+	}
+	params := req.URL.Query()
+	query := history.Query{
+		Kind:   params.Get("kind"),
+		Bundle: params.Get("bundle"),
+	}
+	if since := params.Get("since"); since != "" {
+		parsed, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			admin.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		query.Since = parsed
+	}
+	admin.WriteJSON(w, http.StatusOK, r.history.Query(query))
+}
+
+func (r *cogRelay) adminConfigHash(w http.ResponseWriter, req *http.Request) {
+	if !admin.RequireMethod(w, req, "GET") {
+		return
+	}
+	hash, err := r.currentConfig().Hash()
+	if err != nil {
+		admin.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	admin.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"attested":    r.currentConfig().Attested,
+		"config_hash": hash,
+	})
+}
+
+// adminConfigPreview validates a candidate config and reports how it
+// differs from the running config without applying anything
+func (r *cogRelay) adminConfigPreview(w http.ResponseWriter, req *http.Request) {
+	if !admin.RequireMethod(w, req, "POST") {
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, maxConfigPreviewSize))
+	if err != nil {
+		admin.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	candidate, err := config.RawConfig(body).Parse(r.currentConfig().Build.CommandDriverTag)
+	if err == nil {
+		candidate.DevMode = r.currentConfig().DevMode
+		candidate.Build = r.currentConfig().Build
+		err = candidate.Verify()
+	}
+	if err != nil {
+		admin.WriteJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+			"valid": false,
+			"error": fmt.Sprintf("%s", err),
+		})
+		return
+	}
+	changes := r.currentConfig().Diff(candidate)
+	restartRequired := false
+	for _, change := range changes {
+		if change.Apply == config.RestartApply {
+			restartRequired = true
+		}
+	}
+	admin.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"valid":            true,
+		"restart_required": restartRequired,
+		"changes":          changes,
+	})
+}
+
+// adminConfigReload re-reads the config file and applies every setting
+// which doesn't require a restart
+func (r *cogRelay) adminConfigReload(w http.ResponseWriter, req *http.Request) {
+	if !admin.RequireMethod(w, req, "POST") {
+		return
+	}
+	changes, err := r.Reload()
+	if err != nil {
+		admin.WriteJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+			"reloaded": false,
+			"error":    fmt.Sprintf("%s", err),
+		})
+		return
+	}
+	restartRequired := []string{}
+	for _, change := range changes {
+		if change.Apply == config.RestartApply {
+			restartRequired = append(restartRequired, change.Setting)
+		}
+	}
+	admin.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"reloaded":         true,
+		"restart_required": restartRequired,
+		"changes":          changes,
+	})
+}
@@ -0,0 +1,162 @@
+package relay
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/operable/go-relay/relay/admin"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// adminBundle describes one bundle in the catalog
+type adminBundle struct {
+	Name          string `json:"name"`
+	Version       string `json:"version"`
+	Image         string `json:"image,omitempty"`
+	Available     bool   `json:"available"`
+	PullFailures  int    `json:"pull_failures,omitempty"`
+	LastPullError string `json:"last_pull_error,omitempty"`
+}
+
+// adminState reports Relay's lifecycle state
+func (r *cogRelay) adminState(w http.ResponseWriter, req *http.Request) {
+	if !admin.RequireMethod(w, req, "GET") {
+		return
+	}
+	state, connected, lastRefresh := r.status.get()
+	readOnly, _ := r.readOnly.Status()
+	maintenance, _ := r.maintenance.Status()
+	response := map[string]interface{}{
+		"relay":        r.currentConfig().ID,
+		"version":      r.currentConfig().Build.Tag,
+		"state":        state.String(),
+		"connected":    connected,
+		"read_only":    readOnly,
+		"maintenance":  maintenance,
+		"flapping":     r.status.isFlapping(),
+		"transitions":  r.StateHistory(),
+		"last_refresh": nil,
+	}
+	if lastRefresh.IsZero() == false {
+		response["last_refresh"] = lastRefresh
+	}
+	if r.singletons != nil {
+		response["singletons"] = r.singletons.Leading()
+	}
+	admin.WriteJSON(w, http.StatusOK, response)
+}
+
+// adminBundles lists the bundles assigned to Relay, sorted by name
+func (r *cogRelay) adminBundles(w http.ResponseWriter, req *http.Request) {
+	if !admin.RequireMethod(w, req, "GET") {
+		return
+	}
+	names := r.catalog.BundleNames()
+	sort.Strings(names)
+	bundles := []adminBundle{}
+	for _, name := range names {
+		b := r.catalog.Find(name)
+		if b == nil {
+			continue
+		}
+		entry := adminBundle{
+			Name:      b.Name,
+			Version:   b.Version,
+			Available: b.IsAvailable(),
+		}
+		if b.IsDocker() {
+			entry.Image = b.Docker.Image + ":" + b.Docker.Tag
+		}
+		entry.PullFailures, entry.LastPullError = b.PullFailures()
+		bundles = append(bundles, entry)
+	}
+	admin.WriteJSON(w, http.StatusOK, bundles)
+}
+
+// adminBundleRefresh asks Cog for Relay's bundle assignment without
+// waiting for the next scheduled refresh
+func (r *cogRelay) adminBundleRefresh(w http.ResponseWriter, req *http.Request) {
+	if !admin.RequireMethod(w, req, "POST") {
+		return
+	}
+	if r.conn == nil {
+		admin.WriteError(w, http.StatusServiceUnavailable, errorCogUnreachable)
+		return
+	}
+	if err := r.requestBundles(); err != nil {
+		admin.WriteError(w, http.StatusBadGateway, err)
+		return
+	}
+	log.Info("Bundle catalog refresh requested through the admin API.")
+	admin.WriteJSON(w, http.StatusAccepted, map[string]interface{}{
+		"requested": true,
+	})
+}
+
+// adminQueue reports request queue and worker statistics
+func (r *cogRelay) adminQueue(w http.ResponseWriter, req *http.Request) {
+	if !admin.RequireMethod(w, req, "GET") {
+		return
+	}
+	admin.WriteJSON(w, http.StatusOK, r.queueStats())
+}
+
+func (r *cogRelay) queueStats() map[string]interface{} {
+	workers := 0
+	inFlight := 0
+	if r.workers != nil {
+		for _, worker := range r.workers.Status() {
+			if worker.Alive == true {
+				workers++
+			}
+			if worker.Busy == true {
+				inFlight++
+			}
+		}
+	}
+	depth, capacity := r.queueDepth()
+	stats := map[string]interface{}{
+		"queue_depth":       depth,
+		"queue_capacity":    capacity,
+		"background_queued": len(r.backgroundQueue),
+		"held_by_bundle":    r.bundleLimits.Held(),
+		"average_wait_ms":   int64(r.queueWaits.Average() / time.Millisecond),
+		"saturated":         r.pressure.get(),
+		"in_flight":         inFlight,
+		"workers":           workers,
+		"concurrency_limit": r.limiter.Status().Limit,
+		"draining":          r.State() >= RelayDraining,
+	}
+	if r.autoscaler != nil {
+		stats["min_workers"], stats["max_workers"] = r.autoscaler.Bounds()
+	}
+	return stats
+}
+
+// adminDrain stops Relay accepting new commands while letting queued
+// and running ones finish. Commands arriving afterwards are refused.
+// Draining lasts until Relay is restarted.
+func (r *cogRelay) adminDrain(w http.ResponseWriter, req *http.Request) {
+	if !admin.RequireMethod(w, req, "POST") {
+		return
+	}
+	if r.setState(RelayDraining) {
+		log.Warn("Draining requested through the admin API. New commands will be refused.")
+	}
+	admin.WriteJSON(w, http.StatusOK, r.queueStats())
+}
+
+// adminStop shuts Relay down the same way as SIGINT
+func (r *cogRelay) adminStop(w http.ResponseWriter, req *http.Request) {
+	if !admin.RequireMethod(w, req, "POST") {
+		return
+	}
+	admin.WriteJSON(w, http.StatusAccepted, map[string]interface{}{
+		"stopping": true,
+	})
+	select {
+	case r.stopRequested <- struct{}{}:
+		log.Info("Stop requested through the admin API.")
+	default:
+	}
+}
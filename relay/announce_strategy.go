@@ -0,0 +1,80 @@
+package relay
+
+import (
+	"github.com/operable/go-relay/relay/bundle"
+	"github.com/operable/go-relay/relay/config"
+	"time"
+)
+
+// AnnouncementStrategy decides when Relay announces its bundles to
+// Cog and which bundles each announcement lists
+type AnnouncementStrategy interface {
+	// Bundles returns the bundles to announce
+	Bundles(catalog *bundle.Catalog) []config.Bundle
+	// ShouldAnnounce is asked before each requested announcement,
+	// which happen after catalog changes and reconnects. Returning
+	// false skips it.
+	ShouldAnnounce(catalog *bundle.Catalog) bool
+	// ReannounceInterval is how often to announce again even if
+	// nothing was requested. Zero disables periodic announcements.
+	ReannounceInterval() time.Duration
+}
+
+// AnnounceOnRequest announces the available bundles every time an
+// announcement is requested. It is the default strategy.
+type AnnounceOnRequest struct{}
+
+// Bundles is required by the AnnouncementStrategy interface
+func (AnnounceOnRequest) Bundles(catalog *bundle.Catalog) []config.Bundle {
+	return getBundles(catalog)
+}
+
+// ShouldAnnounce is required by the AnnouncementStrategy interface
+func (AnnounceOnRequest) ShouldAnnounce(catalog *bundle.Catalog) bool {
+	return true
+}
+
+// ReannounceInterval is required by the AnnouncementStrategy interface
+func (AnnounceOnRequest) ReannounceInterval() time.Duration {
+	return 0
+}
+
+// AnnounceOnChange only announces when the catalog has changed since
+// Cog last acknowledged an announcement
+type AnnounceOnChange struct {
+	AnnounceOnRequest
+}
+
+// ShouldAnnounce is required by the AnnouncementStrategy interface
+func (AnnounceOnChange) ShouldAnnounce(catalog *bundle.Catalog) bool {
+	return catalog.IsChanged()
+}
+
+// AnnouncePeriodically announces on request and also every Interval,
+// since brokers sometimes drop a one-off announcement
+type AnnouncePeriodically struct {
+	AnnounceOnRequest
+	Interval time.Duration
+}
+
+// ReannounceInterval is required by the AnnouncementStrategy interface
+func (ap AnnouncePeriodically) ReannounceInterval() time.Duration {
+	return ap.Interval
+}
+
+// WithAnnouncementStrategy replaces the announcement strategy chosen
+// by Relay's configuration
+func WithAnnouncementStrategy(strategy AnnouncementStrategy) Option {
+	return func(r *cogRelay) {
+		r.announceStrategy = strategy
+	}
+}
+
+// announcementStrategyFor returns the strategy configured by
+// cog/reannounce_interval
+func announcementStrategyFor(relayConfig *config.Config) AnnouncementStrategy {
+	if interval := relayConfig.Cog.ReannounceDuration(); interval > 0 {
+		return AnnouncePeriodically{Interval: interval}
+	}
+	return AnnounceOnRequest{}
+}
@@ -20,6 +20,8 @@ type Announcer interface {
 	SetSubscriptions() error
 	Run() error
 	Halt()
+	// Withdraw announces no bundles while withdrawn is true
+	Withdraw(withdrawn bool)
 }
 
 type relayAnnouncerCommand byte
@@ -31,6 +33,8 @@ var reannounceInterval2 = time.Duration(5) * time.Second
 const (
 	relayAnnouncerAnnounceCommand (relayAnnouncerCommand) = iota
 	relayAnnouncerStopCommand
+	// Announces regardless of the announcement strategy
+	relayAnnouncerForceCommand
 )
 
 const (
@@ -51,12 +55,31 @@ type relayAnnouncer struct {
 	receiptFor          string
 	announceTimer       *time.Timer
 	announcementPending bool
+	attestation         *messages.Attestation
+	encoding            func() string
+	tags                map[string]string
+	labels              []string
+	withdrawn           bool
+	strategy            AnnouncementStrategy
 }
 
-// NewAnnouncer creates a new Announcer
-func NewAnnouncer(relayID string, conn bus.Connection, catalog *bundle.Catalog) Announcer {
+// NewAnnouncer creates a new Announcer. If attestation is set it is
+// included in every announcement, as are the Relay's tags and labels.
+// Announcements are sent using the encoding returned by encoding.
+// strategy decides when to announce and what; nil uses
+// AnnounceOnRequest.
+func NewAnnouncer(relayID string, conn bus.Connection, catalog *bundle.Catalog, attestation *messages.Attestation, encoding func() string,
+	tags map[string]string, labels []string, strategy AnnouncementStrategy) Announcer {
+	if strategy == nil {
+		strategy = AnnounceOnRequest{}
+	}
 	announcer := &relayAnnouncer{
+		strategy:            strategy,
 		id:                  relayID,
+		tags:                tags,
+		labels:              labels,
+		attestation:         attestation,
+		encoding:            encoding,
 		receiptTopic:        fmt.Sprintf("bot/relays/%s/announcer", relayID),
 		conn:                conn,
 		catalog:             catalog,
@@ -73,7 +96,9 @@ func (ra *relayAnnouncer) Run() error {
 	if err := ra.SetSubscriptions(); err != nil {
 		return err
 	}
+	ra.stateLock.Lock()
 	ra.state = relayAnnouncerWaitingState
+	ra.stateLock.Unlock()
 	go func() {
 		ra.loop()
 	}()
@@ -89,6 +114,13 @@ func (ra *relayAnnouncer) SendAnnouncement() {
 	log.Debug("Called relayAnnouncer.SendAnnouncement()")
 }
 
+func (ra *relayAnnouncer) Withdraw(withdrawn bool) {
+	ra.stateLock.Lock()
+	ra.withdrawn = withdrawn
+	ra.stateLock.Unlock()
+	ra.control <- relayAnnouncerForceCommand
+}
+
 func (ra *relayAnnouncer) SetSubscriptions() error {
 	if err := ra.conn.Subscribe(ra.receiptTopic, ra.cogReceipt); err != nil {
 		return err
@@ -124,38 +156,74 @@ func (ra *relayAnnouncer) cogReceipt(conn bus.Connection, topic string, payload
 }
 
 func (ra *relayAnnouncer) loop() {
-	for ra.state != relayAnnouncerStoppedState {
-		switch <-ra.control {
-		case relayAnnouncerStopCommand:
-			ra.state = relayAnnouncerStoppedState
-			ra.announceTimer.Stop()
-		case relayAnnouncerAnnounceCommand:
-			ra.stateLock.Lock()
-			if ra.state == relayAnnouncerReceiptWaitingState {
-				ra.announcementPending = true
-				ra.stateLock.Unlock()
-			} else {
+	var reannounce <-chan time.Time
+	if interval := ra.strategy.ReannounceInterval(); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		reannounce = ticker.C
+	}
+	for {
+		select {
+		case command := <-ra.control:
+			switch command {
+			case relayAnnouncerStopCommand:
+				ra.stateLock.Lock()
+				ra.state = relayAnnouncerStoppedState
 				ra.stateLock.Unlock()
-				ra.sendAnnouncement(true)
-				ra.announceTimer = time.AfterFunc(reannounceInterval2, func() {
-					log.Debugf("Retrying announcement %s.", ra.receiptFor)
-					ra.sendAnnouncement(false)
-				})
+				if ra.announceTimer != nil {
+					ra.announceTimer.Stop()
+				}
+				return
+			case relayAnnouncerAnnounceCommand:
+				if ra.strategy.ShouldAnnounce(ra.catalog) == false {
+					log.Debug("Skipped bundle announcement.")
+					continue
+				}
+				ra.announce()
+			case relayAnnouncerForceCommand:
+				ra.announce()
 			}
+		case <-reannounce:
+			log.Debug("Re-announcing bundles.")
+			ra.announce()
 		}
 	}
 }
 
+func (ra *relayAnnouncer) announce() {
+	ra.stateLock.Lock()
+	if ra.state == relayAnnouncerReceiptWaitingState {
+		ra.announcementPending = true
+		ra.stateLock.Unlock()
+		return
+	}
+	ra.stateLock.Unlock()
+	ra.sendAnnouncement(true)
+	ra.announceTimer = time.AfterFunc(reannounceInterval2, func() {
+		log.Debugf("Retrying announcement %s.", ra.receiptFor)
+		ra.sendAnnouncement(false)
+	})
+}
+
 func (ra *relayAnnouncer) sendAnnouncement(skipTimer bool) {
 	ra.stateLock.Lock()
 	defer ra.stateLock.Unlock()
 	log.Debug("Preparing announcement")
 	announcementID := fmt.Sprintf("%d", ra.catalog.CurrentEpoch())
-	announcement := messages.NewBundleAnnouncementExtended(ra.id, getBundles(ra.catalog), ra.receiptTopic, announcementID)
-	raw, _ := json.Marshal(announcement)
+	bundles := ra.strategy.Bundles(ra.catalog)
+	if ra.withdrawn == true {
+		bundles = nil
+	}
+	announcement := messages.NewBundleAnnouncementExtended(ra.id, bundles, ra.receiptTopic, announcementID,
+		ra.tags, ra.labels)
+	announcement.Announcement.Attestation = ra.attestation
+	announcement.Announcement.Encodings = bus.SupportedEncodings
+	announcement.Announcement.MessageEncodings = messages.SupportedMessageEncodings
+	announcement.Announcement.ProtocolVersion = messages.ProtocolVersion
+	raw := encodeAnnouncement(announcement, ra.encoding())
 	for {
-		log.Debug("Publishing bundle announcement to bot/relays/discover")
-		if err := ra.conn.Publish("bot/relays/discover", raw); err != nil {
+		log.Debugf("Publishing bundle announcement to %s", bus.AnnouncementTopic)
+		if err := ra.conn.Publish(bus.AnnouncementTopic, raw); err != nil {
 			ra.stateLock.Unlock()
 			log.Error(err)
 			log.Debug("Retrying announcement")
@@ -0,0 +1,76 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	log "github.com/Sirupsen/logrus"
+	"github.com/operable/go-relay/relay/config"
+	"github.com/operable/go-relay/relay/metrics"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var dampenedAssignments = metrics.NewCounter("relay_bundle_assignments_dampened_total",
+	"Bundle assignments from Cog ignored because bundles were flapping.")
+
+// How long to wait for the flap webhook to respond
+const flapWebhookTimeout = 10 * time.Second
+
+// flapAlert is posted to assignment_flap_webhook when bundles start
+// flapping
+type flapAlert struct {
+	RelayID  string   `json:"relay_id"`
+	Bundles  []string `json:"bundles"`
+	Limit    int      `json:"limit"`
+	Window   string   `json:"window"`
+	Detected int64    `json:"detected_at"`
+}
+
+// dampenAssignment returns the bundles Relay should serve for an
+// assignment received from Cog. Flapping assignments are replaced by
+// the last stable assignment.
+func (r *cogRelay) dampenAssignment(bundles []*config.Bundle) []*config.Bundle {
+	if r.flapGuard == nil {
+		return bundles
+	}
+	wasFlapping := len(r.flapGuard.Flapping()) > 0
+	served, flapping := r.flapGuard.Check(bundles, time.Now())
+	if len(flapping) == 0 {
+		if wasFlapping {
+			log.Info("Bundle assignments are stable again.")
+		}
+		return served
+	}
+	dampenedAssignments.Inc()
+	if wasFlapping {
+		log.Debugf("Ignoring bundle assignment while bundles are flapping: %s.", strings.Join(flapping, ", "))
+		return served
+	}
+	log.Warnf("Bundles changed %d or more times within %s: %s. Serving the last stable assignment until they settle.",
+		r.currentConfig().AssignmentFlapLimit, r.currentConfig().AssignmentFlapWindow, strings.Join(flapping, ", "))
+	if r.currentConfig().AssignmentFlapWebhook != "" {
+		go r.postFlapAlert(flapping)
+	}
+	return served
+}
+
+func (r *cogRelay) postFlapAlert(flapping []string) {
+	body, _ := json.Marshal(flapAlert{
+		RelayID:  r.currentConfig().ID,
+		Bundles:  flapping,
+		Limit:    r.currentConfig().AssignmentFlapLimit,
+		Window:   r.currentConfig().AssignmentFlapWindow,
+		Detected: time.Now().Unix(),
+	})
+	client := &http.Client{Timeout: flapWebhookTimeout}
+	resp, err := client.Post(r.currentConfig().AssignmentFlapWebhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Errorf("Failed to post bundle flap alert: %s.", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Errorf("Bundle flap webhook returned %s.", resp.Status)
+	}
+}
@@ -0,0 +1,131 @@
+package relay
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/operable/go-relay/relay/bus"
+	"github.com/operable/go-relay/relay/messages"
+	"github.com/operable/go-relay/relay/worker"
+	"sync"
+	"time"
+)
+
+// How often queue occupancy is checked between new requests
+const backpressureCheckInterval = time.Second
+
+// SaturatedMessage is returned for requests refused while Relay's work
+// queue is saturated and backpressure/reject is enabled
+const SaturatedMessage = "Relay is saturated. Retry on another Relay."
+
+type backpressureState struct {
+	lock      sync.Mutex
+	saturated bool
+}
+
+// update records whether the queue is saturated given its occupancy
+// as a percentage. Saturation starts at high and ends at low. Returns
+// whether the queue is saturated and whether that changed.
+func (bs *backpressureState) update(occupancy int, high int, low int) (bool, bool) {
+	bs.lock.Lock()
+	defer bs.lock.Unlock()
+	previous := bs.saturated
+	if occupancy >= high {
+		bs.saturated = true
+	} else if occupancy <= low {
+		bs.saturated = false
+	}
+	return bs.saturated, bs.saturated != previous
+}
+
+func (bs *backpressureState) get() bool {
+	bs.lock.Lock()
+	defer bs.lock.Unlock()
+	return bs.saturated
+}
+
+// scheduledBackpressureCheck notices the queue draining while no new
+// requests arrive
+func (r *cogRelay) scheduledBackpressureCheck() {
+	if r.stopped() {
+		return
+	}
+	r.checkBackpressure()
+	r.backpressureTimer.Reset(backpressureCheckInterval)
+}
+
+// checkBackpressure signals Cog when queue occupancy crosses the
+// high or low water mark. Returns true while the queue is saturated.
+func (r *cogRelay) checkBackpressure() bool {
+	settings := r.currentConfig().Backpressure
+	if settings == nil || settings.Enabled == false {
+		return false
+	}
+	depth, capacity := r.queueDepth()
+	occupancy := 100
+	if capacity > 0 {
+		occupancy = depth * 100 / capacity
+	}
+	saturated, changed := r.pressure.update(occupancy, settings.HighWater, settings.LowWater)
+	if changed {
+		wait := r.queueWaits.Average()
+		if saturated {
+			log.Warnf("Work queue is %d%% full with %d requests waiting %v on average. Signalling backpressure to Cog.",
+				occupancy, depth, wait)
+		} else {
+			log.Infof("Work queue has drained to %d%% full. Signalling Cog that backpressure has eased.", occupancy)
+		}
+		r.publishBackpressure(saturated, depth, capacity, wait)
+	}
+	return saturated
+}
+
+func (r *cogRelay) publishBackpressure(saturated bool, depth int, capacity int, wait time.Duration) {
+	conn := r.conn
+	if conn == nil {
+		return
+	}
+	data, _ := json.Marshal(messages.Backpressure{
+		RelayID:       r.currentConfig().ID,
+		Timestamp:     time.Now().Unix(),
+		Saturated:     saturated,
+		QueueDepth:    depth,
+		QueueCapacity: capacity,
+		WaitMillis:    int64(wait / time.Millisecond),
+		Rejecting:     saturated && r.currentConfig().Backpressure.Reject,
+	})
+	if err := conn.Publish(fmt.Sprintf(bus.BackpressureTopicTemplate, r.currentConfig().ID), data); err != nil {
+		log.Errorf("Failed to publish backpressure signal: %s.", err)
+	}
+}
+
+// refuseSaturated answers a request from Cog with an error instead of
+// queueing it if the queue is saturated and backpressure/reject is
+// enabled. Returns true if the request was refused.
+func (r *cogRelay) refuseSaturated(topic string, message []byte, publisher bus.MessagePublisher) bool {
+	if r.checkBackpressure() == false || r.currentConfig().Backpressure.Reject == false {
+		return false
+	}
+	if _, scheduled := publisher.(*scheduleNotifier); scheduled {
+		return false
+	}
+	depth, capacity := r.queueDepth()
+	wait := r.queueWaits.Average()
+	response := &messages.ExecutionResponse{
+		Status:        "error",
+		StatusMessage: SaturatedMessage,
+		Body: worker.SaturatedBody{
+			Error:         "saturated",
+			QueueDepth:    depth,
+			QueueCapacity: capacity,
+			WaitMillis:    int64(wait / time.Millisecond),
+		},
+	}
+	request, err := worker.RefuseRequest(publisher, r.sealer, r.currentConfig().StrictMessageValidation(), message, response)
+	if err != nil {
+		log.Errorf("Dropping request on %s refused while saturated: %s.", topic, err)
+		return true
+	}
+	log.Infof("Refused %s (%s) while saturated.", request.Command, request.CorrelationID)
+	return true
+}
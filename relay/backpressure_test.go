@@ -0,0 +1,28 @@
+package relay
+
+import (
+	"testing"
+)
+
+func TestBackpressureHysteresis(t *testing.T) {
+	var state backpressureState
+	steps := []struct {
+		occupancy int
+		saturated bool
+		changed   bool
+	}{
+		{60, false, false},
+		{80, true, true},
+		{90, true, false},
+		{60, true, false},
+		{50, false, true},
+		{70, false, false},
+	}
+	for _, step := range steps {
+		saturated, changed := state.update(step.occupancy, 80, 50)
+		if saturated != step.saturated || changed != step.changed {
+			t.Errorf("At %d%% expected saturated=%v changed=%v: %v %v", step.occupancy,
+				step.saturated, step.changed, saturated, changed)
+		}
+	}
+}
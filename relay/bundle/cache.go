@@ -0,0 +1,76 @@
+package bundle
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/operable/go-relay/relay/config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// CachedBundle is a bundle assignment saved to disk along with the
+// repository digests its Docker image resolved to
+type CachedBundle struct {
+	Bundle       *config.Bundle `json:"bundle"`
+	ImageDigests []string       `json:"image_digests,omitempty"`
+}
+
+// MatchesImage returns true if a local image with the given
+// repository digests is the one the bundle was cached with. Bundles
+// cached without digests, such as locally built images, match any
+// local image.
+func (cb CachedBundle) MatchesImage(digests []string) bool {
+	if len(cb.ImageDigests) == 0 {
+		return true
+	}
+	for _, cached := range cb.ImageDigests {
+		for _, digest := range digests {
+			if cached == digest {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Cache is the last bundle catalog a Relay received from Cog. It
+// lets a restarted Relay serve bundles while Cog is unreachable.
+type Cache struct {
+	SavedAt time.Time      `json:"saved_at"`
+	Bundles []CachedBundle `json:"bundles"`
+}
+
+// SaveCache atomically writes cache to path
+func SaveCache(path string, cache *Cache) error {
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := fmt.Sprintf("%s.tmp", path)
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// LoadCache reads the cache stored at path. Returns nil without an
+// error if nothing has been cached yet.
+func LoadCache(path string) (*Cache, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var cache Cache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil, err
+	}
+	return &cache, nil
+}
@@ -0,0 +1,55 @@
+package bundle
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCacheRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bundle_cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state", "bundle_cache.json")
+	if cache, err := LoadCache(path); cache != nil || err != nil {
+		t.Fatalf("Expected nothing cached yet: %v, %s", cache, err)
+	}
+	saved := &Cache{
+		SavedAt: time.Now().UTC(),
+		Bundles: []CachedBundle{
+			{Bundle: &bundle12, ImageDigests: []string{"operable/foo@sha256:abc"}},
+		},
+	}
+	if err := SaveCache(path, saved); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Bundles) != 1 || loaded.Bundles[0].Bundle.Name != "foo" ||
+		loaded.Bundles[0].Bundle.Version != bundle12.Version {
+		t.Fatalf("Unexpected cached bundles: %+v", loaded.Bundles)
+	}
+	if loaded.Bundles[0].Bundle.IsAvailable() {
+		t.Error("Expected cached bundles to need an availability check")
+	}
+}
+
+func TestCachedBundleMatchesImage(t *testing.T) {
+	cached := CachedBundle{Bundle: &bundle12, ImageDigests: []string{"operable/foo@sha256:abc"}}
+	if cached.MatchesImage([]string{"operable/foo@sha256:def"}) {
+		t.Error("Expected a different digest not to match")
+	}
+	if cached.MatchesImage([]string{"mirror/foo@sha256:def", "operable/foo@sha256:abc"}) == false {
+		t.Error("Expected a shared digest to match")
+	}
+	undigested := CachedBundle{Bundle: &bundle12}
+	if undigested.MatchesImage(nil) == false {
+		t.Error("Expected bundles cached without digests to match any local image")
+	}
+}
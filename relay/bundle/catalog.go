@@ -85,6 +85,19 @@ func (bc *Catalog) BundleNames() []string {
 	return names
 }
 
+// HasPullFailures returns true if any bundle's assets failed to
+// download during the last refresh
+func (bc *Catalog) HasPullFailures() bool {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+	for _, bundle := range bc.bundles {
+		if failures, _ := bundle.PullFailures(); failures > 0 {
+			return true
+		}
+	}
+	return false
+}
+
 // Remove deletes the named config.Bundle instance from the catalog.
 func (bc *Catalog) Remove(name string) {
 	bc.lock.Lock()
@@ -0,0 +1,91 @@
+package bundle
+
+import (
+	"github.com/operable/go-relay/relay/config"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FlapGuard detects bundles which Cog repeatedly adds, removes or
+// changes the version of. While any bundle is flapping the guard keeps
+// serving the last stable assignment instead of reinstalling bundles
+// and re-announcing on every change.
+type FlapGuard struct {
+	lock     sync.Mutex
+	limit    int
+	window   time.Duration
+	previous map[string]string
+	changes  map[string][]time.Time
+	stable   []*config.Bundle
+	flapping []string
+}
+
+// NewFlapGuard creates a FlapGuard which dampens assignments once a
+// bundle changes limit times within window. A zero limit disables
+// dampening.
+func NewFlapGuard(limit int, window time.Duration) *FlapGuard {
+	return &FlapGuard{
+		limit:    limit,
+		window:   window,
+		previous: make(map[string]string),
+		changes:  make(map[string][]time.Time),
+	}
+}
+
+// Check records an assignment received from Cog and returns the
+// bundles Relay should serve along with the names of any flapping
+// bundles. When bundles are flapping the last stable assignment is
+// returned.
+func (fg *FlapGuard) Check(bundles []*config.Bundle, now time.Time) ([]*config.Bundle, []string) {
+	fg.lock.Lock()
+	defer fg.lock.Unlock()
+	current := make(map[string]string)
+	for _, bundle := range bundles {
+		current[bundle.Name] = bundle.Version
+	}
+	if fg.stable != nil {
+		for name, version := range current {
+			if previous, ok := fg.previous[name]; !ok || previous != version {
+				fg.changes[name] = append(fg.changes[name], now)
+			}
+		}
+		for name := range fg.previous {
+			if _, ok := current[name]; !ok {
+				fg.changes[name] = append(fg.changes[name], now)
+			}
+		}
+	}
+	fg.previous = current
+	flapping := []string{}
+	for name, times := range fg.changes {
+		recent := []time.Time{}
+		for _, t := range times {
+			if now.Sub(t) < fg.window {
+				recent = append(recent, t)
+			}
+		}
+		if len(recent) == 0 {
+			delete(fg.changes, name)
+			continue
+		}
+		fg.changes[name] = recent
+		if fg.limit > 0 && len(recent) >= fg.limit {
+			flapping = append(flapping, name)
+		}
+	}
+	sort.Strings(flapping)
+	fg.flapping = flapping
+	if len(flapping) > 0 {
+		return fg.stable, flapping
+	}
+	fg.stable = bundles
+	return bundles, flapping
+}
+
+// Flapping returns the names of bundles flapping as of the last Check
+func (fg *FlapGuard) Flapping() []string {
+	fg.lock.Lock()
+	defer fg.lock.Unlock()
+	return append([]string{}, fg.flapping...)
+}
@@ -0,0 +1,40 @@
+package bundle
+
+import (
+	"github.com/operable/go-relay/relay/config"
+	"testing"
+	"time"
+)
+
+func TestFlapGuardDampensFlappingBundles(t *testing.T) {
+	guard := NewFlapGuard(3, 10*time.Minute)
+	now := time.Now()
+	stable := []*config.Bundle{&barBundle10, &bundle12}
+	without := []*config.Bundle{&barBundle10}
+	if served, flapping := guard.Check(stable, now); len(served) != 2 || len(flapping) != 0 {
+		t.Fatalf("Expected first assignment to be served: %d bundles, flapping %v", len(served), flapping)
+	}
+	guard.Check(without, now.Add(time.Minute))
+	guard.Check(stable, now.Add(2*time.Minute))
+	served, flapping := guard.Check(without, now.Add(3*time.Minute))
+	if len(flapping) != 1 || flapping[0] != "foo" {
+		t.Fatalf("Expected foo to be flapping: %v", flapping)
+	}
+	if len(served) != 2 {
+		t.Errorf("Expected last stable assignment to be served: %d bundles", len(served))
+	}
+	served, flapping = guard.Check(without, now.Add(20*time.Minute))
+	if len(flapping) != 0 || len(served) != 1 {
+		t.Errorf("Expected assignment to be served once changes age out: %d bundles, flapping %v", len(served), flapping)
+	}
+}
+
+func TestFlapGuardCountsVersionChanges(t *testing.T) {
+	guard := NewFlapGuard(2, time.Minute)
+	now := time.Now()
+	guard.Check([]*config.Bundle{&bundle12}, now)
+	guard.Check([]*config.Bundle{&bundle13}, now.Add(time.Second))
+	if _, flapping := guard.Check([]*config.Bundle{&bundle12}, now.Add(2*time.Second)); len(flapping) != 1 {
+		t.Errorf("Expected version changes to count as flaps: %v", flapping)
+	}
+}
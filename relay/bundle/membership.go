@@ -0,0 +1,78 @@
+package bundle
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Membership tracks the Relays in a group from the heartbeats they
+// publish. Members which miss heartbeats for longer than the TTL are
+// dropped. The local Relay is always a member.
+type Membership struct {
+	lock sync.Mutex
+	self string
+	ttl  time.Duration
+	seen map[string]time.Time
+}
+
+// NewMembership creates a Membership for the Relay with ID self
+func NewMembership(self string, ttl time.Duration) *Membership {
+	return &Membership{
+		self: self,
+		ttl:  ttl,
+		seen: make(map[string]time.Time),
+	}
+}
+
+// Seen records a heartbeat from id. Returns true if id wasn't already
+// a member.
+func (m *Membership) Seen(id string, now time.Time) bool {
+	if id == m.self {
+		return false
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	_, known := m.seen[id]
+	m.seen[id] = now
+	return known == false
+}
+
+// Left removes id after it announced it's leaving the group. Returns
+// true if id was a member.
+func (m *Membership) Left(id string) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	_, known := m.seen[id]
+	delete(m.seen, id)
+	return known
+}
+
+// Expire drops members whose last heartbeat is older than the TTL.
+// Returns the IDs of dropped members.
+func (m *Membership) Expire(now time.Time) []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	expired := []string{}
+	for id, seen := range m.seen {
+		if now.Sub(seen) > m.ttl {
+			delete(m.seen, id)
+			expired = append(expired, id)
+		}
+	}
+	sort.Strings(expired)
+	return expired
+}
+
+// Members returns the IDs of every member, including the local Relay,
+// sorted
+func (m *Membership) Members() []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	members := []string{m.self}
+	for id := range m.seen {
+		members = append(members, id)
+	}
+	sort.Strings(members)
+	return members
+}
@@ -0,0 +1,28 @@
+package bundle
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMembershipExpiresSilentMembers(t *testing.T) {
+	membership := NewMembership("relay-a", time.Minute)
+	now := time.Now()
+	if membership.Seen("relay-b", now) != true {
+		t.Error("Expected relay-b to be a new member")
+	}
+	if membership.Seen("relay-b", now.Add(10*time.Second)) != false {
+		t.Error("Expected relay-b to already be a member")
+	}
+	membership.Seen("relay-c", now.Add(50*time.Second))
+	if members := membership.Members(); len(members) != 3 || members[0] != "relay-a" {
+		t.Errorf("Unexpected members: %v", members)
+	}
+	expired := membership.Expire(now.Add(90 * time.Second))
+	if len(expired) != 1 || expired[0] != "relay-b" {
+		t.Errorf("Expected relay-b to expire: %v", expired)
+	}
+	if membership.Left("relay-c") != true || len(membership.Members()) != 1 {
+		t.Errorf("Expected only relay-a to remain: %v", membership.Members())
+	}
+}
@@ -0,0 +1,36 @@
+package bundle
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"github.com/operable/go-relay/relay/config"
+)
+
+// ShardOwner returns the member responsible for the named bundle.
+// Owners are picked by rendezvous hashing so every member computes the
+// same owner from the same member list, and a member joining or
+// leaving only moves the bundles it gains or loses.
+func ShardOwner(name string, members []string) string {
+	owner := ""
+	var highest uint64
+	for _, member := range members {
+		sum := sha256.Sum256([]byte(member + "\x00" + name))
+		weight := binary.BigEndian.Uint64(sum[:8])
+		if owner == "" || weight > highest || (weight == highest && member < owner) {
+			owner = member
+			highest = weight
+		}
+	}
+	return owner
+}
+
+// Shard returns the bundles owned by self among members
+func Shard(bundles []*config.Bundle, self string, members []string) []*config.Bundle {
+	owned := []*config.Bundle{}
+	for _, bundle := range bundles {
+		if ShardOwner(bundle.Name, members) == self {
+			owned = append(owned, bundle)
+		}
+	}
+	return owned
+}
@@ -0,0 +1,54 @@
+package bundle
+
+import (
+	"fmt"
+	"github.com/operable/go-relay/relay/config"
+	"testing"
+)
+
+func shardBundles(count int) []*config.Bundle {
+	bundles := []*config.Bundle{}
+	for i := 0; i < count; i++ {
+		bundles = append(bundles, &config.Bundle{Name: fmt.Sprintf("bundle%d", i), Version: "1.0.0"})
+	}
+	return bundles
+}
+
+func TestShardAssignsEveryBundleOnce(t *testing.T) {
+	bundles := shardBundles(50)
+	members := []string{"relay-a", "relay-b", "relay-c"}
+	owners := map[string]string{}
+	for _, member := range members {
+		for _, bundle := range Shard(bundles, member, members) {
+			if owner, ok := owners[bundle.Name]; ok {
+				t.Errorf("Bundle %s owned by both %s and %s", bundle.Name, owner, member)
+			}
+			owners[bundle.Name] = member
+		}
+	}
+	if len(owners) != len(bundles) {
+		t.Errorf("Expected all %d bundles to be owned: %d", len(bundles), len(owners))
+	}
+}
+
+func TestShardOwnerIgnoresMemberOrder(t *testing.T) {
+	for _, bundle := range shardBundles(20) {
+		first := ShardOwner(bundle.Name, []string{"relay-a", "relay-b", "relay-c"})
+		second := ShardOwner(bundle.Name, []string{"relay-c", "relay-a", "relay-b"})
+		if first != second {
+			t.Errorf("Expected the same owner for %s: %s != %s", bundle.Name, first, second)
+		}
+	}
+}
+
+func TestShardMovesOnlyDepartedMembersBundles(t *testing.T) {
+	before := []string{"relay-a", "relay-b", "relay-c"}
+	after := []string{"relay-a", "relay-b"}
+	for _, bundle := range shardBundles(50) {
+		previous := ShardOwner(bundle.Name, before)
+		current := ShardOwner(bundle.Name, after)
+		if previous != "relay-c" && previous != current {
+			t.Errorf("Expected %s to stay with %s: moved to %s", bundle.Name, previous, current)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package bundle
+
+import (
+	"github.com/operable/go-relay/relay/config"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SingletonElection decides which member of a Relay group serves each
+// singleton bundle. The owner is picked the same way as for sharding,
+// but a member only starts serving a singleton after it has owned it
+// for the handover delay. This gives the previous owner time to notice
+// the membership change and stop serving it.
+type SingletonElection struct {
+	lock   sync.Mutex
+	self   string
+	delay  time.Duration
+	claims map[string]time.Time
+	led    []string
+}
+
+// NewSingletonElection creates an election for the Relay with ID self
+func NewSingletonElection(self string, delay time.Duration) *SingletonElection {
+	return &SingletonElection{
+		self:   self,
+		delay:  delay,
+		claims: make(map[string]time.Time),
+	}
+}
+
+// Filter removes the singleton bundles this Relay mustn't serve from
+// bundles. Also returns how long until a singleton it has just become
+// the owner of can be served, or zero if there are none.
+func (se *SingletonElection) Filter(bundles []*config.Bundle, singleton func(name string) bool,
+	members []string, now time.Time) ([]*config.Bundle, time.Duration) {
+	se.lock.Lock()
+	defer se.lock.Unlock()
+	served := []*config.Bundle{}
+	owned := map[string]bool{}
+	led := []string{}
+	var wait time.Duration
+	for _, bundle := range bundles {
+		if singleton(bundle.Name) == false {
+			served = append(served, bundle)
+			continue
+		}
+		if ShardOwner(bundle.Name, members) != se.self {
+			continue
+		}
+		owned[bundle.Name] = true
+		claimed, ok := se.claims[bundle.Name]
+		if !ok {
+			claimed = now
+			se.claims[bundle.Name] = now
+		}
+		if remaining := se.delay - now.Sub(claimed); remaining > 0 {
+			if wait == 0 || remaining < wait {
+				wait = remaining
+			}
+			continue
+		}
+		served = append(served, bundle)
+		led = append(led, bundle.Name)
+	}
+	for name := range se.claims {
+		if owned[name] == false {
+			delete(se.claims, name)
+		}
+	}
+	sort.Strings(led)
+	se.led = led
+	return served, wait
+}
+
+// Leading returns the names of the singleton bundles this Relay
+// served after the last Filter
+func (se *SingletonElection) Leading() []string {
+	se.lock.Lock()
+	defer se.lock.Unlock()
+	return se.led
+}
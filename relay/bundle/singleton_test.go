@@ -0,0 +1,37 @@
+package bundle
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSingletonElectionWaitsForHandover(t *testing.T) {
+	bundles := shardBundles(10)
+	singleton := func(name string) bool {
+		return name == "bundle3"
+	}
+	members := []string{"relay-a", "relay-b"}
+	owner := ShardOwner("bundle3", members)
+	other := "relay-a"
+	if owner == other {
+		other = "relay-b"
+	}
+	now := time.Now()
+	election := NewSingletonElection(owner, 10*time.Second)
+	served, wait := election.Filter(bundles, singleton, members, now)
+	if len(served) != 9 || wait != 10*time.Second {
+		t.Errorf("Expected singleton to wait for handover: %d bundles, wait %v", len(served), wait)
+	}
+	served, wait = election.Filter(bundles, singleton, members, now.Add(10*time.Second))
+	if len(served) != 10 || wait != 0 {
+		t.Errorf("Expected owner to serve singleton after handover: %d bundles, wait %v", len(served), wait)
+	}
+	if leading := election.Leading(); len(leading) != 1 || leading[0] != "bundle3" {
+		t.Errorf("Expected owner to lead bundle3: %v", leading)
+	}
+	follower := NewSingletonElection(other, 10*time.Second)
+	served, wait = follower.Filter(bundles, singleton, members, now.Add(time.Minute))
+	if len(served) != 9 || wait != 0 || len(follower.Leading()) != 0 {
+		t.Errorf("Expected non-owner to never serve singleton: %d bundles, wait %v", len(served), wait)
+	}
+}
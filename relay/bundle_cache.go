@@ -0,0 +1,115 @@
+package relay
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/operable/go-relay/relay/bundle"
+	"github.com/operable/go-relay/relay/config"
+	"github.com/operable/go-relay/relay/engines"
+	"time"
+)
+
+const bundleCacheFile = "bundle_cache.json"
+
+// saveBundleCache writes the bundle catalog and the image digests its
+// Docker bundles resolved to into the state directory
+func (r *cogRelay) saveBundleCache() {
+	path := r.currentConfig().StatePath(bundleCacheFile)
+	if path == "" {
+		return
+	}
+	dockerEngine := r.localDockerEngine()
+	cache := &bundle.Cache{
+		SavedAt: time.Now().UTC(),
+		Bundles: []bundle.CachedBundle{},
+	}
+	for _, name := range r.catalog.BundleNames() {
+		b := r.catalog.Find(name)
+		if b == nil {
+			continue
+		}
+		entry := bundle.CachedBundle{Bundle: b}
+		if b.IsDocker() && b.IsAvailable() && dockerEngine != nil {
+			entry.ImageDigests, _ = dockerEngine.DigestsForName(b.Docker.Image, b.Docker.Tag)
+		}
+		cache.Bundles = append(cache.Bundles, entry)
+	}
+	if err := bundle.SaveCache(path, cache); err != nil {
+		log.Errorf("Failed to save bundle cache to %s: %s.", path, err)
+	}
+}
+
+// loadBundleCache fills the empty bundle catalog with the bundles a
+// previous run received from Cog. Relay is degraded until Cog sends
+// a fresh catalog. Cached Docker bundles are only available if their
+// image is still present locally since Relay may be offline.
+func (r *cogRelay) loadBundleCache() {
+	path := r.currentConfig().StatePath(bundleCacheFile)
+	if path == "" {
+		return
+	}
+	cache, err := bundle.LoadCache(path)
+	if err != nil {
+		log.Warnf("Failed to load bundle cache from %s: %s.", path, err)
+		return
+	}
+	if cache == nil || len(cache.Bundles) == 0 {
+		return
+	}
+	bundles := []*config.Bundle{}
+	for _, entry := range cache.Bundles {
+		if entry.Bundle != nil {
+			bundles = append(bundles, entry.Bundle)
+		}
+	}
+	r.catalog.Replace(bundles)
+	available := 0
+	for _, entry := range cache.Bundles {
+		if entry.Bundle != nil && r.cachedBundleAvailable(entry) {
+			entry.Bundle.SetAvailable(true)
+			available++
+		}
+	}
+	r.setState(RelayDegraded)
+	log.Warnf("Loaded %d cached bundles from %s saved at %s. %d are available until Cog sends its bundle catalog.",
+		len(bundles), path, cache.SavedAt.Format(time.RFC3339), available)
+}
+
+func (r *cogRelay) cachedBundleAvailable(entry bundle.CachedBundle) bool {
+	b := entry.Bundle
+	if len(b.MissingLabels(r.currentConfig().ParsedLabels)) > 0 {
+		return false
+	}
+	if b.IsDocker() == false {
+		engine, err := r.engines.EngineForBundle(b)
+		if err != nil {
+			return false
+		}
+		avail, _ := engine.IsAvailable(b.Name, b.Version)
+		return avail
+	}
+	dockerEngine := r.localDockerEngine()
+	if dockerEngine == nil {
+		return false
+	}
+	// Pulling could fail or change the image so only check for the
+	// one which was cached
+	digests, err := dockerEngine.DigestsForName(b.Docker.Image, b.Docker.Tag)
+	if err != nil {
+		return false
+	}
+	return entry.MatchesImage(digests)
+}
+
+// localDockerEngine returns a Docker engine for inspecting local
+// images or nil if Docker isn't enabled
+func (r *cogRelay) localDockerEngine() *engines.DockerEngine {
+	if r.currentConfig().DockerEnabled() == false {
+		return nil
+	}
+	engine, err := r.engines.GetEngine(engines.DockerEngineType)
+	if err != nil {
+		return nil
+	}
+	dockerEngine, _ := engine.(*engines.DockerEngine)
+	return dockerEngine
+}
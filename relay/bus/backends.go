@@ -0,0 +1,63 @@
+package bus
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// MQTTBackend is the name of the built-in MQTT backend
+const MQTTBackend = "mqtt"
+
+// Factory creates an unconnected Connection for a backend
+type Factory func() Connection
+
+var backends = struct {
+	lock      sync.Mutex
+	factories map[string]Factory
+}{
+	factories: map[string]Factory{
+		MQTTBackend: func() Connection {
+			return &MQTTConnection{}
+		},
+	},
+}
+
+// Register makes a message bus backend available by name, replacing
+// any backend already registered under that name. Embedders register
+// their own backends before starting Relay and select them with
+// bus/type.
+func Register(name string, factory Factory) {
+	backends.lock.Lock()
+	defer backends.lock.Unlock()
+	backends.factories[name] = factory
+}
+
+// NewConnection creates an unconnected Connection using the named
+// backend
+func NewConnection(name string) (Connection, error) {
+	backends.lock.Lock()
+	defer backends.lock.Unlock()
+	factory, ok := backends.factories[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown message bus type %s. Available types: %v", name, registeredBackends())
+	}
+	return factory(), nil
+}
+
+// Backends returns the names of registered backends
+func Backends() []string {
+	backends.lock.Lock()
+	defer backends.lock.Unlock()
+	return registeredBackends()
+}
+
+// registeredBackends must be called with backends.lock held
+func registeredBackends() []string {
+	names := []string{}
+	for name := range backends.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
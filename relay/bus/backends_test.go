@@ -0,0 +1,28 @@
+package bus
+
+import (
+	"testing"
+)
+
+type fakeConnection struct {
+	MQTTConnection
+}
+
+func TestNewConnection(t *testing.T) {
+	if conn, err := NewConnection(MQTTBackend); err != nil {
+		t.Error(err)
+	} else if _, ok := conn.(*MQTTConnection); !ok {
+		t.Errorf("Expected MQTT connection: %T", conn)
+	}
+	Register("fake", func() Connection {
+		return &fakeConnection{}
+	})
+	if conn, err := NewConnection("fake"); err != nil {
+		t.Error(err)
+	} else if _, ok := conn.(*fakeConnection); !ok {
+		t.Errorf("Expected registered backend's connection: %T", conn)
+	}
+	if _, err := NewConnection("carrier-pigeon"); err == nil {
+		t.Error("Expected unknown backend to be rejected")
+	}
+}
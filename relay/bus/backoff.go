@@ -1,50 +1,91 @@
 package bus
 
 import (
+	"errors"
 	log "github.com/Sirupsen/logrus"
 	"math/rand"
+	"sync"
 	"time"
 )
 
-// intervalTable is the schedule of backoff intervals in seconds
-var intervalTable = []int{5, 5, 5, 15, 15, 15,
-	30, 30, 30, 60, 60, 60, 90}
-var intervals = byte(len(intervalTable) - 1)
+// Defaults used when ConnectionOptions leave backoff unset
+const (
+	initialBackoff        = time.Second
+	defaultMaxBackoff     = 90 * time.Second
+	backoffGrowthExponent = 2
+)
+
+var errorRetryBudgetExhausted = errors.New("Reconnect attempts exhausted")
+
+var jitterSource = struct {
+	lock sync.Mutex
+	rand *rand.Rand
+}{
+	rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+}
 
-// Backoff implements a basic increasing backoff strategy
-// with a small amount of random jitter
+// Backoff implements exponential backoff with jitter. Intervals double
+// from one second up to a maximum, and each wait is randomized between
+// half and all of the current interval so Relays disconnected by the
+// same broker restart don't reconnect in lockstep.
 type Backoff struct {
-	interval byte
+	max         time.Duration
+	maxAttempts int
+	interval    time.Duration
+	attempts    int
 }
 
-// NewBackoff returns a freshly initialized Backoff
-func NewBackoff() *Backoff {
+// NewBackoff returns a freshly initialized Backoff. Waits never exceed
+// max and Wait fails once it has been called maxAttempts times since
+// the last Reset. Zero values use the default maximum and allow
+// unlimited attempts.
+func NewBackoff(max time.Duration, maxAttempts int) *Backoff {
+	if max <= 0 {
+		max = defaultMaxBackoff
+	}
 	return &Backoff{
-		interval: 0,
+		max:         max,
+		maxAttempts: maxAttempts,
 	}
 }
 
-// Wait calculates the next backoff interval, sleeps
-// for that amount and returns.
-func (b *Backoff) Wait() {
+// Wait calculates the next backoff interval, sleeps for that amount
+// and returns. Returns an error without sleeping once the retry budget
+// is used up.
+func (b *Backoff) Wait() error {
+	b.attempts++
+	if b.maxAttempts > 0 && b.attempts >= b.maxAttempts {
+		return errorRetryBudgetExhausted
+	}
 	interval := b.nextInterval()
-	log.Infof("Waiting %d seconds before reconnecting.", interval/time.Second)
+	log.Infof("Waiting %v before reconnecting (attempt %d).", interval, b.attempts)
 	time.Sleep(interval)
+	return nil
 }
 
-// Reset restarts wait interval escalation
+// Reset restarts wait interval escalation and the retry budget
 func (b *Backoff) Reset() {
-	b.interval = byte(0)
+	b.interval = 0
+	b.attempts = 0
 }
 
 func (b *Backoff) nextInterval() time.Duration {
-	if b.interval < intervals {
-		b.interval++
+	if b.interval == 0 {
+		b.interval = initialBackoff
+	} else if b.interval < b.max {
+		b.interval *= backoffGrowthExponent
 	}
-	return time.Duration(intervalTable[b.interval]+b.jitter()) * time.Second
+	if b.interval > b.max {
+		b.interval = b.max
+	}
+	return b.interval/2 + jitter(b.interval/2)
 }
 
-func (b *Backoff) jitter() int {
-	rand.Seed(time.Now().UnixNano())
-	return rand.Intn(4)
+func jitter(limit time.Duration) time.Duration {
+	if limit <= 0 {
+		return 0
+	}
+	jitterSource.lock.Lock()
+	defer jitterSource.lock.Unlock()
+	return time.Duration(jitterSource.rand.Int63n(int64(limit) + 1))
 }
@@ -0,0 +1,51 @@
+package bus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffGrowsToMax(t *testing.T) {
+	backoff := NewBackoff(10*time.Second, 0)
+	expected := []time.Duration{1, 2, 4, 8, 10, 10}
+	for _, want := range expected {
+		interval := backoff.nextInterval()
+		max := want * time.Second
+		if interval < max/2 || interval > max {
+			t.Errorf("Expected interval between %v and %v: %v", max/2, max, interval)
+		}
+	}
+	backoff.Reset()
+	if interval := backoff.nextInterval(); interval > time.Second {
+		t.Errorf("Expected Reset to restart escalation: %v", interval)
+	}
+}
+
+func TestBackoffRetryBudget(t *testing.T) {
+	backoff := NewBackoff(time.Second, 1)
+	if err := backoff.Wait(); err != errorRetryBudgetExhausted {
+		t.Errorf("Expected errorRetryBudgetExhausted: %v", err)
+	}
+	backoff.Reset()
+	if backoff.attempts != 0 {
+		t.Errorf("Expected Reset to restore retry budget: %d", backoff.attempts)
+	}
+}
+
+func TestMQTTConnectionHoldsWhileOffline(t *testing.T) {
+	outbox, _ := openOutbox("", 0)
+	mqc := &MQTTConnection{outbox: outbox}
+	mqc.setOffline()
+	for i := 0; i < defaultOutboxSize+1; i++ {
+		if err := mqc.Publish("/bot/pipelines/abc/reply", []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pending := mqc.outbox.Drain()
+	if len(pending) != defaultOutboxSize {
+		t.Errorf("Expected %d held messages: %d", defaultOutboxSize, len(pending))
+	}
+	if pending[0].Payload[0] != 1 {
+		t.Errorf("Expected oldest message to be dropped: %v", pending[0].Payload)
+	}
+}
@@ -0,0 +1,79 @@
+package bus
+
+import (
+	"sync"
+	"time"
+)
+
+// RestartBreaker trips when a connection is lost too often, so a
+// broker which accepts connections only to drop them again isn't
+// hammered with reconnects. Backoff can't help there since every
+// successful connect resets it.
+type RestartBreaker struct {
+	lock     sync.Mutex
+	limit    int
+	window   time.Duration
+	cooldown time.Duration
+	restarts []time.Time
+}
+
+// NewRestartBreaker returns a breaker which trips when limit
+// connections are lost within window. Returns nil, which never trips,
+// if limit is zero.
+func NewRestartBreaker(limit int, window time.Duration, cooldown time.Duration) *RestartBreaker {
+	if limit <= 0 {
+		return nil
+	}
+	return &RestartBreaker{
+		limit:    limit,
+		window:   window,
+		cooldown: cooldown,
+	}
+}
+
+// Record notes a connection lost at now. Returns true if the breaker
+// tripped, after which it starts counting afresh.
+func (rb *RestartBreaker) Record(now time.Time) bool {
+	if rb == nil {
+		return false
+	}
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+	recent := []time.Time{}
+	for _, restart := range rb.restarts {
+		if now.Sub(restart) < rb.window {
+			recent = append(recent, restart)
+		}
+	}
+	rb.restarts = append(recent, now)
+	if len(rb.restarts) >= rb.limit {
+		rb.restarts = nil
+		return true
+	}
+	return false
+}
+
+// Cooldown returns how long to wait before reconnecting once the
+// breaker has tripped
+func (rb *RestartBreaker) Cooldown() time.Duration {
+	if rb == nil {
+		return 0
+	}
+	return rb.cooldown
+}
+
+// Limit returns how many lost connections trip the breaker
+func (rb *RestartBreaker) Limit() int {
+	if rb == nil {
+		return 0
+	}
+	return rb.limit
+}
+
+// Window returns the period lost connections are counted over
+func (rb *RestartBreaker) Window() time.Duration {
+	if rb == nil {
+		return 0
+	}
+	return rb.window
+}
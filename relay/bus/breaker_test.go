@@ -0,0 +1,41 @@
+package bus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRestartBreakerTripsWithinWindow(t *testing.T) {
+	breaker := NewRestartBreaker(3, time.Minute, 5*time.Minute)
+	now := time.Now()
+	if breaker.Record(now) || breaker.Record(now.Add(10*time.Second)) {
+		t.Fatal("Expected breaker to stay closed below the limit")
+	}
+	if breaker.Record(now.Add(20*time.Second)) == false {
+		t.Fatal("Expected breaker to trip at the limit")
+	}
+	if breaker.Record(now.Add(30 * time.Second)) {
+		t.Error("Expected breaker to count afresh after tripping")
+	}
+}
+
+func TestRestartBreakerForgetsOldRestarts(t *testing.T) {
+	breaker := NewRestartBreaker(2, time.Minute, time.Minute)
+	now := time.Now()
+	breaker.Record(now)
+	if breaker.Record(now.Add(2 * time.Minute)) {
+		t.Error("Expected restarts outside the window to be ignored")
+	}
+}
+
+func TestDisabledRestartBreaker(t *testing.T) {
+	breaker := NewRestartBreaker(0, time.Minute, time.Minute)
+	for i := 0; i < 10; i++ {
+		if breaker.Record(time.Now()) {
+			t.Fatal("Expected disabled breaker never to trip")
+		}
+	}
+	if breaker.Cooldown() != 0 {
+		t.Errorf("Expected no cooldown: %v", breaker.Cooldown())
+	}
+}
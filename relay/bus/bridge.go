@@ -0,0 +1,146 @@
+package bus
+
+import (
+	"crypto/sha256"
+	log "github.com/Sirupsen/logrus"
+	"github.com/operable/go-relay/relay/metrics"
+	"sync"
+	"time"
+)
+
+// How long a forwarded message is remembered so it isn't forwarded
+// back when inbound and outbound rules overlap
+const bridgeEchoWindow = time.Minute
+
+var bridgedMessages = metrics.NewCounter("relay_bridge_messages_forwarded_total",
+	"Messages forwarded between message buses in bridge mode.")
+
+// BridgeRules select the topics a Bridge forwards. Each entry is an
+// MQTT topic filter. Topics matching Deny are never forwarded.
+type BridgeRules struct {
+	Inbound  []string
+	Outbound []string
+	Deny     []string
+}
+
+// Bridge forwards messages between two message buses, such as a
+// broker in a DMZ which Cog uses and a broker on an internal network
+// which Relays use. Commands are forwarded from the outer bus inward
+// and responses from the inner bus outward.
+type Bridge struct {
+	rules   BridgeRules
+	outer   Connection
+	inner   Connection
+	lock    sync.Mutex
+	recent  map[[sha256.Size]byte]time.Time
+	stopped bool
+	// Connections Run has connected and Stop must disconnect
+	connected []Connection
+}
+
+// NewBridge creates a Bridge forwarding messages between outer and
+// inner, which must not be connected yet
+func NewBridge(outer Connection, inner Connection, rules BridgeRules) *Bridge {
+	return &Bridge{
+		rules:  rules,
+		outer:  outer,
+		inner:  inner,
+		recent: make(map[[sha256.Size]byte]time.Time),
+	}
+}
+
+// Run connects both buses and starts forwarding. Subscriptions are
+// restored whenever either connection reconnects.
+func (b *Bridge) Run(outerOpts ConnectionOptions, innerOpts ConnectionOptions) error {
+	innerOpts.EventsHandler = func(conn Connection, event Event) {
+		if event == ConnectedEvent {
+			b.subscribe(conn, b.rules.Outbound, b.outer, "outward")
+		}
+	}
+	outerOpts.EventsHandler = func(conn Connection, event Event) {
+		if event == ConnectedEvent {
+			b.subscribe(conn, b.rules.Inbound, b.inner, "inward")
+		}
+	}
+	if err := b.connect(b.inner, innerOpts); err != nil {
+		return err
+	}
+	return b.connect(b.outer, outerOpts)
+}
+
+// Stop disconnects both buses
+func (b *Bridge) Stop() {
+	b.lock.Lock()
+	b.stopped = true
+	connected := b.connected
+	b.connected = nil
+	b.lock.Unlock()
+	for _, conn := range connected {
+		conn.Disconnect()
+	}
+}
+
+func (b *Bridge) connect(conn Connection, options ConnectionOptions) error {
+	if err := conn.Connect(options); err != nil {
+		return err
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.stopped == true {
+		conn.Disconnect()
+		return nil
+	}
+	b.connected = append(b.connected, conn)
+	return nil
+}
+
+func (b *Bridge) subscribe(from Connection, filters []string, to Connection, direction string) {
+	for _, filter := range filters {
+		err := from.Subscribe(filter, func(conn Connection, topic string, payload []byte) {
+			b.forward(to, direction, topic, payload)
+		})
+		if err != nil {
+			log.Errorf("Failed to subscribe to %s for forwarding %s: %s.", filter, direction, err)
+		}
+	}
+}
+
+func (b *Bridge) forward(to Connection, direction string, topic string, payload []byte) {
+	for _, filter := range b.rules.Deny {
+		if topicMatches(filter, topic) {
+			return
+		}
+	}
+	if b.echo(topic, payload) {
+		return
+	}
+	log.Debugf("Forwarding message on %s %s.", topic, direction)
+	if err := to.Publish(topic, payload); err != nil {
+		log.Errorf("Failed to forward message on %s %s: %s.", topic, direction, err)
+		return
+	}
+	bridgedMessages.Inc()
+}
+
+// echo returns true if the message is one the bridge just forwarded
+// in the other direction. Otherwise the message is remembered.
+func (b *Bridge) echo(topic string, payload []byte) bool {
+	sum := sha256.Sum256(append([]byte(topic+"\x00"), payload...))
+	now := time.Now()
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.stopped == true {
+		return true
+	}
+	for key, at := range b.recent {
+		if now.Sub(at) > bridgeEchoWindow {
+			delete(b.recent, key)
+		}
+	}
+	if _, ok := b.recent[sum]; ok {
+		delete(b.recent, sum)
+		return true
+	}
+	b.recent[sum] = now
+	return false
+}
@@ -0,0 +1,47 @@
+package bus
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+)
+
+func TestBridgeForwarding(t *testing.T) {
+	dmz := NewMemoryBus()
+	internal := NewMemoryBus()
+	bridge := NewBridge(dmz.NewConnection(), internal.NewConnection(), BridgeRules{
+		Inbound:  []string{"/bot/commands/#", "bot/relays/+/directives"},
+		Outbound: []string{"/bot/pipelines/+/reply", "bot/relays/#"},
+		Deny:     []string{"bot/relays/info"},
+	})
+	if err := bridge.Run(ConnectionOptions{}, ConnectionOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	cog := dmz.NewConnection()
+	cog.Connect(ConnectionOptions{})
+	relay := internal.NewConnection()
+	relay.Connect(ConnectionOptions{})
+	relay.Subscribe("/bot/commands/relay-1/#", func(conn Connection, topic string, payload []byte) {
+		conn.Publish("/bot/pipelines/abc/reply", payload)
+		conn.Publish("bot/relays/info", payload)
+	})
+	directives := 0
+	relay.Subscribe("bot/relays/relay-1/directives", func(conn Connection, topic string, payload []byte) {
+		directives++
+	})
+	received := []string{}
+	cog.Subscribe("#", func(conn Connection, topic string, payload []byte) {
+		received = append(received, topic)
+	})
+	cog.Publish("/bot/commands/relay-1/operable/echo", []byte("hello"))
+	cog.Publish("bot/relays/relay-1/directives", []byte("{}"))
+	sort.Strings(received)
+	expected := "[/bot/commands/relay-1/operable/echo /bot/pipelines/abc/reply bot/relays/relay-1/directives]"
+	if fmt.Sprint(received) != expected {
+		t.Errorf("Unexpected messages on outer bus: %v", received)
+	}
+	if directives != 1 {
+		t.Errorf("Expected directive forwarded inward once: %d", directives)
+	}
+	bridge.Stop()
+}
@@ -0,0 +1,94 @@
+package bus
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// Payload encodings
+const (
+	NoCompression   = "none"
+	GzipCompression = "gzip"
+)
+
+// SupportedEncodings lists the payload encodings Relay can decode.
+// Relays advertise them in announcements so Cog knows which it may use
+// for requests.
+var SupportedEncodings = []string{GzipCompression}
+
+// Compressed payloads are wrapped in a JSON envelope whose first key
+// is "encoding", which lets receivers tell them apart from plain
+// messages without parsing every payload
+var compressedPrefix = []byte(`{"encoding":`)
+
+var errorUnknownEncoding = errors.New("Unknown payload encoding")
+
+type compressedEnvelope struct {
+	Encoding string `json:"encoding"`
+	Payload  []byte `json:"payload"`
+}
+
+// compressPayload wraps payload in a compressed envelope when it is
+// larger than threshold bytes. Payloads which don't shrink are
+// returned unchanged.
+func compressPayload(encoding string, threshold int, payload []byte) ([]byte, error) {
+	if encoding == "" || encoding == NoCompression || len(payload) <= threshold {
+		return payload, nil
+	}
+	if encoding != GzipCompression {
+		return nil, errorUnknownEncoding
+	}
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	wrapped, err := json.Marshal(compressedEnvelope{
+		Encoding: encoding,
+		Payload:  buf.Bytes(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) >= len(payload) {
+		return payload, nil
+	}
+	return wrapped, nil
+}
+
+// publishCompression returns the encoding used to compress published
+// payloads, which is none until Cog agrees to compressed payloads
+func (opts ConnectionOptions) publishCompression() string {
+	if opts.CompressionAgreed == nil || opts.CompressionAgreed() == false {
+		return NoCompression
+	}
+	return opts.Compression
+}
+
+// decompressPayload unwraps a compressed envelope. Other payloads are
+// returned unchanged.
+func decompressPayload(payload []byte) ([]byte, error) {
+	if bytes.HasPrefix(payload, compressedPrefix) == false {
+		return payload, nil
+	}
+	envelope := compressedEnvelope{}
+	if err := json.Unmarshal(payload, &envelope); err != nil {
+		return nil, err
+	}
+	if envelope.Encoding != GzipCompression {
+		return nil, fmt.Errorf("%s: %s", errorUnknownEncoding, envelope.Encoding)
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(envelope.Payload))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
@@ -0,0 +1,69 @@
+package bus
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCompressPayloadRoundTrip(t *testing.T) {
+	payload := bytes.Repeat([]byte(`{"status":"ok","body":["a large command response"]}`), 200)
+	compressed, err := compressPayload(GzipCompression, 1024, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.HasPrefix(compressed, compressedPrefix) == false || len(compressed) >= len(payload) {
+		t.Fatalf("Expected compressed envelope smaller than %d bytes: %d", len(payload), len(compressed))
+	}
+	decompressed, err := decompressPayload(compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(decompressed, payload) == false {
+		t.Error("Decompressed payload doesn't match original")
+	}
+}
+
+func TestCompressPayloadSkipsSmallMessages(t *testing.T) {
+	payload := []byte(`{"status":"ok"}`)
+	compressed, err := compressPayload(GzipCompression, 1024, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(compressed, payload) == false {
+		t.Errorf("Expected small payload to be sent as is: %s", compressed)
+	}
+	decompressed, err := decompressPayload(payload)
+	if err != nil || bytes.Equal(decompressed, payload) == false {
+		t.Errorf("Expected plain payload to pass through: %s %v", decompressed, err)
+	}
+}
+
+func TestDecompressPayloadUnknownEncoding(t *testing.T) {
+	if _, err := decompressPayload([]byte(`{"encoding":"zstd","payload":""}`)); err == nil {
+		t.Error("Expected unknown encoding to be rejected")
+	}
+}
+
+func TestPayloadsUncompressedUntilAgreed(t *testing.T) {
+	payload := bytes.Repeat([]byte(`{"status":"ok","body":["a large command response"]}`), 200)
+	agreed := false
+	opts := ConnectionOptions{Compression: GzipCompression, CompressAbove: 1024}
+	for _, compressionAgreed := range []func() bool{nil, func() bool { return agreed }} {
+		opts.CompressionAgreed = compressionAgreed
+		published, err := compressPayload(opts.publishCompression(), opts.CompressAbove, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(published, payload) == false {
+			t.Error("Expected payload to be sent uncompressed before Cog agreed to compression")
+		}
+	}
+	agreed = true
+	published, err := compressPayload(opts.publishCompression(), opts.CompressAbove, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.HasPrefix(published, compressedPrefix) == false {
+		t.Error("Expected payload to be compressed once Cog agreed to compression")
+	}
+}
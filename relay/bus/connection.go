@@ -1,7 +1,9 @@
 package bus
 
 import (
+	"crypto/tls"
 	"errors"
+	"time"
 )
 
 // MessagePublisher sends messages on the message bus
@@ -10,13 +12,22 @@ type MessagePublisher interface {
 }
 
 // Event describes different events which can happen over the
-// life of a connection. Currently only BusConnection is supported.
+// life of a connection.
 type Event int
 
 const (
 	// ConnectedEvent indicates a working bus connection has been
 	// established
 	ConnectedEvent Event = iota
+	// ReconnectFailedEvent indicates the connection couldn't be
+	// re-established within ConnectionOptions.ReconnectMaxAttempts
+	ReconnectFailedEvent
+	// DisconnectedEvent indicates the connection was lost and is
+	// being re-established
+	DisconnectedEvent
+	// FlappingEvent indicates the connection was lost too often and
+	// reconnecting is paused for ConnectionOptions.FlapCooldown
+	FlappingEvent
 )
 
 // SubscriptionHandler is called when a message is received on its
@@ -26,6 +37,39 @@ type SubscriptionHandler func(conn Connection, topic string, message []byte)
 // EventHandler is called when a bus event occurs.
 type EventHandler func(conn Connection, event Event)
 
+// AnnouncementTopic is where Relays announce their bundles to Cog
+const AnnouncementTopic = "bot/relays/discover"
+
+// HandshakeTopic is where Relays start the protocol handshake with Cog
+const HandshakeTopic = "bot/relays/handshake"
+
+// HeartbeatTopicTemplate is where Relays publish heartbeats when
+// heartbeat_interval is set
+const HeartbeatTopicTemplate = "bot/relays/%s/heartbeat"
+
+// GroupTopicTemplate is where members of a Relay group publish
+// their heartbeats
+const GroupTopicTemplate = "bot/relays/groups/%s/members"
+
+// BackpressureTopicTemplate is where Relays publish backpressure
+// signals when backpressure is enabled
+const BackpressureTopicTemplate = "bot/relays/%s/backpressure"
+
+// PresenceTopicTemplate is where Relays publish retained presence
+// messages when cog/presence is enabled
+const PresenceTopicTemplate = "bot/relays/%s/presence"
+
+// DeliveryOptions control MQTT delivery guarantees for each class of
+// message. Commands covers every subscription, announcements covers
+// AnnouncementTopic, and responses covers every other publish.
+type DeliveryOptions struct {
+	CommandQoS          byte
+	ResponseQoS         byte
+	AnnouncementQoS     byte
+	RetainAnnouncements bool
+	PersistentSession   bool
+}
+
 // DisconnectMessage is sent when the connection is broken
 type DisconnectMessage struct {
 	Topic string
@@ -40,9 +84,53 @@ type ConnectionOptions struct {
 	Port          int
 	SSLEnabled    bool
 	SSLCertPath   string
+	SSLPins       []string
+	ClientCert    func(*tls.CertificateRequestInfo) (*tls.Certificate, error)
 	EventsHandler EventHandler
 	AutoReconnect bool
 	OnDisconnect  *DisconnectMessage
+	WebSocketPath string
+	Delivery      DeliveryOptions
+	// Longest wait between reconnect attempts. Zero uses the default.
+	ReconnectMaxInterval time.Duration
+	// Consecutive failed reconnect attempts before giving up. Zero
+	// retries forever.
+	ReconnectMaxAttempts int
+	// Losing the connection FlapLimit times within FlapWindow pauses
+	// reconnecting for FlapCooldown. Zero disables the limit.
+	FlapLimit    int
+	FlapWindow   time.Duration
+	FlapCooldown time.Duration
+	// Encoding used to compress published payloads larger than
+	// CompressAbove bytes. Empty or NoCompression disables it.
+	// Payloads are only compressed while CompressionAgreed returns
+	// true because Cog agreed to read them. Nil never compresses.
+	Compression       string
+	CompressAbove     int
+	CompressionAgreed func() bool
+	// Messages published while disconnected are held in an outbox of
+	// up to OutboxSize messages, persisted to OutboxPath if it is set
+	OutboxPath string
+	OutboxSize int
+	Tuning     TuningOptions
+	// Applied after middleware registered with Use
+	Middleware []Middleware
+	// Prepended to every topic so several Cog environments can share
+	// a broker
+	TopicPrefix string
+}
+
+// TuningOptions adjust connection timing for slow or high latency
+// links. Zero values use the defaults.
+type TuningOptions struct {
+	KeepAlive      time.Duration
+	PingTimeout    time.Duration
+	ConnectTimeout time.Duration
+	// Zero never times out writes
+	WriteTimeout time.Duration
+	// Most publishes awaiting acknowledgement at once. Zero is
+	// unlimited.
+	MaxInFlight int
 }
 
 // Connection is the high-level message bus interface
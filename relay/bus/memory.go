@@ -0,0 +1,200 @@
+package bus
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+// MemoryBackend is the name MemoryBus.Register registers under
+const MemoryBackend = "memory"
+
+var errorNotConnected = errors.New("Not connected")
+
+// MemoryBus is an in-process message bus which delivers messages
+// synchronously to subscribers, honoring MQTT topic wildcards and
+// retained messages. Embedders and tests use it to connect Relay to a
+// fake Cog without running a broker.
+type MemoryBus struct {
+	lock          sync.Mutex
+	subscriptions []memorySubscription
+	retained      map[string][]byte
+}
+
+type memorySubscription struct {
+	conn    *MemoryConnection
+	filter  string
+	handler SubscriptionHandler
+}
+
+// NewMemoryBus creates an empty MemoryBus
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{
+		retained: make(map[string][]byte),
+	}
+}
+
+// NewConnection returns an unconnected Connection to the bus
+func (mb *MemoryBus) NewConnection() *MemoryConnection {
+	return &MemoryConnection{bus: mb}
+}
+
+// Register makes the bus available to Relay as the "memory" backend,
+// selected with bus/type
+func (mb *MemoryBus) Register() {
+	Register(MemoryBackend, func() Connection {
+		return mb.NewConnection()
+	})
+}
+
+func (mb *MemoryBus) publish(topic string, payload []byte, retained bool) {
+	mb.lock.Lock()
+	if retained {
+		if len(payload) == 0 {
+			delete(mb.retained, topic)
+		} else {
+			mb.retained[topic] = payload
+		}
+	}
+	matching := []memorySubscription{}
+	for _, sub := range mb.subscriptions {
+		if topicMatches(sub.filter, topic) {
+			matching = append(matching, sub)
+		}
+	}
+	mb.lock.Unlock()
+	// Handlers are called without the lock held so they can publish
+	for _, sub := range matching {
+		sub.handler(sub.conn, topic, payload)
+	}
+}
+
+func (mb *MemoryBus) subscribe(sub memorySubscription) {
+	mb.lock.Lock()
+	mb.subscriptions = append(mb.subscriptions, sub)
+	retained := make(map[string][]byte)
+	for topic, payload := range mb.retained {
+		if topicMatches(sub.filter, topic) {
+			retained[topic] = payload
+		}
+	}
+	mb.lock.Unlock()
+	for topic, payload := range retained {
+		sub.handler(sub.conn, topic, payload)
+	}
+}
+
+func (mb *MemoryBus) unsubscribeAll(conn *MemoryConnection) {
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+	remaining := []memorySubscription{}
+	for _, sub := range mb.subscriptions {
+		if sub.conn != conn {
+			remaining = append(remaining, sub)
+		}
+	}
+	mb.subscriptions = remaining
+}
+
+// MemoryConnection is a Connection to a MemoryBus
+type MemoryConnection struct {
+	bus        *MemoryBus
+	lock       sync.Mutex
+	connected  bool
+	options    ConnectionOptions
+	middleware []Middleware
+	publisher  PublishFunc
+}
+
+// Connect is required by the bus.Connection interface. The events
+// handler is called before Connect returns.
+func (mc *MemoryConnection) Connect(options ConnectionOptions) error {
+	mc.lock.Lock()
+	mc.options = options
+	mc.connected = true
+	mc.middleware = middlewareFor(options)
+	mc.publisher = chainPublish(mc.middleware, mc.send)
+	mc.lock.Unlock()
+	if options.EventsHandler != nil {
+		options.EventsHandler(mc, ConnectedEvent)
+	}
+	return nil
+}
+
+// Disconnect is required by the bus.Connection interface
+func (mc *MemoryConnection) Disconnect() error {
+	mc.lock.Lock()
+	mc.connected = false
+	mc.lock.Unlock()
+	mc.bus.unsubscribeAll(mc)
+	return nil
+}
+
+// Drop simulates the connection failing: subscriptions are removed
+// and the connection's will, if any, is published
+func (mc *MemoryConnection) Drop() {
+	mc.Disconnect()
+	if will := mc.options.OnDisconnect; will != nil {
+		_, retained := mc.options.Delivery.forPublish(will.Topic)
+		mc.bus.publish(will.Topic, []byte(will.Body), retained)
+	}
+}
+
+// Publish is required by the bus.Connection interface
+func (mc *MemoryConnection) Publish(topic string, payload []byte) error {
+	mc.lock.Lock()
+	publisher := mc.publisher
+	mc.lock.Unlock()
+	if publisher == nil {
+		return errorNotConnected
+	}
+	return publisher(topic, payload)
+}
+
+func (mc *MemoryConnection) send(topic string, payload []byte) error {
+	mc.lock.Lock()
+	connected := mc.connected
+	mc.lock.Unlock()
+	if connected == false {
+		return errorNotConnected
+	}
+	_, retained := mc.options.Delivery.forPublish(topic)
+	mc.bus.publish(topic, payload, retained)
+	return nil
+}
+
+// Subscribe is required by the bus.Connection interface
+func (mc *MemoryConnection) Subscribe(topic string, handler SubscriptionHandler) error {
+	mc.lock.Lock()
+	connected := mc.connected
+	middleware := mc.middleware
+	mc.lock.Unlock()
+	if connected == false {
+		return errorNotConnected
+	}
+	mc.bus.subscribe(memorySubscription{
+		conn:    mc,
+		filter:  topic,
+		handler: chainReceive(middleware, handler),
+	})
+	return nil
+}
+
+// topicMatches returns true if topic matches an MQTT topic filter,
+// which may contain + and # wildcards
+func topicMatches(filter string, topic string) bool {
+	filterParts := strings.Split(filter, "/")
+	topicParts := strings.Split(topic, "/")
+	for i, part := range filterParts {
+		if part == "#" {
+			return true
+		}
+		if i >= len(topicParts) {
+			return false
+		}
+		if part != "+" && part != topicParts[i] {
+			return false
+		}
+	}
+	return len(filterParts) == len(topicParts)
+}
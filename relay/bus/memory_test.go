@@ -0,0 +1,84 @@
+package bus
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTopicMatches(t *testing.T) {
+	cases := []struct {
+		filter  string
+		topic   string
+		matches bool
+	}{
+		{"/bot/commands/relay-1/#", "/bot/commands/relay-1/operable/echo", true},
+		{"/bot/commands/relay-1/#", "/bot/commands/relay-2/operable/echo", false},
+		{"bot/relays/+/presence", "bot/relays/relay-1/presence", true},
+		{"bot/relays/+/presence", "bot/relays/relay-1/announcer", false},
+		{"bot/relays/discover", "bot/relays/discover", true},
+		{"bot/relays/discover", "bot/relays/discover/extra", false},
+	}
+	for _, c := range cases {
+		if topicMatches(c.filter, c.topic) != c.matches {
+			t.Errorf("Expected %s matching %s to be %v", c.filter, c.topic, c.matches)
+		}
+	}
+}
+
+func TestMemoryBusDelivery(t *testing.T) {
+	memoryBus := NewMemoryBus()
+	cog := memoryBus.NewConnection()
+	relay := memoryBus.NewConnection()
+	connected := false
+	if err := cog.Connect(ConnectionOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	err := relay.Connect(ConnectionOptions{
+		EventsHandler: func(conn Connection, event Event) {
+			connected = event == ConnectedEvent
+		},
+		OnDisconnect: &DisconnectMessage{Topic: AnnouncementTopic, Body: "offline"},
+	})
+	if err != nil || connected == false {
+		t.Fatalf("Expected connected event before Connect returned: %v", err)
+	}
+	// Relay answers requests on the reply topic
+	relay.Subscribe("/bot/commands/relay-1/#", func(conn Connection, topic string, payload []byte) {
+		conn.Publish("/bot/pipelines/abc/reply", append([]byte("re: "), payload...))
+	})
+	replies := []string{}
+	cog.Subscribe("/bot/pipelines/+/reply", func(conn Connection, topic string, payload []byte) {
+		replies = append(replies, string(payload))
+	})
+	announcements := []string{}
+	cog.Subscribe(AnnouncementTopic, func(conn Connection, topic string, payload []byte) {
+		announcements = append(announcements, string(payload))
+	})
+	cog.Publish("/bot/commands/relay-1/operable/echo", []byte("hello"))
+	if fmt.Sprint(replies) != "[re: hello]" {
+		t.Errorf("Unexpected replies: %v", replies)
+	}
+	relay.Drop()
+	if fmt.Sprint(announcements) != "[offline]" {
+		t.Errorf("Expected will to be published: %v", announcements)
+	}
+	if err := relay.Publish("/bot/pipelines/abc/reply", []byte("late")); err != errorNotConnected {
+		t.Errorf("Expected errorNotConnected: %v", err)
+	}
+}
+
+func TestMemoryBusRetained(t *testing.T) {
+	memoryBus := NewMemoryBus()
+	relay := memoryBus.NewConnection()
+	relay.Connect(ConnectionOptions{})
+	relay.Publish(fmt.Sprintf(PresenceTopicTemplate, "relay-1"), []byte("online"))
+	cog := memoryBus.NewConnection()
+	cog.Connect(ConnectionOptions{})
+	received := ""
+	cog.Subscribe("bot/relays/+/presence", func(conn Connection, topic string, payload []byte) {
+		received = string(payload)
+	})
+	if received != "online" {
+		t.Errorf("Expected retained presence on subscribe: %s", received)
+	}
+}
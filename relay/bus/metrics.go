@@ -0,0 +1,29 @@
+package bus
+
+import (
+	"github.com/operable/go-relay/relay/metrics"
+)
+
+// Transport-level metrics, exposed by the admin API's /metrics
+// endpoint
+var (
+	publishedMessages = metrics.NewCounter("relay_bus_messages_published_total",
+		"Messages published to the message bus.")
+	publishFailures = metrics.NewCounter("relay_bus_publish_failures_total",
+		"Messages the message bus failed to accept.")
+	publishLatency = metrics.NewHistogram("relay_bus_publish_duration_seconds",
+		"Time taken to publish a message, including waiting for the broker to acknowledge it.",
+		metrics.DefaultBuckets)
+	receivedMessages = metrics.NewCounter("relay_bus_messages_received_total",
+		"Messages received from the message bus.")
+	heldMessages = metrics.NewCounter("relay_bus_messages_held_total",
+		"Messages held in the outbox while disconnected from the message bus.")
+	droppedMessages = metrics.NewCounter("relay_bus_messages_dropped_total",
+		"Messages dropped because the outbox was full or they couldn't be decoded.")
+	reconnects = metrics.NewCounter("relay_bus_reconnects_total",
+		"Successful reconnects to the message bus after losing the connection.")
+	reconnectFailures = metrics.NewCounter("relay_bus_reconnect_failures_total",
+		"Failed attempts to reconnect to the message bus.")
+	connectionFlaps = metrics.NewCounter("relay_bus_connection_flaps_total",
+		"Times reconnecting was paused because the message bus connection kept dropping.")
+)
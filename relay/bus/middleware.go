@@ -0,0 +1,73 @@
+package bus
+
+import (
+	"sync"
+)
+
+// PublishFunc sends a message to topic
+type PublishFunc func(topic string, payload []byte) error
+
+// PublishMiddleware wraps publishing. Implementations call next to
+// pass the message on, possibly after changing it, or return an error
+// to stop it.
+type PublishMiddleware func(next PublishFunc) PublishFunc
+
+// ReceiveMiddleware wraps the handlers of received messages.
+// Implementations call next to pass the message on or return without
+// calling it to drop the message.
+type ReceiveMiddleware func(next SubscriptionHandler) SubscriptionHandler
+
+// Middleware intercepts messages published and received by a bus
+// connection, for example to record metrics, validate payloads or tag
+// messages. Middleware sees payloads before compression on publish and
+// after decompression on receive. Either function may be nil.
+type Middleware struct {
+	Name    string
+	Publish PublishMiddleware
+	Receive ReceiveMiddleware
+}
+
+var registry = struct {
+	lock       sync.Mutex
+	middleware []Middleware
+}{}
+
+// Use registers middleware applied to every connection opened
+// afterwards, ahead of any listed in ConnectionOptions.Middleware.
+// Embedders call it before starting Relay.
+func Use(middleware Middleware) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	registry.middleware = append(registry.middleware, middleware)
+}
+
+// middlewareFor returns registered middleware followed by
+// connection-specific middleware
+func middlewareFor(options ConnectionOptions) []Middleware {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	chain := append([]Middleware{}, registry.middleware...)
+	return append(chain, options.Middleware...)
+}
+
+// chainPublish wraps publish in middleware. The first middleware sees
+// messages first.
+func chainPublish(middleware []Middleware, publish PublishFunc) PublishFunc {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		if middleware[i].Publish != nil {
+			publish = middleware[i].Publish(publish)
+		}
+	}
+	return publish
+}
+
+// chainReceive wraps handler in middleware. The first middleware sees
+// messages first.
+func chainReceive(middleware []Middleware, handler SubscriptionHandler) SubscriptionHandler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		if middleware[i].Receive != nil {
+			handler = middleware[i].Receive(handler)
+		}
+	}
+	return handler
+}
@@ -0,0 +1,72 @@
+package bus
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func tagging(tag string, calls *[]string) Middleware {
+	return Middleware{
+		Name: tag,
+		Publish: func(next PublishFunc) PublishFunc {
+			return func(topic string, payload []byte) error {
+				*calls = append(*calls, tag)
+				return next(topic, append(payload, []byte(tag)...))
+			}
+		},
+		Receive: func(next SubscriptionHandler) SubscriptionHandler {
+			return func(conn Connection, topic string, payload []byte) {
+				*calls = append(*calls, tag)
+				next(conn, topic, append(payload, []byte(tag)...))
+			}
+		},
+	}
+}
+
+func TestChainPublishOrder(t *testing.T) {
+	calls := []string{}
+	var sent []byte
+	publish := chainPublish([]Middleware{tagging("a", &calls), {Name: "noop"}, tagging("b", &calls)},
+		func(topic string, payload []byte) error {
+			sent = payload
+			return nil
+		})
+	if err := publish("topic", []byte("msg:")); err != nil {
+		t.Fatal(err)
+	}
+	if string(sent) != "msg:ab" || reflect.DeepEqual(calls, []string{"a", "b"}) == false {
+		t.Errorf("Unexpected middleware order: %s %v", sent, calls)
+	}
+}
+
+func TestChainPublishStops(t *testing.T) {
+	errorRejected := errors.New("rejected")
+	reject := Middleware{
+		Publish: func(next PublishFunc) PublishFunc {
+			return func(topic string, payload []byte) error {
+				return errorRejected
+			}
+		},
+	}
+	publish := chainPublish([]Middleware{reject}, func(topic string, payload []byte) error {
+		t.Error("Expected rejected message not to be sent")
+		return nil
+	})
+	if err := publish("topic", nil); err != errorRejected {
+		t.Errorf("Expected errorRejected: %v", err)
+	}
+}
+
+func TestChainReceiveOrder(t *testing.T) {
+	calls := []string{}
+	var received []byte
+	handler := chainReceive([]Middleware{tagging("a", &calls), tagging("b", &calls)},
+		func(conn Connection, topic string, payload []byte) {
+			received = payload
+		})
+	handler(nil, "topic", []byte("msg:"))
+	if string(received) != "msg:ab" || reflect.DeepEqual(calls, []string{"a", "b"}) == false {
+		t.Errorf("Unexpected middleware order: %s %v", received, calls)
+	}
+}
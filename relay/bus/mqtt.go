@@ -1,52 +1,108 @@
 package bus
 
 import (
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/hex"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"github.com/eclipse/paho.mqtt.golang"
 	"github.com/golang/snappy"
 	"io/ioutil"
+	"strings"
+	"sync"
 	"time"
 )
 
+// Connection timing used when ConnectionOptions.Tuning is unset
+const (
+	defaultKeepAlive      = 60 * time.Second
+	defaultPingTimeout    = 15 * time.Second
+	defaultConnectTimeout = 30 * time.Second
+)
+
 // MQTTConnection is a MQTT-specific implementation of
 // bus.Connection
 type MQTTConnection struct {
 	options ConnectionOptions
 	conn    *mqtt.Client
 	backoff *Backoff
+	breaker *RestartBreaker
+	bridge  *webSocketBridge
+	lock    sync.Mutex
+	offline bool
+	outbox  *outbox
+	// Limits publishes awaiting acknowledgement. Nil is unlimited.
+	inFlight chan struct{}
+	// Middleware wrapped around send and subscription handlers
+	middleware []Middleware
+	publisher  PublishFunc
+	namespace  namespace
 }
 
 // Connect is required by the bus.Connection interface
 func (mqc *MQTTConnection) Connect(options ConnectionOptions) error {
+	mqc.options = options
+	mqc.namespace = namespace{prefix: options.TopicPrefix}
+	outbox, err := openOutbox(options.OutboxPath, options.OutboxSize)
+	if err != nil {
+		return err
+	}
+	mqc.outbox = outbox
+	mqc.middleware = middlewareFor(options)
+	mqc.publisher = chainPublish(mqc.middleware, mqc.send)
+	if options.Tuning.MaxInFlight > 0 {
+		mqc.inFlight = make(chan struct{}, options.Tuning.MaxInFlight)
+	}
+	// Hold new messages until those left by a previous run are sent
+	mqc.offline = outbox.Len() > 0
 	mqttOpts := mqc.buildMQTTOptions(options)
-	if err := configureSSL(options, mqttOpts); err != nil {
+	if options.WebSocketPath != "" {
+		// The MQTT client can't use proxies, so it talks to a local
+		// bridge which tunnels to Cog over a WebSocket
+		tlsConfig, err := newTLSConfig(options)
+		if err != nil {
+			return err
+		}
+		bridge, err := newWebSocketBridge(webSocketURL(options), tlsConfig)
+		if err != nil {
+			return err
+		}
+		mqc.bridge = bridge
+		mqttOpts.Servers = nil
+		mqttOpts.AddBroker(fmt.Sprintf("tcp://%s", bridge.Addr()))
+	} else if err := configureSSL(options, mqttOpts); err != nil {
 		return err
 	}
 	if options.OnDisconnect != nil {
 		compressed := snappy.Encode(nil, []byte(options.OnDisconnect.Body))
-		mqttOpts.SetWill(options.OnDisconnect.Topic, string(compressed), 1, false)
+		qos, retained := options.Delivery.forPublish(options.OnDisconnect.Topic)
+		mqttOpts.SetWill(mqc.namespace.qualify(options.OnDisconnect.Topic), string(compressed), qos, retained)
 	}
 	if options.EventsHandler != nil && options.AutoReconnect == true {
 		mqttOpts.OnConnect = func(c *mqtt.Client) {
 			mqc.conn = c
+			mqc.flushPending()
 			mqc.options.EventsHandler(mqc, ConnectedEvent)
 		}
 	}
-	mqc.backoff = NewBackoff()
+	// Relay can't do anything useful until it first reaches Cog so the
+	// retry budget only applies to reconnects
+	initial := NewBackoff(options.ReconnectMaxInterval, 0)
+	mqc.backoff = NewBackoff(options.ReconnectMaxInterval, options.ReconnectMaxAttempts)
+	mqc.breaker = NewRestartBreaker(options.FlapLimit, options.FlapWindow, options.FlapCooldown)
 	mqc.conn = mqtt.NewClient(mqttOpts)
 	for {
 		if token := mqc.conn.Connect(); token.Wait() && token.Error() != nil {
 			log.Errorf("Error connecting to %s: %s", brokerURL(options), token.Error())
-			mqc.backoff.Wait()
+			initial.Wait()
 		} else {
-			mqc.backoff.Reset()
 			break
 		}
 	}
-	mqc.options = options
+	// Send anything a previous run couldn't
+	mqc.flushPending()
 	if mqc.options.EventsHandler != nil && mqc.options.AutoReconnect != true {
 		mqc.options.EventsHandler(mqc, ConnectedEvent)
 	}
@@ -56,44 +112,170 @@ func (mqc *MQTTConnection) Connect(options ConnectionOptions) error {
 // Disconnect is required by the bus.Connection interface
 func (mqc *MQTTConnection) Disconnect() error {
 	mqc.conn.Disconnect(1000)
+	if mqc.bridge != nil {
+		mqc.bridge.Close()
+	}
 	return nil
 }
 
-// Publish is required by the bus.Connection interface
+// Publish is required by the bus.Connection interface. Messages
+// published while reconnecting are held and sent once the connection
+// is re-established.
 func (mqc *MQTTConnection) Publish(topic string, payload []byte) error {
+	if mqc.publisher == nil {
+		return mqc.send(topic, payload)
+	}
+	return mqc.publisher(topic, payload)
+}
+
+// send publishes a message which has passed through middleware
+func (mqc *MQTTConnection) send(topic string, payload []byte) error {
+	if mqc.holdIfOffline(topic, payload) {
+		return nil
+	}
+	err := mqc.publish(topic, payload)
+	if err == mqtt.ErrNotConnected {
+		// The connection dropped before the lost handler ran
+		mqc.hold(topic, payload)
+		return nil
+	}
+	return err
+}
+
+func (mqc *MQTTConnection) publish(topic string, payload []byte) error {
+	payload, err := compressPayload(mqc.options.publishCompression(), mqc.options.CompressAbove, payload)
+	if err != nil {
+		return err
+	}
 	compressed := snappy.Encode(nil, payload)
-	token := mqc.conn.Publish(topic, 1, false, compressed)
+	qos, retained := mqc.options.Delivery.forPublish(topic)
+	if mqc.inFlight != nil {
+		mqc.inFlight <- struct{}{}
+		defer func() { <-mqc.inFlight }()
+	}
+	started := time.Now()
+	token := mqc.conn.Publish(mqc.namespace.qualify(topic), qos, retained, compressed)
 	token.Wait()
-	return token.Error()
+	publishLatency.Observe(time.Now().Sub(started).Seconds())
+	if err := token.Error(); err != nil {
+		publishFailures.Inc()
+		return err
+	}
+	publishedMessages.Inc()
+	return nil
 }
 
 // Subscribe is required by the bus.Connection interface
 func (mqc *MQTTConnection) Subscribe(topic string, handler SubscriptionHandler) error {
+	handler = chainReceive(mqc.middleware, handler)
 	mqttHandler := func(client *mqtt.Client, message mqtt.Message) {
 		compressed := message.Payload()
+		receivedMessages.Inc()
 		payload, err := snappy.Decode(nil, compressed)
 		if err != nil {
 			log.Errorf("Decompressing MQTT payload failed: %s", err)
+			droppedMessages.Inc()
 			return
 		}
-		handler(mqc, message.Topic(), payload)
+		payload, err = decompressPayload(payload)
+		if err != nil {
+			log.Errorf("Decoding compressed payload on %s failed: %s", message.Topic(), err)
+			droppedMessages.Inc()
+			return
+		}
+		handler(mqc, mqc.namespace.unqualify(message.Topic(), topic), payload)
 	}
-	token := mqc.conn.Subscribe(topic, 1, mqttHandler)
+	token := mqc.conn.Subscribe(mqc.namespace.qualify(topic), mqc.options.Delivery.CommandQoS, mqttHandler)
 	token.Wait()
 	return token.Error()
 }
 
+func (mqc *MQTTConnection) holdIfOffline(topic string, payload []byte) bool {
+	mqc.lock.Lock()
+	defer mqc.lock.Unlock()
+	if mqc.offline == false {
+		return false
+	}
+	mqc.outbox.Add(topic, payload)
+	return true
+}
+
+func (mqc *MQTTConnection) hold(topic string, payload []byte) {
+	mqc.outbox.Add(topic, payload)
+}
+
+// flushPending sends messages held while disconnected, in the order
+// they were published, and resumes publishing directly
+func (mqc *MQTTConnection) flushPending() {
+	for {
+		mqc.lock.Lock()
+		pending := mqc.outbox.Drain()
+		if len(pending) == 0 {
+			mqc.offline = false
+			mqc.lock.Unlock()
+			return
+		}
+		mqc.lock.Unlock()
+		log.Infof("Sending %d messages held while disconnected.", len(pending))
+		for i, message := range pending {
+			err := mqc.publish(message.Topic, message.Payload)
+			if err == mqtt.ErrNotConnected {
+				// Lost the connection again. The rest are sent after
+				// the next reconnect.
+				mqc.outbox.Requeue(pending[i:])
+				return
+			}
+			if err != nil {
+				log.Errorf("Failed to send message for %s held while disconnected: %s.", message.Topic, err)
+			}
+		}
+	}
+}
+
+func (mqc *MQTTConnection) setOffline() {
+	mqc.lock.Lock()
+	defer mqc.lock.Unlock()
+	mqc.offline = true
+}
+
+// disconnected reconnects to Cog with exponential backoff. Subscribers
+// and request workers are left running; responses they publish in the
+// meantime are held until the connection is back.
 func (mqc *MQTTConnection) disconnected(client *mqtt.Client, err error) {
 	log.Errorf("MQTT connection failed: %s.", err)
+	mqc.setOffline()
+	if mqc.options.EventsHandler != nil {
+		mqc.options.EventsHandler(mqc, DisconnectedEvent)
+	}
+	if mqc.breaker.Record(time.Now()) {
+		log.Warnf("Connection to %s was lost %d times within %v. Waiting %v before reconnecting.",
+			brokerURL(mqc.options), mqc.breaker.Limit(), mqc.breaker.Window(), mqc.breaker.Cooldown())
+		connectionFlaps.Inc()
+		if mqc.options.EventsHandler != nil {
+			mqc.options.EventsHandler(mqc, FlappingEvent)
+		}
+		time.Sleep(mqc.breaker.Cooldown())
+	}
 	for {
 		if token := mqc.conn.Connect(); token.Wait() && token.Error() != nil {
 			log.Errorf("Error connecting to %s: %s", brokerURL(mqc.options), token.Error())
-			mqc.backoff.Wait()
+			reconnectFailures.Inc()
+			if err := mqc.backoff.Wait(); err != nil {
+				log.Errorf("Giving up reconnecting to %s after %d attempts.",
+					brokerURL(mqc.options), mqc.options.ReconnectMaxAttempts)
+				if mqc.options.EventsHandler != nil {
+					mqc.options.EventsHandler(mqc, ReconnectFailedEvent)
+				}
+				return
+			}
 		} else {
 			mqc.backoff.Reset()
 			break
 		}
 	}
+	log.Infof("Reconnected to %s.", brokerURL(mqc.options))
+	reconnects.Inc()
+	mqc.flushPending()
 	if mqc.options.EventsHandler != nil {
 		mqc.options.EventsHandler(mqc, ConnectedEvent)
 	}
@@ -101,14 +283,20 @@ func (mqc *MQTTConnection) disconnected(client *mqtt.Client, err error) {
 
 func (mqc *MQTTConnection) buildMQTTOptions(options ConnectionOptions) *mqtt.ClientOptions {
 	clientID := fmt.Sprintf("%x", time.Now().UTC().UnixNano())
+	if options.Delivery.PersistentSession {
+		// Brokers only resume sessions for the same client ID
+		clientID = persistentClientID(options.Userid)
+	}
 	mqttOpts := mqtt.NewClientOptions()
 	mqttOpts.SetAutoReconnect(options.AutoReconnect)
-	mqttOpts.SetKeepAlive(time.Duration(60) * time.Second)
-	mqttOpts.SetPingTimeout(time.Duration(15) * time.Second)
+	mqttOpts.SetKeepAlive(durationOr(options.Tuning.KeepAlive, defaultKeepAlive))
+	mqttOpts.SetPingTimeout(durationOr(options.Tuning.PingTimeout, defaultPingTimeout))
+	mqttOpts.SetConnectTimeout(durationOr(options.Tuning.ConnectTimeout, defaultConnectTimeout))
+	mqttOpts.SetWriteTimeout(options.Tuning.WriteTimeout)
 	mqttOpts.SetUsername(options.Userid)
 	mqttOpts.SetPassword(options.Password)
 	mqttOpts.SetClientID(clientID)
-	mqttOpts.SetCleanSession(true)
+	mqttOpts.SetCleanSession(!options.Delivery.PersistentSession)
 	brokerURL := brokerURL(options)
 	mqttOpts.AddBroker(brokerURL)
 	if !options.AutoReconnect {
@@ -117,40 +305,94 @@ func (mqc *MQTTConnection) buildMQTTOptions(options ConnectionOptions) *mqtt.Cli
 	return mqttOpts
 }
 
+// forPublish returns the QoS and retain flag for a message published
+// to topic. Presence messages are always retained so Cog sees a
+// Relay's last known state when it subscribes.
+func (do DeliveryOptions) forPublish(topic string) (byte, bool) {
+	if topic == AnnouncementTopic {
+		return do.AnnouncementQoS, do.RetainAnnouncements
+	}
+	if isPresenceTopic(topic) {
+		return do.AnnouncementQoS, true
+	}
+	return do.ResponseQoS, false
+}
+
+func durationOr(value, fallback time.Duration) time.Duration {
+	if value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+func isPresenceTopic(topic string) bool {
+	return strings.HasPrefix(topic, "bot/relays/") && strings.HasSuffix(topic, "/presence")
+}
+
+// persistentClientID derives a stable MQTT client ID from a user ID.
+// IDs are kept to the 23 characters every broker accepts.
+func persistentClientID(userid string) string {
+	sum := sha256.Sum256([]byte(userid))
+	return hex.EncodeToString(sum[:])[:23]
+}
+
 func configureSSL(options ConnectionOptions, mqttOpts *mqtt.ClientOptions) error {
+	tlsConfig, err := newTLSConfig(options)
+	if err != nil || tlsConfig == nil {
+		return err
+	}
+	mqttOpts.TLSConfig.InsecureSkipVerify = tlsConfig.InsecureSkipVerify
+	mqttOpts.TLSConfig.RootCAs = tlsConfig.RootCAs
+	mqttOpts.TLSConfig.GetClientCertificate = tlsConfig.GetClientCertificate
+	mqttOpts.TLSConfig.VerifyPeerCertificate = tlsConfig.VerifyPeerCertificate
+	return nil
+}
+
+// newTLSConfig returns the TLS settings for connecting to Cog, or nil
+// if SSL is disabled
+func newTLSConfig(options ConnectionOptions) (*tls.Config, error) {
 	if !options.SSLEnabled {
-		return nil
+		return nil, nil
 	}
 	log.Info("SSL enabled on MQTT connection to Cog")
-	if options.SSLCertPath == "" {
+	tlsConfig := &tls.Config{}
+	if options.SSLCertPath == "" && len(options.SSLPins) > 0 {
+		log.Info("TLS certificate verification disabled. Cog's certificate must match a configured SPKI pin.")
+		tlsConfig.InsecureSkipVerify = true
+	} else if options.SSLCertPath == "" {
 		log.Warn("TLS certificate verification disabled.")
-		mqttOpts.TLSConfig = tls.Config{
-			InsecureSkipVerify: true,
-		}
+		tlsConfig.InsecureSkipVerify = true
 	} else {
 		buf, err := ioutil.ReadFile(options.SSLCertPath)
 		if err != nil {
 			log.Errorf("Error reading TLS certificate file %s: %s.",
 				options.SSLCertPath, err)
-			return err
+			return nil, err
 		}
 		roots := x509.NewCertPool()
 		ok := roots.AppendCertsFromPEM(buf)
 		if !ok {
 			log.Errorf("Failed to parse TLS certificate file %s.",
 				options.SSLCertPath)
-			return errorBadTLSCert
+			return nil, errorBadTLSCert
 		}
 		log.Info("TLS certificate verification enabled.")
-		mqttOpts.TLSConfig = tls.Config{
-			InsecureSkipVerify: false,
-			RootCAs:            roots,
-		}
+		tlsConfig.RootCAs = roots
 	}
-	return nil
+	if len(options.SSLPins) > 0 {
+		tlsConfig.VerifyPeerCertificate = pinVerifier(options.SSLPins, tlsConfig.InsecureSkipVerify == false)
+	}
+	if options.ClientCert != nil {
+		log.Info("TLS client certificate authentication enabled.")
+		tlsConfig.GetClientCertificate = options.ClientCert
+	}
+	return tlsConfig, nil
 }
 
 func brokerURL(options ConnectionOptions) string {
+	if options.WebSocketPath != "" {
+		return webSocketURL(options).String()
+	}
 	prefix := "tcp"
 	if options.SSLEnabled {
 		prefix = "ssl"
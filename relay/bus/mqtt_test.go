@@ -0,0 +1,27 @@
+package bus
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDeliveryForPublish(t *testing.T) {
+	delivery := DeliveryOptions{ResponseQoS: 0, AnnouncementQoS: 2, RetainAnnouncements: true}
+	if qos, retained := delivery.forPublish(AnnouncementTopic); qos != 2 || retained == false {
+		t.Errorf("Unexpected announcement delivery: %d %v", qos, retained)
+	}
+	if qos, retained := delivery.forPublish("/bot/pipelines/abc/reply"); qos != 0 || retained == true {
+		t.Errorf("Unexpected response delivery: %d %v", qos, retained)
+	}
+	delivery.RetainAnnouncements = false
+	if qos, retained := delivery.forPublish(fmt.Sprintf(PresenceTopicTemplate, "relay-1")); qos != 2 || retained == false {
+		t.Errorf("Unexpected presence delivery: %d %v", qos, retained)
+	}
+}
+
+func TestPersistentClientID(t *testing.T) {
+	id := persistentClientID("2bba0d1f-a30c-45ec-87e6-e4c5d8c6104f/announcer")
+	if len(id) != 23 || id != persistentClientID("2bba0d1f-a30c-45ec-87e6-e4c5d8c6104f/announcer") {
+		t.Errorf("Expected stable 23 character client ID: %s", id)
+	}
+}
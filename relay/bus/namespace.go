@@ -0,0 +1,35 @@
+package bus
+
+import (
+	"strings"
+)
+
+// namespace maps topics used by Relay to topics on a broker shared by
+// several Cog environments. Relay code always uses unqualified topics;
+// the connection adds the prefix when publishing and subscribing and
+// removes it from the topics of received messages.
+type namespace struct {
+	prefix string
+}
+
+// qualify returns topic within the namespace. Topics with and without
+// a leading slash map to the same qualified topic.
+func (ns namespace) qualify(topic string) string {
+	if ns.prefix == "" {
+		return topic
+	}
+	return ns.prefix + "/" + strings.TrimPrefix(topic, "/")
+}
+
+// unqualify returns a received topic as Relay expects it, given the
+// unqualified filter it was subscribed with
+func (ns namespace) unqualify(topic string, filter string) string {
+	if ns.prefix == "" {
+		return topic
+	}
+	topic = strings.TrimPrefix(topic, ns.prefix+"/")
+	if strings.HasPrefix(filter, "/") {
+		return "/" + topic
+	}
+	return topic
+}
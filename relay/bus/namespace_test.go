@@ -0,0 +1,26 @@
+package bus
+
+import (
+	"testing"
+)
+
+func TestNamespace(t *testing.T) {
+	ns := namespace{prefix: "staging"}
+	if topic := ns.qualify(AnnouncementTopic); topic != "staging/bot/relays/discover" {
+		t.Errorf("Unexpected qualified topic: %s", topic)
+	}
+	if topic := ns.qualify("/bot/commands/relay-1/#"); topic != "staging/bot/commands/relay-1/#" {
+		t.Errorf("Unexpected qualified topic: %s", topic)
+	}
+	received := ns.unqualify("staging/bot/commands/relay-1/abc", "/bot/commands/relay-1/#")
+	if received != "/bot/commands/relay-1/abc" {
+		t.Errorf("Unexpected unqualified topic: %s", received)
+	}
+	received = ns.unqualify("staging/bot/relays/relay-1/directives", "bot/relays/relay-1/directives")
+	if received != "bot/relays/relay-1/directives" {
+		t.Errorf("Unexpected unqualified topic: %s", received)
+	}
+	if topic := (namespace{}).qualify("/bot/commands/relay-1/#"); topic != "/bot/commands/relay-1/#" {
+		t.Errorf("Expected empty namespace to leave topics alone: %s", topic)
+	}
+}
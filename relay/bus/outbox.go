@@ -0,0 +1,167 @@
+package bus
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Messages held while disconnected when ConnectionOptions don't set
+// OutboxSize
+const defaultOutboxSize = 1000
+
+type pendingPublish struct {
+	Topic   string `json:"topic"`
+	Payload []byte `json:"payload"`
+}
+
+// outbox is a bounded, ordered store of messages published while
+// disconnected from Cog. When path is set messages are persisted so
+// responses survive a Relay restart during an outage. The oldest
+// messages are dropped once the limit is reached.
+type outbox struct {
+	lock     sync.Mutex
+	path     string
+	max      int
+	messages []pendingPublish
+}
+
+// openOutbox loads messages left in the outbox at path. An empty path
+// keeps messages in memory only.
+func openOutbox(path string, max int) (*outbox, error) {
+	if max <= 0 {
+		max = defaultOutboxSize
+	}
+	ob := &outbox{
+		path: path,
+		max:  max,
+	}
+	if path == "" {
+		return ob, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ob, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var message pendingPublish
+		if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+			log.Warnf("Skipped corrupt outbox entry in %s: %s.", path, err)
+			continue
+		}
+		ob.messages = append(ob.messages, message)
+	}
+	if len(ob.messages) > 0 {
+		log.Infof("Loaded %d messages left unsent by a previous run.", len(ob.messages))
+	}
+	ob.trim()
+	return ob, scanner.Err()
+}
+
+// Add appends a message to the outbox
+func (ob *outbox) Add(topic string, payload []byte) {
+	ob.lock.Lock()
+	defer ob.lock.Unlock()
+	message := pendingPublish{Topic: topic, Payload: payload}
+	ob.messages = append(ob.messages, message)
+	heldMessages.Inc()
+	compact := ob.trim()
+	if err := ob.persist(message, compact); err != nil {
+		log.Errorf("Failed to persist outbox to %s: %s.", ob.path, err)
+	}
+}
+
+// Drain removes and returns every message in the outbox, oldest first
+func (ob *outbox) Drain() []pendingPublish {
+	ob.lock.Lock()
+	defer ob.lock.Unlock()
+	messages := ob.messages
+	ob.messages = nil
+	if ob.path != "" && len(messages) > 0 {
+		if err := os.Remove(ob.path); err != nil && os.IsNotExist(err) == false {
+			log.Errorf("Failed to clear outbox %s: %s.", ob.path, err)
+		}
+	}
+	return messages
+}
+
+// Requeue puts messages which couldn't be sent back at the front of
+// the outbox
+func (ob *outbox) Requeue(messages []pendingPublish) {
+	ob.lock.Lock()
+	defer ob.lock.Unlock()
+	ob.messages = append(append([]pendingPublish{}, messages...), ob.messages...)
+	ob.trim()
+	if err := ob.persist(pendingPublish{}, true); err != nil {
+		log.Errorf("Failed to persist outbox to %s: %s.", ob.path, err)
+	}
+}
+
+// Len returns the number of messages waiting to be sent
+func (ob *outbox) Len() int {
+	ob.lock.Lock()
+	defer ob.lock.Unlock()
+	return len(ob.messages)
+}
+
+// trim drops the oldest messages beyond the size limit. Returns true
+// if any were dropped.
+func (ob *outbox) trim() bool {
+	if len(ob.messages) <= ob.max {
+		return false
+	}
+	dropped := len(ob.messages) - ob.max
+	log.Warnf("Dropped %d messages held while disconnected. More than %d messages are waiting.",
+		dropped, ob.max)
+	ob.messages = ob.messages[dropped:]
+	droppedMessages.Add(int64(dropped))
+	return true
+}
+
+// persist appends message to the outbox file, or rewrites the whole
+// file after older messages have been dropped
+func (ob *outbox) persist(message pendingPublish, rewrite bool) error {
+	if ob.path == "" {
+		return nil
+	}
+	if rewrite {
+		data := []byte{}
+		for _, m := range ob.messages {
+			line, err := json.Marshal(m)
+			if err != nil {
+				return err
+			}
+			data = append(append(data, line...), '\n')
+		}
+		tmp := fmt.Sprintf("%s.tmp", ob.path)
+		if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+			return err
+		}
+		return os.Rename(tmp, ob.path)
+	}
+	line, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(ob.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
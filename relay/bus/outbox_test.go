@@ -0,0 +1,45 @@
+package bus
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOutboxPersists(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "outbox.jsonl")
+	outbox, err := openOutbox(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dropped := droppedMessages.Value()
+	outbox.Add("/bot/pipelines/1/reply", []byte("one"))
+	outbox.Add(AnnouncementTopic, []byte("two"))
+	outbox.Add("/bot/pipelines/3/reply", []byte("three"))
+	if droppedMessages.Value() != dropped+1 {
+		t.Errorf("Expected one dropped message to be counted: %d", droppedMessages.Value()-dropped)
+	}
+	reopened, err := openOutbox(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending := reopened.Drain()
+	if len(pending) != 2 || string(pending[0].Payload) != "two" || string(pending[1].Payload) != "three" {
+		t.Fatalf("Expected the two newest messages in order: %v", pending)
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) == false {
+		t.Errorf("Expected Drain to remove %s", path)
+	}
+	reopened.Add("/bot/pipelines/4/reply", []byte("four"))
+	reopened.Requeue(pending[1:])
+	pending = reopened.Drain()
+	if len(pending) != 2 || string(pending[0].Payload) != "three" || string(pending[1].Payload) != "four" {
+		t.Errorf("Expected requeued message first: %v", pending)
+	}
+}
@@ -0,0 +1,53 @@
+package bus
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+var errorCertificateNotPinned = errors.New("Cog's TLS certificate doesn't match any configured SPKI pin")
+
+// SPKIPin returns the base64 encoded SHA-256 hash of a certificate's
+// subject public key info, the format used by cog/ssl_pins
+func SPKIPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// pinVerifier returns a tls.Config.VerifyPeerCertificate function
+// which requires the server's certificate chain to contain a pinned
+// key. When certificate verification is disabled only the leaf
+// certificate can match, since unverified chains may include
+// certificates the server doesn't hold the key for.
+func pinVerifier(pins []string, chainVerified bool) func([][]byte, [][]*x509.Certificate) error {
+	pinned := map[string]bool{}
+	for _, pin := range pins {
+		pinned[strings.TrimPrefix(pin, "sha256/")] = true
+	}
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if chainVerified {
+			for _, chain := range verifiedChains {
+				for _, cert := range chain {
+					if pinned[SPKIPin(cert)] {
+						return nil
+					}
+				}
+			}
+			return errorCertificateNotPinned
+		}
+		if len(rawCerts) == 0 {
+			return errorCertificateNotPinned
+		}
+		leaf, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return err
+		}
+		if pinned[SPKIPin(leaf)] {
+			return nil
+		}
+		return errorCertificateNotPinned
+	}
+}
@@ -0,0 +1,53 @@
+package bus
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func selfSigned(t *testing.T) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "cog"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestPinVerifier(t *testing.T) {
+	leaf := selfSigned(t)
+	other := selfSigned(t)
+	verify := pinVerifier([]string{"sha256/" + SPKIPin(leaf)}, false)
+	if err := verify([][]byte{leaf.Raw}, nil); err != nil {
+		t.Errorf("Expected pinned leaf to be accepted: %s", err)
+	}
+	if err := verify([][]byte{other.Raw, leaf.Raw}, nil); err != errorCertificateNotPinned {
+		t.Errorf("Expected unverified intermediate to be ignored: %v", err)
+	}
+	verify = pinVerifier([]string{SPKIPin(leaf)}, true)
+	if err := verify(nil, [][]*x509.Certificate{{other, leaf}}); err != nil {
+		t.Errorf("Expected pinned CA in verified chain to be accepted: %s", err)
+	}
+	if err := verify(nil, [][]*x509.Certificate{{other}}); err != errorCertificateNotPinned {
+		t.Errorf("Expected unpinned chain to be rejected: %v", err)
+	}
+}
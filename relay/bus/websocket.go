@@ -0,0 +1,210 @@
+package bus
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/websocket"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// How long to wait for Cog or a proxy when opening a tunnel
+const webSocketDialTimeout = 30 * time.Second
+
+// webSocketURL returns the ws:// or wss:// URL of Cog's MQTT over
+// WebSocket endpoint
+func webSocketURL(options ConnectionOptions) *url.URL {
+	scheme := "ws"
+	if options.SSLEnabled {
+		scheme = "wss"
+	}
+	return &url.URL{
+		Scheme: scheme,
+		Host:   fmt.Sprintf("%s:%d", options.Host, options.Port),
+		Path:   options.WebSocketPath,
+	}
+}
+
+// webSocketBridge accepts MQTT connections from the local MQTT client
+// on a loopback port and tunnels each to Cog over a WebSocket. Tunnels
+// honor HTTPS_PROXY and NO_PROXY so Relays can reach Cog through
+// HTTP(S)-only egress proxies.
+type webSocketBridge struct {
+	target    *url.URL
+	tlsConfig *tls.Config
+	listener  net.Listener
+	lock      sync.Mutex
+	tunnels   map[net.Conn]bool
+}
+
+func newWebSocketBridge(target *url.URL, tlsConfig *tls.Config) (*webSocketBridge, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	bridge := &webSocketBridge{
+		target:    target,
+		tlsConfig: tlsConfig,
+		listener:  listener,
+		tunnels:   make(map[net.Conn]bool),
+	}
+	go bridge.serve()
+	return bridge, nil
+}
+
+// Addr returns the loopback address the MQTT client connects to
+func (b *webSocketBridge) Addr() string {
+	return b.listener.Addr().String()
+}
+
+// Close stops accepting connections and closes open tunnels
+func (b *webSocketBridge) Close() {
+	b.listener.Close()
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for conn := range b.tunnels {
+		conn.Close()
+	}
+}
+
+func (b *webSocketBridge) serve() {
+	for {
+		local, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		go b.tunnel(local)
+	}
+}
+
+func (b *webSocketBridge) tunnel(local net.Conn) {
+	remote, err := dialWebSocket(b.target, b.tlsConfig)
+	if err != nil {
+		log.Errorf("Error opening WebSocket to %s: %s.", b.target, err)
+		local.Close()
+		return
+	}
+	b.track(local, true)
+	b.track(remote, true)
+	done := make(chan struct{}, 2)
+	pipe := func(dst io.Writer, src io.Reader) {
+		io.Copy(dst, src)
+		done <- struct{}{}
+	}
+	go pipe(remote, local)
+	go pipe(local, remote)
+	// Either side closing tears down the whole tunnel
+	<-done
+	local.Close()
+	remote.Close()
+	b.track(local, false)
+	b.track(remote, false)
+}
+
+func (b *webSocketBridge) track(conn net.Conn, open bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if open {
+		b.tunnels[conn] = true
+	} else {
+		delete(b.tunnels, conn)
+	}
+}
+
+// dialWebSocket opens a binary WebSocket speaking the mqtt
+// subprotocol, through the environment's proxy if one applies
+func dialWebSocket(target *url.URL, tlsConfig *tls.Config) (*websocket.Conn, error) {
+	origin := &url.URL{Scheme: "http", Host: target.Host}
+	if target.Scheme == "wss" {
+		origin.Scheme = "https"
+	}
+	config, err := websocket.NewConfig(target.String(), origin.String())
+	if err != nil {
+		return nil, err
+	}
+	config.Protocol = []string{"mqtt"}
+	conn, err := dialProxied(origin)
+	if err != nil {
+		return nil, err
+	}
+	if target.Scheme == "wss" {
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+		tlsConfig = tlsConfig.Clone()
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = target.Hostname()
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	ws, err := websocket.NewClient(config, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	ws.PayloadType = websocket.BinaryFrame
+	return ws, nil
+}
+
+// dialProxied opens a TCP connection to the host of target, tunneling
+// through an HTTP CONNECT proxy when the environment configures one
+func dialProxied(target *url.URL) (net.Conn, error) {
+	proxyURL, err := http.ProxyFromEnvironment(&http.Request{URL: target})
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL == nil {
+		return net.DialTimeout("tcp", target.Host, webSocketDialTimeout)
+	}
+	return dialThrough(proxyURL, target)
+}
+
+// dialThrough opens a tunnel to the host of target through an HTTP
+// CONNECT proxy
+func dialThrough(proxyURL *url.URL, target *url.URL) (net.Conn, error) {
+	log.Debugf("Connecting to %s through proxy %s.", target.Host, proxyURL.Host)
+	conn, err := net.DialTimeout("tcp", proxyURL.Host, webSocketDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	connect := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: target.Host},
+		Host:   target.Host,
+		Header: make(http.Header),
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		connect.SetBasicAuth(proxyURL.User.Username(), password)
+		connect.Header.Set("Proxy-Authorization", connect.Header.Get("Authorization"))
+		connect.Header.Del("Authorization")
+	}
+	conn.SetDeadline(time.Now().Add(webSocketDialTimeout))
+	if err := connect.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), connect)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("Proxy %s refused tunnel to %s: %s", proxyURL.Host, target.Host, resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
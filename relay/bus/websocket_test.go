@@ -0,0 +1,66 @@
+package bus
+
+import (
+	"bufio"
+	"golang.org/x/net/websocket"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestWebSocketURL(t *testing.T) {
+	options := ConnectionOptions{Host: "cog.example.com", Port: 443, SSLEnabled: true, WebSocketPath: "/mqtt"}
+	if u := webSocketURL(options).String(); u != "wss://cog.example.com:443/mqtt" {
+		t.Errorf("Unexpected WebSocket URL: %s", u)
+	}
+	if u := brokerURL(options); u != "wss://cog.example.com:443/mqtt" {
+		t.Errorf("Unexpected broker URL: %s", u)
+	}
+}
+
+func TestWebSocketBridgeTunnelsBytes(t *testing.T) {
+	server := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		io.Copy(ws, ws)
+	}))
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+	target.Scheme = "ws"
+	bridge, err := newWebSocketBridge(target, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bridge.Close()
+	conn, err := net.Dial("tcp", bridge.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("ping\n"))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "ping\n" {
+		t.Errorf("Unexpected echo through bridge: %q (%v)", line, err)
+	}
+}
+
+func TestDialProxiedUsesConnect(t *testing.T) {
+	backend, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer backend.Close()
+	connected := make(chan string, 1)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		connected <- req.Method + " " + req.Host
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+	conn, err := dialThrough(proxyURL, &url.URL{Scheme: "https", Host: backend.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if request := <-connected; request != "CONNECT "+backend.Addr().String() {
+		t.Errorf("Unexpected proxy request: %s", request)
+	}
+}
@@ -0,0 +1,117 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+// KeyPair is a TLS certificate and private key which are reloaded
+// from disk when either file changes. Reloading only affects new TLS
+// handshakes so established connections aren't dropped.
+type KeyPair struct {
+	certPath string
+	keyPath  string
+	lock     sync.RWMutex
+	cert     *tls.Certificate
+	done     chan struct{}
+}
+
+// NewKeyPair loads a certificate and key from PEM files
+func NewKeyPair(certPath, keyPath string) (*KeyPair, error) {
+	kp := &KeyPair{
+		certPath: certPath,
+		keyPath:  keyPath,
+	}
+	if err := kp.Reload(); err != nil {
+		return nil, err
+	}
+	return kp, nil
+}
+
+// Reload re-reads the certificate and key. The previous pair is kept
+// if the files can't be loaded, such as when only one of them has
+// been replaced so far.
+func (kp *KeyPair) Reload() error {
+	cert, err := loadKeyPair(kp.certPath, kp.keyPath)
+	if err != nil {
+		return err
+	}
+	kp.lock.Lock()
+	kp.cert = &cert
+	kp.lock.Unlock()
+	return nil
+}
+
+// loadKeyPair loads a key pair, explaining which file is at fault when
+// loading fails. Expired certificates are rejected.
+func loadKeyPair(certPath, keyPath string) (tls.Certificate, error) {
+	certPEM, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("Can't read certificate %s: %s", certPath, err)
+	}
+	keyPEM, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("Can't read private key %s: %s", keyPath, err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("Certificate %s and private key %s aren't a valid key pair: %s",
+			certPath, keyPath, err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("Can't parse certificate %s: %s", certPath, err)
+	}
+	if time.Now().After(leaf.NotAfter) {
+		return tls.Certificate{}, fmt.Errorf("Certificate %s expired at %s", certPath,
+			leaf.NotAfter.UTC().Format(time.RFC3339))
+	}
+	cert.Leaf = leaf
+	return cert, nil
+}
+
+// Certificate returns the most recently loaded certificate
+func (kp *KeyPair) Certificate() *tls.Certificate {
+	kp.lock.RLock()
+	defer kp.lock.RUnlock()
+	return kp.cert
+}
+
+// GetCertificate is suitable for use as tls.Config.GetCertificate
+func (kp *KeyPair) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return kp.Certificate(), nil
+}
+
+// GetClientCertificate is suitable for use as
+// tls.Config.GetClientCertificate
+func (kp *KeyPair) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return kp.Certificate(), nil
+}
+
+// Watch reloads the key pair whenever its files change until Stop is
+// called
+func (kp *KeyPair) Watch() error {
+	kp.done = make(chan struct{})
+	return watchFiles([]string{kp.certPath, kp.keyPath}, kp.changed, kp.done)
+}
+
+// Stop ends watching started by Watch
+func (kp *KeyPair) Stop() {
+	if kp.done != nil {
+		close(kp.done)
+		kp.done = nil
+	}
+}
+
+func (kp *KeyPair) changed() {
+	if err := kp.Reload(); err != nil {
+		log.Warnf("Failed to reload TLS certificate %s: %s. Keeping the previous certificate.", kp.certPath, err)
+		return
+	}
+	log.Infof("Reloaded TLS certificate %s.", kp.certPath)
+}
@@ -0,0 +1,138 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeKeyPair(t *testing.T, dir string, serial int64) {
+	writeKeyPairExpiring(t, dir, serial, time.Now().Add(time.Hour))
+}
+
+func writeKeyPairExpiring(t *testing.T, dir string, serial int64, notAfter time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "relay"},
+		NotBefore:    notAfter.Add(-2 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	// Replace the key first so the pair only changes once the
+	// certificate matches
+	if err := ioutil.WriteFile(filepath.Join(dir, "tls.key"), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "tls.crt"), certPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func serial(t *testing.T, kp *KeyPair) int64 {
+	cert, err := x509.ParseCertificate(kp.Certificate().Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.SerialNumber.Int64()
+}
+
+func TestKeyPairReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeKeyPair(t, dir, 1)
+	kp, err := NewKeyPair(filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serial(t, kp) != 1 {
+		t.Errorf("Unexpected certificate serial: %d", serial(t, kp))
+	}
+	ioutil.WriteFile(filepath.Join(dir, "tls.crt"), []byte("garbage"), 0644)
+	if err := kp.Reload(); err == nil {
+		t.Error("Expected reloading a bad certificate to fail")
+	}
+	if serial(t, kp) != 1 {
+		t.Error("Expected previous certificate to be kept")
+	}
+	writeKeyPair(t, dir, 2)
+	if err := kp.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if serial(t, kp) != 2 {
+		t.Errorf("Expected reloaded certificate: %d", serial(t, kp))
+	}
+}
+
+func TestKeyPairWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeKeyPair(t, dir, 1)
+	kp, err := NewKeyPair(filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kp.Watch(); err != nil {
+		t.Fatal(err)
+	}
+	defer kp.Stop()
+	writeKeyPair(t, dir, 2)
+	deadline := time.Now().Add(5 * time.Second)
+	for serial(t, kp) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for certificate to be reloaded")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestKeyPairLoadErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certPath := filepath.Join(dir, "tls.crt")
+	keyPath := filepath.Join(dir, "tls.key")
+	writeKeyPair(t, dir, 1)
+	if _, err := NewKeyPair(certPath, filepath.Join(dir, "missing.key")); err == nil ||
+		strings.HasPrefix(err.Error(), "Can't read private key") == false {
+		t.Errorf("Expected missing key error: %v", err)
+	}
+	ioutil.WriteFile(keyPath, []byte("garbage"), 0600)
+	if _, err := NewKeyPair(certPath, keyPath); err == nil || strings.Contains(err.Error(), "aren't a valid key pair") == false {
+		t.Errorf("Expected invalid key pair error: %v", err)
+	}
+	writeKeyPairExpiring(t, dir, 2, time.Now().Add(-time.Minute))
+	if _, err := NewKeyPair(certPath, keyPath); err == nil || strings.Contains(err.Error(), "expired") == false {
+		t.Errorf("Expected expired certificate error: %v", err)
+	}
+}
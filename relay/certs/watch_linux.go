@@ -0,0 +1,100 @@
+package certs
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"path/filepath"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Changes are coalesced for this long since certificate and key are
+// rarely replaced atomically together
+var settleDelay = time.Duration(500) * time.Millisecond
+
+const watchMask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_CREATE | syscall.IN_DELETE
+
+// watchFiles calls changed after any of paths is modified. The
+// directories containing the files are watched rather than the files
+// themselves so replacements via rename or symlink swap, as done by
+// cert-manager and Vault agent, are noticed.
+func watchFiles(paths []string, changed func(), done chan struct{}) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return err
+	}
+	watched := map[string]bool{}
+	for _, path := range paths {
+		dir := filepath.Dir(path)
+		if watched[dir] {
+			continue
+		}
+		if _, err := syscall.InotifyAddWatch(fd, dir, watchMask); err != nil {
+			syscall.Close(fd)
+			return err
+		}
+		watched[dir] = true
+	}
+	go func() {
+		defer syscall.Close(fd)
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		ticker := time.NewTicker(settleDelay)
+		defer ticker.Stop()
+		pending := false
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			for {
+				n, err := syscall.Read(fd, buf)
+				if err != nil || n < syscall.SizeofInotifyEvent {
+					if err != nil && err != syscall.EAGAIN {
+						log.Errorf("Error watching TLS certificates: %s.", err)
+					}
+					break
+				}
+				if eventsMatch(buf[:n], paths) {
+					pending = true
+				}
+			}
+			if pending {
+				pending = false
+				changed()
+			}
+		}
+	}()
+	return nil
+}
+
+// eventsMatch returns true if any inotify event in buf names one of
+// paths or a Kubernetes style ..data symlink
+func eventsMatch(buf []byte, paths []string) bool {
+	names := map[string]bool{
+		"..data": true,
+	}
+	for _, path := range paths {
+		names[filepath.Base(path)] = true
+	}
+	for offset := 0; offset+syscall.SizeofInotifyEvent <= len(buf); {
+		event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+		start := offset + syscall.SizeofInotifyEvent
+		end := start + int(event.Len)
+		if end > len(buf) {
+			break
+		}
+		name := string(buf[start:end])
+		for i, c := range name {
+			if c == 0 {
+				name = name[:i]
+				break
+			}
+		}
+		if names[name] {
+			return true
+		}
+		offset = end
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+//go:build !linux
+// +build !linux
+
+package certs
+
+import (
+	"os"
+	"time"
+)
+
+var pollInterval = time.Duration(10) * time.Second
+
+// watchFiles calls changed after any of paths is modified. Platforms
+// without inotify poll modification times.
+func watchFiles(paths []string, changed func(), done chan struct{}) error {
+	modified := func() map[string]time.Time {
+		times := map[string]time.Time{}
+		for _, path := range paths {
+			if info, err := os.Stat(path); err == nil {
+				times[path] = info.ModTime()
+			}
+		}
+		return times
+	}
+	last := modified()
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				current := modified()
+				for path, t := range current {
+					if last[path].Equal(t) == false {
+						changed()
+						break
+					}
+				}
+				last = current
+			}
+		}
+	}()
+	return nil
+}
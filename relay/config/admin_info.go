@@ -0,0 +1,63 @@
+package config
+
+import (
+	"errors"
+	"net"
+	"strings"
+)
+
+var errorIncompleteAdminTLS = errors.New("admin/tls_cert and admin/tls_key must be set together")
+var errorMissingAdminToken = errors.New("admin/token is required when admin/listen isn't a loopback address or unix socket")
+
+// AdminInfo configures Relay's local admin HTTP API
+type AdminInfo struct {
+	Enabled bool   `yaml:"enabled" env:"RELAY_ADMIN_ENABLED" valid:"bool" default:"false"`
+	Listen  string `yaml:"listen" env:"RELAY_ADMIN_LISTEN" valid:"-" default:"127.0.0.1:7780"`
+	TLSCert string `yaml:"tls_cert" env:"RELAY_ADMIN_TLS_CERT" valid:"-"`
+	TLSKey  string `yaml:"tls_key" env:"RELAY_ADMIN_TLS_KEY" valid:"-"`
+	Token   string `yaml:"token" env:"RELAY_ADMIN_TOKEN" valid:"-"`
+}
+
+// TLSEnabled returns true if the admin API is served over TLS
+func (ai *AdminInfo) TLSEnabled() bool {
+	return ai.TLSCert != "" && ai.TLSKey != ""
+}
+
+func (ai *AdminInfo) verifyTLS() error {
+	if (ai.TLSCert == "") != (ai.TLSKey == "") {
+		return errorIncompleteAdminTLS
+	}
+	return nil
+}
+
+// verifyToken ensures the admin API isn't reachable from other hosts
+// without authentication
+func (ai *AdminInfo) verifyToken() error {
+	if ai.Token != "" || ai.Network() == "unix" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(ai.Address())
+	if err != nil {
+		host = ai.Address()
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return errorMissingAdminToken
+}
+
+// Network returns the network type the admin API listens on
+func (ai *AdminInfo) Network() string {
+	if strings.HasPrefix(ai.Listen, "unix://") {
+		return "unix"
+	}
+	return "tcp"
+}
+
+// Address returns the address the admin API listens on
+func (ai *AdminInfo) Address() string {
+	return strings.TrimPrefix(ai.Listen, "unix://")
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// AttestationViolations returns the debugging and insecure features
+// which are enabled. Attested Relays refuse to start unless the list
+// is empty.
+func (c *Config) AttestationViolations() []string {
+	violations := []string{}
+	if c.DevMode == true {
+		violations = append(violations, "developer mode is enabled")
+	}
+	if c.LogLevel == "debug" {
+		violations = append(violations, "log_level is debug")
+	}
+	if c.Docker != nil && c.DockerEnabled() {
+		if c.Docker.TLSSkipVerify == true {
+			violations = append(violations, "docker/tls_skip_verify is enabled")
+		}
+	}
+	if c.Cog != nil && c.Cog.Token == "" {
+		violations = append(violations, "cog/token is unset, so attestations can't be signed")
+	}
+	for name, settings := range c.Bundles {
+		if settings != nil && settings.SeccompProfile == UnconfinedSeccompProfile {
+			violations = append(violations, fmt.Sprintf("bundle %s runs without seccomp", name))
+		}
+	}
+	return violations
+}
+
+func (c *Config) verifyAttestation() error {
+	if c.Attested == false {
+		return nil
+	}
+	if violations := c.AttestationViolations(); len(violations) > 0 {
+		return fmt.Errorf("Attested mode forbids: %s", strings.Join(violations, "; "))
+	}
+	return nil
+}
+
+// Hash returns the SHA-256 hash of the effective configuration.
+// Credentials are excluded so the hash can be shared freely.
+func (c *Config) Hash() (string, error) {
+	sanitized := c.Sanitized()
+	data, err := json.Marshal(&sanitized)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// SignHash signs a config hash using the Relay's Cog token as the
+// HMAC-SHA256 key so Cog can verify the hash came from this Relay
+func (c *Config) SignHash(hash string) string {
+	mac := hmac.New(sha256.New, []byte(c.Cog.Token))
+	mac.Write([]byte(hash))
+	return hex.EncodeToString(mac.Sum(nil))
+}
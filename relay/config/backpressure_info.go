@@ -0,0 +1,27 @@
+package config
+
+import (
+	"errors"
+)
+
+var errorBadBackpressureLevels = errors.New("backpressure/low_water must be at least 0 and below high_water, which must be at most 100")
+
+// BackpressureInfo configures the backpressure signals Relay sends Cog
+// when its work queue fills up. Levels are percentages of the queue's
+// capacity.
+type BackpressureInfo struct {
+	Enabled   bool `yaml:"enabled" env:"RELAY_BACKPRESSURE_ENABLED" valid:"bool" default:"false"`
+	HighWater int  `yaml:"high_water" env:"RELAY_BACKPRESSURE_HIGH_WATER" valid:"-" default:"80"`
+	LowWater  int  `yaml:"low_water" env:"RELAY_BACKPRESSURE_LOW_WATER" valid:"-" default:"50"`
+	Reject    bool `yaml:"reject" env:"RELAY_BACKPRESSURE_REJECT" valid:"bool" default:"false"`
+}
+
+func (bi *BackpressureInfo) verify() error {
+	if bi.Enabled == false {
+		return nil
+	}
+	if bi.LowWater < 0 || bi.LowWater >= bi.HighWater || bi.HighWater > 100 {
+		return errorBadBackpressureLevels
+	}
+	return nil
+}
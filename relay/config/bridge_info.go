@@ -0,0 +1,70 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var errorMissingBridgeHost = errors.New("bridge/host is required when bridging is enabled")
+var errorEmptyBridgeRules = errors.New("bridge/inbound and bridge/outbound can't both be empty")
+
+// BridgeInfo configures forwarding between Cog's message bus and a
+// second broker, such as one on an internal network Cog can't reach
+type BridgeInfo struct {
+	Enabled     bool   `yaml:"enabled" env:"RELAY_BRIDGE_ENABLED" valid:"bool" default:"false"`
+	Host        string `yaml:"host" env:"RELAY_BRIDGE_HOST" valid:"-"`
+	Port        int    `yaml:"port" env:"RELAY_BRIDGE_PORT" valid:"-" default:"1883"`
+	Username    string `yaml:"username" env:"RELAY_BRIDGE_USERNAME" valid:"-"`
+	Password    string `yaml:"password" env:"RELAY_BRIDGE_PASSWORD" valid:"-"`
+	SSLEnabled  bool   `yaml:"enable_ssl" env:"RELAY_BRIDGE_ENABLE_SSL" valid:"bool" default:"false"`
+	SSLCertPath string `yaml:"ssl_cert_path" env:"RELAY_BRIDGE_SSL_CERT_PATH" valid:"-"`
+	Inbound     string `yaml:"inbound" env:"RELAY_BRIDGE_INBOUND" valid:"-" default:"/bot/commands/#,bot/relays/+/directives,bot/relays/+/announcer,bot/relays/+/dynconfigs"`
+	Outbound    string `yaml:"outbound" env:"RELAY_BRIDGE_OUTBOUND" valid:"-" default:"/bot/pipelines/#,bot/relays/discover,bot/relays/info,bot/relays/+/presence"`
+	Deny        string `yaml:"deny" env:"RELAY_BRIDGE_DENY" valid:"-"`
+}
+
+// InboundTopics returns the topic filters forwarded from Cog's bus
+func (bi *BridgeInfo) InboundTopics() []string {
+	return splitTopicFilters(bi.Inbound)
+}
+
+// OutboundTopics returns the topic filters forwarded to Cog's bus
+func (bi *BridgeInfo) OutboundTopics() []string {
+	return splitTopicFilters(bi.Outbound)
+}
+
+// DeniedTopics returns the topic filters never forwarded
+func (bi *BridgeInfo) DeniedTopics() []string {
+	return splitTopicFilters(bi.Deny)
+}
+
+func (bi *BridgeInfo) verify() error {
+	if bi.Enabled == false {
+		return nil
+	}
+	if bi.Host == "" {
+		return errorMissingBridgeHost
+	}
+	if len(bi.InboundTopics()) == 0 && len(bi.OutboundTopics()) == 0 {
+		return errorEmptyBridgeRules
+	}
+	filters := append(bi.InboundTopics(), bi.OutboundTopics()...)
+	for _, filter := range append(filters, bi.DeniedTopics()...) {
+		if i := strings.Index(filter, "#"); i > -1 && (i != len(filter)-1 || (i > 0 && filter[i-1] != '/')) {
+			return fmt.Errorf("Illegal bridge topic filter %s: # must be the last topic level", filter)
+		}
+	}
+	return nil
+}
+
+func splitTopicFilters(filters string) []string {
+	parsed := []string{}
+	for _, filter := range strings.Split(filters, ",") {
+		filter = strings.TrimSpace(filter)
+		if filter != "" {
+			parsed = append(parsed, filter)
+		}
+	}
+	return parsed
+}
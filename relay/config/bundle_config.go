@@ -15,7 +15,12 @@ type Bundle struct {
 	Docker        *DockerImage               `json:"docker" valid:"-"`
 	Commands      map[string]*BundleCommand  `json:"commands" valid:"-"`
 	Templates     map[string]*BundleTemplate `json:"templates" valid:"-"`
+	Preflight     *PreflightChecks           `json:"preflight" valid:"-"`
+	PostProcessor string                     `json:"post_processor" valid:"-"`
+	Requires      []string                   `json:"requires" valid:"-"`
 	available     bool
+	pullFailures  int
+	lastPullError string
 }
 
 // DockerImage identifies the bundle's image name and version
@@ -27,11 +32,29 @@ type DockerImage struct {
 
 // BundleCommand identifies a command within a bundle
 type BundleCommand struct {
-	Name       string
-	Executable string                          `json:"executable" valid:"required"`
-	Options    map[string]*BundleCommandOption `json:"options"`
-	Rules      []string                        `json:"rules"`
-	EnvVars    map[string]string               `json:"env_vars"`
+	Name          string
+	Executable    string                          `json:"executable" valid:"required"`
+	Options       map[string]*BundleCommandOption `json:"options"`
+	Rules         []string                        `json:"rules"`
+	EnvVars       map[string]string               `json:"env_vars"`
+	ReadOnly      bool                            `json:"read_only"`
+	Preflight     *PreflightChecks                `json:"preflight"`
+	PostProcessor string                          `json:"post_processor"`
+}
+
+// PreflightChecks describe conditions Relay verifies before
+// executing a command
+type PreflightChecks struct {
+	Env       []string         `json:"env"`
+	Reachable []string         `json:"reachable"`
+	DiskSpace []DiskSpaceCheck `json:"disk_space"`
+}
+
+// DiskSpaceCheck requires at least MinMB megabytes to be free on
+// the filesystem containing Path
+type DiskSpaceCheck struct {
+	Path  string `json:"path"`
+	MinMB int64  `json:"min_mb"`
 }
 
 // BundleCommandOption is a description of a command's option
@@ -62,9 +85,27 @@ func (b *Bundle) IsAvailable() bool {
 	return b.available
 }
 
-// SetAvailable sets the availability flag
+// SetAvailable sets the availability flag. Marking a bundle
+// available clears its pull failure history.
 func (b *Bundle) SetAvailable(flag bool) {
 	b.available = flag
+	if flag == true {
+		b.pullFailures = 0
+		b.lastPullError = ""
+	}
+}
+
+// RecordPullFailure notes a failed attempt to fetch the bundle's assets
+func (b *Bundle) RecordPullFailure(err error) {
+	b.available = false
+	b.pullFailures++
+	b.lastPullError = fmt.Sprintf("%s", err)
+}
+
+// PullFailures returns the number of consecutive failed asset pulls
+// and the most recent error
+func (b *Bundle) PullFailures() (int, string) {
+	return b.pullFailures, b.lastPullError
 }
 
 // NeedsRefresh returns true if Relay needs to refresh
@@ -76,6 +117,58 @@ func (b *Bundle) NeedsRefresh() bool {
 	return !b.available
 }
 
+// MissingLabels returns the labels required by the bundle which
+// aren't in the Relay's label set
+func (b *Bundle) MissingLabels(labels []string) []string {
+	missing := []string{}
+	for _, required := range b.Requires {
+		found := false
+		for _, label := range labels {
+			if label == required {
+				found = true
+				break
+			}
+		}
+		if found == false {
+			missing = append(missing, required)
+		}
+	}
+	return missing
+}
+
+// PreflightFor returns the combined bundle- and command-level
+// pre-flight checks for the named command. Returns nil if
+// no checks are declared.
+func (b *Bundle) PreflightFor(commandName string) *PreflightChecks {
+	var checks []*PreflightChecks
+	if b.Preflight != nil {
+		checks = append(checks, b.Preflight)
+	}
+	if command := b.Commands[commandName]; command != nil && command.Preflight != nil {
+		checks = append(checks, command.Preflight)
+	}
+	if len(checks) == 0 {
+		return nil
+	}
+	retval := &PreflightChecks{}
+	for _, c := range checks {
+		retval.Env = append(retval.Env, c.Env...)
+		retval.Reachable = append(retval.Reachable, c.Reachable...)
+		retval.DiskSpace = append(retval.DiskSpace, c.DiskSpace...)
+	}
+	return retval
+}
+
+// PostProcessorFor returns the executable which post-processes the
+// named command's output. Command-level post-processors override the
+// bundle's. Returns an empty string if none is declared.
+func (b *Bundle) PostProcessorFor(commandName string) string {
+	if command := b.Commands[commandName]; command != nil && command.PostProcessor != "" {
+		return command.PostProcessor
+	}
+	return b.PostProcessor
+}
+
 // PrettyImageName returns a prettified version of a Docker image
 // include repository, name, and tag
 func (di *DockerImage) PrettyImageName() string {
@@ -191,3 +191,30 @@ func checkNames(config *Bundle, t *testing.T) {
 	}
 
 }
+
+func TestMissingLabels(t *testing.T) {
+	bundle := &Bundle{
+		Requires: []string{"gpu", "pci-zone"},
+	}
+	missing := bundle.MissingLabels([]string{"gpu", "prod-network"})
+	if len(missing) != 1 || missing[0] != "pci-zone" {
+		t.Errorf("Expected pci-zone to be missing: %v", missing)
+	}
+	if missing := bundle.MissingLabels([]string{"pci-zone", "gpu"}); len(missing) != 0 {
+		t.Errorf("Expected no missing labels: %v", missing)
+	}
+}
+
+func TestPullFailures(t *testing.T) {
+	bundle := &Bundle{}
+	bundle.RecordPullFailure(fmt.Errorf("registry timeout"))
+	bundle.RecordPullFailure(fmt.Errorf("registry unavailable"))
+	failures, lastError := bundle.PullFailures()
+	if failures != 2 || lastError != "registry unavailable" {
+		t.Errorf("Unexpected pull failures: %d %s", failures, lastError)
+	}
+	bundle.SetAvailable(true)
+	if failures, _ := bundle.PullFailures(); failures != 0 {
+		t.Errorf("Expected pull failures to be cleared: %d", failures)
+	}
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ulimitNames are the resource limits Docker can set on containers
+var ulimitNames = []string{"core", "cpu", "data", "fsize", "locks", "memlock", "msgqueue", "nice",
+	"nofile", "nproc", "rss", "rtprio", "rttime", "sigpending", "stack"}
+
+// namespacedSysctls are the sysctl prefixes Docker allows containers
+// to set without sharing the host's namespaces
+var namespacedSysctls = []string{"kernel.msgmax", "kernel.msgmnb", "kernel.msgmni", "kernel.sem",
+	"kernel.shmall", "kernel.shmmax", "kernel.shmmni", "kernel.shm_rmid_forced", "fs.mqueue.", "net."}
+
+// Ulimit is a resource limit applied to a bundle's containers
+type Ulimit struct {
+	Name string
+	Soft int64
+	Hard int64
+}
+
+// UlimitSpecs parses the bundle's ulimits, sorted by name. Limits use
+// the soft[:hard] format of "docker run --ulimit". The hard limit
+// defaults to the soft limit.
+func (bs BundleSettings) UlimitSpecs() ([]Ulimit, error) {
+	names := []string{}
+	for name := range bs.Ulimits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ulimits := []Ulimit{}
+	for _, name := range names {
+		ulimit, err := ParseUlimit(name, bs.Ulimits[name])
+		if err != nil {
+			return nil, err
+		}
+		ulimits = append(ulimits, ulimit)
+	}
+	return ulimits, nil
+}
+
+// ParseUlimit parses a soft[:hard] limit for the named resource
+func ParseUlimit(name string, value string) (Ulimit, error) {
+	if isKnownUlimit(name) == false {
+		return Ulimit{}, fmt.Errorf("Unknown ulimit %s", name)
+	}
+	parts := strings.Split(value, ":")
+	if len(parts) > 2 {
+		return Ulimit{}, fmt.Errorf("Illegal ulimit specification for %s: %s", name, value)
+	}
+	soft, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return Ulimit{}, fmt.Errorf("Illegal ulimit specification for %s: %s", name, value)
+	}
+	hard := soft
+	if len(parts) == 2 {
+		if hard, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+			return Ulimit{}, fmt.Errorf("Illegal ulimit specification for %s: %s", name, value)
+		}
+	}
+	if soft > hard {
+		return Ulimit{}, fmt.Errorf("Soft ulimit for %s exceeds its hard limit: %s", name, value)
+	}
+	return Ulimit{
+		Name: name,
+		Soft: soft,
+		Hard: hard,
+	}, nil
+}
+
+func isKnownUlimit(name string) bool {
+	for _, v := range ulimitNames {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+// verifySysctls rejects sysctls which would affect the Docker host
+// rather than just the container
+func (bs BundleSettings) verifySysctls() error {
+	for name := range bs.Sysctls {
+		namespaced := false
+		for _, prefix := range namespacedSysctls {
+			if name == prefix || (strings.HasSuffix(prefix, ".") && strings.HasPrefix(name, prefix)) {
+				namespaced = true
+				break
+			}
+		}
+		if namespaced == false {
+			return fmt.Errorf("Sysctl %s isn't namespaced and can't be set on containers", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// DefaultScheduleTopic is the topic scheduled results are published to
+// unless a schedule names another. Cog delivers results published
+// there to the schedule's chat room.
+const DefaultScheduleTopic = "bot/relays/scheduled_results"
+
+// Schedule runs one of a bundle's commands periodically and sends its
+// output to a chat room
+type Schedule struct {
+	Name    string                 `yaml:"name" valid:"-"`
+	Command string                 `yaml:"command" valid:"-"`
+	Args    []interface{}          `yaml:"args" valid:"-"`
+	Options map[string]interface{} `yaml:"options" valid:"-"`
+	Every   string                 `yaml:"every" valid:"-"`
+	At      string                 `yaml:"at" valid:"-"`
+	Room    string                 `yaml:"room" valid:"-"`
+	Topic   string                 `yaml:"topic" valid:"-"`
+}
+
+// EveryDuration returns Every as a time.Duration
+func (s Schedule) EveryDuration() time.Duration {
+	duration, err := time.ParseDuration(s.Every)
+	if err != nil {
+		panic(fmt.Errorf("Error parsing schedule interval %s", s.Every))
+	}
+	return duration
+}
+
+// ResultTopic returns the topic the schedule's results are published to
+func (s Schedule) ResultTopic() string {
+	if s.Topic != "" {
+		return s.Topic
+	}
+	return DefaultScheduleTopic
+}
+
+// Next returns the first time after now the schedule should run.
+// Schedules with an "at" time of day run at that time (UTC) and every
+// interval after it. Other schedules run one interval from now.
+func (s Schedule) Next(now time.Time) time.Time {
+	every := s.EveryDuration()
+	if s.At == "" {
+		return now.Add(every)
+	}
+	at, _ := time.Parse("15:04", s.At)
+	now = now.UTC()
+	next := time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, time.UTC)
+	if next.After(now) {
+		// Step back to the most recent run before now
+		for next.Add(-every).After(now) {
+			next = next.Add(-every)
+		}
+		return next
+	}
+	for next.After(now) == false {
+		next = next.Add(every)
+	}
+	return next
+}
+
+func (s Schedule) verify() error {
+	if s.Command == "" {
+		return fmt.Errorf("Schedule %s is missing a command", s.Name)
+	}
+	if s.Room == "" {
+		return fmt.Errorf("Schedule %s is missing a room", s.Name)
+	}
+	every, err := time.ParseDuration(s.Every)
+	if err != nil || every < time.Minute {
+		return fmt.Errorf("Schedule %s interval must be at least 1m: %s", s.Name, s.Every)
+	}
+	// Nested YAML maps can't be sent to commands
+	if _, err := json.Marshal(s.Args); err != nil {
+		return fmt.Errorf("Schedule %s has illegal arguments: %s", s.Name, err)
+	}
+	if _, err := json.Marshal(s.Options); err != nil {
+		return fmt.Errorf("Schedule %s has illegal options: %s", s.Name, err)
+	}
+	if s.At != "" {
+		if _, err := time.Parse("15:04", s.At); err != nil {
+			return fmt.Errorf("Schedule %s has an illegal time of day (expected HH:MM): %s", s.Name, s.At)
+		}
+	}
+	return nil
+}
+
+// verifySchedules names unnamed schedules after their command and
+// checks every schedule is runnable
+func (bs *BundleSettings) verifySchedules(bundleName string) error {
+	for i := range bs.Schedules {
+		schedule := &bs.Schedules[i]
+		if schedule.Name == "" {
+			schedule.Name = fmt.Sprintf("%s:%s", bundleName, schedule.Command)
+		}
+		if err := schedule.verify(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Log levels accepted by bundles/<name>/log/level, from most to
+// least verbose
+var logLevels = []string{"debug", "info", "warn", "error"}
+
+// UnconfinedSeccompProfile disables seccomp filtering for a bundle's containers
+const UnconfinedSeccompProfile = "unconfined"
+
+// NvidiaRuntime is the container runtime used for bundles requesting GPUs
+const NvidiaRuntime = "nvidia"
+
+// Priority classes accepted by bundles/<name>/priority
+const (
+	// InteractivePriority commands are run ahead of background ones
+	InteractivePriority = "interactive"
+	// BackgroundPriority commands only run when no interactive
+	// command is waiting
+	BackgroundPriority = "background"
+)
+
+// BundleSettings contains Relay-side settings applied to a
+// single bundle's commands
+type BundleSettings struct {
+	SeccompProfile  string            `yaml:"seccomp_profile" valid:"-"`
+	AppArmorProfile string            `yaml:"apparmor_profile" valid:"-"`
+	Log             LogLimits         `yaml:"log" valid:"-"`
+	Runtime         string            `yaml:"runtime" valid:"-"`
+	GPUs            string            `yaml:"gpus" valid:"-"`
+	Devices         []string          `yaml:"devices" valid:"-"`
+	Entrypoint      []string          `yaml:"entrypoint" valid:"-"`
+	WorkingDir      string            `yaml:"working_dir" valid:"-"`
+	Executables     map[string]string `yaml:"executables" valid:"-"`
+	NativeRuntimes  []string          `yaml:"native_runtimes" valid:"-"`
+	Timeout         string            `yaml:"timeout" valid:"-"`
+	Sysctls         map[string]string `yaml:"sysctls" valid:"-"`
+	Ulimits         map[string]string `yaml:"ulimits" valid:"-"`
+	Schedules       []Schedule        `yaml:"schedules" valid:"-"`
+	Singleton       bool              `yaml:"singleton" valid:"-"`
+	Priority        string            `yaml:"priority" valid:"-"`
+	MaxConcurrent   int               `yaml:"max_concurrent" valid:"-"`
+}
+
+// IsBackground returns true if the bundle's commands are queued
+// behind interactive commands
+func (bs BundleSettings) IsBackground() bool {
+	return bs.Priority == BackgroundPriority
+}
+
+// ExecutableFor returns the executable run for the named command.
+// Overrides in executables take precedence over the bundle config.
+func (bs BundleSettings) ExecutableFor(commandName, executable string) string {
+	if override := bs.Executables[commandName]; override != "" {
+		return override
+	}
+	return executable
+}
+
+// DeviceSpec describes a host device exposed to a bundle's containers.
+// Specs use the same host[:container[:permissions]] format as
+// "docker run --device".
+type DeviceSpec struct {
+	HostPath      string
+	ContainerPath string
+	Permissions   string
+}
+
+// ContainerRuntime returns the runtime used for the bundle's
+// containers. Bundles requesting GPUs default to the NVIDIA runtime.
+func (bs BundleSettings) ContainerRuntime() string {
+	if bs.Runtime == "" && bs.GPUs != "" {
+		return NvidiaRuntime
+	}
+	return bs.Runtime
+}
+
+// DeviceSpecs parses the bundle's device list
+func (bs BundleSettings) DeviceSpecs() ([]DeviceSpec, error) {
+	specs := []DeviceSpec{}
+	for _, device := range bs.Devices {
+		spec, err := ParseDeviceSpec(device)
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// ParseDeviceSpec parses a host[:container[:permissions]] device spec
+func ParseDeviceSpec(device string) (DeviceSpec, error) {
+	parts := strings.Split(device, ":")
+	if len(parts) > 3 || parts[0] == "" {
+		return DeviceSpec{}, fmt.Errorf("Illegal device specification: %s", device)
+	}
+	spec := DeviceSpec{
+		HostPath:      parts[0],
+		ContainerPath: parts[0],
+		Permissions:   "rwm",
+	}
+	if len(parts) > 1 && parts[1] != "" {
+		spec.ContainerPath = parts[1]
+	}
+	if len(parts) > 2 {
+		spec.Permissions = parts[2]
+	}
+	if filepath.IsAbs(spec.HostPath) == false || filepath.IsAbs(spec.ContainerPath) == false {
+		return DeviceSpec{}, fmt.Errorf("Device paths must be absolute: %s", device)
+	}
+	if spec.Permissions == "" || strings.Trim(spec.Permissions, "rwm") != "" {
+		return DeviceSpec{}, fmt.Errorf("Illegal device permissions: %s", device)
+	}
+	return spec, nil
+}
+
+// LogLimits restrict how much of a command's COGCMD_* log output
+// is written to Relay's log. Zero values disable the limit.
+type LogLimits struct {
+	MaxLineLength int    `yaml:"max_line_length" valid:"-"`
+	MaxLines      int    `yaml:"max_lines" valid:"-"`
+	Level         string `yaml:"level" valid:"-"`
+}
+
+// LevelRank returns the position of level in the list of log levels
+// ordered by increasing severity. Unset levels rank lowest.
+func (ll LogLimits) LevelRank(level string) int {
+	for i, v := range logLevels {
+		if v == level {
+			return i
+		}
+	}
+	return 0
+}
+
+// SettingsForBundle returns the settings configured for the named
+// bundle. Bundles without settings receive the zero value.
+func (c *Config) SettingsForBundle(name string) BundleSettings {
+	if settings := c.Bundles[name]; settings != nil {
+		return *settings
+	}
+	return BundleSettings{}
+}
+
+func (c *Config) verifyBundleSettings() error {
+	for name, settings := range c.Bundles {
+		if settings == nil {
+			continue
+		}
+		if level := settings.Log.Level; level != "" && isValidLogLevel(level) == false {
+			return fmt.Errorf("Bundle %s has unknown log level %s", name, level)
+		}
+		if _, err := settings.DeviceSpecs(); err != nil {
+			return fmt.Errorf("Bundle %s: %s", name, err)
+		}
+		if _, err := settings.UlimitSpecs(); err != nil {
+			return fmt.Errorf("Bundle %s: %s", name, err)
+		}
+		if err := settings.verifySysctls(); err != nil {
+			return fmt.Errorf("Bundle %s: %s", name, err)
+		}
+		if err := settings.verifySchedules(name); err != nil {
+			return fmt.Errorf("Bundle %s: %s", name, err)
+		}
+		if p := settings.Priority; p != "" && p != InteractivePriority && p != BackgroundPriority {
+			return fmt.Errorf("Bundle %s has unknown priority %s", name, p)
+		}
+		if settings.MaxConcurrent < 0 {
+			return fmt.Errorf("Bundle %s max_concurrent must not be negative", name)
+		}
+		if settings.Singleton == true && (c.Group == nil || c.Group.Enabled() == false) {
+			return fmt.Errorf("Bundle %s is a singleton but group/id isn't set", name)
+		}
+		if settings.WorkingDir != "" && filepath.IsAbs(settings.WorkingDir) == false {
+			return fmt.Errorf("Bundle %s working directory must be absolute: %s", name, settings.WorkingDir)
+		}
+		for command, executable := range settings.Executables {
+			if filepath.IsAbs(executable) == false {
+				return fmt.Errorf("Bundle %s executable for command %s must be absolute: %s", name, command, executable)
+			}
+		}
+	}
+	if c.DockerEnabled() == false || c.Docker == nil {
+		return nil
+	}
+	for name, settings := range c.Bundles {
+		if settings == nil {
+			continue
+		}
+		profile := settings.SeccompProfile
+		if profile == "" || profile == UnconfinedSeccompProfile {
+			continue
+		}
+		if _, ok := c.Docker.SeccompProfiles[profile]; !ok {
+			return fmt.Errorf("Bundle %s references unknown seccomp profile %s", name, profile)
+		}
+	}
+	for name, path := range c.Docker.SeccompProfiles {
+		if _, err := loadSeccompProfile(path); err != nil {
+			return fmt.Errorf("Error loading seccomp profile %s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+func isValidLogLevel(level string) bool {
+	for _, v := range logLevels {
+		if v == level {
+			return true
+		}
+	}
+	return false
+}
+
+// SeccompProfile returns the compacted JSON contents of the named
+// seccomp profile, suitable for passing to the Docker daemon
+func (di *DockerInfo) SeccompProfile(name string) (string, error) {
+	path, ok := di.SeccompProfiles[name]
+	if !ok {
+		return "", fmt.Errorf("Unknown seccomp profile %s", name)
+	}
+	return loadSeccompProfile(path)
+}
+
+func loadSeccompProfile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var profile map[string]interface{}
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return "", err
+	}
+	compacted, err := json.Marshal(profile)
+	if err != nil {
+		return "", err
+	}
+	return string(compacted), nil
+}
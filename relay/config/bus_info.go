@@ -0,0 +1,19 @@
+package config
+
+import (
+	"errors"
+)
+
+var errorMissingBusType = errors.New("bus/type can't be empty")
+
+// BusInfo selects the message bus backend used to talk to Cog
+type BusInfo struct {
+	Type string `yaml:"type" env:"RELAY_BUS_TYPE" valid:"-" default:"mqtt"`
+}
+
+func (bi *BusInfo) verify() error {
+	if bi.Type == "" {
+		return errorMissingBusType
+	}
+	return nil
+}
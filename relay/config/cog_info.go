@@ -1,21 +1,394 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errorIncompleteCogClientCert = errors.New("cog/ssl_client_cert and cog/ssl_client_key must be set together")
+var errorCogClientCertWithoutSSL = errors.New("cog/ssl_client_cert requires cog/enable_ssl")
+var errorMissingCogToken = errors.New("cog/token is required unless Relay authenticates with cog/ssl_client_cert")
+var errorCogPinsWithoutSSL = errors.New("cog/ssl_pins requires cog/enable_ssl")
+var errorBadCogTransport = errors.New("cog/transport must be mqtt or websocket")
+var errorBadWebSocketPath = errors.New("cog/websocket_path must start with /")
+var errorBadMaxBackoff = errors.New("cog/reconnect_max_interval must be a duration of at least 1s")
+var errorBadMaxReconnects = errors.New("cog/reconnect_max_attempts can't be negative")
+var errorBadReconnectFlapLimit = errors.New("cog/reconnect_flap_limit must be 0 (disabled) or at least 2")
+var errorBadReconnectFlapWindow = errors.New("Error parsing cog/reconnect_flap_window")
+var errorBadReconnectFlapCooldown = errors.New("Error parsing cog/reconnect_flap_cooldown")
+var errorBadReannounceInterval = errors.New("Error parsing cog/reannounce_interval")
+var errorBadCompression = errors.New("cog/compression must be none or gzip")
+var errorBadCompressionThreshold = errors.New("cog/compression_threshold can't be negative")
+var errorBadPayloadKey = errors.New("cog/payload_key must be a base64 encoded 32 byte key")
+var errorPayloadKeyIDWithoutKey = errors.New("cog/payload_key_id requires cog/payload_key")
+var errorBadMaxInFlight = errors.New("cog/max_inflight can't be negative")
+var errorBadTopicPrefix = errors.New("cog/topic_prefix can't contain wildcards or start or end with /")
+var errorBadMessageEncoding = errors.New("cog/message_encoding must be json or protobuf")
+var errorBadHandshakeTimeout = errors.New("Error parsing cog/handshake_timeout")
+
+// Transports Relay can use to reach Cog's message bus
+const (
+	MQTTTransport      = "mqtt"
+	WebSocketTransport = "websocket"
 )
 
 // CogInfo contains information required to connect to an upstream Cog host
 type CogInfo struct {
 	Host            string `yaml:"host" env:"RELAY_COG_HOST" valid:"hostorip,required" default:"127.0.0.1"`
 	Port            int    `yaml:"port" env:"RELAY_COG_PORT" valid:"int64,required" default:"1883"`
-	Token           string `yaml:"token" env:"RELAY_COG_TOKEN" valid:"required"`
+	Token           string `yaml:"token" env:"RELAY_COG_TOKEN" valid:"-"`
 	SSLEnabled      bool   `yaml:"enable_ssl" env:"RELAY_COG_ENABLE_SSL" valid:"bool" default:"false"`
 	SSLCertPath     string `yaml:"ssl_cert_path" env:"RELAY_COG_SSL_CERT_PATH" valid:"-"`
+	SSLPins         string `yaml:"ssl_pins" env:"RELAY_COG_SSL_PINS" valid:"-"`
+	SSLClientCert   string `yaml:"ssl_client_cert" env:"RELAY_COG_SSL_CLIENT_CERT" valid:"-"`
+	SSLClientKey    string `yaml:"ssl_client_key" env:"RELAY_COG_SSL_CLIENT_KEY" valid:"-"`
 	RefreshInterval string `yaml:"refresh_interval" env:"RELAY_COG_REFRESH_INTERVAL" valid:"required" default:"1m"`
+	Transport       string `yaml:"transport" env:"RELAY_COG_TRANSPORT" valid:"-" default:"mqtt"`
+	WebSocketPath   string `yaml:"websocket_path" env:"RELAY_COG_WEBSOCKET_PATH" valid:"-" default:"/mqtt"`
+	// QoS levels are strings so an explicit 0 isn't replaced by the default
+	CommandQoS      string `yaml:"command_qos" env:"RELAY_COG_COMMAND_QOS" valid:"-" default:"1"`
+	ResponseQoS     string `yaml:"response_qos" env:"RELAY_COG_RESPONSE_QOS" valid:"-" default:"1"`
+	AnnouncementQoS string `yaml:"announcement_qos" env:"RELAY_COG_ANNOUNCEMENT_QOS" valid:"-" default:"1"`
+	PersistSession  bool   `yaml:"persistent_session" env:"RELAY_COG_PERSISTENT_SESSION" valid:"bool" default:"false"`
+	RetainAnnounce  bool   `yaml:"retain_announcements" env:"RELAY_COG_RETAIN_ANNOUNCEMENTS" valid:"bool" default:"false"`
+	Reannounce      string `yaml:"reannounce_interval" env:"RELAY_COG_REANNOUNCE_INTERVAL" valid:"-" default:"0s"`
+	MaxBackoff      string `yaml:"reconnect_max_interval" env:"RELAY_COG_RECONNECT_MAX_INTERVAL" valid:"-" default:"90s"`
+	MaxReconnects   int    `yaml:"reconnect_max_attempts" env:"RELAY_COG_RECONNECT_MAX_ATTEMPTS" valid:"int64" default:"0"`
+	FlapLimit       int    `yaml:"reconnect_flap_limit" env:"RELAY_COG_RECONNECT_FLAP_LIMIT" valid:"int64" default:"5"`
+	FlapWindow      string `yaml:"reconnect_flap_window" env:"RELAY_COG_RECONNECT_FLAP_WINDOW" valid:"-" default:"5m"`
+	FlapCooldown    string `yaml:"reconnect_flap_cooldown" env:"RELAY_COG_RECONNECT_FLAP_COOLDOWN" valid:"-" default:"5m"`
+	Compression     string `yaml:"compression" env:"RELAY_COG_COMPRESSION" valid:"-" default:"none"`
+	CompressAbove   int    `yaml:"compression_threshold" env:"RELAY_COG_COMPRESSION_THRESHOLD" valid:"int64" default:"65536"`
+	PayloadKey      string `yaml:"payload_key" env:"RELAY_COG_PAYLOAD_KEY" valid:"-"`
+	PayloadKeyID    string `yaml:"payload_key_id" env:"RELAY_COG_PAYLOAD_KEY_ID" valid:"-"`
+	Presence        bool   `yaml:"presence" env:"RELAY_COG_PRESENCE" valid:"bool" default:"false"`
+	KeepAlive       string `yaml:"keepalive_interval" env:"RELAY_COG_KEEPALIVE_INTERVAL" valid:"-" default:"60s"`
+	PingTimeout     string `yaml:"ping_timeout" env:"RELAY_COG_PING_TIMEOUT" valid:"-" default:"15s"`
+	ConnectTimeout  string `yaml:"connect_timeout" env:"RELAY_COG_CONNECT_TIMEOUT" valid:"-" default:"30s"`
+	WriteTimeout    string `yaml:"write_timeout" env:"RELAY_COG_WRITE_TIMEOUT" valid:"-" default:"0s"`
+	MaxInFlight     int    `yaml:"max_inflight" env:"RELAY_COG_MAX_INFLIGHT" valid:"int64" default:"0"`
+	TopicPrefix     string `yaml:"topic_prefix" env:"RELAY_COG_TOPIC_PREFIX" valid:"-"`
+	Encoding        string `yaml:"message_encoding" env:"RELAY_COG_MESSAGE_ENCODING" valid:"-" default:"json"`
+	Handshake       string `yaml:"handshake_timeout" env:"RELAY_COG_HANDSHAKE_TIMEOUT" valid:"-" default:"5s"`
+}
+
+// HandshakeDuration returns how long Relay waits for Cog to answer the
+// protocol handshake. Zero disables the handshake.
+func (ci *CogInfo) HandshakeDuration() time.Duration {
+	duration, err := time.ParseDuration(ci.Handshake)
+	if err != nil {
+		panic(errorBadHandshakeTimeout)
+	}
+	return duration
+}
+
+func (ci *CogInfo) verifyHandshake() error {
+	if duration, err := time.ParseDuration(ci.Handshake); err != nil || duration < 0 {
+		return errorBadHandshakeTimeout
+	}
+	return nil
+}
+
+func (ci *CogInfo) verifyEncoding() error {
+	if ci.Encoding != "json" && ci.Encoding != "protobuf" {
+		return errorBadMessageEncoding
+	}
+	return nil
+}
+
+func (ci *CogInfo) verifyTopicPrefix() error {
+	if ci.TopicPrefix == "" {
+		return nil
+	}
+	if strings.ContainsAny(ci.TopicPrefix, "+#") || strings.HasPrefix(ci.TopicPrefix, "/") ||
+		strings.HasSuffix(ci.TopicPrefix, "/") {
+		return errorBadTopicPrefix
+	}
+	return nil
+}
+
+// KeepAliveDuration returns how often Relay pings Cog's broker when
+// the connection is otherwise idle
+func (ci *CogInfo) KeepAliveDuration() time.Duration {
+	duration, err := time.ParseDuration(ci.KeepAlive)
+	if err != nil {
+		panic(err)
+	}
+	return duration
+}
+
+// PingTimeoutDuration returns how long Relay waits for the broker to
+// answer a ping before treating the connection as lost
+func (ci *CogInfo) PingTimeoutDuration() time.Duration {
+	duration, err := time.ParseDuration(ci.PingTimeout)
+	if err != nil {
+		panic(err)
+	}
+	return duration
+}
+
+// ConnectTimeoutDuration returns how long Relay waits for each
+// connection attempt to Cog's broker
+func (ci *CogInfo) ConnectTimeoutDuration() time.Duration {
+	duration, err := time.ParseDuration(ci.ConnectTimeout)
+	if err != nil {
+		panic(err)
+	}
+	return duration
+}
+
+// WriteTimeoutDuration returns how long a publish may block writing
+// to the connection. Zero waits forever.
+func (ci *CogInfo) WriteTimeoutDuration() time.Duration {
+	duration, err := time.ParseDuration(ci.WriteTimeout)
+	if err != nil {
+		panic(err)
+	}
+	return duration
+}
+
+func (ci *CogInfo) verifyConnectionTuning() error {
+	positive := map[string]string{
+		"keepalive_interval": ci.KeepAlive,
+		"ping_timeout":       ci.PingTimeout,
+		"connect_timeout":    ci.ConnectTimeout,
+	}
+	for name, value := range positive {
+		if duration, err := time.ParseDuration(value); err != nil || duration < time.Second {
+			return fmt.Errorf("cog/%s must be a duration of at least 1s: %s", name, value)
+		}
+	}
+	if duration, err := time.ParseDuration(ci.WriteTimeout); err != nil || duration < 0 {
+		return fmt.Errorf("cog/write_timeout must be a duration of 0s or more: %s", ci.WriteTimeout)
+	}
+	if ci.MaxInFlight < 0 {
+		return errorBadMaxInFlight
+	}
+	return nil
+}
+
+// PayloadKeyBytes returns the decoded key used to encrypt response
+// bodies and decrypt requests, or nil if payload encryption is
+// disabled
+func (ci *CogInfo) PayloadKeyBytes() []byte {
+	if ci.PayloadKey == "" {
+		return nil
+	}
+	key, err := base64.StdEncoding.DecodeString(ci.PayloadKey)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func (ci *CogInfo) verifyPayloadKey() error {
+	if ci.PayloadKey == "" {
+		if ci.PayloadKeyID != "" {
+			return errorPayloadKeyIDWithoutKey
+		}
+		return nil
+	}
+	key, err := base64.StdEncoding.DecodeString(ci.PayloadKey)
+	if err != nil || len(key) != 32 {
+		return errorBadPayloadKey
+	}
+	return nil
+}
+
+func (ci *CogInfo) verifyCompression() error {
+	if ci.Compression != "none" && ci.Compression != "gzip" {
+		return errorBadCompression
+	}
+	if ci.CompressAbove < 0 {
+		return errorBadCompressionThreshold
+	}
+	return nil
+}
+
+// MaxBackoffDuration returns the longest wait between attempts to
+// reconnect to Cog
+func (ci *CogInfo) MaxBackoffDuration() time.Duration {
+	duration, err := time.ParseDuration(ci.MaxBackoff)
+	if err != nil {
+		panic(err)
+	}
+	return duration
+}
+
+func (ci *CogInfo) verifyReconnect() error {
+	duration, err := time.ParseDuration(ci.MaxBackoff)
+	if err != nil || duration < time.Second {
+		return errorBadMaxBackoff
+	}
+	if ci.MaxReconnects < 0 {
+		return errorBadMaxReconnects
+	}
+	if ci.FlapLimit != 0 {
+		if ci.FlapLimit < 2 {
+			return errorBadReconnectFlapLimit
+		}
+		if duration, err := time.ParseDuration(ci.FlapWindow); err != nil || duration <= 0 {
+			return errorBadReconnectFlapWindow
+		}
+		if duration, err := time.ParseDuration(ci.FlapCooldown); err != nil || duration <= 0 {
+			return errorBadReconnectFlapCooldown
+		}
+	}
+	return nil
+}
+
+// ReannounceDuration returns how often bundles are announced again
+// without being asked. Zero disables periodic announcements.
+func (ci *CogInfo) ReannounceDuration() time.Duration {
+	duration, err := time.ParseDuration(ci.Reannounce)
+	if err != nil {
+		panic(errorBadReannounceInterval)
+	}
+	return duration
+}
+
+func (ci *CogInfo) verifyReannounce() error {
+	if duration, err := time.ParseDuration(ci.Reannounce); err != nil || duration < 0 {
+		return errorBadReannounceInterval
+	}
+	return nil
+}
+
+// FlapWindowDuration returns FlapWindow as a time.Duration
+func (ci *CogInfo) FlapWindowDuration() time.Duration {
+	duration, err := time.ParseDuration(ci.FlapWindow)
+	if err != nil {
+		panic(errorBadReconnectFlapWindow)
+	}
+	return duration
+}
+
+// FlapCooldownDuration returns FlapCooldown as a time.Duration
+func (ci *CogInfo) FlapCooldownDuration() time.Duration {
+	duration, err := time.ParseDuration(ci.FlapCooldown)
+	if err != nil {
+		panic(errorBadReconnectFlapCooldown)
+	}
+	return duration
+}
+
+// CommandQoSLevel returns the QoS used to subscribe to command topics
+func (ci *CogInfo) CommandQoSLevel() byte {
+	return parseQoS(ci.CommandQoS)
+}
+
+// ResponseQoSLevel returns the QoS used to publish responses
+func (ci *CogInfo) ResponseQoSLevel() byte {
+	return parseQoS(ci.ResponseQoS)
+}
+
+// AnnouncementQoSLevel returns the QoS used to publish announcements
+func (ci *CogInfo) AnnouncementQoSLevel() byte {
+	return parseQoS(ci.AnnouncementQoS)
+}
+
+func parseQoS(value string) byte {
+	level, err := strconv.Atoi(value)
+	if err != nil || level < 0 || level > 2 {
+		panic(fmt.Errorf("Illegal MQTT QoS level %s", value))
+	}
+	return byte(level)
+}
+
+func (ci *CogInfo) verifyQoS() error {
+	levels := map[string]string{
+		"command_qos":      ci.CommandQoS,
+		"response_qos":     ci.ResponseQoS,
+		"announcement_qos": ci.AnnouncementQoS,
+	}
+	for name, value := range levels {
+		if level, err := strconv.Atoi(value); err != nil || level < 0 || level > 2 {
+			return fmt.Errorf("cog/%s must be 0, 1 or 2: %s", name, value)
+		}
+	}
+	return nil
+}
+
+// UsesWebSocket returns true if Relay tunnels MQTT to Cog over a
+// WebSocket
+func (ci *CogInfo) UsesWebSocket() bool {
+	return ci.Transport == WebSocketTransport
+}
+
+// verifyAuth checks Relay has a way to authenticate to Cog: the shared
+// token, a client certificate, or both
+func (ci *CogInfo) verifyAuth() error {
+	if ci.HasClientCert() && ci.SSLEnabled == false {
+		return errorCogClientCertWithoutSSL
+	}
+	if ci.Token == "" && ci.HasClientCert() == false {
+		return errorMissingCogToken
+	}
+	return nil
+}
+
+// Pins returns the SPKI pins Cog's TLS certificate chain must match
+func (ci *CogInfo) Pins() []string {
+	pins := []string{}
+	for _, pin := range strings.Split(ci.SSLPins, ",") {
+		if pin = strings.TrimSpace(pin); pin != "" {
+			pins = append(pins, pin)
+		}
+	}
+	return pins
+}
+
+func (ci *CogInfo) verifyPins() error {
+	pins := ci.Pins()
+	if len(pins) > 0 && ci.SSLEnabled == false {
+		return errorCogPinsWithoutSSL
+	}
+	for _, pin := range pins {
+		hash, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, "sha256/"))
+		if err != nil || len(hash) != sha256.Size {
+			return fmt.Errorf("cog/ssl_pins entry %s isn't a base64 encoded SHA-256 hash", pin)
+		}
+	}
+	return nil
+}
+
+func (ci *CogInfo) verifyTransport() error {
+	if ci.Transport != MQTTTransport && ci.Transport != WebSocketTransport {
+		return errorBadCogTransport
+	}
+	if ci.UsesWebSocket() && strings.HasPrefix(ci.WebSocketPath, "/") == false {
+		return errorBadWebSocketPath
+	}
+	return nil
+}
+
+// HasClientCert returns true if Relay authenticates to Cog's MQTT
+// broker with a client certificate
+func (ci *CogInfo) HasClientCert() bool {
+	return ci.SSLClientCert != "" && ci.SSLClientKey != ""
+}
+
+func (ci *CogInfo) verifyClientCert() error {
+	if (ci.SSLClientCert == "") != (ci.SSLClientKey == "") {
+		return errorIncompleteCogClientCert
+	}
+	return nil
 }
 
 // URL returns a MQTT URL for the upstream Cog host
 func (ci *CogInfo) URL() string {
+	if ci.UsesWebSocket() {
+		proto := "ws"
+		if ci.SSLEnabled {
+			proto = "wss"
+		}
+		return fmt.Sprintf("%s://%s:%d%s", proto, ci.Host, ci.Port, ci.WebSocketPath)
+	}
 	proto := "tcp"
 	if ci.SSLEnabled {
 		proto = "ssl"
@@ -31,12 +31,33 @@ var validEngineNames = []string{DockerEngine, NativeEngine}
 var errorNoExecutionEngines = errors.New("Invalid Relay configuration detected. At least one execution engine must be enabled.")
 var errorMissingDynamicConfigRoot = errors.New("Enabling 'managed_dynamic_config' requires setting 'dynamic_config_root'.")
 var errorBadDynConfigInterval = errors.New("Error parsing managed_dynamic_config_interval")
+var errorBadClaimWindow = errors.New("Error parsing execution_claim_window")
+var errorBadExecutionTimeout = errors.New("Error parsing execution_timeout")
+var errorBadQueueTTL = errors.New("Error parsing queue_ttl")
+var errorBadFlapWindow = errors.New("Error parsing assignment_flap_window")
+var errorBadFlapLimit = errors.New("assignment_flap_limit must be 0 (disabled) or at least 2")
+var errorBadMessageValidation = errors.New("message_validation must be lenient or strict")
+var errorBadHeartbeatInterval = errors.New("Error parsing heartbeat_interval")
+var errorBadDrainTimeout = errors.New("Error parsing drain_timeout")
+var errorBadMinWorkers = errors.New("min_workers must be between 1 and max_concurrent")
+var errorBadEventLogSize = errors.New("event_log_size must not be negative")
+var errorBadStuckWorkerMultiple = errors.New("stuck_worker_multiple must not be negative")
+var errorDurableQueueNeedsStateDir = errors.New("Enabling 'durable_queue' requires setting 'state_dir'.")
+var errorBadQueueRecovery = errors.New("queue_recovery must be rerun or fail")
+var errorBadExecutionRetries = errors.New("execution_retries must not be negative")
+var errorBadRetryBackoff = errors.New("Error parsing execution_retry_backoff")
+var errorBadDeadLetterSize = errors.New("dead_letter_size must not be negative")
+var errorBadDedupWindow = errors.New("Error parsing dedup_window")
 
 // Config is the top level struct for all Relay configuration
 type Config struct {
 	Version               int      `yaml:"version" valid:"int64,required"`
 	ID                    string   `yaml:"id" env:"RELAY_ID" valid:"uuid,required"`
 	MaxConcurrent         int      `yaml:"max_concurrent" env:"RELAY_MAX_CONCURRENT" valid:"int64,required" default:"16"`
+	AdaptiveConcurrency   bool     `yaml:"adaptive_concurrency" env:"RELAY_ADAPTIVE_CONCURRENCY" valid:"bool" default:"false"`
+	MinConcurrent         int      `yaml:"min_concurrent" env:"RELAY_MIN_CONCURRENT" valid:"-" default:"1"`
+	AutoscaleWorkers      bool     `yaml:"autoscale_workers" env:"RELAY_AUTOSCALE_WORKERS" valid:"bool" default:"false"`
+	MinWorkers            int      `yaml:"min_workers" env:"RELAY_MIN_WORKERS" valid:"-" default:"2"`
 	DynamicConfigRoot     string   `yaml:"dynamic_config_root" env:"RELAY_DYNAMIC_CONFIG_ROOT" valid:"-"`
 	ManagedDynamicConfig  bool     `yaml:"managed_dynamic_config" env:"RELAY_MANAGED_DYNAMIC_CONFIG" valid:"bool" default:"true"`
 	DynamicConfigInterval string   `yaml:"managed_dynamic_config_interval" env:"RELAY_MANAGED_DYNAMIC_CONFIG_INTERVAL" default:"5s"`
@@ -45,10 +66,90 @@ type Config struct {
 	LogPath               string   `yaml:"log_path" env:"RELAY_LOG_PATH" valid:"required" default:"stdout"`
 	Cog                   *CogInfo `yaml:"cog" valid:"required"`
 	EnginesEnabled        string   `yaml:"enabled_engines" env:"RELAY_ENABLED_ENGINES" valid:"exec_engines" default:"docker,native"`
+	Attested              bool     `yaml:"attested" env:"RELAY_ATTESTED" valid:"bool" default:"false"`
+	ReadOnly              bool     `yaml:"read_only" env:"RELAY_READ_ONLY" valid:"bool" default:"false"`
+	ReadOnlyMessage       string   `yaml:"read_only_message" env:"RELAY_READ_ONLY_MESSAGE" valid:"-" default:"Relay is in read-only mode. Only read-only commands can be executed."`
+	Labels                string   `yaml:"labels" env:"RELAY_LABELS" valid:"-"`
+	Tags                  string   `yaml:"tags" env:"RELAY_TAGS" valid:"-"`
+	StateDir              string   `yaml:"state_dir" env:"RELAY_STATE_DIR" valid:"-"`
+	BundleHistorySize     int      `yaml:"bundle_history_size" env:"RELAY_BUNDLE_HISTORY_SIZE" valid:"-" default:"500"`
+	OutboxSize            int      `yaml:"outbox_size" env:"RELAY_OUTBOX_SIZE" valid:"-" default:"1000"`
+	EventLogSize          int      `yaml:"event_log_size" env:"RELAY_EVENT_LOG_SIZE" valid:"-" default:"1000"`
+	ExecutionTimeout      string   `yaml:"execution_timeout" env:"RELAY_EXECUTION_TIMEOUT" valid:"-" default:"0s"`
+	StuckWorkerMultiple   int      `yaml:"stuck_worker_multiple" env:"RELAY_STUCK_WORKER_MULTIPLE" valid:"-" default:"3"`
+	QueueTTL              string   `yaml:"queue_ttl" env:"RELAY_QUEUE_TTL" valid:"-" default:"0s"`
+	DurableQueue          bool     `yaml:"durable_queue" env:"RELAY_DURABLE_QUEUE" valid:"bool" default:"false"`
+	QueueRecovery         string   `yaml:"queue_recovery" env:"RELAY_QUEUE_RECOVERY" valid:"-" default:"rerun"`
+	ExecutionRetries      int      `yaml:"execution_retries" env:"RELAY_EXECUTION_RETRIES" valid:"-" default:"0"`
+	ExecutionRetryBackoff string   `yaml:"execution_retry_backoff" env:"RELAY_EXECUTION_RETRY_BACKOFF" valid:"-" default:"2s"`
+	DeadLetterSize        int      `yaml:"dead_letter_size" env:"RELAY_DEAD_LETTER_SIZE" valid:"-" default:"100"`
+	DedupWindow           string   `yaml:"dedup_window" env:"RELAY_DEDUP_WINDOW" valid:"-" default:"10m"`
+	ExecutionClaims       bool     `yaml:"execution_claims" env:"RELAY_EXECUTION_CLAIMS" valid:"bool" default:"false"`
+	ExecutionClaimWindow  string   `yaml:"execution_claim_window" env:"RELAY_EXECUTION_CLAIM_WINDOW" valid:"-" default:"250ms"`
+	AssignmentFlapLimit   int      `yaml:"assignment_flap_limit" env:"RELAY_ASSIGNMENT_FLAP_LIMIT" valid:"-" default:"0"`
+	AssignmentFlapWindow  string   `yaml:"assignment_flap_window" env:"RELAY_ASSIGNMENT_FLAP_WINDOW" valid:"-" default:"10m"`
+	AssignmentFlapWebhook string   `yaml:"assignment_flap_webhook" env:"RELAY_ASSIGNMENT_FLAP_WEBHOOK" valid:"-"`
+	MessageValidation     string   `yaml:"message_validation" env:"RELAY_MESSAGE_VALIDATION" valid:"-" default:"lenient"`
+	HeartbeatInterval     string   `yaml:"heartbeat_interval" env:"RELAY_HEARTBEAT_INTERVAL" valid:"-" default:"0s"`
+	DrainTimeout          string   `yaml:"drain_timeout" env:"RELAY_DRAIN_TIMEOUT" valid:"-" default:"30s"`
+	HealthListen          string   `yaml:"health_listen" env:"RELAY_HEALTH_LISTEN" valid:"-"`
 	ParsedEnginesEnabled  []string
+	ParsedLabels          []string
+	ParsedTags            map[string]string
 	DevMode               bool
-	Docker                *DockerInfo    `yaml:"docker" valid:"-"`
-	Execution             *ExecutionInfo `yaml:"execution" valid:"-"`
+	Build                 BuildInfo
+	Path                  string                     `yaml:"-" json:"-"`
+	Docker                *DockerInfo                `yaml:"docker" valid:"-"`
+	Execution             *ExecutionInfo             `yaml:"execution" valid:"-"`
+	Facts                 *FactsInfo                 `yaml:"facts" valid:"-"`
+	Admin                 *AdminInfo                 `yaml:"admin" valid:"-"`
+	Bus                   *BusInfo                   `yaml:"bus" valid:"-"`
+	Bridge                *BridgeInfo                `yaml:"bridge" valid:"-"`
+	Group                 *GroupInfo                 `yaml:"group" valid:"-"`
+	SelfTest              *SelfTestInfo              `yaml:"self_test" valid:"-"`
+	Hooks                 *HooksInfo                 `yaml:"hooks" valid:"-"`
+	Watchdog              *WatchdogInfo              `yaml:"watchdog" valid:"-"`
+	RateLimit             *RateLimitInfo             `yaml:"rate_limit" valid:"-"`
+	Backpressure          *BackpressureInfo          `yaml:"backpressure" valid:"-"`
+	Bundles               map[string]*BundleSettings `yaml:"bundles" valid:"-"`
+	NativeRuntimes        map[string]*NativeRuntime  `yaml:"native_runtimes" valid:"-"`
+}
+
+// BuildInfo describes the running Relay executable
+type BuildInfo struct {
+	Hash             string `json:"hash"`
+	Tag              string `json:"tag"`
+	Timestamp        string `json:"timestamp"`
+	CommandDriverTag string `json:"command_driver_tag"`
+}
+
+// StrictMessageValidation returns true if execution requests with
+// fields their schema doesn't define are rejected
+func (c *Config) StrictMessageValidation() bool {
+	return c.MessageValidation == "strict"
+}
+
+// RerunQueuedRequests returns true if requests left in the durable
+// queue by a previous run should be run rather than failed
+func (c *Config) RerunQueuedRequests() bool {
+	return c.QueueRecovery != "fail"
+}
+
+func (c *Config) verifyDurableQueue() error {
+	if c.DurableQueue == true && c.StateDir == "" {
+		return errorDurableQueueNeedsStateDir
+	}
+	if c.QueueRecovery != "rerun" && c.QueueRecovery != "fail" {
+		return errorBadQueueRecovery
+	}
+	return nil
+}
+
+func (c *Config) verifyMessageValidation() error {
+	if c.MessageValidation != "lenient" && c.MessageValidation != "strict" {
+		return errorBadMessageValidation
+	}
+	return nil
 }
 
 // RefreshDuration returns RefreshInterval as a time.Duration
@@ -69,6 +170,152 @@ func (c *Config) ManagedDynamicConfigRefreshDuration() time.Duration {
 	return duration
 }
 
+// ExecutionTimeoutFor returns the execution timeout for the named
+// bundle. Bundle timeouts override execution_timeout. Zero means
+// commands may run indefinitely.
+func (c *Config) ExecutionTimeoutFor(bundleName string) time.Duration {
+	timeout := c.ExecutionTimeout
+	if bundleTimeout := c.SettingsForBundle(bundleName).Timeout; bundleTimeout != "" {
+		timeout = bundleTimeout
+	}
+	if timeout == "" {
+		return 0
+	}
+	duration, err := time.ParseDuration(timeout)
+	if err != nil {
+		panic(errorBadExecutionTimeout)
+	}
+	return duration
+}
+
+// ExecutionRetryBackoffDuration returns ExecutionRetryBackoff as a
+// time.Duration
+func (c *Config) ExecutionRetryBackoffDuration() time.Duration {
+	duration, err := time.ParseDuration(c.ExecutionRetryBackoff)
+	if err != nil {
+		panic(errorBadRetryBackoff)
+	}
+	return duration
+}
+
+func (c *Config) verifyExecutionRetries() error {
+	if c.ExecutionRetries < 0 {
+		return errorBadExecutionRetries
+	}
+	if duration, err := time.ParseDuration(c.ExecutionRetryBackoff); err != nil || duration < 0 {
+		return errorBadRetryBackoff
+	}
+	if c.DeadLetterSize < 0 {
+		return errorBadDeadLetterSize
+	}
+	return nil
+}
+
+// QueueTTLDuration returns QueueTTL as a time.Duration. Zero means
+// queued requests never expire.
+func (c *Config) QueueTTLDuration() time.Duration {
+	if c.QueueTTL == "" {
+		return 0
+	}
+	duration, err := time.ParseDuration(c.QueueTTL)
+	if err != nil {
+		panic(errorBadQueueTTL)
+	}
+	return duration
+}
+
+// DedupWindowDuration returns DedupWindow as a time.Duration. Zero
+// means duplicate deliveries aren't detected.
+func (c *Config) DedupWindowDuration() time.Duration {
+	if c.DedupWindow == "" {
+		return 0
+	}
+	duration, err := time.ParseDuration(c.DedupWindow)
+	if err != nil {
+		panic(errorBadDedupWindow)
+	}
+	return duration
+}
+
+// ExecutionClaimWindowDuration returns ExecutionClaimWindow as a time.Duration
+func (c *Config) ExecutionClaimWindowDuration() time.Duration {
+	duration, err := time.ParseDuration(c.ExecutionClaimWindow)
+	if err != nil {
+		panic(errorBadClaimWindow)
+	}
+	return duration
+}
+
+// AssignmentFlapWindowDuration returns AssignmentFlapWindow as a time.Duration
+func (c *Config) AssignmentFlapWindowDuration() time.Duration {
+	duration, err := time.ParseDuration(c.AssignmentFlapWindow)
+	if err != nil {
+		panic(errorBadFlapWindow)
+	}
+	return duration
+}
+
+// HeartbeatDuration returns HeartbeatInterval as a time.Duration. Zero
+// disables heartbeats.
+func (c *Config) HeartbeatDuration() time.Duration {
+	duration, err := time.ParseDuration(c.HeartbeatInterval)
+	if err != nil {
+		panic(errorBadHeartbeatInterval)
+	}
+	return duration
+}
+
+func (c *Config) verifyHeartbeat() error {
+	if duration, err := time.ParseDuration(c.HeartbeatInterval); err != nil || duration < 0 {
+		return errorBadHeartbeatInterval
+	}
+	return nil
+}
+
+// DrainDuration returns how long Stop waits for in-flight commands to
+// finish before interrupting them
+func (c *Config) DrainDuration() time.Duration {
+	duration, err := time.ParseDuration(c.DrainTimeout)
+	if err != nil {
+		panic(errorBadDrainTimeout)
+	}
+	return duration
+}
+
+func (c *Config) verifyDrainTimeout() error {
+	if duration, err := time.ParseDuration(c.DrainTimeout); err != nil || duration < 0 {
+		return errorBadDrainTimeout
+	}
+	return nil
+}
+
+func (c *Config) verifyExecutionTimeouts() error {
+	timeouts := []string{c.ExecutionTimeout}
+	for _, settings := range c.Bundles {
+		if settings != nil {
+			timeouts = append(timeouts, settings.Timeout)
+		}
+	}
+	for _, timeout := range timeouts {
+		if timeout == "" {
+			continue
+		}
+		if duration, err := time.ParseDuration(timeout); err != nil || duration < 0 {
+			return errorBadExecutionTimeout
+		}
+	}
+	return nil
+}
+
+// StatePath returns the path of the named file in the state
+// directory. Returns an empty string if no state directory is set.
+func (c *Config) StatePath(name string) string {
+	if c.StateDir == "" {
+		return ""
+	}
+	return path.Join(c.StateDir, name)
+}
+
 // DockerEnabled returns true when enabled_engines includes "docker"
 func (c *Config) DockerEnabled() bool {
 	return c.engineEnabled(DockerEngine)
@@ -96,6 +343,178 @@ func (c *Config) Verify() error {
 	if c.ManagedDynamicConfig == true && c.DynamicConfigRoot == "" {
 		return errorMissingDynamicConfigRoot
 	}
+	if c.DockerEnabled() == true && c.Docker != nil {
+		if err := c.Docker.verifyHosts(); err != nil {
+			return err
+		}
+		if err := c.Docker.verifyTLS(); err != nil {
+			return err
+		}
+		if err := c.Docker.verifyContainerUser(); err != nil {
+			return err
+		}
+		if err := c.Docker.verifyScratch(); err != nil {
+			return err
+		}
+		if err := c.Docker.verifyRegistryCredentials(); err != nil {
+			return err
+		}
+		if err := c.Docker.verifyPullParallelism(); err != nil {
+			return err
+		}
+		if err := c.Docker.verifyDevBuilds(c.DevMode); err != nil {
+			return err
+		}
+	}
+	if err := c.verifyBundleSettings(); err != nil {
+		return err
+	}
+	if err := c.verifyNativeRuntimes(); err != nil {
+		return err
+	}
+	if err := c.Cog.verifyClientCert(); err != nil {
+		return err
+	}
+	if err := c.Cog.verifyTransport(); err != nil {
+		return err
+	}
+	if err := c.Cog.verifyPins(); err != nil {
+		return err
+	}
+	if err := c.Cog.verifyQoS(); err != nil {
+		return err
+	}
+	if err := c.Cog.verifyReconnect(); err != nil {
+		return err
+	}
+	if err := c.Cog.verifyReannounce(); err != nil {
+		return err
+	}
+	if err := c.Cog.verifyCompression(); err != nil {
+		return err
+	}
+	if err := c.Cog.verifyPayloadKey(); err != nil {
+		return err
+	}
+	if err := c.Cog.verifyConnectionTuning(); err != nil {
+		return err
+	}
+	if err := c.Cog.verifyTopicPrefix(); err != nil {
+		return err
+	}
+	if err := c.Cog.verifyEncoding(); err != nil {
+		return err
+	}
+	if err := c.Cog.verifyHandshake(); err != nil {
+		return err
+	}
+	if c.Bus != nil {
+		if err := c.Bus.verify(); err != nil {
+			return err
+		}
+	}
+	if c.Bridge != nil {
+		if err := c.Bridge.verify(); err != nil {
+			return err
+		}
+	}
+	if c.Group != nil {
+		if err := c.Group.verify(); err != nil {
+			return err
+		}
+	}
+	if c.SelfTest != nil {
+		if err := c.SelfTest.verify(); err != nil {
+			return err
+		}
+	}
+	if c.Hooks != nil {
+		if err := c.Hooks.verify(); err != nil {
+			return err
+		}
+	}
+	if c.Watchdog != nil {
+		if err := c.Watchdog.verify(); err != nil {
+			return err
+		}
+	}
+	if c.RateLimit != nil {
+		if err := c.RateLimit.verify(); err != nil {
+			return err
+		}
+	}
+	if c.Backpressure != nil {
+		if err := c.Backpressure.verify(); err != nil {
+			return err
+		}
+	}
+	if c.Admin != nil {
+		if err := c.Admin.verifyTLS(); err != nil {
+			return err
+		}
+		if err := c.Admin.verifyToken(); err != nil {
+			return err
+		}
+	}
+	if err := c.Cog.verifyAuth(); err != nil {
+		return err
+	}
+	if err := c.verifyExecutionTimeouts(); err != nil {
+		return err
+	}
+	if err := c.verifyTags(); err != nil {
+		return err
+	}
+	if c.QueueTTL != "" {
+		if duration, err := time.ParseDuration(c.QueueTTL); err != nil || duration < 0 {
+			return errorBadQueueTTL
+		}
+	}
+	if err := c.verifyDurableQueue(); err != nil {
+		return err
+	}
+	if err := c.verifyExecutionRetries(); err != nil {
+		return err
+	}
+	if c.DedupWindow != "" {
+		if duration, err := time.ParseDuration(c.DedupWindow); err != nil || duration < 0 {
+			return errorBadDedupWindow
+		}
+	}
+	if c.ExecutionClaims == true {
+		if duration, err := time.ParseDuration(c.ExecutionClaimWindow); err != nil || duration <= 0 {
+			return errorBadClaimWindow
+		}
+	}
+	if c.AutoscaleWorkers == true && (c.MinWorkers < 1 || c.MinWorkers > c.MaxConcurrent) {
+		return errorBadMinWorkers
+	}
+	if c.EventLogSize < 0 {
+		return errorBadEventLogSize
+	}
+	if c.StuckWorkerMultiple < 0 {
+		return errorBadStuckWorkerMultiple
+	}
+	if c.AssignmentFlapLimit != 0 {
+		if c.AssignmentFlapLimit < 2 {
+			return errorBadFlapLimit
+		}
+		if duration, err := time.ParseDuration(c.AssignmentFlapWindow); err != nil || duration <= 0 {
+			return errorBadFlapWindow
+		}
+	}
+	if err := c.verifyMessageValidation(); err != nil {
+		return err
+	}
+	if err := c.verifyHeartbeat(); err != nil {
+		return err
+	}
+	if err := c.verifyDrainTimeout(); err != nil {
+		return err
+	}
+	if err := c.verifyAttestation(); err != nil {
+		return err
+	}
 	if c.ManagedDynamicConfig == true {
 		c.DynamicConfigRoot = path.Join(c.DynamicConfigRoot, ManagedDynamicConfigLink)
 	}
@@ -121,7 +540,97 @@ func (c *Config) populate() {
 	setDefaultValues(c.Execution)
 	setEnvVars(c.Execution)
 	c.Execution.parse()
+	if c.Facts == nil {
+		c.Facts = &FactsInfo{}
+	}
+	setDefaultValues(c.Facts)
+	setEnvVars(c.Facts)
+	if c.Admin == nil {
+		c.Admin = &AdminInfo{}
+	}
+	setDefaultValues(c.Admin)
+	setEnvVars(c.Admin)
+	if c.Bus == nil {
+		c.Bus = &BusInfo{}
+	}
+	setDefaultValues(c.Bus)
+	setEnvVars(c.Bus)
+	if c.Bridge == nil {
+		c.Bridge = &BridgeInfo{}
+	}
+	setDefaultValues(c.Bridge)
+	setEnvVars(c.Bridge)
+	if c.Group == nil {
+		c.Group = &GroupInfo{}
+	}
+	setDefaultValues(c.Group)
+	setEnvVars(c.Group)
+	if c.SelfTest == nil {
+		c.SelfTest = &SelfTestInfo{}
+	}
+	setDefaultValues(c.SelfTest)
+	setEnvVars(c.SelfTest)
+	if c.Hooks == nil {
+		c.Hooks = &HooksInfo{}
+	}
+	setDefaultValues(c.Hooks)
+	setEnvVars(c.Hooks)
+	if c.Watchdog == nil {
+		c.Watchdog = &WatchdogInfo{}
+	}
+	setDefaultValues(c.Watchdog)
+	setEnvVars(c.Watchdog)
+	if c.RateLimit == nil {
+		c.RateLimit = &RateLimitInfo{}
+	}
+	setDefaultValues(c.RateLimit)
+	setEnvVars(c.RateLimit)
+	if c.Backpressure == nil {
+		c.Backpressure = &BackpressureInfo{}
+	}
+	setDefaultValues(c.Backpressure)
+	setEnvVars(c.Backpressure)
 	c.parseEngines()
+	c.parseLabels()
+	c.parseTags()
+}
+
+func (c *Config) parseLabels() {
+	parsed := []string{}
+	for _, label := range strings.Split(c.Labels, ",") {
+		label = strings.TrimSpace(label)
+		if label != "" {
+			parsed = append(parsed, label)
+		}
+	}
+	c.ParsedLabels = parsed
+}
+
+// parseTags parses comma separated key=value pairs. Malformed pairs
+// are skipped here and reported by verifyTags.
+func (c *Config) parseTags() {
+	parsed := map[string]string{}
+	for _, tag := range strings.Split(c.Tags, ",") {
+		parts := strings.SplitN(tag, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			continue
+		}
+		parsed[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	c.ParsedTags = parsed
+}
+
+func (c *Config) verifyTags() error {
+	for _, tag := range strings.Split(c.Tags, ",") {
+		if strings.TrimSpace(tag) == "" {
+			continue
+		}
+		parts := strings.SplitN(tag, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return fmt.Errorf("Malformed tag '%s'. Tags must be key=value pairs", strings.TrimSpace(tag))
+		}
+	}
+	return nil
 }
 
 func (c *Config) parseEngines() {
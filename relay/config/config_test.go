@@ -1,8 +1,11 @@
 package config
 
 import (
+	"io/ioutil"
 	"os"
+	"path"
 	"testing"
+	"time"
 )
 
 const (
@@ -179,3 +182,1256 @@ func TestEmptyRawConfig(t *testing.T) {
 		t.Error("Expected IsEmpty() to return false")
 	}
 }
+
+func TestDockerTLSRequiresTCP(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RELAY_DYNAMIC_CONFIG_ROOT", "/tmp/relay_dyn")
+	os.Setenv("RELAY_DOCKER_CERT_PATH", "/tmp")
+	rawConfig := RawConfig(fullConfig)
+	config, err := rawConfig.Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Verify(); err != errorTLSRequiresTCP {
+		t.Errorf("Expected errorTLSRequiresTCP: %v", err)
+	}
+}
+
+func TestDockerTLSMissingCerts(t *testing.T) {
+	os.Clearenv()
+	certPath, err := ioutil.TempDir("", "relay_docker_tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(certPath)
+	os.Setenv("RELAY_DYNAMIC_CONFIG_ROOT", "/tmp/relay_dyn")
+	os.Setenv("RELAY_DOCKER_SOCKET_PATH", "tcp://docker.example.com:2376")
+	os.Setenv("RELAY_DOCKER_CERT_PATH", certPath)
+	rawConfig := RawConfig(fullConfig)
+	config, err := rawConfig.Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Verify(); err == nil {
+		t.Error("Expected missing Docker TLS files to fail verification")
+	}
+	for _, name := range []string{dockerCAFile, dockerCertFile, dockerKeyFile} {
+		if err := ioutil.WriteFile(path.Join(certPath, name), []byte("test"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := config.Verify(); err != nil {
+		t.Errorf("Expected Docker TLS config to verify: %s", err)
+	}
+}
+
+func TestBundleSeccompProfiles(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RELAY_DYNAMIC_CONFIG_ROOT", "/tmp/relay_dyn")
+	dir, err := ioutil.TempDir("", "seccomp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	profilePath := path.Join(dir, "network-only.json")
+	if err := ioutil.WriteFile(profilePath, []byte(`{"defaultAction": "SCMP_ACT_ERRNO"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rawConfig := RawConfig(fullConfig + "  seccomp_profiles:\n    network-only: " + profilePath + `
+bundles:
+  ping:
+    seccomp_profile: network-only
+    apparmor_profile: relay-ping
+`)
+	config, err := rawConfig.Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	settings := config.SettingsForBundle("ping")
+	if settings.AppArmorProfile != "relay-ping" {
+		t.Errorf("Unexpected AppArmor profile: %s", settings.AppArmorProfile)
+	}
+	profile, err := config.Docker.SeccompProfile(settings.SeccompProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile != `{"defaultAction":"SCMP_ACT_ERRNO"}` {
+		t.Errorf("Unexpected seccomp profile: %s", profile)
+	}
+	if config.SettingsForBundle("other").SeccompProfile != "" {
+		t.Error("Expected unconfigured bundle to have no seccomp profile")
+	}
+	config.Bundles["ping"].SeccompProfile = "missing"
+	if err := config.Verify(); err == nil {
+		t.Error("Expected unknown seccomp profile to be rejected")
+	}
+}
+
+func TestDockerContainerUser(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RELAY_DYNAMIC_CONFIG_ROOT", "/tmp/relay_dyn")
+	for user, valid := range map[string]bool{
+		"1000":      true,
+		"1000:1000": true,
+		"1000:0":    true,
+		"0":         false,
+		"0:1000":    false,
+		"nobody":    false,
+		"1:2:3":     false,
+	} {
+		os.Setenv("RELAY_DOCKER_CONTAINER_USER", user)
+		config, err := RawConfig(fullConfig).Parse("0.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = config.Verify()
+		if valid && err != nil {
+			t.Errorf("Expected container user %s to be accepted: %s", user, err)
+		}
+		if !valid && err != errorBadContainerUser {
+			t.Errorf("Expected container user %s to be rejected: %v", user, err)
+		}
+	}
+}
+
+func TestDockerReadOnlyRootfs(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RELAY_DYNAMIC_CONFIG_ROOT", "/tmp/relay_dyn")
+	os.Setenv("RELAY_DOCKER_READ_ONLY_ROOTFS", "true")
+	config, err := RawConfig(fullConfig).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	tmpfs := config.Docker.ScratchTmpfs()
+	if tmpfs["/tmp"] != "rw,noexec,nosuid,size=64m,mode=1777" {
+		t.Errorf("Unexpected scratch tmpfs: %v", tmpfs)
+	}
+	config.Docker.ScratchPath = "scratch"
+	if err := config.Verify(); err != errorBadScratchPath {
+		t.Errorf("Expected errorBadScratchPath: %v", err)
+	}
+}
+
+func TestSanitizedConfig(t *testing.T) {
+	os.Clearenv()
+	config, err := RawConfig(usingDefaultsConfig).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sanitized := config.Sanitized()
+	if sanitized.Cog.Token != redacted || sanitized.Docker.RegistryPassword != redacted {
+		t.Error("Expected credentials to be redacted")
+	}
+	if sanitized.Execution.ParsedExtraEnv["TEST1"] != redacted {
+		t.Errorf("Expected execution env to be redacted: %v", sanitized.Execution.ParsedExtraEnv)
+	}
+	if config.Cog.Token != "wubba" || config.Execution.ParsedExtraEnv["TEST1"] != "a" {
+		t.Error("Expected original config to be unmodified")
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RELAY_LABELS", "gpu, prod-network,,pci-zone ")
+	config, err := RawConfig(fullConfig).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.ParsedLabels) != 3 || config.ParsedLabels[1] != "prod-network" || config.ParsedLabels[2] != "pci-zone" {
+		t.Errorf("Unexpected parsed labels: %v", config.ParsedLabels)
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RELAY_TAGS", "datacenter=ams1, environment = prod,,role=")
+	config, err := RawConfig(fullConfig).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.ParsedTags) != 3 || config.ParsedTags["datacenter"] != "ams1" || config.ParsedTags["environment"] != "prod" {
+		t.Errorf("Unexpected parsed tags: %v", config.ParsedTags)
+	}
+	if err := config.verifyTags(); err != nil {
+		t.Error(err)
+	}
+	config.Tags = "datacenter=ams1,prod"
+	if err := config.verifyTags(); err == nil {
+		t.Error("Expected tag without a value separator to be rejected")
+	}
+}
+
+func TestAttestedConfig(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RELAY_DYNAMIC_CONFIG_ROOT", "/tmp/relay_dyn")
+	os.Setenv("RELAY_ATTESTED", "true")
+	config, err := RawConfig(fullConfig).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := config.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Cog.Token = "changed"
+	if rehashed, _ := config.Hash(); rehashed != hash {
+		t.Error("Expected config hash to ignore credentials")
+	}
+	config.LogLevel = "debug"
+	if rehashed, _ := config.Hash(); rehashed == hash {
+		t.Error("Expected config hash to change with config")
+	}
+	if err := config.Verify(); err == nil {
+		t.Error("Expected attested config with debug logging to be rejected")
+	}
+}
+
+func TestBundleDevices(t *testing.T) {
+	settings := BundleSettings{
+		GPUs:    "0,1",
+		Devices: []string{"/dev/fuse", "/dev/sda:/dev/xvda:r"},
+	}
+	if settings.ContainerRuntime() != NvidiaRuntime {
+		t.Errorf("Expected GPU bundle to use the NVIDIA runtime: %s", settings.ContainerRuntime())
+	}
+	specs, err := settings.DeviceSpecs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if specs[0].ContainerPath != "/dev/fuse" || specs[0].Permissions != "rwm" {
+		t.Errorf("Unexpected device spec: %+v", specs[0])
+	}
+	if specs[1].ContainerPath != "/dev/xvda" || specs[1].Permissions != "r" {
+		t.Errorf("Unexpected device spec: %+v", specs[1])
+	}
+	for _, device := range []string{"dev/fuse", "/dev/fuse:/dev/fuse:rx", "/a:/b:r:w"} {
+		if _, err := ParseDeviceSpec(device); err == nil {
+			t.Errorf("Expected device spec %s to be rejected", device)
+		}
+	}
+}
+
+func TestBundleExecutableOverrides(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RELAY_DYNAMIC_CONFIG_ROOT", "/tmp/relay_dyn")
+	rawConfig := RawConfig(fullConfig + `bundles:
+  ping:
+    entrypoint: ["/usr/bin/env"]
+    working_dir: /opt/vendor
+    executables:
+      ping: /opt/vendor/bin/ping
+`)
+	config, err := rawConfig.Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	settings := config.SettingsForBundle("ping")
+	if settings.ExecutableFor("ping", "/bundle/ping") != "/opt/vendor/bin/ping" {
+		t.Errorf("Expected ping executable to be overridden: %v", settings.Executables)
+	}
+	if settings.ExecutableFor("pong", "/bundle/pong") != "/bundle/pong" {
+		t.Error("Expected pong executable to be unchanged")
+	}
+	config.Bundles["ping"].WorkingDir = "vendor"
+	if err := config.Verify(); err == nil {
+		t.Error("Expected relative working directory to be rejected")
+	}
+}
+
+func TestNativeRuntimes(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RELAY_DYNAMIC_CONFIG_ROOT", "/tmp/relay_dyn")
+	dir, err := ioutil.TempDir("", "runtimes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rawConfig := RawConfig(fullConfig + `bundles:
+  ping:
+    native_runtimes: ["python3.8"]
+native_runtimes:
+  python3.8:
+    bin_dirs: ["` + dir + `"]
+    env: ["PYENV_VERSION=3.8.18"]
+`)
+	config, err := rawConfig.Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	env := config.NativeRuntimeEnv("ping", "/usr/bin")
+	if env["PATH"] != dir+":/usr/bin" || env["PYENV_VERSION"] != "3.8.18" {
+		t.Errorf("Unexpected native runtime env: %v", env)
+	}
+	if config.NativeRuntimeEnv("other", "/usr/bin") != nil {
+		t.Error("Expected bundle without native runtimes to have no runtime env")
+	}
+	config.Bundles["ping"].NativeRuntimes = []string{"ruby2.7"}
+	if err := config.Verify(); err == nil {
+		t.Error("Expected unknown native runtime to be rejected")
+	}
+}
+
+func TestExecutionTimeouts(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RELAY_DYNAMIC_CONFIG_ROOT", "/tmp/relay_dyn")
+	os.Setenv("RELAY_EXECUTION_TIMEOUT", "5m")
+	rawConfig := RawConfig(fullConfig + `bundles:
+  ping:
+    timeout: 30s
+`)
+	config, err := rawConfig.Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if timeout := config.ExecutionTimeoutFor("ping"); timeout != 30*time.Second {
+		t.Errorf("Expected bundle timeout to override execution_timeout: %v", timeout)
+	}
+	if timeout := config.ExecutionTimeoutFor("other"); timeout != 5*time.Minute {
+		t.Errorf("Expected execution_timeout for bundle without a timeout: %v", timeout)
+	}
+	config.Bundles["ping"].Timeout = "forever"
+	if err := config.Verify(); err != errorBadExecutionTimeout {
+		t.Errorf("Expected errorBadExecutionTimeout: %v", err)
+	}
+}
+
+func TestIncompleteTLSKeyPairs(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RELAY_DYNAMIC_CONFIG_ROOT", "/tmp/relay_dyn")
+	os.Setenv("RELAY_COG_SSL_CLIENT_CERT", "/etc/relay/client.pem")
+	config, err := RawConfig(fullConfig).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Verify(); err != errorIncompleteCogClientCert {
+		t.Errorf("Expected errorIncompleteCogClientCert: %v", err)
+	}
+	config.Cog.SSLClientKey = "/etc/relay/client-key.pem"
+	config.Admin.TLSKey = "/etc/relay/admin-key.pem"
+	if err := config.Verify(); err != errorIncompleteAdminTLS {
+		t.Errorf("Expected errorIncompleteAdminTLS: %v", err)
+	}
+}
+
+func TestAdminToken(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RELAY_DYNAMIC_CONFIG_ROOT", "/tmp/relay_dyn")
+	config, err := RawConfig(fullConfig).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, listen := range []string{"127.0.0.1:7780", "localhost:7780", "[::1]:7780", "unix:///var/run/relay.sock"} {
+		config.Admin.Listen = listen
+		if err := config.Admin.verifyToken(); err != nil {
+			t.Errorf("Expected %s to be allowed without a token: %s", listen, err)
+		}
+	}
+	config.Admin.Listen = "0.0.0.0:7780"
+	if err := config.Admin.verifyToken(); err != errorMissingAdminToken {
+		t.Errorf("Expected errorMissingAdminToken: %v", err)
+	}
+	config.Admin.Token = "sekrit"
+	if err := config.Admin.verifyToken(); err != nil {
+		t.Error(err)
+	}
+	if sanitized := config.Sanitized(); sanitized.Admin.Token != redacted {
+		t.Errorf("Expected admin token to be redacted: %s", sanitized.Admin.Token)
+	}
+}
+
+func TestGroup(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RELAY_DYNAMIC_CONFIG_ROOT", "/tmp/relay_dyn")
+	config, err := RawConfig(fullConfig).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Group.Enabled() == true || config.Group.HeartbeatDuration() != 10*time.Second {
+		t.Errorf("Unexpected group defaults: %+v", config.Group)
+	}
+	if err := config.Group.verify(); err != nil {
+		t.Error(err)
+	}
+	config.Group.Sharding = true
+	if err := config.Group.verify(); err != errorMissingGroupID {
+		t.Errorf("Expected errorMissingGroupID: %v", err)
+	}
+	config.Group.ID = "dc1"
+	config.Group.HeartbeatInterval = "0s"
+	if err := config.Group.verify(); err != errorBadGroupHeartbeat {
+		t.Errorf("Expected errorBadGroupHeartbeat: %v", err)
+	}
+	config.Group.HeartbeatInterval = "5s"
+	if err := config.Group.verify(); err != nil || config.Group.MemberTTL() != 15*time.Second {
+		t.Errorf("Unexpected group verification: %v %v", err, config.Group.MemberTTL())
+	}
+}
+
+func TestSingletonBundles(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RELAY_DYNAMIC_CONFIG_ROOT", "/tmp/relay_dyn")
+	config, err := RawConfig(fullConfig).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Bundles = map[string]*BundleSettings{
+		"cron": &BundleSettings{Singleton: true},
+	}
+	if err := config.verifyBundleSettings(); err == nil {
+		t.Error("Expected singleton bundle without a group to be rejected")
+	}
+	config.Group.ID = "dc1"
+	if err := config.verifyBundleSettings(); err != nil {
+		t.Error(err)
+	}
+	if config.SettingsForBundle("cron").Singleton == false {
+		t.Error("Expected cron to be a singleton")
+	}
+}
+
+func TestDockerHosts(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RELAY_DYNAMIC_CONFIG_ROOT", "/tmp/relay_dyn")
+	os.Setenv("RELAY_DOCKER_BALANCE", "round-robin")
+	config, err := RawConfig(fullConfig).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if endpoints := config.Docker.Endpoints(); len(endpoints) != 1 || endpoints[0] != config.Docker.SocketPath {
+		t.Errorf("Expected socket_path to be the only endpoint: %v", endpoints)
+	}
+	config.Docker.Hosts = []string{"tcp://docker1:2376", "tcp://docker2:2376"}
+	if err := config.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if hostConfig := config.Docker.ForHost("tcp://docker2:2376"); hostConfig.SocketPath != "tcp://docker2:2376" {
+		t.Errorf("Unexpected socket path for host: %s", hostConfig.SocketPath)
+	}
+	config.Docker.Hosts = []string{"tcp://docker1:2376", "docker2:2376"}
+	if err := config.Verify(); err != errorBadDockerHost {
+		t.Errorf("Expected errorBadDockerHost: %v", err)
+	}
+	config.Docker.Hosts = nil
+	config.Docker.Balance = "random"
+	if err := config.Verify(); err != errorBadDockerBalance {
+		t.Errorf("Expected errorBadDockerBalance: %v", err)
+	}
+}
+
+func TestRegistryCredentials(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RELAY_DYNAMIC_CONFIG_ROOT", "/tmp/relay_dyn")
+	os.Setenv("RELAY_DOCKER_REGISTRY_CREDENTIALS", "ecr")
+	config, err := RawConfig(fullConfig).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Verify(); err != errorConflictingRegistryCredentials {
+		t.Errorf("Expected errorConflictingRegistryCredentials: %v", err)
+	}
+	config.Docker.RegistryPassword = ""
+	if err := config.Verify(); err != nil {
+		t.Error(err)
+	}
+	config.Docker.RegistryCredentials = "quay"
+	if err := config.Verify(); err != errorBadRegistryCredentials {
+		t.Errorf("Expected errorBadRegistryCredentials: %v", err)
+	}
+}
+
+func TestConfigDiff(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RELAY_DYNAMIC_CONFIG_ROOT", "/tmp/relay_dyn")
+	current, err := RawConfig(fullConfig).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	candidate, err := RawConfig(fullConfig).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes := current.Diff(candidate); len(changes) != 0 {
+		t.Errorf("Expected identical configs to have no changes: %+v", changes)
+	}
+	candidate.ReadOnly = true
+	candidate.Cog.Token = "new-sekrit"
+	candidate.Docker.CleanInterval = "10m"
+	changes := current.Diff(candidate)
+	if len(changes) != 3 {
+		t.Fatalf("Expected 3 changes: %+v", changes)
+	}
+	if changes[0].Setting != "cog/token" || changes[0].Candidate != redacted || changes[0].Apply != RestartApply {
+		t.Errorf("Unexpected token change: %+v", changes[0])
+	}
+	if changes[1].Setting != "docker/clean_interval" || changes[1].Current != "5m" || changes[1].Candidate != "10m" {
+		t.Errorf("Unexpected clean_interval change: %+v", changes[1])
+	}
+	if changes[2].Setting != "read_only" || changes[2].Apply != HotApply {
+		t.Errorf("Unexpected read_only change: %+v", changes[2])
+	}
+}
+
+func TestConfigReloaded(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RELAY_DYNAMIC_CONFIG_ROOT", "/tmp/relay_dyn")
+	current, err := RawConfig(fullConfig).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	candidate, err := RawConfig(fullConfig).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	candidate.LogLevel = "debug"
+	candidate.Cog.RefreshInterval = "2m"
+	candidate.Cog.Token = "new-sekrit"
+	candidate.Docker.CleanInterval = "10m"
+	candidate.Bundles = map[string]*BundleSettings{
+		"foo": &BundleSettings{Runtime: "runsc"},
+	}
+	reloaded, changes := current.Reloaded(candidate)
+	if len(changes) == 0 {
+		t.Fatal("Expected changes")
+	}
+	for _, change := range changes {
+		if change.Setting == "cog/token" && change.Apply != RestartApply {
+			t.Errorf("Expected token change to require a restart: %+v", change)
+		}
+		if change.Setting != "cog/token" && change.Apply != HotApply {
+			t.Errorf("Expected %s to be applied without a restart: %+v", change.Setting, change)
+		}
+	}
+	if reloaded.LogLevel != "debug" || reloaded.Cog.RefreshInterval != "2m" || reloaded.Docker.CleanInterval != "10m" {
+		t.Errorf("Hot settings weren't applied: %s %s %s", reloaded.LogLevel, reloaded.Cog.RefreshInterval,
+			reloaded.Docker.CleanInterval)
+	}
+	if reloaded.Bundles["foo"] == nil || reloaded.Bundles["foo"].Runtime != "runsc" {
+		t.Errorf("Bundle settings weren't applied: %+v", reloaded.Bundles)
+	}
+	if reloaded.Cog.Token == "new-sekrit" {
+		t.Error("Expected token to be left alone until restart")
+	}
+	if current.LogLevel == "debug" || current.Cog.RefreshInterval == "2m" || current.Docker.CleanInterval == "10m" {
+		t.Error("Expected the running config to be left unchanged")
+	}
+}
+
+func TestBundleLimits(t *testing.T) {
+	settings := BundleSettings{
+		Ulimits: map[string]string{
+			"nproc":  "512",
+			"nofile": "4096:65536",
+		},
+		Sysctls: map[string]string{
+			"net.ipv4.ip_local_port_range": "1024 65000",
+		},
+	}
+	ulimits, err := settings.UlimitSpecs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ulimits[0] != (Ulimit{Name: "nofile", Soft: 4096, Hard: 65536}) {
+		t.Errorf("Unexpected ulimit: %+v", ulimits[0])
+	}
+	if ulimits[1] != (Ulimit{Name: "nproc", Soft: 512, Hard: 512}) {
+		t.Errorf("Expected hard limit to default to soft limit: %+v", ulimits[1])
+	}
+	if err := settings.verifySysctls(); err != nil {
+		t.Error(err)
+	}
+	for name, value := range map[string]string{"nofiles": "1024", "nofile": "2048:1024", "nproc": "many"} {
+		if _, err := ParseUlimit(name, value); err == nil {
+			t.Errorf("Expected ulimit %s=%s to be rejected", name, value)
+		}
+	}
+	settings.Sysctls["vm.swappiness"] = "10"
+	if err := settings.verifySysctls(); err == nil {
+		t.Error("Expected host-wide sysctl to be rejected")
+	}
+}
+
+func TestBundleSchedules(t *testing.T) {
+	settings := &BundleSettings{
+		Schedules: []Schedule{{Command: "status", Every: "24h", At: "02:00", Room: "ops"}},
+	}
+	if err := settings.verifySchedules("backup"); err != nil {
+		t.Fatal(err)
+	}
+	schedule := settings.Schedules[0]
+	if schedule.Name != "backup:status" || schedule.ResultTopic() != DefaultScheduleTopic {
+		t.Errorf("Unexpected schedule defaults: %+v", schedule)
+	}
+	now := time.Date(2026, 3, 4, 1, 30, 0, 0, time.UTC)
+	if next := schedule.Next(now); next != time.Date(2026, 3, 4, 2, 0, 0, 0, time.UTC) {
+		t.Errorf("Unexpected next run before time of day: %v", next)
+	}
+	if next := schedule.Next(now.Add(time.Hour)); next != time.Date(2026, 3, 5, 2, 0, 0, 0, time.UTC) {
+		t.Errorf("Unexpected next run after time of day: %v", next)
+	}
+	schedule.Every = "6h"
+	if next := schedule.Next(now.Add(time.Hour)); next != time.Date(2026, 3, 4, 8, 0, 0, 0, time.UTC) {
+		t.Errorf("Unexpected next run of sub-daily schedule: %v", next)
+	}
+	schedule.At = ""
+	if next := schedule.Next(now); next != now.Add(6*time.Hour) {
+		t.Errorf("Unexpected next run of unaligned schedule: %v", next)
+	}
+	for _, bad := range []Schedule{
+		{Command: "status", Every: "30s", Room: "ops"},
+		{Command: "status", Every: "1h", At: "2am", Room: "ops"},
+		{Command: "status", Every: "1h"},
+		{Every: "1h", Room: "ops"},
+	} {
+		if err := bad.verify(); err == nil {
+			t.Errorf("Expected schedule to be rejected: %+v", bad)
+		}
+	}
+}
+
+func TestCogAuthentication(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RELAY_DYNAMIC_CONFIG_ROOT", "/tmp/relay_dyn")
+	config, err := RawConfig(fullConfig).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Cog.SSLClientCert = "/etc/relay/client.pem"
+	config.Cog.SSLClientKey = "/etc/relay/client-key.pem"
+	if err := config.Verify(); err != errorCogClientCertWithoutSSL {
+		t.Errorf("Expected errorCogClientCertWithoutSSL: %v", err)
+	}
+	config.Cog.SSLEnabled = true
+	config.Cog.Token = ""
+	if err := config.Cog.verifyAuth(); err != nil {
+		t.Errorf("Expected client certificate to replace token: %v", err)
+	}
+	config.Cog.SSLClientCert = ""
+	config.Cog.SSLClientKey = ""
+	if err := config.Cog.verifyAuth(); err != errorMissingCogToken {
+		t.Errorf("Expected errorMissingCogToken: %v", err)
+	}
+}
+
+func TestCogPins(t *testing.T) {
+	cog := &CogInfo{
+		SSLEnabled: true,
+		SSLPins:    "sha256/47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=, 47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=",
+	}
+	if pins := cog.Pins(); len(pins) != 2 {
+		t.Errorf("Unexpected pins: %v", pins)
+	}
+	if err := cog.verifyPins(); err != nil {
+		t.Error(err)
+	}
+	cog.SSLPins = "sha256/dGVzdA=="
+	if err := cog.verifyPins(); err == nil {
+		t.Error("Expected short hash to be rejected")
+	}
+	cog.SSLPins = "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="
+	cog.SSLEnabled = false
+	if err := cog.verifyPins(); err != errorCogPinsWithoutSSL {
+		t.Errorf("Expected errorCogPinsWithoutSSL: %v", err)
+	}
+}
+
+func TestCogDelivery(t *testing.T) {
+	os.Clearenv()
+	config, err := RawConfig(`id: 2bba0d1f-a30c-45ec-87e6-e4c5d8c6104f
+version: 1
+cog:
+  token: wubba
+  command_qos: 2
+  response_qos: 0
+`).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cog := config.Cog
+	if cog.CommandQoSLevel() != 2 || cog.ResponseQoSLevel() != 0 || cog.AnnouncementQoSLevel() != 1 {
+		t.Errorf("Unexpected QoS levels: %s %s %s", cog.CommandQoS, cog.ResponseQoS, cog.AnnouncementQoS)
+	}
+	cog.AnnouncementQoS = "3"
+	if err := cog.verifyQoS(); err == nil {
+		t.Error("Expected QoS 3 to be rejected")
+	}
+}
+
+func TestCogReconnect(t *testing.T) {
+	os.Clearenv()
+	config, err := RawConfig(`id: 2bba0d1f-a30c-45ec-87e6-e4c5d8c6104f
+version: 1
+cog:
+  token: wubba
+  reconnect_max_interval: 2m
+  reconnect_max_attempts: 12
+`).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cog := config.Cog
+	if cog.MaxBackoffDuration() != 2*time.Minute || cog.MaxReconnects != 12 {
+		t.Errorf("Unexpected reconnect settings: %s %d", cog.MaxBackoff, cog.MaxReconnects)
+	}
+	cog.MaxBackoff = "500ms"
+	if err := cog.verifyReconnect(); err != errorBadMaxBackoff {
+		t.Errorf("Expected errorBadMaxBackoff: %v", err)
+	}
+	cog.MaxBackoff = "90s"
+	cog.MaxReconnects = -1
+	if err := cog.verifyReconnect(); err != errorBadMaxReconnects {
+		t.Errorf("Expected errorBadMaxReconnects: %v", err)
+	}
+	cog.MaxReconnects = 0
+	if cog.FlapLimit != 5 || cog.FlapWindowDuration() != 5*time.Minute || cog.FlapCooldownDuration() != 5*time.Minute {
+		t.Errorf("Unexpected flap defaults: %d %s %s", cog.FlapLimit, cog.FlapWindow, cog.FlapCooldown)
+	}
+	cog.FlapLimit = 1
+	if err := cog.verifyReconnect(); err != errorBadReconnectFlapLimit {
+		t.Errorf("Expected errorBadReconnectFlapLimit: %v", err)
+	}
+	cog.FlapLimit = 3
+	cog.FlapCooldown = "never"
+	if err := cog.verifyReconnect(); err != errorBadReconnectFlapCooldown {
+		t.Errorf("Expected errorBadReconnectFlapCooldown: %v", err)
+	}
+	cog.FlapLimit = 0
+	if err := cog.verifyReconnect(); err != nil {
+		t.Errorf("Expected disabled breaker settings to be ignored: %v", err)
+	}
+}
+
+func TestCogReannounce(t *testing.T) {
+	os.Clearenv()
+	config, err := RawConfig(`id: 2bba0d1f-a30c-45ec-87e6-e4c5d8c6104f
+version: 1
+cog:
+  token: wubba
+  reannounce_interval: 10m
+`).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Cog.ReannounceDuration() != 10*time.Minute {
+		t.Errorf("Unexpected reannounce interval: %s", config.Cog.Reannounce)
+	}
+	config.Cog.Reannounce = "-1s"
+	if err := config.Cog.verifyReannounce(); err != errorBadReannounceInterval {
+		t.Errorf("Expected errorBadReannounceInterval: %v", err)
+	}
+}
+
+func TestCogCompression(t *testing.T) {
+	os.Clearenv()
+	config, err := RawConfig(`id: 2bba0d1f-a30c-45ec-87e6-e4c5d8c6104f
+version: 1
+cog:
+  token: wubba
+  compression: gzip
+`).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cog := config.Cog
+	if cog.Compression != "gzip" || cog.CompressAbove != 65536 {
+		t.Errorf("Unexpected compression settings: %s %d", cog.Compression, cog.CompressAbove)
+	}
+	cog.Compression = "zstd"
+	if err := cog.verifyCompression(); err != errorBadCompression {
+		t.Errorf("Expected errorBadCompression: %v", err)
+	}
+}
+
+func TestCogPayloadKey(t *testing.T) {
+	os.Clearenv()
+	config, err := RawConfig(`id: 2bba0d1f-a30c-45ec-87e6-e4c5d8c6104f
+version: 1
+cog:
+  token: wubba
+  payload_key: BwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHBwc=
+  payload_key_id: "2024-01"
+`).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cog := config.Cog
+	if len(cog.PayloadKeyBytes()) != 32 {
+		t.Errorf("Expected 32 byte key: %v", cog.PayloadKeyBytes())
+	}
+	if sanitized := config.Sanitized(); sanitized.Cog.PayloadKey != redacted {
+		t.Errorf("Expected payload key to be redacted: %s", sanitized.Cog.PayloadKey)
+	}
+	cog.PayloadKey = "c2hvcnQ="
+	if err := cog.verifyPayloadKey(); err != errorBadPayloadKey {
+		t.Errorf("Expected errorBadPayloadKey: %v", err)
+	}
+	cog.PayloadKey = ""
+	if err := cog.verifyPayloadKey(); err != errorPayloadKeyIDWithoutKey {
+		t.Errorf("Expected errorPayloadKeyIDWithoutKey: %v", err)
+	}
+}
+
+func TestAssignmentFlapLimit(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RELAY_DYNAMIC_CONFIG_ROOT", "/tmp/relay_dyn")
+	config, err := RawConfig(fullConfig).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.AssignmentFlapLimit != 0 {
+		t.Errorf("Expected flap dampening to be disabled by default: %d", config.AssignmentFlapLimit)
+	}
+	config.AssignmentFlapLimit = 1
+	if err := config.Verify(); err != errorBadFlapLimit {
+		t.Errorf("Expected errorBadFlapLimit: %v", err)
+	}
+}
+
+func TestCogConnectionTuning(t *testing.T) {
+	os.Clearenv()
+	config, err := RawConfig(`id: 2bba0d1f-a30c-45ec-87e6-e4c5d8c6104f
+version: 1
+cog:
+  token: wubba
+  keepalive_interval: 5m
+  write_timeout: 45s
+  max_inflight: 4
+`).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cog := config.Cog
+	if cog.KeepAliveDuration() != 5*time.Minute || cog.PingTimeoutDuration() != 15*time.Second ||
+		cog.ConnectTimeoutDuration() != 30*time.Second || cog.WriteTimeoutDuration() != 45*time.Second {
+		t.Errorf("Unexpected timing: %s %s %s %s", cog.KeepAlive, cog.PingTimeout, cog.ConnectTimeout, cog.WriteTimeout)
+	}
+	if cog.MaxInFlight != 4 {
+		t.Errorf("Expected max_inflight 4: %d", cog.MaxInFlight)
+	}
+	cog.PingTimeout = "100ms"
+	if err := cog.verifyConnectionTuning(); err == nil {
+		t.Error("Expected sub-second ping_timeout to be rejected")
+	}
+}
+
+func TestCogTopicPrefix(t *testing.T) {
+	cog := &CogInfo{TopicPrefix: "staging/eu"}
+	if err := cog.verifyTopicPrefix(); err != nil {
+		t.Error(err)
+	}
+	for _, prefix := range []string{"/staging", "staging/", "staging/#", "+"} {
+		cog.TopicPrefix = prefix
+		if err := cog.verifyTopicPrefix(); err != errorBadTopicPrefix {
+			t.Errorf("Expected prefix %s to be rejected: %v", prefix, err)
+		}
+	}
+}
+
+func TestBusType(t *testing.T) {
+	os.Clearenv()
+	config, err := RawConfig(fullConfig).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Bus == nil || config.Bus.Type != "mqtt" {
+		t.Errorf("Expected default bus type mqtt: %v", config.Bus)
+	}
+	config.Bus.Type = ""
+	if err := config.Bus.verify(); err != errorMissingBusType {
+		t.Errorf("Expected errorMissingBusType: %v", err)
+	}
+}
+
+func TestBridge(t *testing.T) {
+	os.Clearenv()
+	config, err := RawConfig(fullConfig).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bridge := config.Bridge
+	if bridge == nil || bridge.Enabled == true || bridge.Port != 1883 {
+		t.Fatalf("Expected bridging disabled by default: %v", bridge)
+	}
+	if len(bridge.InboundTopics()) != 4 || bridge.InboundTopics()[0] != "/bot/commands/#" {
+		t.Errorf("Unexpected default inbound topics: %v", bridge.InboundTopics())
+	}
+	bridge.Enabled = true
+	if err := bridge.verify(); err != errorMissingBridgeHost {
+		t.Errorf("Expected errorMissingBridgeHost: %v", err)
+	}
+	bridge.Host = "mqtt.internal"
+	bridge.Deny = " bot/relays/info , "
+	if err := bridge.verify(); err != nil {
+		t.Error(err)
+	}
+	if denied := bridge.DeniedTopics(); len(denied) != 1 || denied[0] != "bot/relays/info" {
+		t.Errorf("Unexpected denied topics: %v", denied)
+	}
+	bridge.Outbound = "/bot/pipelines/#/reply"
+	if err := bridge.verify(); err == nil {
+		t.Error("Expected # before the last topic level to be rejected")
+	}
+	bridge.Inbound = ""
+	bridge.Outbound = ""
+	if err := bridge.verify(); err != errorEmptyBridgeRules {
+		t.Errorf("Expected errorEmptyBridgeRules: %v", err)
+	}
+}
+
+func TestMessageEncoding(t *testing.T) {
+	os.Clearenv()
+	config, err := RawConfig(fullConfig).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Cog.Encoding != "json" {
+		t.Errorf("Expected default message encoding json: %s", config.Cog.Encoding)
+	}
+	config.Cog.Encoding = "msgpack"
+	if err := config.Cog.verifyEncoding(); err != errorBadMessageEncoding {
+		t.Errorf("Expected errorBadMessageEncoding: %v", err)
+	}
+}
+
+func TestMessageValidation(t *testing.T) {
+	os.Clearenv()
+	config, err := RawConfig(fullConfig).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.MessageValidation != "lenient" || config.StrictMessageValidation() == true {
+		t.Errorf("Expected lenient validation by default: %s", config.MessageValidation)
+	}
+	config.MessageValidation = "paranoid"
+	if err := config.verifyMessageValidation(); err != errorBadMessageValidation {
+		t.Errorf("Expected errorBadMessageValidation: %v", err)
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	os.Clearenv()
+	config, err := RawConfig(fullConfig).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Cog.HandshakeDuration() != 5*time.Second {
+		t.Errorf("Expected default handshake_timeout of 5s: %s", config.Cog.Handshake)
+	}
+	config.Cog.Handshake = "0s"
+	if err := config.Cog.verifyHandshake(); err != nil {
+		t.Errorf("Expected 0s to disable the handshake: %s", err)
+	}
+	config.Cog.Handshake = "-1s"
+	if err := config.Cog.verifyHandshake(); err != errorBadHandshakeTimeout {
+		t.Errorf("Expected errorBadHandshakeTimeout: %v", err)
+	}
+}
+
+func TestHeartbeatInterval(t *testing.T) {
+	os.Clearenv()
+	config, err := RawConfig(fullConfig).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.HeartbeatDuration() != 0 {
+		t.Errorf("Expected heartbeats disabled by default: %s", config.HeartbeatInterval)
+	}
+	config.HeartbeatInterval = "30s"
+	if err := config.verifyHeartbeat(); err != nil || config.HeartbeatDuration() != 30*time.Second {
+		t.Errorf("Expected 30s heartbeat interval: %v", err)
+	}
+	config.HeartbeatInterval = "often"
+	if err := config.verifyHeartbeat(); err != errorBadHeartbeatInterval {
+		t.Errorf("Expected errorBadHeartbeatInterval: %v", err)
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	os.Clearenv()
+	config, err := RawConfig(fullConfig).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.DrainDuration() != 30*time.Second {
+		t.Errorf("Expected default drain_timeout of 30s: %s", config.DrainTimeout)
+	}
+	config.DrainTimeout = "-5s"
+	if err := config.verifyDrainTimeout(); err != errorBadDrainTimeout {
+		t.Errorf("Expected errorBadDrainTimeout: %v", err)
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RELAY_DYNAMIC_CONFIG_ROOT", "/tmp/relay_dyn")
+	os.Setenv("RELAY_SELF_TEST_ENABLED", "true")
+	config, err := RawConfig(fullConfig).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.SelfTest.Command != "/bin/echo" || config.SelfTest.DockerImage != "alpine" ||
+		config.SelfTest.TimeoutDuration() != time.Minute {
+		t.Errorf("Unexpected self-test defaults: %+v", config.SelfTest)
+	}
+	config.SelfTest.Timeout = "soon"
+	if err := config.SelfTest.verify(); err != errorBadSelfTestTimeout {
+		t.Errorf("Expected errorBadSelfTestTimeout: %v", err)
+	}
+	config.SelfTest.Timeout = "10s"
+	config.SelfTest.Command = ""
+	if err := config.SelfTest.verify(); err != errorMissingSelfTestCommand {
+		t.Errorf("Expected errorMissingSelfTestCommand: %v", err)
+	}
+}
+
+func TestAutoscaleWorkers(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RELAY_DYNAMIC_CONFIG_ROOT", "/tmp/relay_dyn")
+	os.Setenv("RELAY_AUTOSCALE_WORKERS", "true")
+	os.Setenv("RELAY_MIN_WORKERS", "1")
+	config, err := RawConfig(fullConfig).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.AutoscaleWorkers == false || config.MinWorkers != 1 {
+		t.Errorf("Unexpected autoscaling settings: %v %d", config.AutoscaleWorkers, config.MinWorkers)
+	}
+	if err := config.Verify(); err != nil {
+		t.Error(err)
+	}
+	config.MinWorkers = config.MaxConcurrent + 1
+	if err := config.Verify(); err != errorBadMinWorkers {
+		t.Errorf("Expected errorBadMinWorkers: %v", err)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RELAY_DYNAMIC_CONFIG_ROOT", "/tmp/relay_dyn")
+	config, err := RawConfig(fullConfig).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Hooks.Enabled() == true || config.Hooks.TimeoutDuration() != 30*time.Second {
+		t.Errorf("Unexpected hook defaults: %+v", config.Hooks)
+	}
+	os.Setenv("RELAY_HOOK_READY", "/usr/local/bin/relay-ready")
+	os.Setenv("RELAY_HOOK_TIMEOUT", "later")
+	config, err = RawConfig(fullConfig).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Hooks.Enabled() == false {
+		t.Error("Expected hooks to be enabled")
+	}
+	if err := config.Hooks.verify(); err != errorBadHookTimeout {
+		t.Errorf("Expected errorBadHookTimeout: %v", err)
+	}
+}
+
+func TestEventLogSize(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RELAY_DYNAMIC_CONFIG_ROOT", "/tmp/relay_dyn")
+	config, err := RawConfig(fullConfig).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.EventLogSize != 1000 {
+		t.Errorf("Unexpected event_log_size default: %d", config.EventLogSize)
+	}
+	config.EventLogSize = -1
+	if err := config.Verify(); err != errorBadEventLogSize {
+		t.Errorf("Expected errorBadEventLogSize: %v", err)
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RELAY_DYNAMIC_CONFIG_ROOT", "/tmp/relay_dyn")
+	os.Setenv("RELAY_WATCHDOG_ENABLED", "true")
+	config, err := RawConfig(fullConfig).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Watchdog.Checks != 3 || config.Watchdog.IntervalDuration() != 30*time.Second {
+		t.Errorf("Unexpected watchdog defaults: %+v", config.Watchdog)
+	}
+	if err := config.Watchdog.verify(); err != errorMissingWatchdogLimit {
+		t.Errorf("Expected errorMissingWatchdogLimit: %v", err)
+	}
+	config.Watchdog.MaxMemoryMB = 512
+	config.Watchdog.Checks = 0
+	if err := config.Watchdog.verify(); err != errorBadWatchdogChecks {
+		t.Errorf("Expected errorBadWatchdogChecks: %v", err)
+	}
+	config.Watchdog.Checks = 1
+	if err := config.Watchdog.verify(); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
+
+func TestStuckWorkerMultiple(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RELAY_DYNAMIC_CONFIG_ROOT", "/tmp/relay_dyn")
+	config, err := RawConfig(fullConfig).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.StuckWorkerMultiple != 3 {
+		t.Errorf("Unexpected stuck_worker_multiple default: %d", config.StuckWorkerMultiple)
+	}
+	config.StuckWorkerMultiple = -1
+	if err := config.Verify(); err != errorBadStuckWorkerMultiple {
+		t.Errorf("Expected errorBadStuckWorkerMultiple: %v", err)
+	}
+}
+
+func TestBundlePriority(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RELAY_DYNAMIC_CONFIG_ROOT", "/tmp/relay_dyn")
+	config, err := RawConfig(fullConfig).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Bundles = map[string]*BundleSettings{
+		"reports": &BundleSettings{Priority: "later"},
+	}
+	if err := config.verifyBundleSettings(); err == nil {
+		t.Error("Expected unknown priority to be rejected")
+	}
+	config.Bundles["reports"].Priority = BackgroundPriority
+	if err := config.verifyBundleSettings(); err != nil {
+		t.Error(err)
+	}
+	if config.SettingsForBundle("reports").IsBackground() == false || config.SettingsForBundle("chat").IsBackground() == true {
+		t.Error("Unexpected bundle priorities")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RELAY_DYNAMIC_CONFIG_ROOT", "/tmp/relay_dyn")
+	config, err := RawConfig(fullConfig).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.RateLimit.Enabled() == true {
+		t.Error("Expected rate limits to be disabled by default")
+	}
+	os.Setenv("RELAY_RATE_LIMIT_USER_RATE", "30")
+	os.Setenv("RELAY_RATE_LIMIT_BUNDLE_BURST", "-1")
+	config, err = RawConfig(fullConfig).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.RateLimit.Enabled() == false || config.RateLimit.UserRate != 30 {
+		t.Errorf("Unexpected rate limits: %+v", config.RateLimit)
+	}
+	if err := config.RateLimit.verify(); err != errorBadRateLimit {
+		t.Errorf("Expected errorBadRateLimit: %v", err)
+	}
+}
+
+func TestDurableQueue(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RELAY_DYNAMIC_CONFIG_ROOT", "/tmp/relay_dyn")
+	config, err := RawConfig(fullConfig).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.DurableQueue == true || config.RerunQueuedRequests() == false {
+		t.Errorf("Unexpected durable queue defaults: %v %s", config.DurableQueue, config.QueueRecovery)
+	}
+	config.DurableQueue = true
+	config.StateDir = ""
+	if err := config.Verify(); err != errorDurableQueueNeedsStateDir {
+		t.Errorf("Expected errorDurableQueueNeedsStateDir: %v", err)
+	}
+	config.StateDir = "/var/lib/relay"
+	config.QueueRecovery = "later"
+	if err := config.Verify(); err != errorBadQueueRecovery {
+		t.Errorf("Expected errorBadQueueRecovery: %v", err)
+	}
+	config.QueueRecovery = "fail"
+	if config.RerunQueuedRequests() == true {
+		t.Error("Expected queued requests to be failed")
+	}
+}
+
+func TestExecutionRetries(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RELAY_DYNAMIC_CONFIG_ROOT", "/tmp/relay_dyn")
+	os.Setenv("RELAY_EXECUTION_RETRIES", "2")
+	config, err := RawConfig(fullConfig).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ExecutionRetries != 2 || config.ExecutionRetryBackoffDuration() != 2*time.Second ||
+		config.DeadLetterSize != 100 {
+		t.Errorf("Unexpected retry settings: %d %s %d", config.ExecutionRetries,
+			config.ExecutionRetryBackoff, config.DeadLetterSize)
+	}
+	config.ExecutionRetryBackoff = "soon"
+	if err := config.Verify(); err != errorBadRetryBackoff {
+		t.Errorf("Expected errorBadRetryBackoff: %v", err)
+	}
+	config.ExecutionRetryBackoff = "1s"
+	config.ExecutionRetries = -1
+	if err := config.Verify(); err != errorBadExecutionRetries {
+		t.Errorf("Expected errorBadExecutionRetries: %v", err)
+	}
+}
+
+func TestBackpressure(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RELAY_DYNAMIC_CONFIG_ROOT", "/tmp/relay_dyn")
+	os.Setenv("RELAY_BACKPRESSURE_ENABLED", "true")
+	config, err := RawConfig(fullConfig).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Backpressure.HighWater != 80 || config.Backpressure.LowWater != 50 || config.Backpressure.Reject == true {
+		t.Errorf("Unexpected backpressure defaults: %+v", config.Backpressure)
+	}
+	config.Backpressure.LowWater = 90
+	if err := config.Verify(); err != errorBadBackpressureLevels {
+		t.Errorf("Expected errorBadBackpressureLevels: %v", err)
+	}
+}
+
+func TestDedupWindow(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RELAY_DYNAMIC_CONFIG_ROOT", "/tmp/relay_dyn")
+	config, err := RawConfig(fullConfig).Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.DedupWindowDuration() != 10*time.Minute {
+		t.Errorf("Unexpected default dedup window: %s", config.DedupWindow)
+	}
+	config.DedupWindow = "-1m"
+	if err := config.Verify(); err != errorBadDedupWindow {
+		t.Errorf("Expected errorBadDedupWindow: %v", err)
+	}
+	config.DedupWindow = "0s"
+	if err := config.Verify(); err != nil || config.DedupWindowDuration() != 0 {
+		t.Errorf("Expected 0s to disable duplicate detection: %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// How a config change takes effect
+const (
+	HotApply     = "hot"
+	RestartApply = "restart"
+)
+
+// How settings which are picked up from a reloaded config file are
+// applied
+const reloadApplyWith = "SIGHUP or POST /config/reload on the admin API"
+
+// hotSettings can be changed on a running Relay without a restart.
+// Everything else is only read at startup. bundles covers every
+// setting under bundles/.
+var hotSettings = map[string]string{
+	"read_only":                     "POST /read-only on the admin API, a Cog directive, " + reloadApplyWith,
+	"read_only_message":             "POST /read-only on the admin API, a Cog directive, " + reloadApplyWith,
+	"log_level":                     reloadApplyWith,
+	"max_concurrent":                reloadApplyWith,
+	"execution_timeout":             reloadApplyWith,
+	"queue_ttl":                     reloadApplyWith,
+	"bundles":                       reloadApplyWith,
+	"cog/refresh_interval":          reloadApplyWith,
+	"docker/container_memory":       reloadApplyWith,
+	"docker/clean_interval":         reloadApplyWith,
+	"docker/pull_retries":           reloadApplyWith,
+	"docker/pull_retry_delay":       reloadApplyWith,
+	"docker/pull_progress_interval": reloadApplyWith,
+	"docker/shutdown_grace_period":  reloadApplyWith,
+	"docker/log_excerpt_size":       reloadApplyWith,
+}
+
+// hotSetting returns the hotSettings entry covering setting
+func hotSetting(setting string) (string, bool) {
+	if strings.HasPrefix(setting, "bundles/") {
+		setting = "bundles"
+	}
+	_, ok := hotSettings[setting]
+	return setting, ok
+}
+
+// ConfigChange describes a setting which differs between two configs.
+// Secret values are redacted.
+type ConfigChange struct {
+	Setting   string `json:"setting"`
+	Current   string `json:"current"`
+	Candidate string `json:"candidate"`
+	Apply     string `json:"apply"`
+	ApplyWith string `json:"apply_with,omitempty"`
+}
+
+// Diff returns the effective settings which differ between c and
+// candidate, sorted by setting name. Settings are named by their YAML
+// path, e.g. docker/socket_path.
+func (c *Config) Diff(candidate *Config) []ConfigChange {
+	current := flattenConfig(c)
+	proposed := flattenConfig(candidate)
+	currentSanitized := c.Sanitized()
+	proposedSanitized := candidate.Sanitized()
+	currentDisplay := flattenConfig(&currentSanitized)
+	proposedDisplay := flattenConfig(&proposedSanitized)
+	settings := map[string]bool{}
+	for setting := range current {
+		settings[setting] = true
+	}
+	for setting := range proposed {
+		settings[setting] = true
+	}
+	changes := []ConfigChange{}
+	for setting := range settings {
+		if current[setting] == proposed[setting] {
+			continue
+		}
+		change := ConfigChange{
+			Setting:   setting,
+			Current:   currentDisplay[setting],
+			Candidate: proposedDisplay[setting],
+			Apply:     RestartApply,
+		}
+		if hot, ok := hotSetting(setting); ok {
+			change.Apply = HotApply
+			change.ApplyWith = hotSettings[hot]
+		}
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Setting < changes[j].Setting
+	})
+	return changes
+}
+
+// flattenConfig maps the YAML path of every setting to its value.
+// Fields without a yaml tag are derived from other settings and are
+// skipped.
+func flattenConfig(c *Config) map[string]string {
+	settings := map[string]string{}
+	flattenValue(reflect.ValueOf(*c), "", settings)
+	return settings
+}
+
+func flattenValue(value reflect.Value, prefix string, settings map[string]string) {
+	switch value.Kind() {
+	case reflect.Ptr:
+		if value.IsNil() == false {
+			flattenValue(value.Elem(), prefix, settings)
+		}
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			name := strings.Split(value.Type().Field(i).Tag.Get("yaml"), ",")[0]
+			if name == "" || name == "-" {
+				continue
+			}
+			flattenValue(value.Field(i), joinSetting(prefix, name), settings)
+		}
+	case reflect.Map:
+		for _, key := range value.MapKeys() {
+			flattenValue(value.MapIndex(key), joinSetting(prefix, fmt.Sprintf("%v", key.Interface())), settings)
+		}
+	default:
+		if value.IsValid() {
+			settings[prefix] = fmt.Sprintf("%v", value.Interface())
+		}
+	}
+}
+
+func joinSetting(prefix string, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "/" + name
+}
@@ -2,22 +2,80 @@ package config
 
 import (
 	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
 	"time"
 )
 
 var errorBadCleanInterval = errors.New("Error parsing docker/clean_interval")
+var errorBadPullRetryDelay = errors.New("Error parsing docker/pull_retry_delay")
+var errorBadPullProgressInterval = errors.New("Error parsing docker/pull_progress_interval")
+var errorBadShutdownGracePeriod = errors.New("Error parsing docker/shutdown_grace_period")
+var errorTLSRequiresTCP = errors.New("Setting docker/cert_path requires a tcp:// docker/socket_path")
+var errorBadScratchPath = errors.New("docker/scratch_path must be an absolute path")
+var errorBadScratchSize = errors.New("docker/scratch_size must be greater than zero")
+var errorBadPullParallelism = errors.New("docker/pull_parallelism must be greater than zero")
+var errorBadContainerUser = errors.New("docker/container_user must be a non-root numeric UID or UID:GID")
+var errorBadDockerHost = errors.New("docker/hosts entries must begin with unix:// or tcp://")
+var errorBadDockerBalance = errors.New("docker/balance must be least-loaded or round-robin")
+var errorDockerHostsWithEnv = errors.New("docker/hosts can't be used with docker/use_env")
+var errorBadRegistryCredentials = errors.New("docker/registry_credentials must be ecr, gcr or acr")
+var errorConflictingRegistryCredentials = errors.New("docker/registry_credentials can't be used with docker/registry_password")
+
+// Names of the files expected in docker/cert_path. These match the
+// layout used by the Docker CLI's $DOCKER_CERT_PATH.
+const (
+	dockerCAFile   = "ca.pem"
+	dockerCertFile = "cert.pem"
+	dockerKeyFile  = "key.pem"
+)
+
+// Cloud registry credential helpers supported by docker/registry_credentials
+const (
+	ECRCredentials = "ecr"
+	GCRCredentials = "gcr"
+	ACRCredentials = "acr"
+)
+
+// Strategies for distributing executions across docker/hosts
+const (
+	LeastLoadedBalance = "least-loaded"
+	RoundRobinBalance  = "round-robin"
+)
 
 // DockerInfo contains information required to interact with dockerd and external Docker registries
 type DockerInfo struct {
-	UseEnv               bool   `yaml:"use_env" env:"RELAY_DOCKER_USE_ENV" valid:"-" default:"false"`
-	SocketPath           string `yaml:"socket_path" env:"RELAY_DOCKER_SOCKET_PATH" valid:"dockersocket,required" default:"unix:///var/run/docker.sock"`
-	ContainerMemory      int    `yaml:"container_memory" env:"RELAY_DOCKER_CONTAINER_MEMORY" valid:"required" default:"16"`
-	CleanInterval        string `yaml:"clean_interval" env:"RELAY_DOCKER_CLEAN_INTERVAL" valid:"required" default:"5m"`
-	CommandDriverVersion string `yaml:"command_driver_version" env:"RELAY_DOCKER_CIRCUIT_DRIVER_VERSION" valid:"required"`
-	RegistryHost         string `yaml:"registry_host" env:"RELAY_DOCKER_REGISTRY_HOST" valid:"host,required" default:"index.docker.io"`
-	RegistryUser         string `yaml:"registry_user" env:"RELAY_DOCKER_REGISTRY_USER" valid:"-"`
-	RegistryEmail        string `yaml:"registry_email" env:"RELAY_DOCKER_REGISTRY_EMAIL" valid:"-"`
-	RegistryPassword     string `yaml:"registry_password" env:"RELAY_DOCKER_REGISTRY_PASSWORD" valid:"-"`
+	UseEnv               bool              `yaml:"use_env" env:"RELAY_DOCKER_USE_ENV" valid:"-" default:"false"`
+	SocketPath           string            `yaml:"socket_path" env:"RELAY_DOCKER_SOCKET_PATH" valid:"dockersocket,required" default:"unix:///var/run/docker.sock"`
+	Hosts                []string          `yaml:"hosts" valid:"-"`
+	Balance              string            `yaml:"balance" env:"RELAY_DOCKER_BALANCE" valid:"-" default:"least-loaded"`
+	ContainerMemory      int               `yaml:"container_memory" env:"RELAY_DOCKER_CONTAINER_MEMORY" valid:"required" default:"16"`
+	CleanInterval        string            `yaml:"clean_interval" env:"RELAY_DOCKER_CLEAN_INTERVAL" valid:"required" default:"5m"`
+	PullParallelism      int               `yaml:"pull_parallelism" env:"RELAY_DOCKER_PULL_PARALLELISM" valid:"-" default:"4"`
+	PullRetries          int               `yaml:"pull_retries" env:"RELAY_DOCKER_PULL_RETRIES" valid:"-" default:"3"`
+	PullRetryDelay       string            `yaml:"pull_retry_delay" env:"RELAY_DOCKER_PULL_RETRY_DELAY" valid:"-" default:"2s"`
+	PullProgressInterval string            `yaml:"pull_progress_interval" env:"RELAY_DOCKER_PULL_PROGRESS_INTERVAL" valid:"-" default:"15s"`
+	ShutdownGracePeriod  string            `yaml:"shutdown_grace_period" env:"RELAY_DOCKER_SHUTDOWN_GRACE_PERIOD" valid:"-" default:"10s"`
+	CommandDriverVersion string            `yaml:"command_driver_version" env:"RELAY_DOCKER_CIRCUIT_DRIVER_VERSION" valid:"required"`
+	RegistryHost         string            `yaml:"registry_host" env:"RELAY_DOCKER_REGISTRY_HOST" valid:"host,required" default:"index.docker.io"`
+	RegistryUser         string            `yaml:"registry_user" env:"RELAY_DOCKER_REGISTRY_USER" valid:"-"`
+	RegistryEmail        string            `yaml:"registry_email" env:"RELAY_DOCKER_REGISTRY_EMAIL" valid:"-"`
+	RegistryPassword     string            `yaml:"registry_password" env:"RELAY_DOCKER_REGISTRY_PASSWORD" valid:"-"`
+	RegistryCredentials  string            `yaml:"registry_credentials" env:"RELAY_DOCKER_REGISTRY_CREDENTIALS" valid:"-"`
+	CertPath             string            `yaml:"cert_path" env:"RELAY_DOCKER_CERT_PATH" valid:"-"`
+	TLSSkipVerify        bool              `yaml:"tls_skip_verify" env:"RELAY_DOCKER_TLS_SKIP_VERIFY" valid:"bool" default:"false"`
+	DiagnosticsDir       string            `yaml:"diagnostics_dir" env:"RELAY_DOCKER_DIAGNOSTICS_DIR" valid:"-"`
+	LogExcerptSize       int               `yaml:"log_excerpt_size" env:"RELAY_DOCKER_LOG_EXCERPT_SIZE" valid:"-" default:"2048"`
+	SeccompProfiles      map[string]string `yaml:"seccomp_profiles" valid:"-"`
+	UsernsRemap          bool              `yaml:"userns_remap" env:"RELAY_DOCKER_USERNS_REMAP" valid:"bool" default:"false"`
+	ContainerUser        string            `yaml:"container_user" env:"RELAY_DOCKER_CONTAINER_USER" valid:"-"`
+	ReadOnlyRootfs       bool              `yaml:"read_only_rootfs" env:"RELAY_DOCKER_READ_ONLY_ROOTFS" valid:"bool" default:"false"`
+	ScratchPath          string            `yaml:"scratch_path" env:"RELAY_DOCKER_SCRATCH_PATH" valid:"-" default:"/tmp"`
+	ScratchSize          int               `yaml:"scratch_size" env:"RELAY_DOCKER_SCRATCH_SIZE" valid:"-" default:"64"`
+	DevBuilds            map[string]string `yaml:"dev_builds" valid:"-"`
 }
 
 // CleanDuration returns CleanInterval as a time.Duration
@@ -28,3 +86,198 @@ func (di *DockerInfo) CleanDuration() time.Duration {
 	}
 	return duration
 }
+
+// PullRetryDelayDuration returns PullRetryDelay as a time.Duration
+func (di *DockerInfo) PullRetryDelayDuration() time.Duration {
+	duration, err := time.ParseDuration(di.PullRetryDelay)
+	if err != nil {
+		panic(errorBadPullRetryDelay)
+	}
+	return duration
+}
+
+// PullProgressDuration returns PullProgressInterval as a time.Duration
+func (di *DockerInfo) PullProgressDuration() time.Duration {
+	duration, err := time.ParseDuration(di.PullProgressInterval)
+	if err != nil || duration <= 0 {
+		panic(errorBadPullProgressInterval)
+	}
+	return duration
+}
+
+// ShutdownGraceDuration returns ShutdownGracePeriod as a time.Duration
+func (di *DockerInfo) ShutdownGraceDuration() time.Duration {
+	duration, err := time.ParseDuration(di.ShutdownGracePeriod)
+	if err != nil {
+		panic(errorBadShutdownGracePeriod)
+	}
+	return duration
+}
+
+// TLSEnabled returns true when Relay should use TLS and client
+// certificates to talk to a remote Docker daemon
+func (di *DockerInfo) TLSEnabled() bool {
+	return di.CertPath != ""
+}
+
+// CAFile returns the path to the CA certificate used to verify the Docker daemon
+func (di *DockerInfo) CAFile() string {
+	return path.Join(di.CertPath, dockerCAFile)
+}
+
+// CertFile returns the path to Relay's Docker client certificate
+func (di *DockerInfo) CertFile() string {
+	return path.Join(di.CertPath, dockerCertFile)
+}
+
+// KeyFile returns the path to Relay's Docker client key
+func (di *DockerInfo) KeyFile() string {
+	return path.Join(di.CertPath, dockerKeyFile)
+}
+
+// Endpoints returns the addresses of every Docker daemon Relay should
+// use. docker/hosts takes precedence over docker/socket_path.
+func (di *DockerInfo) Endpoints() []string {
+	if len(di.Hosts) > 0 {
+		return di.Hosts
+	}
+	return []string{di.SocketPath}
+}
+
+// ForHost returns a copy of the Docker settings which connects to the
+// daemon at address
+func (di *DockerInfo) ForHost(address string) DockerInfo {
+	hostConfig := *di
+	hostConfig.SocketPath = address
+	hostConfig.Hosts = nil
+	return hostConfig
+}
+
+func (di *DockerInfo) verifyHosts() error {
+	if di.Balance != LeastLoadedBalance && di.Balance != RoundRobinBalance {
+		return errorBadDockerBalance
+	}
+	if len(di.Hosts) == 0 {
+		return nil
+	}
+	if di.UseEnv {
+		return errorDockerHostsWithEnv
+	}
+	seen := map[string]bool{}
+	for _, host := range di.Hosts {
+		if !strings.HasPrefix(host, "unix://") && !strings.HasPrefix(host, "tcp://") {
+			return errorBadDockerHost
+		}
+		if seen[host] {
+			return fmt.Errorf("Docker host %s is listed more than once in docker/hosts", host)
+		}
+		seen[host] = true
+	}
+	return nil
+}
+
+func (di *DockerInfo) verifyTLS() error {
+	if di.UseEnv || !di.TLSEnabled() {
+		return nil
+	}
+	for _, endpoint := range di.Endpoints() {
+		if !strings.HasPrefix(endpoint, "tcp://") {
+			return errorTLSRequiresTCP
+		}
+	}
+	files := []string{di.CertFile(), di.KeyFile()}
+	if di.TLSSkipVerify == false {
+		files = append(files, di.CAFile())
+	}
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return fmt.Errorf("Error reading Docker TLS file %s: %s", file, err)
+		}
+		if info.IsDir() {
+			return fmt.Errorf("Docker TLS file %s is a directory", file)
+		}
+	}
+	return nil
+}
+
+// ScratchTmpfs returns the tmpfs mounts given to command containers
+// running with a read-only root filesystem
+func (di *DockerInfo) ScratchTmpfs() map[string]string {
+	if di.ReadOnlyRootfs == false {
+		return nil
+	}
+	return map[string]string{
+		di.ScratchPath: fmt.Sprintf("rw,noexec,nosuid,size=%dm,mode=1777", di.ScratchSize),
+	}
+}
+
+// verifyRegistryCredentials ensures registry_credentials names a
+// supported helper. Helper tokens replace the static registry password.
+func (di *DockerInfo) verifyRegistryCredentials() error {
+	switch di.RegistryCredentials {
+	case "":
+		return nil
+	case ECRCredentials, GCRCredentials, ACRCredentials:
+	default:
+		return errorBadRegistryCredentials
+	}
+	if di.RegistryPassword != "" {
+		return errorConflictingRegistryCredentials
+	}
+	return nil
+}
+
+func (di *DockerInfo) verifyPullParallelism() error {
+	if di.PullParallelism <= 0 {
+		return errorBadPullParallelism
+	}
+	return nil
+}
+
+func (di *DockerInfo) verifyScratch() error {
+	if di.ReadOnlyRootfs == false {
+		return nil
+	}
+	if path.IsAbs(di.ScratchPath) == false {
+		return errorBadScratchPath
+	}
+	if di.ScratchSize <= 0 {
+		return errorBadScratchSize
+	}
+	return nil
+}
+
+// verifyDevBuilds ensures every dev_builds directory contains a
+// Dockerfile. Builds are only used in developer mode.
+func (di *DockerInfo) verifyDevBuilds(devMode bool) error {
+	if devMode == false {
+		return nil
+	}
+	for image, dir := range di.DevBuilds {
+		if _, err := os.Stat(path.Join(dir, "Dockerfile")); err != nil {
+			return fmt.Errorf("Build directory for image %s has no Dockerfile: %s", image, err)
+		}
+	}
+	return nil
+}
+
+// verifyContainerUser ensures container_user names a non-root user
+// by number. Names are rejected since they'd be resolved against
+// each bundle image's /etc/passwd.
+func (di *DockerInfo) verifyContainerUser() error {
+	if di.ContainerUser == "" {
+		return nil
+	}
+	parts := strings.Split(di.ContainerUser, ":")
+	if len(parts) > 2 {
+		return errorBadContainerUser
+	}
+	for i, part := range parts {
+		id, err := strconv.Atoi(part)
+		if err != nil || id < 0 || (i == 0 && id == 0) {
+			return errorBadContainerUser
+		}
+	}
+	return nil
+}
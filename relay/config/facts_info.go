@@ -0,0 +1,26 @@
+package config
+
+import (
+	"errors"
+	"time"
+)
+
+var errorBadFactsInterval = errors.New("Error parsing facts/refresh_interval")
+
+// FactsInfo configures collection of host facts exposed to commands
+type FactsInfo struct {
+	Enabled         bool   `yaml:"enabled" env:"RELAY_FACTS_ENABLED" valid:"bool" default:"false"`
+	RefreshInterval string `yaml:"refresh_interval" env:"RELAY_FACTS_REFRESH_INTERVAL" valid:"-" default:"10m"`
+	CloudMetadata   bool   `yaml:"cloud_metadata" env:"RELAY_FACTS_CLOUD_METADATA" valid:"bool" default:"false"`
+	Script          string `yaml:"script" env:"RELAY_FACTS_SCRIPT" valid:"-"`
+	File            string `yaml:"file" env:"RELAY_FACTS_FILE" valid:"-"`
+}
+
+// RefreshDuration returns RefreshInterval as a time.Duration
+func (fi *FactsInfo) RefreshDuration() time.Duration {
+	duration, err := time.ParseDuration(fi.RefreshInterval)
+	if err != nil {
+		panic(errorBadFactsInterval)
+	}
+	return duration
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"errors"
+	"time"
+)
+
+var errorMissingGroupID = errors.New("group/id is required when group/sharding is enabled")
+var errorBadGroupHeartbeat = errors.New("Error parsing group/heartbeat_interval")
+
+// GroupInfo configures a group of Relays which cooperate on the
+// bundles Cog assigns them. Members find each other through heartbeats
+// published on the message bus.
+type GroupInfo struct {
+	ID                string `yaml:"id" env:"RELAY_GROUP_ID" valid:"-"`
+	Sharding          bool   `yaml:"sharding" env:"RELAY_GROUP_SHARDING" valid:"bool" default:"false"`
+	HeartbeatInterval string `yaml:"heartbeat_interval" env:"RELAY_GROUP_HEARTBEAT_INTERVAL" valid:"-" default:"10s"`
+}
+
+// Enabled returns true if Relay belongs to a group
+func (gi *GroupInfo) Enabled() bool {
+	return gi.ID != ""
+}
+
+// HeartbeatDuration returns HeartbeatInterval as a time.Duration
+func (gi *GroupInfo) HeartbeatDuration() time.Duration {
+	duration, err := time.ParseDuration(gi.HeartbeatInterval)
+	if err != nil {
+		panic(errorBadGroupHeartbeat)
+	}
+	return duration
+}
+
+// MemberTTL returns how long a member is kept after its last
+// heartbeat
+func (gi *GroupInfo) MemberTTL() time.Duration {
+	return 3 * gi.HeartbeatDuration()
+}
+
+func (gi *GroupInfo) verify() error {
+	if gi.Sharding == true && gi.ID == "" {
+		return errorMissingGroupID
+	}
+	duration, err := time.ParseDuration(gi.HeartbeatInterval)
+	if err != nil || duration <= 0 {
+		return errorBadGroupHeartbeat
+	}
+	return nil
+}
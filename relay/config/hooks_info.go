@@ -0,0 +1,43 @@
+package config
+
+import (
+	"errors"
+	"time"
+)
+
+var errorBadHookTimeout = errors.New("Error parsing hooks/timeout")
+
+// HooksInfo configures commands Relay runs on lifecycle events. Each
+// is run with /bin/sh -c and is told about the event through
+// $RELAY_HOOK_* environment variables and a JSON document on stdin.
+type HooksInfo struct {
+	Started       string `yaml:"started" env:"RELAY_HOOK_STARTED" valid:"-" default:""`
+	Ready         string `yaml:"ready" env:"RELAY_HOOK_READY" valid:"-" default:""`
+	BundleRefresh string `yaml:"bundle_refresh" env:"RELAY_HOOK_BUNDLE_REFRESH" valid:"-" default:""`
+	Disconnected  string `yaml:"disconnected" env:"RELAY_HOOK_DISCONNECTED" valid:"-" default:""`
+	ShuttingDown  string `yaml:"shutting_down" env:"RELAY_HOOK_SHUTTING_DOWN" valid:"-" default:""`
+	Timeout       string `yaml:"timeout" env:"RELAY_HOOK_TIMEOUT" valid:"-" default:"30s"`
+}
+
+// Enabled returns true if any hook command is configured
+func (hi *HooksInfo) Enabled() bool {
+	return hi.Started != "" || hi.Ready != "" || hi.BundleRefresh != "" ||
+		hi.Disconnected != "" || hi.ShuttingDown != ""
+}
+
+// TimeoutDuration returns Timeout as a time.Duration
+func (hi *HooksInfo) TimeoutDuration() time.Duration {
+	duration, err := time.ParseDuration(hi.Timeout)
+	if err != nil {
+		panic(errorBadHookTimeout)
+	}
+	return duration
+}
+
+func (hi *HooksInfo) verify() error {
+	duration, err := time.ParseDuration(hi.Timeout)
+	if err != nil || duration <= 0 {
+		return errorBadHookTimeout
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// NativeRuntime describes a language runtime version installed on the
+// Relay host, typically by a version manager such as asdf or pyenv
+type NativeRuntime struct {
+	BinDirs []string `yaml:"bin_dirs" valid:"-"`
+	Env     []string `yaml:"env" valid:"-"`
+}
+
+// NativeRuntimeEnv returns the environment variables selecting the
+// native runtimes configured for the named bundle. The runtimes' bin
+// directories are prepended to path in the order they're listed.
+// Returns nil if the bundle selects no runtimes.
+func (c *Config) NativeRuntimeEnv(bundleName string, path string) map[string]string {
+	names := c.SettingsForBundle(bundleName).NativeRuntimes
+	if len(names) == 0 {
+		return nil
+	}
+	env := make(map[string]string)
+	binDirs := []string{}
+	for _, name := range names {
+		runtime := c.NativeRuntimes[name]
+		if runtime == nil {
+			continue
+		}
+		binDirs = append(binDirs, runtime.BinDirs...)
+		for _, v := range runtime.Env {
+			parts := strings.SplitN(v, "=", 2)
+			env[parts[0]] = parts[1]
+		}
+	}
+	if path != "" {
+		binDirs = append(binDirs, path)
+	}
+	env["PATH"] = strings.Join(binDirs, string(os.PathListSeparator))
+	return env
+}
+
+func (c *Config) verifyNativeRuntimes() error {
+	for name, settings := range c.Bundles {
+		if settings == nil {
+			continue
+		}
+		for _, runtime := range settings.NativeRuntimes {
+			if c.NativeRuntimes[runtime] == nil {
+				return fmt.Errorf("Bundle %s references unknown native runtime %s", name, runtime)
+			}
+		}
+	}
+	if c.NativeEnabled() == false {
+		return nil
+	}
+	for name, runtime := range c.NativeRuntimes {
+		if runtime == nil {
+			return fmt.Errorf("Native runtime %s is empty", name)
+		}
+		for _, dir := range runtime.BinDirs {
+			if filepath.IsAbs(dir) == false {
+				return fmt.Errorf("Native runtime %s bin directory must be absolute: %s", name, dir)
+			}
+			if _, err := os.Stat(dir); err != nil {
+				return fmt.Errorf("Native runtime %s is unavailable: %s", name, err)
+			}
+		}
+		for _, v := range runtime.Env {
+			parts := strings.SplitN(v, "=", 2)
+			if len(parts) != 2 || parts[0] == "" {
+				return fmt.Errorf("Illegal environment var specification in native runtime %s: %s", name, v)
+			}
+		}
+	}
+	return nil
+}
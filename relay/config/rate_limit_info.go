@@ -0,0 +1,30 @@
+package config
+
+import (
+	"errors"
+)
+
+var errorBadRateLimit = errors.New("rate_limit rates and bursts must not be negative")
+
+// RateLimitInfo configures token bucket rate limits on commands run
+// by each requestor and each bundle. Rates are in commands per
+// minute; zero disables the limit. A zero burst allows one minute's
+// worth of commands at once.
+type RateLimitInfo struct {
+	UserRate    int `yaml:"user_rate" env:"RELAY_RATE_LIMIT_USER_RATE" valid:"-" default:"0"`
+	UserBurst   int `yaml:"user_burst" env:"RELAY_RATE_LIMIT_USER_BURST" valid:"-" default:"0"`
+	BundleRate  int `yaml:"bundle_rate" env:"RELAY_RATE_LIMIT_BUNDLE_RATE" valid:"-" default:"0"`
+	BundleBurst int `yaml:"bundle_burst" env:"RELAY_RATE_LIMIT_BUNDLE_BURST" valid:"-" default:"0"`
+}
+
+// Enabled returns true if either rate limit is set
+func (rli *RateLimitInfo) Enabled() bool {
+	return rli.UserRate > 0 || rli.BundleRate > 0
+}
+
+func (rli *RateLimitInfo) verify() error {
+	if rli.UserRate < 0 || rli.UserBurst < 0 || rli.BundleRate < 0 || rli.BundleBurst < 0 {
+		return errorBadRateLimit
+	}
+	return nil
+}
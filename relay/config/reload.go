@@ -0,0 +1,73 @@
+package config
+
+// reloadable copies each hot setting from a reloaded config
+var reloadable = map[string]func(c *Config, candidate *Config){
+	"read_only":            func(c *Config, candidate *Config) { c.ReadOnly = candidate.ReadOnly },
+	"read_only_message":    func(c *Config, candidate *Config) { c.ReadOnlyMessage = candidate.ReadOnlyMessage },
+	"log_level":            func(c *Config, candidate *Config) { c.LogLevel = candidate.LogLevel },
+	"max_concurrent":       func(c *Config, candidate *Config) { c.MaxConcurrent = candidate.MaxConcurrent },
+	"execution_timeout":    func(c *Config, candidate *Config) { c.ExecutionTimeout = candidate.ExecutionTimeout },
+	"queue_ttl":            func(c *Config, candidate *Config) { c.QueueTTL = candidate.QueueTTL },
+	"bundles":              func(c *Config, candidate *Config) { c.Bundles = candidate.Bundles },
+	"cog/refresh_interval": func(c *Config, candidate *Config) { c.Cog.RefreshInterval = candidate.Cog.RefreshInterval },
+	"docker/container_memory": func(c *Config, candidate *Config) {
+		c.Docker.ContainerMemory = candidate.Docker.ContainerMemory
+	},
+	"docker/clean_interval": func(c *Config, candidate *Config) {
+		c.Docker.CleanInterval = candidate.Docker.CleanInterval
+	},
+	"docker/pull_retries": func(c *Config, candidate *Config) {
+		c.Docker.PullRetries = candidate.Docker.PullRetries
+	},
+	"docker/pull_retry_delay": func(c *Config, candidate *Config) {
+		c.Docker.PullRetryDelay = candidate.Docker.PullRetryDelay
+	},
+	"docker/pull_progress_interval": func(c *Config, candidate *Config) {
+		c.Docker.PullProgressInterval = candidate.Docker.PullProgressInterval
+	},
+	"docker/shutdown_grace_period": func(c *Config, candidate *Config) {
+		c.Docker.ShutdownGracePeriod = candidate.Docker.ShutdownGracePeriod
+	},
+	"docker/log_excerpt_size": func(c *Config, candidate *Config) {
+		c.Docker.LogExcerptSize = candidate.Docker.LogExcerptSize
+	},
+}
+
+// Clone returns a copy of c which can be changed without affecting
+// c. Only the sections holding hot settings are copied; the rest are
+// shared and must be treated as read only.
+func (c *Config) Clone() *Config {
+	clone := *c
+	if c.Cog != nil {
+		cog := *c.Cog
+		clone.Cog = &cog
+	}
+	if c.Docker != nil {
+		docker := *c.Docker
+		clone.Docker = &docker
+	}
+	return &clone
+}
+
+// Reloaded returns a copy of c with every hot setting which differs in
+// candidate, a freshly loaded and verified copy of the config file,
+// copied over. c itself is left unchanged so it can be read while the
+// copy is built. Returns all differences as well; those whose Apply is
+// RestartApply weren't copied. Callers are responsible for acting on
+// the copied settings, e.g. changing the log level.
+func (c *Config) Reloaded(candidate *Config) (*Config, []ConfigChange) {
+	reloaded := c.Clone()
+	changes := c.Diff(candidate)
+	applied := map[string]bool{}
+	for _, change := range changes {
+		if change.Apply != HotApply {
+			continue
+		}
+		setting, _ := hotSetting(change.Setting)
+		if apply, ok := reloadable[setting]; ok && applied[setting] == false {
+			apply(reloaded, candidate)
+			applied[setting] = true
+		}
+	}
+	return reloaded, changes
+}
@@ -0,0 +1,52 @@
+package config
+
+const redacted = "[REDACTED]"
+
+// Sanitized returns a copy of the config with credentials and
+// execution environment values redacted. Suitable for sharing
+// in support requests.
+func (c *Config) Sanitized() Config {
+	retval := *c
+	if c.Cog != nil {
+		cog := *c.Cog
+		if cog.Token != "" {
+			cog.Token = redacted
+		}
+		if cog.PayloadKey != "" {
+			cog.PayloadKey = redacted
+		}
+		retval.Cog = &cog
+	}
+	if c.Docker != nil {
+		docker := *c.Docker
+		if docker.RegistryPassword != "" {
+			docker.RegistryPassword = redacted
+		}
+		retval.Docker = &docker
+	}
+	if c.Admin != nil {
+		admin := *c.Admin
+		if admin.Token != "" {
+			admin.Token = redacted
+		}
+		retval.Admin = &admin
+	}
+	if c.Bridge != nil {
+		bridge := *c.Bridge
+		if bridge.Password != "" {
+			bridge.Password = redacted
+		}
+		retval.Bridge = &bridge
+	}
+	if c.Execution != nil {
+		execution := ExecutionInfo{
+			ParsedExtraEnv: make(map[string]string),
+		}
+		for name := range c.Execution.ParsedExtraEnv {
+			execution.ExtraEnv = append(execution.ExtraEnv, name+"="+redacted)
+			execution.ParsedExtraEnv[name] = redacted
+		}
+		retval.Execution = &execution
+	}
+	return retval
+}
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"errors"
+	"time"
+)
+
+var errorBadSelfTestTimeout = errors.New("Error parsing self_test/timeout")
+var errorMissingSelfTestCommand = errors.New("self_test/command is required when self_test/enabled is true")
+
+// SelfTestInfo configures the command Relay runs through each enabled
+// execution engine at startup to prove it can execute commands
+type SelfTestInfo struct {
+	Enabled     bool   `yaml:"enabled" env:"RELAY_SELF_TEST_ENABLED" valid:"bool" default:"false"`
+	Command     string `yaml:"command" env:"RELAY_SELF_TEST_COMMAND" valid:"-" default:"/bin/echo"`
+	DockerImage string `yaml:"docker_image" env:"RELAY_SELF_TEST_DOCKER_IMAGE" valid:"-" default:"alpine"`
+	DockerTag   string `yaml:"docker_tag" env:"RELAY_SELF_TEST_DOCKER_TAG" valid:"-" default:"latest"`
+	Timeout     string `yaml:"timeout" env:"RELAY_SELF_TEST_TIMEOUT" valid:"-" default:"60s"`
+}
+
+// TimeoutDuration returns Timeout as a time.Duration
+func (sti *SelfTestInfo) TimeoutDuration() time.Duration {
+	duration, err := time.ParseDuration(sti.Timeout)
+	if err != nil {
+		panic(errorBadSelfTestTimeout)
+	}
+	return duration
+}
+
+func (sti *SelfTestInfo) verify() error {
+	if sti.Enabled == false {
+		return nil
+	}
+	if sti.Command == "" {
+		return errorMissingSelfTestCommand
+	}
+	duration, err := time.ParseDuration(sti.Timeout)
+	if err != nil || duration <= 0 {
+		return errorBadSelfTestTimeout
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"errors"
+	"time"
+)
+
+var errorBadWatchdogInterval = errors.New("Error parsing watchdog/interval")
+var errorBadWatchdogChecks = errors.New("watchdog/checks must be at least 1")
+var errorBadWatchdogLimits = errors.New("watchdog/max_memory_mb and watchdog/max_goroutines must not be negative")
+var errorMissingWatchdogLimit = errors.New("watchdog requires max_memory_mb or max_goroutines when enabled")
+
+// WatchdogInfo configures limits on Relay's own memory use and
+// goroutine count. Relay drains and restarts itself when it stays
+// over a limit for Checks consecutive checks.
+type WatchdogInfo struct {
+	Enabled       bool   `yaml:"enabled" env:"RELAY_WATCHDOG_ENABLED" valid:"bool" default:"false"`
+	MaxMemoryMB   int    `yaml:"max_memory_mb" env:"RELAY_WATCHDOG_MAX_MEMORY_MB" valid:"-" default:"0"`
+	MaxGoroutines int    `yaml:"max_goroutines" env:"RELAY_WATCHDOG_MAX_GOROUTINES" valid:"-" default:"0"`
+	Interval      string `yaml:"interval" env:"RELAY_WATCHDOG_INTERVAL" valid:"-" default:"30s"`
+	Checks        int    `yaml:"checks" env:"RELAY_WATCHDOG_CHECKS" valid:"-" default:"3"`
+	Webhook       string `yaml:"webhook" env:"RELAY_WATCHDOG_WEBHOOK" valid:"-"`
+}
+
+// IntervalDuration returns Interval as a time.Duration
+func (wi *WatchdogInfo) IntervalDuration() time.Duration {
+	duration, err := time.ParseDuration(wi.Interval)
+	if err != nil {
+		panic(errorBadWatchdogInterval)
+	}
+	return duration
+}
+
+func (wi *WatchdogInfo) verify() error {
+	if wi.Enabled == false {
+		return nil
+	}
+	if wi.MaxMemoryMB < 0 || wi.MaxGoroutines < 0 {
+		return errorBadWatchdogLimits
+	}
+	if wi.MaxMemoryMB == 0 && wi.MaxGoroutines == 0 {
+		return errorMissingWatchdogLimit
+	}
+	if wi.Checks < 1 {
+		return errorBadWatchdogChecks
+	}
+	duration, err := time.ParseDuration(wi.Interval)
+	if err != nil || duration <= 0 {
+		return errorBadWatchdogInterval
+	}
+	return nil
+}
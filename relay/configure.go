@@ -0,0 +1,116 @@
+package relay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/operable/go-relay/relay/messages"
+	"time"
+)
+
+const configureAckTopicTemplate = "bot/relays/%s/configure"
+
+var errorBadMaxConcurrent = errors.New("max_concurrent must be positive")
+
+// configure applies runtime settings pushed by Cog and acknowledges
+// the directive. Every setting is checked before any is applied so a
+// bad value doesn't leave Relay half reconfigured.
+func (r *cogRelay) configure(directive *messages.Configure) {
+	ack := &messages.ConfigureAck{
+		ID:      directive.ID,
+		RelayID: r.currentConfig().ID,
+		Status:  "ok",
+	}
+	if err := r.applyConfigure(directive); err != nil {
+		log.Errorf("Rejected configuration directive %s: %s.", directive.ID, err)
+		ack.Status = "error"
+		ack.Error = err.Error()
+	}
+	replyTo := directive.ReplyTo
+	if replyTo == "" {
+		replyTo = fmt.Sprintf(configureAckTopicTemplate, r.currentConfig().ID)
+	}
+	data, _ := json.Marshal(ack)
+	if err := r.conn.Publish(replyTo, data); err != nil {
+		log.Errorf("Failed to acknowledge configuration directive %s: %s.", directive.ID, err)
+	}
+}
+
+func (r *cogRelay) applyConfigure(directive *messages.Configure) error {
+	var level log.Level
+	var refresh time.Duration
+	var err error
+	if directive.LogLevel != "" {
+		if level, err = parseLogLevel(directive.LogLevel); err != nil {
+			return err
+		}
+	}
+	if directive.RefreshInterval != "" {
+		refresh, err = time.ParseDuration(directive.RefreshInterval)
+		if err != nil || refresh <= 0 {
+			return fmt.Errorf("Invalid refresh_interval %s", directive.RefreshInterval)
+		}
+	}
+	if directive.MaxConcurrent < 0 {
+		return errorBadMaxConcurrent
+	}
+	// Serialized with config file reloads so neither loses the
+	// other's changes
+	r.reloadLock.Lock()
+	defer r.reloadLock.Unlock()
+	updated := r.currentConfig().Clone()
+	if directive.LogLevel != "" {
+		updated.LogLevel = directive.LogLevel
+	}
+	if directive.RefreshInterval != "" {
+		updated.Cog.RefreshInterval = directive.RefreshInterval
+	}
+	if directive.MaxConcurrent > 0 {
+		updated.MaxConcurrent = directive.MaxConcurrent
+	}
+	r.swapConfig(updated)
+	if directive.LogLevel != "" {
+		log.SetLevel(level)
+		log.Infof("Log level changed to %s by Cog.", directive.LogLevel)
+	}
+	if directive.RefreshInterval != "" {
+		if r.bundleTimer != nil {
+			r.bundleTimer.Reset(refresh)
+		}
+		log.Infof("Bundle catalog refresh interval changed to %v by Cog.", refresh)
+	}
+	if directive.MaxConcurrent > 0 {
+		r.setMaxConcurrent(directive.MaxConcurrent)
+		log.Infof("Maximum concurrent executions changed to %d by Cog.", directive.MaxConcurrent)
+	}
+	return nil
+}
+
+// setMaxConcurrent starts extra workers when raising the limit. Spare
+// workers are left idle when lowering it; the limiter keeps them from
+// running commands. Autoscaled pools adjust to the new limit on their
+// own. The running config must already hold the new limit.
+func (r *cogRelay) setMaxConcurrent(max int) {
+	if r.autoscaler != nil {
+		r.autoscaler.SetMax(max)
+	} else if started := len(r.workers.Status()); max > started {
+		r.workers.Start(max - started)
+	}
+	r.limiter.SetMax(max)
+}
+
+// parseLogLevel accepts the same levels as log_level
+func parseLogLevel(level string) (log.Level, error) {
+	switch level {
+	case "debug":
+		return log.DebugLevel, nil
+	case "info":
+		return log.InfoLevel, nil
+	case "warn":
+		return log.WarnLevel, nil
+	case "err", "error":
+		return log.ErrorLevel, nil
+	}
+	return log.InfoLevel, fmt.Errorf("Unknown log level %s", level)
+}
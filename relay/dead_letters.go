@@ -0,0 +1,63 @@
+package relay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/operable/go-relay/relay/admin"
+	"github.com/operable/go-relay/relay/worker"
+	"net/http"
+)
+
+const deadLettersFile = "dead_letters.json"
+
+var errorNoSuchDeadLetter = errors.New("No such dead letter")
+
+// openDeadLetters sets up retries of requests which fail for reasons
+// outside the command's control and keeps those which exhaust them,
+// under state_dir if set
+func (r *cogRelay) openDeadLetters() error {
+	deadLetters, err := worker.OpenDeadLetters(r.currentConfig().StatePath(deadLettersFile), r.currentConfig(), r.requeueRequest)
+	if err != nil {
+		return err
+	}
+	r.deadLetters = deadLetters
+	return nil
+}
+
+// adminDeadLetters lists dead-lettered requests, oldest first
+func (r *cogRelay) adminDeadLetters(w http.ResponseWriter, req *http.Request) {
+	if !admin.RequireMethod(w, req, "GET") {
+		return
+	}
+	admin.WriteJSON(w, http.StatusOK, r.deadLetters.List())
+}
+
+// adminRequeueDeadLetter removes the dead letter whose id is POSTed as
+// {"id": 1} and runs its request again with a fresh set of retries
+func (r *cogRelay) adminRequeueDeadLetter(w http.ResponseWriter, req *http.Request) {
+	if !admin.RequireMethod(w, req, "POST") {
+		return
+	}
+	var target struct {
+		ID uint64 `json:"id"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&target); err != nil {
+		admin.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if r.conn == nil {
+		admin.WriteError(w, http.StatusServiceUnavailable, errorCogUnreachable)
+		return
+	}
+	letter, ok := r.deadLetters.Take(target.ID)
+	if ok == false {
+		admin.WriteError(w, http.StatusNotFound, fmt.Errorf("%s: %d", errorNoSuchDeadLetter, target.ID))
+		return
+	}
+	log.Infof("Requeueing dead-lettered %s (%s) through the admin API.", letter.Command, letter.CorrelationID)
+	r.deliveries.Forget(letter.DeliveryKey)
+	go r.enqueue(letter.Topic, letter.Payload, r.conn)
+	admin.WriteJSON(w, http.StatusAccepted, letter)
+}
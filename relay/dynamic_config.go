@@ -21,6 +21,7 @@ import (
 type DynamicConfigUpdater struct {
 	id                string
 	configTopic       string
+	newConnection     func() (bus.Connection, error)
 	options           bus.ConnectionOptions
 	conn              bus.Connection
 	dynamicConfigRoot string
@@ -31,11 +32,12 @@ type DynamicConfigUpdater struct {
 }
 
 // NewDynamicConfigUpdater creates a new updater
-func NewDynamicConfigUpdater(relayID string, busOpts bus.ConnectionOptions, dynamicConfigRoot string,
-	refreshInterval time.Duration) *DynamicConfigUpdater {
+func NewDynamicConfigUpdater(relayID string, newConnection func() (bus.Connection, error), busOpts bus.ConnectionOptions,
+	dynamicConfigRoot string, refreshInterval time.Duration) *DynamicConfigUpdater {
 	return &DynamicConfigUpdater{
 		id:                relayID,
 		configTopic:       fmt.Sprintf("bot/relays/%s/dynconfigs", relayID),
+		newConnection:     newConnection,
 		options:           busOpts,
 		dynamicConfigRoot: dynamicConfigRoot,
 		refreshInterval:   refreshInterval,
@@ -48,7 +50,10 @@ func (dcu *DynamicConfigUpdater) Run() error {
 	log.Infof("Refreshing bundle dynamic configs every %v.", dcu.refreshInterval)
 	dcu.options.AutoReconnect = true
 	dcu.options.EventsHandler = dcu.handleBusEvents
-	conn := &bus.MQTTConnection{}
+	conn, err := dcu.newConnection()
+	if err != nil {
+		return err
+	}
 	if err := conn.Connect(dcu.options); err != nil {
 		return err
 	}
@@ -0,0 +1,67 @@
+package engines
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Docker rejects memory limits below 6MB on current daemons
+const minContainerMemory = 6
+
+var cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupVersion returns 2 if the cgroup hierarchy mounted at root is
+// the unified (v2) hierarchy and 1 otherwise
+func cgroupVersion(root string) int {
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err == nil {
+		return 2
+	}
+	return 1
+}
+
+// setMemoryLimit limits a container's memory to mb megabytes. Swap is
+// limited to the same value so the container can't exceed its limit
+// by swapping; on cgroup v2 Docker otherwise leaves memory.swap.max
+// unlimited.
+func setMemoryLimit(hostConfig *container.HostConfig, mb int) {
+	if mb < minContainerMemory {
+		mb = minContainerMemory
+	}
+	hostConfig.Memory = int64(mb * megabyte)
+	hostConfig.MemorySwap = hostConfig.Memory
+}
+
+// resourceLimitWarnings describes the resource limits the Docker
+// daemon can't enforce
+func resourceLimitWarnings(info types.Info, version int) []string {
+	warnings := []string{}
+	if info.MemoryLimit == false {
+		warnings = append(warnings, "docker/container_memory won't be enforced because the memory controller is unavailable")
+	}
+	if info.SwapLimit == false {
+		warnings = append(warnings, "command containers may use swap beyond docker/container_memory because swap limits are unsupported")
+	}
+	if version == 2 && info.CgroupDriver != "" && info.CgroupDriver != "systemd" {
+		warnings = append(warnings, "the Docker daemon uses the "+info.CgroupDriver+
+			" cgroup driver on a cgroup v2 host; the systemd driver is recommended")
+	}
+	return warnings
+}
+
+// verifyResourceLimits logs a warning for each resource limit Relay
+// can't apply to command containers. The host's cgroup version is only
+// inspected when the Docker daemon is local.
+func (de *DockerEngine) verifyResourceLimits(info types.Info) {
+	version := 0
+	if strings.HasPrefix(de.daemonAddress(), "unix://") {
+		version = cgroupVersion(cgroupRoot)
+		log.Infof("Docker daemon at %s is running on a cgroup v%d host.", de.daemonAddress(), version)
+	}
+	for _, warning := range resourceLimitWarnings(info, version) {
+		log.Warnf("Docker daemon at %s: %s.", de.daemonAddress(), warning)
+	}
+}
@@ -0,0 +1,51 @@
+package engines
+
+import (
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCgroupVersion(t *testing.T) {
+	root, err := ioutil.TempDir("", "cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	if cgroupVersion(root) != 1 {
+		t.Error("Expected cgroup v1 without cgroup.controllers")
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "cgroup.controllers"), []byte("cpu memory pids"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if cgroupVersion(root) != 2 {
+		t.Error("Expected cgroup v2 with cgroup.controllers")
+	}
+}
+
+func TestSetMemoryLimit(t *testing.T) {
+	hostConfig := container.HostConfig{}
+	setMemoryLimit(&hostConfig, 4)
+	if hostConfig.Memory != 6*megabyte || hostConfig.MemorySwap != hostConfig.Memory {
+		t.Errorf("Unexpected memory limits: %d %d", hostConfig.Memory, hostConfig.MemorySwap)
+	}
+}
+
+func TestResourceLimitWarnings(t *testing.T) {
+	info := types.Info{
+		MemoryLimit:  true,
+		SwapLimit:    true,
+		CgroupDriver: "systemd",
+	}
+	if warnings := resourceLimitWarnings(info, 2); len(warnings) != 0 {
+		t.Errorf("Unexpected warnings: %v", warnings)
+	}
+	info.SwapLimit = false
+	info.CgroupDriver = "cgroupfs"
+	if warnings := resourceLimitWarnings(info, 2); len(warnings) != 2 {
+		t.Errorf("Expected swap and cgroup driver warnings: %v", warnings)
+	}
+}
@@ -11,12 +11,17 @@ import (
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/versions"
 	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/tlsconfig"
+	"github.com/docker/go-units"
 	"github.com/operable/circuit"
 	"github.com/operable/go-relay/relay/config"
+	"github.com/operable/go-relay/relay/registry"
 	"golang.org/x/net/context"
-	"io/ioutil"
+	"io"
+	"net/http"
 	"os"
 	"strings"
+	"time"
 )
 
 const (
@@ -25,6 +30,8 @@ const (
 
 var relayCreatedLabel = "io.operable.cog.relay.create"
 var errorDriverImageUnavailable = errors.New("Command driver image is unavailable")
+var errorUsernsRemapUnsupported = errors.New("Docker daemon does not have user namespace remapping enabled")
+var errorRuntimeUnavailable = errors.New("Container runtime requested by bundle is not installed on the Docker daemon")
 
 // DockerEngine is responsible for managing execution of
 // Docker bundled commands.
@@ -34,38 +41,122 @@ type DockerEngine struct {
 	config      config.DockerInfo
 	auth        string
 	cache       *envCache
+	monitor     *dockerMonitor
+	pulls       *pullTracker
+	hosts       *hostPool
+	credentials *registry.Helper
 }
 
-// NewDockerEngine makes a new DockerEngine instance
-func NewDockerEngine(relayConfig *config.Config, cache *envCache) (Engine, error) {
-	dockerConfig := *relayConfig.Docker
+// NewDockerEngine makes a new DockerEngine instance. Image queries
+// made directly against the engine use the first configured host.
+func NewDockerEngine(relayConfig *config.Config, cache *envCache, monitor *dockerMonitor, pulls *pullTracker,
+	hosts *hostPool, credentials *registry.Helper) (Engine, error) {
 	return &DockerEngine{
 		client:      nil,
 		relayConfig: relayConfig,
-		config:      dockerConfig,
+		config:      relayConfig.Docker.ForHost(relayConfig.Docker.Endpoints()[0]),
 		cache:       cache,
+		monitor:     monitor,
+		pulls:       pulls,
+		hosts:       hosts,
+		credentials: credentials,
 	}, nil
 }
 
-// Init is required by the engines.Engine interface
+// forHost returns an engine bound to the Docker daemon at address
+func (de *DockerEngine) forHost(address string) *DockerEngine {
+	return &DockerEngine{
+		client:      nil,
+		relayConfig: de.relayConfig,
+		config:      de.relayConfig.Docker.ForHost(address),
+		cache:       de.cache,
+		monitor:     de.monitor,
+		pulls:       de.pulls,
+		hosts:       de.hosts,
+		credentials: de.credentials,
+	}
+}
+
+// Init is required by the engines.Engine interface. Hosts which fail
+// to initialize are marked unhealthy; Init only fails when no host
+// is usable.
 func (de *DockerEngine) Init() error {
+	var lastErr error
+	for _, address := range de.hosts.addresses() {
+		hostEngine := de.forHost(address)
+		err := hostEngine.initHost()
+		hostEngine.close()
+		de.hosts.setHealth(address, err)
+		if err != nil {
+			log.Errorf("Docker host %s failed to initialize: %s.", address, err)
+			lastErr = err
+		}
+	}
+	if len(de.hosts.healthy()) == 0 {
+		return lastErr
+	}
+	return nil
+}
+
+func (de *DockerEngine) initHost() error {
+	if err := de.verifyDaemon(); err != nil {
+		return err
+	}
+	de.monitor.start(de.config)
 	return de.createCircuitDriver()
 }
 
-// IsAvailable returns true/false if a Docker image is found
+// IsAvailable returns true/false if a Docker image is found. The image
+// is pulled on every healthy host.
 func (de *DockerEngine) IsAvailable(name string, meta string) (bool, error) {
+	healthy := de.hosts.healthy()
+	if len(healthy) == 0 {
+		return false, errorNoHealthyDockerHosts
+	}
+	var lastErr error
+	available := false
+	for _, address := range healthy {
+		hostEngine := de.forHost(address)
+		avail, err := hostEngine.isAvailable(name, meta)
+		if err == nil && avail == true && name != circuitDriverImage {
+			if err = hostEngine.verifyDriverPlatform(fmt.Sprintf("%s:%s", name, meta)); err != nil {
+				log.Errorf("Image %s:%s can't run on Docker host %s: %s.", name, meta, address, err)
+				avail = false
+			}
+		}
+		hostEngine.close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		available = available || avail
+	}
+	if available {
+		return true, nil
+	}
+	return false, lastErr
+}
+
+func (de *DockerEngine) isAvailable(name string, meta string) (bool, error) {
 	err := de.ensureConnected()
 	if err != nil {
 		return false, err
 	}
 
+	fullName := fmt.Sprintf("%s:%s", name, meta)
+	if dir := de.devBuildDir(name); dir != "" {
+		if err := de.buildImage(fullName, dir); err != nil {
+			log.Errorf("Developer mode: Building Docker image %s failed: %s.", fullName, err)
+			return false, err
+		}
+		return true, nil
+	}
 	if de.needsUpdate(name, meta) == false {
 		return true, nil
 	}
-	fullName := fmt.Sprintf("%s:%s", name, meta)
 
 	// Circuit driver is always public, needs no auth
-	if name != "operable/circuit-driver" {
+	if name != circuitDriverImage {
 		err = de.attemptAuth()
 	}
 	if err != nil {
@@ -90,22 +181,75 @@ func (de *DockerEngine) IsAvailable(name string, meta string) (bool, error) {
 
 // NewEnvironment is required by the engines.Engine interface
 func (de *DockerEngine) NewEnvironment(pipelineID string, bundle *config.Bundle) (circuit.Environment, error) {
+	if de.monitor.isDraining() {
+		return nil, errorRelayShuttingDown
+	}
 	key := makeKey(pipelineID, bundle)
 	if cached := de.cache.get(key); cached != nil {
-		return cached, nil
+		if isDeadEnvironment(cached) == false {
+			de.hosts.acquire(environmentHost(cached))
+			return cached, nil
+		}
+		de.cache.discard(key)
+		cached.Shutdown()
 	}
 	log.Debugf("Creating environment %s", key)
-	return de.newEnvironment(bundle)
+	return de.placeEnvironment(pipelineID, bundle)
+}
+
+// placeEnvironment creates an environment on the host chosen by the
+// host pool. Hosts which turn out to be unreachable are marked
+// unhealthy and the next host is tried.
+func (de *DockerEngine) placeEnvironment(pipelineID string, bundle *config.Bundle) (circuit.Environment, error) {
+	for {
+		address, err := de.hosts.pick()
+		if err != nil {
+			return nil, err
+		}
+		hostEngine := de.forHost(address)
+		env, err := hostEngine.newEnvironment(pipelineID, bundle)
+		if err == nil {
+			hostEngine.close()
+			return env, nil
+		}
+		de.hosts.release(address)
+		pingErr := hostEngine.ping()
+		hostEngine.close()
+		if pingErr == nil {
+			return nil, err
+		}
+		log.Errorf("Docker host %s is unreachable: %s. Marking it unhealthy.", address, pingErr)
+		de.hosts.setHealth(address, pingErr)
+	}
 }
 
 // ReleaseEnvironment is required by the engines.Engine interface
 func (de *DockerEngine) ReleaseEnvironment(pipelineID string, bundle *config.Bundle, env circuit.Environment) {
+	de.hosts.release(environmentHost(env))
 	key := makeKey(pipelineID, bundle)
+	if isDeadEnvironment(env) {
+		de.cache.discard(key)
+		env.Shutdown()
+		return
+	}
 	if de.cache.put(key, env) == false {
 		env.Shutdown()
 	}
 }
 
+// DigestsForName returns the repository digests of a local image
+func (de *DockerEngine) DigestsForName(name string, meta string) ([]string, error) {
+	err := de.ensureConnected()
+	if err != nil {
+		return nil, err
+	}
+	image, _, err := de.client.ImageInspectWithRaw(context.Background(), fmt.Sprintf("%s:%s", name, meta))
+	if err != nil {
+		return nil, err
+	}
+	return image.RepoDigests, nil
+}
+
 // IDForName returns the image ID for a given image name
 func (de *DockerEngine) IDForName(name string, meta string) (string, error) {
 	err := de.ensureConnected()
@@ -119,30 +263,66 @@ func (de *DockerEngine) IDForName(name string, meta string) (string, error) {
 	return image.ID, nil
 }
 
-// Clean removes exited containers
+// Clean removes exited containers from every host. Healthy hosts are
+// checked for reachability and unhealthy hosts are re-initialized so
+// they rejoin the pool once they recover.
 func (de *DockerEngine) Clean() int {
-	err := de.ensureConnected()
-	if err != nil {
-		return 0
-	}
 	count := 0
 	for _, env := range de.cache.getOld() {
 		if env.Shutdown() == nil {
 			count++
 		}
 	}
+	for _, host := range de.hosts.status() {
+		hostEngine := de.forHost(host.Address)
+		var err error
+		if host.Healthy {
+			err = hostEngine.ping()
+		} else {
+			err = hostEngine.initHost()
+			if err == nil {
+				log.Infof("Docker host %s recovered.", host.Address)
+			}
+		}
+		de.hosts.setHealth(host.Address, err)
+		if err == nil {
+			count += hostEngine.removeLeftoverContainers()
+		} else if host.Healthy {
+			log.Errorf("Docker host %s is unreachable: %s. Marking it unhealthy.", host.Address, err)
+		}
+		hostEngine.close()
+	}
+	return count
+}
+
+// removeLeftoverContainers removes exited command containers and
+// those orphaned by earlier Relay processes. Containers created by
+// other Relays are left alone.
+func (de *DockerEngine) removeLeftoverContainers() int {
+	err := de.ensureConnected()
+	if err != nil {
+		return 0
+	}
+	count := 0
 	args := filters.NewArgs()
-	args.Add("status", "exited")
-	args.Add("label", fmt.Sprintf("%s=yes", relayCreatedLabel))
+	args.Add("label", fmt.Sprintf("%s=%s", relayIDLabel, de.relayConfig.ID))
 	containers, err := de.client.ContainerList(context.Background(),
 		types.ContainerListOptions{
+			All:     true,
 			Filters: args,
 		})
 	if err != nil {
-		log.Errorf("Listing dead Docker containers failed: %s.", err)
+		log.Errorf("Listing Relay's Docker containers failed: %s.", err)
 		return 0
 	}
 	for _, container := range containers {
+		if isLeftoverContainer(container) == false {
+			continue
+		}
+		if container.State != "exited" && container.State != "dead" {
+			log.Warnf("Removing command container %s for bundle %s orphaned by an earlier Relay process.",
+				shortContainerID(container.ID), container.Labels[bundleLabel])
+		}
 		err = de.removeContainer(container.ID)
 		if err != nil {
 			log.Errorf("Error removing Docker container %s: %s.", shortContainerID(container.ID), err)
@@ -153,6 +333,106 @@ func (de *DockerEngine) Clean() int {
 	return count
 }
 
+func (de *DockerEngine) ping() error {
+	if err := de.ensureConnected(); err != nil {
+		return err
+	}
+	_, err := de.client.Ping(context.Background())
+	return err
+}
+
+func (de *DockerEngine) close() {
+	if de.client != nil {
+		de.client.Close()
+		de.client = nil
+	}
+}
+
+func (de *DockerEngine) verifyDaemon() error {
+	err := de.ensureConnected()
+	if err != nil {
+		return err
+	}
+	if _, err := de.client.Ping(context.Background()); err != nil {
+		log.Errorf("Docker daemon at %s is unreachable: %s.", de.daemonAddress(), err)
+		return err
+	}
+	if de.config.TLSEnabled() {
+		log.Infof("Connected to Docker daemon at %s using TLS.", de.daemonAddress())
+	}
+	info, err := de.client.Info(context.Background())
+	if err != nil {
+		log.Errorf("Failed to query Docker daemon info: %s.", err)
+		return err
+	}
+	if de.config.UsernsRemap == true {
+		if err := de.verifyUsernsRemap(info); err != nil {
+			return err
+		}
+	}
+	de.verifyResourceLimits(info)
+	return de.verifyBundleDevices(info)
+}
+
+// verifyBundleDevices confirms the runtimes and host devices requested
+// by bundle settings are available so misconfigured GPU bundles are
+// caught at startup instead of on first execution
+func (de *DockerEngine) verifyBundleDevices(info types.Info) error {
+	runtimes := map[string]string{}
+	for name := range de.relayConfig.Bundles {
+		settings := de.relayConfig.SettingsForBundle(name)
+		if runtime := settings.ContainerRuntime(); runtime != "" {
+			runtimes[runtime] = name
+		}
+		specs, err := settings.DeviceSpecs()
+		if err != nil {
+			return err
+		}
+		for _, spec := range specs {
+			if _, err := os.Stat(spec.HostPath); err != nil {
+				log.Errorf("Device %s requested by bundle %s is unavailable: %s.", spec.HostPath, name, err)
+				return err
+			}
+		}
+	}
+	for runtime, bundle := range runtimes {
+		if _, ok := info.Runtimes[runtime]; !ok {
+			log.Errorf("Container runtime %s requested by bundle %s isn't installed on the Docker daemon at %s.",
+				runtime, bundle, de.daemonAddress())
+			return errorRuntimeUnavailable
+		}
+		log.Infof("Verified container runtime %s for bundle %s.", runtime, bundle)
+	}
+	return nil
+}
+
+// verifyUsernsRemap confirms the Docker daemon was started with
+// --userns-remap so command containers can't run as host root
+func (de *DockerEngine) verifyUsernsRemap(info types.Info) error {
+	opts, err := types.DecodeSecurityOptions(info.SecurityOptions)
+	if err != nil {
+		return err
+	}
+	for _, opt := range opts {
+		if opt.Name == "userns" {
+			log.Infof("Docker daemon at %s has user namespace remapping enabled.", de.daemonAddress())
+			return nil
+		}
+	}
+	log.Errorf("docker/userns_remap is enabled but the Docker daemon at %s isn't running with --userns-remap.", de.daemonAddress())
+	return errorUsernsRemapUnsupported
+}
+
+func (de *DockerEngine) daemonAddress() string {
+	if de.config.UseEnv {
+		if host := os.Getenv("DOCKER_HOST"); host != "" {
+			return host
+		}
+		return client.DefaultDockerHost
+	}
+	return de.config.SocketPath
+}
+
 func (de *DockerEngine) removeContainer(id string) error {
 	return de.client.ContainerRemove(context.Background(), id, types.ContainerRemoveOptions{
 		RemoveVolumes: true,
@@ -160,16 +440,30 @@ func (de *DockerEngine) removeContainer(id string) error {
 	})
 }
 
-func (de *DockerEngine) makeAuthConfig() *types.AuthConfig {
+// makeAuthConfig returns the registry credentials to pull with.
+// Tokens from a cloud credential helper take precedence over the
+// configured registry user.
+func (de *DockerEngine) makeAuthConfig() (*types.AuthConfig, error) {
+	if de.credentials != nil {
+		creds, err := de.credentials.Credentials()
+		if err != nil {
+			return nil, err
+		}
+		return &types.AuthConfig{
+			ServerAddress: de.config.RegistryHost,
+			Username:      creds.Username,
+			Password:      creds.Password,
+		}, nil
+	}
 	if de.config.RegistryUser == "" || de.config.RegistryPassword == "" || de.config.RegistryEmail == "" {
-		return nil
+		return nil, nil
 	}
 	return &types.AuthConfig{
 		ServerAddress: de.config.RegistryHost,
 		Username:      de.config.RegistryUser,
 		Password:      de.config.RegistryPassword,
 		Email:         de.config.RegistryEmail,
-	}
+	}, nil
 }
 
 func (de *DockerEngine) createCircuitDriver() error {
@@ -177,50 +471,59 @@ func (de *DockerEngine) createCircuitDriver() error {
 	if err != nil {
 		return err
 	}
-	// Just in case
-	de.client.ContainerRemove(context.Background(), "cog-circuit-driver", types.ContainerRemoveOptions{
-		RemoveVolumes: true,
-		Force:         true,
-	})
-	avail, err := de.IsAvailable("operable/circuit-driver", de.config.CommandDriverVersion)
+	avail, err := de.isAvailable(circuitDriverImage, de.config.CommandDriverVersion)
 	if err != nil {
 		return err
 	}
 	if avail == false {
 		return errorDriverImageUnavailable
 	}
+	if err := de.createDriverContainer(de.config.CommandDriverVersion, de.driverContainerName()); err != nil {
+		return err
+	}
+	log.Info("Created required command driver container.")
+	return nil
+}
 
+// createDriverContainer (re)creates the named data container holding
+// the command driver from the driver image with the given tag
+func (de *DockerEngine) createDriverContainer(tag string, name string) error {
+	// Just in case
+	de.client.ContainerRemove(context.Background(), name, types.ContainerRemoveOptions{
+		RemoveVolumes: true,
+		Force:         true,
+	})
 	hostConfig := container.HostConfig{
 		Privileged: false,
 	}
-	fullName := fmt.Sprintf("operable/circuit-driver:%s", de.config.CommandDriverVersion)
-	hostConfig.Memory = int64(4 * megabyte)
+	fullName := fmt.Sprintf("%s:%s", circuitDriverImage, tag)
+	setMemoryLimit(&hostConfig, minContainerMemory)
 	config := container.Config{
 		Image:     fullName,
 		Cmd:       []string{"/bin/date"},
 		OpenStdin: false,
 		StdinOnce: false,
 		Env:       []string{},
-		Labels: map[string]string{
-			relayCreatedLabel: "yes",
-		},
+		Labels:    de.driverLabels(),
 	}
-	_, err = de.client.ContainerCreate(context.Background(), &config, &hostConfig, nil, "cog-circuit-driver")
+	_, err := de.client.ContainerCreate(context.Background(), &config, &hostConfig, nil, name)
 	if err != nil {
 		log.Errorf("Creation of required command driver container failed: %s.", err)
 		return err
 	}
-	log.Info("Created required command driver container.")
 	return nil
 }
 
 func (de *DockerEngine) attemptAuth() error {
 	if de.auth == "" {
-		authConfig := de.makeAuthConfig()
+		authConfig, err := de.makeAuthConfig()
+		if err != nil {
+			return err
+		}
 		if authConfig == nil {
 			return nil
 		}
-		_, err := de.client.RegistryLogin(context.Background(), *authConfig)
+		_, err = de.client.RegistryLogin(context.Background(), *authConfig)
 		if err != nil {
 			log.Errorf("Authenticating to Docker registry failed: %s.", err)
 			return err
@@ -241,6 +544,13 @@ func (de *DockerEngine) developerModeRefresh(bundle *config.Bundle) error {
 			return err
 		}
 		fullName := fmt.Sprintf("%s:%s", bundle.Docker.Image, bundle.Docker.Tag)
+		if dir := de.devBuildDir(bundle.Docker.Image); dir != "" {
+			if err := de.buildImage(fullName, dir); err != nil {
+				log.Errorf("Developer mode: Building Docker image %s failed: %s.", fullName, err)
+				return err
+			}
+			return nil
+		}
 		log.Warnf("Developer mode: Refreshing Docker image %s.", fullName)
 
 		err = de.attemptAuth()
@@ -263,27 +573,137 @@ func (de *DockerEngine) developerModeRefresh(bundle *config.Bundle) error {
 	return nil
 }
 
-func (de *DockerEngine) newEnvironment(bundle *config.Bundle) (circuit.Environment, error) {
+func (de *DockerEngine) newEnvironment(pipelineID string, bundle *config.Bundle) (circuit.Environment, error) {
 	// Only happens if Relay is running in developer mode
 	err := de.developerModeRefresh(bundle)
 	if err != nil {
 		return nil, err
 	}
+	if err := de.ensureConnected(); err != nil {
+		return nil, err
+	}
+	fullName := fmt.Sprintf("%s:%s", bundle.Docker.Image, bundle.Docker.Tag)
+	driver, err := de.driverFor(fullName)
+	if err != nil {
+		return nil, err
+	}
 	client, err := newClient(de.config)
 	if err != nil {
 		return nil, err
 	}
-	options := circuit.CreateEnvironmentOptions{}
-	options.Kind = circuit.DockerKind
-	options.Bundle = bundle.Name
-	options.DockerOptions.Conn = client
-	options.DockerOptions.Image = bundle.Docker.Image
-	options.DockerOptions.Tag = bundle.Docker.Tag
-	options.DockerOptions.Binds = bundle.Docker.Binds
-	options.DockerOptions.DriverInstance = "cog-circuit-driver"
-	options.DockerOptions.DriverPath = "/operable/circuit/bin/circuit-driver"
-	options.DockerOptions.Memory = int64(de.relayConfig.Docker.ContainerMemory * megabyte)
-	return circuit.CreateEnvironment(options)
+	options := dockerEnvironmentOptions{
+		bundle:         bundle.Name,
+		host:           de.config.SocketPath,
+		image:          bundle.Docker.Image,
+		tag:            bundle.Docker.Tag,
+		diagnosticsDir: de.config.DiagnosticsDir,
+		logExcerptSize: de.config.LogExcerptSize,
+		timeout:        de.relayConfig.ExecutionTimeoutFor(bundle.Name),
+		killGrace:      de.config.ShutdownGraceDuration(),
+		config: container.Config{
+			Image:     fullName,
+			Cmd:       []string{"/operable/circuit/bin/circuit-driver"},
+			User:      de.config.ContainerUser,
+			OpenStdin: true,
+			StdinOnce: false,
+			Tty:       false,
+			Labels:    de.containerLabels(bundle.Name, pipelineID),
+		},
+		hostConfig: container.HostConfig{
+			Privileged:     false,
+			VolumesFrom:    []string{driver},
+			Binds:          bundle.Docker.Binds,
+			ReadonlyRootfs: de.config.ReadOnlyRootfs,
+			Tmpfs:          de.config.ScratchTmpfs(),
+		},
+	}
+	setMemoryLimit(&options.hostConfig, de.relayConfig.Docker.ContainerMemory)
+	options.hostConfig.SecurityOpt, err = de.securityOpts(bundle)
+	if err != nil {
+		return nil, err
+	}
+	if err := de.applyDevices(bundle, &options); err != nil {
+		return nil, err
+	}
+	if err := de.applyLimits(bundle, &options); err != nil {
+		return nil, err
+	}
+	settings := de.relayConfig.SettingsForBundle(bundle.Name)
+	if len(settings.Entrypoint) > 0 {
+		options.config.Entrypoint = settings.Entrypoint
+	}
+	options.config.WorkingDir = settings.WorkingDir
+	env, err := newDockerEnvironment(client, de.monitor, options)
+	if err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// securityOpts builds the seccomp and AppArmor options configured
+// for a bundle's command containers
+func (de *DockerEngine) securityOpts(bundle *config.Bundle) ([]string, error) {
+	settings := de.relayConfig.SettingsForBundle(bundle.Name)
+	opts := []string{}
+	switch settings.SeccompProfile {
+	case "":
+	case config.UnconfinedSeccompProfile:
+		opts = append(opts, "seccomp=unconfined")
+	default:
+		profile, err := de.config.SeccompProfile(settings.SeccompProfile)
+		if err != nil {
+			log.Errorf("Failed to load seccomp profile %s for bundle %s: %s.", settings.SeccompProfile, bundle.Name, err)
+			return nil, err
+		}
+		opts = append(opts, fmt.Sprintf("seccomp=%s", profile))
+	}
+	if settings.AppArmorProfile != "" {
+		opts = append(opts, fmt.Sprintf("apparmor=%s", settings.AppArmorProfile))
+	}
+	return opts, nil
+}
+
+// applyDevices sets the runtime, device mappings and GPU selection
+// configured for a bundle's command containers
+func (de *DockerEngine) applyDevices(bundle *config.Bundle, options *dockerEnvironmentOptions) error {
+	settings := de.relayConfig.SettingsForBundle(bundle.Name)
+	options.hostConfig.Runtime = settings.ContainerRuntime()
+	specs, err := settings.DeviceSpecs()
+	if err != nil {
+		return err
+	}
+	for _, spec := range specs {
+		options.hostConfig.Devices = append(options.hostConfig.Devices, container.DeviceMapping{
+			PathOnHost:        spec.HostPath,
+			PathInContainer:   spec.ContainerPath,
+			CgroupPermissions: spec.Permissions,
+		})
+	}
+	if settings.GPUs != "" {
+		options.config.Env = append(options.config.Env, fmt.Sprintf("NVIDIA_VISIBLE_DEVICES=%s", settings.GPUs))
+	}
+	return nil
+}
+
+// applyLimits sets the sysctls and ulimits configured for a bundle's
+// command containers
+func (de *DockerEngine) applyLimits(bundle *config.Bundle, options *dockerEnvironmentOptions) error {
+	settings := de.relayConfig.SettingsForBundle(bundle.Name)
+	if len(settings.Sysctls) > 0 {
+		options.hostConfig.Sysctls = settings.Sysctls
+	}
+	ulimits, err := settings.UlimitSpecs()
+	if err != nil {
+		return err
+	}
+	for _, ulimit := range ulimits {
+		options.hostConfig.Ulimits = append(options.hostConfig.Ulimits, &units.Ulimit{
+			Name: ulimit.Name,
+			Soft: ulimit.Soft,
+			Hard: ulimit.Hard,
+		})
+	}
+	return nil
 }
 
 func (de *DockerEngine) needsUpdate(name, meta string) bool {
@@ -292,7 +712,7 @@ func (de *DockerEngine) needsUpdate(name, meta string) bool {
 		image, _, _ := de.client.ImageInspectWithRaw(context.Background(), fullName)
 		if image.ID != "" {
 			// Override when DevMode is enabled
-			if name != "operable/circuit-driver" && de.relayConfig.DevMode == true {
+			if name != circuitDriverImage && de.relayConfig.DevMode == true {
 				log.Warnf("Developer mode: Marked %s stale even though local image %s exists.",
 					fullName, shortImageID(image.ID))
 				return true
@@ -304,21 +724,77 @@ func (de *DockerEngine) needsUpdate(name, meta string) bool {
 	return true
 }
 
+// pullImage pulls an image, retrying transient failures with an
+// exponential backoff. Concurrent pulls of the same image from the
+// same daemon, including those made by other Relays sharing engine
+// resources, wait for a single pull.
 func (de *DockerEngine) pullImage(fullName string) error {
 	err := de.ensureConnected()
 	if err != nil {
 		return err
 	}
-	closer, pullErr := de.client.ImagePull(context.Background(), fullName,
+	return de.pulls.share(de.config.SocketPath+"|"+fullName, func() error {
+		de.pulls.begin(fullName)
+		defer de.pulls.end(fullName)
+		delay := de.config.PullRetryDelayDuration()
+		for attempt := 0; ; attempt++ {
+			err := de.attemptPull(fullName)
+			if err == nil || attempt >= de.config.PullRetries || isPermanentPullError(err) {
+				return err
+			}
+			log.Warnf("Pulling Docker image %s failed: %s. Retrying in %v.", fullName, err, delay)
+			time.Sleep(delay)
+			delay *= 2
+		}
+	})
+}
+
+func (de *DockerEngine) attemptPull(fullName string) error {
+	closer, err := de.client.ImagePull(context.Background(), fullName,
 		types.ImagePullOptions{
 			All:          false,
 			RegistryAuth: de.auth,
 		})
-	if closer != nil {
-		ioutil.ReadAll(closer)
-		closer.Close()
+	if err != nil {
+		return err
 	}
-	return pullErr
+	defer closer.Close()
+	// Failures after the pull has started are reported in the
+	// progress stream rather than as an API error
+	decoder := json.NewDecoder(closer)
+	for {
+		var progress pullProgress
+		if err := decoder.Decode(&progress); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if progress.Error != "" {
+			return errors.New(progress.Error)
+		}
+		if progress.Detail != nil && progress.Status == "Downloading" {
+			de.pulls.update(fullName, progress.ID, progress.Detail.Current, progress.Detail.Total)
+		}
+	}
+}
+
+// pullProgress is a single message in an image pull's progress stream
+type pullProgress struct {
+	ID     string          `json:"id"`
+	Status string          `json:"status"`
+	Detail *progressDetail `json:"progressDetail"`
+	Error  string          `json:"error"`
+}
+
+type progressDetail struct {
+	Current int64 `json:"current"`
+	Total   int64 `json:"total"`
+}
+
+// isPermanentPullError returns true for failures retrying won't fix
+func isPermanentPullError(err error) bool {
+	return client.IsErrImageNotFound(err) || client.IsErrUnauthorized(err) || client.IsErrNotFound(err)
 }
 
 func (de *DockerEngine) removeOldImage(oldID, newID, fullName string) {
@@ -367,7 +843,11 @@ func newClient(dockerConfig config.DockerInfo) (*client.Client, error) {
 		if dockerAPIVersion == "" {
 			dockerAPIVersion = client.DefaultVersion
 		}
-		c, err = client.NewClient(dockerConfig.SocketPath, dockerAPIVersion, nil, nil)
+		httpClient, err := newHTTPClient(dockerConfig)
+		if err != nil {
+			return nil, err
+		}
+		c, err = client.NewClient(dockerConfig.SocketPath, dockerAPIVersion, httpClient, nil)
 		if err != nil {
 			return nil, err
 		}
@@ -385,6 +865,35 @@ func newClient(dockerConfig config.DockerInfo) (*client.Client, error) {
 	return c, nil
 }
 
+// newHTTPClient returns a TLS-enabled HTTP client when Relay is configured
+// to use client certificates to talk to a remote Docker daemon. A nil client
+// tells the Docker API client to use its default transport.
+func newHTTPClient(dockerConfig config.DockerInfo) (*http.Client, error) {
+	if dockerConfig.TLSEnabled() == false {
+		return nil, nil
+	}
+	options := tlsconfig.Options{
+		CertFile:           dockerConfig.CertFile(),
+		KeyFile:            dockerConfig.KeyFile(),
+		InsecureSkipVerify: dockerConfig.TLSSkipVerify,
+	}
+	if dockerConfig.TLSSkipVerify == false {
+		options.CAFile = dockerConfig.CAFile()
+	} else {
+		log.Warn("Docker daemon TLS certificate verification disabled.")
+	}
+	tlsConfig, err := tlsconfig.Client(options)
+	if err != nil {
+		log.Errorf("Error loading Docker TLS certificates from %s: %s.", dockerConfig.CertPath, err)
+		return nil, err
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: tlsConfig,
+		},
+	}, nil
+}
+
 func shortContainerID(containerID string) string {
 	idEnd := len(containerID)
 	idStart := idEnd - 12
@@ -399,6 +908,18 @@ func shortImageID(imageID string) string {
 	return chunks[1][:11]
 }
 
+// environmentHost returns the address of the Docker daemon running env
+func environmentHost(env circuit.Environment) string {
+	return env.GetMetadata()["host"]
+}
+
+func isDeadEnvironment(env circuit.Environment) bool {
+	if dockerEnv, ok := env.(*dockerEnvironment); ok {
+		return dockerEnv.IsDead()
+	}
+	return false
+}
+
 func makeKey(pipelineID string, bundle *config.Bundle) string {
 	return fmt.Sprintf("%s/%s:%s", pipelineID, bundle.Name, bundle.Version)
 }
@@ -0,0 +1,116 @@
+package engines
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/api/types"
+	"golang.org/x/net/context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// buildProgress is a single message in an image build's output stream
+type buildProgress struct {
+	Stream string `json:"stream"`
+	Error  string `json:"error"`
+}
+
+// devBuildDir returns the local build directory configured for an
+// image. Images are only built locally in developer mode.
+func (de *DockerEngine) devBuildDir(name string) string {
+	if de.relayConfig.DevMode == false {
+		return ""
+	}
+	return de.config.DevBuilds[name]
+}
+
+// buildImage builds and tags fullName from the Dockerfile in dir.
+// The daemon's build cache is used so unchanged layers aren't rebuilt.
+func (de *DockerEngine) buildImage(fullName string, dir string) error {
+	log.Warnf("Developer mode: Building Docker image %s from %s.", fullName, dir)
+	buildContext, err := tarDirectory(dir)
+	if err != nil {
+		return err
+	}
+	response, err := de.client.ImageBuild(context.Background(), buildContext, types.ImageBuildOptions{
+		Tags:   []string{fullName},
+		Remove: true,
+	})
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	decoder := json.NewDecoder(response.Body)
+	for {
+		var progress buildProgress
+		if err := decoder.Decode(&progress); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		if progress.Error != "" {
+			return errors.New(strings.TrimSpace(progress.Error))
+		}
+		if line := strings.TrimSpace(progress.Stream); line != "" {
+			log.Debugf("Building %s: %s", fullName, line)
+		}
+	}
+	log.Warnf("Developer mode: Built Docker image %s.", fullName)
+	return nil
+}
+
+// tarDirectory archives dir for use as a Docker build context. VCS
+// metadata is skipped to keep the context small.
+func tarDirectory(dir string) (io.Reader, error) {
+	var buf bytes.Buffer
+	archive := tar.NewWriter(&buf)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() == false {
+			return nil
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(archive, file)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
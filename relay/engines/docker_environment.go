@@ -0,0 +1,322 @@
+package engines
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/operable/circuit"
+	"github.com/operable/circuit-driver/api"
+	cio "github.com/operable/circuit-driver/io"
+	"golang.org/x/net/context"
+	"sync"
+	"time"
+)
+
+var errorDriverConnectionLost = errors.New("Lost connection to command container")
+var errorRelayShuttingDown = errors.New("Relay is shutting down")
+
+// deathGrace is how long Run waits for the Docker event monitor to
+// explain a broken driver connection before reporting a generic error
+var deathGrace = time.Duration(2) * time.Second
+
+// dockerEnvironmentOptions describes the container backing a
+// dockerEnvironment
+type dockerEnvironmentOptions struct {
+	bundle     string
+	host       string
+	image      string
+	tag        string
+	config     container.Config
+	hostConfig container.HostConfig
+	// Where to save the logs of containers which exit abnormally
+	diagnosticsDir string
+	// Maximum amount of container log output included in error responses
+	logExcerptSize int
+	// Longest a request may run before the container is stopped. Zero
+	// disables the timeout.
+	timeout time.Duration
+	// How long a timed out container has to exit after SIGTERM
+	killGrace time.Duration
+}
+
+// ExecutionTimeoutError is returned when a command runs longer than
+// its execution timeout
+type ExecutionTimeoutError struct {
+	Timeout time.Duration
+}
+
+func (ete *ExecutionTimeoutError) Error() string {
+	return fmt.Sprintf("Command timed out after %v", ete.Timeout)
+}
+
+type execOutcome struct {
+	result api.ExecResult
+	err    error
+}
+
+// dockerEnvironment is Relay's implementation of circuit.Environment
+// for Docker containers. Unlike the stock circuit implementation it
+// lets Relay control how command containers are created and it
+// reports container deaths back to the caller instead of blocking
+// forever.
+type dockerEnvironment struct {
+	client      *client.Client
+	monitor     *dockerMonitor
+	containerID string
+	options     dockerEnvironmentOptions
+	userData    circuit.EnvironmentUserData
+	lock        sync.Mutex
+	isDead      bool
+	isShutdown  bool
+	isRunning   bool
+	requests    chan api.ExecRequest
+	results     chan execOutcome
+	control     chan struct{}
+	died        chan error
+}
+
+func newDockerEnvironment(conn *client.Client, monitor *dockerMonitor, options dockerEnvironmentOptions) (*dockerEnvironment, error) {
+	de := &dockerEnvironment{
+		client:   conn,
+		monitor:  monitor,
+		options:  options,
+		requests: make(chan api.ExecRequest),
+		results:  make(chan execOutcome, 1),
+		control:  make(chan struct{}),
+		died:     make(chan error, 1),
+	}
+	created, err := conn.ContainerCreate(context.Background(), &options.config, &options.hostConfig, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	de.containerID = created.ID
+	if monitor != nil {
+		monitor.track(de)
+	}
+	if err := conn.ContainerStart(context.Background(), de.containerID, types.ContainerStartOptions{}); err != nil {
+		de.Shutdown()
+		return nil, err
+	}
+	attached, err := conn.ContainerAttach(context.Background(), de.containerID, types.ContainerAttachOptions{
+		Stream: true,
+		Stdin:  true,
+		Stdout: true,
+		Stderr: true,
+	})
+	if err != nil {
+		de.Shutdown()
+		return nil, err
+	}
+	go func() {
+		de.runWorker(attached)
+	}()
+	return de, nil
+}
+
+func (de *dockerEnvironment) runWorker(attached types.HijackedResponse) {
+	defer attached.Close()
+	encoder := api.WrapEncoder(attached.Conn)
+	decoder := api.WrapDecoder(cio.NewDockerStdoutReader(attached.Conn))
+	for {
+		select {
+		case <-de.control:
+			return
+		case request := <-de.requests:
+			var outcome execOutcome
+			if err := encoder.EncodeRequest(&request); err != nil {
+				outcome.err = errorDriverConnectionLost
+			} else if err := decoder.DecodeResult(&outcome.result); err != nil {
+				outcome.err = errorDriverConnectionLost
+			}
+			de.results <- outcome
+		}
+	}
+}
+
+// GetKind is required by the circuit.Environment interface
+func (de *dockerEnvironment) GetKind() circuit.EnvironmentKind {
+	return circuit.DockerKind
+}
+
+// SetUserData is required by the circuit.Environment interface
+func (de *dockerEnvironment) SetUserData(data circuit.EnvironmentUserData) error {
+	if de.IsDead() {
+		return circuit.ErrorDeadEnvironment
+	}
+	de.userData = data
+	return nil
+}
+
+// GetUserData is required by the circuit.Environment interface
+func (de *dockerEnvironment) GetUserData() (circuit.EnvironmentUserData, error) {
+	if de.IsDead() {
+		return nil, circuit.ErrorDeadEnvironment
+	}
+	return de.userData, nil
+}
+
+// GetMetadata is required by the circuit.Environment interface
+func (de *dockerEnvironment) GetMetadata() circuit.EnvironmentMetadata {
+	return circuit.EnvironmentMetadata{
+		"bundle":    de.options.bundle,
+		"host":      de.options.host,
+		"image":     de.options.image,
+		"tag":       de.options.tag,
+		"container": de.containerID,
+	}
+}
+
+// Run is required by the circuit.Environment interface. Commands are
+// bounded by the environment's timeout.
+func (de *dockerEnvironment) Run(request api.ExecRequest) (api.ExecResult, error) {
+	ctx, cancel := WithExecutionTimeout(context.Background(), de.options.timeout)
+	defer cancel()
+	return de.RunContext(ctx, request)
+}
+
+// RunContext is required by the ContextRunner interface. The
+// container is stopped if ctx is done before the command finishes.
+// If the container dies while the request is executing RunContext
+// returns an error describing why.
+func (de *dockerEnvironment) RunContext(ctx context.Context, request api.ExecRequest) (api.ExecResult, error) {
+	if de.IsDead() {
+		return circuit.EmptyExecResult, circuit.ErrorDeadEnvironment
+	}
+	de.setRunning(true)
+	defer de.setRunning(false)
+	select {
+	case de.requests <- request:
+	case err := <-de.died:
+		return circuit.EmptyExecResult, de.failure(err)
+	case <-ctx.Done():
+		return circuit.EmptyExecResult, ContextError(ctx)
+	}
+	select {
+	case <-ctx.Done():
+		err := ContextError(ctx)
+		log.Warnf("Stopping command in container %s for bundle %s: %s.",
+			shortContainerID(de.containerID), de.options.bundle, err)
+		de.terminate(de.options.killGrace, err)
+		return circuit.EmptyExecResult, err
+	case outcome := <-de.results:
+		if outcome.err != nil {
+			// A broken driver connection is usually caused by the
+			// container dying. Prefer the monitor's explanation if
+			// it arrives promptly.
+			if err := de.awaitDeath(); err != nil {
+				return circuit.EmptyExecResult, de.failure(err)
+			}
+			de.markDead()
+			return outcome.result, de.failure(outcome.err)
+		}
+		return outcome.result, nil
+	case err := <-de.died:
+		return circuit.EmptyExecResult, de.failure(err)
+	}
+}
+
+// failure attaches the container's logs to an abnormal exit error
+func (de *dockerEnvironment) failure(reason error) error {
+	if reason == errorRelayShuttingDown {
+		return reason
+	}
+	return captureContainerLogs(de.client, de.containerID, de.options.bundle, reason,
+		de.options.diagnosticsDir, de.options.logExcerptSize)
+}
+
+// Shutdown is required by the circuit.Environment interface
+func (de *dockerEnvironment) Shutdown() error {
+	de.lock.Lock()
+	if de.isShutdown {
+		de.lock.Unlock()
+		return circuit.ErrorDeadEnvironment
+	}
+	close(de.control)
+	de.isShutdown = true
+	de.isDead = true
+	de.lock.Unlock()
+	if de.monitor != nil {
+		de.monitor.untrack(de.containerID)
+	}
+	return de.client.ContainerRemove(context.Background(), de.containerID, types.ContainerRemoveOptions{
+		RemoveVolumes: true,
+		Force:         true,
+	})
+}
+
+// IsDead returns true if the environment's container has exited or
+// been shut down
+func (de *dockerEnvironment) IsDead() bool {
+	de.lock.Lock()
+	defer de.lock.Unlock()
+	return de.isDead
+}
+
+func (de *dockerEnvironment) setRunning(running bool) {
+	de.lock.Lock()
+	defer de.lock.Unlock()
+	de.isRunning = running
+}
+
+func (de *dockerEnvironment) running() bool {
+	de.lock.Lock()
+	defer de.lock.Unlock()
+	return de.isRunning
+}
+
+// drain stops the environment's container during Relay shutdown. Idle
+// containers are removed immediately. Busy containers are sent SIGTERM
+// and given grace to exit before being killed. Any in-flight Run call
+// returns errorRelayShuttingDown.
+func (de *dockerEnvironment) drain(grace time.Duration) {
+	if de.running() == false {
+		de.Shutdown()
+		return
+	}
+	de.terminate(grace, errorRelayShuttingDown)
+}
+
+// terminate sends SIGTERM to the environment's container, waits up to
+// grace for it to exit, and then forcibly removes it. reason is
+// reported to any request still waiting on the container.
+func (de *dockerEnvironment) terminate(grace time.Duration, reason error) {
+	id := shortContainerID(de.containerID)
+	log.Infof("Stopping command container %s for bundle %s.", id, de.options.bundle)
+	if err := de.client.ContainerKill(context.Background(), de.containerID, "SIGTERM"); err != nil {
+		log.Errorf("Failed to send SIGTERM to command container %s: %s.", id, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	if _, err := de.client.ContainerWait(ctx, de.containerID); err != nil {
+		log.Warnf("Command container %s didn't exit within %v. Killing it.", id, grace)
+	}
+	cancel()
+	de.containerDied(reason)
+	de.Shutdown()
+}
+
+func (de *dockerEnvironment) markDead() {
+	de.lock.Lock()
+	defer de.lock.Unlock()
+	de.isDead = true
+}
+
+// containerDied is called by the monitor when the container exits unexpectedly
+func (de *dockerEnvironment) containerDied(err error) {
+	de.markDead()
+	select {
+	case de.died <- err:
+	default:
+	}
+}
+
+func (de *dockerEnvironment) awaitDeath() error {
+	select {
+	case err := <-de.died:
+		return err
+	case <-time.After(deathGrace):
+		return nil
+	}
+}
@@ -0,0 +1,64 @@
+package engines
+
+import (
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"os"
+	"time"
+)
+
+// Labels applied to containers created by Relay. Cleanup only touches
+// containers labeled with this Relay's ID.
+const (
+	relayIDLabel       = "io.operable.cog.relay.id"
+	relayInstanceLabel = "io.operable.cog.relay.instance"
+	bundleLabel        = "io.operable.cog.relay.bundle"
+	pipelineLabel      = "io.operable.cog.relay.pipeline"
+)
+
+// instanceID distinguishes containers created by this Relay process
+// from those left behind by an earlier process with the same Relay ID
+var instanceID = fmt.Sprintf("%d-%d", os.Getpid(), time.Now().UnixNano())
+
+// containerLabels returns the labels for a command container created
+// for pipelineID. Command containers may serve several requests from
+// the same pipeline.
+func (de *DockerEngine) containerLabels(bundle string, pipelineID string) map[string]string {
+	labels := de.driverLabels()
+	labels[bundleLabel] = bundle
+	labels[pipelineLabel] = pipelineID
+	return labels
+}
+
+func (de *DockerEngine) driverLabels() map[string]string {
+	return map[string]string{
+		relayCreatedLabel:  "yes",
+		relayIDLabel:       de.relayConfig.ID,
+		relayInstanceLabel: instanceID,
+	}
+}
+
+// DriverContainerName returns the name of the container holding the
+// command driver. Names are per Relay so Relays sharing a Docker
+// daemon don't replace each other's driver.
+func DriverContainerName(relayID string) string {
+	return fmt.Sprintf("cog-circuit-driver-%s", relayID)
+}
+
+func (de *DockerEngine) driverContainerName() string {
+	return DriverContainerName(de.relayConfig.ID)
+}
+
+// isLeftoverContainer returns true for containers Clean should remove:
+// command containers which have exited and command containers created
+// by a Relay process which is no longer running
+func isLeftoverContainer(container types.Container) bool {
+	if container.Labels[bundleLabel] == "" {
+		// Command driver
+		return false
+	}
+	if container.State == "exited" || container.State == "dead" {
+		return true
+	}
+	return container.Labels[relayInstanceLabel] != instanceID
+}
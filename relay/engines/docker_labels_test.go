@@ -0,0 +1,57 @@
+package engines
+
+import (
+	"github.com/docker/docker/api/types"
+	"github.com/operable/go-relay/relay/config"
+	"testing"
+)
+
+func TestContainerLabels(t *testing.T) {
+	de := &DockerEngine{
+		relayConfig: &config.Config{ID: "2bba0d1f-a30c-45ec-87e6-e4c5d8c6104f"},
+	}
+	labels := de.containerLabels("ping", "abc123")
+	if labels[relayIDLabel] != "2bba0d1f-a30c-45ec-87e6-e4c5d8c6104f" || labels[bundleLabel] != "ping" ||
+		labels[pipelineLabel] != "abc123" || labels[relayInstanceLabel] != instanceID {
+		t.Errorf("Unexpected container labels: %v", labels)
+	}
+	if name := de.driverContainerName(); name != "cog-circuit-driver-2bba0d1f-a30c-45ec-87e6-e4c5d8c6104f" {
+		t.Errorf("Unexpected driver container name: %s", name)
+	}
+}
+
+func TestLeftoverContainers(t *testing.T) {
+	running := types.Container{
+		State: "running",
+		Labels: map[string]string{
+			bundleLabel:        "ping",
+			relayInstanceLabel: instanceID,
+		},
+	}
+	if isLeftoverContainer(running) {
+		t.Error("Expected running container from this Relay process to be kept")
+	}
+	running.Labels[relayInstanceLabel] = "1234-5678"
+	if isLeftoverContainer(running) == false {
+		t.Error("Expected container orphaned by an earlier Relay process to be removed")
+	}
+	exited := types.Container{
+		State: "exited",
+		Labels: map[string]string{
+			bundleLabel:        "ping",
+			relayInstanceLabel: instanceID,
+		},
+	}
+	if isLeftoverContainer(exited) == false {
+		t.Error("Expected exited container to be removed")
+	}
+	driver := types.Container{
+		State: "created",
+		Labels: map[string]string{
+			relayInstanceLabel: "1234-5678",
+		},
+	}
+	if isLeftoverContainer(driver) {
+		t.Error("Expected command driver container to be kept")
+	}
+}
@@ -0,0 +1,112 @@
+package engines
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"golang.org/x/net/context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// containerFailure describes a command container which exited
+// abnormally along with the tail of its logs
+type containerFailure struct {
+	reason  error
+	excerpt string
+	logPath string
+}
+
+func (cf *containerFailure) Error() string {
+	message := fmt.Sprintf("%s", cf.reason)
+	if cf.excerpt != "" {
+		message = fmt.Sprintf("%s\nLast container output:\n%s", message, cf.excerpt)
+	}
+	if cf.logPath != "" {
+		message = fmt.Sprintf("%s\nFull container log saved to %s on the Relay host.", message, cf.logPath)
+	}
+	return message
+}
+
+// captureContainerLogs retrieves the complete stdout and stderr of a
+// container, saves it to diagnosticsDir (if set), and returns a
+// containerFailure wrapping reason.
+func captureContainerLogs(conn *client.Client, containerID string, bundle string,
+	reason error, diagnosticsDir string, excerptSize int) error {
+	failure := &containerFailure{
+		reason: reason,
+	}
+	reader, err := conn.ContainerLogs(context.Background(), containerID, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+	})
+	if err != nil {
+		log.Errorf("Failed to retrieve logs for command container %s: %s.", shortContainerID(containerID), err)
+		return failure
+	}
+	defer reader.Close()
+	output, err := demuxDockerLogs(reader)
+	if err != nil {
+		log.Errorf("Failed to read logs for command container %s: %s.", shortContainerID(containerID), err)
+	}
+	failure.excerpt = logExcerpt(output, excerptSize)
+	if diagnosticsDir != "" && len(output) > 0 {
+		logPath, err := saveContainerLog(diagnosticsDir, containerID, bundle, output)
+		if err != nil {
+			log.Errorf("Failed to save logs for command container %s: %s.", shortContainerID(containerID), err)
+		} else {
+			failure.logPath = logPath
+			log.Infof("Saved logs for failed command container %s to %s.", shortContainerID(containerID), logPath)
+		}
+	}
+	return failure
+}
+
+// demuxDockerLogs strips the stream headers Docker adds to the logs of
+// containers created without a TTY, interleaving stdout and stderr in
+// the order they were written.
+func demuxDockerLogs(reader io.Reader) ([]byte, error) {
+	var output bytes.Buffer
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if err == io.EOF {
+				return output.Bytes(), nil
+			}
+			return output.Bytes(), err
+		}
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.CopyN(&output, reader, size); err != nil {
+			return output.Bytes(), err
+		}
+	}
+}
+
+// logExcerpt returns at most the last size bytes of output, starting
+// on a line boundary where possible
+func logExcerpt(output []byte, size int) string {
+	trimmed := bytes.TrimSpace(output)
+	if size <= 0 || len(trimmed) <= size {
+		return string(trimmed)
+	}
+	excerpt := trimmed[len(trimmed)-size:]
+	if newline := bytes.IndexByte(excerpt, '\n'); newline > -1 && newline < len(excerpt)-1 {
+		excerpt = excerpt[newline+1:]
+	}
+	return fmt.Sprintf("...\n%s", excerpt)
+}
+
+func saveContainerLog(diagnosticsDir, containerID, bundle string, output []byte) (string, error) {
+	if err := os.MkdirAll(diagnosticsDir, 0755); err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("%s-%s-%s.log", time.Now().UTC().Format("20060102T150405Z"), bundle, shortContainerID(containerID))
+	logPath := filepath.Join(diagnosticsDir, name)
+	return logPath, ioutil.WriteFile(logPath, output, 0644)
+}
@@ -0,0 +1,42 @@
+package engines
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func frame(stream byte, payload string) []byte {
+	header := make([]byte, 8)
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(payload)))
+	return append(header, []byte(payload)...)
+}
+
+func TestDemuxDockerLogs(t *testing.T) {
+	var raw bytes.Buffer
+	raw.Write(frame(1, "starting driver\n"))
+	raw.Write(frame(2, "panic: boom\n"))
+	output, err := demuxDockerLogs(&raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(output) != "starting driver\npanic: boom\n" {
+		t.Errorf("Unexpected demuxed output: %q", string(output))
+	}
+}
+
+func TestLogExcerpt(t *testing.T) {
+	output := []byte("line one\nline two\nline three\n")
+	if excerpt := logExcerpt(output, 1024); excerpt != "line one\nline two\nline three" {
+		t.Errorf("Unexpected untruncated excerpt: %q", excerpt)
+	}
+	excerpt := logExcerpt(output, 14)
+	if !strings.HasPrefix(excerpt, "...\n") || !strings.HasSuffix(excerpt, "line three") {
+		t.Errorf("Unexpected truncated excerpt: %q", excerpt)
+	}
+	if strings.Contains(excerpt, "two") {
+		t.Errorf("Expected excerpt to start on a line boundary: %q", excerpt)
+	}
+}
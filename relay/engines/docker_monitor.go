@@ -0,0 +1,234 @@
+package engines
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/operable/go-relay/relay/config"
+	"golang.org/x/net/context"
+	"sync"
+	"time"
+)
+
+var monitorRetryInterval = time.Duration(5) * time.Second
+
+var errorContainerOOM = errors.New("Command container was killed after exceeding its memory limit")
+var errorDockerDaemonRestarted = errors.New("Docker daemon restarted or became unreachable while the command was running")
+
+type trackedContainer struct {
+	env       *dockerEnvironment
+	oomKilled bool
+}
+
+// dockerMonitor subscribes to the event stream of each Docker daemon
+// and notifies in-flight environments when their containers are OOM
+// killed or die unexpectedly. Watching stops when the monitor is
+// stopped.
+type dockerMonitor struct {
+	lock     sync.Mutex
+	tracked  map[string]*trackedContainer
+	watching map[string]bool
+	draining bool
+	ctx      context.Context
+	cancel   context.CancelFunc
+	watchers sync.WaitGroup
+}
+
+func newDockerMonitor() *dockerMonitor {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &dockerMonitor{
+		tracked:  make(map[string]*trackedContainer),
+		watching: make(map[string]bool),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// start begins watching the event stream of the daemon described by
+// dockerConfig. Calling start for a daemon which is already being
+// watched is a no-op.
+func (dm *dockerMonitor) start(dockerConfig config.DockerInfo) {
+	dm.lock.Lock()
+	defer dm.lock.Unlock()
+	if dm.watching[dockerConfig.SocketPath] || dm.ctx.Err() != nil {
+		return
+	}
+	dm.watching[dockerConfig.SocketPath] = true
+	dm.watchers.Add(1)
+	go func() {
+		defer dm.watchers.Done()
+		dm.watch(dockerConfig)
+	}()
+}
+
+// stop stops watching every daemon and waits for the watchers to exit.
+// Daemons aren't watched again once the monitor is stopped.
+func (dm *dockerMonitor) stop() {
+	dm.cancel()
+	dm.watchers.Wait()
+}
+
+func (dm *dockerMonitor) track(env *dockerEnvironment) {
+	dm.lock.Lock()
+	defer dm.lock.Unlock()
+	dm.tracked[env.containerID] = &trackedContainer{
+		env: env,
+	}
+}
+
+func (dm *dockerMonitor) untrack(containerID string) {
+	dm.lock.Lock()
+	defer dm.lock.Unlock()
+	delete(dm.tracked, containerID)
+}
+
+func (dm *dockerMonitor) isDraining() bool {
+	dm.lock.Lock()
+	defer dm.lock.Unlock()
+	return dm.draining
+}
+
+// drain stops every tracked container in parallel and returns once
+// they have all been removed
+func (dm *dockerMonitor) drain(grace time.Duration) {
+	dm.lock.Lock()
+	dm.draining = true
+	envs := []*dockerEnvironment{}
+	for _, tracked := range dm.tracked {
+		envs = append(envs, tracked.env)
+	}
+	dm.lock.Unlock()
+	if len(envs) == 0 {
+		return
+	}
+	log.Infof("Stopping %d command containers with a %v grace period.", len(envs), grace)
+	var wg sync.WaitGroup
+	for _, env := range envs {
+		wg.Add(1)
+		go func(env *dockerEnvironment) {
+			defer wg.Done()
+			env.drain(grace)
+		}(env)
+	}
+	wg.Wait()
+}
+
+func (dm *dockerMonitor) watch(dockerConfig config.DockerInfo) {
+	connected := false
+	for {
+		conn, err := newClient(dockerConfig)
+		if err != nil {
+			log.Errorf("Docker event monitor failed to connect: %s.", err)
+			if dm.pause() == false {
+				return
+			}
+			continue
+		}
+		if connected {
+			dm.reconcile(conn, dockerConfig.SocketPath)
+		}
+		connected = true
+		err = dm.consume(conn)
+		conn.Close()
+		if dm.ctx.Err() != nil {
+			return
+		}
+		log.Errorf("Docker event stream interrupted: %s. Reconnecting in %v.", err, monitorRetryInterval)
+		if dm.pause() == false {
+			return
+		}
+	}
+}
+
+// pause waits before reconnecting to a daemon. Returns false if the
+// monitor was stopped meanwhile.
+func (dm *dockerMonitor) pause() bool {
+	select {
+	case <-dm.ctx.Done():
+		return false
+	case <-time.After(monitorRetryInterval):
+		return true
+	}
+}
+
+func (dm *dockerMonitor) consume(conn *client.Client) error {
+	args := filters.NewArgs()
+	args.Add("type", events.ContainerEventType)
+	args.Add("label", fmt.Sprintf("%s=yes", relayCreatedLabel))
+	args.Add("event", "oom")
+	args.Add("event", "die")
+	messages, errs := conn.Events(dm.ctx, types.EventsOptions{
+		Filters: args,
+	})
+	log.Debug("Watching Docker event stream.")
+	for {
+		select {
+		case message := <-messages:
+			dm.handleEvent(message)
+		case err := <-errs:
+			return err
+		case <-dm.ctx.Done():
+			return dm.ctx.Err()
+		}
+	}
+}
+
+func (dm *dockerMonitor) handleEvent(message events.Message) {
+	dm.lock.Lock()
+	defer dm.lock.Unlock()
+	tracked := dm.tracked[message.Actor.ID]
+	if tracked == nil {
+		return
+	}
+	switch message.Action {
+	case "oom":
+		log.Warnf("Command container %s for bundle %s ran out of memory.",
+			shortContainerID(message.Actor.ID), tracked.env.options.bundle)
+		tracked.oomKilled = true
+	case "die":
+		delete(dm.tracked, message.Actor.ID)
+		if dm.draining {
+			tracked.env.containerDied(errorRelayShuttingDown)
+			return
+		}
+		var err error
+		if tracked.oomKilled {
+			err = errorContainerOOM
+		} else {
+			err = fmt.Errorf("Command container exited unexpectedly with status %s", message.Actor.Attributes["exitCode"])
+		}
+		log.Errorf("Command container %s for bundle %s died: %s.",
+			shortContainerID(message.Actor.ID), tracked.env.options.bundle, err)
+		tracked.env.containerDied(err)
+	}
+}
+
+// reconcile checks every tracked container after the event stream has
+// been re-established. Containers which stopped while the stream was
+// down (typically because the Docker daemon restarted) are reported
+// as dead. Only containers running on host are checked.
+func (dm *dockerMonitor) reconcile(conn *client.Client, host string) {
+	dm.lock.Lock()
+	defer dm.lock.Unlock()
+	for id, tracked := range dm.tracked {
+		if tracked.env.options.host != host {
+			continue
+		}
+		info, err := conn.ContainerInspect(context.Background(), id)
+		if err == nil && info.State != nil && info.State.Running {
+			continue
+		}
+		delete(dm.tracked, id)
+		died := errorDockerDaemonRestarted
+		if err == nil && info.State != nil && info.State.OOMKilled {
+			died = errorContainerOOM
+		}
+		log.Errorf("Command container %s for bundle %s was lost: %s.",
+			shortContainerID(id), tracked.env.options.bundle, died)
+		tracked.env.containerDied(died)
+	}
+}
@@ -0,0 +1,71 @@
+package engines
+
+import (
+	"github.com/docker/docker/api/types/events"
+	"github.com/operable/go-relay/relay/config"
+	"testing"
+	"time"
+)
+
+func trackedEnvironment(monitor *dockerMonitor, id string) *dockerEnvironment {
+	env := &dockerEnvironment{
+		containerID: id,
+		died:        make(chan error, 1),
+	}
+	monitor.track(env)
+	return env
+}
+
+func dieEvent(id string) events.Message {
+	return events.Message{
+		Action: "die",
+		Actor: events.Actor{
+			ID: id,
+			Attributes: map[string]string{
+				"exitCode": "137",
+			},
+		},
+	}
+}
+
+func TestMonitorReportsOOM(t *testing.T) {
+	monitor := newDockerMonitor()
+	env := trackedEnvironment(monitor, "4f1d2c3b5a6e7f8091a2b3c4")
+	monitor.handleEvent(events.Message{Action: "oom", Actor: events.Actor{ID: "4f1d2c3b5a6e7f8091a2b3c4"}})
+	monitor.handleEvent(dieEvent("4f1d2c3b5a6e7f8091a2b3c4"))
+	if err := <-env.died; err != errorContainerOOM {
+		t.Errorf("Expected errorContainerOOM: %v", err)
+	}
+	if env.IsDead() == false {
+		t.Error("Expected environment to be marked dead")
+	}
+}
+
+func TestMonitorReportsShutdownWhileDraining(t *testing.T) {
+	monitor := newDockerMonitor()
+	env := trackedEnvironment(monitor, "4f1d2c3b5a6e7f8091a2b3c4")
+	monitor.draining = true
+	monitor.handleEvent(dieEvent("4f1d2c3b5a6e7f8091a2b3c4"))
+	if err := <-env.died; err != errorRelayShuttingDown {
+		t.Errorf("Expected errorRelayShuttingDown: %v", err)
+	}
+}
+
+func TestMonitorStops(t *testing.T) {
+	monitor := newDockerMonitor()
+	monitor.start(config.DockerInfo{SocketPath: "unix:///nonexistent/docker.sock"})
+	stopped := make(chan struct{})
+	go func() {
+		monitor.stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Docker event watcher never exited")
+	}
+	monitor.start(config.DockerInfo{SocketPath: "unix:///nonexistent/other.sock"})
+	if len(monitor.watching) != 1 {
+		t.Errorf("Expected stopped monitor not to watch daemons: %v", monitor.watching)
+	}
+}
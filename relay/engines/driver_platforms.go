@@ -0,0 +1,134 @@
+package engines
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/client"
+	"golang.org/x/net/context"
+	"strings"
+)
+
+const circuitDriverImage = "operable/circuit-driver"
+
+// archAliases maps the architecture names reported by image metadata
+// and uname to Go's names
+var archAliases = map[string]string{
+	"x86_64":  "amd64",
+	"x86-64":  "amd64",
+	"aarch64": "arm64",
+	"armv7l":  "arm",
+	"i386":    "386",
+	"i686":    "386",
+}
+
+func normalizeArch(arch string) string {
+	arch = strings.ToLower(arch)
+	if alias, ok := archAliases[arch]; ok {
+		return alias
+	}
+	return arch
+}
+
+// driverTagFor returns the circuit driver image tag built for arch.
+// The configured command_driver_version is used for the driver's own
+// architecture; other architectures use "<version>-<arch>" variants.
+func driverTagFor(version, nativeArch, arch string) string {
+	if arch == "" || arch == nativeArch {
+		return version
+	}
+	return fmt.Sprintf("%s-%s", version, arch)
+}
+
+// driverContainerNameFor returns the name of the driver container
+// serving images built for arch
+func driverContainerNameFor(relayID, nativeArch, arch string) string {
+	if arch == "" || arch == nativeArch {
+		return DriverContainerName(relayID)
+	}
+	return fmt.Sprintf("%s-%s", DriverContainerName(relayID), arch)
+}
+
+// imageArch returns the normalized architecture of a local image
+func (de *DockerEngine) imageArch(fullName string) (string, error) {
+	image, _, err := de.client.ImageInspectWithRaw(context.Background(), fullName)
+	if err != nil {
+		return "", err
+	}
+	return normalizeArch(image.Architecture), nil
+}
+
+// nativeDriverArch returns the architecture of the configured circuit
+// driver image
+func (de *DockerEngine) nativeDriverArch() (string, error) {
+	return de.imageArch(fmt.Sprintf("%s:%s", circuitDriverImage, de.config.CommandDriverVersion))
+}
+
+// driverFor returns the name of a driver container whose binaries run
+// on the architecture of the image fullName. Driver containers for
+// other architectures are created on first use.
+func (de *DockerEngine) driverFor(fullName string) (string, error) {
+	arch, err := de.imageArch(fullName)
+	if err != nil {
+		return "", err
+	}
+	nativeArch, err := de.nativeDriverArch()
+	if err != nil {
+		return "", err
+	}
+	if arch == "" || arch == nativeArch {
+		return de.driverContainerName(), nil
+	}
+	name := driverContainerNameFor(de.relayConfig.ID, nativeArch, arch)
+	tag := driverTagFor(de.config.CommandDriverVersion, nativeArch, arch)
+	existing, err := de.client.ContainerInspect(context.Background(), name)
+	if err == nil && existing.Config != nil && existing.Config.Image == fmt.Sprintf("%s:%s", circuitDriverImage, tag) {
+		return name, nil
+	}
+	if err != nil && client.IsErrContainerNotFound(err) == false {
+		return "", err
+	}
+	if err := de.verifyDriverArch(tag, arch); err != nil {
+		return "", err
+	}
+	if err := de.createDriverContainer(tag, name); err != nil {
+		return "", err
+	}
+	log.Infof("Created command driver container for %s images.", arch)
+	return name, nil
+}
+
+// verifyDriverPlatform checks a command driver is available for the
+// architecture of the image fullName. Called when bundles are
+// installed so incompatible images are reported before any command
+// runs, rather than failing with exec format errors.
+func (de *DockerEngine) verifyDriverPlatform(fullName string) error {
+	arch, err := de.imageArch(fullName)
+	if err != nil {
+		return err
+	}
+	nativeArch, err := de.nativeDriverArch()
+	if err != nil {
+		return err
+	}
+	if arch == "" || arch == nativeArch {
+		return nil
+	}
+	return de.verifyDriverArch(driverTagFor(de.config.CommandDriverVersion, nativeArch, arch), arch)
+}
+
+// verifyDriverArch pulls the driver image tag and checks it was built
+// for arch
+func (de *DockerEngine) verifyDriverArch(tag, arch string) error {
+	avail, err := de.isAvailable(circuitDriverImage, tag)
+	if err != nil || avail == false {
+		return fmt.Errorf("No command driver is available for %s images (%s:%s)", arch, circuitDriverImage, tag)
+	}
+	driverArch, err := de.imageArch(fmt.Sprintf("%s:%s", circuitDriverImage, tag))
+	if err != nil {
+		return err
+	}
+	if driverArch != arch {
+		return fmt.Errorf("Command driver %s:%s is built for %s, not %s", circuitDriverImage, tag, driverArch, arch)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package engines
+
+import (
+	"testing"
+)
+
+func TestNormalizeArch(t *testing.T) {
+	for arch, expected := range map[string]string{"x86_64": "amd64", "aarch64": "arm64", "arm64": "arm64", "AMD64": "amd64"} {
+		if normalized := normalizeArch(arch); normalized != expected {
+			t.Errorf("Expected %s to normalize to %s: %s", arch, expected, normalized)
+		}
+	}
+}
+
+func TestDriverVariants(t *testing.T) {
+	if tag := driverTagFor("0.13", "amd64", "amd64"); tag != "0.13" {
+		t.Errorf("Expected native architecture to use configured tag: %s", tag)
+	}
+	if tag := driverTagFor("0.13", "amd64", ""); tag != "0.13" {
+		t.Errorf("Expected images without architecture to use configured tag: %s", tag)
+	}
+	if tag := driverTagFor("0.13", "amd64", "arm64"); tag != "0.13-arm64" {
+		t.Errorf("Unexpected arm64 driver tag: %s", tag)
+	}
+	if name := driverContainerNameFor("abc", "amd64", "arm64"); name != "cog-circuit-driver-abc-arm64" {
+		t.Errorf("Unexpected arm64 driver container: %s", name)
+	}
+	if name := driverContainerNameFor("abc", "arm64", "arm64"); name != DriverContainerName("abc") {
+		t.Errorf("Unexpected native driver container: %s", name)
+	}
+}
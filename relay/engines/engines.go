@@ -4,6 +4,9 @@ import (
 	"errors"
 	"github.com/operable/circuit"
 	"github.com/operable/go-relay/relay/config"
+	"github.com/operable/go-relay/relay/registry"
+	"sync"
+	"time"
 )
 
 // EngineType is an enum describing the various engine types
@@ -32,18 +35,64 @@ type Engine interface {
 
 // Engines knows how to create engines based on bundle type
 type Engines struct {
+	lock        sync.RWMutex
 	relayConfig *config.Config
 	cache       *envCache
+	monitor     *dockerMonitor
+	pulls       *pullTracker
+	hosts       *hostPool
+	credentials *registry.Helper
+}
+
+// SharedResources holds engine state which several Relays running
+// in one process can share. Image pulls are tracked and deduplicated
+// across every Relay using the same SharedResources, so Relays
+// talking to the same Docker daemon pull each image once.
+type SharedResources struct {
+	pulls *pullTracker
+}
+
+// NewSharedResources constructs a new SharedResources instance
+func NewSharedResources() *SharedResources {
+	return &SharedResources{
+		pulls: newPullTracker(),
+	}
 }
 
 // NewEngines constructs a new Engines instance
 func NewEngines(relayConfig *config.Config) *Engines {
+	return NewSharedEngines(relayConfig, NewSharedResources())
+}
+
+// NewSharedEngines constructs a new Engines instance which uses
+// shared for the resources it has in common with other Relays.
+// Environments, containers and Docker hosts remain private to each
+// instance so draining one Relay leaves the others running.
+func NewSharedEngines(relayConfig *config.Config, shared *SharedResources) *Engines {
 	return &Engines{
 		relayConfig: relayConfig,
 		cache:       newEnvCache(),
+		monitor:     newDockerMonitor(),
+		pulls:       shared.pulls,
+		hosts:       newHostPool(relayConfig.Docker.Endpoints(), relayConfig.Docker.Balance),
+		credentials: registry.NewHelper(relayConfig.Docker),
 	}
 }
 
+// SetConfig makes engines created from now on use relayConfig.
+// Engines already handed out keep the config they were created with.
+func (e *Engines) SetConfig(relayConfig *config.Config) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.relayConfig = relayConfig
+}
+
+func (e *Engines) config() *config.Config {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return e.relayConfig
+}
+
 // EngineForBundle returns the correct engine for a given
 // bundle type.
 func (e *Engines) EngineForBundle(bundle *config.Bundle) (Engine, error) {
@@ -56,10 +105,25 @@ func (e *Engines) EngineForBundle(bundle *config.Bundle) (Engine, error) {
 // GetEngine returns the specified engine (if available)
 func (e *Engines) GetEngine(engineType EngineType) (Engine, error) {
 	if engineType == DockerEngineType {
-		if e.relayConfig.DockerEnabled() {
-			return NewDockerEngine(e.relayConfig, e.cache)
+		if relayConfig := e.config(); relayConfig.DockerEnabled() {
+			return NewDockerEngine(relayConfig, e.cache, e.monitor, e.pulls, e.hosts, e.credentials)
 		}
 		return nil, ErrDockerDisabled
 	}
-	return NewNativeEngine(e.relayConfig)
+	return NewNativeEngine(e.config())
+}
+
+// Drain stops all running Docker command containers, giving each
+// grace to exit before it is killed. New Docker environments are
+// refused once draining starts.
+func (e *Engines) Drain(grace time.Duration) {
+	if e.config().DockerEnabled() {
+		e.monitor.drain(grace)
+	}
+}
+
+// Close stops watching Docker daemons for container deaths. Docker
+// environments created afterwards aren't monitored.
+func (e *Engines) Close() {
+	e.monitor.stop()
 }
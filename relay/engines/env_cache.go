@@ -66,6 +66,13 @@ func (ec *envCache) put(key string, env circuit.Environment) bool {
 	return true
 }
 
+// discard forgets the environment stored with the specified key.
+func (ec *envCache) discard(key string) {
+	ec.lock.Lock()
+	defer ec.lock.Unlock()
+	delete(ec.envs, key)
+}
+
 func (ec *envCache) getOld() []circuit.Environment {
 	retval := []circuit.Environment{}
 	ec.lock.Lock()
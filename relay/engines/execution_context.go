@@ -0,0 +1,60 @@
+package engines
+
+import (
+	"github.com/operable/circuit"
+	"github.com/operable/circuit-driver/api"
+	"golang.org/x/net/context"
+	"time"
+)
+
+type executionTimeoutKey struct{}
+
+// ContextRunner is implemented by environments which can abort a
+// running command, stopping its process or container, once the
+// command's context is done
+type ContextRunner interface {
+	RunContext(ctx context.Context, request api.ExecRequest) (api.ExecResult, error)
+}
+
+// WithExecutionTimeout returns a context for running a command which
+// expires after timeout. A zero timeout never expires.
+func WithExecutionTimeout(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(parent)
+	}
+	ctx := context.WithValue(parent, executionTimeoutKey{}, timeout)
+	return context.WithTimeout(ctx, timeout)
+}
+
+// Run runs request in env until it finishes or ctx is done.
+// Environments which aren't ContextRunners run the command to
+// completion regardless of ctx.
+func Run(ctx context.Context, env circuit.Environment, request api.ExecRequest) (api.ExecResult, error) {
+	if runner, ok := env.(ContextRunner); ok {
+		return runner.RunContext(ctx, request)
+	}
+	return env.Run(request)
+}
+
+// IsStoppedError returns true if err means the command didn't finish
+// because it was stopped, whether for running too long, being
+// cancelled or Relay shutting down, rather than because its
+// environment failed
+func IsStoppedError(err error) bool {
+	if _, timedOut := err.(*ExecutionTimeoutError); timedOut {
+		return true
+	}
+	return err == context.Canceled || err == context.DeadlineExceeded || err == errorRelayShuttingDown
+}
+
+// ContextError explains why a command's context is done. Commands
+// which ran out of time get an ExecutionTimeoutError.
+func ContextError(ctx context.Context) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		timeout, _ := ctx.Value(executionTimeoutKey{}).(time.Duration)
+		return &ExecutionTimeoutError{
+			Timeout: timeout,
+		}
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,68 @@
+package engines
+
+import (
+	"fmt"
+	"github.com/operable/go-relay/relay/config"
+	"golang.org/x/net/context"
+)
+
+// EngineHealth describes the state of an execution engine
+type EngineHealth struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Version string `json:"version,omitempty"`
+	Error   string `json:"error,omitempty"`
+	// Populated when Relay uses more than one Docker host
+	Hosts []DockerHostStatus `json:"hosts,omitempty"`
+}
+
+// Health checks each enabled execution engine
+func (e *Engines) Health() []EngineHealth {
+	retval := []EngineHealth{}
+	if e.config().DockerEnabled() {
+		retval = append(retval, e.dockerHealth())
+	}
+	if e.config().NativeEnabled() {
+		retval = append(retval, EngineHealth{
+			Name:    "native",
+			Healthy: true,
+		})
+	}
+	return retval
+}
+
+func (e *Engines) dockerHealth() EngineHealth {
+	health := EngineHealth{
+		Name: "docker",
+	}
+	addresses := e.hosts.addresses()
+	for _, address := range addresses {
+		version, err := dockerVersion(e.config().Docker.ForHost(address))
+		if err != nil {
+			health.Error = fmt.Sprintf("%s", err)
+			continue
+		}
+		health.Healthy = true
+		health.Version = version
+	}
+	if health.Healthy {
+		health.Error = ""
+	}
+	if len(addresses) > 1 {
+		health.Hosts = e.hosts.status()
+	}
+	return health
+}
+
+func dockerVersion(dockerConfig config.DockerInfo) (string, error) {
+	client, err := newClient(dockerConfig)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+	version, err := client.ServerVersion(context.Background())
+	if err != nil {
+		return "", err
+	}
+	return version.Version, nil
+}
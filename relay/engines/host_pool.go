@@ -0,0 +1,166 @@
+package engines
+
+import (
+	"errors"
+	"fmt"
+	"github.com/operable/go-relay/relay/config"
+	"sync"
+)
+
+var errorNoHealthyDockerHosts = errors.New("No healthy Docker hosts are available")
+
+// DockerHostStatus describes the state of a single Docker daemon in
+// Relay's host pool
+type DockerHostStatus struct {
+	Address    string `json:"address"`
+	Healthy    bool   `json:"healthy"`
+	Active     int    `json:"active"`
+	Executions int64  `json:"executions"`
+	Error      string `json:"error,omitempty"`
+}
+
+type dockerHost struct {
+	address    string
+	healthy    bool
+	active     int
+	executions int64
+	lastError  string
+}
+
+// hostPool tracks the load and health of each configured Docker
+// daemon and picks the daemon new environments are created on
+type hostPool struct {
+	lock     sync.Mutex
+	strategy string
+	hosts    []*dockerHost
+	next     int
+}
+
+func newHostPool(addresses []string, strategy string) *hostPool {
+	pool := &hostPool{
+		strategy: strategy,
+	}
+	for _, address := range addresses {
+		pool.hosts = append(pool.hosts, &dockerHost{
+			address: address,
+			healthy: true,
+		})
+	}
+	return pool
+}
+
+// addresses returns every host in the pool, healthy or not
+func (hp *hostPool) addresses() []string {
+	hp.lock.Lock()
+	defer hp.lock.Unlock()
+	retval := []string{}
+	for _, host := range hp.hosts {
+		retval = append(retval, host.address)
+	}
+	return retval
+}
+
+// healthy returns the hosts currently accepting executions
+func (hp *hostPool) healthy() []string {
+	hp.lock.Lock()
+	defer hp.lock.Unlock()
+	retval := []string{}
+	for _, host := range hp.hosts {
+		if host.healthy {
+			retval = append(retval, host.address)
+		}
+	}
+	return retval
+}
+
+// pick chooses a healthy host for a new environment and counts it as
+// active until release is called
+func (hp *hostPool) pick() (string, error) {
+	hp.lock.Lock()
+	defer hp.lock.Unlock()
+	var chosen *dockerHost
+	switch hp.strategy {
+	case config.RoundRobinBalance:
+		for i := 0; i < len(hp.hosts); i++ {
+			host := hp.hosts[(hp.next+i)%len(hp.hosts)]
+			if host.healthy {
+				chosen = host
+				hp.next = (hp.next + i + 1) % len(hp.hosts)
+				break
+			}
+		}
+	default:
+		for _, host := range hp.hosts {
+			if host.healthy && (chosen == nil || host.active < chosen.active) {
+				chosen = host
+			}
+		}
+	}
+	if chosen == nil {
+		return "", errorNoHealthyDockerHosts
+	}
+	chosen.active++
+	chosen.executions++
+	return chosen.address, nil
+}
+
+// acquire counts a cached environment on address as active again
+func (hp *hostPool) acquire(address string) {
+	hp.lock.Lock()
+	defer hp.lock.Unlock()
+	if host := hp.find(address); host != nil {
+		host.active++
+		host.executions++
+	}
+}
+
+func (hp *hostPool) release(address string) {
+	hp.lock.Lock()
+	defer hp.lock.Unlock()
+	if host := hp.find(address); host != nil && host.active > 0 {
+		host.active--
+	}
+}
+
+// setHealth records the outcome of the latest operation against
+// address. A nil err marks the host healthy.
+func (hp *hostPool) setHealth(address string, err error) {
+	hp.lock.Lock()
+	defer hp.lock.Unlock()
+	host := hp.find(address)
+	if host == nil {
+		return
+	}
+	if err == nil {
+		host.healthy = true
+		host.lastError = ""
+		return
+	}
+	host.healthy = false
+	host.lastError = fmt.Sprintf("%s", err)
+}
+
+func (hp *hostPool) status() []DockerHostStatus {
+	hp.lock.Lock()
+	defer hp.lock.Unlock()
+	retval := []DockerHostStatus{}
+	for _, host := range hp.hosts {
+		retval = append(retval, DockerHostStatus{
+			Address:    host.address,
+			Healthy:    host.healthy,
+			Active:     host.active,
+			Executions: host.executions,
+			Error:      host.lastError,
+		})
+	}
+	return retval
+}
+
+func (hp *hostPool) find(address string) *dockerHost {
+	for _, host := range hp.hosts {
+		if host.address == address {
+			return host
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package engines
+
+import (
+	"errors"
+	"github.com/operable/go-relay/relay/config"
+	"testing"
+)
+
+func TestLeastLoadedHostPool(t *testing.T) {
+	pool := newHostPool([]string{"tcp://docker1:2376", "tcp://docker2:2376"}, config.LeastLoadedBalance)
+	first, _ := pool.pick()
+	second, _ := pool.pick()
+	if first == second {
+		t.Errorf("Expected executions to be spread across hosts: %s %s", first, second)
+	}
+	pool.release(second)
+	if next, _ := pool.pick(); next != second {
+		t.Errorf("Expected least loaded host %s: %s", second, next)
+	}
+}
+
+func TestRoundRobinHostPool(t *testing.T) {
+	pool := newHostPool([]string{"tcp://docker1:2376", "tcp://docker2:2376", "tcp://docker3:2376"}, config.RoundRobinBalance)
+	pool.setHealth("tcp://docker2:2376", errors.New("connection refused"))
+	picked := []string{}
+	for i := 0; i < 3; i++ {
+		address, _ := pool.pick()
+		picked = append(picked, address)
+	}
+	if picked[0] != "tcp://docker1:2376" || picked[1] != "tcp://docker3:2376" || picked[2] != "tcp://docker1:2376" {
+		t.Errorf("Unexpected round robin order: %v", picked)
+	}
+}
+
+func TestUnhealthyHostPool(t *testing.T) {
+	pool := newHostPool([]string{"tcp://docker1:2376"}, config.LeastLoadedBalance)
+	pool.setHealth("tcp://docker1:2376", errors.New("connection refused"))
+	if _, err := pool.pick(); err != errorNoHealthyDockerHosts {
+		t.Errorf("Expected errorNoHealthyDockerHosts: %v", err)
+	}
+	status := pool.status()
+	if status[0].Healthy || status[0].Error != "connection refused" {
+		t.Errorf("Unexpected host status: %+v", status[0])
+	}
+	pool.setHealth("tcp://docker1:2376", nil)
+	if _, err := pool.pick(); err != nil {
+		t.Errorf("Expected recovered host to be picked: %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"github.com/operable/circuit"
 	"github.com/operable/go-relay/relay/config"
+	"time"
 )
 
 // NativeEngine executes commands natively, that is directly,
@@ -15,6 +16,10 @@ type NativeEngine struct {
 var errorDisabled = errors.New("Native execution engine is disabled.")
 var errorUnknownCommand = errors.New("Unknown command")
 
+// How long timed out native commands have to exit after SIGTERM when
+// Relay has no Docker settings
+const defaultNativeKillGrace = 10 * time.Second
+
 // NewNativeEngine constructs a new instance
 func NewNativeEngine(relayConfig *config.Config) (Engine, error) {
 	if relayConfig.NativeEnabled() == true {
@@ -35,12 +40,14 @@ func (ne *NativeEngine) IsAvailable(name string, version string) (bool, error) {
 	return true, nil
 }
 
-// NewEnvironment is required by the engines.Engine interface
+// NewEnvironment is required by the engines.Engine interface. Timed
+// out native commands get as long to exit as Docker commands.
 func (ne *NativeEngine) NewEnvironment(pipelineID string, bundle *config.Bundle) (circuit.Environment, error) {
-	options := circuit.CreateEnvironmentOptions{}
-	options.Kind = circuit.NativeKind
-	options.Bundle = bundle.Name
-	return circuit.CreateEnvironment(options)
+	killGrace := defaultNativeKillGrace
+	if ne.relayConfig.Docker != nil {
+		killGrace = ne.relayConfig.Docker.ShutdownGraceDuration()
+	}
+	return newNativeEnvironment(bundle.Name, killGrace), nil
 }
 
 // ReleaseEnvironment is required by the engines.Engine interface
@@ -0,0 +1,144 @@
+package engines
+
+import (
+	"bytes"
+	log "github.com/Sirupsen/logrus"
+	"github.com/operable/circuit"
+	"github.com/operable/circuit-driver/api"
+	"golang.org/x/net/context"
+	"regexp"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var forkExecPrefix = regexp.MustCompile("^fork/exec ")
+
+// nativeEnvironment runs commands directly on the Relay host. Unlike
+// circuit's native environment it stops commands whose context is
+// done, along with any processes they started.
+type nativeEnvironment struct {
+	lock      sync.Mutex
+	bundle    string
+	killGrace time.Duration
+	userData  circuit.EnvironmentUserData
+	isDead    bool
+}
+
+func newNativeEnvironment(bundle string, killGrace time.Duration) *nativeEnvironment {
+	return &nativeEnvironment{
+		bundle:    bundle,
+		killGrace: killGrace,
+	}
+}
+
+// GetKind is required by the circuit.Environment interface
+func (ne *nativeEnvironment) GetKind() circuit.EnvironmentKind {
+	return circuit.NativeKind
+}
+
+// SetUserData is required by the circuit.Environment interface
+func (ne *nativeEnvironment) SetUserData(data circuit.EnvironmentUserData) error {
+	ne.lock.Lock()
+	defer ne.lock.Unlock()
+	if ne.isDead {
+		return circuit.ErrorDeadEnvironment
+	}
+	ne.userData = data
+	return nil
+}
+
+// GetUserData is required by the circuit.Environment interface
+func (ne *nativeEnvironment) GetUserData() (circuit.EnvironmentUserData, error) {
+	ne.lock.Lock()
+	defer ne.lock.Unlock()
+	if ne.isDead {
+		return nil, circuit.ErrorDeadEnvironment
+	}
+	return ne.userData, nil
+}
+
+// GetMetadata is required by the circuit.Environment interface
+func (ne *nativeEnvironment) GetMetadata() circuit.EnvironmentMetadata {
+	return circuit.EnvironmentMetadata{
+		"bundle": ne.bundle,
+	}
+}
+
+// Run is required by the circuit.Environment interface
+func (ne *nativeEnvironment) Run(request api.ExecRequest) (api.ExecResult, error) {
+	return ne.RunContext(context.Background(), request)
+}
+
+// RunContext is required by the ContextRunner interface. Commands
+// still running when ctx is done are sent SIGTERM and, after the
+// kill grace period, SIGKILL.
+func (ne *nativeEnvironment) RunContext(ctx context.Context, request api.ExecRequest) (api.ExecResult, error) {
+	ne.lock.Lock()
+	dead := ne.isDead
+	ne.lock.Unlock()
+	if dead {
+		return circuit.EmptyExecResult, circuit.ErrorDeadEnvironment
+	}
+	if ctx.Err() != nil {
+		return circuit.EmptyExecResult, ContextError(ctx)
+	}
+	command := request.ToExecCommand()
+	var stdout, stderr bytes.Buffer
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+	// Commands get their own process group so processes they start
+	// are stopped with them
+	command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	result := api.ExecResult{}
+	started := time.Now()
+	err := command.Start()
+	if err == nil {
+		done := make(chan error, 1)
+		go func() {
+			done <- command.Wait()
+		}()
+		select {
+		case err = <-done:
+		case <-ctx.Done():
+			reason := ContextError(ctx)
+			log.Warnf("Stopping native command %s for bundle %s: %s.", command.Path, ne.bundle, reason)
+			ne.terminate(command.Process.Pid, done)
+			return circuit.EmptyExecResult, reason
+		}
+	}
+	result.SetElapsed(time.Now().Sub(started))
+	if err != nil {
+		stderr.WriteString(forkExecPrefix.ReplaceAllString(err.Error(), ""))
+		result.SetSuccess(false)
+	} else {
+		result.SetSuccess(true)
+	}
+	result.Stderr = stderr.Bytes()
+	result.Stdout = stdout.Bytes()
+	return result, nil
+}
+
+// terminate sends SIGTERM to a command's process group and SIGKILL
+// if it hasn't exited within the kill grace period
+func (ne *nativeEnvironment) terminate(pid int, done <-chan error) {
+	syscall.Kill(-pid, syscall.SIGTERM)
+	select {
+	case <-done:
+	case <-time.After(ne.killGrace):
+		log.Warnf("Native command for bundle %s didn't exit within %v. Killing it.", ne.bundle, ne.killGrace)
+		syscall.Kill(-pid, syscall.SIGKILL)
+		<-done
+	}
+}
+
+// Shutdown is required by the circuit.Environment interface
+func (ne *nativeEnvironment) Shutdown() error {
+	ne.lock.Lock()
+	defer ne.lock.Unlock()
+	if ne.isDead {
+		return circuit.ErrorDeadEnvironment
+	}
+	ne.isDead = true
+	return nil
+}
@@ -0,0 +1,64 @@
+package engines
+
+import (
+	"github.com/operable/circuit-driver/api"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func nativeScript(t *testing.T, dir string, body string) *api.ExecRequest {
+	path := filepath.Join(dir, "command")
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	request := api.NewExecRequest()
+	request.SetExecutable(path)
+	return request
+}
+
+func TestNativeEnvironmentRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "native")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	env := newNativeEnvironment("test", time.Second)
+	result, err := env.Run(*nativeScript(t, dir, "cat; echo oops >&2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.GetSuccess() == false || string(result.Stderr) != "oops\n" {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+}
+
+func TestNativeEnvironmentTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "native")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	env := newNativeEnvironment("test", 100*time.Millisecond)
+	// The trap keeps the script alive past SIGTERM so SIGKILL is
+	// needed, and the background sleep must die with it
+	request := nativeScript(t, dir, "trap '' TERM\nsleep 30 &\nwait")
+	ctx, cancel := WithExecutionTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	_, err = env.RunContext(ctx, *request)
+	if timeout, ok := err.(*ExecutionTimeoutError); ok == false || timeout.Timeout != 100*time.Millisecond {
+		t.Fatalf("Expected an execution timeout: %v", err)
+	}
+	if elapsed := time.Now().Sub(started); elapsed > 5*time.Second {
+		t.Errorf("Command wasn't stopped promptly: %v", elapsed)
+	}
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := env.RunContext(cancelled, *request); err != context.Canceled {
+		t.Errorf("Expected cancellation: %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package engines
+
+import (
+	"sync"
+)
+
+// ImagePullStatus summarizes the progress of an in-flight image pull
+type ImagePullStatus struct {
+	Image      string `json:"image"`
+	Downloaded int64  `json:"downloaded"`
+	Size       int64  `json:"size"`
+	Layers     int    `json:"layers"`
+}
+
+type layerProgress struct {
+	current int64
+	total   int64
+}
+
+// sharedPull is a pull other callers can wait on instead of pulling
+// the same image again
+type sharedPull struct {
+	done chan struct{}
+	err  error
+}
+
+// pullTracker records download progress of in-flight image pulls
+// across all DockerEngine instances
+type pullTracker struct {
+	lock     sync.Mutex
+	pulls    map[string]map[string]*layerProgress
+	inflight map[string]*sharedPull
+}
+
+func newPullTracker() *pullTracker {
+	return &pullTracker{
+		pulls:    make(map[string]map[string]*layerProgress),
+		inflight: make(map[string]*sharedPull),
+	}
+}
+
+// share runs pull unless a pull with the same key is already in
+// progress, in which case it waits for that pull and returns its
+// result
+func (pt *pullTracker) share(key string, pull func() error) error {
+	pt.lock.Lock()
+	if existing, ok := pt.inflight[key]; ok {
+		pt.lock.Unlock()
+		<-existing.done
+		return existing.err
+	}
+	current := &sharedPull{
+		done: make(chan struct{}),
+	}
+	pt.inflight[key] = current
+	pt.lock.Unlock()
+	current.err = pull()
+	pt.lock.Lock()
+	delete(pt.inflight, key)
+	pt.lock.Unlock()
+	close(current.done)
+	return current.err
+}
+
+func (pt *pullTracker) begin(image string) {
+	pt.lock.Lock()
+	defer pt.lock.Unlock()
+	pt.pulls[image] = make(map[string]*layerProgress)
+}
+
+func (pt *pullTracker) update(image string, layer string, current int64, total int64) {
+	pt.lock.Lock()
+	defer pt.lock.Unlock()
+	layers := pt.pulls[image]
+	if layers == nil || layer == "" {
+		return
+	}
+	progress := layers[layer]
+	if progress == nil {
+		progress = &layerProgress{}
+		layers[layer] = progress
+	}
+	if total > 0 {
+		progress.total = total
+	}
+	if current > progress.current {
+		progress.current = current
+	}
+}
+
+func (pt *pullTracker) end(image string) {
+	pt.lock.Lock()
+	defer pt.lock.Unlock()
+	delete(pt.pulls, image)
+}
+
+func (pt *pullTracker) active() map[string]ImagePullStatus {
+	pt.lock.Lock()
+	defer pt.lock.Unlock()
+	retval := make(map[string]ImagePullStatus)
+	for image, layers := range pt.pulls {
+		status := ImagePullStatus{
+			Image:  image,
+			Layers: len(layers),
+		}
+		for _, layer := range layers {
+			status.Downloaded += layer.current
+			status.Size += layer.total
+		}
+		retval[image] = status
+	}
+	return retval
+}
+
+// ActivePulls returns the progress of in-flight image pulls keyed
+// by image name
+func (e *Engines) ActivePulls() map[string]ImagePullStatus {
+	return e.pulls.active()
+}
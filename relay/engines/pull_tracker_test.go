@@ -0,0 +1,61 @@
+package engines
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPullTracker(t *testing.T) {
+	tracker := newPullTracker()
+	tracker.update("ignored:latest", "a1", 10, 100)
+	tracker.begin("bundle:1.0")
+	tracker.update("bundle:1.0", "a1", 10, 100)
+	tracker.update("bundle:1.0", "a1", 60, 100)
+	tracker.update("bundle:1.0", "b2", 5, 50)
+	active := tracker.active()
+	if len(active) != 1 {
+		t.Fatalf("Expected one active pull: %v", active)
+	}
+	status := active["bundle:1.0"]
+	if status.Downloaded != 65 || status.Size != 150 || status.Layers != 2 {
+		t.Errorf("Unexpected pull status: %+v", status)
+	}
+	tracker.end("bundle:1.0")
+	if len(tracker.active()) != 0 {
+		t.Error("Expected no active pulls")
+	}
+}
+
+func TestPullTrackerShare(t *testing.T) {
+	tracker := newPullTracker()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	pulls := 0
+	results := make(chan error, 2)
+	go func() {
+		results <- tracker.share("bundle:1.0", func() error {
+			pulls++
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	<-started
+	go func() {
+		results <- tracker.share("bundle:1.0", func() error {
+			pulls++
+			return nil
+		})
+	}()
+	// Give the second pull time to find the first one in flight
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-results; err != nil {
+			t.Errorf("Unexpected pull error: %s", err)
+		}
+	}
+	if pulls != 1 {
+		t.Errorf("Expected one pull: %d", pulls)
+	}
+}
@@ -0,0 +1,49 @@
+package relay
+
+import (
+	"github.com/operable/go-relay/relay/admin"
+	"github.com/operable/go-relay/relay/events"
+	"net/http"
+	"time"
+)
+
+// registerEventLog records lifecycle events in Relay's event log
+func (r *cogRelay) registerEventLog() {
+	WithStateChangeHook(func(from RelayState, to RelayState) {
+		r.events.Record(events.StateChangeKind, "Relay changed from %s to %s.", from, to)
+	})(r)
+	WithBundleRefreshHook(func(bundles []string) {
+		r.events.Record(events.RefreshKind, "Bundle catalog refreshed with %d bundles.", len(bundles))
+	})(r)
+	WithConnectHook(func() {
+		r.events.Record(events.ConnectionKind, "Connected to Cog.")
+	})(r)
+	WithDisconnectHook(func() {
+		r.events.Record(events.ConnectionKind, "Disconnected from Cog.")
+	})(r)
+}
+
+// adminEvents lists recent events, oldest first. since accepts
+// either a duration, meaning that long ago, or an RFC 3339 time.
+func (r *cogRelay) adminEvents(w http.ResponseWriter, req *http.Request) {
+	if !admin.RequireMethod(w, req, "GET") {
+		return
+	}
+	params := req.URL.Query()
+	query := events.Query{
+		Kind: params.Get("kind"),
+	}
+	if since := params.Get("since"); since != "" {
+		if ago, err := time.ParseDuration(since); err == nil {
+			query.Since = time.Now().Add(-ago)
+		} else {
+			parsed, err := time.Parse(time.RFC3339, since)
+			if err != nil {
+				admin.WriteError(w, http.StatusBadRequest, err)
+				return
+			}
+			query.Since = parsed
+		}
+	}
+	admin.WriteJSON(w, http.StatusOK, r.events.Query(query))
+}
@@ -0,0 +1,97 @@
+package events
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Event kinds
+const (
+	// StateChangeKind events record Relay lifecycle state changes
+	StateChangeKind = "state_change"
+	// RefreshKind events record bundle catalog refreshes
+	RefreshKind = "refresh"
+	// ExecutionErrorKind events record commands which failed for
+	// reasons outside the command's control
+	ExecutionErrorKind = "execution_error"
+	// ConnectionKind events record connections to and disconnections
+	// from Cog
+	ConnectionKind = "connection"
+	// WatchdogKind events record Relay exceeding its resource limits
+	WatchdogKind = "watchdog"
+)
+
+// Event is a significant occurrence in the life of a Relay
+type Event struct {
+	Timestamp time.Time `json:"timestamp"`
+	Kind      string    `json:"kind"`
+	Message   string    `json:"message"`
+}
+
+// Query filters events returned by Log.Query. Zero values match
+// everything.
+type Query struct {
+	Kind  string
+	Since time.Time
+}
+
+// Log is an in-memory ring buffer of recent events. Once full the
+// oldest event is overwritten. A nil Log discards events.
+type Log struct {
+	lock   sync.Mutex
+	events []Event
+	next   int
+	full   bool
+}
+
+// NewLog returns a Log holding up to size events
+func NewLog(size int) *Log {
+	return &Log{
+		events: make([]Event, size),
+	}
+}
+
+// Record adds an event to the log. The message is built from format
+// and args as by fmt.Sprintf.
+func (l *Log) Record(kind string, format string, args ...interface{}) {
+	if l == nil || len(l.events) == 0 {
+		return
+	}
+	event := Event{
+		Timestamp: time.Now().UTC(),
+		Kind:      kind,
+		Message:   fmt.Sprintf(format, args...),
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.events[l.next] = event
+	l.next = (l.next + 1) % len(l.events)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// Query returns matching events, oldest first
+func (l *Log) Query(query Query) []Event {
+	retval := []Event{}
+	if l == nil {
+		return retval
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	ordered := l.events[:l.next]
+	if l.full == true {
+		ordered = append(append([]Event{}, l.events[l.next:]...), l.events[:l.next]...)
+	}
+	for _, event := range ordered {
+		if query.Kind != "" && event.Kind != query.Kind {
+			continue
+		}
+		if event.Timestamp.Before(query.Since) {
+			continue
+		}
+		retval = append(retval, event)
+	}
+	return retval
+}
@@ -0,0 +1,42 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLogWrapsAround(t *testing.T) {
+	log := NewLog(3)
+	for i := 1; i <= 5; i++ {
+		log.Record(RefreshKind, "Refresh %d.", i)
+	}
+	recorded := log.Query(Query{})
+	if len(recorded) != 3 {
+		t.Fatalf("Expected 3 events: %v", recorded)
+	}
+	for i, expected := range []string{"Refresh 3.", "Refresh 4.", "Refresh 5."} {
+		if recorded[i].Message != expected {
+			t.Errorf("Expected %s at %d: %s", expected, i, recorded[i].Message)
+		}
+	}
+}
+
+func TestLogQuery(t *testing.T) {
+	log := NewLog(10)
+	log.Record(StateChangeKind, "Relay is ready.")
+	log.Record(ConnectionKind, "Disconnected from Cog.")
+	if recorded := log.Query(Query{Kind: ConnectionKind}); len(recorded) != 1 || recorded[0].Kind != ConnectionKind {
+		t.Errorf("Unexpected events: %v", recorded)
+	}
+	if recorded := log.Query(Query{Since: time.Now().Add(time.Minute)}); len(recorded) != 0 {
+		t.Errorf("Expected no events: %v", recorded)
+	}
+}
+
+func TestNilLog(t *testing.T) {
+	var log *Log
+	log.Record(RefreshKind, "Ignored.")
+	if recorded := log.Query(Query{}); len(recorded) != 0 {
+		t.Errorf("Expected no events: %v", recorded)
+	}
+}
@@ -0,0 +1,143 @@
+package facts
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/operable/go-relay/relay/config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EnvPrefix is prepended to fact names when they are exported to
+// command environments
+const EnvPrefix = "RELAY_FACT_"
+
+var illegalEnvChars = regexp.MustCompile("[^A-Z0-9_]")
+
+// Facts is a snapshot of information about the Relay host
+type Facts map[string]string
+
+// Names returns the fact names in sorted order
+func (f Facts) Names() []string {
+	names := []string{}
+	for name := range f {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// EnvVars converts facts into environment variables suitable for
+// command execution
+func (f Facts) EnvVars() map[string]string {
+	retval := make(map[string]string)
+	for name, value := range f {
+		envName := illegalEnvChars.ReplaceAllString(strings.ToUpper(name), "_")
+		retval[EnvPrefix+envName] = value
+	}
+	return retval
+}
+
+// Gatherer collects host facts at startup and on a fixed interval
+type Gatherer struct {
+	config       config.FactsInfo
+	lock         sync.RWMutex
+	current      Facts
+	gatheredAt   time.Time
+	refreshTimer *time.Timer
+}
+
+// NewGatherer constructs a new Gatherer
+func NewGatherer(factsConfig config.FactsInfo) *Gatherer {
+	return &Gatherer{
+		config:  factsConfig,
+		current: make(Facts),
+	}
+}
+
+// Run gathers facts and schedules periodic refreshes
+func (g *Gatherer) Run() {
+	g.Refresh()
+	log.Infof("Refreshing host facts every %v.", g.config.RefreshDuration())
+	g.refreshTimer = time.AfterFunc(g.config.RefreshDuration(), g.scheduledRefresh)
+}
+
+// Halt stops periodic refreshes
+func (g *Gatherer) Halt() {
+	if g.refreshTimer != nil {
+		g.refreshTimer.Stop()
+	}
+}
+
+// Current returns the most recently gathered facts
+func (g *Gatherer) Current() Facts {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	retval := make(Facts, len(g.current))
+	for k, v := range g.current {
+		retval[k] = v
+	}
+	return retval
+}
+
+// GatheredAt returns when facts were last gathered
+func (g *Gatherer) GatheredAt() time.Time {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	return g.gatheredAt
+}
+
+// Refresh gathers a fresh set of facts
+func (g *Gatherer) Refresh() {
+	gathered := hostFacts()
+	if g.config.CloudMetadata {
+		for k, v := range cloudFacts() {
+			gathered[k] = v
+		}
+	}
+	if g.config.Script != "" {
+		custom, err := scriptFacts(g.config.Script)
+		if err != nil {
+			log.Errorf("Error running host facts script %s: %s.", g.config.Script, err)
+		}
+		for k, v := range custom {
+			gathered[k] = v
+		}
+	}
+	g.lock.Lock()
+	g.current = gathered
+	g.gatheredAt = time.Now()
+	g.lock.Unlock()
+	if g.config.File != "" {
+		if err := writeFactsFile(g.config.File, gathered); err != nil {
+			log.Errorf("Error writing host facts file %s: %s.", g.config.File, err)
+		}
+	}
+	log.Debugf("Gathered %d host facts.", len(gathered))
+}
+
+func (g *Gatherer) scheduledRefresh() {
+	g.Refresh()
+	g.refreshTimer = time.AfterFunc(g.config.RefreshDuration(), g.scheduledRefresh)
+}
+
+// writeFactsFile atomically replaces the facts file so commands never
+// read a partially written file
+func writeFactsFile(path string, facts Facts) error {
+	data, err := json.MarshalIndent(facts, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.tmp", filepath.Base(path)))
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
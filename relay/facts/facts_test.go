@@ -0,0 +1,48 @@
+package facts
+
+import (
+	"testing"
+)
+
+func TestParseKeyValueScriptOutput(t *testing.T) {
+	facts, err := parseScriptOutput([]byte("# comment\nrack=r12\n\nrole = db\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if facts["rack"] != "r12" {
+		t.Errorf("Expected rack fact to be 'r12': %s", facts["rack"])
+	}
+	if facts["role"] != "db" {
+		t.Errorf("Expected role fact to be 'db': %s", facts["role"])
+	}
+}
+
+func TestParseJSONScriptOutput(t *testing.T) {
+	facts, err := parseScriptOutput([]byte(`{"rack": "r12", "slots": 4}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if facts["slots"] != "4" {
+		t.Errorf("Expected slots fact to be '4': %s", facts["slots"])
+	}
+}
+
+func TestParseBadScriptOutput(t *testing.T) {
+	if _, err := parseScriptOutput([]byte("rack")); err == nil {
+		t.Error("Expected malformed fact to be rejected")
+	}
+}
+
+func TestEnvVars(t *testing.T) {
+	facts := Facts{
+		"memory_mb":  "2048",
+		"cloud.zone": "us-east-1a",
+	}
+	env := facts.EnvVars()
+	if env["RELAY_FACT_MEMORY_MB"] != "2048" {
+		t.Errorf("Unexpected RELAY_FACT_MEMORY_MB: %s", env["RELAY_FACT_MEMORY_MB"])
+	}
+	if env["RELAY_FACT_CLOUD_ZONE"] != "us-east-1a" {
+		t.Errorf("Unexpected RELAY_FACT_CLOUD_ZONE: %s", env["RELAY_FACT_CLOUD_ZONE"])
+	}
+}
@@ -0,0 +1,194 @@
+package facts
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var metadataTimeout = time.Duration(2) * time.Second
+var scriptTimeout = time.Duration(30) * time.Second
+
+func hostFacts() Facts {
+	facts := Facts{
+		"os":        runtime.GOOS,
+		"arch":      runtime.GOARCH,
+		"cpu_count": strconv.Itoa(runtime.NumCPU()),
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		facts["hostname"] = hostname
+	}
+	if kernel, err := ioutil.ReadFile("/proc/sys/kernel/osrelease"); err == nil {
+		facts["kernel"] = strings.TrimSpace(string(kernel))
+	}
+	if memory := totalMemoryMB(); memory > 0 {
+		facts["memory_mb"] = strconv.FormatInt(memory, 10)
+	}
+	return facts
+}
+
+func totalMemoryMB() int64 {
+	meminfo, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer meminfo.Close()
+	scanner := bufio.NewScanner(meminfo)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0
+			}
+			return kb / 1024
+		}
+	}
+	return 0
+}
+
+// cloudFacts queries the well-known instance metadata services of the
+// supported cloud providers. Only the first provider to respond is used.
+func cloudFacts() Facts {
+	client := &http.Client{
+		Timeout: metadataTimeout,
+	}
+	if facts := ec2Facts(client); facts != nil {
+		return facts
+	}
+	if facts := gceFacts(client); facts != nil {
+		return facts
+	}
+	return Facts{}
+}
+
+func ec2Facts(client *http.Client) Facts {
+	tokenReq, _ := http.NewRequest("PUT", "http://169.254.169.254/latest/api/token", nil)
+	tokenReq.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	token, err := fetchMetadata(client, tokenReq)
+	if err != nil {
+		return nil
+	}
+	facts := Facts{
+		"cloud_provider": "aws",
+	}
+	paths := map[string]string{
+		"cloud_instance_id":       "instance-id",
+		"cloud_instance_type":     "instance-type",
+		"cloud_availability_zone": "placement/availability-zone",
+		"cloud_region":            "placement/region",
+	}
+	for name, path := range paths {
+		req, _ := http.NewRequest("GET", "http://169.254.169.254/latest/meta-data/"+path, nil)
+		req.Header.Set("X-aws-ec2-metadata-token", token)
+		if value, err := fetchMetadata(client, req); err == nil {
+			facts[name] = value
+		}
+	}
+	return facts
+}
+
+func gceFacts(client *http.Client) Facts {
+	paths := map[string]string{
+		"cloud_instance_id":       "instance/id",
+		"cloud_instance_type":     "instance/machine-type",
+		"cloud_availability_zone": "instance/zone",
+		"cloud_project":           "project/project-id",
+	}
+	var facts Facts
+	for name, path := range paths {
+		req, _ := http.NewRequest("GET", "http://metadata.google.internal/computeMetadata/v1/"+path, nil)
+		req.Header.Set("Metadata-Flavor", "Google")
+		value, err := fetchMetadata(client, req)
+		if err != nil {
+			continue
+		}
+		if facts == nil {
+			facts = Facts{
+				"cloud_provider": "gcp",
+			}
+		}
+		// Machine type and zone are returned as resource paths
+		facts[name] = value[strings.LastIndex(value, "/")+1:]
+	}
+	return facts
+}
+
+func fetchMetadata(client *http.Client, req *http.Request) (string, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Metadata request for %s returned status %d", req.URL.Path, resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// scriptFacts runs an operator supplied script and parses its output.
+// Scripts may emit either a flat JSON object or KEY=VALUE lines.
+func scriptFacts(script string) (Facts, error) {
+	command := exec.Command(script)
+	var stdout, stderr bytes.Buffer
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+	if err := command.Start(); err != nil {
+		return nil, err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- command.Wait()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", err, strings.TrimSpace(stderr.String()))
+		}
+	case <-time.After(scriptTimeout):
+		command.Process.Kill()
+		return nil, fmt.Errorf("Script timed out after %v", scriptTimeout)
+	}
+	return parseScriptOutput(stdout.Bytes())
+}
+
+func parseScriptOutput(output []byte) (Facts, error) {
+	facts := Facts{}
+	trimmed := bytes.TrimSpace(output)
+	if bytes.HasPrefix(trimmed, []byte("{")) {
+		var raw map[string]interface{}
+		if err := json.Unmarshal(trimmed, &raw); err != nil {
+			return nil, err
+		}
+		for k, v := range raw {
+			facts[k] = fmt.Sprintf("%v", v)
+		}
+		return facts, nil
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Illegal fact specification: %s", line)
+		}
+		facts[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return facts, nil
+}
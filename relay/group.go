@@ -0,0 +1,133 @@
+package relay
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/operable/go-relay/relay/bundle"
+	"github.com/operable/go-relay/relay/bus"
+	"github.com/operable/go-relay/relay/config"
+	"github.com/operable/go-relay/relay/messages"
+	"strings"
+	"time"
+)
+
+// groupSettleDelay is how long a Relay sharding bundles waits for
+// existing group members to answer its first heartbeat before loading
+// its bundle catalog
+const groupSettleDelay = 2 * time.Second
+
+func (r *cogRelay) groupTopic() string {
+	return fmt.Sprintf(bus.GroupTopicTemplate, r.currentConfig().Group.ID)
+}
+
+// publishGroupMember tells the rest of the group whether this Relay
+// is a member
+func (r *cogRelay) publishGroupMember(online bool) {
+	conn := r.conn
+	if conn == nil {
+		return
+	}
+	data, _ := json.Marshal(messages.GroupMember{
+		RelayID:   r.currentConfig().ID,
+		Group:     r.currentConfig().Group.ID,
+		Online:    online,
+		Timestamp: time.Now().Unix(),
+	})
+	if err := conn.Publish(r.groupTopic(), data); err != nil {
+		log.Errorf("Failed to publish group heartbeat: %s.", err)
+	}
+}
+
+// scheduledGroupHeartbeat publishes a heartbeat, drops members which
+// have stopped sending theirs and schedules the next heartbeat
+func (r *cogRelay) scheduledGroupHeartbeat() {
+	if r.stopped() {
+		return
+	}
+	r.publishGroupMember(true)
+	if expired := r.membership.Expire(time.Now()); len(expired) > 0 {
+		log.Warnf("Relay group members stopped sending heartbeats: %s.", strings.Join(expired, ", "))
+		r.groupChanged()
+	}
+	r.groupTimer.Reset(r.currentConfig().Group.HeartbeatDuration())
+}
+
+func (r *cogRelay) handleGroupMember(conn bus.Connection, topic string, message []byte) {
+	var member messages.GroupMember
+	if err := json.Unmarshal(message, &member); err != nil {
+		log.Errorf("Ignoring malformed group heartbeat: %s.", err)
+		return
+	}
+	if member.RelayID == r.currentConfig().ID || member.RelayID == "" {
+		return
+	}
+	if member.Online == true {
+		if r.membership.Seen(member.RelayID, time.Now()) {
+			log.Infof("Relay %s joined group %s.", member.RelayID, r.currentConfig().Group.ID)
+			// Answer right away so new members needn't wait a full
+			// heartbeat interval to learn about this one
+			r.publishGroupMember(true)
+			r.groupChanged()
+		}
+	} else if r.membership.Left(member.RelayID) {
+		log.Infof("Relay %s left group %s.", member.RelayID, r.currentConfig().Group.ID)
+		r.groupChanged()
+	}
+}
+
+// groupChanged re-shards the bundle catalog and re-elects singleton
+// bundles after members join or leave by asking Cog for the full
+// assignment again
+func (r *cogRelay) groupChanged() {
+	if r.currentConfig().Group.Sharding == false && r.hasSingletons() == false {
+		return
+	}
+	if err := r.requestBundles(); err != nil {
+		log.Errorf("Failed to refresh bundle catalog after group membership changed: %s.", err)
+	}
+}
+
+func (r *cogRelay) isSingleton(name string) bool {
+	return r.currentConfig().SettingsForBundle(name).Singleton
+}
+
+func (r *cogRelay) hasSingletons() bool {
+	for name := range r.currentConfig().Bundles {
+		if r.isSingleton(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// electSingletons drops singleton bundles which another group member
+// serves. Singletons this Relay has just become the owner of are
+// picked up by a catalog refresh once the handover delay has passed.
+func (r *cogRelay) electSingletons(bundles []*config.Bundle) []*config.Bundle {
+	if r.singletons == nil {
+		return bundles
+	}
+	served, wait := r.singletons.Filter(bundles, r.isSingleton, r.membership.Members(), time.Now())
+	if wait > 0 {
+		log.Infof("Taking over singleton bundles from other Relay group members in %v.", wait)
+		if r.handoverTimer == nil {
+			r.handoverTimer = time.AfterFunc(wait, r.scheduledBundleRefresh)
+		} else {
+			r.handoverTimer.Reset(wait)
+		}
+	}
+	return served
+}
+
+// shardAssignment returns the bundles this Relay serves when bundles
+// are sharded across its group
+func (r *cogRelay) shardAssignment(bundles []*config.Bundle) []*config.Bundle {
+	if r.membership == nil || r.currentConfig().Group.Sharding == false {
+		return bundles
+	}
+	members := r.membership.Members()
+	owned := bundle.Shard(bundles, r.currentConfig().ID, members)
+	log.Debugf("Serving %d of %d assigned bundles shared with %d group members.", len(owned), len(bundles), len(members))
+	return owned
+}
@@ -0,0 +1,182 @@
+package relay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/operable/go-relay/relay/bus"
+	"github.com/operable/go-relay/relay/messages"
+	"strings"
+	"sync"
+	"time"
+)
+
+const handshakeTopicTemplate = "bot/relays/%s/handshake"
+
+var errorProtocolTooOld = errors.New("Cog no longer accepts this Relay's protocol version")
+
+// protocolState records what Relay and Cog agreed on during the most
+// recent handshake. Optional features stay off until the handshake
+// on the current connection has finished.
+type protocolState struct {
+	lock       sync.Mutex
+	cogVersion int
+	features   []string
+}
+
+func (ps *protocolState) set(cogVersion int, features []string) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	ps.cogVersion = cogVersion
+	ps.features = features
+}
+
+func (ps *protocolState) get() (int, []string) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	return ps.cogVersion, ps.features
+}
+
+// supports returns true if Cog agreed to feature
+func (ps *protocolState) supports(feature string) bool {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	for _, agreed := range ps.features {
+		if agreed == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// protocolFeatures lists the optional protocol features this Relay
+// offers Cog
+func (r *cogRelay) protocolFeatures() []string {
+	features := []string{messages.FeatureGzip, messages.FeatureProtobuf, messages.FeatureStages}
+	if r.sealer != nil {
+		features = append(features, messages.FeatureSealed)
+	}
+	if r.currentConfig().Cog.Presence == true {
+		features = append(features, messages.FeaturePresence)
+	}
+	return features
+}
+
+// handshake negotiates a protocol version and optional features with
+// Cog. Cogs which don't answer within cog/handshake_timeout are
+// assumed to predate negotiation and get none of the optional
+// features. With the handshake disabled Cog is assumed to support
+// every feature Relay is configured to use.
+func (r *cogRelay) handshake(conn bus.Connection) {
+	timeout := r.currentConfig().Cog.HandshakeDuration()
+	if timeout == 0 {
+		r.protocol.set(0, r.protocolFeatures())
+		r.protocolAgreed(conn)
+		return
+	}
+	replyTo := fmt.Sprintf(handshakeTopicTemplate, r.currentConfig().ID)
+	replies := make(chan messages.HandshakeReply, 1)
+	err := conn.Subscribe(replyTo, func(conn bus.Connection, topic string, payload []byte) {
+		reply := messages.HandshakeReply{}
+		if err := json.Unmarshal(payload, &reply); err != nil {
+			log.Errorf("Ignoring malformed handshake reply: %s.", err)
+			return
+		}
+		select {
+		case replies <- reply:
+		default:
+		}
+	})
+	if err != nil {
+		log.Errorf("Failed to subscribe to handshake replies: %s.", err)
+		return
+	}
+	hello, _ := json.Marshal(&messages.Handshake{
+		RelayID:         r.currentConfig().ID,
+		ProtocolVersion: messages.ProtocolVersion,
+		Features:        r.protocolFeatures(),
+		ReplyTo:         replyTo,
+	})
+	if err := conn.Publish(bus.HandshakeTopic, hello); err != nil {
+		log.Errorf("Failed to send protocol handshake: %s.", err)
+		return
+	}
+	select {
+	case reply := <-replies:
+		if r.negotiated(reply) == false {
+			return
+		}
+	case <-r.ctx.Done():
+		return
+	case <-time.After(timeout):
+		log.Warnf("Cog didn't answer the protocol handshake within %v. Assuming protocol version 1 without optional features.", timeout)
+		r.protocol.set(1, []string{})
+	}
+	r.protocolAgreed(conn)
+}
+
+// protocolAgreed sends the messages which had to wait until Relay and
+// Cog agreed on the optional features
+func (r *cogRelay) protocolAgreed(conn bus.Connection) {
+	if r.presenceEnabled() == false {
+		return
+	}
+	if err := conn.Publish(r.presenceTopic(), []byte(newPresence(r.currentConfig().ID, true, time.Now()))); err != nil {
+		log.Errorf("Failed to publish Relay presence: %s.", err)
+	}
+}
+
+// presenceEnabled returns true if Relay is configured to publish
+// presence messages and Cog agreed to them
+func (r *cogRelay) presenceEnabled() bool {
+	return r.currentConfig().Cog.Presence == true && r.protocol.supports(messages.FeaturePresence)
+}
+
+// announcementEncoding returns the encoding of announcements. They're
+// JSON unless Relay is configured to use protobuf and Cog agreed to it.
+func (r *cogRelay) announcementEncoding() string {
+	if r.currentConfig().Cog.Encoding == messages.EncodingProtobuf && r.protocol.supports(messages.FeatureProtobuf) {
+		return messages.EncodingProtobuf
+	}
+	return messages.EncodingJSON
+}
+
+// negotiated records the outcome of the handshake. Returns false if
+// Cog no longer accepts this Relay's protocol version.
+func (r *cogRelay) negotiated(reply messages.HandshakeReply) bool {
+	if reply.MinProtocolVersion > messages.ProtocolVersion {
+		log.Errorf("Cog requires Relay protocol version %d or newer but this Relay speaks version %d. Upgrade Relay.",
+			reply.MinProtocolVersion, messages.ProtocolVersion)
+		r.fail(errorProtocolTooOld)
+		return false
+	}
+	features := messages.Negotiate(r.protocolFeatures(), reply.Features)
+	r.protocol.set(reply.ProtocolVersion, features)
+	version := messages.ProtocolVersion
+	if reply.ProtocolVersion < version {
+		version = reply.ProtocolVersion
+	}
+	listed := strings.Join(features, ", ")
+	if listed == "" {
+		listed = "none"
+	}
+	log.Infof("Negotiated protocol version %d with Cog. Optional features: %s.", version, listed)
+	supported := make(map[string]bool, len(features))
+	for _, feature := range features {
+		supported[feature] = true
+	}
+	if r.currentConfig().Cog.Compression == bus.GzipCompression && supported[messages.FeatureGzip] == false {
+		log.Warn("cog/compression is gzip but Cog doesn't support compressed messages. Sending them uncompressed.")
+	}
+	if r.currentConfig().Cog.Encoding == messages.EncodingProtobuf && supported[messages.FeatureProtobuf] == false {
+		log.Warn("cog/message_encoding is protobuf but Cog doesn't support protobuf. Sending announcements as JSON.")
+	}
+	if r.sealer != nil && supported[messages.FeatureSealed] == false {
+		log.Warn("cog/payload_key is set but Cog doesn't support sealed payloads. Sending response bodies unencrypted.")
+	}
+	if r.currentConfig().Cog.Presence == true && supported[messages.FeaturePresence] == false {
+		log.Warn("cog/presence is enabled but Cog doesn't support presence messages. Not sending them.")
+	}
+	return true
+}
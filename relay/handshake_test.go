@@ -0,0 +1,35 @@
+package relay
+
+import (
+	"github.com/operable/go-relay/relay/config"
+	"github.com/operable/go-relay/relay/messages"
+	"testing"
+)
+
+func TestFeaturesWaitForHandshake(t *testing.T) {
+	r := &cogRelay{
+		config: &config.Config{
+			Cog: &config.CogInfo{Encoding: messages.EncodingProtobuf, Presence: true},
+		},
+	}
+	if r.announcementEncoding() != messages.EncodingJSON || r.presenceEnabled() == true {
+		t.Error("Expected optional features to be off before the handshake")
+	}
+	reply := messages.HandshakeReply{
+		ProtocolVersion: messages.ProtocolVersion,
+		Features:        []string{messages.FeatureProtobuf, messages.FeatureSealed},
+	}
+	if r.negotiated(reply) == false {
+		t.Fatal("Expected handshake to succeed")
+	}
+	if r.announcementEncoding() != messages.EncodingProtobuf {
+		t.Error("Expected protobuf announcements once Cog agreed to them")
+	}
+	if r.presenceEnabled() == true {
+		t.Error("Expected no presence messages when Cog didn't agree to them")
+	}
+	// Sealing wasn't offered since Relay has no payload key
+	if r.protocol.supports(messages.FeatureSealed) == true {
+		t.Error("Expected features Relay didn't offer not to be agreed")
+	}
+}
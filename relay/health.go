@@ -0,0 +1,98 @@
+package relay
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/operable/go-relay/relay/admin"
+	"github.com/operable/go-relay/relay/engines"
+	"net"
+	"net/http"
+	"time"
+)
+
+// healthReport is the response body of /healthz and /readyz
+type healthReport struct {
+	State       string                 `json:"state"`
+	Connected   bool                   `json:"connected"`
+	Ready       *bool                  `json:"ready,omitempty"`
+	Maintenance bool                   `json:"maintenance,omitempty"`
+	Flapping    bool                   `json:"flapping,omitempty"`
+	LastRefresh *time.Time             `json:"last_refresh"`
+	Engines     []engines.EngineHealth `json:"engines,omitempty"`
+}
+
+// startHealth serves /healthz and /readyz on health_listen so
+// orchestrators and load balancers can probe Relay. Unlike the admin
+// API it exposes nothing which changes Relay's behavior.
+func (r *cogRelay) startHealth() error {
+	listener, err := net.Listen("tcp", r.currentConfig().HealthListen)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", r.healthz)
+	mux.HandleFunc("/readyz", r.readyz)
+	r.healthServer = &http.Server{
+		Handler: mux,
+	}
+	go func() {
+		if err := r.healthServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Errorf("Health check listener stopped unexpectedly: %s.", err)
+		}
+	}()
+	log.Infof("Serving health checks on %s.", r.currentConfig().HealthListen)
+	return nil
+}
+
+// healthz succeeds as long as Relay is running
+func (r *cogRelay) healthz(w http.ResponseWriter, req *http.Request) {
+	if !admin.RequireMethod(w, req, "GET") {
+		return
+	}
+	admin.WriteJSON(w, http.StatusOK, r.healthReport(false))
+}
+
+// readyz succeeds when Relay can run commands: it's connected to
+// Cog, has loaded its bundle catalog (or a cached one) and at least
+// one engine is healthy
+func (r *cogRelay) readyz(w http.ResponseWriter, req *http.Request) {
+	if !admin.RequireMethod(w, req, "GET") {
+		return
+	}
+	report := r.healthReport(true)
+	status := http.StatusOK
+	if *report.Ready == false {
+		status = http.StatusServiceUnavailable
+	}
+	admin.WriteJSON(w, status, report)
+}
+
+// healthReport describes Relay's current state. Engines are only
+// checked if checkEngines is true since checking Docker is
+// comparatively slow.
+func (r *cogRelay) healthReport(checkEngines bool) *healthReport {
+	state, connected, lastRefresh := r.status.get()
+	report := &healthReport{
+		State:     state.String(),
+		Connected: connected,
+		Flapping:  r.status.isFlapping(),
+	}
+	if lastRefresh.IsZero() == false {
+		report.LastRefresh = &lastRefresh
+	}
+	if checkEngines == false {
+		return report
+	}
+	report.Engines = r.engines.Health()
+	engineHealthy := false
+	for _, engine := range report.Engines {
+		if engine.Healthy == true {
+			engineHealthy = true
+		}
+	}
+	report.Maintenance, _ = r.maintenance.Status()
+	// A degraded Relay is still serving its cached bundles
+	serving := state == RelayReady || state == RelayDegraded
+	ready := connected && engineHealthy && serving && report.Maintenance == false
+	report.Ready = &ready
+	return report
+}
@@ -0,0 +1,64 @@
+package relay
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/operable/go-relay/relay/bus"
+	"github.com/operable/go-relay/relay/messages"
+	"time"
+)
+
+// scheduledHeartbeat publishes a heartbeat and schedules the next one
+func (r *cogRelay) scheduledHeartbeat() {
+	if r.stopped() {
+		return
+	}
+	if conn := r.conn; conn != nil {
+		data, _ := json.Marshal(r.heartbeat(time.Now()))
+		if err := conn.Publish(fmt.Sprintf(bus.HeartbeatTopicTemplate, r.currentConfig().ID), data); err != nil {
+			log.Errorf("Failed to publish heartbeat: %s.", err)
+		}
+	}
+	r.heartbeatTimer.Reset(r.currentConfig().HeartbeatDuration())
+}
+
+func (r *cogRelay) heartbeat(now time.Time) *messages.Heartbeat {
+	readOnly, _ := r.readOnly.Status()
+	maintenance, _ := r.maintenance.Status()
+	usage := currentUsage()
+	depth, capacity := r.queueDepth()
+	heartbeat := &messages.Heartbeat{
+		RelayID:          r.currentConfig().ID,
+		Timestamp:        now.Unix(),
+		Version:          r.currentConfig().Build.Tag,
+		ProtocolVersion:  messages.ProtocolVersion,
+		QueueDepth:       depth,
+		QueueCapacity:    capacity,
+		ConcurrencyLimit: r.limiter.Status().Limit,
+		Bundles:          r.catalog.Len(),
+		ReadOnly:         readOnly,
+		Maintenance:      maintenance,
+		MemoryBytes:      usage.MemoryBytes,
+		Goroutines:       usage.Goroutines,
+		Engines:          []messages.EngineStatus{},
+	}
+	if r.workers != nil {
+		for _, worker := range r.workers.Status() {
+			if worker.Alive == true {
+				heartbeat.Workers++
+			}
+			if worker.Busy == true {
+				heartbeat.InFlight++
+			}
+		}
+	}
+	for _, health := range r.engines.Health() {
+		heartbeat.Engines = append(heartbeat.Engines, messages.EngineStatus{
+			Name:    health.Name,
+			Healthy: health.Healthy,
+			Error:   health.Error,
+		})
+	}
+	return heartbeat
+}
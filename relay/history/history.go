@@ -0,0 +1,240 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Snapshot kinds
+const (
+	// AssignmentKind snapshots record the bundles Cog assigned to the Relay
+	AssignmentKind = "assignment"
+	// AnnouncementKind snapshots record the bundles the Relay announced as ready
+	AnnouncementKind = "announcement"
+)
+
+// BundleRef identifies a bundle version
+type BundleRef struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// Snapshot is a point-in-time record of a bundle set and how it
+// differs from the previous snapshot of the same kind
+type Snapshot struct {
+	Timestamp time.Time   `json:"timestamp"`
+	Kind      string      `json:"kind"`
+	Reason    string      `json:"reason"`
+	Bundles   []BundleRef `json:"bundles"`
+	Added     []BundleRef `json:"added,omitempty"`
+	Removed   []BundleRef `json:"removed,omitempty"`
+	Updated   []BundleRef `json:"updated,omitempty"`
+}
+
+// Mentions returns true if the snapshot's bundle set or changes
+// include the named bundle
+func (s *Snapshot) Mentions(name string) bool {
+	for _, refs := range [][]BundleRef{s.Bundles, s.Added, s.Removed, s.Updated} {
+		for _, ref := range refs {
+			if ref.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Query filters snapshots returned by History.Query. Zero values
+// match everything.
+type Query struct {
+	Kind   string
+	Bundle string
+	Since  time.Time
+}
+
+// History is a bounded log of bundle set snapshots. When path is set
+// snapshots are persisted so they survive Relay restarts.
+type History struct {
+	lock      sync.Mutex
+	path      string
+	max       int
+	snapshots []*Snapshot
+}
+
+// Open loads the history stored at path. An empty path keeps
+// history in memory only.
+func Open(path string, max int) (*History, error) {
+	h := &History{
+		path: path,
+		max:  max,
+	}
+	if path == "" {
+		return h, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return h, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var snapshot Snapshot
+		if err := json.Unmarshal(scanner.Bytes(), &snapshot); err != nil {
+			log.Warnf("Skipped corrupt bundle history entry in %s: %s.", path, err)
+			continue
+		}
+		h.snapshots = append(h.snapshots, &snapshot)
+	}
+	h.trim()
+	return h, scanner.Err()
+}
+
+// Record stores a snapshot of bundles if it differs from the previous
+// snapshot of the same kind. Returns the recorded snapshot or nil if
+// nothing changed.
+func (h *History) Record(kind string, reason string, bundles []BundleRef) *Snapshot {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	sorted := make([]BundleRef, len(bundles))
+	copy(sorted, bundles)
+	sort.Sort(byName(sorted))
+	snapshot := &Snapshot{
+		Timestamp: time.Now().UTC(),
+		Kind:      kind,
+		Reason:    reason,
+		Bundles:   sorted,
+	}
+	previous := h.latest(kind)
+	if previous != nil {
+		snapshot.Added, snapshot.Removed, snapshot.Updated = diff(previous.Bundles, sorted)
+		if len(snapshot.Added) == 0 && len(snapshot.Removed) == 0 && len(snapshot.Updated) == 0 {
+			return nil
+		}
+	} else {
+		snapshot.Added = sorted
+	}
+	h.snapshots = append(h.snapshots, snapshot)
+	compact := h.trim()
+	if err := h.persist(snapshot, compact); err != nil {
+		log.Errorf("Failed to persist bundle history to %s: %s.", h.path, err)
+	}
+	return snapshot
+}
+
+// Query returns matching snapshots, oldest first
+func (h *History) Query(query Query) []Snapshot {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	retval := []Snapshot{}
+	for _, snapshot := range h.snapshots {
+		if query.Kind != "" && snapshot.Kind != query.Kind {
+			continue
+		}
+		if snapshot.Timestamp.Before(query.Since) {
+			continue
+		}
+		if query.Bundle != "" && !snapshot.Mentions(query.Bundle) {
+			continue
+		}
+		retval = append(retval, *snapshot)
+	}
+	return retval
+}
+
+func (h *History) latest(kind string) *Snapshot {
+	for i := len(h.snapshots) - 1; i >= 0; i-- {
+		if h.snapshots[i].Kind == kind {
+			return h.snapshots[i]
+		}
+	}
+	return nil
+}
+
+// trim drops the oldest snapshots beyond the size limit. Returns
+// true if any were dropped.
+func (h *History) trim() bool {
+	if h.max <= 0 || len(h.snapshots) <= h.max {
+		return false
+	}
+	h.snapshots = h.snapshots[len(h.snapshots)-h.max:]
+	return true
+}
+
+// persist appends snapshot to the history file, or rewrites the
+// whole file after older entries have been trimmed
+func (h *History) persist(snapshot *Snapshot, rewrite bool) error {
+	if h.path == "" {
+		return nil
+	}
+	if rewrite {
+		data := []byte{}
+		for _, s := range h.snapshots {
+			line, err := json.Marshal(s)
+			if err != nil {
+				return err
+			}
+			data = append(append(data, line...), '\n')
+		}
+		tmp := fmt.Sprintf("%s.tmp", h.path)
+		if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+			return err
+		}
+		return os.Rename(tmp, h.path)
+	}
+	line, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(h.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+func diff(before, after []BundleRef) ([]BundleRef, []BundleRef, []BundleRef) {
+	var added, removed, updated []BundleRef
+	previous := make(map[string]string)
+	for _, ref := range before {
+		previous[ref.Name] = ref.Version
+	}
+	current := make(map[string]bool)
+	for _, ref := range after {
+		current[ref.Name] = true
+		version, found := previous[ref.Name]
+		if found == false {
+			added = append(added, ref)
+		} else if version != ref.Version {
+			updated = append(updated, ref)
+		}
+	}
+	for _, ref := range before {
+		if current[ref.Name] == false {
+			removed = append(removed, ref)
+		}
+	}
+	return added, removed, updated
+}
+
+type byName []BundleRef
+
+func (b byName) Len() int           { return len(b) }
+func (b byName) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byName) Less(i, j int) bool { return b[i].Name < b[j].Name }
@@ -0,0 +1,62 @@
+package history
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestRecordDiffs(t *testing.T) {
+	h, _ := Open("", 0)
+	h.Record(AssignmentKind, "catalog update", []BundleRef{{"ping", "1.0.0"}, {"echo", "1.0.0"}})
+	if s := h.Record(AssignmentKind, "catalog update", []BundleRef{{"echo", "1.0.0"}, {"ping", "1.0.0"}}); s != nil {
+		t.Errorf("Expected unchanged bundle set to be skipped: %+v", s)
+	}
+	s := h.Record(AssignmentKind, "catalog update", []BundleRef{{"ping", "1.1.0"}, {"date", "0.1.0"}})
+	if s == nil {
+		t.Fatal("Expected changed bundle set to be recorded")
+	}
+	if len(s.Added) != 1 || s.Added[0].Name != "date" {
+		t.Errorf("Unexpected additions: %v", s.Added)
+	}
+	if len(s.Removed) != 1 || s.Removed[0].Name != "echo" {
+		t.Errorf("Unexpected removals: %v", s.Removed)
+	}
+	if len(s.Updated) != 1 || s.Updated[0].Version != "1.1.0" {
+		t.Errorf("Unexpected updates: %v", s.Updated)
+	}
+	if found := h.Query(Query{Bundle: "echo"}); len(found) != 2 {
+		t.Errorf("Expected 2 snapshots mentioning echo: %d", len(found))
+	}
+	if found := h.Query(Query{Kind: AnnouncementKind}); len(found) != 0 {
+		t.Errorf("Expected no announcement snapshots: %d", len(found))
+	}
+}
+
+func TestPersistedHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "relay_history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	historyPath := path.Join(dir, "bundle_history.jsonl")
+	h, err := Open(historyPath, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Record(AssignmentKind, "catalog update", []BundleRef{{"ping", "1.0.0"}})
+	h.Record(AssignmentKind, "catalog update", []BundleRef{{"ping", "1.1.0"}})
+	h.Record(AssignmentKind, "catalog update", []BundleRef{{"ping", "1.2.0"}})
+	reloaded, err := Open(historyPath, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshots := reloaded.Query(Query{})
+	if len(snapshots) != 2 {
+		t.Fatalf("Expected 2 snapshots after trimming: %d", len(snapshots))
+	}
+	if snapshots[1].Bundles[0].Version != "1.2.0" {
+		t.Errorf("Unexpected latest snapshot: %+v", snapshots[1])
+	}
+}
@@ -0,0 +1,118 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	hookStarted       = "started"
+	hookReady         = "ready"
+	hookBundleRefresh = "bundle_refresh"
+	hookDisconnected  = "disconnected"
+	hookShuttingDown  = "shutting_down"
+)
+
+// registerHookScripts hooks the commands configured under hooks into
+// Relay's lifecycle events
+func (r *cogRelay) registerHookScripts() {
+	if r.currentConfig().Hooks == nil || r.currentConfig().Hooks.Enabled() == false {
+		return
+	}
+	WithStateChangeHook(func(from RelayState, to RelayState) {
+		switch to {
+		case RelayReady:
+			go r.runHookScript(hookReady, map[string]string{"previous_state": from.String()})
+		case RelayDraining:
+			// Wait so the hook runs before Relay goes offline
+			r.runHookScript(hookShuttingDown, map[string]string{"previous_state": from.String()})
+		}
+	})(r)
+	WithBundleRefreshHook(func(bundles []string) {
+		go r.runHookScript(hookBundleRefresh, map[string]string{"bundles": strings.Join(bundles, ",")})
+	})(r)
+	WithDisconnectHook(func() {
+		go r.runHookScript(hookDisconnected, nil)
+	})(r)
+}
+
+// hookCommand returns the command configured for event
+func (r *cogRelay) hookCommand(event string) string {
+	switch event {
+	case hookStarted:
+		return r.currentConfig().Hooks.Started
+	case hookReady:
+		return r.currentConfig().Hooks.Ready
+	case hookBundleRefresh:
+		return r.currentConfig().Hooks.BundleRefresh
+	case hookDisconnected:
+		return r.currentConfig().Hooks.Disconnected
+	case hookShuttingDown:
+		return r.currentConfig().Hooks.ShuttingDown
+	}
+	return ""
+}
+
+// runHookScript runs the command configured for event, if any. Event
+// details are passed as $RELAY_HOOK_* environment variables and as a
+// JSON object on stdin. Failures are logged and otherwise ignored.
+func (r *cogRelay) runHookScript(event string, details map[string]string) {
+	if r.currentConfig().Hooks == nil {
+		return
+	}
+	script := r.hookCommand(event)
+	if script == "" {
+		return
+	}
+	data := map[string]string{
+		"relay_id": r.currentConfig().ID,
+		"event":    event,
+		"time":     time.Now().UTC().Format(time.RFC3339),
+	}
+	for key, value := range details {
+		data[key] = value
+	}
+	input, err := json.Marshal(data)
+	if err != nil {
+		log.Errorf("Failed to encode %s hook input: %s.", event, err)
+		return
+	}
+	command := exec.Command("/bin/sh", "-c", script)
+	command.Env = append(os.Environ(), fmt.Sprintf("RELAY_ID=%s", r.currentConfig().ID))
+	for key, value := range data {
+		if key == "relay_id" {
+			continue
+		}
+		command.Env = append(command.Env, fmt.Sprintf("RELAY_HOOK_%s=%s", strings.ToUpper(key), value))
+	}
+	command.Stdin = bytes.NewReader(input)
+	var output bytes.Buffer
+	command.Stdout = &output
+	command.Stderr = &output
+	if err := command.Start(); err != nil {
+		log.Errorf("Failed to run %s hook: %s.", event, err)
+		return
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- command.Wait()
+	}()
+	timeout := r.currentConfig().Hooks.TimeoutDuration()
+	select {
+	case err := <-done:
+		if err != nil {
+			log.Errorf("The %s hook failed: %s. Output: %q.", event, err, strings.TrimSpace(output.String()))
+			return
+		}
+		log.Debugf("Ran %s hook.", event)
+	case <-time.After(timeout):
+		command.Process.Kill()
+		log.Errorf("The %s hook timed out after %v.", event, timeout)
+	}
+}
@@ -0,0 +1,134 @@
+package relay
+
+import (
+	"sync"
+)
+
+// StateChangeHook is called when Relay moves from one lifecycle state
+// to another
+type StateChangeHook func(from RelayState, to RelayState)
+
+// BundleRefreshHook is called each time Relay loads its bundle
+// catalog from Cog with the names of the assigned bundles
+type BundleRefreshHook func(bundles []string)
+
+// ConnectionHook is called when Relay's connection to Cog is
+// established or lost
+type ConnectionHook func()
+
+// Option customizes a Relay built by NewRelay
+type Option func(*cogRelay)
+
+// WithStateChangeHook registers hook for lifecycle state changes
+func WithStateChangeHook(hook StateChangeHook) Option {
+	return func(r *cogRelay) {
+		r.hooks.lock.Lock()
+		defer r.hooks.lock.Unlock()
+		r.hooks.stateChange = append(r.hooks.stateChange, hook)
+	}
+}
+
+// WithBundleRefreshHook registers hook for bundle catalog refreshes
+func WithBundleRefreshHook(hook BundleRefreshHook) Option {
+	return func(r *cogRelay) {
+		r.hooks.lock.Lock()
+		defer r.hooks.lock.Unlock()
+		r.hooks.bundleRefresh = append(r.hooks.bundleRefresh, hook)
+	}
+}
+
+// WithConnectHook registers hook for connections and reconnections
+// to Cog
+func WithConnectHook(hook ConnectionHook) Option {
+	return func(r *cogRelay) {
+		r.hooks.lock.Lock()
+		defer r.hooks.lock.Unlock()
+		r.hooks.connect = append(r.hooks.connect, hook)
+	}
+}
+
+// WithDisconnectHook registers hook for lost connections to Cog.
+// Relay reconnects on its own afterwards.
+func WithDisconnectHook(hook ConnectionHook) Option {
+	return func(r *cogRelay) {
+		r.hooks.lock.Lock()
+		defer r.hooks.lock.Unlock()
+		r.hooks.disconnect = append(r.hooks.disconnect, hook)
+	}
+}
+
+// lifecycleHooks holds callbacks registered by programs embedding
+// Relay. Hooks run synchronously on Relay's own goroutines so they
+// must return quickly.
+type lifecycleHooks struct {
+	lock          sync.Mutex
+	stateChange   []StateChangeHook
+	bundleRefresh []BundleRefreshHook
+	connect       []ConnectionHook
+	disconnect    []ConnectionHook
+}
+
+func (lh *lifecycleHooks) stateChanged(from RelayState, to RelayState) {
+	lh.lock.Lock()
+	hooks := lh.stateChange
+	lh.lock.Unlock()
+	for _, hook := range hooks {
+		hook(from, to)
+	}
+}
+
+func (lh *lifecycleHooks) bundlesRefreshed(bundles []string) {
+	lh.lock.Lock()
+	hooks := lh.bundleRefresh
+	lh.lock.Unlock()
+	for _, hook := range hooks {
+		hook(bundles)
+	}
+}
+
+func (lh *lifecycleHooks) connected() {
+	lh.lock.Lock()
+	hooks := lh.connect
+	lh.lock.Unlock()
+	for _, hook := range hooks {
+		hook()
+	}
+}
+
+func (lh *lifecycleHooks) disconnected() {
+	lh.lock.Lock()
+	hooks := lh.disconnect
+	lh.lock.Unlock()
+	for _, hook := range hooks {
+		hook()
+	}
+}
+
+// OnStateChange registers hook for lifecycle state changes on a
+// running Relay
+func (r *cogRelay) OnStateChange(hook StateChangeHook) {
+	WithStateChangeHook(hook)(r)
+}
+
+// SubscribeState returns a channel receiving future lifecycle state
+// transitions. Transitions are dropped for subscribers which fall
+// behind.
+func (r *cogRelay) SubscribeState() (<-chan StateTransition, func()) {
+	return r.status.states.subscribe()
+}
+
+// StateHistory returns recent lifecycle state transitions
+func (r *cogRelay) StateHistory() []StateTransition {
+	return r.status.states.transitions()
+}
+
+// setState advances Relay to state and notifies hooks. Returns false
+// if Relay was already in state or can't move to it from its current
+// state.
+func (r *cogRelay) setState(state RelayState) bool {
+	previous, changed := r.status.advance(state)
+	if changed {
+		r.hooks.stateChanged(previous, state)
+	}
+	return changed
+}
@@ -0,0 +1,115 @@
+package journal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SocketPath is where systemd-journald accepts native protocol messages
+var SocketPath = "/run/systemd/journal/socket"
+
+// Identifier is sent as SYSLOG_IDENTIFIER with every entry
+var Identifier = "relay"
+
+var illegalFieldChars = regexp.MustCompile("[^A-Z0-9_]")
+
+// Available returns true when the journald socket exists
+func Available() bool {
+	_, err := os.Stat(SocketPath)
+	return err == nil
+}
+
+// Hook is a logrus hook which writes entries to journald. Entry fields
+// become journal fields so `journalctl PIPELINE_ID=...` can isolate a
+// single execution's logs.
+type Hook struct {
+	lock sync.Mutex
+	conn *net.UnixConn
+}
+
+// NewHook connects to journald
+func NewHook() (*Hook, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: SocketPath, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &Hook{
+		conn: conn,
+	}, nil
+}
+
+// Levels is required by the logrus.Hook interface
+func (h *Hook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire is required by the logrus.Hook interface
+func (h *Hook) Fire(entry *log.Entry) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	_, err := h.conn.Write(encodeEntry(entry))
+	return err
+}
+
+// encodeEntry formats entry using journald's native protocol
+func encodeEntry(entry *log.Entry) []byte {
+	var buf bytes.Buffer
+	writeField(&buf, "MESSAGE", entry.Message)
+	writeField(&buf, "PRIORITY", fmt.Sprintf("%d", priority(entry.Level)))
+	writeField(&buf, "SYSLOG_IDENTIFIER", Identifier)
+	names := []string{}
+	for name := range entry.Data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		writeField(&buf, FieldName(name), fmt.Sprintf("%v", entry.Data[name]))
+	}
+	return buf.Bytes()
+}
+
+// writeField appends a single field. Values containing newlines use
+// the length prefixed binary form.
+func writeField(buf *bytes.Buffer, name string, value string) {
+	if strings.Contains(value, "\n") == false {
+		fmt.Fprintf(buf, "%s=%s\n", name, value)
+		return
+	}
+	buf.WriteString(name)
+	buf.WriteByte('\n')
+	binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+// FieldName converts a logrus field name into a valid journal field
+// name. Journal fields are upper case and can't start with an
+// underscore, which is reserved for trusted fields.
+func FieldName(name string) string {
+	field := illegalFieldChars.ReplaceAllString(strings.ToUpper(name), "_")
+	return strings.TrimLeft(field, "_")
+}
+
+// priority maps logrus levels to syslog priorities
+func priority(level log.Level) int {
+	switch level {
+	case log.PanicLevel, log.FatalLevel:
+		return 2
+	case log.ErrorLevel:
+		return 3
+	case log.WarnLevel:
+		return 4
+	case log.InfoLevel:
+		return 6
+	default:
+		return 7
+	}
+}
@@ -0,0 +1,40 @@
+package journal
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"testing"
+)
+
+func TestEncodeEntry(t *testing.T) {
+	entry := &log.Entry{
+		Level:   log.WarnLevel,
+		Message: "Rejected ping:ping while in read-only mode.",
+		Data: log.Fields{
+			"pipeline_id": "abc123",
+			"bundle":      "ping",
+		},
+	}
+	expected := "MESSAGE=Rejected ping:ping while in read-only mode.\nPRIORITY=4\nSYSLOG_IDENTIFIER=relay\n" +
+		"BUNDLE=ping\nPIPELINE_ID=abc123\n"
+	if encoded := string(encodeEntry(entry)); encoded != expected {
+		t.Errorf("Unexpected journal entry: %q", encoded)
+	}
+}
+
+func TestEncodeMultilineField(t *testing.T) {
+	entry := &log.Entry{
+		Level:   log.InfoLevel,
+		Message: "one\ntwo",
+	}
+	encoded := encodeEntry(entry)
+	expected := "MESSAGE\n\x07\x00\x00\x00\x00\x00\x00\x00one\ntwo\n"
+	if string(encoded[:len(expected)]) != expected {
+		t.Errorf("Unexpected binary field encoding: %q", encoded)
+	}
+}
+
+func TestFieldName(t *testing.T) {
+	if name := FieldName("_pipeline-id"); name != "PIPELINE_ID" {
+		t.Errorf("Unexpected journal field name: %s", name)
+	}
+}
@@ -0,0 +1,44 @@
+package relay
+
+import (
+	"encoding/json"
+	log "github.com/Sirupsen/logrus"
+	"github.com/operable/go-relay/relay/admin"
+	"github.com/operable/go-relay/relay/messages"
+	"net/http"
+)
+
+// SetMaintenance enters or leaves maintenance mode. While in
+// maintenance mode Relay refuses new commands and announces no
+// bundles so Cog routes commands elsewhere. The bus connection is
+// kept so Relay can be told to resume.
+func (r *cogRelay) SetMaintenance(enabled bool, message string) {
+	r.maintenance.Set(enabled, message)
+	r.saveMaintenance()
+	if announcer := r.announcer; announcer != nil {
+		announcer.Withdraw(enabled)
+	}
+	if enabled {
+		log.Warn("Maintenance mode enabled. Bundles withdrawn and new commands will be rejected.")
+	} else {
+		log.Info("Maintenance mode disabled. Bundles announced again.")
+	}
+}
+
+func (r *cogRelay) adminMaintenance(w http.ResponseWriter, req *http.Request) {
+	if req.Method == "POST" {
+		var update messages.Maintenance
+		if err := json.NewDecoder(req.Body).Decode(&update); err != nil {
+			admin.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		r.SetMaintenance(update.Enabled, update.Message)
+	} else if !admin.RequireMethod(w, req, "GET") {
+		return
+	}
+	enabled, message := r.maintenance.Status()
+	admin.WriteJSON(w, http.StatusOK, messages.Maintenance{
+		Enabled: enabled,
+		Message: message,
+	})
+}
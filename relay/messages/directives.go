@@ -66,6 +66,67 @@ type DynamicConfig struct {
 	Config     interface{} `json:"config"`
 }
 
+// ReadOnlyEnvelope is a wrapper around a ReadOnly directive.
+type ReadOnlyEnvelope struct {
+	ReadOnly *ReadOnly `json:"read_only"`
+}
+
+// ReadOnly tells a Relay to enter or leave read-only mode.
+// Message, if set, replaces the message returned for rejected commands.
+type ReadOnly struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message,omitempty"`
+}
+
+// MaintenanceEnvelope is a wrapper around a Maintenance directive.
+type MaintenanceEnvelope struct {
+	Maintenance *Maintenance `json:"maintenance"`
+}
+
+// Maintenance tells a Relay to enter or leave maintenance mode. Message,
+// if set, is returned for commands rejected in maintenance mode.
+type Maintenance struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message,omitempty"`
+}
+
+// ConfigureEnvelope is a wrapper around a Configure directive.
+type ConfigureEnvelope struct {
+	Configure *Configure `json:"configure"`
+}
+
+// Configure changes a Relay's runtime settings. Unset fields are left
+// alone. Either every change is applied or none are.
+type Configure struct {
+	ID              string `json:"id"`
+	ReplyTo         string `json:"reply_to,omitempty"`
+	LogLevel        string `json:"log_level,omitempty"`
+	RefreshInterval string `json:"refresh_interval,omitempty"`
+	MaxConcurrent   int    `json:"max_concurrent,omitempty"`
+}
+
+// CancelEnvelope is a wrapper around a Cancel directive.
+type CancelEnvelope struct {
+	Cancel *Cancel `json:"cancel"`
+}
+
+// Cancel tells a Relay to stop the command with the given correlation
+// ID, or every command of the given pipeline. Reason, if set, is
+// included in the cancelled commands' responses.
+type Cancel struct {
+	CorrelationID string `json:"correlation_id,omitempty"`
+	PipelineID    string `json:"pipeline_id,omitempty"`
+	Reason        string `json:"reason,omitempty"`
+}
+
+// ConfigureAck tells Cog whether a Configure directive was applied
+type ConfigureAck struct {
+	ID      string `json:"id"`
+	RelayID string `json:"relay"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+}
+
 // AnnouncementEnvelope is a wrapper around an Announcement directive.
 type AnnouncementEnvelope struct {
 	Announcement *Announcement `json:"announce" valid:"required"`
@@ -78,8 +139,35 @@ type Announcement struct {
 	Online  bool        `json:"online" valid:"bool,required"`
 	Bundles []BundleRef `json:"bundles,omitempty"`
 	// Deprecated
-	Snapshot bool   `json:"snapshot" valid:"bool,required"`
-	ReplyTo  string `json:"reply_to,omitempty" valid:"-"`
+	Snapshot    bool         `json:"snapshot" valid:"bool,required"`
+	ReplyTo     string       `json:"reply_to,omitempty" valid:"-"`
+	Attestation *Attestation `json:"attestation,omitempty" valid:"-"`
+	// Payload encodings Cog may use when sending to this Relay
+	Encodings []string `json:"encodings,omitempty" valid:"-"`
+	// Message encodings Cog may use for execution requests
+	MessageEncodings []string `json:"message_encodings,omitempty" valid:"-"`
+	// Relay protocol version the announcement was written against
+	ProtocolVersion int `json:"protocol_version,omitempty" valid:"-"`
+	// Operator supplied key/value pairs and capability labels
+	// describing the Relay, e.g. its datacenter
+	Tags   map[string]string `json:"tags,omitempty" valid:"-"`
+	Labels []string          `json:"labels,omitempty" valid:"-"`
+}
+
+// Attestation carries the hash of an attested Relay's effective
+// config signed with the Relay's Cog token
+type Attestation struct {
+	ConfigHash string `json:"config_hash"`
+	Signature  string `json:"signature"`
+}
+
+// Presence tells Cog whether a Relay is connected. Relays with
+// cog/presence enabled register an offline Presence as their MQTT will
+// and publish an online Presence every time they connect.
+type Presence struct {
+	RelayID   string `json:"relay"`
+	Online    bool   `json:"online"`
+	Timestamp int64  `json:"timestamp,omitempty"`
 }
 
 // AnnouncementReceipt is sent by Cog to acknowledge a Relay's bundle announcement
@@ -103,8 +191,10 @@ func NewOfflineAnnouncement(relayID string, replyTo string) *AnnouncementEnvelop
 }
 
 // NewBundleAnnouncementExtended builds an Announcement directive describing
-// the list of bundles available on a Relay
-func NewBundleAnnouncementExtended(relayID string, bundles []config.Bundle, replyTo string, id string) *AnnouncementEnvelope {
+// the list of bundles available on a Relay along with the Relay's tags
+// and labels
+func NewBundleAnnouncementExtended(relayID string, bundles []config.Bundle, replyTo string, id string,
+	tags map[string]string, labels []string) *AnnouncementEnvelope {
 	refs := make([]BundleRef, len(bundles))
 	for i, v := range bundles {
 		refs[i].Name = v.Name
@@ -118,6 +208,36 @@ func NewBundleAnnouncementExtended(relayID string, bundles []config.Bundle, repl
 			Bundles:  refs,
 			Snapshot: true,
 			ReplyTo:  replyTo,
+			Tags:     tags,
+			Labels:   labels,
 		},
 	}
 }
+
+// ImagePullProgressEnvelope is a wrapper around an ImagePullProgress message.
+type ImagePullProgressEnvelope struct {
+	ImagePullProgress *ImagePullProgress `json:"image_pull_progress"`
+}
+
+// ImagePullProgress is periodically sent to Cog while a Relay is
+// pulling the Docker images it needs before announcing its bundles.
+type ImagePullProgress struct {
+	RelayID   string      `json:"relay_id"`
+	Finished  bool        `json:"finished"`
+	Total     int         `json:"total"`
+	Completed int         `json:"completed"`
+	Failed    int         `json:"failed"`
+	Elapsed   string      `json:"elapsed"`
+	Pulls     []ImagePull `json:"pulls"`
+}
+
+// ImagePull describes the state of a single bundle's image pull
+type ImagePull struct {
+	Bundle     string `json:"bundle"`
+	Version    string `json:"version"`
+	Image      string `json:"image"`
+	Status     string `json:"status"`
+	Downloaded int64  `json:"downloaded,omitempty"`
+	Size       int64  `json:"size,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
@@ -51,6 +51,7 @@ func (er *ExecutionRequest) compileEnvironment(command *config.BundleCommand, re
 	request.PutEnv("COG_SERVICE_TOKEN", er.ServiceToken)
 	request.PutEnv("COG_SERVICES_ROOT", er.ServicesRoot)
 	request.PutEnv("COG_INVOCATION_ID", er.InvocationID)
+	request.PutEnv("COG_CORRELATION_ID", er.CorrelationID)
 
 	if er.InvocationStep != "" {
 		request.PutEnv("COG_INVOCATION_STEP", er.InvocationStep)
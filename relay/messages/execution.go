@@ -1,11 +1,15 @@
 package messages
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/operable/circuit-driver/api"
 	"github.com/operable/go-relay/relay/config"
 	"strings"
+	"time"
 )
 
 // ExecutionRequest is a request to execute a command
@@ -23,11 +27,41 @@ type ExecutionRequest struct {
 	Room           ChatRoom               `json:"room"`
 	ServiceToken   string                 `json:"service_token"`
 	ServicesRoot   string                 `json:"services_root"`
+	Explain        bool                   `json:"explain"`
+	Deadline       int64                  `json:"deadline,omitempty"`
+	CorrelationID  string                 `json:"correlation_id,omitempty"`
+	Stages         []PipelineStage        `json:"stages,omitempty"`
 	bundleName     string
 	commandName    string
 	pipelineID     string
 }
 
+// PipelineStage is a later stage of a pipeline which Cog asks Relay
+// to run right after the request's own command, saving a round trip
+// through Cog per stage. Its input is the previous stage's output.
+// InvocationID, when set, is the stage's own invocation, claimed
+// separately by Relays coordinating execution.
+type PipelineStage struct {
+	Command      string                 `json:"command"`
+	Args         []interface{}          `json:"args"`
+	Options      map[string]interface{} `json:"options"`
+	InvocationID string                 `json:"invocation_id,omitempty"`
+}
+
+// BundleName returns the name of the bundle the stage's command is
+// part of
+func (ps PipelineStage) BundleName() string {
+	return strings.SplitN(ps.Command, ":", 2)[0]
+}
+
+// ExecutionClaim is published by Relays configured to coordinate
+// execution before running an invocation. Relays which see a competing
+// claim for the same invocation skip it.
+type ExecutionClaim struct {
+	InvocationID string `json:"invocation_id"`
+	RelayID      string `json:"relay_id"`
+}
+
 // ChatUser contains chat information about the submittor
 type ChatUser struct {
 	ID       interface{} `json:"id"` // Slack IDs are strings, HipChat are integers
@@ -57,14 +91,25 @@ type ChatRoom struct {
 
 // ExecutionResponse contains the results of executing a command
 type ExecutionResponse struct {
-	Room          string      `json:"room"`
-	Bundle        string      `json:"bundle"`
-	Status        string      `json:"status"`
-	StatusMessage string      `json:"status_message"`
-	Template      string      `json:"template,omitempty"`
-	Body          interface{} `json:"body"`
-	IsJSON        bool        `json:"omit"`
-	Aborted       bool        `json:"omit"`
+	Room          string            `json:"room"`
+	Bundle        string            `json:"bundle"`
+	Status        string            `json:"status"`
+	StatusMessage string            `json:"status_message"`
+	Template      string            `json:"template,omitempty"`
+	Body          interface{}       `json:"body"`
+	Metadata      *ResponseMetadata `json:"metadata,omitempty"`
+	SealedBody    *Sealed           `json:"sealed_body,omitempty"`
+	CorrelationID string            `json:"correlation_id,omitempty"`
+	IsJSON        bool              `json:"-"`
+	Aborted       bool              `json:"-"`
+	// Relay protocol version the response was written against
+	ProtocolVersion int `json:"protocol_version,omitempty"`
+}
+
+// ResponseMetadata describes how Relay processed a command's output
+type ResponseMetadata struct {
+	LogLinesSuppressed int `json:"log_lines_suppressed"`
+	LogLinesTruncated  int `json:"log_lines_truncated"`
 }
 
 var errorCommandNotFound = errors.New("Command not found")
@@ -103,12 +148,71 @@ func (er *ExecutionRequest) PipelineID() string {
 	return er.pipelineID
 }
 
+// DeadlineTime returns the time after which Cog no longer wants the
+// request executed. The zero time means the request has no deadline.
+func (er *ExecutionRequest) DeadlineTime() time.Time {
+	if er.Deadline == 0 {
+		return time.Time{}
+	}
+	return time.Unix(er.Deadline, 0)
+}
+
 // Parse extracts bundle name, command name, and
-// pipeline id
+// pipeline id, and assigns a correlation ID if Cog didn't send one
 func (er *ExecutionRequest) Parse() {
+	if er.CorrelationID == "" {
+		er.CorrelationID = newCorrelationID()
+	}
 	commandParts := strings.SplitN(er.Command, ":", 2)
 	pipelineParts := strings.SplitN(er.ReplyTo, "/", 5)
 	er.bundleName = commandParts[0]
 	er.commandName = commandParts[1]
 	er.pipelineID = pipelineParts[3]
 }
+
+// StageRequest returns a parsed request running the stage of Stages
+// at index with cogEnv as its input. Everything but the invocation is
+// inherited from the request.
+func (er *ExecutionRequest) StageRequest(index int, cogEnv interface{}) *ExecutionRequest {
+	stage := er.Stages[index]
+	request := *er
+	request.Command = stage.Command
+	request.Args = stage.Args
+	request.Options = stage.Options
+	request.CogEnv = cogEnv
+	request.InvocationID = stage.InvocationID
+	request.InvocationStep = ""
+	if index == len(er.Stages)-1 {
+		request.InvocationStep = "last"
+	}
+	request.Stages = nil
+	if request.Args == nil {
+		request.Args = []interface{}{}
+	}
+	if request.Options == nil {
+		request.Options = map[string]interface{}{}
+	}
+	request.Parse()
+	return &request
+}
+
+// newCorrelationID returns a random 128 bit ID formatted like a W3C
+// trace ID so it can be passed to tracing systems unchanged
+func newCorrelationID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Sprintf("%032x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(id)
+}
+
+// ScheduledResult carries the response of a scheduled command to Cog
+// for delivery to a chat room
+type ScheduledResult struct {
+	RelayID  string             `json:"relay_id"`
+	Schedule string             `json:"schedule"`
+	Command  string             `json:"command"`
+	Room     string             `json:"room"`
+	RanAt    int64              `json:"ran_at"`
+	Response *ExecutionResponse `json:"response"`
+}
@@ -0,0 +1,10 @@
+package messages
+
+// GroupMember is published by each member of a Relay group every
+// group/heartbeat_interval, and with Online false when it leaves
+type GroupMember struct {
+	RelayID   string `json:"relay"`
+	Group     string `json:"group"`
+	Online    bool   `json:"online"`
+	Timestamp int64  `json:"timestamp"`
+}
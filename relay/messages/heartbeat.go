@@ -0,0 +1,43 @@
+package messages
+
+// Heartbeat reports a Relay's live load and health. Relays publish one
+// every heartbeat_interval.
+type Heartbeat struct {
+	RelayID          string         `json:"relay"`
+	Timestamp        int64          `json:"timestamp"`
+	Version          string         `json:"version"`
+	ProtocolVersion  int            `json:"protocol_version"`
+	QueueDepth       int            `json:"queue_depth"`
+	QueueCapacity    int            `json:"queue_capacity"`
+	InFlight         int            `json:"in_flight"`
+	Workers          int            `json:"workers"`
+	ConcurrencyLimit int            `json:"concurrency_limit"`
+	Bundles          int            `json:"bundles"`
+	ReadOnly         bool           `json:"read_only"`
+	Maintenance      bool           `json:"maintenance"`
+	MemoryBytes      int64          `json:"memory_bytes"`
+	Goroutines       int            `json:"goroutines"`
+	Engines          []EngineStatus `json:"engines"`
+}
+
+// Backpressure tells Cog a Relay's work queue has filled past its
+// high water mark, when Saturated is true, or drained below its low
+// water mark. Cog should route new work to other Relays while a Relay
+// is saturated. WaitMillis is how long recent requests waited for a
+// worker, on average.
+type Backpressure struct {
+	RelayID       string `json:"relay"`
+	Timestamp     int64  `json:"timestamp"`
+	Saturated     bool   `json:"saturated"`
+	QueueDepth    int    `json:"queue_depth"`
+	QueueCapacity int    `json:"queue_capacity"`
+	WaitMillis    int64  `json:"wait_ms"`
+	Rejecting     bool   `json:"rejecting"`
+}
+
+// EngineStatus is the health of one execution engine
+type EngineStatus struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
@@ -23,5 +23,33 @@ func ParseUntypedDirective(payload []byte) (interface{}, error) {
 		return result, err
 	}
 
+	// ReadOnlyEnvelope
+	if _, ok := untypedPayload["read_only"]; ok {
+		result := &ReadOnlyEnvelope{}
+		err = json.Unmarshal(payload, result)
+		return result, err
+	}
+
+	// MaintenanceEnvelope
+	if _, ok := untypedPayload["maintenance"]; ok {
+		result := &MaintenanceEnvelope{}
+		err = json.Unmarshal(payload, result)
+		return result, err
+	}
+
+	// ConfigureEnvelope
+	if _, ok := untypedPayload["configure"]; ok {
+		result := &ConfigureEnvelope{}
+		err = json.Unmarshal(payload, result)
+		return result, err
+	}
+
+	// CancelEnvelope
+	if _, ok := untypedPayload["cancel"]; ok {
+		result := &CancelEnvelope{}
+		err = json.Unmarshal(payload, result)
+		return result, err
+	}
+
 	return nil, errorUnknownMessageType
 }
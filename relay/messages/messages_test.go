@@ -18,3 +18,68 @@ func TestEmptyTemplate(t *testing.T) {
 		t.Error("Empty template field included in marshaled output")
 	}
 }
+
+func TestCorrelationID(t *testing.T) {
+	request := ExecutionRequest{Command: "operable:echo", ReplyTo: "/bot/pipelines/abc/reply"}
+	request.Parse()
+	if len(request.CorrelationID) != 32 {
+		t.Errorf("Expected generated 32 character correlation ID: %s", request.CorrelationID)
+	}
+	request = ExecutionRequest{Command: "operable:echo", ReplyTo: "/bot/pipelines/abc/reply", CorrelationID: "from-cog"}
+	request.Parse()
+	if request.CorrelationID != "from-cog" {
+		t.Errorf("Expected Cog's correlation ID to be kept: %s", request.CorrelationID)
+	}
+}
+
+func TestStageRequest(t *testing.T) {
+	request := ExecutionRequest{
+		Command:        "operable:echo",
+		ReplyTo:        "/bot/pipelines/abc/reply",
+		CorrelationID:  "from-cog",
+		InvocationID:   "first-invocation",
+		InvocationStep: "first",
+		Room:           ChatRoom{Name: "ops"},
+		Stages:         []PipelineStage{{Command: "strings:upper", InvocationID: "second-invocation"}},
+	}
+	request.Parse()
+	stage := request.StageRequest(0, map[string]interface{}{"body": "hello"})
+	if stage.BundleName() != "strings" || stage.CommandName() != "upper" || stage.PipelineID() != "abc" {
+		t.Errorf("Unexpected stage command: %s %s %s", stage.BundleName(), stage.CommandName(), stage.PipelineID())
+	}
+	if stage.CorrelationID != "from-cog" || stage.Room.Name != "ops" || len(stage.Stages) != 0 {
+		t.Errorf("Expected stage to inherit the request: %+v", stage)
+	}
+	if stage.InvocationID != "second-invocation" || stage.InvocationStep != "last" {
+		t.Errorf("Expected stage to be its own invocation: %s %s", stage.InvocationID, stage.InvocationStep)
+	}
+	if stage.Args == nil || stage.Options == nil || stage.CogEnv == nil {
+		t.Errorf("Expected stage arguments, options and input: %+v", stage)
+	}
+	if request.Command != "operable:echo" || len(request.Stages) != 1 {
+		t.Errorf("Request changed by building a stage: %+v", request)
+	}
+}
+
+func TestParseConfigureDirective(t *testing.T) {
+	directive, err := ParseUntypedDirective([]byte(`{"configure":{"id":"42","log_level":"debug","max_concurrent":8}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope, ok := directive.(*ConfigureEnvelope)
+	if ok == false || envelope.Configure.ID != "42" || envelope.Configure.LogLevel != "debug" ||
+		envelope.Configure.MaxConcurrent != 8 {
+		t.Errorf("Unexpected configure directive: %+v", directive)
+	}
+}
+
+func TestParseCancelDirective(t *testing.T) {
+	directive, err := ParseUntypedDirective([]byte(`{"cancel":{"pipeline_id":"abc","reason":"Aborted by user"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope, ok := directive.(*CancelEnvelope)
+	if ok == false || envelope.Cancel.PipelineID != "abc" || envelope.Cancel.Reason != "Aborted by user" {
+		t.Errorf("Unexpected cancel directive: %+v", directive)
+	}
+}
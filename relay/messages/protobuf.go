@@ -0,0 +1,393 @@
+package messages
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/golang/protobuf/proto"
+	"github.com/operable/go-relay/relay/util"
+)
+
+// Message encodings Relay can exchange with Cog. JSON is always used
+// unless Cog sends a request encoded as protobuf or Relay is
+// configured to announce itself using protobuf.
+const (
+	EncodingJSON     = "json"
+	EncodingProtobuf = "protobuf"
+)
+
+// Version of protobuf messages produced by this Relay. Every message
+// starts with its version field, so protobuf payloads always begin
+// with the byte 0x08 and can't be mistaken for JSON.
+const protobufVersion = 1
+
+// SupportedMessageEncodings lists the message encodings Relay can
+// decode. It is included in bundle announcements so Cog knows which
+// encodings it may use for execution requests.
+var SupportedMessageEncodings = []string{EncodingJSON, EncodingProtobuf}
+
+var errorProtobufVersion = errors.New("Unsupported protobuf message version")
+
+// IsProtobuf returns true if payload is a protobuf encoded message
+func IsProtobuf(payload []byte) bool {
+	return len(payload) > 0 && payload[0] == 0x08
+}
+
+// DecodeExecutionRequest decodes a protobuf encoded ExecutionRequest
+func DecodeExecutionRequest(payload []byte) (*ExecutionRequest, error) {
+	pb := &pbExecutionRequest{}
+	if err := unmarshalProtobuf(payload, pb, &pb.Version); err != nil {
+		return nil, err
+	}
+	request := &ExecutionRequest{
+		InvocationID:   pb.InvocationID,
+		InvocationStep: pb.InvocationStep,
+		Command:        pb.Command,
+		ReplyTo:        pb.ReplyTo,
+		Room:           ChatRoom{Name: pb.Room},
+		ServiceToken:   pb.ServiceToken,
+		ServicesRoot:   pb.ServicesRoot,
+		Explain:        pb.Explain,
+		Deadline:       pb.Deadline,
+		CorrelationID:  pb.CorrelationID,
+	}
+	if pb.Requestor != nil {
+		request.Requestor = ChatUser{Handle: pb.Requestor.Handle, Provider: pb.Requestor.Provider}
+		if err := decodeEmbeddedJSON(pb.Requestor.IDJSON, &request.Requestor.ID); err != nil {
+			return nil, err
+		}
+	}
+	if pb.User != nil {
+		request.User = CogUser{
+			ID:        pb.User.ID,
+			Email:     pb.User.Email,
+			FirstName: pb.User.FirstName,
+			LastName:  pb.User.LastName,
+			Username:  pb.User.Username,
+		}
+	}
+	if err := decodeEmbeddedJSON(pb.OptionsJSON, &request.Options); err != nil {
+		return nil, err
+	}
+	if err := decodeEmbeddedJSON(pb.ArgsJSON, &request.Args); err != nil {
+		return nil, err
+	}
+	if err := decodeEmbeddedJSON(pb.CogEnvJSON, &request.CogEnv); err != nil {
+		return nil, err
+	}
+	if err := decodeEmbeddedJSON(pb.StagesJSON, &request.Stages); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// EncodeExecutionRequest encodes an ExecutionRequest as protobuf
+func EncodeExecutionRequest(request *ExecutionRequest) ([]byte, error) {
+	pb := &pbExecutionRequest{
+		Version:        protobufVersion,
+		InvocationID:   request.InvocationID,
+		InvocationStep: request.InvocationStep,
+		Command:        request.Command,
+		ReplyTo:        request.ReplyTo,
+		Room:           request.Room.Name,
+		ServiceToken:   request.ServiceToken,
+		ServicesRoot:   request.ServicesRoot,
+		Explain:        request.Explain,
+		Deadline:       request.Deadline,
+		CorrelationID:  request.CorrelationID,
+		Requestor: &pbChatUser{
+			IDJSON:   encodeEmbeddedJSON(request.Requestor.ID),
+			Handle:   request.Requestor.Handle,
+			Provider: request.Requestor.Provider,
+		},
+		User: &pbCogUser{
+			ID:        request.User.ID,
+			Email:     request.User.Email,
+			FirstName: request.User.FirstName,
+			LastName:  request.User.LastName,
+			Username:  request.User.Username,
+		},
+		OptionsJSON: encodeEmbeddedJSON(request.Options),
+		ArgsJSON:    encodeEmbeddedJSON(request.Args),
+		CogEnvJSON:  encodeEmbeddedJSON(request.CogEnv),
+	}
+	if len(request.Stages) > 0 {
+		pb.StagesJSON = encodeEmbeddedJSON(request.Stages)
+	}
+	return proto.Marshal(pb)
+}
+
+// EncodeExecutionResponse encodes an ExecutionResponse as protobuf
+func EncodeExecutionResponse(response *ExecutionResponse) ([]byte, error) {
+	pb := &pbExecutionResponse{
+		Version:       protobufVersion,
+		Room:          response.Room,
+		Bundle:        response.Bundle,
+		Status:        response.Status,
+		StatusMessage: response.StatusMessage,
+		Template:      response.Template,
+		BodyJSON:      encodeEmbeddedJSON(response.Body),
+		CorrelationID: response.CorrelationID,
+		Protocol:      int64(response.ProtocolVersion),
+	}
+	if response.Metadata != nil {
+		pb.Metadata = &pbResponseMetadata{
+			LogLinesSuppressed: int64(response.Metadata.LogLinesSuppressed),
+			LogLinesTruncated:  int64(response.Metadata.LogLinesTruncated),
+		}
+	}
+	if response.SealedBody != nil {
+		pb.SealedBody = &pbSealed{
+			Version:    int64(response.SealedBody.Version),
+			Algorithm:  response.SealedBody.Algorithm,
+			KeyID:      response.SealedBody.KeyID,
+			Nonce:      response.SealedBody.Nonce,
+			Ciphertext: response.SealedBody.Ciphertext,
+		}
+	}
+	return proto.Marshal(pb)
+}
+
+// DecodeExecutionResponse decodes a protobuf encoded ExecutionResponse
+func DecodeExecutionResponse(payload []byte) (*ExecutionResponse, error) {
+	pb := &pbExecutionResponse{}
+	if err := unmarshalProtobuf(payload, pb, &pb.Version); err != nil {
+		return nil, err
+	}
+	response := &ExecutionResponse{
+		Room:          pb.Room,
+		Bundle:        pb.Bundle,
+		Status:        pb.Status,
+		StatusMessage: pb.StatusMessage,
+		Template:      pb.Template,
+		CorrelationID: pb.CorrelationID,
+	}
+	response.ProtocolVersion = int(pb.Protocol)
+	if err := decodeEmbeddedJSON(pb.BodyJSON, &response.Body); err != nil {
+		return nil, err
+	}
+	if pb.Metadata != nil {
+		response.Metadata = &ResponseMetadata{
+			LogLinesSuppressed: int(pb.Metadata.LogLinesSuppressed),
+			LogLinesTruncated:  int(pb.Metadata.LogLinesTruncated),
+		}
+	}
+	if pb.SealedBody != nil {
+		response.SealedBody = &Sealed{
+			Version:    int(pb.SealedBody.Version),
+			Algorithm:  pb.SealedBody.Algorithm,
+			KeyID:      pb.SealedBody.KeyID,
+			Nonce:      pb.SealedBody.Nonce,
+			Ciphertext: pb.SealedBody.Ciphertext,
+		}
+	}
+	return response, nil
+}
+
+// EncodeAnnouncement encodes an Announcement as protobuf
+func EncodeAnnouncement(envelope *AnnouncementEnvelope) ([]byte, error) {
+	announcement := envelope.Announcement
+	pb := &pbAnnouncement{
+		Version:          protobufVersion,
+		ID:               announcement.ID,
+		RelayID:          announcement.RelayID,
+		Online:           announcement.Online,
+		Snapshot:         announcement.Snapshot,
+		ReplyTo:          announcement.ReplyTo,
+		Encodings:        announcement.Encodings,
+		MessageEncodings: announcement.MessageEncodings,
+		Protocol:         int64(announcement.ProtocolVersion),
+		Tags:             announcement.Tags,
+		Labels:           announcement.Labels,
+	}
+	for _, ref := range announcement.Bundles {
+		pb.Bundles = append(pb.Bundles, &pbBundleRef{Name: ref.Name, Version: ref.Version})
+	}
+	if announcement.Attestation != nil {
+		pb.Attestation = &pbAttestation{
+			ConfigHash: announcement.Attestation.ConfigHash,
+			Signature:  announcement.Attestation.Signature,
+		}
+	}
+	return proto.Marshal(pb)
+}
+
+// DecodeAnnouncement decodes a protobuf encoded Announcement
+func DecodeAnnouncement(payload []byte) (*AnnouncementEnvelope, error) {
+	pb := &pbAnnouncement{}
+	if err := unmarshalProtobuf(payload, pb, &pb.Version); err != nil {
+		return nil, err
+	}
+	announcement := &Announcement{
+		ID:               pb.ID,
+		RelayID:          pb.RelayID,
+		Online:           pb.Online,
+		Snapshot:         pb.Snapshot,
+		ReplyTo:          pb.ReplyTo,
+		Encodings:        pb.Encodings,
+		MessageEncodings: pb.MessageEncodings,
+		ProtocolVersion:  int(pb.Protocol),
+		Tags:             pb.Tags,
+		Labels:           pb.Labels,
+	}
+	for _, ref := range pb.Bundles {
+		announcement.Bundles = append(announcement.Bundles, BundleRef{Name: ref.Name, Version: ref.Version})
+	}
+	if pb.Attestation != nil {
+		announcement.Attestation = &Attestation{
+			ConfigHash: pb.Attestation.ConfigHash,
+			Signature:  pb.Attestation.Signature,
+		}
+	}
+	return &AnnouncementEnvelope{Announcement: announcement}, nil
+}
+
+func unmarshalProtobuf(payload []byte, pb proto.Message, version *int64) error {
+	if err := proto.Unmarshal(payload, pb); err != nil {
+		return err
+	}
+	if *version != protobufVersion {
+		return errorProtobufVersion
+	}
+	return nil
+}
+
+// Free-form values such as command options and bodies have no fixed
+// schema so they are carried as embedded JSON
+func encodeEmbeddedJSON(value interface{}) []byte {
+	if value == nil {
+		return nil
+	}
+	data, _ := json.Marshal(value)
+	return data
+}
+
+func decodeEmbeddedJSON(data []byte, value interface{}) error {
+	if len(data) == 0 {
+		return nil
+	}
+	return util.NewJSONDecoder(bytes.NewReader(data)).Decode(value)
+}
+
+type pbExecutionRequest struct {
+	Version        int64       `protobuf:"varint,1,opt,name=version,proto3"`
+	OptionsJSON    []byte      `protobuf:"bytes,2,opt,name=options_json,proto3"`
+	ArgsJSON       []byte      `protobuf:"bytes,3,opt,name=args_json,proto3"`
+	CogEnvJSON     []byte      `protobuf:"bytes,4,opt,name=cog_env_json,proto3"`
+	InvocationID   string      `protobuf:"bytes,5,opt,name=invocation_id,proto3"`
+	InvocationStep string      `protobuf:"bytes,6,opt,name=invocation_step,proto3"`
+	Command        string      `protobuf:"bytes,7,opt,name=command,proto3"`
+	ReplyTo        string      `protobuf:"bytes,8,opt,name=reply_to,proto3"`
+	Requestor      *pbChatUser `protobuf:"bytes,9,opt,name=requestor"`
+	User           *pbCogUser  `protobuf:"bytes,10,opt,name=user"`
+	Room           string      `protobuf:"bytes,11,opt,name=room,proto3"`
+	ServiceToken   string      `protobuf:"bytes,12,opt,name=service_token,proto3"`
+	ServicesRoot   string      `protobuf:"bytes,13,opt,name=services_root,proto3"`
+	Explain        bool        `protobuf:"varint,14,opt,name=explain,proto3"`
+	Deadline       int64       `protobuf:"varint,15,opt,name=deadline,proto3"`
+	CorrelationID  string      `protobuf:"bytes,16,opt,name=correlation_id,proto3"`
+	StagesJSON     []byte      `protobuf:"bytes,17,opt,name=stages_json,proto3"`
+}
+
+func (m *pbExecutionRequest) Reset()         { *m = pbExecutionRequest{} }
+func (m *pbExecutionRequest) String() string { return proto.CompactTextString(m) }
+func (*pbExecutionRequest) ProtoMessage()    {}
+
+type pbChatUser struct {
+	IDJSON   []byte `protobuf:"bytes,1,opt,name=id_json,proto3"`
+	Handle   string `protobuf:"bytes,2,opt,name=handle,proto3"`
+	Provider string `protobuf:"bytes,3,opt,name=provider,proto3"`
+}
+
+func (m *pbChatUser) Reset()         { *m = pbChatUser{} }
+func (m *pbChatUser) String() string { return proto.CompactTextString(m) }
+func (*pbChatUser) ProtoMessage()    {}
+
+type pbCogUser struct {
+	ID        string `protobuf:"bytes,1,opt,name=id,proto3"`
+	Email     string `protobuf:"bytes,2,opt,name=email_address,proto3"`
+	FirstName string `protobuf:"bytes,3,opt,name=first_name,proto3"`
+	LastName  string `protobuf:"bytes,4,opt,name=last_name,proto3"`
+	Username  string `protobuf:"bytes,5,opt,name=username,proto3"`
+}
+
+func (m *pbCogUser) Reset()         { *m = pbCogUser{} }
+func (m *pbCogUser) String() string { return proto.CompactTextString(m) }
+func (*pbCogUser) ProtoMessage()    {}
+
+type pbExecutionResponse struct {
+	Version       int64               `protobuf:"varint,1,opt,name=version,proto3"`
+	Room          string              `protobuf:"bytes,2,opt,name=room,proto3"`
+	Bundle        string              `protobuf:"bytes,3,opt,name=bundle,proto3"`
+	Status        string              `protobuf:"bytes,4,opt,name=status,proto3"`
+	StatusMessage string              `protobuf:"bytes,5,opt,name=status_message,proto3"`
+	Template      string              `protobuf:"bytes,6,opt,name=template,proto3"`
+	BodyJSON      []byte              `protobuf:"bytes,7,opt,name=body_json,proto3"`
+	Metadata      *pbResponseMetadata `protobuf:"bytes,8,opt,name=metadata"`
+	SealedBody    *pbSealed           `protobuf:"bytes,9,opt,name=sealed_body"`
+	CorrelationID string              `protobuf:"bytes,10,opt,name=correlation_id,proto3"`
+	Protocol      int64               `protobuf:"varint,11,opt,name=protocol_version,proto3"`
+}
+
+func (m *pbExecutionResponse) Reset()         { *m = pbExecutionResponse{} }
+func (m *pbExecutionResponse) String() string { return proto.CompactTextString(m) }
+func (*pbExecutionResponse) ProtoMessage()    {}
+
+type pbResponseMetadata struct {
+	LogLinesSuppressed int64 `protobuf:"varint,1,opt,name=log_lines_suppressed,proto3"`
+	LogLinesTruncated  int64 `protobuf:"varint,2,opt,name=log_lines_truncated,proto3"`
+}
+
+func (m *pbResponseMetadata) Reset()         { *m = pbResponseMetadata{} }
+func (m *pbResponseMetadata) String() string { return proto.CompactTextString(m) }
+func (*pbResponseMetadata) ProtoMessage()    {}
+
+type pbSealed struct {
+	Version    int64  `protobuf:"varint,1,opt,name=version,proto3"`
+	Algorithm  string `protobuf:"bytes,2,opt,name=alg,proto3"`
+	KeyID      string `protobuf:"bytes,3,opt,name=kid,proto3"`
+	Nonce      []byte `protobuf:"bytes,4,opt,name=nonce,proto3"`
+	Ciphertext []byte `protobuf:"bytes,5,opt,name=ciphertext,proto3"`
+}
+
+func (m *pbSealed) Reset()         { *m = pbSealed{} }
+func (m *pbSealed) String() string { return proto.CompactTextString(m) }
+func (*pbSealed) ProtoMessage()    {}
+
+type pbAnnouncement struct {
+	Version          int64             `protobuf:"varint,1,opt,name=version,proto3"`
+	ID               string            `protobuf:"bytes,2,opt,name=announcement_id,proto3"`
+	RelayID          string            `protobuf:"bytes,3,opt,name=relay,proto3"`
+	Online           bool              `protobuf:"varint,4,opt,name=online,proto3"`
+	Bundles          []*pbBundleRef    `protobuf:"bytes,5,rep,name=bundles"`
+	Snapshot         bool              `protobuf:"varint,6,opt,name=snapshot,proto3"`
+	ReplyTo          string            `protobuf:"bytes,7,opt,name=reply_to,proto3"`
+	Attestation      *pbAttestation    `protobuf:"bytes,8,opt,name=attestation"`
+	Encodings        []string          `protobuf:"bytes,9,rep,name=encodings"`
+	MessageEncodings []string          `protobuf:"bytes,10,rep,name=message_encodings"`
+	Protocol         int64             `protobuf:"varint,11,opt,name=protocol_version,proto3"`
+	Tags             map[string]string `protobuf:"bytes,12,rep,name=tags" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	Labels           []string          `protobuf:"bytes,13,rep,name=labels"`
+}
+
+func (m *pbAnnouncement) Reset()         { *m = pbAnnouncement{} }
+func (m *pbAnnouncement) String() string { return proto.CompactTextString(m) }
+func (*pbAnnouncement) ProtoMessage()    {}
+
+type pbBundleRef struct {
+	Name    string `protobuf:"bytes,1,opt,name=name,proto3"`
+	Version string `protobuf:"bytes,2,opt,name=version,proto3"`
+}
+
+func (m *pbBundleRef) Reset()         { *m = pbBundleRef{} }
+func (m *pbBundleRef) String() string { return proto.CompactTextString(m) }
+func (*pbBundleRef) ProtoMessage()    {}
+
+type pbAttestation struct {
+	ConfigHash string `protobuf:"bytes,1,opt,name=config_hash,proto3"`
+	Signature  string `protobuf:"bytes,2,opt,name=signature,proto3"`
+}
+
+func (m *pbAttestation) Reset()         { *m = pbAttestation{} }
+func (m *pbAttestation) String() string { return proto.CompactTextString(m) }
+func (*pbAttestation) ProtoMessage()    {}
@@ -0,0 +1,90 @@
+package messages
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestProtobufExecutionRequest(t *testing.T) {
+	request := &ExecutionRequest{
+		Options:        map[string]interface{}{"verbose": true},
+		Args:           []interface{}{"hello", json.Number("12345678901234567890")},
+		InvocationID:   "inv-1",
+		InvocationStep: "last",
+		Command:        "operable:echo",
+		ReplyTo:        "/bot/pipelines/abc/reply",
+		Requestor:      ChatUser{ID: "U123", Handle: "vanstee", Provider: "slack"},
+		User:           CogUser{ID: "1", Username: "vanstee"},
+		Room:           ChatRoom{Name: "ops"},
+		Deadline:       1500000000,
+		CorrelationID:  "from-cog",
+		Stages: []PipelineStage{
+			{Command: "operable:upper", Args: []interface{}{"loud"}, Options: map[string]interface{}{}},
+		},
+	}
+	data, err := EncodeExecutionRequest(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if IsProtobuf(data) == false || IsProtobuf([]byte(`{"command":"operable:echo"}`)) == true {
+		t.Error("Expected protobuf payloads to be distinguishable from JSON")
+	}
+	decoded, err := DecodeExecutionRequest(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(request, decoded) == false {
+		t.Errorf("Request changed by round trip: %+v", decoded)
+	}
+	asJSON, _ := json.Marshal(request)
+	if len(data) >= len(asJSON) {
+		t.Errorf("Expected protobuf request to be smaller than JSON: %d >= %d", len(data), len(asJSON))
+	}
+}
+
+func TestProtobufExecutionResponse(t *testing.T) {
+	response := &ExecutionResponse{
+		Bundle:        "operable",
+		Status:        "ok",
+		Template:      "raw",
+		Body:          []interface{}{map[string]interface{}{"body": []interface{}{"hello"}}},
+		Metadata:      &ResponseMetadata{LogLinesTruncated: 3},
+		SealedBody:    &Sealed{Version: SealedVersion, Algorithm: SealedAlgorithm, Nonce: []byte{1}, Ciphertext: []byte{2}},
+		CorrelationID: "from-cog",
+	}
+	data, err := EncodeExecutionResponse(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeExecutionResponse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(response, decoded) == false {
+		t.Errorf("Response changed by round trip: %+v", decoded)
+	}
+}
+
+func TestProtobufAnnouncement(t *testing.T) {
+	announcement := NewOfflineAnnouncement("relay-1", "bot/relays/relay-1/announcer")
+	announcement.Announcement.Bundles = []BundleRef{{Name: "operable", Version: "1.0.0"}}
+	announcement.Announcement.Attestation = &Attestation{ConfigHash: "abc", Signature: "def"}
+	announcement.Announcement.MessageEncodings = SupportedMessageEncodings
+	announcement.Announcement.Tags = map[string]string{"datacenter": "ams1", "environment": "prod"}
+	announcement.Announcement.Labels = []string{"gpu"}
+	data, err := EncodeAnnouncement(announcement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeAnnouncement(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(announcement, decoded) == false {
+		t.Errorf("Announcement changed by round trip: %+v", decoded.Announcement)
+	}
+	if _, err := DecodeAnnouncement([]byte{0x08, 0x02}); err != errorProtobufVersion {
+		t.Errorf("Expected errorProtobufVersion: %v", err)
+	}
+}
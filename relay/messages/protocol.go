@@ -0,0 +1,53 @@
+package messages
+
+import (
+	"sort"
+)
+
+// ProtocolVersion is the version of the Relay protocol this Relay
+// speaks. Version 1 is the protocol spoken before Relay and Cog
+// negotiated versions and is assumed for Cogs which don't answer the
+// handshake.
+const ProtocolVersion = 2
+
+// Optional protocol features Relay and Cog may negotiate
+const (
+	FeatureGzip     = "gzip"
+	FeatureProtobuf = "protobuf"
+	FeatureSealed   = "sealed"
+	FeaturePresence = "presence"
+	FeatureStages   = "stages"
+)
+
+// Handshake is sent by Relay every time it connects to Cog's message
+// bus, before announcing its bundles
+type Handshake struct {
+	RelayID         string   `json:"relay"`
+	ProtocolVersion int      `json:"protocol_version"`
+	Features        []string `json:"features"`
+	ReplyTo         string   `json:"reply_to"`
+}
+
+// HandshakeReply is Cog's answer to a Handshake. MinProtocolVersion is
+// the oldest Relay protocol version Cog still accepts.
+type HandshakeReply struct {
+	ProtocolVersion    int      `json:"protocol_version"`
+	MinProtocolVersion int      `json:"min_protocol_version,omitempty"`
+	Features           []string `json:"features"`
+}
+
+// Negotiate returns the features both Relay and Cog support
+func Negotiate(relayFeatures []string, cogFeatures []string) []string {
+	supported := make(map[string]bool, len(cogFeatures))
+	for _, feature := range cogFeatures {
+		supported[feature] = true
+	}
+	negotiated := []string{}
+	for _, feature := range relayFeatures {
+		if supported[feature] == true {
+			negotiated = append(negotiated, feature)
+		}
+	}
+	sort.Strings(negotiated)
+	return negotiated
+}
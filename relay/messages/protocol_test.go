@@ -0,0 +1,16 @@
+package messages
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	negotiated := Negotiate([]string{FeatureSealed, FeatureGzip, FeaturePresence}, []string{"gzip", "streaming", "sealed"})
+	if reflect.DeepEqual(negotiated, []string{FeatureGzip, FeatureSealed}) == false {
+		t.Errorf("Unexpected negotiated features: %v", negotiated)
+	}
+	if negotiated := Negotiate([]string{FeatureGzip}, nil); len(negotiated) != 0 {
+		t.Errorf("Expected no features negotiated with a legacy Cog: %v", negotiated)
+	}
+}
@@ -0,0 +1,141 @@
+package messages
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Version and algorithm of sealed payloads produced by this Relay.
+// Receivers must reject versions they don't understand.
+const (
+	SealedVersion   = 1
+	SealedAlgorithm = "A256GCM"
+)
+
+var errorBadPayloadKey = errors.New("Payload encryption keys must be 32 bytes")
+var errorWrongPayloadKey = errors.New("Sealed payload was encrypted with a different key")
+
+// Sealed payloads are wrapped in an envelope whose only key is
+// "sealed" so they can be recognized without decoding every message
+var sealedPrefix = []byte(`{"sealed":`)
+
+// Sealed is an AES-256-GCM encrypted payload. The ID of the Relay it
+// was sealed for or by is authenticated as additional data, so a
+// payload sealed with a key shared between Relays can't be replayed
+// to a different Relay.
+type Sealed struct {
+	Version    int    `json:"version"`
+	Algorithm  string `json:"alg"`
+	KeyID      string `json:"kid,omitempty"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// SealedEnvelope wraps a sealed execution request. ReplyTo and
+// CorrelationID may be left readable so Relay can answer requests it
+// can't decrypt.
+type SealedEnvelope struct {
+	Sealed        *Sealed `json:"sealed"`
+	ReplyTo       string  `json:"reply_to,omitempty"`
+	CorrelationID string  `json:"correlation_id,omitempty"`
+}
+
+// Sealer encrypts response bodies and decrypts requests exchanged
+// with Cog using a key shared by Cog and one or more Relays
+type Sealer struct {
+	relayID string
+	keyID   string
+	aead    cipher.AEAD
+}
+
+// NewSealer creates a Sealer for relayID. keyID is included in sealed
+// payloads so Cog can pick the right key when keys are rotated or
+// issued per Relay.
+func NewSealer(relayID string, key []byte, keyID string) (*Sealer, error) {
+	if len(key) != 32 {
+		return nil, errorBadPayloadKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Sealer{
+		relayID: relayID,
+		keyID:   keyID,
+		aead:    aead,
+	}, nil
+}
+
+// Seal encrypts plaintext
+func (s *Sealer) Seal(plaintext []byte) (*Sealed, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return &Sealed{
+		Version:    SealedVersion,
+		Algorithm:  SealedAlgorithm,
+		KeyID:      s.keyID,
+		Nonce:      nonce,
+		Ciphertext: s.aead.Seal(nil, nonce, plaintext, []byte(s.relayID)),
+	}, nil
+}
+
+// Open decrypts a sealed payload
+func (s *Sealer) Open(sealed *Sealed) ([]byte, error) {
+	if sealed.Version != SealedVersion || sealed.Algorithm != SealedAlgorithm {
+		return nil, fmt.Errorf("Unsupported sealed payload version %d (%s)", sealed.Version, sealed.Algorithm)
+	}
+	if sealed.KeyID != s.keyID {
+		return nil, errorWrongPayloadKey
+	}
+	if len(sealed.Nonce) != s.aead.NonceSize() {
+		return nil, fmt.Errorf("Sealed payload nonce must be %d bytes", s.aead.NonceSize())
+	}
+	return s.aead.Open(nil, sealed.Nonce, sealed.Ciphertext, []byte(s.relayID))
+}
+
+// OpenRequest decrypts an execution request if it is sealed. Plain
+// requests are returned unchanged.
+func (s *Sealer) OpenRequest(payload []byte) ([]byte, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(payload), sealedPrefix) == false {
+		return payload, nil
+	}
+	envelope := SealedEnvelope{}
+	if err := json.Unmarshal(payload, &envelope); err != nil {
+		return nil, err
+	}
+	if envelope.Sealed == nil {
+		return nil, errors.New("Sealed request is empty")
+	}
+	return s.Open(envelope.Sealed)
+}
+
+// SealResponse replaces a response's body with its sealed JSON
+// encoding. Status and template stay readable so Cog can route the
+// response before decrypting it.
+func (s *Sealer) SealResponse(response *ExecutionResponse) error {
+	if response.Body == nil {
+		return nil
+	}
+	plaintext, err := json.Marshal(response.Body)
+	if err != nil {
+		return err
+	}
+	sealed, err := s.Seal(plaintext)
+	if err != nil {
+		return err
+	}
+	response.Body = nil
+	response.SealedBody = sealed
+	return nil
+}
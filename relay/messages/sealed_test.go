@@ -0,0 +1,61 @@
+package messages
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+var testPayloadKey = bytes.Repeat([]byte{7}, 32)
+
+func TestSealResponse(t *testing.T) {
+	sealer, err := NewSealer("relay-1", testPayloadKey, "2024-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := &ExecutionResponse{Status: "ok", Body: []string{"s3kr1t"}}
+	if err := sealer.SealResponse(response); err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := json.Marshal(response)
+	if bytes.Contains(raw, []byte("s3kr1t")) {
+		t.Errorf("Sealed response exposes its body: %s", raw)
+	}
+	plaintext, err := sealer.Open(response.SealedBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != `["s3kr1t"]` {
+		t.Errorf("Unexpected body: %s", plaintext)
+	}
+	other, _ := NewSealer("relay-2", testPayloadKey, "2024-01")
+	if _, err := other.Open(response.SealedBody); err == nil {
+		t.Error("Expected payload sealed for another Relay to be rejected")
+	}
+}
+
+func TestOpenRequest(t *testing.T) {
+	sealer, _ := NewSealer("relay-1", testPayloadKey, "")
+	plain := []byte(`{"command":"operable:echo"}`)
+	opened, err := sealer.OpenRequest(plain)
+	if err != nil || bytes.Equal(opened, plain) == false {
+		t.Errorf("Expected plain request to pass through: %s %v", opened, err)
+	}
+	sealed, _ := sealer.Seal(plain)
+	envelope, _ := json.Marshal(SealedEnvelope{Sealed: sealed})
+	opened, err = sealer.OpenRequest(envelope)
+	if err != nil || bytes.Equal(opened, plain) == false {
+		t.Errorf("Expected sealed request to open: %s %v", opened, err)
+	}
+	sealed.Version = 2
+	envelope, _ = json.Marshal(SealedEnvelope{Sealed: sealed})
+	if _, err := sealer.OpenRequest(envelope); err == nil {
+		t.Error("Expected unknown envelope version to be rejected")
+	}
+}
+
+func TestNewSealerKeyLength(t *testing.T) {
+	if _, err := NewSealer("relay-1", []byte("short"), ""); err != errorBadPayloadKey {
+		t.Errorf("Expected errorBadPayloadKey: %v", err)
+	}
+}
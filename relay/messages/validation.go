@@ -0,0 +1,152 @@
+package messages
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ExecutionRequestSchemaVersion is the newest execution request schema
+// Relay understands. Requests may name the version they were written
+// against in schema_version. Requests without one are treated as
+// version 1.
+const ExecutionRequestSchemaVersion = 1
+
+// ValidationError describes an incoming message which doesn't match
+// its schema. It is sent back to Cog as the body of an error response
+// whenever the message says where to reply.
+type ValidationError struct {
+	Field         string `json:"field,omitempty"`
+	Reason        string `json:"reason"`
+	SchemaVersion int    `json:"schema_version"`
+	ReplyTo       string `json:"-"`
+}
+
+func (ve *ValidationError) Error() string {
+	if ve.Field == "" {
+		return fmt.Sprintf("Invalid execution request: %s", ve.Reason)
+	}
+	return fmt.Sprintf("Invalid execution request: %s %s", ve.Field, ve.Reason)
+}
+
+// Field names and types of version 1 of the execution request schema
+var executionRequestFields = schemaFields(reflect.TypeOf(ExecutionRequest{}))
+
+// ValidateExecutionRequest checks a JSON encoded execution request
+// against its schema. Lenient validation ignores fields the schema
+// doesn't define; strict validation rejects them.
+func ValidateExecutionRequest(payload []byte, strict bool) *ValidationError {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return NewValidationError("", "is not a JSON object", "")
+	}
+	replyTo := ""
+	if raw, ok := fields["reply_to"]; ok {
+		json.Unmarshal(raw, &replyTo)
+		if validReplyTo(replyTo) == false {
+			replyTo = ""
+		}
+	}
+	if raw, ok := fields["schema_version"]; ok {
+		version := 0
+		if err := json.Unmarshal(raw, &version); err != nil || version < 1 {
+			return NewValidationError("schema_version", "must be a positive integer", replyTo)
+		}
+		if version > ExecutionRequestSchemaVersion {
+			return NewValidationError("schema_version",
+				fmt.Sprintf("%d is newer than supported version %d", version, ExecutionRequestSchemaVersion), replyTo)
+		}
+		delete(fields, "schema_version")
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fieldType, known := executionRequestFields[name]
+		if known == false {
+			if strict == true {
+				return NewValidationError(name, "is not part of the schema", replyTo)
+			}
+			continue
+		}
+		if err := json.Unmarshal(fields[name], reflect.New(fieldType).Interface()); err != nil {
+			return NewValidationError(name, "must be "+describeType(fieldType), replyTo)
+		}
+	}
+	request := ExecutionRequest{}
+	json.Unmarshal(payload, &request)
+	return request.Validate()
+}
+
+// Validate checks the fields Relay needs to run a request and route
+// its response
+func (er *ExecutionRequest) Validate() *ValidationError {
+	replyTo := er.ReplyTo
+	if validReplyTo(replyTo) == false {
+		return NewValidationError("reply_to", "must be a pipeline reply topic", "")
+	}
+	parts := strings.SplitN(er.Command, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return NewValidationError("command", "must be a bundle qualified command name", replyTo)
+	}
+	for _, stage := range er.Stages {
+		parts := strings.SplitN(stage.Command, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return NewValidationError("stages", "must have bundle qualified command names", replyTo)
+		}
+	}
+	if er.Deadline < 0 {
+		return NewValidationError("deadline", "can't be negative", replyTo)
+	}
+	return nil
+}
+
+// NewValidationError creates a ValidationError for the current schema
+// version. replyTo may be empty if the message didn't say where to reply.
+func NewValidationError(field string, reason string, replyTo string) *ValidationError {
+	return &ValidationError{
+		Field:         field,
+		Reason:        reason,
+		SchemaVersion: ExecutionRequestSchemaVersion,
+		ReplyTo:       replyTo,
+	}
+}
+
+// validReplyTo returns true for topics shaped like
+// /bot/pipelines/<id>/reply, whose fourth segment is the pipeline ID
+func validReplyTo(topic string) bool {
+	parts := strings.SplitN(topic, "/", 5)
+	return len(parts) >= 4 && parts[3] != ""
+}
+
+func schemaFields(structType reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if field.PkgPath != "" || name == "" || name == "-" {
+			continue
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+func describeType(fieldType reflect.Type) string {
+	switch fieldType.Kind() {
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	case reflect.Slice:
+		return "an array"
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	default:
+		return "a number"
+	}
+}
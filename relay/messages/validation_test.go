@@ -0,0 +1,46 @@
+package messages
+
+import (
+	"testing"
+)
+
+func TestValidateExecutionRequest(t *testing.T) {
+	valid := `{"command":"operable:echo","reply_to":"/bot/pipelines/abc/reply","args":["hello"],"deadline":0}`
+	if verr := ValidateExecutionRequest([]byte(valid), true); verr != nil {
+		t.Errorf("Expected valid request: %s", verr)
+	}
+	extra := `{"command":"operable:echo","reply_to":"/bot/pipelines/abc/reply","colour":"blue"}`
+	if verr := ValidateExecutionRequest([]byte(extra), false); verr != nil {
+		t.Errorf("Expected lenient validation to ignore unknown fields: %s", verr)
+	}
+	if verr := ValidateExecutionRequest([]byte(extra), true); verr == nil || verr.Field != "colour" {
+		t.Errorf("Expected strict validation to reject unknown fields: %v", verr)
+	}
+	cases := map[string]string{
+		`{"command":"operable:echo","reply_to":"/bot/pipelines/abc/reply","args":"hello"}`:         "args",
+		`{"command":"echo","reply_to":"/bot/pipelines/abc/reply"}`:                                 "command",
+		`{"command":"operable:echo","reply_to":"/bot/pipelines/abc/reply","schema_version":2}`:     "schema_version",
+		`{"command":"operable:echo","reply_to":"/bot/pipelines/abc/reply","explain":"yes please"}`: "explain",
+	}
+	for payload, field := range cases {
+		verr := ValidateExecutionRequest([]byte(payload), false)
+		if verr == nil || verr.Field != field {
+			t.Errorf("Expected %s to be rejected: %v", field, verr)
+			continue
+		}
+		if verr.ReplyTo != "/bot/pipelines/abc/reply" {
+			t.Errorf("Expected reply_to to be kept for error response: %s", verr.ReplyTo)
+		}
+	}
+	verr := ValidateExecutionRequest([]byte(`{"command":"operable:echo","reply_to":"nowhere"}`), false)
+	if verr == nil || verr.Field != "reply_to" || verr.ReplyTo != "" {
+		t.Errorf("Expected unusable reply_to to be rejected: %v", verr)
+	}
+	stages := `{"command":"operable:echo","reply_to":"/bot/pipelines/abc/reply","stages":[{"command":"upper"}]}`
+	if verr := ValidateExecutionRequest([]byte(stages), false); verr == nil || verr.Field != "stages" {
+		t.Errorf("Expected stage without a bundle to be rejected: %v", verr)
+	}
+	if verr := ValidateExecutionRequest([]byte(`[1, 2]`), false); verr == nil || verr.Field != "" {
+		t.Errorf("Expected non-object payload to be rejected: %v", verr)
+	}
+}
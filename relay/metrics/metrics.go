@@ -0,0 +1,202 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBuckets are histogram bucket upper bounds, in seconds, suited
+// to network latencies
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Counter is a monotonically increasing count of events
+type Counter struct {
+	name  string
+	help  string
+	value int64
+}
+
+// Inc adds one to the counter
+func (c *Counter) Inc() {
+	atomic.AddInt64(&c.value, 1)
+}
+
+// Add adds n to the counter
+func (c *Counter) Add(n int64) {
+	atomic.AddInt64(&c.value, n)
+}
+
+// Value returns the counter's current value
+func (c *Counter) Value() int64 {
+	return atomic.LoadInt64(&c.value)
+}
+
+func (c *Counter) writeText(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n",
+		c.name, c.help, c.name, c.name, c.Value())
+	return err
+}
+
+// Histogram counts observations in buckets, such as request
+// latencies
+type Histogram struct {
+	name    string
+	help    string
+	lock    sync.Mutex
+	buckets []float64
+	counts  []int64
+	count   int64
+	sum     float64
+}
+
+// Observe records a single observation
+func (h *Histogram) Observe(value float64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for i, bound := range h.buckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// Count returns the number of observations
+func (h *Histogram) Count() int64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.count
+}
+
+func (h *Histogram) writeText(w io.Writer) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name); err != nil {
+		return err
+	}
+	for i, bound := range h.buckets {
+		if _, err := fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.name,
+			strconv.FormatFloat(bound, 'g', -1, 64), h.counts[i]); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n%s_sum %s\n%s_count %d\n",
+		h.name, h.count, h.name, strconv.FormatFloat(h.sum, 'g', -1, 64), h.name, h.count)
+	return err
+}
+
+type metric interface {
+	writeText(w io.Writer) error
+}
+
+var registry = struct {
+	lock       sync.Mutex
+	counters   map[string]*Counter
+	histograms map[string]*Histogram
+}{
+	counters:   make(map[string]*Counter),
+	histograms: make(map[string]*Histogram),
+}
+
+// NewCounter registers a counter. Registering the same name twice
+// returns the existing counter.
+func NewCounter(name, help string) *Counter {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	if existing := registry.counters[name]; existing != nil {
+		return existing
+	}
+	counter := &Counter{
+		name: name,
+		help: help,
+	}
+	registry.counters[name] = counter
+	return counter
+}
+
+// NewHistogram registers a histogram with the given bucket upper
+// bounds, which must be sorted. Registering the same name twice
+// returns the existing histogram.
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	if existing := registry.histograms[name]; existing != nil {
+		return existing
+	}
+	histogram := &Histogram{
+		name:    name,
+		help:    help,
+		buckets: buckets,
+		counts:  make([]int64, len(buckets)),
+	}
+	registry.histograms[name] = histogram
+	return histogram
+}
+
+// WriteText writes every registered metric to w using the Prometheus
+// text exposition format
+func WriteText(w io.Writer) error {
+	registry.lock.Lock()
+	names := []string{}
+	metrics := make(map[string]metric)
+	for name, counter := range registry.counters {
+		names = append(names, name)
+		metrics[name] = counter
+	}
+	for name, histogram := range registry.histograms {
+		names = append(names, name)
+		metrics[name] = histogram
+	}
+	registry.lock.Unlock()
+	sort.Strings(names)
+	for _, name := range names {
+		if err := metrics[name].writeText(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sink receives readings of every registered metric. Programs
+// embedding Relay implement it to forward metrics to their own
+// monitoring system.
+type Sink interface {
+	Report(samples []Sample)
+}
+
+// Sample is a reading of a single metric. Histograms are reported as
+// their _count and _sum.
+type Sample struct {
+	Name  string
+	Value float64
+}
+
+// Gather returns a reading of every registered metric, sorted by name
+func Gather() []Sample {
+	registry.lock.Lock()
+	samples := []Sample{}
+	for name, counter := range registry.counters {
+		samples = append(samples, Sample{Name: name, Value: float64(counter.Value())})
+	}
+	histograms := []*Histogram{}
+	for _, histogram := range registry.histograms {
+		histograms = append(histograms, histogram)
+	}
+	registry.lock.Unlock()
+	for _, histogram := range histograms {
+		histogram.lock.Lock()
+		samples = append(samples,
+			Sample{Name: histogram.name + "_count", Value: float64(histogram.count)},
+			Sample{Name: histogram.name + "_sum", Value: histogram.sum})
+		histogram.lock.Unlock()
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].Name < samples[j].Name
+	})
+	return samples
+}
@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCounter(t *testing.T) {
+	counter := NewCounter("relay_test_events_total", "Events seen by the test.")
+	counter.Inc()
+	counter.Inc()
+	if same := NewCounter("relay_test_events_total", "Ignored."); same != counter {
+		t.Error("Expected registering an existing name to return the same counter")
+	}
+	var out bytes.Buffer
+	if err := WriteText(&out); err != nil {
+		t.Fatal(err)
+	}
+	expected := "# HELP relay_test_events_total Events seen by the test.\n# TYPE relay_test_events_total counter\nrelay_test_events_total 2\n"
+	if !strings.Contains(out.String(), expected) {
+		t.Errorf("Unexpected metrics output: %s", out.String())
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	histogram := NewHistogram("relay_test_latency_seconds", "Latency seen by the test.", []float64{0.1, 1})
+	histogram.Observe(0.05)
+	histogram.Observe(0.5)
+	histogram.Observe(3)
+	if histogram.Count() != 3 {
+		t.Errorf("Expected 3 observations: %d", histogram.Count())
+	}
+	var out bytes.Buffer
+	if err := WriteText(&out); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# TYPE relay_test_latency_seconds histogram
+relay_test_latency_seconds_bucket{le="0.1"} 1
+relay_test_latency_seconds_bucket{le="1"} 2
+relay_test_latency_seconds_bucket{le="+Inf"} 3
+relay_test_latency_seconds_sum 3.55
+relay_test_latency_seconds_count 3
+`
+	if !strings.Contains(out.String(), expected) {
+		t.Errorf("Unexpected metrics output: %s", out.String())
+	}
+}
+
+func TestGather(t *testing.T) {
+	NewCounter("relay_test_gathered_total", "Gathered by the test.").Add(3)
+	NewHistogram("relay_test_gathered_seconds", "Gathered by the test.", []float64{1}).Observe(0.5)
+	found := map[string]float64{}
+	for _, sample := range Gather() {
+		found[sample.Name] = sample.Value
+	}
+	if found["relay_test_gathered_total"] != 3 {
+		t.Errorf("Unexpected counter sample: %v", found)
+	}
+	if found["relay_test_gathered_seconds_count"] != 1 || found["relay_test_gathered_seconds_sum"] != 0.5 {
+		t.Errorf("Unexpected histogram samples: %v", found)
+	}
+}
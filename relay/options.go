@@ -0,0 +1,99 @@
+package relay
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/operable/go-relay/relay/bus"
+	"github.com/operable/go-relay/relay/engines"
+	"github.com/operable/go-relay/relay/metrics"
+	"github.com/operable/go-relay/relay/worker"
+	"time"
+)
+
+// defaultMetricsInterval is how often metrics are reported to a sink
+// registered without an interval
+const defaultMetricsInterval = time.Minute
+
+// WithBus makes Relay connect to Cog, and to the bridged broker, using
+// connections created by factory instead of the backend named by
+// bus/type
+func WithBus(factory bus.Factory) Option {
+	return func(r *cogRelay) {
+		r.busFactory = factory
+	}
+}
+
+// WithEngines replaces the execution engines Relay builds from its
+// configuration
+func WithEngines(e *engines.Engines) Option {
+	return func(r *cogRelay) {
+		r.engines = e
+	}
+}
+
+// WithSharedEngines builds Relay's execution engines over shared so
+// several Relays running in one process, each with its own ID and
+// configuration, pull and track Docker images together. Metrics are
+// process wide and aggregate across every Relay in the process.
+func WithSharedEngines(shared *engines.SharedResources) Option {
+	return func(r *cogRelay) {
+		r.engines = engines.NewSharedEngines(r.currentConfig(), shared)
+	}
+}
+
+// WithLogger sends Relay's logs to logger's output, formatted and
+// filtered the same way. Relay logs through the standard logrus
+// logger so this affects the whole program.
+func WithLogger(logger *log.Logger) Option {
+	return func(r *cogRelay) {
+		std := log.StandardLogger()
+		std.Out = logger.Out
+		std.Formatter = logger.Formatter
+		std.Hooks = logger.Hooks
+		std.Level = logger.Level
+	}
+}
+
+// WithMetricsSink reports Relay's metrics to sink every interval, in
+// addition to serving them from the admin API. Zero reports once a
+// minute.
+func WithMetricsSink(sink metrics.Sink, interval time.Duration) Option {
+	return func(r *cogRelay) {
+		if interval <= 0 {
+			interval = defaultMetricsInterval
+		}
+		r.metricsSink = sink
+		r.metricsInterval = interval
+	}
+}
+
+// WithWorkerFunc makes request workers pass commands to handler
+// instead of executing them with Relay's engines
+func WithWorkerFunc(handler worker.Handler) Option {
+	return func(r *cogRelay) {
+		r.workerFunc = handler
+	}
+}
+
+// newConnection creates an unconnected message bus connection
+func (r *cogRelay) newConnection() (bus.Connection, error) {
+	if r.busFactory != nil {
+		return r.busFactory(), nil
+	}
+	return bus.NewConnection(r.currentConfig().Bus.Type)
+}
+
+// reportMetrics reports metrics to the registered sink until Relay
+// shuts down, with a final report on the way out
+func (r *cogRelay) reportMetrics() {
+	ticker := time.NewTicker(r.metricsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.ctx.Done():
+			r.metricsSink.Report(metrics.Gather())
+			return
+		case <-ticker.C:
+			r.metricsSink.Report(metrics.Gather())
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package relay
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/operable/go-relay/relay/bus"
+	"github.com/operable/go-relay/relay/config"
+	"github.com/operable/go-relay/relay/engines"
+	"github.com/operable/go-relay/relay/messages"
+	"sync"
+	"time"
+)
+
+// Image pull states reported to Cog
+const (
+	pullWaiting   = "waiting"
+	pullPulling   = "pulling"
+	pullAvailable = "available"
+	pullFailed    = "failed"
+)
+
+// pullReporter periodically publishes the progress of a batch of
+// image pulls on the Relay info topic so operators can see why a
+// Relay hasn't announced its bundles yet
+type pullReporter struct {
+	relayID   string
+	publisher bus.MessagePublisher
+	engines   *engines.Engines
+	started   time.Time
+	lock      sync.Mutex
+	pulls     map[string]*messages.ImagePull
+	order     []string
+	control   chan struct{}
+	done      chan struct{}
+}
+
+func newPullReporter(relayID string, publisher bus.MessagePublisher, engines *engines.Engines,
+	bundles []*config.Bundle) *pullReporter {
+	pr := &pullReporter{
+		relayID:   relayID,
+		publisher: publisher,
+		engines:   engines,
+		started:   time.Now(),
+		pulls:     make(map[string]*messages.ImagePull),
+		control:   make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	for _, bundle := range bundles {
+		pr.pulls[bundle.Name] = &messages.ImagePull{
+			Bundle:  bundle.Name,
+			Version: bundle.Version,
+			Image:   fmt.Sprintf("%s:%s", bundle.Docker.Image, bundle.Docker.Tag),
+			Status:  pullWaiting,
+		}
+		pr.order = append(pr.order, bundle.Name)
+	}
+	return pr
+}
+
+// run publishes progress every interval until finish is called
+func (pr *pullReporter) run(interval time.Duration) {
+	defer close(pr.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			pr.publish(false)
+		case <-pr.control:
+			return
+		}
+	}
+}
+
+// finish stops periodic reports and publishes the final status
+func (pr *pullReporter) finish() {
+	close(pr.control)
+	<-pr.done
+	pr.publish(true)
+}
+
+func (pr *pullReporter) setStatus(bundle *config.Bundle, status string, err error) {
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+	pull := pr.pulls[bundle.Name]
+	if pull == nil {
+		return
+	}
+	pull.Status = status
+	if err != nil {
+		pull.Error = fmt.Sprintf("%s", err)
+	}
+}
+
+func (pr *pullReporter) progress(finished bool) *messages.ImagePullProgress {
+	active := pr.engines.ActivePulls()
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+	progress := &messages.ImagePullProgress{
+		RelayID:  pr.relayID,
+		Finished: finished,
+		Total:    len(pr.order),
+		Elapsed:  time.Now().Sub(pr.started).String(),
+		Pulls:    []messages.ImagePull{},
+	}
+	for _, name := range pr.order {
+		pull := *pr.pulls[name]
+		switch pull.Status {
+		case pullAvailable:
+			progress.Completed++
+		case pullFailed:
+			progress.Failed++
+		case pullPulling:
+			if status, ok := active[pull.Image]; ok {
+				pull.Downloaded = status.Downloaded
+				pull.Size = status.Size
+			}
+		}
+		progress.Pulls = append(progress.Pulls, pull)
+	}
+	return progress
+}
+
+func (pr *pullReporter) publish(finished bool) {
+	progress := pr.progress(finished)
+	log.Infof("Image prefetch progress: %d of %d complete, %d failed.", progress.Completed, progress.Total, progress.Failed)
+	if pr.publisher == nil {
+		return
+	}
+	raw, _ := json.Marshal(&messages.ImagePullProgressEnvelope{
+		ImagePullProgress: progress,
+	})
+	if err := pr.publisher.Publish(infoTopic, raw); err != nil {
+		log.Errorf("Failed to publish image pull progress: %s.", err)
+	}
+}
@@ -0,0 +1,95 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var azureTokenURL = "http://169.254.169.254/metadata/identity/oauth2/token?api-version=2018-02-01&resource=https%3A%2F%2Fmanagement.azure.com%2F"
+
+// acrUsername is the fixed user name ACR expects with refresh tokens
+const acrUsername = "00000000-0000-0000-0000-000000000000"
+
+// acrProvider exchanges the Azure VM's managed identity token for an
+// ACR refresh token
+type acrProvider struct {
+	client   *http.Client
+	registry string
+}
+
+func newACRProvider(client *http.Client, registryHost string) *acrProvider {
+	return &acrProvider{
+		client:   client,
+		registry: registryHost,
+	}
+}
+
+type azureToken struct {
+	AccessToken string `json:"access_token"`
+	ExpiresOn   string `json:"expires_on"`
+}
+
+type acrExchange struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (ap *acrProvider) Fetch() (Credentials, error) {
+	req, _ := http.NewRequest("GET", azureTokenURL, nil)
+	req.Header.Set("Metadata", "true")
+	resp, err := ap.client.Do(req)
+	if err != nil {
+		return Credentials{}, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp, "Azure managed identity token request"); err != nil {
+		return Credentials{}, err
+	}
+	var token azureToken
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return Credentials{}, err
+	}
+	refreshToken, err := ap.exchange(token.AccessToken)
+	if err != nil {
+		return Credentials{}, err
+	}
+	// The refresh token lives at least as long as the identity token
+	// it was exchanged for
+	expiresOn, err := strconv.ParseInt(token.ExpiresOn, 10, 64)
+	if err != nil {
+		return Credentials{}, err
+	}
+	return Credentials{
+		Username:  acrUsername,
+		Password:  refreshToken,
+		ExpiresAt: time.Unix(expiresOn, 0),
+	}, nil
+}
+
+func (ap *acrProvider) exchange(accessToken string) (string, error) {
+	form := url.Values{
+		"grant_type":   {"access_token"},
+		"service":      {ap.registry},
+		"access_token": {accessToken},
+	}
+	req, _ := http.NewRequest("POST", fmt.Sprintf("https://%s/oauth2/exchange", ap.registry),
+		strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := ap.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp, "ACR token exchange"); err != nil {
+		return "", err
+	}
+	var exchanged acrExchange
+	if err := json.NewDecoder(resp.Body).Decode(&exchanged); err != nil {
+		return "", err
+	}
+	return exchanged.RefreshToken, nil
+}
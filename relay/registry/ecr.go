@@ -0,0 +1,155 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var ec2MetadataURL = "http://169.254.169.254/latest"
+
+var ecrHostPattern = regexp.MustCompile(`^\d+\.dkr\.ecr(-fips)?\.([a-z0-9-]+)\.amazonaws\.com`)
+
+var errorNoInstanceRole = errors.New("EC2 instance has no IAM role")
+var errorNoECRToken = errors.New("ECR returned no authorization data")
+
+// ecrProvider uses the EC2 instance role to call ECR's
+// GetAuthorizationToken
+type ecrProvider struct {
+	client *http.Client
+	region string
+}
+
+func newECRProvider(client *http.Client, registryHost string) *ecrProvider {
+	return &ecrProvider{
+		client: client,
+		region: ecrRegion(registryHost),
+	}
+}
+
+// ecrRegion extracts the region from a registry host such as
+// 123456789012.dkr.ecr.eu-west-1.amazonaws.com
+func ecrRegion(registryHost string) string {
+	matches := ecrHostPattern.FindStringSubmatch(registryHost)
+	if matches == nil {
+		return ""
+	}
+	return matches[2]
+}
+
+func (ep *ecrProvider) Fetch() (Credentials, error) {
+	token, err := ep.metadataToken()
+	if err != nil {
+		return Credentials{}, err
+	}
+	region := ep.region
+	if region == "" {
+		if region, err = ep.metadata(token, "/meta-data/placement/region"); err != nil {
+			return Credentials{}, err
+		}
+	}
+	roles, err := ep.metadata(token, "/meta-data/iam/security-credentials/")
+	if err != nil {
+		return Credentials{}, err
+	}
+	role := strings.TrimSpace(strings.Split(roles, "\n")[0])
+	if role == "" {
+		return Credentials{}, errorNoInstanceRole
+	}
+	rawCreds, err := ep.metadata(token, "/meta-data/iam/security-credentials/"+role)
+	if err != nil {
+		return Credentials{}, err
+	}
+	var creds awsCredentials
+	if err := json.Unmarshal([]byte(rawCreds), &creds); err != nil {
+		return Credentials{}, err
+	}
+	return ep.authorizationToken(creds, region)
+}
+
+func (ep *ecrProvider) authorizationToken(creds awsCredentials, region string) (Credentials, error) {
+	body := []byte("{}")
+	req, _ := http.NewRequest("POST", fmt.Sprintf("https://api.ecr.%s.amazonaws.com/", region), bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "AmazonEC2ContainerRegistry_V20150921.GetAuthorizationToken")
+	signV4(req, body, creds, region, "ecr", time.Now())
+	resp, err := ep.client.Do(req)
+	if err != nil {
+		return Credentials{}, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp, "ECR GetAuthorizationToken"); err != nil {
+		return Credentials{}, err
+	}
+	return parseECRToken(resp.Body)
+}
+
+type ecrAuthorization struct {
+	AuthorizationData []struct {
+		AuthorizationToken string  `json:"authorizationToken"`
+		ExpiresAt          float64 `json:"expiresAt"`
+	} `json:"authorizationData"`
+}
+
+// parseECRToken decodes a GetAuthorizationToken response. Tokens are
+// the base64 encoding of "AWS:<password>".
+func parseECRToken(body io.Reader) (Credentials, error) {
+	var auth ecrAuthorization
+	if err := json.NewDecoder(body).Decode(&auth); err != nil {
+		return Credentials{}, err
+	}
+	if len(auth.AuthorizationData) == 0 {
+		return Credentials{}, errorNoECRToken
+	}
+	data := auth.AuthorizationData[0]
+	decoded, err := base64.StdEncoding.DecodeString(data.AuthorizationToken)
+	if err != nil {
+		return Credentials{}, err
+	}
+	parts := strings.SplitN(string(decoded), ":", 2)
+	if len(parts) != 2 {
+		return Credentials{}, errorNoECRToken
+	}
+	return Credentials{
+		Username:  parts[0],
+		Password:  parts[1],
+		ExpiresAt: time.Unix(int64(data.ExpiresAt), 0),
+	}, nil
+}
+
+// metadataToken obtains an IMDSv2 session token
+func (ep *ecrProvider) metadataToken() (string, error) {
+	req, _ := http.NewRequest("PUT", ec2MetadataURL+"/api/token", nil)
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	return ep.fetch(req)
+}
+
+func (ep *ecrProvider) metadata(token string, path string) (string, error) {
+	req, _ := http.NewRequest("GET", ec2MetadataURL+path, nil)
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	return ep.fetch(req)
+}
+
+func (ep *ecrProvider) fetch(req *http.Request) (string, error) {
+	resp, err := ep.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp, "EC2 metadata request for "+req.URL.Path); err != nil {
+		return "", err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(body)), nil
+}
@@ -0,0 +1,48 @@
+package registry
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+var gceTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// gcrProvider uses the GCE instance's default service account to
+// authenticate to Container Registry and Artifact Registry
+type gcrProvider struct {
+	client *http.Client
+}
+
+func newGCRProvider(client *http.Client) *gcrProvider {
+	return &gcrProvider{
+		client: client,
+	}
+}
+
+type oauthToken struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+func (gp *gcrProvider) Fetch() (Credentials, error) {
+	req, _ := http.NewRequest("GET", gceTokenURL, nil)
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := gp.client.Do(req)
+	if err != nil {
+		return Credentials{}, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp, "GCE metadata token request"); err != nil {
+		return Credentials{}, err
+	}
+	var token oauthToken
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return Credentials{}, err
+	}
+	return Credentials{
+		Username:  "oauth2accesstoken",
+		Password:  token.AccessToken,
+		ExpiresAt: time.Now().Add(time.Duration(token.ExpiresIn) * time.Second),
+	}, nil
+}
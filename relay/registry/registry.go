@@ -0,0 +1,91 @@
+package registry
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/operable/go-relay/relay/config"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// refreshMargin is how long before expiry cached credentials are
+// replaced
+var refreshMargin = time.Duration(5) * time.Minute
+
+var metadataTimeout = time.Duration(5) * time.Second
+
+// Credentials are short-lived registry credentials obtained from a
+// cloud provider
+type Credentials struct {
+	Username  string
+	Password  string
+	ExpiresAt time.Time
+}
+
+// Provider exchanges the Relay host's cloud identity for registry
+// credentials
+type Provider interface {
+	Fetch() (Credentials, error)
+}
+
+// Helper caches the credentials issued by a Provider and fetches new
+// ones shortly before they expire
+type Helper struct {
+	lock     sync.Mutex
+	name     string
+	provider Provider
+	current  *Credentials
+}
+
+// NewHelper returns the credential helper configured by
+// docker/registry_credentials or nil if none is configured
+func NewHelper(dockerConfig *config.DockerInfo) *Helper {
+	client := &http.Client{
+		Timeout: metadataTimeout,
+	}
+	var provider Provider
+	switch dockerConfig.RegistryCredentials {
+	case config.ECRCredentials:
+		provider = newECRProvider(client, dockerConfig.RegistryHost)
+	case config.GCRCredentials:
+		provider = newGCRProvider(client)
+	case config.ACRCredentials:
+		provider = newACRProvider(client, dockerConfig.RegistryHost)
+	default:
+		return nil
+	}
+	return newHelper(dockerConfig.RegistryCredentials, provider)
+}
+
+func newHelper(name string, provider Provider) *Helper {
+	return &Helper{
+		name:     name,
+		provider: provider,
+	}
+}
+
+// Credentials returns cached credentials, fetching new ones if the
+// cached credentials are missing or about to expire
+func (h *Helper) Credentials() (Credentials, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.current != nil && time.Now().Add(refreshMargin).Before(h.current.ExpiresAt) {
+		return *h.current, nil
+	}
+	creds, err := h.provider.Fetch()
+	if err != nil {
+		log.Errorf("Failed to obtain %s registry credentials: %s.", h.name, err)
+		return Credentials{}, err
+	}
+	log.Infof("Obtained %s registry credentials valid until %s.", h.name, creds.ExpiresAt.Format(time.RFC3339))
+	h.current = &creds
+	return creds, nil
+}
+
+func checkStatus(resp *http.Response, source string) error {
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status %d", source, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type countingProvider struct {
+	fetches  int
+	lifetime time.Duration
+	err      error
+}
+
+func (cp *countingProvider) Fetch() (Credentials, error) {
+	cp.fetches++
+	return Credentials{
+		Username:  "user",
+		Password:  "token",
+		ExpiresAt: time.Now().Add(cp.lifetime),
+	}, cp.err
+}
+
+func TestHelperCachesCredentials(t *testing.T) {
+	provider := &countingProvider{lifetime: time.Hour}
+	helper := newHelper("test", provider)
+	helper.Credentials()
+	helper.Credentials()
+	if provider.fetches != 1 {
+		t.Errorf("Expected cached credentials to be reused: %d fetches", provider.fetches)
+	}
+}
+
+func TestHelperRefreshesBeforeExpiry(t *testing.T) {
+	provider := &countingProvider{lifetime: refreshMargin / 2}
+	helper := newHelper("test", provider)
+	helper.Credentials()
+	helper.Credentials()
+	if provider.fetches != 2 {
+		t.Errorf("Expected credentials about to expire to be refreshed: %d fetches", provider.fetches)
+	}
+	provider.err = errors.New("metadata unavailable")
+	if _, err := helper.Credentials(); err == nil {
+		t.Error("Expected fetch error to be returned")
+	}
+}
+
+func TestECRRegion(t *testing.T) {
+	if region := ecrRegion("123456789012.dkr.ecr.eu-west-1.amazonaws.com"); region != "eu-west-1" {
+		t.Errorf("Unexpected ECR region: %s", region)
+	}
+	if region := ecrRegion("index.docker.io"); region != "" {
+		t.Errorf("Expected no region for non-ECR registry: %s", region)
+	}
+}
+
+func TestParseECRToken(t *testing.T) {
+	body := `{"authorizationData":[{"authorizationToken":"QVdTOnNla3JpdA==","expiresAt":1.7e9,"proxyEndpoint":"https://123456789012.dkr.ecr.eu-west-1.amazonaws.com"}]}`
+	creds, err := parseECRToken(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.Username != "AWS" || creds.Password != "sekrit" || creds.ExpiresAt.Unix() != 1700000000 {
+		t.Errorf("Unexpected ECR credentials: %+v", creds)
+	}
+}
+
+func TestGCRProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		json.NewEncoder(w).Encode(oauthToken{AccessToken: "ya29.token", ExpiresIn: 3600})
+	}))
+	defer server.Close()
+	gceTokenURL = server.URL
+	creds, err := newGCRProvider(server.Client()).Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.Username != "oauth2accesstoken" || creds.Password != "ya29.token" {
+		t.Errorf("Unexpected GCR credentials: %+v", creds)
+	}
+	if time.Until(creds.ExpiresAt) < 59*time.Minute {
+		t.Errorf("Unexpected GCR credential expiry: %v", creds.ExpiresAt)
+	}
+}
@@ -0,0 +1,42 @@
+package registry
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Example request from the AWS Signature Version 4 documentation
+func TestSignV4(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	creds := awsCredentials{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+	now, _ := time.Parse("20060102T150405Z", "20150830T123600Z")
+	signV4(req, nil, creds, "us-east-1", "iam", now)
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, " +
+		"SignedHeaders=content-type;host;x-amz-date, " +
+		"Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7"
+	if auth := req.Header.Get("Authorization"); auth != expected {
+		t.Errorf("Unexpected Authorization header: %s", auth)
+	}
+}
+
+func TestSignV4SessionToken(t *testing.T) {
+	req, _ := http.NewRequest("POST", "https://api.ecr.us-east-1.amazonaws.com/", nil)
+	creds := awsCredentials{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		Token:           "session",
+	}
+	signV4(req, []byte("{}"), creds, "us-east-1", "ecr", time.Now())
+	if req.Header.Get("X-Amz-Security-Token") != "session" {
+		t.Error("Expected session token header")
+	}
+	if !strings.Contains(req.Header.Get("Authorization"), "SignedHeaders=host;x-amz-date;x-amz-security-token,") {
+		t.Errorf("Expected session token to be signed: %s", req.Header.Get("Authorization"))
+	}
+}
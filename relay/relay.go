@@ -2,16 +2,26 @@ package relay
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
+	"github.com/operable/go-relay/relay/admin"
 	"github.com/operable/go-relay/relay/bundle"
 	"github.com/operable/go-relay/relay/bus"
+	"github.com/operable/go-relay/relay/certs"
 	"github.com/operable/go-relay/relay/config"
 	"github.com/operable/go-relay/relay/engines"
+	"github.com/operable/go-relay/relay/events"
+	"github.com/operable/go-relay/relay/facts"
+	"github.com/operable/go-relay/relay/history"
 	"github.com/operable/go-relay/relay/messages"
+	"github.com/operable/go-relay/relay/metrics"
 	"github.com/operable/go-relay/relay/worker"
 	"golang.org/x/net/context"
+	"net/http"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -35,36 +45,183 @@ const (
 type Relay interface {
 	Start() error
 	Stop() error
+	// Failed receives an error when Relay can't continue running and
+	// should be stopped
+	Failed() <-chan error
+	// State returns Relay's current lifecycle state
+	State() RelayState
+	// SetMaintenance enters or leaves maintenance mode
+	SetMaintenance(enabled bool, message string)
+	// Reload re-reads the config file and applies settings which
+	// don't require a restart
+	Reload() ([]config.ConfigChange, error)
+	// StopRequested receives a value when an operator asks Relay to
+	// stop through the admin API
+	StopRequested() <-chan struct{}
+	// OnStateChange registers a callback for lifecycle state
+	// changes. See also the With*Hook options to NewRelay.
+	OnStateChange(hook StateChangeHook)
+	// SubscribeState returns a channel receiving future lifecycle
+	// state transitions and a function which cancels the
+	// subscription and closes the channel
+	SubscribeState() (<-chan StateTransition, func())
+	// StateHistory returns recent lifecycle state transitions,
+	// oldest first
+	StateHistory() []StateTransition
 }
 
+var errorImageUnavailable = errors.New("Docker image is unavailable")
+var errorCogUnreachable = errors.New("Relay couldn't reconnect to Cog")
+var errorMissingConfig = errors.New("Relay requires a configuration")
+
 type cogRelay struct {
+	configLock        sync.RWMutex
 	config            *config.Config
 	connOpts          bus.ConnectionOptions
 	conn              bus.Connection
 	queue             chan interface{}
+	backgroundQueue   chan interface{}
+	bundleLimits      *worker.BundleLimiter
+	rateLimits        *worker.RateLimiter
+	cancellations     *worker.Cancellations
+	deliveries        *worker.Deliveries
 	engines           *engines.Engines
 	dockerEngine      engines.Engine
 	catalog           *bundle.Catalog
 	announcer         Announcer
 	dynConfigUpdater  *DynamicConfigUpdater
+	facts             *facts.Gatherer
+	admin             *admin.Server
+	readOnly          *worker.ReadOnlyMode
+	maintenance       *worker.MaintenanceMode
+	history           *history.History
+	limiter           *worker.ConcurrencyLimiter
+	claims            *worker.ClaimCoordinator
+	cogClientCert     *certs.KeyPair
+	inFlight          sync.WaitGroup
+	workers           *worker.Supervisor
+	autoscaler        *worker.Autoscaler
+	scheduler         *scheduler
+	flapGuard         *bundle.FlapGuard
+	sealer            *messages.Sealer
+	bridge            *bus.Bridge
+	protocol          protocolState
+	status            relayStatus
+	healthServer      *http.Server
 	directivesReplyTo string
 	bundleTimer       *time.Timer
 	cleanTimer        *time.Timer
+	heartbeatTimer    *time.Timer
+	groupTimer        *time.Timer
+	membership        *bundle.Membership
+	singletons        *bundle.SingletonElection
+	handoverTimer     *time.Timer
+	watchdogTimer     *time.Timer
+	stuckTimer        *time.Timer
+	backpressureTimer *time.Timer
+	pressure          backpressureState
+	queueWaits        *worker.QueueWaits
+	watchdog          *watchdog
+	failed            chan error
+	stopRequested     chan struct{}
+	stopOnce          sync.Once
+	hooks             lifecycleHooks
+	events            *events.Log
+	journal           *worker.RequestJournal
+	lostRequests      []worker.RequestRecord
+	workQueue         *worker.DurableQueue
+	unrunRequests     []worker.QueuedRequest
+	deadLetters       *worker.DeadLetters
+	lostLock          sync.Mutex
+	announceStrategy  AnnouncementStrategy
+	busFactory        bus.Factory
+	workerFunc        worker.Handler
+	metricsSink       metrics.Sink
+	metricsInterval   time.Duration
+	reloadLock        sync.Mutex
+	// Cancelled once Relay has drained and is shutting down.
+	// Background work checks it instead of shared flags.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewRelay constructs a new Relay instance. It is the same as
+// NewWithOptions.
+func NewRelay(config *config.Config, options ...Option) (Relay, error) {
+	return NewWithOptions(config, options...)
 }
 
-// NewRelay constructs a new Relay instance
-func NewRelay(config *config.Config) (Relay, error) {
-	return &cogRelay{
+// NewWithOptions constructs a new Relay instance. Programs embedding
+// Relay pass options to replace its message bus, engines, logger,
+// metrics reporting or request handling, and to be told about
+// lifecycle events.
+func NewWithOptions(config *config.Config, options ...Option) (Relay, error) {
+	if config == nil {
+		return nil, errorMissingConfig
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &cogRelay{
+		ctx:               ctx,
+		cancel:            cancel,
 		config:            config,
 		engines:           engines.NewEngines(config),
 		catalog:           bundle.NewCatalog(),
 		queue:             make(chan interface{}, config.MaxConcurrent),
+		backgroundQueue:   make(chan interface{}, config.MaxConcurrent),
+		readOnly:          worker.NewReadOnlyMode(config.ReadOnly, config.ReadOnlyMessage),
+		maintenance:       worker.NewMaintenanceMode(),
+		limiter:           worker.NewConcurrencyLimiter(config.AdaptiveConcurrency, config.MinConcurrent, config.MaxConcurrent),
 		directivesReplyTo: fmt.Sprintf(directiveTopicTemplate, config.ID),
-	}, nil
+		failed:            make(chan error, 1),
+		stopRequested:     make(chan struct{}, 1),
+		announceStrategy:  announcementStrategyFor(config),
+		events:            events.NewLog(config.EventLogSize),
+		rateLimits:        worker.NewRateLimiter(config.RateLimit),
+		cancellations:     worker.NewCancellations(),
+		deliveries:        worker.NewDeliveries(config.DedupWindowDuration()),
+		queueWaits:        worker.NewQueueWaits(),
+	}
+	r.bundleLimits = worker.NewBundleLimiter(r.bundleConcurrency, r.queueRequest)
+	for _, option := range options {
+		option(r)
+	}
+	r.registerEventLog()
+	r.registerHookScripts()
+	return r, nil
 }
 
 func (r *cogRelay) Start() error {
-	if r.config.DockerEnabled() == true {
+	// Load the Cog client certificate first so a bad certificate or
+	// key is reported before slower startup work
+	if r.currentConfig().Cog.HasClientCert() {
+		keyPair, err := certs.NewKeyPair(r.currentConfig().Cog.SSLClientCert, r.currentConfig().Cog.SSLClientKey)
+		if err != nil {
+			log.Errorf("Failed to load Cog client certificate: %s.", err)
+			return err
+		}
+		if leaf := keyPair.Certificate().Leaf; leaf != nil {
+			log.Infof("Authenticating to Cog as %s with a client certificate expiring %s.",
+				leaf.Subject.CommonName, leaf.NotAfter.UTC().Format(time.RFC3339))
+		}
+		if err := keyPair.Watch(); err != nil {
+			log.Warnf("Failed to watch Cog client certificate %s: %s. Certificate changes require a restart.",
+				r.currentConfig().Cog.SSLClientCert, err)
+		}
+		r.cogClientCert = keyPair
+	}
+	if key := r.currentConfig().Cog.PayloadKeyBytes(); key != nil {
+		sealer, err := messages.NewSealer(r.currentConfig().ID, key, r.currentConfig().Cog.PayloadKeyID)
+		if err != nil {
+			return err
+		}
+		r.sealer = sealer
+		log.Info("Encrypting command response bodies sent to Cog.")
+	}
+	if enabled, _ := r.readOnly.Status(); enabled {
+		log.Warn("Relay is starting in read-only mode.")
+	}
+	r.loadMaintenance()
+	if r.currentConfig().DockerEnabled() == true {
 		dockerEngine, err := r.engines.GetEngine(engines.DockerEngineType)
 		if err != nil {
 			return err
@@ -74,62 +231,348 @@ func (r *cogRelay) Start() error {
 		}
 		r.dockerEngine = dockerEngine
 	}
+	if r.currentConfig().SelfTest.Enabled == true {
+		if err := r.runSelfTest(); err != nil {
+			log.Errorf("%s.", err)
+			return err
+		}
+	}
+	bundleHistory, err := history.Open(r.currentConfig().StatePath("bundle_history.jsonl"), r.currentConfig().BundleHistorySize)
+	if err != nil {
+		log.Errorf("Failed to load bundle history: %s.", err)
+		return err
+	}
+	r.history = bundleHistory
+	if err := r.openRequestJournal(); err != nil {
+		log.Errorf("Failed to open request journal: %s.", err)
+		return err
+	}
+	if err := r.openDurableQueue(); err != nil {
+		log.Errorf("Failed to open durable work queue: %s.", err)
+		return err
+	}
+	if err := r.openDeadLetters(); err != nil {
+		log.Errorf("Failed to open dead letters: %s.", err)
+		return err
+	}
+	if r.currentConfig().AssignmentFlapLimit > 0 {
+		r.flapGuard = bundle.NewFlapGuard(r.currentConfig().AssignmentFlapLimit, r.currentConfig().AssignmentFlapWindowDuration())
+	}
+	r.loadBundleCache()
+	if r.currentConfig().Facts.Enabled == true {
+		r.facts = facts.NewGatherer(*r.currentConfig().Facts)
+		r.facts.Run()
+	}
+	if r.currentConfig().Admin.Enabled == true {
+		r.admin = admin.NewServer(*r.currentConfig().Admin)
+		r.registerAdminHandlers()
+		if err := r.admin.Start(); err != nil {
+			return err
+		}
+	}
+	if r.currentConfig().HealthListen != "" {
+		if err := r.startHealth(); err != nil {
+			return err
+		}
+	}
 	r.connOpts = r.makeConnOpts()
-	r.connOpts.Userid = fmt.Sprintf("%s/announcer", r.config.ID)
+	r.connOpts.Userid = fmt.Sprintf("%s/announcer", r.currentConfig().ID)
 	r.connOpts.EventsHandler = r.handleBusEvents
+	r.connOpts.OutboxPath = r.currentConfig().StatePath("outbox.jsonl")
+	r.connOpts.OutboxSize = r.currentConfig().OutboxSize
 	r.connOpts.OnDisconnect = &bus.DisconnectMessage{
-		Topic: "bot/relays/discover",
-		Body:  newWill(r.config.ID, fmt.Sprintf("bot/relays/%s/announcer", r.config.ID)),
+		Topic: bus.AnnouncementTopic,
+		Body:  newWill(r.currentConfig().ID, fmt.Sprintf("bot/relays/%s/announcer", r.currentConfig().ID), messages.EncodingJSON),
+	}
+	if r.currentConfig().Cog.Presence == true {
+		// An MQTT connection has a single will, so Cog learns of
+		// unexpected disconnects from the presence topic instead
+		r.connOpts.OnDisconnect = &bus.DisconnectMessage{
+			Topic: r.presenceTopic(),
+			Body:  newPresence(r.currentConfig().ID, false, time.Time{}),
+		}
+	}
+	if r.currentConfig().Group.Enabled() {
+		r.membership = bundle.NewMembership(r.currentConfig().ID, r.currentConfig().Group.MemberTTL())
+		r.singletons = bundle.NewSingletonElection(r.currentConfig().ID, r.currentConfig().Group.HeartbeatDuration())
+	}
+	r.workers = worker.NewSupervisor(r.queue)
+	r.workers.SetBackgroundQueue(r.backgroundQueue)
+	if r.workerFunc != nil {
+		r.workers.SetHandler(r.workerFunc)
+	}
+	if r.currentConfig().AutoscaleWorkers == true {
+		r.autoscaler = worker.NewAutoscaler(r.workers, r.limiter, r.queue, r.currentConfig().MinWorkers, r.currentConfig().MaxConcurrent)
+		r.autoscaler.Run()
+		log.Infof("Scaling request workers between %d and %d.", r.currentConfig().MinWorkers, r.currentConfig().MaxConcurrent)
+	} else {
+		r.workers.Start(r.currentConfig().MaxConcurrent)
+		log.Infof("Started %d request workers.", r.currentConfig().MaxConcurrent)
 	}
-	for i := 0; i < r.config.MaxConcurrent; i++ {
-		go func() {
-			worker.ExecutionWorker(r.queue)
-		}()
+	if r.currentConfig().StuckWorkerMultiple > 0 {
+		r.stuckTimer = time.AfterFunc(stuckCheckInterval, r.scheduledStuckCheck)
+	}
+	if r.currentConfig().Backpressure != nil && r.currentConfig().Backpressure.Enabled == true {
+		r.backpressureTimer = time.AfterFunc(backpressureCheckInterval, r.scheduledBackpressureCheck)
+	}
+	if r.currentConfig().AdaptiveConcurrency == true {
+		log.Infof("Adaptive concurrency enabled. Concurrent executions will be kept between %d and %d.",
+			r.limiter.Status().Min, r.currentConfig().MaxConcurrent)
+	}
+	conn, err := r.newConnection()
+	if err != nil {
+		return err
 	}
-	log.Infof("Started %d request workers.", r.config.MaxConcurrent)
-	conn := &bus.MQTTConnection{}
 	if err := conn.Connect(r.connOpts); err != nil {
 		return err
 	}
-	if r.config.DockerEnabled() {
-		r.cleanTimer = time.AfterFunc(r.config.Docker.CleanDuration(), r.scheduledDockerCleanup)
-		log.Infof("Cleaning up expired Docker environments every %v.", r.config.Docker.CleanDuration())
+	if r.currentConfig().Bridge.Enabled == true {
+		if err := r.startBridge(); err != nil {
+			return err
+		}
+	}
+	if r.currentConfig().DockerEnabled() {
+		r.cleanTimer = time.AfterFunc(r.currentConfig().Docker.CleanDuration(), r.scheduledDockerCleanup)
+		log.Infof("Cleaning up expired Docker environments every %v.", r.currentConfig().Docker.CleanDuration())
+	}
+	log.Infof("Refreshing bundle catalog every %v.", r.currentConfig().RefreshDuration())
+	if interval := r.currentConfig().HeartbeatDuration(); interval > 0 {
+		r.heartbeatTimer = time.AfterFunc(interval, r.scheduledHeartbeat)
+		log.Infof("Publishing heartbeats every %v.", interval)
+	}
+	if r.membership != nil {
+		r.groupTimer = time.AfterFunc(r.currentConfig().Group.HeartbeatDuration(), r.scheduledGroupHeartbeat)
+		log.Infof("Joined Relay group %s.", r.currentConfig().Group.ID)
+		if r.currentConfig().Group.Sharding == true {
+			log.Info("Sharding assigned bundles across the Relay group.")
+		}
+	}
+	if r.metricsSink != nil {
+		go r.reportMetrics()
+	}
+	if r.currentConfig().Watchdog != nil && r.currentConfig().Watchdog.Enabled == true {
+		r.watchdog = newWatchdog(*r.currentConfig().Watchdog)
+		r.watchdogTimer = time.AfterFunc(r.currentConfig().Watchdog.IntervalDuration(), r.scheduledWatchdogCheck)
+		log.Infof("Checking Relay memory and goroutine use every %v.", r.currentConfig().Watchdog.IntervalDuration())
+	}
+	r.scheduler = newScheduler(r)
+	if count := r.scheduler.Start(); count > 0 {
+		log.Infof("Running %d scheduled commands.", count)
 	}
-	log.Infof("Refreshing bundle catalog every %v.", r.config.RefreshDuration())
+	go r.runHookScript(hookStarted, nil)
 	return nil
 }
 
+// Stop shuts Relay down. New commands are refused while queued and
+// running commands get up to drain_timeout to finish, after which
+// Docker commands are interrupted. Safe to call more than once.
 func (r *cogRelay) Stop() error {
+	r.stopOnce.Do(r.stop)
+	return nil
+}
+
+func (r *cogRelay) stop() {
+	r.setState(RelayDraining)
 	if r.bundleTimer != nil {
 		r.bundleTimer.Stop()
 	}
-	if r.config.DockerEnabled() {
-		if r.bundleTimer != nil {
-			r.cleanTimer.Stop()
-		}
+	if r.scheduler != nil {
+		r.scheduler.Stop()
+	}
+	if drain := r.currentConfig().DrainDuration(); drain > 0 {
+		log.Infof("Waiting up to %v for in-flight commands to finish.", drain)
+		r.awaitInFlight(drain)
+	}
+	r.cancel()
+	// Requests still waiting for a slot won't get one
+	r.limiter.Stop()
+	if r.heartbeatTimer != nil {
+		r.heartbeatTimer.Stop()
+	}
+	if r.groupTimer != nil {
+		r.groupTimer.Stop()
+	}
+	if r.handoverTimer != nil {
+		r.handoverTimer.Stop()
+	}
+	if r.watchdogTimer != nil {
+		r.watchdogTimer.Stop()
+	}
+	if r.stuckTimer != nil {
+		r.stuckTimer.Stop()
+	}
+	if r.backpressureTimer != nil {
+		r.backpressureTimer.Stop()
+	}
+	if r.currentConfig().DockerEnabled() {
+		grace := r.currentConfig().Docker.ShutdownGraceDuration()
+		r.engines.Drain(grace)
+		r.awaitInFlight(grace)
+	}
+	if r.autoscaler != nil {
+		r.autoscaler.Stop()
+	}
+	if r.workers != nil && r.workers.Stop(workerStopTimeout) == false {
+		log.Warnf("Timed out after %v waiting for request workers to exit.", workerStopTimeout)
+	}
+	r.engines.Close()
+	r.workQueue.Close()
+	if r.cleanTimer != nil {
+		r.cleanTimer.Stop()
 	}
 	if r.announcer != nil {
 		r.announcer.Halt()
 	}
+	if r.membership != nil {
+		r.publishGroupMember(false)
+	}
+	r.goOffline()
+	r.status.setConnected(false)
+	if r.bridge != nil {
+		r.bridge.Stop()
+	}
 	if r.dynConfigUpdater != nil {
 		r.dynConfigUpdater.Halt()
 	}
-	return nil
+	if r.facts != nil {
+		r.facts.Halt()
+	}
+	if r.admin != nil {
+		r.admin.Stop()
+	}
+	if r.cogClientCert != nil {
+		r.cogClientCert.Stop()
+	}
+	r.setState(RelayStopped)
+	if r.healthServer != nil {
+		r.healthServer.Close()
+	}
+}
+
+func (r *cogRelay) State() RelayState {
+	return r.status.current()
+}
+
+// stopped returns true once Relay has drained and is shutting down
+func (r *cogRelay) stopped() bool {
+	return r.ctx.Err() != nil
+}
+
+// goOffline tells Cog Relay is shutting down and disconnects from the
+// message bus. Cog otherwise only learns of the shutdown once it
+// notices missed announcements.
+func (r *cogRelay) goOffline() {
+	if r.conn == nil {
+		return
+	}
+	if r.presenceEnabled() == true {
+		if err := r.conn.Publish(r.presenceTopic(), []byte(newPresence(r.currentConfig().ID, false, time.Now()))); err != nil {
+			log.Errorf("Failed to publish Relay presence: %s.", err)
+		}
+	}
+	offline := newWill(r.currentConfig().ID, fmt.Sprintf("bot/relays/%s/announcer", r.currentConfig().ID), r.announcementEncoding())
+	if err := r.conn.Publish(bus.AnnouncementTopic, []byte(offline)); err != nil {
+		log.Errorf("Failed to announce Relay is going offline: %s.", err)
+	}
+	r.conn.Disconnect()
+}
+
+func (r *cogRelay) presenceTopic() string {
+	return fmt.Sprintf(bus.PresenceTopicTemplate, r.currentConfig().ID)
+}
+
+func (r *cogRelay) Failed() <-chan error {
+	return r.failed
+}
+
+func (r *cogRelay) StopRequested() <-chan struct{} {
+	return r.stopRequested
+}
+
+// fail reports an unrecoverable error to whoever is running Relay
+func (r *cogRelay) fail(err error) {
+	select {
+	case r.failed <- err:
+	default:
+	}
+}
+
+// How long Stop waits for request workers to finish their current
+// request and exit
+const workerStopTimeout = 5 * time.Second
+
+// awaitInFlight gives workers time to publish responses for
+// executions interrupted by shutdown
+func (r *cogRelay) awaitInFlight(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		r.inFlight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Warnf("Timed out after %v waiting for in-flight commands to finish.", timeout)
+	}
+}
+
+// attestation returns the signed config hash included in
+// announcements by attested Relays
+func (r *cogRelay) attestation() *messages.Attestation {
+	if r.currentConfig().Attested == false {
+		return nil
+	}
+	hash, err := r.currentConfig().Hash()
+	if err != nil {
+		log.Errorf("Failed to hash Relay config: %s.", err)
+		return nil
+	}
+	return &messages.Attestation{
+		ConfigHash: hash,
+		Signature:  r.currentConfig().SignHash(hash),
+	}
 }
 
 func (r *cogRelay) handleBusEvents(conn bus.Connection, event bus.Event) {
+	if event == bus.ReconnectFailedEvent {
+		r.fail(errorCogUnreachable)
+		return
+	}
+	if event == bus.DisconnectedEvent {
+		r.status.setConnected(false)
+		r.hooks.disconnected()
+		return
+	}
+	if event == bus.FlappingEvent {
+		r.status.setFlapping(true)
+		r.events.Record(events.ConnectionKind, "Reconnecting paused because the connection to Cog keeps dropping.")
+		return
+	}
 	if event == bus.ConnectedEvent {
 		r.conn = conn
+		r.status.setConnected(true)
+		r.status.setFlapping(false)
+		r.hooks.connected()
+		r.failLostRequests(conn)
+		r.recoverQueuedRequests(conn)
+		// Optional features, including presence, wait for the
+		// handshake
+		r.protocol.set(0, []string{})
+		go r.handshake(conn)
 		if r.announcer == nil {
-			r.announcer = NewAnnouncer(r.config.ID, r.conn, r.catalog)
+			r.announcer = NewAnnouncer(r.currentConfig().ID, r.conn, r.catalog, r.attestation(), r.announcementEncoding,
+				r.currentConfig().ParsedTags, r.currentConfig().ParsedLabels, r.announceStrategy)
+			if enabled, _ := r.maintenance.Status(); enabled == true {
+				r.announcer.Withdraw(true)
+			}
 			if err := r.announcer.Run(); err != nil {
 				log.Errorf("Failed to start announcer: %s.", err)
 				panic(err)
 			}
-			if r.config.ManagedDynamicConfig == true {
+			if r.currentConfig().ManagedDynamicConfig == true {
 				opts := r.makeConnOpts()
-				r.dynConfigUpdater = NewDynamicConfigUpdater(r.config.ID, opts, r.config.DynamicConfigRoot,
-					r.config.ManagedDynamicConfigRefreshDuration())
+				r.dynConfigUpdater = NewDynamicConfigUpdater(r.currentConfig().ID, r.newConnection, opts, r.currentConfig().DynamicConfigRoot,
+					r.currentConfig().ManagedDynamicConfigRefreshDuration())
 				if err := r.dynConfigUpdater.Run(); err != nil {
 					log.Errorf("Failed to start bundle dynamic config updater: %s.", err)
 					panic(err)
@@ -145,35 +588,160 @@ func (r *cogRelay) handleBusEvents(conn bus.Connection, event bus.Event) {
 			log.Errorf("Failed to set Relay subscriptions: %s.", err)
 			panic(err)
 		}
-		if r.catalog.Len() > 0 {
+		if r.membership != nil {
+			r.publishGroupMember(true)
+		}
+		if r.catalog.Len() > 0 && r.status.lastRefreshed() == true {
 			r.catalog.Reconnected()
+		} else if r.membership != nil && r.currentConfig().Group.Sharding == true && r.status.lastRefreshed() == false {
+			log.Infof("Waiting %v for Relay group members before loading bundle catalog.", groupSettleDelay)
+			time.AfterFunc(groupSettleDelay, func() {
+				if r.stopped() == false {
+					r.requestBundles()
+				}
+			})
 		} else {
 			log.Info("Loading bundle catalog.")
 			r.requestBundles()
 		}
+		if r.State() == RelayDegraded && r.bundleTimer == nil {
+			// Cog may not be listening yet so keep asking
+			r.bundleTimer = time.AfterFunc(r.currentConfig().RefreshDuration(), r.scheduledBundleRefresh)
+		}
 	}
 }
 
 func (r *cogRelay) setSubscriptions() error {
 	// Set directives handler
-	if err := r.conn.Subscribe(fmt.Sprintf(directiveTopicTemplate, r.config.ID), r.handleDirective); err != nil {
+	if err := r.conn.Subscribe(fmt.Sprintf(directiveTopicTemplate, r.currentConfig().ID), r.handleDirective); err != nil {
 		return err
 	}
-	return r.conn.Subscribe(fmt.Sprintf(commandTopicTemplate, r.config.ID), r.handleCommand)
+	if r.currentConfig().ExecutionClaims == true {
+		if r.claims == nil {
+			r.claims = worker.NewClaimCoordinator(r.currentConfig().ID, r.conn, r.currentConfig().ExecutionClaimWindowDuration())
+		}
+		if err := r.conn.Subscribe(worker.ClaimTopic, r.claims.HandleClaim); err != nil {
+			return err
+		}
+	}
+	if r.membership != nil {
+		if err := r.conn.Subscribe(r.groupTopic(), r.handleGroupMember); err != nil {
+			return err
+		}
+	}
+	return r.conn.Subscribe(fmt.Sprintf(commandTopicTemplate, r.currentConfig().ID), r.handleCommand)
 }
 
 func (r *cogRelay) handleCommand(conn bus.Connection, topic string, message []byte) {
 	log.Debugf("Got invocation request on %s", topic)
+	r.enqueue(topic, message, r.conn)
+}
+
+// enqueue queues a request for execution. Responses are sent through
+// publisher. Requests from Cog are also recorded in the durable queue,
+// if enabled, until they start.
+func (r *cogRelay) enqueue(topic string, message []byte, publisher bus.MessagePublisher) {
+	if r.refuseSaturated(topic, message, publisher) {
+		return
+	}
+	queuedAt := time.Now()
+	var queueID uint64
+	if _, scheduled := publisher.(*scheduleNotifier); scheduled == false {
+		queueID = r.workQueue.Add(topic, message, queuedAt)
+	}
+	r.queueInvocation(topic, message, publisher, queuedAt, queueID)
+}
+
+// queueInvocation queues a request whose durable queue entry, if any,
+// is queueID
+func (r *cogRelay) queueInvocation(topic string, message []byte, publisher bus.MessagePublisher,
+	queuedAt time.Time, queueID uint64) {
 	invoke := &worker.CommandInvocation{
-		RelayConfig: r.config,
+		RelayConfig: r.currentConfig(),
 		Engines:     r.engines,
-		Publisher:   r.conn,
+		Publisher:   publisher,
 		Catalog:     r.catalog,
+		Facts:       r.facts,
+		ReadOnly:    r.readOnly,
+		Maintenance: r.maintenance,
+		InFlight:    &r.inFlight,
+		Limiter:     r.limiter,
+		Claims:      r.claims,
+		Sealer:      r.sealer,
+		SealReplies: r.protocol.supports(messages.FeatureSealed),
+		Events:      r.events,
+		Journal:     r.journal,
+		Bundles:     r.bundleLimits,
+		Rates:       r.rateLimits,
+		Cancels:     r.cancellations,
+		Deliveries:  r.deliveries,
+		Backlog:     r.workQueue,
+		QueueID:     queueID,
+		DeadLetters: r.deadLetters,
+		Waits:       r.queueWaits,
 		Topic:       topic,
 		Payload:     message,
+		QueuedAt:    queuedAt,
+		Shutdown:    r.State() >= RelayDraining,
 	}
 	ctx := context.WithValue(context.Background(), "invoke", invoke)
-	r.queue <- ctx
+	r.inFlight.Add(1)
+	r.queueRequest(ctx)
+}
+
+// queueRequest puts a request on the queue matching its priority
+func (r *cogRelay) queueRequest(thing interface{}) {
+	r.queueFor(thing) <- thing
+}
+
+// requeueRequest puts a request being retried back on the queue
+// matching its priority. Returns false instead of blocking once Relay
+// is shutting down.
+func (r *cogRelay) requeueRequest(thing interface{}) bool {
+	if r.ctx.Err() != nil {
+		return false
+	}
+	select {
+	case r.queueFor(thing) <- thing:
+		return true
+	case <-r.ctx.Done():
+		return false
+	}
+}
+
+func (r *cogRelay) queueFor(thing interface{}) chan interface{} {
+	if invoke, ok := thing.(context.Context).Value("invoke").(*worker.CommandInvocation); ok &&
+		r.isBackground(invoke.Topic, invoke.Publisher) {
+		return r.backgroundQueue
+	}
+	return r.queue
+}
+
+// bundleConcurrency returns the most commands of the named bundle
+// which may run at once. Zero means no limit.
+func (r *cogRelay) bundleConcurrency(bundle string) int {
+	return r.currentConfig().SettingsForBundle(bundle).MaxConcurrent
+}
+
+// isBackground returns true if a request should wait behind
+// interactive requests. Scheduled commands and commands of bundles
+// configured with background priority are background requests.
+func (r *cogRelay) isBackground(topic string, publisher bus.MessagePublisher) bool {
+	if _, scheduled := publisher.(*scheduleNotifier); scheduled {
+		return true
+	}
+	// Command topics are /bot/commands/<relay id>/<bundle>/<command>
+	parts := strings.Split(topic, "/")
+	if len(parts) < 5 {
+		return false
+	}
+	return r.currentConfig().SettingsForBundle(parts[4]).IsBackground()
+}
+
+// queueDepth returns the number of requests waiting to run and the
+// number which can wait
+func (r *cogRelay) queueDepth() (int, int) {
+	return len(r.queue) + len(r.backgroundQueue) + r.bundleLimits.Held(), cap(r.queue) + cap(r.backgroundQueue)
 }
 
 func (r *cogRelay) handleDirective(conn bus.Connection, topic string, message []byte) {
@@ -187,6 +755,46 @@ func (r *cogRelay) handleDirective(conn bus.Connection, topic string, message []
 	case *messages.ListBundlesResponseEnvelope:
 		log.Debug("Processing bundle catalog updates.")
 		r.updateCatalog(tm.(*messages.ListBundlesResponseEnvelope))
+	case *messages.ReadOnlyEnvelope:
+		directive := tm.(*messages.ReadOnlyEnvelope).ReadOnly
+		if directive != nil {
+			r.setReadOnly(directive.Enabled, directive.Message)
+		}
+	case *messages.MaintenanceEnvelope:
+		directive := tm.(*messages.MaintenanceEnvelope).Maintenance
+		if directive != nil {
+			r.SetMaintenance(directive.Enabled, directive.Message)
+		}
+	case *messages.ConfigureEnvelope:
+		directive := tm.(*messages.ConfigureEnvelope).Configure
+		if directive != nil {
+			r.configure(directive)
+		}
+	case *messages.CancelEnvelope:
+		directive := tm.(*messages.CancelEnvelope).Cancel
+		if directive != nil {
+			r.cancelExecution(directive)
+		}
+	}
+}
+
+// cancelExecution stops the commands a Cancel directive names
+func (r *cogRelay) cancelExecution(directive *messages.Cancel) {
+	if directive.CorrelationID == "" && directive.PipelineID == "" {
+		log.Warn("Ignoring cancel directive without a correlation or pipeline ID.")
+		return
+	}
+	cancelled := r.cancellations.Cancel(directive.CorrelationID, directive.PipelineID, directive.Reason)
+	log.Infof("Cancelled %d running commands for correlation ID %q, pipeline ID %q.",
+		cancelled, directive.CorrelationID, directive.PipelineID)
+}
+
+func (r *cogRelay) setReadOnly(enabled bool, message string) {
+	r.readOnly.Set(enabled, message)
+	if enabled {
+		log.Warn("Read-only mode enabled. Commands not marked read-only will be rejected.")
+	} else {
+		log.Info("Read-only mode disabled.")
 	}
 }
 
@@ -197,40 +805,96 @@ func (r *cogRelay) updateCatalog(envelope *messages.ListBundlesResponseEnvelope)
 		configFile := b.ConfigFile
 		bundles = append(bundles, &configFile)
 	}
-	r.catalog.Replace(bundles)
-	if r.catalog.IsChanged() {
+	r.recordAssignment(bundles)
+	r.catalog.Replace(r.shardAssignment(r.electSingletons(r.dampenAssignment(bundles))))
+	changed := r.catalog.IsChanged()
+	if changed || r.catalog.HasPullFailures() {
+		if changed == false {
+			log.Info("Retrying bundles whose Docker images failed to download.")
+		}
 		if err := r.refreshBundles(); err != nil {
 			log.Errorf("Bundle catalog refresh failed: %s.", err)
+			r.events.Record(events.RefreshKind, "Bundle catalog refresh failed: %s.", err)
 		} else {
 			log.Info("Changes to bundle catalog detected.")
+			r.recordAnnouncement(changed)
 			r.announcer.SendAnnouncement()
+			r.saveBundleCache()
 		}
 	} else {
 		log.Debug("Bundle catalog is unchanged.")
 	}
-	r.bundleTimer = time.AfterFunc(r.config.RefreshDuration(), r.scheduledBundleRefresh)
+	if previous, ready := r.status.refreshed(time.Now()); ready {
+		if previous == RelayDegraded {
+			log.Info("Received bundle catalog from Cog. Relay is no longer degraded.")
+		}
+		r.hooks.stateChanged(previous, RelayReady)
+	}
+	r.hooks.bundlesRefreshed(r.catalog.BundleNames())
+	if r.State() >= RelayDraining {
+		return
+	}
+	if r.bundleTimer != nil {
+		// Refreshes requested outside the schedule would otherwise
+		// start a second refresh cycle
+		r.bundleTimer.Stop()
+	}
+	r.bundleTimer = time.AfterFunc(r.currentConfig().RefreshDuration(), r.scheduledBundleRefresh)
+}
+
+func (r *cogRelay) recordAssignment(bundles []*config.Bundle) {
+	refs := []history.BundleRef{}
+	for _, bundle := range bundles {
+		refs = append(refs, history.BundleRef{
+			Name:    bundle.Name,
+			Version: bundle.Version,
+		})
+	}
+	if snapshot := r.history.Record(history.AssignmentKind, "Cog bundle list", refs); snapshot != nil {
+		log.Infof("Cog changed bundle assignments. Added: %d, Removed: %d, Updated: %d.",
+			len(snapshot.Added), len(snapshot.Removed), len(snapshot.Updated))
+	}
+}
+
+func (r *cogRelay) recordAnnouncement(catalogChanged bool) {
+	reason := "Bundle catalog changed"
+	if catalogChanged == false {
+		reason = "Retried failed image pulls"
+	}
+	refs := []history.BundleRef{}
+	for _, bundle := range getBundles(r.catalog) {
+		refs = append(refs, history.BundleRef{
+			Name:    bundle.Name,
+			Version: bundle.Version,
+		})
+	}
+	r.history.Record(history.AnnouncementKind, reason, refs)
 }
 
 func (r *cogRelay) refreshBundles() error {
-	var dockerEngine engines.Engine
-	var err error
-	if r.config.DockerEnabled() == true {
-		dockerEngine, err = r.engines.GetEngine(engines.DockerEngineType)
-		if err != nil {
+	if r.currentConfig().DockerEnabled() == true {
+		// Fail fast if the Docker engine is unavailable
+		if _, err := r.engines.GetEngine(engines.DockerEngineType); err != nil {
 			return err
 		}
 	}
+	pending := []*config.Bundle{}
 	for _, name := range r.catalog.BundleNames() {
 		if bundle := r.catalog.Find(name); bundle != nil {
+			if missing := bundle.MissingLabels(r.currentConfig().ParsedLabels); len(missing) > 0 {
+				log.Infof("Skipping bundle %s %s. Relay lacks required labels: %s.", bundle.Name, bundle.Version,
+					strings.Join(missing, ", "))
+				bundle.SetAvailable(false)
+				continue
+			}
 			if bundle.NeedsRefresh() {
 				if bundle.IsDocker() {
-					if r.config.DockerEnabled() == false {
+					if r.currentConfig().DockerEnabled() == false {
 						log.Infof("Skipping Docker-based bundle %s %s.", bundle.Name, bundle.Version)
 						bundle.SetAvailable(false)
 						continue
 					}
-					avail, _ := dockerEngine.IsAvailable(bundle.Docker.Image, bundle.Docker.Tag)
-					bundle.SetAvailable(avail)
+					pending = append(pending, bundle)
 				} else {
 					engine, _ := r.engines.EngineForBundle(bundle)
 					avail, _ := engine.IsAvailable(bundle.Name, bundle.Version)
@@ -239,13 +903,75 @@ func (r *cogRelay) refreshBundles() error {
 			}
 		}
 	}
+	if len(pending) > 0 {
+		r.prefetchImages(pending)
+	}
 	return nil
 }
 
+// prefetchImages pulls the images of Docker bundles concurrently,
+// bounded by docker/pull_parallelism, and returns once every pull
+// has finished
+func (r *cogRelay) prefetchImages(bundles []*config.Bundle) {
+	started := time.Now()
+	log.Infof("Prefetching %d Docker images using %d parallel pulls.", len(bundles), r.currentConfig().Docker.PullParallelism)
+	var publisher bus.MessagePublisher
+	if r.conn != nil {
+		publisher = r.conn
+	}
+	reporter := newPullReporter(r.currentConfig().ID, publisher, r.engines, bundles)
+	go reporter.run(r.currentConfig().Docker.PullProgressDuration())
+	slots := make(chan struct{}, r.currentConfig().Docker.PullParallelism)
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	failed := []string{}
+	for _, bundle := range bundles {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(bundle *config.Bundle) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			reporter.setStatus(bundle, pullPulling, nil)
+			// DockerEngine instances aren't safe for concurrent use
+			// so each pull gets its own
+			dockerEngine, err := r.engines.GetEngine(engines.DockerEngineType)
+			avail := false
+			if err == nil {
+				avail, err = dockerEngine.IsAvailable(bundle.Docker.Image, bundle.Docker.Tag)
+			}
+			if avail == true {
+				bundle.SetAvailable(true)
+				reporter.setStatus(bundle, pullAvailable, nil)
+			} else {
+				if err == nil {
+					err = errorImageUnavailable
+				}
+				bundle.RecordPullFailure(err)
+				reporter.setStatus(bundle, pullFailed, err)
+				lock.Lock()
+				failed = append(failed, fmt.Sprintf("%s %s", bundle.Name, bundle.Version))
+				lock.Unlock()
+			}
+		}(bundle)
+	}
+	wg.Wait()
+	reporter.finish()
+	elapsed := time.Now().Sub(started)
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		log.Warnf("Prefetched %d of %d Docker images in %v. Unavailable bundles: %s.", len(bundles)-len(failed),
+			len(bundles), elapsed, strings.Join(failed, ", "))
+	} else {
+		log.Infof("Prefetched %d Docker images in %v.", len(bundles), elapsed)
+	}
+}
+
 func (r *cogRelay) requestBundles() error {
 	msg := messages.ListBundlesEnvelope{
 		ListBundles: &messages.ListBundlesMessage{
-			RelayID: r.config.ID,
+			RelayID: r.currentConfig().ID,
 			ReplyTo: r.directivesReplyTo,
 		},
 	}
@@ -255,13 +981,22 @@ func (r *cogRelay) requestBundles() error {
 }
 
 func (r *cogRelay) scheduledBundleRefresh() {
-	if err := r.requestBundles(); err != nil {
+	if r.State() >= RelayDraining {
+		return
+	}
+	err := r.requestBundles()
+	if err != nil {
 		log.Errorf("Scheduled bundle catalog refresh failed: %s.", err)
-		r.bundleTimer = time.AfterFunc(r.config.RefreshDuration(), r.scheduledBundleRefresh)
+	}
+	if err != nil || r.State() == RelayDegraded {
+		r.bundleTimer = time.AfterFunc(r.currentConfig().RefreshDuration(), r.scheduledBundleRefresh)
 	}
 }
 
 func (r *cogRelay) scheduledDockerCleanup() {
+	if r.stopped() {
+		return
+	}
 	cleaned := r.dockerEngine.Clean()
 	container := "containers"
 	if cleaned == 1 {
@@ -270,22 +1005,95 @@ func (r *cogRelay) scheduledDockerCleanup() {
 	if cleaned > 0 {
 		log.Infof("Scheduled Docker clean up removed %d %s.", cleaned, container)
 	}
-	r.cleanTimer = time.AfterFunc(r.config.Docker.CleanDuration(), r.scheduledDockerCleanup)
+	r.cleanTimer = time.AfterFunc(r.currentConfig().Docker.CleanDuration(), r.scheduledDockerCleanup)
 }
 
 func (r *cogRelay) makeConnOpts() bus.ConnectionOptions {
 	connOpts := bus.ConnectionOptions{
-		Userid:        r.config.ID,
-		Password:      r.config.Cog.Token,
-		Host:          r.config.Cog.Host,
-		Port:          r.config.Cog.Port,
-		SSLEnabled:    r.config.Cog.SSLEnabled,
-		SSLCertPath:   r.config.Cog.SSLCertPath,
+		Userid:        r.currentConfig().ID,
+		Password:      r.currentConfig().Cog.Token,
+		Host:          r.currentConfig().Cog.Host,
+		Port:          r.currentConfig().Cog.Port,
+		SSLEnabled:    r.currentConfig().Cog.SSLEnabled,
+		SSLCertPath:   r.currentConfig().Cog.SSLCertPath,
+	}
+	connOpts.ReconnectMaxInterval = r.currentConfig().Cog.MaxBackoffDuration()
+	connOpts.ReconnectMaxAttempts = r.currentConfig().Cog.MaxReconnects
+	if r.currentConfig().Cog.FlapLimit > 0 {
+		connOpts.FlapLimit = r.currentConfig().Cog.FlapLimit
+		connOpts.FlapWindow = r.currentConfig().Cog.FlapWindowDuration()
+		connOpts.FlapCooldown = r.currentConfig().Cog.FlapCooldownDuration()
+	}
+	connOpts.Compression = r.currentConfig().Cog.Compression
+	connOpts.CompressAbove = r.currentConfig().Cog.CompressAbove
+	connOpts.CompressionAgreed = func() bool {
+		return r.protocol.supports(messages.FeatureGzip)
+	}
+	connOpts.TopicPrefix = r.currentConfig().Cog.TopicPrefix
+	connOpts.Tuning = bus.TuningOptions{
+		KeepAlive:      r.currentConfig().Cog.KeepAliveDuration(),
+		PingTimeout:    r.currentConfig().Cog.PingTimeoutDuration(),
+		ConnectTimeout: r.currentConfig().Cog.ConnectTimeoutDuration(),
+		WriteTimeout:   r.currentConfig().Cog.WriteTimeoutDuration(),
+		MaxInFlight:    r.currentConfig().Cog.MaxInFlight,
+	}
+	if r.cogClientCert != nil {
+		connOpts.ClientCert = r.cogClientCert.GetClientCertificate
+	}
+	connOpts.Delivery = bus.DeliveryOptions{
+		CommandQoS:          r.currentConfig().Cog.CommandQoSLevel(),
+		ResponseQoS:         r.currentConfig().Cog.ResponseQoSLevel(),
+		AnnouncementQoS:     r.currentConfig().Cog.AnnouncementQoSLevel(),
+		RetainAnnouncements: r.currentConfig().Cog.RetainAnnounce,
+		PersistentSession:   r.currentConfig().Cog.PersistSession,
+	}
+	if pins := r.currentConfig().Cog.Pins(); len(pins) > 0 {
+		connOpts.SSLPins = pins
+	}
+	if r.currentConfig().Cog.UsesWebSocket() {
+		connOpts.WebSocketPath = r.currentConfig().Cog.WebSocketPath
 	}
 	return connOpts
 }
 
 
+// startBridge connects to the internal broker and starts forwarding
+// messages between it and Cog's bus. Connecting happens in the
+// background so an unreachable internal broker doesn't hold up Relay.
+func (r *cogRelay) startBridge() error {
+	outer, err := r.newConnection()
+	if err != nil {
+		return err
+	}
+	inner, err := r.newConnection()
+	if err != nil {
+		return err
+	}
+	outerOpts := r.makeConnOpts()
+	outerOpts.Userid = fmt.Sprintf("%s/bridge", r.currentConfig().ID)
+	innerOpts := bus.ConnectionOptions{
+		Userid:      r.currentConfig().Bridge.Username,
+		Password:    r.currentConfig().Bridge.Password,
+		Host:        r.currentConfig().Bridge.Host,
+		Port:        r.currentConfig().Bridge.Port,
+		SSLEnabled:  r.currentConfig().Bridge.SSLEnabled,
+		SSLCertPath: r.currentConfig().Bridge.SSLCertPath,
+	}
+	r.bridge = bus.NewBridge(outer, inner, bus.BridgeRules{
+		Inbound:  r.currentConfig().Bridge.InboundTopics(),
+		Outbound: r.currentConfig().Bridge.OutboundTopics(),
+		Deny:     r.currentConfig().Bridge.DeniedTopics(),
+	})
+	go func() {
+		if err := r.bridge.Run(outerOpts, innerOpts); err != nil {
+			log.Errorf("Failed to start bridge to %s:%d: %s.", r.currentConfig().Bridge.Host, r.currentConfig().Bridge.Port, err)
+			return
+		}
+		log.Infof("Bridging messages between Cog and %s:%d.", r.currentConfig().Bridge.Host, r.currentConfig().Bridge.Port)
+	}()
+	return nil
+}
+
 func fixBundleVersion(version string) string {
 	if len(strings.Split(version, ".")) == 2 {
 		return fmt.Sprintf("%s.0", version)
@@ -293,8 +1101,34 @@ func fixBundleVersion(version string) string {
 	return version
 }
 
-func newWill(id string, replyTo string) string {
-	announcement := messages.NewOfflineAnnouncement(id, replyTo)
-	data, _ := json.Marshal(announcement)
+// newPresence returns a presence message. Wills carry no timestamp
+// since they are registered long before they are sent.
+func newPresence(id string, online bool, at time.Time) string {
+	presence := messages.Presence{
+		RelayID: id,
+		Online:  online,
+	}
+	if at.IsZero() == false {
+		presence.Timestamp = at.Unix()
+	}
+	data, _ := json.Marshal(presence)
 	return string(data)
 }
+
+func newWill(id string, replyTo string, encoding string) string {
+	return string(encodeAnnouncement(messages.NewOfflineAnnouncement(id, replyTo), encoding))
+}
+
+// encodeAnnouncement encodes announcement as JSON unless encoding is
+// protobuf
+func encodeAnnouncement(announcement *messages.AnnouncementEnvelope, encoding string) []byte {
+	if encoding == messages.EncodingProtobuf {
+		data, err := messages.EncodeAnnouncement(announcement)
+		if err == nil {
+			return data
+		}
+		log.Errorf("Failed to encode announcement as protobuf: %s.", err)
+	}
+	data, _ := json.Marshal(announcement)
+	return data
+}
@@ -0,0 +1,112 @@
+package relay
+
+import (
+	"errors"
+	log "github.com/Sirupsen/logrus"
+	"github.com/operable/go-relay/relay/config"
+)
+
+var errorNoConfigPath = errors.New("Relay wasn't started from a config file")
+
+// Reload re-reads the config file Relay was started with and applies
+// every setting which can change without a restart. The bus connection
+// and in-flight executions are left alone. Returns all settings which
+// differ from the running config.
+func (r *cogRelay) Reload() ([]config.ConfigChange, error) {
+	r.reloadLock.Lock()
+	defer r.reloadLock.Unlock()
+	running := r.currentConfig()
+	if running.Path == "" {
+		return nil, errorNoConfigPath
+	}
+	rawConfig, err := config.LoadConfig(running.Path)
+	if err != nil {
+		return nil, err
+	}
+	candidate, err := rawConfig.Parse(running.Build.CommandDriverTag)
+	if err == nil {
+		candidate.DevMode = running.DevMode
+		candidate.Build = running.Build
+		candidate.Path = running.Path
+		err = candidate.Verify()
+	}
+	if err != nil {
+		log.Errorf("Reloading config file '%s' failed: %s.", running.Path, err)
+		return nil, err
+	}
+	previous := r.reloadSnapshot()
+	reloaded, changes := running.Reloaded(candidate)
+	r.swapConfig(reloaded)
+	for _, change := range changes {
+		if change.Apply == config.RestartApply {
+			log.Warnf("Setting %s changed but requires a restart to take effect.", change.Setting)
+			continue
+		}
+		log.Infof("Setting %s changed from '%s' to '%s'.", change.Setting, change.Current, change.Candidate)
+	}
+	r.applyReloadedSettings(previous)
+	log.Infof("Reloaded config file '%s'.", running.Path)
+	return changes, nil
+}
+
+// currentConfig returns the config Relay is running with. Configs are
+// never changed once in use; reloads and Cog directives swap in a
+// changed copy instead, so the result can be read without locking.
+func (r *cogRelay) currentConfig() *config.Config {
+	r.configLock.RLock()
+	defer r.configLock.RUnlock()
+	return r.config
+}
+
+// swapConfig makes updated the config Relay is running with. Requests
+// already queued keep the config they were queued with.
+func (r *cogRelay) swapConfig(updated *config.Config) {
+	r.configLock.Lock()
+	r.config = updated
+	r.configLock.Unlock()
+	r.engines.SetConfig(updated)
+}
+
+// reloadedSettings holds the hot settings Relay has to act on when
+// they change. Everything else reads the config each time it's needed.
+type reloadedSettings struct {
+	logLevel        string
+	refreshInterval string
+	maxConcurrent   int
+	readOnly        bool
+	readOnlyMessage string
+	cleanInterval   string
+}
+
+func (r *cogRelay) reloadSnapshot() reloadedSettings {
+	running := r.currentConfig()
+	return reloadedSettings{
+		logLevel:        running.LogLevel,
+		refreshInterval: running.Cog.RefreshInterval,
+		maxConcurrent:   running.MaxConcurrent,
+		readOnly:        running.ReadOnly,
+		readOnlyMessage: running.ReadOnlyMessage,
+		cleanInterval:   running.Docker.CleanInterval,
+	}
+}
+
+func (r *cogRelay) applyReloadedSettings(previous reloadedSettings) {
+	current := r.reloadSnapshot()
+	if current.logLevel != previous.logLevel {
+		if level, err := parseLogLevel(current.logLevel); err == nil {
+			log.SetLevel(level)
+		}
+	}
+	if current.refreshInterval != previous.refreshInterval && r.bundleTimer != nil {
+		r.bundleTimer.Reset(r.currentConfig().RefreshDuration())
+	}
+	if current.maxConcurrent != previous.maxConcurrent && r.workers != nil {
+		r.setMaxConcurrent(current.maxConcurrent)
+	}
+	if current.readOnly != previous.readOnly || current.readOnlyMessage != previous.readOnlyMessage {
+		r.setReadOnly(current.readOnly, current.readOnlyMessage)
+	}
+	if current.cleanInterval != previous.cleanInterval && r.cleanTimer != nil {
+		r.cleanTimer.Reset(r.currentConfig().Docker.CleanDuration())
+	}
+}
@@ -0,0 +1,113 @@
+package relay
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/operable/go-relay/relay/config"
+	"github.com/operable/go-relay/relay/messages"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"testing"
+)
+
+const reloadConfigTemplate = `id: 2bba0d1f-a30c-45ec-87e6-e4c5d8c6104f
+version: 1
+enabled_engines: native
+log_level: %s
+execution_timeout: %s
+cog:
+  token: wubba
+  refresh_interval: %s
+max_concurrent: %d
+`
+
+func writeReloadConfig(t *testing.T, configPath string, generation int) {
+	levels := []string{"info", "warn"}
+	timeouts := []string{"60s", "90s"}
+	refreshes := []string{"1m", "2m"}
+	contents := fmt.Sprintf(reloadConfigTemplate, levels[generation%2], timeouts[generation%2],
+		refreshes[generation%2], generation%2+1)
+	if err := ioutil.WriteFile(configPath, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadWhileExecuting(t *testing.T) {
+	os.Clearenv()
+	defer log.SetLevel(log.GetLevel())
+	dir, err := ioutil.TempDir("", "relay_reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("RELAY_DYNAMIC_CONFIG_ROOT", dir)
+	configPath := path.Join(dir, "relay.conf")
+	writeReloadConfig(t, configPath, 0)
+	rawConfig, err := config.LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	relayConfig, err := rawConfig.Parse("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	relayConfig.Path = configPath
+	relayConfig.Build = config.BuildInfo{CommandDriverTag: "0.1"}
+	if err := relayConfig.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	relay, err := NewRelay(relayConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := relay.(*cogRelay)
+	queued := r.currentConfig()
+
+	stop := make(chan struct{})
+	var executing sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		executing.Add(1)
+		// Reads the settings an executing request reads
+		go func() {
+			defer executing.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				running := r.currentConfig()
+				if running.ExecutionTimeoutFor("deploy") <= 0 || running.MaxConcurrent < 1 ||
+					running.LogLevel == "" || running.RefreshDuration() <= 0 {
+					t.Error("Read a half applied config")
+					return
+				}
+			}
+		}()
+	}
+	for generation := 1; generation <= 20; generation++ {
+		writeReloadConfig(t, configPath, generation)
+		if _, err := r.Reload(); err != nil {
+			t.Fatal(err)
+		}
+		directive := &messages.Configure{LogLevel: "info", RefreshInterval: "30s"}
+		if err := r.applyConfigure(directive); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	executing.Wait()
+
+	if queued.LogLevel != "info" || queued.Cog.RefreshInterval != "1m" || queued.MaxConcurrent != 1 {
+		t.Errorf("Expected config captured before reloading to be unchanged: %s %s %d", queued.LogLevel,
+			queued.Cog.RefreshInterval, queued.MaxConcurrent)
+	}
+	running := r.currentConfig()
+	if running.Cog.RefreshInterval != "30s" || running.MaxConcurrent != 1 ||
+		running.ExecutionTimeout != "60s" {
+		t.Errorf("Expected reloads and directives to be applied: %s %d %s", running.Cog.RefreshInterval,
+			running.MaxConcurrent, running.ExecutionTimeout)
+	}
+}
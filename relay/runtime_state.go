@@ -0,0 +1,144 @@
+package relay
+
+import (
+	"encoding/json"
+	log "github.com/Sirupsen/logrus"
+	"github.com/operable/go-relay/relay/bus"
+	"github.com/operable/go-relay/relay/messages"
+	"github.com/operable/go-relay/relay/worker"
+	"io/ioutil"
+	"os"
+)
+
+const (
+	requestJournalFile = "requests.json"
+	durableQueueFile   = "queue.jsonl"
+	maintenanceFile    = "maintenance.json"
+)
+
+// openRequestJournal starts recording executing requests under
+// state_dir and remembers those the previous run lost so they can be
+// failed once Cog is reachable
+func (r *cogRelay) openRequestJournal() error {
+	path := r.currentConfig().StatePath(requestJournalFile)
+	if path == "" {
+		return nil
+	}
+	journal, lost, err := worker.OpenRequestJournal(path)
+	if err != nil {
+		return err
+	}
+	r.journal = journal
+	if len(lost) > 0 {
+		log.Warnf("%d commands were running when Relay last stopped. Failing them once connected to Cog.", len(lost))
+		r.lostLock.Lock()
+		r.lostRequests = lost
+		r.lostLock.Unlock()
+	}
+	return nil
+}
+
+// failLostRequests tells Cog the commands the previous run lost have
+// failed so their pipelines don't wait until they time out
+func (r *cogRelay) failLostRequests(publisher bus.MessagePublisher) {
+	r.lostLock.Lock()
+	lost := r.lostRequests
+	r.lostRequests = nil
+	r.lostLock.Unlock()
+	if len(lost) > 0 {
+		worker.FailLostRequests(publisher, lost)
+	}
+}
+
+// openDurableQueue starts persisting queued requests under state_dir
+// if durable_queue is enabled and remembers those the previous run
+// never started so they can be recovered once Cog is reachable
+func (r *cogRelay) openDurableQueue() error {
+	if r.currentConfig().DurableQueue == false {
+		return nil
+	}
+	queue, unrun, err := worker.OpenDurableQueue(r.currentConfig().StatePath(durableQueueFile))
+	if err != nil {
+		return err
+	}
+	r.workQueue = queue
+	if len(unrun) > 0 {
+		log.Warnf("%d commands were queued when Relay last stopped. Recovering them once connected to Cog.", len(unrun))
+		r.lostLock.Lock()
+		r.unrunRequests = unrun
+		r.lostLock.Unlock()
+	}
+	return nil
+}
+
+// recoverQueuedRequests reruns or fails, according to queue_recovery,
+// the requests the previous run queued but never started
+func (r *cogRelay) recoverQueuedRequests(publisher bus.MessagePublisher) {
+	r.lostLock.Lock()
+	unrun := r.unrunRequests
+	r.unrunRequests = nil
+	r.lostLock.Unlock()
+	if len(unrun) == 0 {
+		return
+	}
+	if r.currentConfig().RerunQueuedRequests() == false {
+		worker.FailQueuedRequests(publisher, r.sealer, r.currentConfig().StrictMessageValidation(), unrun)
+		for _, request := range unrun {
+			r.workQueue.Remove(request.ID)
+		}
+		return
+	}
+	log.Infof("Rerunning %d commands queued when Relay last stopped.", len(unrun))
+	// Queueing blocks while workers are busy
+	go func() {
+		for _, request := range unrun {
+			r.queueInvocation(request.Topic, request.Payload, publisher, request.QueuedAt, request.ID)
+		}
+	}()
+}
+
+// saveMaintenance persists maintenance mode under state_dir so a
+// restarted Relay doesn't announce bundles it had withdrawn
+func (r *cogRelay) saveMaintenance() {
+	path := r.currentConfig().StatePath(maintenanceFile)
+	if path == "" {
+		return
+	}
+	enabled, message := r.maintenance.Status()
+	data, _ := json.Marshal(messages.Maintenance{
+		Enabled: enabled,
+		Message: message,
+	})
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		log.Errorf("Failed to persist maintenance mode to %s: %s.", path, err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		log.Errorf("Failed to persist maintenance mode to %s: %s.", path, err)
+	}
+}
+
+// loadMaintenance restores maintenance mode saved by a previous run
+func (r *cogRelay) loadMaintenance() {
+	path := r.currentConfig().StatePath(maintenanceFile)
+	if path == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) == false {
+			log.Errorf("Failed to load maintenance mode from %s: %s.", path, err)
+		}
+		return
+	}
+	var saved messages.Maintenance
+	if err := json.Unmarshal(data, &saved); err != nil {
+		log.Errorf("Failed to load maintenance mode from %s: %s.", path, err)
+		return
+	}
+	if saved.Enabled == true {
+		r.maintenance.Set(true, saved.Message)
+		log.Warn("Relay is starting in maintenance mode.")
+	}
+}
@@ -0,0 +1,146 @@
+package relay
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/operable/go-relay/relay/bus"
+	"github.com/operable/go-relay/relay/config"
+	"github.com/operable/go-relay/relay/messages"
+	"sync"
+	"time"
+)
+
+// Reply topic of scheduled requests. Workers read the pipeline ID from
+// the fourth segment, as they do for Cog's pipeline reply topics.
+const scheduleReplyTemplate = "/bot/schedules/%s/reply"
+
+// scheduler runs the commands listed in bundles/<name>/schedules and
+// forwards their responses to Cog for delivery to chat
+type scheduler struct {
+	relay   *cogRelay
+	lock    sync.Mutex
+	timers  map[string]*time.Timer
+	stopped bool
+}
+
+func newScheduler(relay *cogRelay) *scheduler {
+	return &scheduler{
+		relay:  relay,
+		timers: make(map[string]*time.Timer),
+	}
+}
+
+// Start arms a timer for every configured schedule. Returns the
+// number of schedules.
+func (s *scheduler) Start() int {
+	now := time.Now()
+	count := 0
+	for bundleName, settings := range s.relay.currentConfig().Bundles {
+		if settings == nil {
+			continue
+		}
+		for _, schedule := range settings.Schedules {
+			s.arm(bundleName, schedule, schedule.Next(now))
+			count++
+		}
+	}
+	return count
+}
+
+// Stop cancels all pending scheduled runs. Runs already queued still
+// execute.
+func (s *scheduler) Stop() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.stopped = true
+	for _, timer := range s.timers {
+		timer.Stop()
+	}
+}
+
+func (s *scheduler) arm(bundleName string, schedule config.Schedule, at time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.stopped == true {
+		return
+	}
+	log.Debugf("Next run of schedule %s at %s.", schedule.Name, at.UTC().Format(time.RFC3339))
+	s.timers[bundleName+"/"+schedule.Name] = time.AfterFunc(at.Sub(time.Now()), func() {
+		s.run(bundleName, schedule)
+		s.arm(bundleName, schedule, schedule.Next(time.Now()))
+	})
+}
+
+func (s *scheduler) run(bundleName string, schedule config.Schedule) {
+	conn := s.relay.conn
+	if conn == nil {
+		log.Warnf("Skipped schedule %s because Relay isn't connected to Cog.", schedule.Name)
+		return
+	}
+	ranAt := time.Now()
+	pipelineID := fmt.Sprintf("schedule-%x", ranAt.UnixNano())
+	request := messages.ExecutionRequest{
+		Options: schedule.Options,
+		Args:    schedule.Args,
+		Command: fmt.Sprintf("%s:%s", bundleName, schedule.Command),
+		ReplyTo: fmt.Sprintf(scheduleReplyTemplate, pipelineID),
+		Requestor: messages.ChatUser{
+			Handle: s.relay.currentConfig().ID,
+		},
+		Room: messages.ChatRoom{
+			Name: schedule.Room,
+		},
+	}
+	if request.Options == nil {
+		request.Options = map[string]interface{}{}
+	}
+	if request.Args == nil {
+		request.Args = []interface{}{}
+	}
+	payload, err := json.Marshal(request)
+	if err != nil {
+		log.Errorf("Failed to build request for schedule %s: %s.", schedule.Name, err)
+		return
+	}
+	log.Infof("Running schedule %s.", schedule.Name)
+	notifier := &scheduleNotifier{
+		relayID:   s.relay.currentConfig().ID,
+		schedule:  schedule,
+		command:   request.Command,
+		ranAt:     ranAt,
+		publisher: conn,
+	}
+	s.relay.enqueue(request.ReplyTo, payload, notifier)
+}
+
+// scheduleNotifier publishes a scheduled command's response to the
+// schedule's result topic in place of a Cog pipeline reply topic
+type scheduleNotifier struct {
+	relayID   string
+	schedule  config.Schedule
+	command   string
+	ranAt     time.Time
+	publisher bus.MessagePublisher
+}
+
+func (sn *scheduleNotifier) Publish(topic string, payload []byte) error {
+	response := &messages.ExecutionResponse{}
+	if err := json.Unmarshal(payload, response); err != nil {
+		log.Errorf("Dropping unreadable response to schedule %s: %s.", sn.schedule.Name, err)
+		return err
+	}
+	raw, _ := json.Marshal(messages.ScheduledResult{
+		RelayID:  sn.relayID,
+		Schedule: sn.schedule.Name,
+		Command:  sn.command,
+		Room:     sn.schedule.Room,
+		RanAt:    sn.ranAt.Unix(),
+		Response: response,
+	})
+	if err := sn.publisher.Publish(sn.schedule.ResultTopic(), raw); err != nil {
+		log.Errorf("Failed to publish result of schedule %s: %s.", sn.schedule.Name, err)
+		return err
+	}
+	return nil
+}
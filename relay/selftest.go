@@ -0,0 +1,99 @@
+package relay
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/operable/circuit-driver/api"
+	"github.com/operable/go-relay/relay/config"
+	"github.com/operable/go-relay/relay/engines"
+	"strings"
+	"time"
+)
+
+const selfTestBundle = "relay-self-test"
+
+var errorSelfTestImage = errors.New("Self-test image is unavailable")
+
+type selfTestOutcome struct {
+	result api.ExecResult
+	err    error
+}
+
+// runSelfTest runs self_test/command through each enabled engine so
+// broken execution plumbing, such as an unreadable Docker socket, is
+// reported at startup instead of on the first command
+func (r *cogRelay) runSelfTest() error {
+	if r.currentConfig().DockerEnabled() == true {
+		testBundle := &config.Bundle{
+			Name:    selfTestBundle,
+			Version: "0.0.0",
+			Docker: &config.DockerImage{
+				Image: r.currentConfig().SelfTest.DockerImage,
+				Tag:   r.currentConfig().SelfTest.DockerTag,
+			},
+		}
+		if err := r.selfTestEngine(engines.DockerEngineType, testBundle); err != nil {
+			return fmt.Errorf("Docker engine self-test failed: %s", err)
+		}
+	}
+	if r.currentConfig().NativeEnabled() == true {
+		testBundle := &config.Bundle{
+			Name:    selfTestBundle,
+			Version: "0.0.0",
+		}
+		if err := r.selfTestEngine(engines.NativeEngineType, testBundle); err != nil {
+			return fmt.Errorf("Native engine self-test failed: %s", err)
+		}
+	}
+	return nil
+}
+
+func (r *cogRelay) selfTestEngine(engineType engines.EngineType, testBundle *config.Bundle) error {
+	engine, err := r.engines.GetEngine(engineType)
+	if err != nil {
+		return err
+	}
+	if testBundle.IsDocker() {
+		avail, err := engine.IsAvailable(testBundle.Docker.Image, testBundle.Docker.Tag)
+		if err != nil {
+			return err
+		}
+		if avail == false {
+			return errorSelfTestImage
+		}
+	}
+	env, err := engine.NewEnvironment(selfTestBundle, testBundle)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// Shut down first so the Docker engine doesn't keep the
+		// container around for reuse
+		env.Shutdown()
+		engine.ReleaseEnvironment(selfTestBundle, testBundle, env)
+	}()
+	request := api.ExecRequest{}
+	request.SetExecutable(r.currentConfig().SelfTest.Command)
+	started := time.Now()
+	outcome := make(chan selfTestOutcome, 1)
+	go func() {
+		result, err := env.Run(request)
+		outcome <- selfTestOutcome{result, err}
+	}()
+	timeout := r.currentConfig().SelfTest.TimeoutDuration()
+	select {
+	case done := <-outcome:
+		if done.err != nil {
+			return done.err
+		}
+		if done.result.GetSuccess() == false {
+			return fmt.Errorf("%s failed: %s", r.currentConfig().SelfTest.Command,
+				strings.TrimSpace(string(done.result.GetStderr())))
+		}
+	case <-time.After(timeout):
+		return fmt.Errorf("%s didn't finish within %v", r.currentConfig().SelfTest.Command, timeout)
+	}
+	log.Infof("Self-test command %s succeeded in %v.", r.currentConfig().SelfTest.Command, time.Now().Sub(started))
+	return nil
+}
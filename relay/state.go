@@ -0,0 +1,108 @@
+package relay
+
+import (
+	"sync"
+	"time"
+)
+
+// RelayState describes where Relay is in its lifecycle. States only
+// ever advance, in the order they're declared. See validTransitions
+// for the transitions Relay allows.
+type RelayState byte
+
+const (
+	// RelayStarting means Relay hasn't received its bundle catalog
+	// from Cog yet
+	RelayStarting RelayState = iota
+	// RelayDegraded means Relay is serving bundles cached by a
+	// previous run because Cog hasn't sent its bundle catalog yet
+	RelayDegraded
+	// RelayReady means Relay is accepting commands
+	RelayReady
+	// RelayDraining means Relay is shutting down and waiting for
+	// in-flight commands to finish
+	RelayDraining
+	// RelayStopped means Relay has shut down
+	RelayStopped
+)
+
+func (rs RelayState) String() string {
+	switch rs {
+	case RelayStarting:
+		return "starting"
+	case RelayDegraded:
+		return "degraded"
+	case RelayReady:
+		return "ready"
+	case RelayDraining:
+		return "draining"
+	case RelayStopped:
+		return "stopped"
+	}
+	return "unknown"
+}
+
+// relayStatus tracks Relay's lifecycle state, bus connection and most
+// recent bundle catalog refresh for health checks
+type relayStatus struct {
+	lock        sync.Mutex
+	states      stateMachine
+	connected   bool
+	lastRefresh time.Time
+	// Set while reconnecting is paused because the connection to
+	// Cog kept dropping
+	flapping bool
+}
+
+// advance moves to state if that's a valid transition. Returns the
+// previous state and true if the state changed.
+func (rs *relayStatus) advance(state RelayState) (RelayState, bool) {
+	previous, err := rs.states.transition(state)
+	return previous, err == nil
+}
+
+func (rs *relayStatus) current() RelayState {
+	return rs.states.current()
+}
+
+func (rs *relayStatus) setConnected(connected bool) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	rs.connected = connected
+}
+
+func (rs *relayStatus) setFlapping(flapping bool) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	rs.flapping = flapping
+}
+
+func (rs *relayStatus) isFlapping() bool {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	return rs.flapping
+}
+
+// refreshed records a bundle catalog refresh and returns the previous
+// state and true if it made Relay ready. Relay is ready once its
+// first catalog arrives unless it's already shutting down.
+func (rs *relayStatus) refreshed(now time.Time) (RelayState, bool) {
+	rs.lock.Lock()
+	rs.lastRefresh = now
+	rs.lock.Unlock()
+	return rs.advance(RelayReady)
+}
+
+// lastRefreshed returns true once a bundle catalog has been loaded
+func (rs *relayStatus) lastRefreshed() bool {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	return rs.lastRefresh.IsZero() == false
+}
+
+func (rs *relayStatus) get() (RelayState, bool, time.Time) {
+	state := rs.states.current()
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	return state, rs.connected, rs.lastRefresh
+}
@@ -0,0 +1,122 @@
+package relay
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Most recent state transitions kept for inspection
+const maxStateHistory = 50
+
+var errorStateUnchanged = errors.New("Relay is already in that state")
+var errorInvalidTransition = errors.New("Invalid Relay state transition")
+
+// validTransitions lists the states each state may move to. States
+// only ever advance, and Relay may start shutting down at any point.
+var validTransitions = map[RelayState][]RelayState{
+	RelayStarting: {RelayDegraded, RelayReady, RelayDraining},
+	RelayDegraded: {RelayReady, RelayDraining},
+	RelayReady:    {RelayDraining},
+	RelayDraining: {RelayStopped},
+	RelayStopped:  {},
+}
+
+// StateTransition records a change in Relay's lifecycle state
+type StateTransition struct {
+	From RelayState `json:"from"`
+	To   RelayState `json:"to"`
+	At   time.Time  `json:"at"`
+}
+
+// MarshalText encodes a RelayState as its name
+func (rs RelayState) MarshalText() ([]byte, error) {
+	return []byte(rs.String()), nil
+}
+
+// stateMachine holds Relay's lifecycle state and enforces the
+// transitions between states. The zero value starts in RelayStarting.
+type stateMachine struct {
+	lock        sync.Mutex
+	state       RelayState
+	history     []StateTransition
+	subscribers []chan StateTransition
+}
+
+// transition moves to state if that's a valid transition from the
+// current state. Returns the previous state. Subscribers which aren't
+// keeping up miss transitions rather than blocking Relay.
+func (sm *stateMachine) transition(state RelayState) (RelayState, error) {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	previous := sm.state
+	if state == previous {
+		return previous, errorStateUnchanged
+	}
+	if canTransition(previous, state) == false {
+		return previous, errorInvalidTransition
+	}
+	sm.state = state
+	change := StateTransition{
+		From: previous,
+		To:   state,
+		At:   time.Now().UTC(),
+	}
+	sm.history = append(sm.history, change)
+	if len(sm.history) > maxStateHistory {
+		sm.history = sm.history[len(sm.history)-maxStateHistory:]
+	}
+	for _, subscriber := range sm.subscribers {
+		select {
+		case subscriber <- change:
+		default:
+		}
+	}
+	return previous, nil
+}
+
+func (sm *stateMachine) current() RelayState {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	return sm.state
+}
+
+// transitions returns recorded transitions, oldest first
+func (sm *stateMachine) transitions() []StateTransition {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	return append([]StateTransition{}, sm.history...)
+}
+
+// subscribe returns a channel receiving each future transition and a
+// function which stops delivery and closes the channel
+func (sm *stateMachine) subscribe() (<-chan StateTransition, func()) {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	subscriber := make(chan StateTransition, len(validTransitions))
+	sm.subscribers = append(sm.subscribers, subscriber)
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			sm.lock.Lock()
+			defer sm.lock.Unlock()
+			for i, existing := range sm.subscribers {
+				if existing == subscriber {
+					sm.subscribers = append(sm.subscribers[:i], sm.subscribers[i+1:]...)
+					break
+				}
+			}
+			close(subscriber)
+		})
+	}
+	return subscriber, cancel
+}
+
+func canTransition(from RelayState, to RelayState) bool {
+	for _, allowed := range validTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
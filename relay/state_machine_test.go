@@ -0,0 +1,65 @@
+package relay
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStateMachineTransitions(t *testing.T) {
+	var machine stateMachine
+	if machine.current() != RelayStarting {
+		t.Fatalf("Expected to start in %s: %s", RelayStarting, machine.current())
+	}
+	if _, err := machine.transition(RelayStopped); err != errorInvalidTransition {
+		t.Errorf("Expected errorInvalidTransition: %v", err)
+	}
+	for _, state := range []RelayState{RelayDegraded, RelayReady, RelayDraining, RelayStopped} {
+		if _, err := machine.transition(state); err != nil {
+			t.Fatalf("Unexpected error moving to %s: %s", state, err)
+		}
+	}
+	if _, err := machine.transition(RelayStopped); err != errorStateUnchanged {
+		t.Errorf("Expected errorStateUnchanged: %v", err)
+	}
+	if _, err := machine.transition(RelayReady); err != errorInvalidTransition {
+		t.Errorf("Expected errorInvalidTransition: %v", err)
+	}
+	history := machine.transitions()
+	if len(history) != 4 || history[0].From != RelayStarting || history[3].To != RelayStopped {
+		t.Errorf("Unexpected transition history: %v", history)
+	}
+}
+
+func TestStateMachineSubscribe(t *testing.T) {
+	var machine stateMachine
+	changes, cancel := machine.subscribe()
+	machine.transition(RelayReady)
+	machine.transition(RelayDraining)
+	for _, expected := range []RelayState{RelayReady, RelayDraining} {
+		change := <-changes
+		if change.To != expected {
+			t.Errorf("Expected transition to %s: %+v", expected, change)
+		}
+	}
+	cancel()
+	cancel()
+	if _, open := <-changes; open == true {
+		t.Error("Expected subscription to be closed")
+	}
+	machine.transition(RelayStopped)
+}
+
+func TestRelayStatusRefreshed(t *testing.T) {
+	var status relayStatus
+	status.advance(RelayDegraded)
+	if previous, ready := status.refreshed(time.Now()); ready == false || previous != RelayDegraded {
+		t.Errorf("Expected refresh to make Relay ready: %s %v", previous, ready)
+	}
+	status.advance(RelayDraining)
+	if _, ready := status.refreshed(time.Now()); ready == true {
+		t.Error("Refresh while draining made Relay ready")
+	}
+	if status.lastRefreshed() == false {
+		t.Error("Expected refresh to be recorded")
+	}
+}
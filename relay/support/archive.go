@@ -0,0 +1,89 @@
+package support
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"time"
+)
+
+// Archive is a gzipped tarball of diagnostic files
+type Archive struct {
+	gz      *gzip.Writer
+	tw      *tar.Writer
+	prefix  string
+	created time.Time
+}
+
+// NewArchive writes a new archive to w. All files are stored
+// beneath the directory prefix.
+func NewArchive(w io.Writer, prefix string) *Archive {
+	gz := gzip.NewWriter(w)
+	return &Archive{
+		gz:      gz,
+		tw:      tar.NewWriter(gz),
+		prefix:  prefix,
+		created: time.Now(),
+	}
+}
+
+// AddFile adds a file containing data to the archive
+func (a *Archive) AddFile(name string, data []byte) error {
+	header := &tar.Header{
+		Name:    path.Join(a.prefix, name),
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: a.created,
+	}
+	if err := a.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := a.tw.Write(data)
+	return err
+}
+
+// AddJSON adds a file containing value encoded as indented JSON
+func (a *Archive) AddJSON(name string, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	return a.AddFile(name, data)
+}
+
+// Close flushes and finalizes the archive
+func (a *Archive) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	return a.gz.Close()
+}
+
+// TailFile returns at most the last maxBytes bytes of the named file
+func TailFile(name string, maxBytes int64) ([]byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset := info.Size() - maxBytes
+	if offset < 0 {
+		offset = 0
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data := make([]byte, info.Size()-offset)
+	n, err := io.ReadFull(f, data)
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return data[:n], err
+}
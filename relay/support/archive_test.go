@@ -0,0 +1,61 @@
+package support
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestArchive(t *testing.T) {
+	var buf bytes.Buffer
+	archive := NewArchive(&buf, "relay-support")
+	if err := archive.AddFile("relay.log", []byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := archive.AddJSON("version.json", map[string]string{"tag": "1.0"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	gz, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	names := []string{}
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			break
+		}
+		names = append(names, header.Name)
+	}
+	if len(names) != 2 || names[0] != "relay-support/relay.log" || names[1] != "relay-support/version.json" {
+		t.Errorf("Unexpected archive contents: %v", names)
+	}
+}
+
+func TestTailFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "relay_log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("0123456789")
+	f.Close()
+	data, err := TailFile(f.Name(), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "6789" {
+		t.Errorf("Unexpected tail: %s", data)
+	}
+	data, _ = TailFile(f.Name(), 100)
+	if string(data) != "0123456789" {
+		t.Errorf("Unexpected tail of short file: %s", data)
+	}
+}
@@ -0,0 +1,145 @@
+package relay
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/operable/go-relay/relay/events"
+	"github.com/operable/go-relay/relay/support"
+	"github.com/operable/go-relay/relay/worker"
+	"io"
+	"runtime"
+	"sort"
+	"time"
+)
+
+// Most recent Relay log output included in support bundles
+const supportLogBytes = 1024 * 1024
+
+type bundleSummary struct {
+	Name          string `json:"name"`
+	Version       string `json:"version"`
+	Image         string `json:"image,omitempty"`
+	Available     bool   `json:"available"`
+	PullFailures  int    `json:"pull_failures,omitempty"`
+	LastPullError string `json:"last_pull_error,omitempty"`
+}
+
+// writeSupportBundle packages diagnostic information about the running
+// Relay into a gzipped tarball
+func (r *cogRelay) writeSupportBundle(w io.Writer) error {
+	now := time.Now().UTC()
+	archive := support.NewArchive(w, fmt.Sprintf("relay-support-%s", now.Format("20060102T150405Z")))
+	sections := map[string]interface{}{
+		"version.json": r.currentConfig().Build,
+		"config.json":  r.currentConfig().Sanitized(),
+		"state.json":   r.stateDump(),
+		"runtime.json": runtimeSnapshot(),
+		"bundles.json": r.bundleSummaries(),
+		"engines.json": r.engines.Health(),
+		"workers.json": r.workerStatus(),
+		"events.json":  r.events.Query(events.Query{}),
+	}
+	names := []string{}
+	for name := range sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := archive.AddJSON(name, sections[name]); err != nil {
+			return err
+		}
+	}
+	if err := archive.AddFile("relay.log", r.recentLogs()); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+func (r *cogRelay) stateDump() map[string]interface{} {
+	readOnly, readOnlyMessage := r.readOnly.Status()
+	state := map[string]interface{}{
+		"id":                r.currentConfig().ID,
+		"connected":         r.conn != nil,
+		"read_only":         readOnly,
+		"read_only_message": readOnlyMessage,
+		"queued_requests":   len(r.queue) + len(r.backgroundQueue),
+		"max_concurrent":    r.currentConfig().MaxConcurrent,
+		"concurrency":       r.limiter.Status(),
+		"catalog_epoch":     r.catalog.CurrentEpoch(),
+		"catalog_changed":   r.catalog.IsChanged(),
+	}
+	if r.workers != nil {
+		alive := 0
+		for _, status := range r.workers.Status() {
+			if status.Alive == true {
+				alive++
+			}
+		}
+		state["workers_alive"] = alive
+	}
+	if r.facts != nil {
+		state["facts"] = r.facts.Current()
+	}
+	if r.flapGuard != nil {
+		state["flapping_bundles"] = r.flapGuard.Flapping()
+	}
+	return state
+}
+
+func (r *cogRelay) workerStatus() []worker.WorkerStatus {
+	if r.workers == nil {
+		return []worker.WorkerStatus{}
+	}
+	return r.workers.Status()
+}
+
+func runtimeSnapshot() map[string]interface{} {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return map[string]interface{}{
+		"go_version":     runtime.Version(),
+		"goroutines":     runtime.NumGoroutine(),
+		"heap_alloc":     mem.HeapAlloc,
+		"heap_objects":   mem.HeapObjects,
+		"total_alloc":    mem.TotalAlloc,
+		"sys":            mem.Sys,
+		"gc_runs":        mem.NumGC,
+		"gc_pause_total": time.Duration(mem.PauseTotalNs).String(),
+	}
+}
+
+func (r *cogRelay) bundleSummaries() []bundleSummary {
+	retval := []bundleSummary{}
+	names := r.catalog.BundleNames()
+	sort.Strings(names)
+	for _, name := range names {
+		bundle := r.catalog.Find(name)
+		if bundle == nil {
+			continue
+		}
+		summary := bundleSummary{
+			Name:      bundle.Name,
+			Version:   bundle.Version,
+			Available: bundle.IsAvailable(),
+		}
+		summary.PullFailures, summary.LastPullError = bundle.PullFailures()
+		if bundle.IsDocker() {
+			summary.Image = fmt.Sprintf("%s:%s", bundle.Docker.Image, bundle.Docker.Tag)
+		}
+		retval = append(retval, summary)
+	}
+	return retval
+}
+
+func (r *cogRelay) recentLogs() []byte {
+	switch r.currentConfig().LogPath {
+	case "stdout", "stderr", "console":
+		return []byte(fmt.Sprintf("Relay is logging to %s. Logs are not included.\n", r.currentConfig().LogPath))
+	}
+	logs, err := support.TailFile(r.currentConfig().LogPath, supportLogBytes)
+	if err != nil {
+		log.Errorf("Failed to read Relay log %s for support bundle: %s.", r.currentConfig().LogPath, err)
+		return []byte(fmt.Sprintf("Error reading %s: %s\n", r.currentConfig().LogPath, err))
+	}
+	return logs
+}
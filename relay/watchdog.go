@@ -0,0 +1,154 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/operable/go-relay/relay/config"
+	"github.com/operable/go-relay/relay/events"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrRestartRequired is sent on Failed when the watchdog finds Relay
+// has stayed over its resource limits. Relay should be stopped and
+// started again in a fresh process.
+var ErrRestartRequired = errors.New("Relay exceeded its resource limits and needs to restart")
+
+// How long to wait for the watchdog webhook to respond
+const watchdogWebhookTimeout = 10 * time.Second
+
+// How often execution workers are checked for stuck commands
+const stuckCheckInterval = 10 * time.Second
+
+// resourceUsage is a measurement of Relay's own resource use
+type resourceUsage struct {
+	MemoryBytes int64
+	Goroutines  int
+}
+
+// watchdogAlert is posted to watchdog/webhook before Relay restarts
+type watchdogAlert struct {
+	RelayID     string `json:"relay_id"`
+	Reason      string `json:"reason"`
+	MemoryBytes int64  `json:"memory_bytes"`
+	Goroutines  int    `json:"goroutines"`
+	Detected    int64  `json:"detected_at"`
+}
+
+// currentUsage measures Relay's resident memory and goroutine count.
+// Memory obtained from the OS by the Go runtime stands in for
+// resident memory where /proc isn't available.
+func currentUsage() resourceUsage {
+	usage := resourceUsage{
+		Goroutines: runtime.NumGoroutine(),
+	}
+	if statm, err := ioutil.ReadFile("/proc/self/statm"); err == nil {
+		fields := strings.Fields(string(statm))
+		if len(fields) > 1 {
+			if pages, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+				usage.MemoryBytes = pages * int64(os.Getpagesize())
+				return usage
+			}
+		}
+	}
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	usage.MemoryBytes = int64(stats.Sys)
+	return usage
+}
+
+// watchdog counts consecutive checks finding Relay over its limits
+type watchdog struct {
+	config   config.WatchdogInfo
+	breaches int
+}
+
+func newWatchdog(config config.WatchdogInfo) *watchdog {
+	return &watchdog{
+		config: config,
+	}
+}
+
+// check records usage and returns why Relay is over its limits and
+// true once it has been over them for the configured number of
+// consecutive checks
+func (w *watchdog) check(usage resourceUsage) (string, bool) {
+	reasons := []string{}
+	if limit := int64(w.config.MaxMemoryMB) * 1024 * 1024; limit > 0 && usage.MemoryBytes > limit {
+		reasons = append(reasons, fmt.Sprintf("is using %d MB of memory, over the %d MB limit",
+			usage.MemoryBytes/(1024*1024), w.config.MaxMemoryMB))
+	}
+	if w.config.MaxGoroutines > 0 && usage.Goroutines > w.config.MaxGoroutines {
+		reasons = append(reasons, fmt.Sprintf("is running %d goroutines, over the limit of %d",
+			usage.Goroutines, w.config.MaxGoroutines))
+	}
+	if len(reasons) == 0 {
+		w.breaches = 0
+		return "", false
+	}
+	w.breaches++
+	return strings.Join(reasons, " and "), w.breaches >= w.config.Checks
+}
+
+// scheduledWatchdogCheck checks Relay's resource use and asks for a
+// restart once it has stayed over its limits
+func (r *cogRelay) scheduledWatchdogCheck() {
+	if r.stopped() {
+		return
+	}
+	usage := currentUsage()
+	reason, tripped := r.watchdog.check(usage)
+	if tripped == false {
+		if reason != "" {
+			log.Warnf("Relay %s.", reason)
+		}
+		r.watchdogTimer.Reset(r.currentConfig().Watchdog.IntervalDuration())
+		return
+	}
+	log.Errorf("Relay %s for %d consecutive checks. Draining and restarting.", reason, r.currentConfig().Watchdog.Checks)
+	r.events.Record(events.WatchdogKind, "Relay %s. Restarting.", reason)
+	if r.currentConfig().Watchdog.Webhook != "" {
+		r.postWatchdogAlert(reason, usage)
+	}
+	r.fail(ErrRestartRequired)
+}
+
+func (r *cogRelay) postWatchdogAlert(reason string, usage resourceUsage) {
+	body, _ := json.Marshal(watchdogAlert{
+		RelayID:     r.currentConfig().ID,
+		Reason:      reason,
+		MemoryBytes: usage.MemoryBytes,
+		Goroutines:  usage.Goroutines,
+		Detected:    time.Now().Unix(),
+	})
+	client := &http.Client{Timeout: watchdogWebhookTimeout}
+	resp, err := client.Post(r.currentConfig().Watchdog.Webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Errorf("Failed to post watchdog alert: %s.", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Errorf("Watchdog webhook returned %s.", resp.Status)
+	}
+}
+
+// scheduledStuckCheck recycles execution workers stuck running a
+// command
+func (r *cogRelay) scheduledStuckCheck() {
+	if r.stopped() {
+		return
+	}
+	if recycled := r.workers.RecycleStuck(time.Now()); recycled > 0 {
+		r.events.Record(events.ExecutionErrorKind, "Recycled %d stuck execution workers.", recycled)
+	}
+	r.stuckTimer.Reset(stuckCheckInterval)
+}
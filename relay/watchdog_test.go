@@ -0,0 +1,33 @@
+package relay
+
+import (
+	"github.com/operable/go-relay/relay/config"
+	"testing"
+)
+
+func TestWatchdogCheck(t *testing.T) {
+	dog := newWatchdog(config.WatchdogInfo{
+		MaxMemoryMB:   100,
+		MaxGoroutines: 50,
+		Checks:        2,
+	})
+	over := resourceUsage{MemoryBytes: 200 * 1024 * 1024, Goroutines: 10}
+	if _, tripped := dog.check(over); tripped == true {
+		t.Error("Watchdog tripped on the first check")
+	}
+	if _, tripped := dog.check(resourceUsage{Goroutines: 10}); tripped == true {
+		t.Error("Watchdog tripped while under its limits")
+	}
+	dog.check(over)
+	reason, tripped := dog.check(resourceUsage{MemoryBytes: 10, Goroutines: 60})
+	if tripped == false || reason == "" {
+		t.Errorf("Expected watchdog to trip after consecutive breaches: %s", reason)
+	}
+}
+
+func TestCurrentUsage(t *testing.T) {
+	usage := currentUsage()
+	if usage.MemoryBytes <= 0 || usage.Goroutines <= 0 {
+		t.Errorf("Unexpected usage: %+v", usage)
+	}
+}
@@ -0,0 +1,133 @@
+package worker
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// Autoscaler tuning. The pool grows as soon as requests queue up but
+// only shrinks after staying underused for scaleDownChecks checks in
+// a row, so bursts don't make it thrash.
+const (
+	scaleInterval   = time.Second
+	scaleDownChecks = 30
+)
+
+// Autoscaler grows a Supervisor's worker pool when requests queue up
+// and shrinks it again once workers sit idle, keeping between min and
+// max workers. The pool doesn't grow while execution latency shows
+// the host is already saturated.
+type Autoscaler struct {
+	supervisor *Supervisor
+	limiter    *ConcurrencyLimiter
+	queue      chan interface{}
+	lock       sync.Mutex
+	min        int
+	max        int
+	idleChecks int
+	quit       chan struct{}
+	stopOnce   sync.Once
+}
+
+// NewAutoscaler constructs an Autoscaler for supervisor's workers,
+// which read requests from queue
+func NewAutoscaler(supervisor *Supervisor, limiter *ConcurrencyLimiter, queue chan interface{}, min int, max int) *Autoscaler {
+	if min < 1 {
+		min = 1
+	}
+	if min > max {
+		min = max
+	}
+	return &Autoscaler{
+		supervisor: supervisor,
+		limiter:    limiter,
+		queue:      queue,
+		min:        min,
+		max:        max,
+		quit:       make(chan struct{}),
+	}
+}
+
+// Run starts the minimum number of workers and scales the pool until
+// Stop is called
+func (a *Autoscaler) Run() {
+	a.supervisor.Start(a.min)
+	go func() {
+		ticker := time.NewTicker(scaleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-a.quit:
+				return
+			case <-ticker.C:
+				a.check()
+			}
+		}
+	}()
+}
+
+// Stop stops scaling the pool. Running workers are left alone.
+func (a *Autoscaler) Stop() {
+	a.stopOnce.Do(func() {
+		close(a.quit)
+	})
+}
+
+// SetMax changes the largest pool size. Surplus workers are retired
+// as they become idle.
+func (a *Autoscaler) SetMax(max int) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.max = max
+	if a.min > max {
+		a.min = max
+	}
+}
+
+// Bounds returns the smallest and largest pool sizes
+func (a *Autoscaler) Bounds() (int, int) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.min, a.max
+}
+
+func (a *Autoscaler) check() {
+	workers, busy := a.supervisor.Load()
+	target := a.target(workers, busy, len(a.queue)+len(a.supervisor.bgQueue), a.limiter.Saturated())
+	if target == workers {
+		return
+	}
+	if resized := a.supervisor.Resize(target); resized != workers {
+		log.Debugf("Scaled execution workers from %d to %d.", workers, resized)
+	}
+}
+
+// target returns the pool size suited to the current load
+func (a *Autoscaler) target(workers int, busy int, queued int, saturated bool) int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	target := workers
+	if queued > 0 {
+		a.idleChecks = 0
+		if saturated == false {
+			target = workers + queued
+		}
+	} else if busy < workers {
+		a.idleChecks++
+		if a.idleChecks >= scaleDownChecks {
+			a.idleChecks = 0
+			// Retire half of the idle workers at a time
+			target = workers - (workers-busy+1)/2
+		}
+	} else {
+		a.idleChecks = 0
+	}
+	if target < a.min {
+		target = a.min
+	}
+	if target > a.max {
+		target = a.max
+	}
+	return target
+}
@@ -0,0 +1,67 @@
+package worker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAutoscalerGrowsWithQueue(t *testing.T) {
+	scaler := NewAutoscaler(nil, nil, nil, 2, 8)
+	if target := scaler.target(2, 2, 3, false); target != 5 {
+		t.Errorf("Expected pool to grow by the queue depth: %d", target)
+	}
+	if target := scaler.target(6, 6, 10, false); target != 8 {
+		t.Errorf("Expected pool to stop at max: %d", target)
+	}
+	if target := scaler.target(4, 4, 3, true); target != 4 {
+		t.Errorf("Expected saturated pool not to grow: %d", target)
+	}
+}
+
+func TestAutoscalerShrinksWhenIdle(t *testing.T) {
+	scaler := NewAutoscaler(nil, nil, nil, 2, 8)
+	for i := 1; i < scaleDownChecks; i++ {
+		if target := scaler.target(8, 1, 0, false); target != 8 {
+			t.Fatalf("Expected pool to wait before shrinking: %d", target)
+		}
+	}
+	if target := scaler.target(8, 1, 0, false); target != 4 {
+		t.Errorf("Expected half the idle workers to be retired: %d", target)
+	}
+	for i := 1; i < scaleDownChecks; i++ {
+		scaler.target(3, 0, 0, false)
+	}
+	if target := scaler.target(3, 0, 0, false); target != 2 {
+		t.Errorf("Expected pool to stop at min: %d", target)
+	}
+}
+
+func TestSupervisorRetiresIdleWorkers(t *testing.T) {
+	queue := make(chan interface{})
+	supervisor := NewSupervisor(queue)
+	supervisor.Start(3)
+	deadline := time.Now().Add(time.Second)
+	retired := 0
+	for retired < 2 && time.Now().Before(deadline) {
+		// Workers may not be waiting for requests yet
+		retired += supervisor.Retire(2 - retired)
+	}
+	if retired != 2 {
+		t.Fatalf("Expected two workers to be retired: %d", retired)
+	}
+	// Retired workers stop counting as alive right away
+	if alive, _ := supervisor.Load(); alive != 1 {
+		t.Errorf("Expected one live worker: %d", alive)
+	}
+	for time.Now().Before(deadline) && len(supervisor.Status()) != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	if statuses := supervisor.Status(); len(statuses) != 1 {
+		t.Fatalf("Expected retired workers to be forgotten: %+v", statuses)
+	}
+	supervisor.Start(1)
+	if statuses := supervisor.Status(); statuses[len(statuses)-1].ID != 4 {
+		t.Errorf("Expected new workers to get unused IDs: %+v", statuses)
+	}
+	supervisor.Stop(time.Second)
+}
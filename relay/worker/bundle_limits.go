@@ -0,0 +1,121 @@
+package worker
+
+import (
+	"golang.org/x/net/context"
+	"sync"
+)
+
+// BundleLimiter caps how many commands of each bundle run at once.
+// Requests over a bundle's limit are held and put back on the queue,
+// in arrival order, as running commands of the bundle finish. A nil
+// BundleLimiter imposes no limits.
+type BundleLimiter struct {
+	lock     sync.Mutex
+	limitFor func(bundle string) int
+	requeue  func(thing interface{})
+	running  map[string]int
+	held     map[string][]interface{}
+	released chan struct{}
+}
+
+// NewBundleLimiter constructs a BundleLimiter. limitFor returns a
+// bundle's limit, zero meaning unlimited, and requeue puts a held
+// request back on the queue.
+func NewBundleLimiter(limitFor func(bundle string) int, requeue func(thing interface{})) *BundleLimiter {
+	return &BundleLimiter{
+		limitFor: limitFor,
+		requeue:  requeue,
+		running:  make(map[string]int),
+		held:     make(map[string][]interface{}),
+		released: make(chan struct{}),
+	}
+}
+
+// Acquire returns true if a request for bundle may run now, in which
+// case Release must be called once it finishes. Otherwise thing is
+// held until a running command of the bundle finishes.
+func (bl *BundleLimiter) Acquire(bundle string, thing interface{}) bool {
+	if bl == nil {
+		return true
+	}
+	limit := bl.limitFor(bundle)
+	bl.lock.Lock()
+	defer bl.lock.Unlock()
+	if limit > 0 && bl.running[bundle] >= limit {
+		bl.held[bundle] = append(bl.held[bundle], thing)
+		return false
+	}
+	bl.running[bundle]++
+	return true
+}
+
+// Wait blocks until a command of bundle may run, in which case Release
+// must be called once it finishes. Used for chained stages, which
+// can't be put back on the queue. Returns ctx's error if ctx is done
+// first.
+func (bl *BundleLimiter) Wait(ctx context.Context, bundle string) error {
+	if bl == nil {
+		return nil
+	}
+	limit := bl.limitFor(bundle)
+	bl.lock.Lock()
+	defer bl.lock.Unlock()
+	for limit > 0 && bl.running[bundle] >= limit {
+		released := bl.released
+		bl.lock.Unlock()
+		select {
+		case <-released:
+			bl.lock.Lock()
+		case <-ctx.Done():
+			bl.lock.Lock()
+			return ctx.Err()
+		}
+	}
+	bl.running[bundle]++
+	return nil
+}
+
+// Release records that a command of bundle finished and requeues the
+// oldest request held for the bundle
+func (bl *BundleLimiter) Release(bundle string) {
+	if bl == nil {
+		return
+	}
+	bl.lock.Lock()
+	bl.running[bundle]--
+	if bl.running[bundle] <= 0 {
+		delete(bl.running, bundle)
+	}
+	close(bl.released)
+	bl.released = make(chan struct{})
+	var next interface{}
+	if held := bl.held[bundle]; len(held) > 0 {
+		next = held[0]
+		if len(held) == 1 {
+			delete(bl.held, bundle)
+		} else {
+			bl.held[bundle] = held[1:]
+		}
+	}
+	bl.lock.Unlock()
+	if next != nil {
+		// The queue may be full and the releasing worker is one of
+		// the goroutines which drain it
+		go bl.requeue(next)
+	}
+}
+
+// Held returns the number of requests waiting for their bundle's
+// running commands to finish
+func (bl *BundleLimiter) Held() int {
+	if bl == nil {
+		return 0
+	}
+	bl.lock.Lock()
+	defer bl.lock.Unlock()
+	count := 0
+	for _, held := range bl.held {
+		count += len(held)
+	}
+	return count
+}
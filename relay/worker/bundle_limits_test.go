@@ -0,0 +1,83 @@
+package worker
+
+import (
+	"golang.org/x/net/context"
+	"testing"
+	"time"
+)
+
+func TestBundleLimiter(t *testing.T) {
+	requeued := make(chan interface{}, 2)
+	limiter := NewBundleLimiter(func(bundle string) int {
+		if bundle == "deploy" {
+			return 1
+		}
+		return 0
+	}, func(thing interface{}) {
+		requeued <- thing
+	})
+	if limiter.Acquire("deploy", "first") == false {
+		t.Fatal("Expected first deploy to run")
+	}
+	if limiter.Acquire("deploy", "second") == true || limiter.Acquire("deploy", "third") == true {
+		t.Fatal("Expected deploys over the limit to be held")
+	}
+	for i := 0; i < 3; i++ {
+		if limiter.Acquire("echo", "echo") == false {
+			t.Error("Expected unlimited bundle to run")
+		}
+	}
+	if limiter.Held() != 2 {
+		t.Errorf("Expected 2 held requests: %d", limiter.Held())
+	}
+	limiter.Release("deploy")
+	select {
+	case thing := <-requeued:
+		if thing != "second" {
+			t.Errorf("Expected oldest held request to be requeued: %v", thing)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Held request was never requeued")
+	}
+	if limiter.Acquire("deploy", "second") == false {
+		t.Error("Expected requeued deploy to run")
+	}
+	if limiter.Held() != 1 {
+		t.Errorf("Expected 1 held request: %d", limiter.Held())
+	}
+}
+
+func TestBundleLimiterWait(t *testing.T) {
+	limiter := NewBundleLimiter(func(bundle string) int {
+		return 1
+	}, func(thing interface{}) {})
+	if err := limiter.Wait(context.Background(), "deploy"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := limiter.Wait(ctx, "deploy"); err != context.DeadlineExceeded {
+		t.Fatalf("Expected wait over the limit to time out: %v", err)
+	}
+	waited := make(chan error)
+	go func() {
+		waited <- limiter.Wait(context.Background(), "deploy")
+	}()
+	limiter.Release("deploy")
+	select {
+	case err := <-waited:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected waiting stage to run once the bundle was released")
+	}
+}
+
+func TestNilBundleLimiter(t *testing.T) {
+	var limiter *BundleLimiter
+	if limiter.Acquire("deploy", "first") == false {
+		t.Error("Expected nil limiter to allow everything")
+	}
+	limiter.Release("deploy")
+}
@@ -0,0 +1,152 @@
+package worker
+
+import (
+	"github.com/operable/go-relay/relay/messages"
+	"github.com/operable/go-relay/relay/metrics"
+	"golang.org/x/net/context"
+	"sync"
+	"time"
+)
+
+// DefaultCancelReason is returned for commands cancelled without a
+// reason
+const DefaultCancelReason = "Command cancelled by Cog"
+
+// Cancellations of commands which haven't started yet are remembered
+// this long so they're rejected once a worker picks them up
+const pendingCancelTTL = 10 * time.Minute
+
+var cancelledCommands = metrics.NewCounter("relay_commands_cancelled_total",
+	"Commands stopped or rejected because Cog cancelled them.")
+
+type cancellationKey struct{}
+
+// trackedCommand is a command which Cog may cancel
+type trackedCommand struct {
+	correlationID string
+	pipelineID    string
+	cancel        context.CancelFunc
+	reason        string
+	cancelled     bool
+}
+
+// Cancellations tracks queued and running commands so Cog can cancel
+// them by correlation or pipeline ID. A nil Cancellations ignores
+// cancellations.
+type Cancellations struct {
+	lock    sync.Mutex
+	running map[string]*trackedCommand
+	// Cancelled correlation and pipeline IDs with no running command
+	pending map[string]pendingCancel
+}
+
+type pendingCancel struct {
+	reason string
+	at     time.Time
+}
+
+// NewCancellations constructs a Cancellations
+func NewCancellations() *Cancellations {
+	return &Cancellations{
+		running: make(map[string]*trackedCommand),
+		pending: make(map[string]pendingCancel),
+	}
+}
+
+// track returns a context for running request which is cancelled if
+// Cog cancels the request. untrack must be called once the command
+// finishes. Requests cancelled before they started get a context which
+// is already cancelled.
+func (c *Cancellations) track(parent context.Context, request *messages.ExecutionRequest) (context.Context, func()) {
+	if c == nil {
+		return parent, func() {}
+	}
+	command := &trackedCommand{
+		correlationID: request.CorrelationID,
+		pipelineID:    request.PipelineID(),
+	}
+	ctx, cancel := context.WithCancel(context.WithValue(parent, cancellationKey{}, command))
+	command.cancel = cancel
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, key := range []string{command.correlationID, command.pipelineID} {
+		if pending, ok := c.pending[key]; ok && key != "" {
+			command.reason = pending.reason
+			command.cancelled = true
+			cancel()
+		}
+	}
+	c.running[command.correlationID] = command
+	return ctx, func() {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		if c.running[command.correlationID] == command {
+			delete(c.running, command.correlationID)
+		}
+		cancel()
+	}
+}
+
+// Cancel stops the running command with correlationID or, if
+// correlationID is empty, every running command of pipelineID.
+// Matching commands which haven't started yet are rejected when a
+// worker picks them up. Returns the number of running commands
+// cancelled.
+func (c *Cancellations) Cancel(correlationID, pipelineID, reason string) int {
+	if c == nil {
+		return 0
+	}
+	if reason == "" {
+		reason = DefaultCancelReason
+	}
+	now := time.Now()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for key, pending := range c.pending {
+		if now.Sub(pending.at) > pendingCancelTTL {
+			delete(c.pending, key)
+		}
+	}
+	key := correlationID
+	if key == "" {
+		key = pipelineID
+	}
+	if key == "" {
+		return 0
+	}
+	c.pending[key] = pendingCancel{reason: reason, at: now}
+	cancelled := 0
+	for _, command := range c.running {
+		if (correlationID != "" && command.correlationID == correlationID) ||
+			(correlationID == "" && command.pipelineID == pipelineID) {
+			if command.cancelled == false {
+				command.reason = reason
+				command.cancelled = true
+				command.cancel()
+				cancelled++
+			}
+		}
+	}
+	return cancelled
+}
+
+// cancellation returns the reason the command running under ctx was
+// cancelled and true, or false if Cog didn't cancel it
+func (c *Cancellations) cancellation(ctx context.Context) (string, bool) {
+	command, ok := ctx.Value(cancellationKey{}).(*trackedCommand)
+	if c == nil || ok == false {
+		return "", false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return command.reason, command.cancelled
+}
+
+// cancelledResponse returns the response sent for a cancelled command
+func cancelledResponse(reason string) *messages.ExecutionResponse {
+	cancelledCommands.Inc()
+	return &messages.ExecutionResponse{
+		Status:        "cancelled",
+		StatusMessage: reason,
+	}
+}
@@ -0,0 +1,84 @@
+package worker
+
+import (
+	"github.com/operable/go-relay/relay/config"
+	"github.com/operable/go-relay/relay/messages"
+	"golang.org/x/net/context"
+	"testing"
+)
+
+func cancellableRequest(correlationID, pipelineID string) *messages.ExecutionRequest {
+	request := &messages.ExecutionRequest{
+		Command:       "operable:echo",
+		ReplyTo:       "/bot/pipelines/" + pipelineID + "/reply",
+		CorrelationID: correlationID,
+	}
+	request.Parse()
+	return request
+}
+
+func TestCancellations(t *testing.T) {
+	cancellations := NewCancellations()
+	first, untrackFirst := cancellations.track(context.Background(), cancellableRequest("1", "abc"))
+	second, untrackSecond := cancellations.track(context.Background(), cancellableRequest("2", "abc"))
+	other, untrackOther := cancellations.track(context.Background(), cancellableRequest("3", "def"))
+	defer untrackFirst()
+	defer untrackSecond()
+	defer untrackOther()
+	if cancelled := cancellations.Cancel("", "abc", ""); cancelled != 2 {
+		t.Errorf("Expected 2 commands to be cancelled: %d", cancelled)
+	}
+	for _, ctx := range []context.Context{first, second} {
+		if ctx.Err() != context.Canceled {
+			t.Error("Expected pipeline's commands to be cancelled")
+		}
+		if reason, cancelled := cancellations.cancellation(ctx); cancelled == false || reason != DefaultCancelReason {
+			t.Errorf("Unexpected cancellation: %v %s", cancelled, reason)
+		}
+	}
+	if other.Err() != nil {
+		t.Error("Expected other pipeline's command to keep running")
+	}
+	if _, cancelled := cancellations.cancellation(other); cancelled == true {
+		t.Error("Expected other pipeline's command not to be cancelled")
+	}
+	// Commands of cancelled pipelines which start later are cancelled
+	late, untrackLate := cancellations.track(context.Background(), cancellableRequest("4", "abc"))
+	defer untrackLate()
+	if late.Err() != context.Canceled {
+		t.Error("Expected late command of cancelled pipeline to be cancelled")
+	}
+	if cancellations.Cancel("5", "", "Aborted") != 0 {
+		t.Error("Expected no running command with correlation ID 5")
+	}
+	early, untrackEarly := cancellations.track(context.Background(), cancellableRequest("5", "ghi"))
+	defer untrackEarly()
+	if reason, cancelled := cancellations.cancellation(early); cancelled == false || reason != "Aborted" {
+		t.Errorf("Expected command cancelled before starting to be cancelled: %v %s", cancelled, reason)
+	}
+}
+
+func TestCancelledRequestsAreRejected(t *testing.T) {
+	recorder := &responseRecorder{}
+	cancellations := NewCancellations()
+	invoke := &CommandInvocation{
+		RelayConfig: &config.Config{QueueTTL: "0s"},
+		Publisher:   recorder,
+		Cancels:     cancellations,
+	}
+	request := cancellableRequest("from-cog", "abc")
+	cancellations.Cancel("", "abc", "Aborted by user")
+	ctx, untrack := cancellations.track(context.Background(), request)
+	defer untrack()
+	if err := executeCommand(ctx, request, messages.EncodingJSON, invoke); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorder.responses) != 1 {
+		t.Fatalf("Expected one response: %v", recorder.topics)
+	}
+	response := recorder.responses[0]
+	if response.Status != "cancelled" || response.StatusMessage != "Aborted by user" ||
+		response.CorrelationID != "from-cog" {
+		t.Errorf("Unexpected response: %+v", response)
+	}
+}
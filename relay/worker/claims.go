@@ -0,0 +1,121 @@
+package worker
+
+import (
+	"encoding/json"
+	log "github.com/Sirupsen/logrus"
+	"github.com/operable/go-relay/relay/bus"
+	"github.com/operable/go-relay/relay/messages"
+	"sync"
+	"time"
+)
+
+// ClaimTopic is shared by all Relays coordinating execution claims
+const ClaimTopic = "bot/relays/claims"
+
+// Claims are remembered for this many claim windows so duplicates
+// arriving late are still skipped
+const claimRetention = 20
+
+type claimState struct {
+	claimed     bool
+	competitors []string
+	expires     time.Time
+}
+
+// ClaimCoordinator prevents Relays which receive the same invocation
+// from executing it more than once. Before executing, a Relay
+// publishes a claim and waits for competing claims. A Relay skips the
+// invocation if another Relay claimed it first or if a competing claim
+// made during the window came from a Relay with a lower ID.
+type ClaimCoordinator struct {
+	relayID   string
+	publisher bus.MessagePublisher
+	window    time.Duration
+	lock      sync.Mutex
+	claims    map[string]*claimState
+}
+
+// NewClaimCoordinator constructs a new ClaimCoordinator
+func NewClaimCoordinator(relayID string, publisher bus.MessagePublisher, window time.Duration) *ClaimCoordinator {
+	return &ClaimCoordinator{
+		relayID:   relayID,
+		publisher: publisher,
+		window:    window,
+		claims:    make(map[string]*claimState),
+	}
+}
+
+// Claim returns true if this Relay should execute the invocation.
+// Blocks for the claim window unless this Relay already claimed it.
+func (cc *ClaimCoordinator) Claim(invocationID string) bool {
+	cc.lock.Lock()
+	cc.expire()
+	state := cc.stateFor(invocationID)
+	if len(state.competitors) > 0 {
+		cc.lock.Unlock()
+		log.Debugf("Skipping invocation %s already claimed by Relay %s.", invocationID, state.competitors[0])
+		return false
+	}
+	// Such as a chained stage running once per input item
+	if state.claimed == true {
+		cc.lock.Unlock()
+		return true
+	}
+	state.claimed = true
+	cc.lock.Unlock()
+	claim, _ := json.Marshal(&messages.ExecutionClaim{
+		InvocationID: invocationID,
+		RelayID:      cc.relayID,
+	})
+	if err := cc.publisher.Publish(ClaimTopic, claim); err != nil {
+		// Executing twice is better than not executing at all
+		log.Errorf("Failed to publish claim for invocation %s: %s.", invocationID, err)
+		return true
+	}
+	time.Sleep(cc.window)
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+	for _, competitor := range state.competitors {
+		if competitor < cc.relayID {
+			log.Debugf("Skipping invocation %s claimed concurrently by Relay %s.", invocationID, competitor)
+			return false
+		}
+	}
+	return true
+}
+
+// HandleClaim records claims published by other Relays
+func (cc *ClaimCoordinator) HandleClaim(conn bus.Connection, topic string, message []byte) {
+	var claim messages.ExecutionClaim
+	if err := json.Unmarshal(message, &claim); err != nil {
+		log.Errorf("Ignoring malformed execution claim: %s.", err)
+		return
+	}
+	if claim.RelayID == cc.relayID || claim.InvocationID == "" {
+		return
+	}
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+	state := cc.stateFor(claim.InvocationID)
+	state.competitors = append(state.competitors, claim.RelayID)
+}
+
+func (cc *ClaimCoordinator) stateFor(invocationID string) *claimState {
+	state := cc.claims[invocationID]
+	if state == nil {
+		state = &claimState{
+			expires: time.Now().Add(cc.window * claimRetention),
+		}
+		cc.claims[invocationID] = state
+	}
+	return state
+}
+
+func (cc *ClaimCoordinator) expire() {
+	now := time.Now()
+	for id, state := range cc.claims {
+		if now.After(state.expires) {
+			delete(cc.claims, id)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package worker
+
+import (
+	"encoding/json"
+	"github.com/operable/go-relay/relay/messages"
+	"sync"
+	"testing"
+	"time"
+)
+
+type claimRecorder struct {
+	lock   sync.Mutex
+	claims []messages.ExecutionClaim
+	peer   *ClaimCoordinator
+	peerID string
+}
+
+func (cr *claimRecorder) Publish(topic string, message []byte) error {
+	var claim messages.ExecutionClaim
+	json.Unmarshal(message, &claim)
+	cr.lock.Lock()
+	cr.claims = append(cr.claims, claim)
+	cr.lock.Unlock()
+	if cr.peerID != "" {
+		// Simulate a competing Relay claiming the same invocation
+		// while this one waits
+		competing, _ := json.Marshal(&messages.ExecutionClaim{
+			InvocationID: claim.InvocationID,
+			RelayID:      cr.peerID,
+		})
+		go cr.peer.HandleClaim(nil, ClaimTopic, competing)
+	}
+	return nil
+}
+
+func claim(relayID string, invocationID string) []byte {
+	raw, _ := json.Marshal(&messages.ExecutionClaim{
+		InvocationID: invocationID,
+		RelayID:      relayID,
+	})
+	return raw
+}
+
+func TestClaimUncontested(t *testing.T) {
+	recorder := &claimRecorder{}
+	coordinator := NewClaimCoordinator("relay-b", recorder, time.Millisecond)
+	if coordinator.Claim("inv-1") == false {
+		t.Error("Expected uncontested claim to succeed")
+	}
+	if len(recorder.claims) != 1 || recorder.claims[0].RelayID != "relay-b" {
+		t.Errorf("Unexpected published claims: %v", recorder.claims)
+	}
+	// Our own claims are echoed back by the broker
+	coordinator.HandleClaim(nil, ClaimTopic, claim("relay-b", "inv-2"))
+	if coordinator.Claim("inv-2") == false {
+		t.Error("Expected Relay to ignore its own claims")
+	}
+	if coordinator.Claim("inv-1") == false || len(recorder.claims) != 2 {
+		t.Errorf("Expected invocation claimed by this Relay not to be claimed again: %v", recorder.claims)
+	}
+}
+
+func TestClaimAlreadyClaimed(t *testing.T) {
+	recorder := &claimRecorder{}
+	coordinator := NewClaimCoordinator("relay-a", recorder, time.Millisecond)
+	coordinator.HandleClaim(nil, ClaimTopic, claim("relay-z", "inv-1"))
+	if coordinator.Claim("inv-1") == true {
+		t.Error("Expected invocation claimed by another Relay to be skipped")
+	}
+	if len(recorder.claims) != 0 {
+		t.Error("Expected no claim to be published")
+	}
+}
+
+func TestClaimConcurrent(t *testing.T) {
+	recorder := &claimRecorder{peerID: "relay-a"}
+	coordinator := NewClaimCoordinator("relay-b", recorder, 50*time.Millisecond)
+	recorder.peer = coordinator
+	if coordinator.Claim("inv-1") == true {
+		t.Error("Expected concurrent claim from lower Relay ID to win")
+	}
+	recorder.peerID = "relay-c"
+	if coordinator.Claim("inv-2") == false {
+		t.Error("Expected concurrent claim from higher Relay ID to lose")
+	}
+}
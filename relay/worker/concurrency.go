@@ -0,0 +1,205 @@
+package worker
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+	"sync"
+	"time"
+)
+
+// Tuning for adaptive concurrency. Latency is tracked with a fast
+// and a slow moving average; when the fast average exceeds the slow
+// one by latencyTolerance the host is assumed to be saturated.
+const (
+	shortLatencyWeight = 0.3
+	longLatencyWeight  = 0.02
+	latencyTolerance   = 2.0
+	decreaseFactor     = 0.75
+)
+
+// ConcurrencyLimiter bounds the number of commands executing at once.
+// In adaptive mode the limit grows additively while executions succeed
+// with stable latency and shrinks multiplicatively on errors or
+// latency spikes (AIMD).
+type ConcurrencyLimiter struct {
+	lock        sync.Mutex
+	changed     chan struct{}
+	stopped     chan struct{}
+	adaptive    bool
+	min         int
+	max         int
+	limit       int
+	inFlight    int
+	successes   int
+	shortAvg    float64
+	longAvg     float64
+	lastChanged time.Time
+}
+
+// LimiterStatus describes a ConcurrencyLimiter's current state
+type LimiterStatus struct {
+	Adaptive bool `json:"adaptive"`
+	Limit    int  `json:"limit"`
+	Min      int  `json:"min"`
+	Max      int  `json:"max"`
+	InFlight int  `json:"in_flight"`
+}
+
+// NewConcurrencyLimiter constructs a limiter. Fixed limiters always
+// allow max concurrent executions; adaptive ones start at max and
+// float between min and max.
+func NewConcurrencyLimiter(adaptive bool, min int, max int) *ConcurrencyLimiter {
+	if min < 1 {
+		min = 1
+	}
+	if min > max {
+		min = max
+	}
+	cl := &ConcurrencyLimiter{
+		adaptive: adaptive,
+		min:      min,
+		max:      max,
+		limit:    max,
+		changed:  make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	return cl
+}
+
+// Acquire blocks until an execution slot is free. Returns ctx's error
+// without taking a slot if ctx is done first, or an error if the
+// limiter is stopped.
+func (cl *ConcurrencyLimiter) Acquire(ctx context.Context) error {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+	for cl.inFlight >= cl.limit {
+		changed := cl.changed
+		cl.lock.Unlock()
+		select {
+		case <-changed:
+			cl.lock.Lock()
+		case <-ctx.Done():
+			cl.lock.Lock()
+			return ctx.Err()
+		case <-cl.stopped:
+			cl.lock.Lock()
+			return errorShuttingDown
+		}
+	}
+	cl.inFlight++
+	return nil
+}
+
+// Release frees an execution slot and, in adaptive mode, feeds the
+// execution's outcome into the limit calculation
+func (cl *ConcurrencyLimiter) Release(latency time.Duration, failed bool) {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+	cl.inFlight--
+	cl.track(latency)
+	if cl.adaptive {
+		cl.adjust(failed)
+	}
+	cl.broadcast()
+}
+
+// Stop makes waiting and future Acquires which would block fail
+// instead. Safe to call more than once.
+func (cl *ConcurrencyLimiter) Stop() {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+	select {
+	case <-cl.stopped:
+	default:
+		close(cl.stopped)
+	}
+}
+
+// Saturated returns true when recent executions are markedly slower
+// than usual, suggesting the host has no spare capacity
+func (cl *ConcurrencyLimiter) Saturated() bool {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+	return cl.saturated()
+}
+
+// SetMax changes the most concurrent executions allowed. Adaptive
+// limiters keep their current limit if it is still within bounds.
+func (cl *ConcurrencyLimiter) SetMax(max int) {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+	cl.max = max
+	if cl.min > max {
+		cl.min = max
+	}
+	if cl.adaptive == false || cl.limit > max {
+		cl.limit = max
+	}
+	cl.broadcast()
+}
+
+// Status returns the limiter's current state
+func (cl *ConcurrencyLimiter) Status() LimiterStatus {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+	return LimiterStatus{
+		Adaptive: cl.adaptive,
+		Limit:    cl.limit,
+		Min:      cl.min,
+		Max:      cl.max,
+		InFlight: cl.inFlight,
+	}
+}
+
+// broadcast wakes every Acquire waiting for the limit to change
+func (cl *ConcurrencyLimiter) broadcast() {
+	close(cl.changed)
+	cl.changed = make(chan struct{})
+}
+
+func (cl *ConcurrencyLimiter) track(latency time.Duration) {
+	sample := float64(latency)
+	if cl.longAvg == 0 {
+		cl.shortAvg = sample
+		cl.longAvg = sample
+	} else {
+		cl.shortAvg += shortLatencyWeight * (sample - cl.shortAvg)
+		cl.longAvg += longLatencyWeight * (sample - cl.longAvg)
+	}
+}
+
+func (cl *ConcurrencyLimiter) saturated() bool {
+	return cl.shortAvg > cl.longAvg*latencyTolerance
+}
+
+func (cl *ConcurrencyLimiter) adjust(failed bool) {
+	if failed || cl.saturated() {
+		cl.successes = 0
+		cl.decrease()
+		return
+	}
+	cl.successes++
+	// Additive increase: one slot per limit's worth of successes
+	if cl.successes >= cl.limit && cl.limit < cl.max {
+		cl.successes = 0
+		cl.limit++
+		log.Debugf("Adaptive concurrency limit raised to %d.", cl.limit)
+	}
+}
+
+func (cl *ConcurrencyLimiter) decrease() {
+	// Only back off once per batch of in-flight executions so a single
+	// slow burst doesn't collapse the limit to the minimum
+	if time.Now().Sub(cl.lastChanged) < time.Duration(cl.shortAvg) {
+		return
+	}
+	limit := int(float64(cl.limit) * decreaseFactor)
+	if limit < cl.min {
+		limit = cl.min
+	}
+	if limit != cl.limit {
+		log.Infof("Adaptive concurrency limit lowered from %d to %d.", cl.limit, limit)
+		cl.limit = limit
+	}
+	cl.lastChanged = time.Now()
+}
@@ -0,0 +1,94 @@
+package worker
+
+import (
+	"golang.org/x/net/context"
+	"testing"
+	"time"
+)
+
+func TestFixedLimiterIgnoresFailures(t *testing.T) {
+	limiter := NewConcurrencyLimiter(false, 1, 4)
+	limiter.Acquire(context.Background())
+	limiter.Release(time.Second, true)
+	if status := limiter.Status(); status.Limit != 4 || status.InFlight != 0 {
+		t.Errorf("Unexpected limiter status: %+v", status)
+	}
+}
+
+func TestAdaptiveLimiterBacksOffAndRecovers(t *testing.T) {
+	limiter := NewConcurrencyLimiter(true, 2, 8)
+	limiter.Acquire(context.Background())
+	limiter.Release(time.Millisecond, true)
+	if limit := limiter.Status().Limit; limit != 6 {
+		t.Fatalf("Expected limit to drop to 6: %d", limit)
+	}
+	for i := 0; i < 6; i++ {
+		limiter.Acquire(context.Background())
+		limiter.Release(time.Millisecond, false)
+	}
+	if limit := limiter.Status().Limit; limit != 7 {
+		t.Errorf("Expected limit to recover to 7: %d", limit)
+	}
+}
+
+func TestAdaptiveLimiterRespectsMinimum(t *testing.T) {
+	limiter := NewConcurrencyLimiter(true, 3, 4)
+	for i := 0; i < 10; i++ {
+		limiter.lastChanged = time.Time{}
+		limiter.Acquire(context.Background())
+		limiter.Release(time.Millisecond, true)
+	}
+	if limit := limiter.Status().Limit; limit != 3 {
+		t.Errorf("Expected limit to stop at minimum of 3: %d", limit)
+	}
+}
+
+func TestLimiterSetMax(t *testing.T) {
+	limiter := NewConcurrencyLimiter(false, 1, 4)
+	limiter.SetMax(8)
+	if status := limiter.Status(); status.Limit != 8 || status.Max != 8 {
+		t.Errorf("Expected fixed limiter to follow max: %+v", status)
+	}
+	adaptive := NewConcurrencyLimiter(true, 4, 8)
+	adaptive.SetMax(2)
+	if status := adaptive.Status(); status.Limit != 2 || status.Min != 2 {
+		t.Errorf("Expected adaptive limit and minimum clamped to new max: %+v", status)
+	}
+	adaptive.SetMax(6)
+	if status := adaptive.Status(); status.Limit != 2 || status.Max != 6 {
+		t.Errorf("Expected adaptive limit to grow on its own: %+v", status)
+	}
+}
+
+func TestLimiterAcquireStopsWhenCancelled(t *testing.T) {
+	limiter := NewConcurrencyLimiter(false, 1, 1)
+	if err := limiter.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := limiter.Acquire(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Expected waiting Acquire to time out: %v", err)
+	}
+	if inFlight := limiter.Status().InFlight; inFlight != 1 {
+		t.Errorf("Expected cancelled Acquire not to take a slot: %d", inFlight)
+	}
+	limiter.Release(time.Millisecond, false)
+	if err := limiter.Acquire(context.Background()); err != nil {
+		t.Errorf("Expected released slot to be acquired: %v", err)
+	}
+}
+
+func TestLimiterAcquireFailsWhenStopped(t *testing.T) {
+	limiter := NewConcurrencyLimiter(false, 1, 1)
+	limiter.Acquire(context.Background())
+	result := make(chan error)
+	go func() {
+		result <- limiter.Acquire(context.Background())
+	}()
+	limiter.Stop()
+	limiter.Stop()
+	if err := <-result; err != errorShuttingDown {
+		t.Errorf("Expected waiting Acquire to fail: %v", err)
+	}
+}
@@ -0,0 +1,44 @@
+package worker
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/operable/go-relay/relay/events"
+	"github.com/operable/go-relay/relay/messages"
+	"github.com/operable/go-relay/relay/metrics"
+	"runtime/debug"
+)
+
+var crashedRequests = metrics.NewCounter("relay_requests_crashed_total",
+	"Execution requests whose execution panicked.")
+
+// respondToCrash tells Cog a request failed because processing it
+// panicked, so its pipeline doesn't wait until it times out. request
+// is nil if the panic happened before it was decoded, in which case
+// there's nobody to tell. The worker itself is replaced by its
+// Supervisor.
+func respondToCrash(invoke *CommandInvocation, request *messages.ExecutionRequest, encoding string, crash interface{}) {
+	crashedRequests.Inc()
+	if request == nil {
+		log.Errorf("Processing request on %s panicked: %v.\n%s", invoke.Topic, crash, debug.Stack())
+		invoke.Events.Record(events.ExecutionErrorKind, "Processing request on %s panicked: %v.", invoke.Topic, crash)
+		return
+	}
+	executionLogger(request).Errorf("Executing %s panicked: %v.\n%s", request.Command, crash, debug.Stack())
+	invoke.Events.Record(events.ExecutionErrorKind, "Executing %s panicked: %v.", request.Command, crash)
+	if request.ReplyTo == "" {
+		return
+	}
+	response := &messages.ExecutionResponse{
+		CorrelationID: request.CorrelationID,
+		Status:        "error",
+		StatusMessage: fmt.Sprintf("Relay crashed executing the command: %v", crash),
+	}
+	defer func() {
+		// Failing to respond mustn't hide the original crash
+		if err := recover(); err != nil {
+			log.Errorf("Failed to report crash executing %s: %v.", request.Command, err)
+		}
+	}()
+	publishResponse(invoke, request.ReplyTo, response, encoding)
+}
@@ -0,0 +1,234 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/operable/go-relay/relay/config"
+	"github.com/operable/go-relay/relay/engines"
+	"github.com/operable/go-relay/relay/messages"
+	"github.com/operable/go-relay/relay/metrics"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var retriedRequests = metrics.NewCounter("relay_requests_retried_total",
+	"Execution requests retried after failing for reasons outside the command's control.")
+var deadLetteredRequests = metrics.NewCounter("relay_requests_dead_lettered_total",
+	"Execution requests moved to the dead-letter store after exhausting their retries.")
+
+// DeadLetter is a request which kept failing for reasons outside the
+// command's control
+type DeadLetter struct {
+	ID            uint64    `json:"id"`
+	Command       string    `json:"command"`
+	CorrelationID string    `json:"correlation_id"`
+	DeliveryKey   string    `json:"delivery_key,omitempty"`
+	Topic         string    `json:"topic"`
+	Payload       []byte    `json:"payload"`
+	Error         string    `json:"error"`
+	Attempts      int       `json:"attempts"`
+	FailedAt      time.Time `json:"failed_at"`
+}
+
+// DeadLetteredBody is the body of the error response sent when a
+// request is dead-lettered
+type DeadLetteredBody struct {
+	Error        string `json:"error"`
+	DeadLetterID uint64 `json:"dead_letter_id"`
+	Attempts     int    `json:"attempts"`
+}
+
+// DeadLetters retries requests whose execution fails for reasons
+// outside the command's control, such as an unavailable engine or a
+// missing image, with exponential backoff. Requests still failing
+// after execution_retries retries are kept, up to dead_letter_size of
+// them, so they can be inspected and requeued. When path is set dead
+// letters survive restarts. A nil DeadLetters never retries.
+type DeadLetters struct {
+	lock    sync.Mutex
+	path    string
+	retries int
+	backoff time.Duration
+	max     int
+	requeue func(thing interface{}) bool
+	nextID  uint64
+	letters []DeadLetter
+}
+
+// OpenDeadLetters loads the dead letters saved at path, if any.
+// requeue puts a request being retried back on the work queue and
+// returns false, without blocking, if Relay is shutting down.
+func OpenDeadLetters(path string, relayConfig *config.Config, requeue func(thing interface{}) bool) (*DeadLetters, error) {
+	dl := &DeadLetters{
+		path:    path,
+		retries: relayConfig.ExecutionRetries,
+		backoff: relayConfig.ExecutionRetryBackoffDuration(),
+		max:     relayConfig.DeadLetterSize,
+		requeue: requeue,
+		nextID:  1,
+		letters: []DeadLetter{},
+	}
+	if path == "" {
+		return dl, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return dl, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &dl.letters); err != nil {
+		log.Warnf("Ignoring corrupt dead letters %s: %s.", path, err)
+		dl.letters = []DeadLetter{}
+	}
+	for _, letter := range dl.letters {
+		if letter.ID >= dl.nextID {
+			dl.nextID = letter.ID + 1
+		}
+	}
+	return dl, nil
+}
+
+// isInfrastructureError returns true if err means the command
+// couldn't run, rather than that it failed or was stopped
+func isInfrastructureError(err error) bool {
+	return err != nil && engines.IsStoppedError(err) == false
+}
+
+// willRetry returns true if a request which failed with err will be
+// retried
+func (dl *DeadLetters) willRetry(invoke *CommandInvocation, err error) bool {
+	return dl != nil && isInfrastructureError(err) && invoke.Attempts < dl.retries
+}
+
+// retry puts a request which failed with err back on the work queue
+// once its backoff, which doubles with each retry, has passed.
+// Requests which can't be requeued because Relay is shutting down are
+// dead-lettered instead.
+func (dl *DeadLetters) retry(thing interface{}, invoke *CommandInvocation, request *messages.ExecutionRequest,
+	encoding string, err error) time.Duration {
+	invoke.Attempts++
+	delay := dl.backoff << uint(invoke.Attempts-1)
+	retriedRequests.Inc()
+	time.AfterFunc(delay, func() {
+		if dl.requeue(thing) == false {
+			dl.abandonRetry(invoke, request, encoding, err)
+		}
+	})
+	return delay
+}
+
+// abandonRetry dead-letters a request whose retry couldn't be queued
+// and releases what it held while waiting
+func (dl *DeadLetters) abandonRetry(invoke *CommandInvocation, request *messages.ExecutionRequest, encoding string, err error) {
+	log.Warnf("Dead-lettering %s instead of retrying it because Relay is shutting down.", request.Command)
+	response := dl.deadLetter(request, invoke, err)
+	response.CorrelationID = request.CorrelationID
+	if invoke.Sealer != nil && invoke.SealReplies == true {
+		if serr := invoke.Sealer.SealResponse(response); serr != nil {
+			log.Errorf("Failed to encrypt response to %s: %s.", request.Command, serr)
+			response = &messages.ExecutionResponse{CorrelationID: request.CorrelationID}
+			setError(response, serr)
+		}
+	}
+	publishResponse(invoke, request.ReplyTo, response, encoding)
+	invoke.Backlog.Remove(invoke.QueueID)
+	if invoke.InFlight != nil {
+		invoke.InFlight.Done()
+	}
+}
+
+// deadLetter keeps a request which exhausted its retries and returns
+// the response sent in place of its result, or nil if err doesn't
+// warrant dead-lettering
+func (dl *DeadLetters) deadLetter(request *messages.ExecutionRequest, invoke *CommandInvocation, err error) *messages.ExecutionResponse {
+	if dl == nil || dl.retries == 0 || isInfrastructureError(err) == false {
+		return nil
+	}
+	dl.lock.Lock()
+	defer dl.lock.Unlock()
+	letter := DeadLetter{
+		ID:            dl.nextID,
+		Command:       request.Command,
+		CorrelationID: request.CorrelationID,
+		DeliveryKey:   deliveryKey(request),
+		Topic:         invoke.Topic,
+		Payload:       invoke.Payload,
+		Error:         err.Error(),
+		Attempts:      invoke.Attempts + 1,
+		FailedAt:      time.Now().UTC(),
+	}
+	dl.nextID++
+	if dl.max > 0 {
+		dl.letters = append(dl.letters, letter)
+		if len(dl.letters) > dl.max {
+			dropped := len(dl.letters) - dl.max
+			log.Warnf("Dropped %d oldest dead letters. More than %d are kept.", dropped, dl.max)
+			dl.letters = dl.letters[dropped:]
+		}
+		dl.save()
+	}
+	deadLetteredRequests.Inc()
+	return &messages.ExecutionResponse{
+		Status:        "error",
+		StatusMessage: fmt.Sprintf("Command failed after %d attempts: %s", letter.Attempts, err),
+		Body: DeadLetteredBody{
+			Error:        "dead_lettered",
+			DeadLetterID: letter.ID,
+			Attempts:     letter.Attempts,
+		},
+	}
+}
+
+// List returns the dead letters, oldest first
+func (dl *DeadLetters) List() []DeadLetter {
+	if dl == nil {
+		return []DeadLetter{}
+	}
+	dl.lock.Lock()
+	defer dl.lock.Unlock()
+	return append([]DeadLetter{}, dl.letters...)
+}
+
+// Take removes and returns the dead letter with id so it can be
+// requeued. Returns false if there is no such dead letter.
+func (dl *DeadLetters) Take(id uint64) (DeadLetter, bool) {
+	if dl == nil {
+		return DeadLetter{}, false
+	}
+	dl.lock.Lock()
+	defer dl.lock.Unlock()
+	for i, letter := range dl.letters {
+		if letter.ID == id {
+			dl.letters = append(dl.letters[:i], dl.letters[i+1:]...)
+			dl.save()
+			return letter, true
+		}
+	}
+	return DeadLetter{}, false
+}
+
+// save writes the dead letters. Callers must hold the lock.
+func (dl *DeadLetters) save() {
+	if dl.path == "" {
+		return
+	}
+	data, err := json.Marshal(dl.letters)
+	if err == nil {
+		tmp := dl.path + ".tmp"
+		if err = ioutil.WriteFile(tmp, data, 0600); err == nil {
+			err = os.Rename(tmp, dl.path)
+		}
+	}
+	if err != nil {
+		log.Errorf("Failed to persist dead letters to %s: %s.", dl.path, err)
+	}
+}
@@ -0,0 +1,106 @@
+package worker
+
+import (
+	"errors"
+	"github.com/operable/go-relay/relay/config"
+	"github.com/operable/go-relay/relay/engines"
+	"github.com/operable/go-relay/relay/messages"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDeadLetters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dead_letters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lettersPath := path.Join(dir, "dead_letters.json")
+	relayConfig := &config.Config{ExecutionRetries: 2, ExecutionRetryBackoff: "10ms", DeadLetterSize: 1}
+	requeued := make(chan interface{}, 1)
+	deadLetters, err := OpenDeadLetters(lettersPath, relayConfig, func(thing interface{}) bool {
+		requeued <- thing
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	imageMissing := errors.New("Image not found")
+	invoke := &CommandInvocation{Topic: "/bot/commands/relay/operable/echo", Payload: []byte(`{}`)}
+	if deadLetters.willRetry(invoke, &engines.ExecutionTimeoutError{Timeout: time.Second}) == true {
+		t.Error("Expected timeouts not to be retried")
+	}
+	for attempt := 1; attempt <= 2; attempt++ {
+		if deadLetters.willRetry(invoke, imageMissing) == false {
+			t.Fatalf("Expected retry %d", attempt)
+		}
+		if delay := deadLetters.retry("request", invoke, nil, messages.EncodingJSON, imageMissing); delay != time.Duration(attempt)*10*time.Millisecond {
+			t.Errorf("Unexpected backoff for retry %d: %v", attempt, delay)
+		}
+		select {
+		case thing := <-requeued:
+			if thing != "request" {
+				t.Errorf("Unexpected requeued request: %v", thing)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected request to be requeued")
+		}
+	}
+	if deadLetters.willRetry(invoke, imageMissing) == true {
+		t.Error("Expected retries to be exhausted")
+	}
+	request := &messages.ExecutionRequest{Command: "operable:echo", CorrelationID: "first"}
+	deadLetters.deadLetter(request, invoke, imageMissing)
+	request.CorrelationID = "second"
+	response := deadLetters.deadLetter(request, invoke, imageMissing)
+	body, ok := response.Body.(DeadLetteredBody)
+	if response.Status != "error" || ok == false || body.Error != "dead_lettered" || body.Attempts != 3 {
+		t.Fatalf("Unexpected dead-lettered response: %+v", response)
+	}
+	reopened, err := OpenDeadLetters(lettersPath, relayConfig, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	letters := reopened.List()
+	if len(letters) != 1 || letters[0].CorrelationID != "second" || letters[0].ID != body.DeadLetterID {
+		t.Fatalf("Expected only the newest dead letter to be kept: %+v", letters)
+	}
+	if _, ok := reopened.Take(body.DeadLetterID + 1); ok == true {
+		t.Error("Expected unknown dead letter not to be found")
+	}
+	if letter, ok := reopened.Take(body.DeadLetterID); ok == false || letter.Topic != invoke.Topic {
+		t.Errorf("Unexpected dead letter: %+v", letter)
+	}
+	if len(reopened.List()) != 0 {
+		t.Error("Expected taken dead letter to be removed")
+	}
+}
+
+func TestRetriesAreDeadLetteredOnShutdown(t *testing.T) {
+	relayConfig := &config.Config{ExecutionRetries: 2, ExecutionRetryBackoff: "1ms", DeadLetterSize: 1}
+	deadLetters, err := OpenDeadLetters("", relayConfig, func(thing interface{}) bool {
+		return false
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var inFlight sync.WaitGroup
+	inFlight.Add(1)
+	recorder := &responseRecorder{}
+	invoke := &CommandInvocation{Publisher: recorder, InFlight: &inFlight, Topic: "/bot/commands/relay/operable/echo"}
+	request := &messages.ExecutionRequest{Command: "operable:echo", CorrelationID: "stopping",
+		ReplyTo: "/bot/pipelines/abc/reply"}
+	deadLetters.retry("request", invoke, request, messages.EncodingJSON, errors.New("Image not found"))
+	inFlight.Wait()
+	if len(recorder.responses) != 1 || recorder.responses[0].CorrelationID != "stopping" ||
+		recorder.responses[0].Status != "error" {
+		t.Errorf("Expected request to be failed: %+v", recorder.responses)
+	}
+	if letters := deadLetters.List(); len(letters) != 1 || letters[0].CorrelationID != "stopping" {
+		t.Errorf("Expected request to be dead-lettered: %+v", letters)
+	}
+}
@@ -0,0 +1,93 @@
+package worker
+
+import (
+	"github.com/operable/go-relay/relay/messages"
+	"github.com/operable/go-relay/relay/metrics"
+	"sync"
+	"time"
+)
+
+var duplicateDeliveries = metrics.NewCounter("relay_requests_duplicate_total",
+	"Execution requests dropped because Cog's request was already delivered.")
+
+type delivery struct {
+	invoke *CommandInvocation
+	at     time.Time
+}
+
+// Deliveries remembers the delivery keys of requests delivered within
+// a window so requests the broker redelivers, or replays after a
+// reconnect, aren't executed twice. A nil Deliveries detects no
+// duplicates.
+type Deliveries struct {
+	lock   sync.Mutex
+	window time.Duration
+	seen   map[string]delivery
+	swept  time.Time
+}
+
+// NewDeliveries constructs a Deliveries remembering requests for
+// window. Returns nil if window is zero.
+func NewDeliveries(window time.Duration) *Deliveries {
+	if window <= 0 {
+		return nil
+	}
+	return &Deliveries{
+		window: window,
+		seen:   make(map[string]delivery),
+	}
+}
+
+// deliveryKey identifies request across deliveries. Cog gives each
+// pipeline stage its own invocation ID, so the invocation ID and step
+// are used; requests without one fall back to the correlation ID Cog
+// sent, if any. Must be called before the request is parsed, which
+// assigns correlation IDs. Returns "" for requests which can't be
+// told apart.
+func deliveryKey(request *messages.ExecutionRequest) string {
+	if request.InvocationID != "" {
+		return request.InvocationID + "/" + request.InvocationStep
+	}
+	return request.CorrelationID
+}
+
+// Duplicate records a delivery of the request with key and returns
+// true if a different delivery of it was seen within the window. Held
+// and retried requests pass through a worker again with the same
+// invoke and aren't duplicates. Requests without a key can't be told
+// apart and are never duplicates.
+func (d *Deliveries) Duplicate(key string, invoke *CommandInvocation, now time.Time) bool {
+	if d == nil || key == "" {
+		return false
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if now.Sub(d.swept) > d.window {
+		for id, seen := range d.seen {
+			if now.Sub(seen.at) > d.window {
+				delete(d.seen, id)
+			}
+		}
+		d.swept = now
+	}
+	if seen, ok := d.seen[key]; ok && now.Sub(seen.at) <= d.window {
+		if seen.invoke == invoke {
+			return false
+		}
+		duplicateDeliveries.Inc()
+		return true
+	}
+	d.seen[key] = delivery{invoke: invoke, at: now}
+	return false
+}
+
+// Forget lets the request with key run again, such as when a
+// dead-lettered request is requeued
+func (d *Deliveries) Forget(key string) {
+	if d == nil {
+		return
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	delete(d.seen, key)
+}
@@ -0,0 +1,91 @@
+package worker
+
+import (
+	"github.com/operable/go-relay/relay/config"
+	"github.com/operable/go-relay/relay/messages"
+	"golang.org/x/net/context"
+	"testing"
+	"time"
+)
+
+func TestDeliveries(t *testing.T) {
+	deliveries := NewDeliveries(time.Minute)
+	now := time.Now()
+	first := &CommandInvocation{}
+	if deliveries.Duplicate("abc", first, now) == true {
+		t.Error("First delivery reported as a duplicate")
+	}
+	if deliveries.Duplicate("abc", first, now.Add(time.Second)) == true {
+		t.Error("Held request reported as a duplicate")
+	}
+	if deliveries.Duplicate("abc", &CommandInvocation{}, now.Add(time.Second)) == false {
+		t.Error("Expected redelivery to be a duplicate")
+	}
+	if deliveries.Duplicate("", &CommandInvocation{}, now) == true ||
+		deliveries.Duplicate("", &CommandInvocation{}, now) == true {
+		t.Error("Requests without a delivery key reported as duplicates")
+	}
+	if deliveries.Duplicate("abc", &CommandInvocation{}, now.Add(2*time.Minute)) == true {
+		t.Error("Delivery after the window reported as a duplicate")
+	}
+	deliveries.Forget("abc")
+	if deliveries.Duplicate("abc", &CommandInvocation{}, now.Add(2*time.Minute)) == true {
+		t.Error("Forgotten request reported as a duplicate")
+	}
+	if NewDeliveries(0).Duplicate("abc", first, now) == true {
+		t.Error("Disabled duplicate detection reported a duplicate")
+	}
+}
+
+func TestDeliveryKey(t *testing.T) {
+	request := &messages.ExecutionRequest{InvocationID: "abc", InvocationStep: "last", CorrelationID: "def"}
+	if key := deliveryKey(request); key != "abc/last" {
+		t.Errorf("Expected requests to be keyed on their invocation: %s", key)
+	}
+	request.InvocationID = ""
+	if key := deliveryKey(request); key != "def" {
+		t.Errorf("Expected requests without an invocation to be keyed on their correlation ID: %s", key)
+	}
+}
+
+func TestDuplicateDeliveriesAreDropped(t *testing.T) {
+	recorder := &responseRecorder{}
+	deliveries := NewDeliveries(time.Minute)
+	deliveries.Duplicate("from-cog", &CommandInvocation{}, time.Now())
+	worker := &executionWorker{}
+	invoke := &CommandInvocation{
+		RelayConfig: &config.Config{QueueTTL: "0s"},
+		Publisher:   recorder,
+		Deliveries:  deliveries,
+		Payload: []byte(`{"command":"test:echo","reply_to":"/bot/pipelines/abc/reply",` +
+			`"correlation_id":"from-cog","room":{},"requestor":{},"user":{},"cog_env":{},"args":[],"options":{}}`),
+	}
+	worker.process(context.WithValue(context.Background(), "invoke", invoke))
+	if len(recorder.responses) != 0 {
+		t.Errorf("Expected duplicate to be dropped: %+v", recorder.responses)
+	}
+}
+
+func TestRedeliveriesWithoutCorrelationIDAreDropped(t *testing.T) {
+	recorder := &responseRecorder{}
+	deliveries := NewDeliveries(time.Minute)
+	payload := []byte(`{"command":"test:echo","reply_to":"/bot/pipelines/abc/reply",` +
+		`"invocation_id":"inv-1","invocation_step":"last",` +
+		`"room":{},"requestor":{},"user":{},"cog_env":{},"args":[],"options":{}}`)
+	worker := &executionWorker{}
+	// Requests arriving while shutting down are answered without
+	// needing engines
+	for i := 0; i < 2; i++ {
+		invoke := &CommandInvocation{
+			RelayConfig: &config.Config{QueueTTL: "0s"},
+			Publisher:   recorder,
+			Deliveries:  deliveries,
+			Payload:     payload,
+			Shutdown:    true,
+		}
+		worker.process(context.WithValue(context.Background(), "invoke", invoke))
+	}
+	if len(recorder.responses) != 1 {
+		t.Errorf("Expected only the first delivery to be answered: %+v", recorder.responses)
+	}
+}
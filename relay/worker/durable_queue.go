@@ -0,0 +1,246 @@
+package worker
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/operable/go-relay/relay/bus"
+	"github.com/operable/go-relay/relay/messages"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// UnrunRequestMessage is returned to Cog for requests a previous run
+// accepted but never started when queue_recovery is "fail"
+const UnrunRequestMessage = "Relay restarted before running the command"
+
+// The queue file is compacted once it holds this many more removed
+// requests than queued ones
+const durableQueueSlack = 1000
+
+const (
+	queueAddOp    = "add"
+	queueRemoveOp = "remove"
+)
+
+// QueuedRequest is an execution request waiting in a DurableQueue
+type QueuedRequest struct {
+	ID       uint64    `json:"id"`
+	Topic    string    `json:"topic,omitempty"`
+	Payload  []byte    `json:"payload,omitempty"`
+	QueuedAt time.Time `json:"queued_at,omitempty"`
+}
+
+type queueOp struct {
+	Op string `json:"op"`
+	QueuedRequest
+}
+
+// DurableQueue persists the requests waiting for a worker so those
+// accepted but not yet started survive a crash or restart. Requests
+// are appended to a log as they're queued and marked removed when
+// they start; the log is compacted as removals pile up. A nil
+// DurableQueue persists nothing.
+type DurableQueue struct {
+	lock    sync.Mutex
+	path    string
+	file    *os.File
+	nextID  uint64
+	queued  map[uint64]QueuedRequest
+	removed int
+}
+
+// OpenDurableQueue opens the queue at path and returns the requests
+// left in it by the previous run, oldest first. They stay queued until
+// removed.
+func OpenDurableQueue(path string) (*DurableQueue, []QueuedRequest, error) {
+	dq := &DurableQueue{
+		path:   path,
+		nextID: 1,
+		queued: make(map[uint64]QueuedRequest),
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, nil, err
+	}
+	if err := dq.load(); err != nil {
+		return nil, nil, err
+	}
+	if err := dq.compact(); err != nil {
+		return nil, nil, err
+	}
+	return dq, dq.Pending(), nil
+}
+
+func (dq *DurableQueue) load() error {
+	f, err := os.Open(dq.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var op queueOp
+		if err := json.Unmarshal(scanner.Bytes(), &op); err != nil {
+			log.Warnf("Skipped corrupt work queue entry in %s: %s.", dq.path, err)
+			continue
+		}
+		switch op.Op {
+		case queueAddOp:
+			dq.queued[op.ID] = op.QueuedRequest
+		case queueRemoveOp:
+			delete(dq.queued, op.ID)
+		}
+		if op.ID >= dq.nextID {
+			dq.nextID = op.ID + 1
+		}
+	}
+	return scanner.Err()
+}
+
+// Add queues a request and returns its ID
+func (dq *DurableQueue) Add(topic string, payload []byte, queuedAt time.Time) uint64 {
+	if dq == nil {
+		return 0
+	}
+	dq.lock.Lock()
+	defer dq.lock.Unlock()
+	request := QueuedRequest{
+		ID:       dq.nextID,
+		Topic:    topic,
+		Payload:  payload,
+		QueuedAt: queuedAt,
+	}
+	dq.nextID++
+	dq.queued[request.ID] = request
+	if err := dq.append(queueOp{Op: queueAddOp, QueuedRequest: request}); err != nil {
+		log.Errorf("Failed to persist queued request to %s: %s.", dq.path, err)
+	}
+	return request.ID
+}
+
+// Remove drops a request from the queue. Removing a request which
+// isn't queued does nothing.
+func (dq *DurableQueue) Remove(id uint64) {
+	if dq == nil {
+		return
+	}
+	dq.lock.Lock()
+	defer dq.lock.Unlock()
+	if _, ok := dq.queued[id]; ok == false {
+		return
+	}
+	delete(dq.queued, id)
+	dq.removed++
+	var err error
+	if dq.removed > len(dq.queued)+durableQueueSlack {
+		err = dq.compact()
+	} else {
+		err = dq.append(queueOp{Op: queueRemoveOp, QueuedRequest: QueuedRequest{ID: id}})
+	}
+	if err != nil {
+		log.Errorf("Failed to persist queued request removal to %s: %s.", dq.path, err)
+	}
+}
+
+// Pending returns the queued requests, oldest first
+func (dq *DurableQueue) Pending() []QueuedRequest {
+	if dq == nil {
+		return nil
+	}
+	dq.lock.Lock()
+	defer dq.lock.Unlock()
+	pending := []QueuedRequest{}
+	for _, request := range dq.queued {
+		pending = append(pending, request)
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].ID < pending[j].ID
+	})
+	return pending
+}
+
+// Close closes the queue's file. Queued requests stay on disk.
+func (dq *DurableQueue) Close() error {
+	if dq == nil {
+		return nil
+	}
+	dq.lock.Lock()
+	defer dq.lock.Unlock()
+	if dq.file == nil {
+		return nil
+	}
+	err := dq.file.Close()
+	dq.file = nil
+	return err
+}
+
+func (dq *DurableQueue) append(op queueOp) error {
+	if dq.file == nil {
+		f, err := os.OpenFile(dq.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return err
+		}
+		dq.file = f
+	}
+	line, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	_, err = dq.file.Write(append(line, '\n'))
+	return err
+}
+
+// compact rewrites the queue file with only the queued requests
+func (dq *DurableQueue) compact() error {
+	if dq.file != nil {
+		dq.file.Close()
+		dq.file = nil
+	}
+	ids := []uint64{}
+	for id := range dq.queued {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	data := []byte{}
+	for _, id := range ids {
+		line, err := json.Marshal(queueOp{Op: queueAddOp, QueuedRequest: dq.queued[id]})
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+	}
+	tmp := fmt.Sprintf("%s.tmp", dq.path)
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	dq.removed = 0
+	return os.Rename(tmp, dq.path)
+}
+
+// FailQueuedRequests tells Cog the requests a previous run accepted
+// but never started have failed
+func FailQueuedRequests(publisher bus.MessagePublisher, sealer *messages.Sealer, strict bool, queued []QueuedRequest) {
+	for _, entry := range queued {
+		response := &messages.ExecutionResponse{
+			Status:        "error",
+			StatusMessage: UnrunRequestMessage,
+		}
+		request, err := RefuseRequest(publisher, sealer, strict, entry.Payload, response)
+		if err != nil {
+			log.Errorf("Dropping queued request on %s: %s.", entry.Topic, err)
+			continue
+		}
+		log.Warnf("Failed %s (%s) which was queued when Relay stopped.", request.Command, request.CorrelationID)
+	}
+}
@@ -0,0 +1,73 @@
+package worker
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestDurableQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "durable_queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	queuePath := path.Join(dir, "queue.jsonl")
+	queue, unrun, err := OpenDurableQueue(queuePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unrun) != 0 {
+		t.Errorf("Expected no queued requests: %v", unrun)
+	}
+	queuedAt := time.Now().UTC().Truncate(time.Second)
+	first := queue.Add("/bot/commands/relay/operable/echo", []byte(`{"command":"operable:echo"}`), queuedAt)
+	started := queue.Add("/bot/commands/relay/operable/echo", []byte(`{}`), queuedAt)
+	second := queue.Add("/bot/commands/relay/operable/date", []byte(`{"command":"operable:date"}`), queuedAt)
+	queue.Remove(started)
+	queue.Remove(started)
+	queue.Close()
+	queue, unrun, err = OpenDurableQueue(queuePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer queue.Close()
+	if len(unrun) != 2 || unrun[0].ID != first || unrun[1].ID != second {
+		t.Fatalf("Unexpected queued requests: %v", unrun)
+	}
+	if string(unrun[1].Payload) != `{"command":"operable:date"}` || unrun[1].QueuedAt.Equal(queuedAt) == false {
+		t.Errorf("Unexpected queued request: %+v", unrun[1])
+	}
+	if next := queue.Add("/bot/commands/relay/operable/echo", nil, queuedAt); next <= second {
+		t.Errorf("Expected IDs not to be reused: %d", next)
+	}
+	for i := 0; i < durableQueueSlack+10; i++ {
+		queue.Remove(queue.Add("/bot/commands/relay/operable/echo", nil, queuedAt))
+	}
+	if info, err := os.Stat(queuePath); err != nil || info.Size() > 10000 {
+		t.Errorf("Expected queue file to be compacted: %v", err)
+	}
+	if len(queue.Pending()) != 3 {
+		t.Errorf("Unexpected queued requests: %v", queue.Pending())
+	}
+	var disabled *DurableQueue
+	if disabled.Add("/bot/commands/relay/operable/echo", nil, queuedAt) != 0 {
+		t.Error("Expected nil queue to persist nothing")
+	}
+}
+
+func TestFailQueuedRequests(t *testing.T) {
+	recorder := &responseRecorder{}
+	FailQueuedRequests(recorder, nil, false, []QueuedRequest{
+		{ID: 1, Payload: []byte(`{"command":"operable:echo","reply_to":"/bot/pipelines/abc/reply","cog_env":{},"args":[],"options":{}}`)},
+		{ID: 2, Payload: []byte(`not json`)},
+	})
+	if len(recorder.responses) != 1 || recorder.topics[0] != "/bot/pipelines/abc/reply" {
+		t.Fatalf("Expected one response: %v", recorder.topics)
+	}
+	if response := recorder.responses[0]; response.Status != "error" || response.StatusMessage != UnrunRequestMessage {
+		t.Errorf("Unexpected response: %+v", response)
+	}
+}
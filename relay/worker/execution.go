@@ -4,74 +4,374 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"github.com/operable/circuit"
+	"github.com/operable/circuit-driver/api"
 	"github.com/operable/go-relay/relay/bundle"
 	"github.com/operable/go-relay/relay/bus"
 	"github.com/operable/go-relay/relay/config"
 	"github.com/operable/go-relay/relay/engines"
+	"github.com/operable/go-relay/relay/events"
+	"github.com/operable/go-relay/relay/facts"
 	"github.com/operable/go-relay/relay/messages"
 	"github.com/operable/go-relay/relay/util"
 	"golang.org/x/net/context"
+	"os"
+	"strings"
+	"sync"
+	"time"
 )
 
-// CommandInvocation request
+var errorShuttingDown = errors.New("Relay is shutting down")
+
+// CommandInvocation request. Invocations with Shutdown set arrived
+// after Relay started shutting down and are answered with an error
+// instead of being run. Sealed requests are always opened but
+// response bodies are only sealed if SealReplies is set because Cog
+// agreed to sealed payloads.
 type CommandInvocation struct {
 	RelayConfig *config.Config
 	Publisher   bus.MessagePublisher
 	Catalog     *bundle.Catalog
 	Engines     *engines.Engines
+	Facts       *facts.Gatherer
+	ReadOnly    *ReadOnlyMode
+	Maintenance *MaintenanceMode
+	InFlight    *sync.WaitGroup
+	Limiter     *ConcurrencyLimiter
+	Claims      *ClaimCoordinator
+	Sealer      *messages.Sealer
+	SealReplies bool
+	Events      *events.Log
+	Journal     *RequestJournal
+	Bundles     *BundleLimiter
+	Rates       *RateLimiter
+	Cancels     *Cancellations
+	Deliveries  *Deliveries
+	Backlog     *DurableQueue
+	QueueID     uint64
+	DeadLetters *DeadLetters
+	Attempts    int
+	Waits       *QueueWaits
 	Topic       string
 	Payload     []byte
+	QueuedAt    time.Time
 	Shutdown    bool
+	state       int32
+	holdLock    sync.Mutex
+	held        []*heldSlot
+}
+
+// executionWorker holds the state a single execution goroutine
+// reuses between requests
+type executionWorker struct {
+	decoder        *json.Decoder
+	bufferedReader *bufio.Reader
+	lock           sync.Mutex
+	active         *activeRequest
 }
 
-// ExecutionWorker is the entry point for command execution
-// goroutines.
-func ExecutionWorker(queue chan interface{}) {
-	var decoder *json.Decoder
-	var bufferedReader *bufio.Reader
-	for {
-		thing := <-queue
-		// Convert dequeued thing to context
-		ctx, ok := thing.(context.Context)
+// invocationFor extracts the CommandInvocation from a dequeued
+// request
+func invocationFor(thing interface{}) (*CommandInvocation, bool) {
+	ctx, ok := thing.(context.Context)
+	if ok == false {
+		return nil, false
+	}
+	invoke, ok := ctx.Value("invoke").(*CommandInvocation)
+	return invoke, ok
+}
 
-		if ok == false {
-			log.Error("Dropping improperly queued request.")
-			continue
+// process executes a single queued request. In-flight and concurrency
+// accounting is released even if execution panics. Requests held back
+// by their bundle's concurrency limit stay in flight until they run.
+// Commands are stopped once their bundle's execution timeout passes
+// or Cog cancels them. Cog is sent an error response if execution
+// panics. Duplicate deliveries of a request are dropped. The capacity
+// a request holds is freed early if its worker is recycled as stuck.
+func (ew *executionWorker) process(thing interface{}) {
+	invoke, ok := invocationFor(thing)
+	if ok == false {
+		log.Error("Dropping improperly queued request.")
+		return
+	}
+	var request *messages.ExecutionRequest
+	var encoding string
+	invoke.begin()
+	held := false
+	if invoke.InFlight != nil {
+		done := invoke.hold(invoke.InFlight.Done)
+		defer func() {
+			if held == false {
+				done()
+			}
+		}()
+	}
+	defer func() {
+		if held == false {
+			invoke.Backlog.Remove(invoke.QueueID)
 		}
-		invoke := ctx.Value("invoke").(*CommandInvocation)
-		if bufferedReader == nil {
-			bufferedReader = bufio.NewReader(bytes.NewReader(invoke.Payload))
-			decoder = util.NewJSONDecoder(bufferedReader)
-		} else {
-			bufferedReader.Reset(bytes.NewReader(invoke.Payload))
+	}()
+	// Registered before anything which might panic, and after the
+	// deferred releases so Cog is answered before they run
+	defer func() {
+		if crash := recover(); crash != nil {
+			respondToCrash(invoke, request, encoding, crash)
+			// Let the Supervisor replace this worker, whose state may
+			// be corrupt
+			panic(crash)
+		}
+	}()
+	if invoke.Attempts == 0 && invoke.QueuedAt.IsZero() == false {
+		invoke.Waits.Observe(time.Now().Sub(invoke.QueuedAt))
+	}
+	payload := invoke.Payload
+	if invoke.Sealer != nil {
+		opened, err := invoke.Sealer.OpenRequest(payload)
+		if err != nil {
+			rejectSealedRequest(payload, err, invoke)
+			return
+		}
+		payload = opened
+	}
+	var verr *messages.ValidationError
+	request, encoding, verr = ew.decodeRequest(payload, invoke.RelayConfig.StrictMessageValidation())
+	if verr != nil {
+		rejectRequest(verr, encoding, invoke)
+		return
+	}
+	// Checked before parsing assigns IDs to requests which lack one
+	if key := deliveryKey(request); invoke.Deliveries.Duplicate(key, invoke, time.Now()) {
+		log.Infof("Dropping duplicate delivery of %s (%s).", request.Command, key)
+		return
+	}
+	// Parsed up front so requests without a correlation ID are
+	// journaled and bundle timeouts apply when spotting stuck workers
+	request.Parse()
+	if invoke.Bundles.Acquire(request.BundleName(), thing) == false {
+		log.Debugf("Holding %s until running commands of bundle %s finish.", request.Command, request.BundleName())
+		held = true
+		return
+	}
+	releaseBundle := invoke.hold(func() {
+		invoke.Bundles.Release(request.BundleName())
+	})
+	defer releaseBundle()
+	record := RequestRecord{
+		CorrelationID: request.CorrelationID,
+		Command:       request.Command,
+		ReplyTo:       request.ReplyTo,
+		Encoding:      encoding,
+		StartedAt:     time.Now().UTC(),
+	}
+	// Once started the journal, not the durable queue, accounts for
+	// the request
+	invoke.Backlog.Remove(invoke.QueueID)
+	invoke.Journal.Begin(record)
+	defer func() {
+		// Abandoned requests were already ended when their worker
+		// was recycled
+		if invoke.isAbandoned() == false {
+			invoke.Journal.End(request.CorrelationID)
+		}
+	}()
+	ew.setActive(&activeRequest{
+		invoke: invoke,
+		record: record,
+		limit:  stuckLimit(request, invoke),
+	})
+	defer ew.setActive(nil)
+	ctx, untrack := invoke.Cancels.track(thing.(context.Context), request)
+	defer untrack()
+	failed := true
+	if invoke.Limiter != nil {
+		// Commands get their bundle's whole execution timeout once
+		// they have a slot but don't wait longer than that for one
+		waitCtx, cancelWait := engines.WithExecutionTimeout(ctx,
+			invoke.RelayConfig.ExecutionTimeoutFor(request.BundleName()))
+		err := invoke.Limiter.Acquire(waitCtx)
+		if err != nil {
+			log.Infof("Gave up waiting to run %s: %s.", request.Command, err)
+			response := stoppedResponse(waitCtx, invoke, err)
+			response.CorrelationID = request.CorrelationID
+			publishResponse(invoke, request.ReplyTo, response, encoding)
+		}
+		cancelWait()
+		if err != nil {
+			return
+		}
+		started := time.Now()
+		releaseSlot := invoke.hold(func() {
+			// Abandoned commands count as failed
+			invoke.Limiter.Release(time.Now().Sub(started), invoke.isAbandoned() || failed)
+		})
+		defer releaseSlot()
+	}
+	execErr := func() error {
+		// Hands what the request holds back to this worker unless it
+		// was recycled first
+		defer invoke.finish()
+		return executeCommand(ctx, request, encoding, invoke)
+	}()
+	failed = execErr != nil
+	if execErr != nil && invoke.isAbandoned() == false {
+		invoke.Events.Record(events.ExecutionErrorKind, "Executing %s failed: %s.", request.Command, execErr)
+		if invoke.DeadLetters.willRetry(invoke, execErr) {
+			// Retries stay in flight until they finish
+			held = true
+			if invoke.QueueID != 0 {
+				invoke.QueueID = invoke.Backlog.Add(invoke.Topic, invoke.Payload, invoke.QueuedAt)
+			}
+			delay := invoke.DeadLetters.retry(thing, invoke, request, encoding, execErr)
+			log.Warnf("Retrying %s in %v after it failed: %s.", request.Command, delay, execErr)
 		}
-		executeCommand(decoder, invoke)
 	}
 }
 
-func executeCommand(decoder *json.Decoder, invoke *CommandInvocation) {
+// decodeRequest validates and decodes an execution request and returns
+// the encoding Cog used so the response can be sent back the same way.
+// Protobuf decoding already enforces field types, so only JSON payloads
+// are checked against the schema before decoding.
+func (ew *executionWorker) decodeRequest(payload []byte, strict bool) (*messages.ExecutionRequest, string, *messages.ValidationError) {
+	if messages.IsProtobuf(payload) {
+		request, err := messages.DecodeExecutionRequest(payload)
+		if err != nil {
+			return nil, messages.EncodingProtobuf, messages.NewValidationError("", "is not a valid protobuf message", "")
+		}
+		return request, messages.EncodingProtobuf, request.Validate()
+	}
+	if verr := messages.ValidateExecutionRequest(payload, strict); verr != nil {
+		return nil, messages.EncodingJSON, verr
+	}
+	if ew.bufferedReader == nil {
+		ew.bufferedReader = bufio.NewReader(bytes.NewReader(payload))
+		ew.decoder = util.NewJSONDecoder(ew.bufferedReader)
+	} else {
+		ew.bufferedReader.Reset(bytes.NewReader(payload))
+	}
 	request := &messages.ExecutionRequest{}
-
-	if err := decoder.Decode(request); err != nil {
-		log.Errorf("Ignoring malformed execution request: %s.", err)
-		return
+	if err := ew.decoder.Decode(request); err != nil {
+		return nil, messages.EncodingJSON, messages.NewValidationError("", err.Error(), "")
 	}
+	return request, messages.EncodingJSON, nil
+}
+
+// executeCommand runs a single command invocation and publishes its
+// response using the request's encoding. Returns an error if execution
+// failed for reasons outside the command's control, such as an
+// environment which couldn't be created or a container which died.
+func executeCommand(ctx context.Context, request *messages.ExecutionRequest, encoding string, invoke *CommandInvocation) error {
 	request.Parse()
+	logger := executionLogger(request)
+	rejected, claimed := admissionResponse(ctx, request, invoke, logger)
+	if claimed == false {
+		return nil
+	}
+	if rejected != nil {
+		rejected.CorrelationID = request.CorrelationID
+		publishResponse(invoke, request.ReplyTo, rejected, encoding)
+		return nil
+	}
+	response, execErr := runCommand(ctx, request, invoke, logger)
+	if len(request.Stages) > 0 && execErr == nil && request.Explain == false {
+		response, execErr = runStages(ctx, request, response, invoke, logger)
+	}
+	if invoke.DeadLetters.willRetry(invoke, execErr) {
+		return execErr
+	}
+	if deadLettered := invoke.DeadLetters.deadLetter(request, invoke, execErr); deadLettered != nil {
+		logger.Errorf("Gave up on %s after %d attempts: %s.", request.Command, invoke.Attempts+1, execErr)
+		response = deadLettered
+	}
+	response.CorrelationID = request.CorrelationID
+	if invoke.Sealer != nil && invoke.SealReplies == true {
+		if err := invoke.Sealer.SealResponse(response); err != nil {
+			logger.Errorf("Failed to encrypt response to %s: %s.", request.Command, err)
+			response = &messages.ExecutionResponse{CorrelationID: request.CorrelationID}
+			setError(response, err)
+		}
+	}
+	publishResponse(invoke, request.ReplyTo, response, encoding)
+	return execErr
+}
+
+// admissionResponse runs the checks every command, including chained
+// stages, must pass before it runs. Returns the response rejecting
+// request, or nil if it may run. claimed is false if another Relay
+// claimed the invocation, in which case this Relay stays silent.
+func admissionResponse(ctx context.Context, request *messages.ExecutionRequest, invoke *CommandInvocation,
+	logger *log.Entry) (*messages.ExecutionResponse, bool) {
+	if expired := expiredResponse(request, invoke, time.Now()); expired != nil {
+		logger.Warnf("Dropped %s: %s.", request.Command, expired.StatusMessage)
+		return expired, true
+	}
+	if invoke.Shutdown == true {
+		logger.Infof("Rejected %s because Relay is shutting down.", request.Command)
+		response := &messages.ExecutionResponse{}
+		setError(response, errorShuttingDown)
+		return response, true
+	}
+	if invoke.Maintenance != nil {
+		if enabled, message := invoke.Maintenance.Status(); enabled {
+			logger.Infof("Rejected %s while in maintenance mode.", request.Command)
+			return &messages.ExecutionResponse{
+				Status:        "error",
+				StatusMessage: message,
+			}, true
+		}
+	}
+	// Retries were claimed when they first ran
+	if invoke.Attempts == 0 && invoke.Claims != nil && request.InvocationID != "" &&
+		invoke.Claims.Claim(request.InvocationID) == false {
+		return nil, false
+	}
+	if limited := rateLimitedResponse(request, invoke, time.Now()); limited != nil {
+		logger.Infof("Rejected %s: %s.", request.Command, limited.StatusMessage)
+		return limited, true
+	}
+	if reason, cancelled := invoke.Cancels.cancellation(ctx); cancelled {
+		logger.Infof("Rejected %s: %s.", request.Command, reason)
+		return cancelledResponse(reason), true
+	}
+	return nil, true
+}
+
+// runCommand runs request's command and returns its response. Returns
+// an error if execution failed for reasons outside the command's
+// control.
+func runCommand(ctx context.Context, request *messages.ExecutionRequest, invoke *CommandInvocation,
+	logger *log.Entry) (*messages.ExecutionResponse, error) {
+	ctx, cancel := engines.WithExecutionTimeout(ctx, invoke.RelayConfig.ExecutionTimeoutFor(request.BundleName()))
+	defer cancel()
+	var execErr error
 	bundle := invoke.Catalog.Find(request.BundleName())
 	response := &messages.ExecutionResponse{}
 	if bundle == nil {
 		response.Status = "error"
 		response.StatusMessage = fmt.Sprintf("Unknown command bundle %s", request.BundleName())
+	} else if request.Explain == true {
+		logger.Infof("Explaining execution plan for %s.", request.Command)
+		response = explainExecution(request, bundle, invoke)
+	} else if missing := bundle.MissingLabels(invoke.RelayConfig.ParsedLabels); len(missing) > 0 {
+		response.Status = "error"
+		response.StatusMessage = fmt.Sprintf("Bundle %s requires Relay labels this Relay lacks: %s",
+			bundle.Name, strings.Join(missing, ", "))
+	} else if invoke.ReadOnly != nil && !invoke.ReadOnly.Permits(bundle.Commands[request.CommandName()]) {
+		_, message := invoke.ReadOnly.Status()
+		logger.Infof("Rejected %s while in read-only mode.", request.Command)
+		response.Status = "error"
+		response.StatusMessage = message
 	} else {
 		engine, err := invoke.Engines.EngineForBundle(bundle)
 		if err != nil {
+			execErr = err
 			setError(response, err)
 		} else {
 			env, err := engine.NewEnvironment(request.PipelineID(), bundle)
 			if err != nil {
+				execErr = err
 				setError(response, err)
 			} else {
 				userData, _ := env.GetUserData()
@@ -92,16 +392,128 @@ func executeCommand(decoder *json.Decoder, invoke *CommandInvocation) {
 						userData["dynamic-config"] = false
 						env.SetUserData(userData)
 					}
-					result, err := env.Run(*circuitRequest)
-					engine.ReleaseEnvironment(request.PipelineID(), bundle, env)
-					parser := NewOutputParserV1()
-					response = parser.Parse(result, *request, err)
+					settings := invoke.RelayConfig.SettingsForBundle(bundle.Name)
+					prepareCircuitRequest(circuitRequest, request, bundle, invoke)
+					if err := runPreflight(bundle.PreflightFor(request.CommandName()), circuitRequest); err != nil {
+						engine.ReleaseEnvironment(request.PipelineID(), bundle, env)
+						logger.Infof("%s for %s.", err, request.Command)
+						setError(response, err)
+					} else {
+						logger.Debugf("Executing %s.", request.Command)
+						started := time.Now()
+						result, err := engines.Run(ctx, env, *circuitRequest)
+						if err == nil {
+							runner := &contextRunner{ctx: ctx, env: env}
+							result, err = runPostProcessor(runner, bundle.PostProcessorFor(request.CommandName()),
+								request.Command, circuitRequest, result)
+						}
+						execErr = err
+						logger.Debugf("Finished %s in %v.", request.Command, time.Now().Sub(started))
+						engine.ReleaseEnvironment(request.PipelineID(), bundle, env)
+						parser := NewLimitedOutputParserV1(settings.Log)
+						response = parser.Parse(result, *request, err)
+						if reason, cancelled := invoke.Cancels.cancellation(ctx); cancelled {
+							logger.Infof("Cancelled %s: %s.", request.Command, reason)
+							response = cancelledResponse(reason)
+							execErr = nil
+						}
+					}
 				}
 			}
 		}
 	}
-	responseBytes, _ := json.Marshal(response)
-	invoke.Publisher.Publish(request.ReplyTo, responseBytes)
+	return response, execErr
+}
+
+// stoppedResponse returns the response sent for a request which gave
+// up waiting for an execution slot because of err
+func stoppedResponse(ctx context.Context, invoke *CommandInvocation, err error) *messages.ExecutionResponse {
+	if reason, cancelled := invoke.Cancels.cancellation(ctx); cancelled {
+		return cancelledResponse(reason)
+	}
+	response := &messages.ExecutionResponse{}
+	if ctx.Err() != nil {
+		err = engines.ContextError(ctx)
+	}
+	setError(response, err)
+	return response
+}
+
+func publishResponse(invoke *CommandInvocation, replyTo string, response *messages.ExecutionResponse, encoding string) {
+	// Cog was already told about requests abandoned by RecycleStuck
+	if invoke.isAbandoned() {
+		log.Warnf("Dropping late response to abandoned request %s.", response.CorrelationID)
+		return
+	}
+	sendResponse(invoke, replyTo, response, encoding)
+}
+
+func sendResponse(invoke *CommandInvocation, replyTo string, response *messages.ExecutionResponse, encoding string) {
+	var responseBytes []byte
+	response.ProtocolVersion = messages.ProtocolVersion
+	if encoding == messages.EncodingProtobuf {
+		encoded, err := messages.EncodeExecutionResponse(response)
+		if err != nil {
+			log.Errorf("Failed to encode response to %s as protobuf: %s.", replyTo, err)
+			return
+		}
+		responseBytes = encoded
+	} else {
+		responseBytes, _ = json.Marshal(response)
+	}
+	invoke.Publisher.Publish(replyTo, responseBytes)
+}
+
+// executionLogger returns a logger which tags entries with the
+// request's pipeline, bundle, command and correlation ID. When logging
+// to journald these become the PIPELINE_ID, BUNDLE, COMMAND and
+// CORRELATION_ID fields.
+func executionLogger(request *messages.ExecutionRequest) *log.Entry {
+	return log.WithFields(log.Fields{
+		"pipeline_id":    request.PipelineID(),
+		"bundle":         request.BundleName(),
+		"command":        request.Command,
+		"correlation_id": request.CorrelationID,
+	})
+}
+
+// prepareCircuitRequest applies Relay-side bundle settings and host
+// facts to a command's request
+func prepareCircuitRequest(circuitRequest *api.ExecRequest, request *messages.ExecutionRequest,
+	bundle *config.Bundle, invoke *CommandInvocation) {
+	settings := invoke.RelayConfig.SettingsForBundle(bundle.Name)
+	circuitRequest.SetExecutable(settings.ExecutableFor(request.CommandName(), circuitRequest.GetExecutable()))
+	if bundle.IsDocker() == false {
+		addNativeRuntimeEnv(circuitRequest, bundle.Name, invoke)
+	}
+	addFactsEnv(circuitRequest, invoke)
+}
+
+// addFactsEnv exposes host facts to the command via environment
+// variables and, if configured, the path to the facts file
+func addFactsEnv(request *api.ExecRequest, invoke *CommandInvocation) {
+	if invoke.Facts == nil {
+		return
+	}
+	for k, v := range invoke.Facts.Current().EnvVars() {
+		request.PutEnv(k, v)
+	}
+	if invoke.RelayConfig.Facts.File != "" {
+		request.PutEnv("RELAY_FACTS_FILE", invoke.RelayConfig.Facts.File)
+	}
+}
+
+// addNativeRuntimeEnv selects the language runtimes configured for
+// natively executed bundles by adjusting PATH and the runtimes' env
+func addNativeRuntimeEnv(request *api.ExecRequest, bundleName string, invoke *CommandInvocation) {
+	path := request.FindEnv("PATH")
+	if path == "" {
+		path = os.Getenv("PATH")
+	}
+	for k, v := range invoke.RelayConfig.NativeRuntimeEnv(bundleName, path) {
+		request.DelEnv(k)
+		request.PutEnv(k, v)
+	}
 }
 
 func setError(resp *messages.ExecutionResponse, err error) {
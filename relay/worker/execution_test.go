@@ -0,0 +1,59 @@
+package worker
+
+import (
+	"github.com/operable/go-relay/relay/config"
+	"github.com/operable/go-relay/relay/messages"
+	"golang.org/x/net/context"
+	"testing"
+)
+
+func TestShutdownRejectsRequests(t *testing.T) {
+	recorder := &responseRecorder{}
+	invoke := &CommandInvocation{
+		RelayConfig: &config.Config{QueueTTL: "0s"},
+		Publisher:   recorder,
+		Shutdown:    true,
+	}
+	request := &messages.ExecutionRequest{
+		Command:       "operable:echo",
+		ReplyTo:       "/bot/pipelines/abc/reply",
+		CorrelationID: "from-cog",
+	}
+	if err := executeCommand(context.Background(), request, messages.EncodingJSON, invoke); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorder.responses) != 1 || recorder.topics[0] != "/bot/pipelines/abc/reply" {
+		t.Fatalf("Expected one response: %v", recorder.topics)
+	}
+	response := recorder.responses[0]
+	if response.Status != "error" || response.StatusMessage != "Relay is shutting down" ||
+		response.CorrelationID != "from-cog" {
+		t.Errorf("Unexpected response: %+v", response)
+	}
+}
+
+func TestMaintenanceRejectsRequests(t *testing.T) {
+	recorder := &responseRecorder{}
+	maintenance := NewMaintenanceMode()
+	maintenance.Set(true, "")
+	invoke := &CommandInvocation{
+		RelayConfig: &config.Config{QueueTTL: "0s"},
+		Publisher:   recorder,
+		Maintenance: maintenance,
+	}
+	request := &messages.ExecutionRequest{
+		Command:       "operable:echo",
+		ReplyTo:       "/bot/pipelines/abc/reply",
+		CorrelationID: "from-cog",
+	}
+	if err := executeCommand(context.Background(), request, messages.EncodingJSON, invoke); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorder.responses) != 1 {
+		t.Fatalf("Expected one response: %v", recorder.topics)
+	}
+	response := recorder.responses[0]
+	if response.Status != "error" || response.StatusMessage != DefaultMaintenanceMessage {
+		t.Errorf("Unexpected response: %+v", response)
+	}
+}
@@ -0,0 +1,152 @@
+package worker
+
+import (
+	"fmt"
+	"github.com/operable/go-relay/relay/config"
+	"github.com/operable/go-relay/relay/engines"
+	"github.com/operable/go-relay/relay/messages"
+	"sort"
+	"strings"
+)
+
+// ExecutionPlan describes how Relay would execute a command. It's
+// returned instead of the command's output for explain requests.
+type ExecutionPlan struct {
+	Command       string   `json:"command"`
+	Engine        string   `json:"engine"`
+	Image         string   `json:"image,omitempty"`
+	ImageID       string   `json:"image_id,omitempty"`
+	ImageDigests  []string `json:"image_digests,omitempty"`
+	Executable    string   `json:"executable"`
+	PostProcessor string   `json:"post_processor,omitempty"`
+	EnvVars       []string `json:"env_vars"`
+	Mounts        []string `json:"mounts,omitempty"`
+	Limits        []string `json:"limits,omitempty"`
+	Timeout       string   `json:"timeout"`
+	Policies      []string `json:"policies"`
+}
+
+// explainExecution resolves the execution plan for a request without
+// running the command. Environment variable values are omitted since
+// they may contain credentials.
+func explainExecution(request *messages.ExecutionRequest, bundle *config.Bundle,
+	invoke *CommandInvocation) *messages.ExecutionResponse {
+	response := &messages.ExecutionResponse{}
+	circuitRequest, _, err := request.ToCircuitRequest(bundle, invoke.RelayConfig, true)
+	if err != nil {
+		setError(response, err)
+		return response
+	}
+	prepareCircuitRequest(circuitRequest, request, bundle, invoke)
+	plan := &ExecutionPlan{
+		Command:       request.Command,
+		Engine:        config.NativeEngine,
+		Executable:    circuitRequest.GetExecutable(),
+		PostProcessor: bundle.PostProcessorFor(request.CommandName()),
+		EnvVars:       []string{},
+		Timeout:       "none",
+		Policies:      explainPolicies(request, bundle, invoke),
+	}
+	for _, ev := range circuitRequest.Env {
+		plan.EnvVars = append(plan.EnvVars, ev.GetName())
+	}
+	sort.Strings(plan.EnvVars)
+	if bundle.IsDocker() {
+		explainDocker(plan, bundle, invoke)
+		if timeout := invoke.RelayConfig.ExecutionTimeoutFor(bundle.Name); timeout > 0 {
+			plan.Timeout = timeout.String()
+		}
+	}
+	response.Status = "ok"
+	response.Bundle = bundle.Name
+	response.Body = []*ExecutionPlan{plan}
+	return response
+}
+
+func explainDocker(plan *ExecutionPlan, bundle *config.Bundle, invoke *CommandInvocation) {
+	relayConfig := invoke.RelayConfig
+	plan.Engine = config.DockerEngine
+	plan.Image = bundle.Docker.PrettyImageName()
+	if engine, err := invoke.Engines.EngineForBundle(bundle); err == nil {
+		if dockerEngine, ok := engine.(*engines.DockerEngine); ok {
+			plan.ImageID, _ = dockerEngine.IDForName(bundle.Docker.Image, bundle.Docker.Tag)
+			plan.ImageDigests, _ = dockerEngine.DigestsForName(bundle.Docker.Image, bundle.Docker.Tag)
+		}
+	}
+	plan.Mounts = append(plan.Mounts, fmt.Sprintf("volumes from %s", engines.DriverContainerName(relayConfig.ID)))
+	plan.Mounts = append(plan.Mounts, bundle.Docker.Binds...)
+	docker := relayConfig.Docker
+	if docker == nil {
+		return
+	}
+	for path, options := range docker.ScratchTmpfs() {
+		plan.Mounts = append(plan.Mounts, fmt.Sprintf("tmpfs %s (%s)", path, options))
+	}
+	plan.Limits = append(plan.Limits, fmt.Sprintf("memory %dMB", docker.ContainerMemory))
+	if docker.ReadOnlyRootfs == true {
+		plan.Limits = append(plan.Limits, "read-only root filesystem")
+	}
+	settings := relayConfig.SettingsForBundle(bundle.Name)
+	if runtime := settings.ContainerRuntime(); runtime != "" {
+		plan.Limits = append(plan.Limits, fmt.Sprintf("runtime %s", runtime))
+	}
+	if settings.GPUs != "" {
+		plan.Limits = append(plan.Limits, fmt.Sprintf("GPUs %s", settings.GPUs))
+	}
+	for _, device := range settings.Devices {
+		plan.Limits = append(plan.Limits, fmt.Sprintf("device %s", device))
+	}
+	if ulimits, err := settings.UlimitSpecs(); err == nil {
+		for _, ulimit := range ulimits {
+			plan.Limits = append(plan.Limits, fmt.Sprintf("ulimit %s=%d:%d", ulimit.Name, ulimit.Soft, ulimit.Hard))
+		}
+	}
+	sysctls := []string{}
+	for name, value := range settings.Sysctls {
+		sysctls = append(sysctls, fmt.Sprintf("sysctl %s=%s", name, value))
+	}
+	sort.Strings(sysctls)
+	plan.Limits = append(plan.Limits, sysctls...)
+}
+
+func explainPolicies(request *messages.ExecutionRequest, bundle *config.Bundle, invoke *CommandInvocation) []string {
+	relayConfig := invoke.RelayConfig
+	policies := []string{}
+	if missing := bundle.MissingLabels(relayConfig.ParsedLabels); len(missing) > 0 {
+		policies = append(policies, fmt.Sprintf("rejected: missing Relay labels %s", strings.Join(missing, ", ")))
+	} else if len(bundle.Requires) > 0 {
+		policies = append(policies, fmt.Sprintf("required Relay labels %s", strings.Join(bundle.Requires, ", ")))
+	}
+	if invoke.ReadOnly != nil {
+		if enabled, _ := invoke.ReadOnly.Status(); enabled {
+			if invoke.ReadOnly.Permits(bundle.Commands[request.CommandName()]) {
+				policies = append(policies, "read-only mode: permitted")
+			} else {
+				policies = append(policies, "rejected: read-only mode")
+			}
+		}
+	}
+	if checks := bundle.PreflightFor(request.CommandName()); checks != nil {
+		policies = append(policies, fmt.Sprintf("pre-flight checks: %d env, %d reachable, %d disk space",
+			len(checks.Env), len(checks.Reachable), len(checks.DiskSpace)))
+	}
+	settings := relayConfig.SettingsForBundle(bundle.Name)
+	if bundle.IsDocker() {
+		if settings.SeccompProfile != "" {
+			policies = append(policies, fmt.Sprintf("seccomp profile %s", settings.SeccompProfile))
+		}
+		if settings.AppArmorProfile != "" {
+			policies = append(policies, fmt.Sprintf("AppArmor profile %s", settings.AppArmorProfile))
+		}
+		if relayConfig.Docker != nil && relayConfig.Docker.ContainerUser != "" {
+			policies = append(policies, fmt.Sprintf("container user %s", relayConfig.Docker.ContainerUser))
+		}
+	} else if len(settings.NativeRuntimes) > 0 {
+		policies = append(policies, fmt.Sprintf("native runtimes %s", strings.Join(settings.NativeRuntimes, ", ")))
+	}
+	if limits := settings.Log; limits.MaxLineLength > 0 || limits.MaxLines > 0 || limits.Level != "" {
+		policies = append(policies, fmt.Sprintf("log limits: max_line_length %d, max_lines %d, level %s",
+			limits.MaxLineLength, limits.MaxLines, limits.Level))
+	}
+	return policies
+}
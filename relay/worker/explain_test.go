@@ -0,0 +1,66 @@
+package worker
+
+import (
+	"github.com/operable/go-relay/relay/config"
+	"github.com/operable/go-relay/relay/messages"
+	"testing"
+)
+
+func TestExplainNativeExecution(t *testing.T) {
+	relayConfig := &config.Config{
+		Execution: &config.ExecutionInfo{
+			ParsedExtraEnv: map[string]string{
+				"API_TOKEN": "sekrit",
+			},
+		},
+		Bundles: map[string]*config.BundleSettings{
+			"ping": &config.BundleSettings{
+				Executables: map[string]string{
+					"ping": "/opt/vendor/ping",
+				},
+			},
+		},
+	}
+	bundle := &config.Bundle{
+		Name: "ping",
+		Commands: map[string]*config.BundleCommand{
+			"ping": &config.BundleCommand{
+				Executable: "/bundle/ping",
+			},
+		},
+		PostProcessor: "/bundle/summarize",
+	}
+	request := &messages.ExecutionRequest{
+		Command: "ping:ping",
+		ReplyTo: "/bot/pipelines/abc123/reply",
+		Explain: true,
+	}
+	request.Parse()
+	invoke := &CommandInvocation{
+		RelayConfig: relayConfig,
+		ReadOnly:    NewReadOnlyMode(true, "Frozen"),
+	}
+	response := explainExecution(request, bundle, invoke)
+	if response.Status != "ok" {
+		t.Fatalf("Unexpected explain status: %s %s", response.Status, response.StatusMessage)
+	}
+	plan := response.Body.([]*ExecutionPlan)[0]
+	if plan.Engine != config.NativeEngine || plan.Executable != "/opt/vendor/ping" || plan.PostProcessor != "/bundle/summarize" {
+		t.Errorf("Unexpected execution plan: %+v", plan)
+	}
+	foundToken := false
+	for _, name := range plan.EnvVars {
+		if name == "API_TOKEN" {
+			foundToken = true
+		}
+		if name == "sekrit" {
+			t.Error("Expected env var values to be omitted")
+		}
+	}
+	if foundToken == false {
+		t.Errorf("Expected API_TOKEN in env vars: %v", plan.EnvVars)
+	}
+	if len(plan.Policies) != 1 || plan.Policies[0] != "rejected: read-only mode" {
+		t.Errorf("Unexpected policies: %v", plan.Policies)
+	}
+}
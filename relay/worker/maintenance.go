@@ -0,0 +1,44 @@
+package worker
+
+import (
+	"sync"
+)
+
+// DefaultMaintenanceMessage is returned for commands rejected in
+// maintenance mode when no other message was given
+const DefaultMaintenanceMessage = "Relay is in maintenance mode"
+
+// MaintenanceMode stops a Relay running new commands while its host is
+// worked on. Unlike shutting down it can be left again.
+type MaintenanceMode struct {
+	lock    sync.RWMutex
+	enabled bool
+	message string
+}
+
+// NewMaintenanceMode constructs a new, disabled MaintenanceMode
+func NewMaintenanceMode() *MaintenanceMode {
+	return &MaintenanceMode{
+		message: DefaultMaintenanceMessage,
+	}
+}
+
+// Set enters or leaves maintenance mode. An empty message restores
+// the default.
+func (mm *MaintenanceMode) Set(enabled bool, message string) {
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+	mm.enabled = enabled
+	if message == "" {
+		message = DefaultMaintenanceMessage
+	}
+	mm.message = message
+}
+
+// Status returns whether maintenance mode is enabled and the message
+// returned for rejected commands
+func (mm *MaintenanceMode) Status() (bool, string) {
+	mm.lock.RLock()
+	defer mm.lock.RUnlock()
+	return mm.enabled, mm.message
+}
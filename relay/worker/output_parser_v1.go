@@ -3,8 +3,8 @@ package worker
 import (
 	"bytes"
 	"fmt"
-	log "github.com/Sirupsen/logrus"
 	"github.com/operable/circuit-driver/api"
+	"github.com/operable/go-relay/relay/config"
 	"github.com/operable/go-relay/relay/messages"
 	"github.com/operable/go-relay/relay/util"
 	"regexp"
@@ -14,13 +14,25 @@ import (
 type outputMatcher func([]string, *messages.ExecutionResponse, messages.ExecutionRequest)
 
 type OutputParserV1 struct {
-	matchers map[*regexp.Regexp]outputMatcher
+	matchers   map[*regexp.Regexp]outputMatcher
+	limits     config.LogLimits
+	logged     int
+	suppressed int
+	truncated  int
 }
 
 // NewOutputParserV1 returns an OutputParser instance which understands Relay's
 // original command output protocol.
 func NewOutputParserV1() OutputParser {
-	retval := &OutputParserV1{}
+	return NewLimitedOutputParserV1(config.LogLimits{})
+}
+
+// NewLimitedOutputParserV1 returns an OutputParserV1 which applies
+// limits to the COGCMD_* log lines it writes to Relay's log.
+func NewLimitedOutputParserV1(limits config.LogLimits) OutputParser {
+	retval := &OutputParserV1{
+		limits: limits,
+	}
 	retval.matchers = map[*regexp.Regexp]outputMatcher{
 		// Currently, all regexes need to capture relevant bits with
 		// subgroups, and there must be at least one subgroup.
@@ -45,6 +57,7 @@ func (op *OutputParserV1) Parse(result api.ExecResult, req messages.ExecutionReq
 		resp.StatusMessage = fmt.Sprintf("%s", err)
 		return resp
 	}
+	defer op.addLogMetadata(resp)
 	retained := []string{}
 	if len(result.Stdout) > 0 {
 		lines := strings.Split(strings.TrimSuffix(string(result.Stdout), "\n"), "\n")
@@ -108,19 +121,59 @@ func (op *OutputParserV1) writeToLog(line []string, resp *messages.ExecutionResp
 	if message == "" {
 		return
 	}
+	level := logDirectiveLevel(line[0])
+	if op.limits.LevelRank(level) < op.limits.LevelRank(op.limits.Level) {
+		op.suppressed++
+		return
+	}
+	if op.limits.MaxLines > 0 && op.logged >= op.limits.MaxLines {
+		op.suppressed++
+		return
+	}
+	op.logged++
+	if op.limits.MaxLineLength > 0 && len(message) > op.limits.MaxLineLength {
+		message = fmt.Sprintf("%s... (truncated %d bytes)", message[:op.limits.MaxLineLength],
+			len(message)-op.limits.MaxLineLength)
+		op.truncated++
+	}
 	format := "(P: %s C: %s) %s"
+	logger := executionLogger(&req)
 
 	switch line[0] {
 	case "DEBUG:":
-		log.Debugf(format, req.PipelineID(), req.Command, message)
+		logger.Debugf(format, req.PipelineID(), req.Command, message)
 	case "WARN:":
-		log.Warnf(format, req.PipelineID(), req.Command, message)
+		logger.Warnf(format, req.PipelineID(), req.Command, message)
 	case "ERR:":
 		fallthrough
 	case "ERROR:":
-		log.Errorf(format, req.PipelineID(), req.Command, message)
+		logger.Errorf(format, req.PipelineID(), req.Command, message)
+	default:
+		logger.Infof(format, req.PipelineID(), req.Command, message)
+	}
+}
+
+// addLogMetadata reports suppressed and truncated log lines to Cog
+func (op *OutputParserV1) addLogMetadata(resp *messages.ExecutionResponse) {
+	if op.suppressed == 0 && op.truncated == 0 {
+		return
+	}
+	resp.Metadata = &messages.ResponseMetadata{
+		LogLinesSuppressed: op.suppressed,
+		LogLinesTruncated:  op.truncated,
+	}
+}
+
+func logDirectiveLevel(directive string) string {
+	switch directive {
+	case "DEBUG:":
+		return "debug"
+	case "WARN:":
+		return "warn"
+	case "ERR:", "ERROR:":
+		return "error"
 	default:
-		log.Infof(format, req.PipelineID(), req.Command, message)
+		return "info"
 	}
 }
 
@@ -3,6 +3,7 @@ package worker
 import (
 	"encoding/json"
 	"github.com/operable/circuit-driver/api"
+	"github.com/operable/go-relay/relay/config"
 	"github.com/operable/go-relay/relay/messages"
 	"testing"
 )
@@ -103,3 +104,44 @@ func TestErrorOnlyIfResultIsNotSuccess(t *testing.T) {
 		t.Errorf("Unexpected response status message %s", resp.StatusMessage)
 	}
 }
+
+func TestParseLimitedLogOutput(t *testing.T) {
+	req.Parse()
+	result := api.ExecResult{
+		Stdout: []byte("COGCMD_DEBUG: chatty\nCOGCMD_WARN: first\nCOGCMD_ERROR: second is much too long\nCOGCMD_WARN: third\nabc\n"),
+		Stderr: emptyStream,
+	}
+	result.SetSuccess(true)
+	parser := NewLimitedOutputParserV1(config.LogLimits{
+		MaxLineLength: 10,
+		MaxLines:      2,
+		Level:         "warn",
+	})
+	resp := parser.Parse(result, req, nil)
+	if resp.Metadata == nil {
+		t.Fatal("Expected response metadata")
+	}
+	if resp.Metadata.LogLinesSuppressed != 2 {
+		t.Errorf("Expected 2 suppressed log lines: %d", resp.Metadata.LogLinesSuppressed)
+	}
+	if resp.Metadata.LogLinesTruncated != 1 {
+		t.Errorf("Expected 1 truncated log line: %d", resp.Metadata.LogLinesTruncated)
+	}
+	text, _ := json.Marshal(resp.Body)
+	if string(text) != `[{"body":["abc"]}]` {
+		t.Errorf("Unexpected body: %s", text)
+	}
+}
+
+func TestParseUnlimitedLogOutputHasNoMetadata(t *testing.T) {
+	req.Parse()
+	result := api.ExecResult{
+		Stdout: []byte("COGCMD_INFO: hello\nabc\n"),
+		Stderr: emptyStream,
+	}
+	result.SetSuccess(true)
+	resp := NewOutputParserV1().Parse(result, req, nil)
+	if resp.Metadata != nil {
+		t.Errorf("Expected no response metadata: %+v", resp.Metadata)
+	}
+}
@@ -0,0 +1,56 @@
+package worker
+
+import (
+	"fmt"
+	"github.com/operable/circuit"
+	"github.com/operable/circuit-driver/api"
+	"github.com/operable/go-relay/relay/engines"
+	"golang.org/x/net/context"
+	"time"
+)
+
+// PostProcessorCommandEnv names the environment variable holding the
+// full name of the command whose output is being post-processed
+const PostProcessorCommandEnv = "RELAY_POST_PROCESSED_COMMAND"
+
+type commandRunner interface {
+	Run(request api.ExecRequest) (api.ExecResult, error)
+}
+
+// contextRunner runs post-processors under their command's context
+type contextRunner struct {
+	ctx context.Context
+	env circuit.Environment
+}
+
+func (cr *contextRunner) Run(request api.ExecRequest) (api.ExecResult, error) {
+	return engines.Run(cr.ctx, cr.env, request)
+}
+
+// runPostProcessor pipes a successful command's output through the
+// bundle's post-processor in the same environment. The post-processor
+// receives the command's environment and its stdout on stdin. Failed
+// commands are returned unchanged so their errors reach the user.
+func runPostProcessor(runner commandRunner, executable string, command string,
+	request *api.ExecRequest, result api.ExecResult) (api.ExecResult, error) {
+	if executable == "" || result.GetSuccess() == false {
+		return result, nil
+	}
+	postRequest := api.NewExecRequest()
+	postRequest.SetExecutable(executable)
+	postRequest.Stdin = result.Stdout
+	for _, ev := range request.Env {
+		postRequest.PutEnv(ev.GetName(), ev.GetValue())
+	}
+	postRequest.PutEnv(PostProcessorCommandEnv, command)
+	postResult, err := runner.Run(*postRequest)
+	if err != nil {
+		return postResult, err
+	}
+	if postResult.GetSuccess() == false && len(postResult.Stderr) == 0 {
+		postResult.Stderr = []byte(fmt.Sprintf("Post-processor %s failed", executable))
+	}
+	postResult.Stderr = append(result.Stderr, postResult.Stderr...)
+	postResult.SetElapsed(time.Duration(result.GetElapsed() + postResult.GetElapsed()))
+	return postResult, nil
+}
@@ -0,0 +1,60 @@
+package worker
+
+import (
+	"github.com/operable/circuit-driver/api"
+	"strings"
+	"testing"
+)
+
+type stubRunner struct {
+	request api.ExecRequest
+	result  api.ExecResult
+}
+
+func (sr *stubRunner) Run(request api.ExecRequest) (api.ExecResult, error) {
+	sr.request = request
+	return sr.result, nil
+}
+
+func execResult(stdout string, success bool) api.ExecResult {
+	result := api.ExecResult{
+		Stdout: []byte(stdout),
+	}
+	result.SetSuccess(success)
+	result.SetElapsed(10)
+	return result
+}
+
+func TestPostProcessorOutput(t *testing.T) {
+	runner := &stubRunner{
+		result: execResult("summary", true),
+	}
+	request := api.NewExecRequest()
+	request.PutEnv("COG_BUNDLE", "ec2")
+	result, err := runPostProcessor(runner, "/bundle/summarize", "ec2:list", request, execResult("raw", true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(runner.request.Stdin) != "raw" || runner.request.GetExecutable() != "/bundle/summarize" {
+		t.Errorf("Unexpected post-processor request: %v", runner.request)
+	}
+	if runner.request.FindEnv("COG_BUNDLE") != "ec2" || runner.request.FindEnv(PostProcessorCommandEnv) != "ec2:list" {
+		t.Errorf("Unexpected post-processor environment: %v", runner.request.Env)
+	}
+	if string(result.Stdout) != "summary" || result.GetElapsed() != 20 {
+		t.Errorf("Unexpected post-processed result: %v", result)
+	}
+}
+
+func TestPostProcessorSkipsFailures(t *testing.T) {
+	runner := &stubRunner{}
+	result, _ := runPostProcessor(runner, "/bundle/summarize", "ec2:list", api.NewExecRequest(), execResult("raw", false))
+	if runner.request.Executable != nil || string(result.Stdout) != "raw" {
+		t.Error("Expected failed command output to bypass the post-processor")
+	}
+	runner.result = execResult("", false)
+	result, _ = runPostProcessor(runner, "/bundle/summarize", "ec2:list", api.NewExecRequest(), execResult("raw", true))
+	if result.GetSuccess() || !strings.Contains(string(result.Stderr), "/bundle/summarize failed") {
+		t.Errorf("Expected post-processor failure to be reported: %v", result)
+	}
+}
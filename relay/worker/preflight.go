@@ -0,0 +1,61 @@
+package worker
+
+import (
+	"fmt"
+	"github.com/operable/circuit-driver/api"
+	"github.com/operable/go-relay/relay/config"
+	"net"
+	"syscall"
+	"time"
+)
+
+var preflightDialTimeout = time.Duration(3) * time.Second
+
+// PreflightError describes the first failed pre-flight check
+type PreflightError struct {
+	Reason string
+}
+
+func (pe *PreflightError) Error() string {
+	return fmt.Sprintf("Pre-flight check failed: %s", pe.Reason)
+}
+
+// runPreflight evaluates a command's pre-flight checks against the
+// fully compiled execution request. Reachability and disk space are
+// checked from the Relay host.
+func runPreflight(checks *config.PreflightChecks, request *api.ExecRequest) error {
+	if checks == nil {
+		return nil
+	}
+	for _, name := range checks.Env {
+		if request.FindEnv(name) == "" {
+			return &PreflightError{
+				Reason: fmt.Sprintf("required environment variable %s is not set", name),
+			}
+		}
+	}
+	for _, address := range checks.Reachable {
+		conn, err := net.DialTimeout("tcp", address, preflightDialTimeout)
+		if err != nil {
+			return &PreflightError{
+				Reason: fmt.Sprintf("%s is unreachable: %s", address, err),
+			}
+		}
+		conn.Close()
+	}
+	for _, check := range checks.DiskSpace {
+		var stat syscall.Statfs_t
+		if err := syscall.Statfs(check.Path, &stat); err != nil {
+			return &PreflightError{
+				Reason: fmt.Sprintf("unable to check free disk space on %s: %s", check.Path, err),
+			}
+		}
+		freeMB := int64(stat.Bavail * uint64(stat.Bsize) / (1024 * 1024))
+		if freeMB < check.MinMB {
+			return &PreflightError{
+				Reason: fmt.Sprintf("%s has %dMB free; %dMB required", check.Path, freeMB, check.MinMB),
+			}
+		}
+	}
+	return nil
+}
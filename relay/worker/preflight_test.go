@@ -0,0 +1,56 @@
+package worker
+
+import (
+	"github.com/operable/circuit-driver/api"
+	"github.com/operable/go-relay/relay/config"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestPreflightEnv(t *testing.T) {
+	request := api.NewExecRequest()
+	request.PutEnv("API_TOKEN", "sekrit")
+	checks := &config.PreflightChecks{
+		Env: []string{"API_TOKEN"},
+	}
+	if err := runPreflight(checks, request); err != nil {
+		t.Errorf("Expected env check to pass: %s", err)
+	}
+	checks.Env = append(checks.Env, "API_HOST")
+	err := runPreflight(checks, request)
+	if err == nil || !strings.Contains(err.Error(), "API_HOST") {
+		t.Errorf("Expected env check to fail on API_HOST: %v", err)
+	}
+}
+
+func TestPreflightReachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	checks := &config.PreflightChecks{
+		Reachable: []string{address},
+	}
+	if err := runPreflight(checks, api.NewExecRequest()); err != nil {
+		t.Errorf("Expected reachability check to pass: %s", err)
+	}
+	listener.Close()
+	if err := runPreflight(checks, api.NewExecRequest()); err == nil {
+		t.Error("Expected reachability check to fail after listener closed")
+	}
+}
+
+func TestPreflightDiskSpace(t *testing.T) {
+	checks := &config.PreflightChecks{
+		DiskSpace: []config.DiskSpaceCheck{{Path: "/", MinMB: 0}},
+	}
+	if err := runPreflight(checks, api.NewExecRequest()); err != nil {
+		t.Errorf("Expected disk space check to pass: %s", err)
+	}
+	checks.DiskSpace[0].MinMB = 1 << 40
+	if err := runPreflight(checks, api.NewExecRequest()); err == nil {
+		t.Error("Expected disk space check to fail")
+	}
+}
@@ -0,0 +1,46 @@
+package worker
+
+import (
+	"fmt"
+	"github.com/operable/go-relay/relay/messages"
+	"github.com/operable/go-relay/relay/metrics"
+	"time"
+)
+
+var expiredRequests = metrics.NewCounter("relay_requests_expired_in_queue_total",
+	"Execution requests dropped because they expired before a worker picked them up.")
+
+// queueExpiry returns when a queued request stops being worth
+// running: the earlier of the request's deadline and queue_ttl after
+// it was queued. The zero time means the request never expires.
+func queueExpiry(request *messages.ExecutionRequest, invoke *CommandInvocation) time.Time {
+	var expiry time.Time
+	if ttl := invoke.RelayConfig.QueueTTLDuration(); ttl > 0 && invoke.QueuedAt.IsZero() == false {
+		expiry = invoke.QueuedAt.Add(ttl)
+	}
+	if deadline := request.DeadlineTime(); deadline.IsZero() == false {
+		if expiry.IsZero() || deadline.Before(expiry) {
+			expiry = deadline
+		}
+	}
+	return expiry
+}
+
+// expiredResponse returns the response sent for a request which
+// expired while waiting in the work queue, or nil if the request can
+// still be run
+func expiredResponse(request *messages.ExecutionRequest, invoke *CommandInvocation, now time.Time) *messages.ExecutionResponse {
+	expiry := queueExpiry(request, invoke)
+	if expiry.IsZero() || now.Before(expiry) {
+		return nil
+	}
+	expiredRequests.Inc()
+	message := "Request expired in queue before Relay could run it"
+	if invoke.QueuedAt.IsZero() == false {
+		message = fmt.Sprintf("Request expired in queue after waiting %v", now.Sub(invoke.QueuedAt).Round(time.Millisecond))
+	}
+	return &messages.ExecutionResponse{
+		Status:        "error",
+		StatusMessage: message,
+	}
+}
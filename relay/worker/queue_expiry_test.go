@@ -0,0 +1,48 @@
+package worker
+
+import (
+	"github.com/operable/go-relay/relay/config"
+	"github.com/operable/go-relay/relay/messages"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueueTTLExpiry(t *testing.T) {
+	queuedAt := time.Now()
+	invoke := &CommandInvocation{
+		RelayConfig: &config.Config{QueueTTL: "30s"},
+		QueuedAt:    queuedAt,
+	}
+	request := &messages.ExecutionRequest{}
+	if response := expiredResponse(request, invoke, queuedAt.Add(10*time.Second)); response != nil {
+		t.Errorf("Expected request to still be runnable: %+v", response)
+	}
+	before := expiredRequests.Value()
+	response := expiredResponse(request, invoke, queuedAt.Add(time.Minute))
+	if response == nil || !strings.HasPrefix(response.StatusMessage, "Request expired in queue") {
+		t.Fatalf("Expected expired in queue response: %+v", response)
+	}
+	if expiredRequests.Value() != before+1 {
+		t.Error("Expected expired request to be counted")
+	}
+}
+
+func TestRequestDeadlineExpiry(t *testing.T) {
+	queuedAt := time.Now()
+	invoke := &CommandInvocation{
+		RelayConfig: &config.Config{QueueTTL: "1h"},
+		QueuedAt:    queuedAt,
+	}
+	request := &messages.ExecutionRequest{
+		Deadline: queuedAt.Add(5 * time.Second).Unix(),
+	}
+	if expiry := queueExpiry(request, invoke); !expiry.Equal(time.Unix(request.Deadline, 0)) {
+		t.Errorf("Expected earlier request deadline to win: %v", expiry)
+	}
+	invoke.RelayConfig.QueueTTL = "0s"
+	request.Deadline = 0
+	if expiry := queueExpiry(request, invoke); !expiry.IsZero() {
+		t.Errorf("Expected request without TTL or deadline to never expire: %v", expiry)
+	}
+}
@@ -0,0 +1,48 @@
+package worker
+
+import (
+	"sync"
+	"time"
+)
+
+// Weight given to each new observation in the moving average
+const queueWaitWeight = 0.2
+
+// QueueWaits tracks a moving average of how long requests wait in the
+// work queue before a worker picks them up. A nil QueueWaits tracks
+// nothing.
+type QueueWaits struct {
+	lock    sync.Mutex
+	average float64
+	seen    bool
+}
+
+// NewQueueWaits constructs a QueueWaits
+func NewQueueWaits() *QueueWaits {
+	return &QueueWaits{}
+}
+
+// Observe records how long a request waited
+func (qw *QueueWaits) Observe(wait time.Duration) {
+	if qw == nil {
+		return
+	}
+	qw.lock.Lock()
+	defer qw.lock.Unlock()
+	if qw.seen == false {
+		qw.average = float64(wait)
+		qw.seen = true
+		return
+	}
+	qw.average += queueWaitWeight * (float64(wait) - qw.average)
+}
+
+// Average returns the moving average wait
+func (qw *QueueWaits) Average() time.Duration {
+	if qw == nil {
+		return 0
+	}
+	qw.lock.Lock()
+	defer qw.lock.Unlock()
+	return time.Duration(qw.average)
+}
@@ -0,0 +1,23 @@
+package worker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQueueWaits(t *testing.T) {
+	waits := NewQueueWaits()
+	waits.Observe(time.Second)
+	if waits.Average() != time.Second {
+		t.Errorf("Expected first wait to be the average: %v", waits.Average())
+	}
+	waits.Observe(6 * time.Second)
+	if waits.Average() != 2*time.Second {
+		t.Errorf("Unexpected average wait: %v", waits.Average())
+	}
+	var disabled *QueueWaits
+	disabled.Observe(time.Second)
+	if disabled.Average() != 0 {
+		t.Error("Expected nil QueueWaits to track nothing")
+	}
+}
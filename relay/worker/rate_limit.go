@@ -0,0 +1,179 @@
+package worker
+
+import (
+	"fmt"
+	"github.com/operable/go-relay/relay/config"
+	"github.com/operable/go-relay/relay/messages"
+	"github.com/operable/go-relay/relay/metrics"
+	"math"
+	"sync"
+	"time"
+)
+
+var rateLimitedRequests = metrics.NewCounter("relay_requests_rate_limited_total",
+	"Execution requests rejected because their requestor or bundle exceeded its rate limit.")
+
+// Buckets idle for this long are full again and can be forgotten
+const rateBucketIdle = 10 * time.Minute
+
+// Forgotten buckets are pruned once this many are tracked
+const maxRateBuckets = 1000
+
+// RateLimitedBody is the body of the error response sent when a
+// request exceeds a rate limit
+type RateLimitedBody struct {
+	Error      string `json:"error"`
+	LimitedBy  string `json:"limited_by"`
+	Key        string `json:"key"`
+	RetryAfter int    `json:"retry_after"`
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type rateLimit struct {
+	perSecond float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+}
+
+func newRateLimit(perMinute, burst int) *rateLimit {
+	if perMinute <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = perMinute
+	}
+	return &rateLimit{
+		perSecond: float64(perMinute) / 60,
+		burst:     float64(burst),
+		buckets:   make(map[string]*tokenBucket),
+	}
+}
+
+// refill tops up key's bucket as of now and returns it
+func (rl *rateLimit) refill(key string, now time.Time) *tokenBucket {
+	bucket := rl.buckets[key]
+	if bucket == nil {
+		if len(rl.buckets) >= maxRateBuckets {
+			rl.prune(now)
+		}
+		bucket = &tokenBucket{tokens: rl.burst, last: now}
+		rl.buckets[key] = bucket
+		return bucket
+	}
+	if elapsed := now.Sub(bucket.last).Seconds(); elapsed > 0 {
+		bucket.tokens = math.Min(rl.burst, bucket.tokens+elapsed*rl.perSecond)
+		bucket.last = now
+	}
+	return bucket
+}
+
+// retryAfter returns how long until bucket holds a whole token
+func (rl *rateLimit) retryAfter(bucket *tokenBucket) time.Duration {
+	return time.Duration((1 - bucket.tokens) / rl.perSecond * float64(time.Second))
+}
+
+func (rl *rateLimit) prune(now time.Time) {
+	for key, bucket := range rl.buckets {
+		if now.Sub(bucket.last) > rateBucketIdle {
+			delete(rl.buckets, key)
+		}
+	}
+}
+
+// RateLimiter applies token bucket rate limits to the requests of
+// each requestor and each bundle. A nil RateLimiter allows every
+// request.
+type RateLimiter struct {
+	lock    sync.Mutex
+	users   *rateLimit
+	bundles *rateLimit
+}
+
+// NewRateLimiter constructs a RateLimiter from Relay's rate_limit
+// settings. It returns nil if no limits are configured.
+func NewRateLimiter(info *config.RateLimitInfo) *RateLimiter {
+	if info == nil || info.Enabled() == false {
+		return nil
+	}
+	return &RateLimiter{
+		users:   newRateLimit(info.UserRate, info.UserBurst),
+		bundles: newRateLimit(info.BundleRate, info.BundleBurst),
+	}
+}
+
+// Allow consumes a token from both user's and bundle's buckets. If
+// either is empty nothing is consumed and Allow returns false along
+// with which limit ("user" or "bundle") was hit and how long until a
+// retry could succeed.
+func (rl *RateLimiter) Allow(user, bundle string, now time.Time) (bool, string, time.Duration) {
+	if rl == nil {
+		return true, "", 0
+	}
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	var userBucket, bundleBucket *tokenBucket
+	if rl.users != nil && user != "" {
+		userBucket = rl.users.refill(user, now)
+		if userBucket.tokens < 1 {
+			return false, "user", rl.users.retryAfter(userBucket)
+		}
+	}
+	if rl.bundles != nil && bundle != "" {
+		bundleBucket = rl.bundles.refill(bundle, now)
+		if bundleBucket.tokens < 1 {
+			return false, "bundle", rl.bundles.retryAfter(bundleBucket)
+		}
+	}
+	if userBucket != nil {
+		userBucket.tokens--
+	}
+	if bundleBucket != nil {
+		bundleBucket.tokens--
+	}
+	return true, "", 0
+}
+
+// requestor returns the name a request's user is rate limited by
+func requestor(request *messages.ExecutionRequest) string {
+	if request.User.Username != "" {
+		return request.User.Username
+	}
+	return request.Requestor.Handle
+}
+
+// rateLimitedResponse returns the response sent for a request over
+// a rate limit, or nil if the request may run. Retries were counted
+// when they first ran.
+func rateLimitedResponse(request *messages.ExecutionRequest, invoke *CommandInvocation, now time.Time) *messages.ExecutionResponse {
+	if invoke.Attempts > 0 {
+		return nil
+	}
+	user := requestor(request)
+	allowed, limitedBy, wait := invoke.Rates.Allow(user, request.BundleName(), now)
+	if allowed {
+		return nil
+	}
+	rateLimitedRequests.Inc()
+	key := user
+	if limitedBy == "bundle" {
+		key = request.BundleName()
+	}
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	return &messages.ExecutionResponse{
+		Status:        "error",
+		StatusMessage: fmt.Sprintf("Rate limited by %s %s. Retry after %ds", limitedBy, key, retryAfter),
+		Body: RateLimitedBody{
+			Error:      "rate_limited",
+			LimitedBy:  limitedBy,
+			Key:        key,
+			RetryAfter: retryAfter,
+		},
+	}
+}
@@ -0,0 +1,42 @@
+package worker
+
+import (
+	"github.com/operable/go-relay/relay/config"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	if NewRateLimiter(&config.RateLimitInfo{}) != nil {
+		t.Fatal("Expected no limiter without rates")
+	}
+	limiter := NewRateLimiter(&config.RateLimitInfo{UserRate: 60, UserBurst: 2, BundleRate: 60, BundleBurst: 3})
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if allowed, _, _ := limiter.Allow("alice", "deploy", now); allowed == false {
+			t.Fatalf("Expected request %d within burst to be allowed", i)
+		}
+	}
+	allowed, limitedBy, wait := limiter.Allow("alice", "deploy", now)
+	if allowed == true || limitedBy != "user" || wait != time.Second {
+		t.Errorf("Expected alice to be limited for 1s: %v %s %v", allowed, limitedBy, wait)
+	}
+	if allowed, _, _ := limiter.Allow("bob", "deploy", now); allowed == false {
+		t.Error("Expected bob to be allowed")
+	}
+	allowed, limitedBy, _ = limiter.Allow("carol", "deploy", now)
+	if allowed == true || limitedBy != "bundle" {
+		t.Errorf("Expected deploy bundle to be limited: %v %s", allowed, limitedBy)
+	}
+	// A request rejected by the bundle limit mustn't use carol's tokens
+	if allowed, _, _ := limiter.Allow("carol", "echo", now); allowed == false {
+		t.Error("Expected carol to be allowed another bundle")
+	}
+	if allowed, _, _ := limiter.Allow("alice", "echo", now.Add(time.Second)); allowed == false {
+		t.Error("Expected alice's bucket to refill")
+	}
+	var disabled *RateLimiter
+	if allowed, _, _ := disabled.Allow("alice", "deploy", now); allowed == false {
+		t.Error("Expected nil limiter to allow everything")
+	}
+}
@@ -0,0 +1,52 @@
+package worker
+
+import (
+	"github.com/operable/go-relay/relay/config"
+	"sync"
+)
+
+// ReadOnlyMode restricts execution to commands which are marked
+// read-only in their bundle's metadata. It's intended for incidents
+// and change freezes.
+type ReadOnlyMode struct {
+	lock    sync.RWMutex
+	enabled bool
+	message string
+}
+
+// NewReadOnlyMode constructs a new ReadOnlyMode
+func NewReadOnlyMode(enabled bool, message string) *ReadOnlyMode {
+	return &ReadOnlyMode{
+		enabled: enabled,
+		message: message,
+	}
+}
+
+// Set enables or disables read-only mode. An empty message keeps the
+// current maintenance message.
+func (rom *ReadOnlyMode) Set(enabled bool, message string) {
+	rom.lock.Lock()
+	defer rom.lock.Unlock()
+	rom.enabled = enabled
+	if message != "" {
+		rom.message = message
+	}
+}
+
+// Status returns whether read-only mode is enabled and the message
+// returned for rejected commands
+func (rom *ReadOnlyMode) Status() (bool, string) {
+	rom.lock.RLock()
+	defer rom.lock.RUnlock()
+	return rom.enabled, rom.message
+}
+
+// Permits returns true if command may be executed
+func (rom *ReadOnlyMode) Permits(command *config.BundleCommand) bool {
+	rom.lock.RLock()
+	defer rom.lock.RUnlock()
+	if rom.enabled == false {
+		return true
+	}
+	return command != nil && command.ReadOnly
+}
@@ -0,0 +1,25 @@
+package worker
+
+import (
+	"github.com/operable/go-relay/relay/config"
+	"testing"
+)
+
+func TestReadOnlyModePermits(t *testing.T) {
+	mode := NewReadOnlyMode(false, "Frozen")
+	writer := &config.BundleCommand{Name: "deploy"}
+	reader := &config.BundleCommand{Name: "status", ReadOnly: true}
+	if !mode.Permits(writer) {
+		t.Error("Expected disabled read-only mode to permit all commands")
+	}
+	mode.Set(true, "")
+	if mode.Permits(writer) {
+		t.Error("Expected read-only mode to reject commands not marked read-only")
+	}
+	if !mode.Permits(reader) {
+		t.Error("Expected read-only mode to permit read-only commands")
+	}
+	if _, message := mode.Status(); message != "Frozen" {
+		t.Errorf("Expected empty message to preserve existing message: %s", message)
+	}
+}
@@ -0,0 +1,39 @@
+package worker
+
+import (
+	"fmt"
+	"github.com/operable/go-relay/relay/bus"
+	"github.com/operable/go-relay/relay/messages"
+)
+
+// SaturatedBody is the body of the error response sent for requests
+// refused because Relay's work queue is saturated
+type SaturatedBody struct {
+	Error         string `json:"error"`
+	QueueDepth    int    `json:"queue_depth"`
+	QueueCapacity int    `json:"queue_capacity"`
+	WaitMillis    int64  `json:"wait_ms"`
+}
+
+// RefuseRequest answers the execution request in payload with
+// response without running it and returns the decoded request.
+// Returns an error if the request couldn't be decrypted or decoded.
+func RefuseRequest(publisher bus.MessagePublisher, sealer *messages.Sealer, strict bool,
+	payload []byte, response *messages.ExecutionResponse) (*messages.ExecutionRequest, error) {
+	if sealer != nil {
+		opened, err := sealer.OpenRequest(payload)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't decrypt request: %s", err)
+		}
+		payload = opened
+	}
+	ew := &executionWorker{}
+	request, encoding, verr := ew.decodeRequest(payload, strict)
+	if verr != nil {
+		return nil, verr
+	}
+	request.Parse()
+	response.CorrelationID = request.CorrelationID
+	publishResponse(&CommandInvocation{Publisher: publisher}, request.ReplyTo, response, encoding)
+	return request, nil
+}
@@ -0,0 +1,131 @@
+package worker
+
+import (
+	"encoding/json"
+	log "github.com/Sirupsen/logrus"
+	"github.com/operable/go-relay/relay/bus"
+	"github.com/operable/go-relay/relay/messages"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LostRequestMessage is returned to Cog for requests Relay was
+// executing when it last stopped unexpectedly
+const LostRequestMessage = "Relay restarted before the command finished"
+
+// RequestRecord identifies a request Relay has started executing and
+// where its response goes
+type RequestRecord struct {
+	CorrelationID string    `json:"correlation_id"`
+	Command       string    `json:"command"`
+	ReplyTo       string    `json:"reply_to"`
+	Encoding      string    `json:"encoding"`
+	StartedAt     time.Time `json:"started_at"`
+}
+
+// RequestJournal persists the requests Relay is executing so they
+// can be failed back to Cog after a crash instead of leaving Cog
+// waiting until they time out. A nil RequestJournal records nothing.
+type RequestJournal struct {
+	lock    sync.Mutex
+	path    string
+	records map[string]RequestRecord
+}
+
+// OpenRequestJournal opens the journal at path and returns the
+// requests left in it by the previous run, oldest first
+func OpenRequestJournal(path string) (*RequestJournal, []RequestRecord, error) {
+	rj := &RequestJournal{
+		path:    path,
+		records: make(map[string]RequestRecord),
+	}
+	lost := []RequestRecord{}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil && os.IsNotExist(err) == false {
+		return nil, nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &lost); err != nil {
+			log.Warnf("Ignoring corrupt request journal %s: %s.", path, err)
+			lost = []RequestRecord{}
+		}
+	}
+	if err := rj.save(); err != nil {
+		return nil, nil, err
+	}
+	return rj, lost, nil
+}
+
+// Begin records that a request has started executing
+func (rj *RequestJournal) Begin(record RequestRecord) {
+	if rj == nil || record.CorrelationID == "" {
+		return
+	}
+	rj.lock.Lock()
+	defer rj.lock.Unlock()
+	rj.records[record.CorrelationID] = record
+	if err := rj.save(); err != nil {
+		log.Errorf("Failed to persist request journal to %s: %s.", rj.path, err)
+	}
+}
+
+// End records that a request's response has been sent
+func (rj *RequestJournal) End(correlationID string) {
+	if rj == nil || correlationID == "" {
+		return
+	}
+	rj.lock.Lock()
+	defer rj.lock.Unlock()
+	if _, ok := rj.records[correlationID]; ok == false {
+		return
+	}
+	delete(rj.records, correlationID)
+	if err := rj.save(); err != nil {
+		log.Errorf("Failed to persist request journal to %s: %s.", rj.path, err)
+	}
+}
+
+// save writes the journal. Callers must hold the lock, except while
+// opening.
+func (rj *RequestJournal) save() error {
+	records := []RequestRecord{}
+	for _, record := range rj.records {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].StartedAt.Before(records[j].StartedAt)
+	})
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	tmp := rj.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, rj.path)
+}
+
+// FailLostRequests sends an error response to Cog for each request
+// Relay lost when it last stopped
+func FailLostRequests(publisher bus.MessagePublisher, lost []RequestRecord) {
+	invoke := &CommandInvocation{
+		Publisher: publisher,
+	}
+	for _, record := range lost {
+		log.Warnf("Failing %s (%s) which was running when Relay stopped.", record.Command, record.CorrelationID)
+		response := &messages.ExecutionResponse{
+			CorrelationID: record.CorrelationID,
+			Status:        "error",
+			StatusMessage: LostRequestMessage,
+		}
+		publishResponse(invoke, record.ReplyTo, response, record.Encoding)
+	}
+}
@@ -0,0 +1,52 @@
+package worker
+
+import (
+	"github.com/operable/go-relay/relay/messages"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestRequestJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "request_journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	journalPath := path.Join(dir, "requests.json")
+	journal, lost, err := OpenRequestJournal(journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lost) != 0 {
+		t.Errorf("Expected no lost requests: %v", lost)
+	}
+	started := time.Now().UTC()
+	journal.Begin(RequestRecord{CorrelationID: "second", Command: "operable:echo",
+		ReplyTo: "/bot/pipelines/b/reply", Encoding: messages.EncodingJSON, StartedAt: started.Add(time.Second)})
+	journal.Begin(RequestRecord{CorrelationID: "first", Command: "operable:echo",
+		ReplyTo: "/bot/pipelines/a/reply", Encoding: messages.EncodingJSON, StartedAt: started})
+	journal.Begin(RequestRecord{CorrelationID: "done", Command: "operable:echo"})
+	journal.End("done")
+	_, lost, err = OpenRequestJournal(journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lost) != 2 || lost[0].CorrelationID != "first" || lost[1].CorrelationID != "second" {
+		t.Fatalf("Unexpected lost requests: %v", lost)
+	}
+	recorder := &responseRecorder{}
+	FailLostRequests(recorder, lost)
+	if len(recorder.responses) != 2 || recorder.topics[0] != "/bot/pipelines/a/reply" {
+		t.Fatalf("Unexpected responses: %v", recorder.topics)
+	}
+	if response := recorder.responses[0]; response.Status != "error" || response.StatusMessage != LostRequestMessage ||
+		response.CorrelationID != "first" {
+		t.Errorf("Unexpected response: %+v", response)
+	}
+	if _, lost, _ = OpenRequestJournal(journalPath); len(lost) != 0 {
+		t.Errorf("Expected reopening to clear lost requests: %v", lost)
+	}
+}
@@ -0,0 +1,94 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/operable/go-relay/relay/engines"
+	"github.com/operable/go-relay/relay/messages"
+	"golang.org/x/net/context"
+)
+
+// runStages runs the stages Cog asked Relay to chain after request's
+// own command, whose response is first, and returns the response of
+// the last stage. Like Cog, a stage whose input is a list runs once
+// per item and their outputs are combined; each run is admitted and
+// timed on its own, exactly like a command sent by Cog. The chain
+// stops at the first stage which doesn't succeed.
+func runStages(ctx context.Context, request *messages.ExecutionRequest, first *messages.ExecutionResponse,
+	invoke *CommandInvocation, logger *log.Entry) (*messages.ExecutionResponse, error) {
+	response := first
+	for index := range request.Stages {
+		if response.Status != "ok" {
+			return response, nil
+		}
+		var last *messages.ExecutionResponse
+		outputs := []interface{}{}
+		for _, input := range stageInputs(response.Body) {
+			stage := request.StageRequest(index, input)
+			logger.Debugf("Running stage %d of %s locally: %s.", index+2, request.Command, stage.Command)
+			stageResponse, err := runStage(ctx, request, stage, invoke, logger)
+			if err != nil || stageResponse.Status != "ok" {
+				return stageResponse, err
+			}
+			outputs = append(outputs, stageInputs(stageResponse.Body)...)
+			last = stageResponse
+		}
+		if last == nil {
+			// Nothing was left for the remaining stages to work on
+			response.Body = outputs
+			return response, nil
+		}
+		last.Body = outputs
+		response = last
+	}
+	return response, nil
+}
+
+// runStage runs a stage once it passes the admission checks and its
+// bundle's concurrency limit allows. Stages of request's own bundle
+// run under the slot request already holds. Stages claimed by another
+// Relay fail the chain since its result can't come back to this one.
+func runStage(ctx context.Context, request *messages.ExecutionRequest, stage *messages.ExecutionRequest,
+	invoke *CommandInvocation, logger *log.Entry) (*messages.ExecutionResponse, error) {
+	rejected, claimed := admissionResponse(ctx, stage, invoke, logger)
+	if claimed == false {
+		return &messages.ExecutionResponse{
+			Status:        "error",
+			StatusMessage: fmt.Sprintf("Stage %s was claimed by another Relay", stage.Command),
+		}, nil
+	}
+	if rejected != nil {
+		return rejected, nil
+	}
+	if bundle := stage.BundleName(); bundle != request.BundleName() {
+		waitCtx, cancelWait := engines.WithExecutionTimeout(ctx, invoke.RelayConfig.ExecutionTimeoutFor(bundle))
+		defer cancelWait()
+		if err := invoke.Bundles.Wait(waitCtx, bundle); err != nil {
+			logger.Infof("Gave up waiting to run stage %s: %s.", stage.Command, err)
+			return stoppedResponse(waitCtx, invoke, err), nil
+		}
+		releaseBundle := invoke.hold(func() {
+			invoke.Bundles.Release(bundle)
+		})
+		defer releaseBundle()
+	}
+	return runCommand(ctx, stage, invoke, logger)
+}
+
+// stageInputs splits a stage's output into the inputs of the next
+// stage. Lists become one input per item.
+func stageInputs(body interface{}) []interface{} {
+	if body == nil {
+		return []interface{}{}
+	}
+	// Normalizes typed bodies, such as text output, to plain JSON values
+	var output interface{}
+	if data, err := json.Marshal(body); err == nil {
+		json.Unmarshal(data, &output)
+	}
+	if items, ok := output.([]interface{}); ok {
+		return items
+	}
+	return []interface{}{output}
+}
@@ -0,0 +1,90 @@
+package worker
+
+import (
+	"github.com/operable/go-relay/relay/bundle"
+	"github.com/operable/go-relay/relay/config"
+	"github.com/operable/go-relay/relay/messages"
+	"golang.org/x/net/context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStageInputs(t *testing.T) {
+	if inputs := stageInputs(nil); len(inputs) != 0 {
+		t.Errorf("Expected no inputs without output: %v", inputs)
+	}
+	inputs := stageInputs([]map[string]string{{"name": "a"}, {"name": "b"}})
+	if len(inputs) != 2 || reflect.DeepEqual(inputs[1], map[string]interface{}{"name": "b"}) == false {
+		t.Errorf("Expected one input per item: %v", inputs)
+	}
+	if inputs := stageInputs(map[string]interface{}{"name": "a"}); len(inputs) != 1 {
+		t.Errorf("Expected object output to be a single input: %v", inputs)
+	}
+}
+
+func TestRunStages(t *testing.T) {
+	invoke := &CommandInvocation{
+		RelayConfig: &config.Config{QueueTTL: "0s"},
+		Catalog:     bundle.NewCatalog(),
+	}
+	request := &messages.ExecutionRequest{
+		Command: "operable:echo",
+		ReplyTo: "/bot/pipelines/abc/reply",
+		Stages:  []messages.PipelineStage{{Command: "strings:upper"}},
+	}
+	request.Parse()
+	logger := executionLogger(request)
+	failed := &messages.ExecutionResponse{Status: "error", StatusMessage: "echo failed"}
+	response, err := runStages(context.Background(), request, failed, invoke, logger)
+	if err != nil || response != failed {
+		t.Errorf("Expected failed first stage to end the chain: %+v %v", response, err)
+	}
+	empty := &messages.ExecutionResponse{Status: "ok"}
+	response, err = runStages(context.Background(), request, empty, invoke, logger)
+	if err != nil || response.Status != "ok" || reflect.DeepEqual(response.Body, []interface{}{}) == false {
+		t.Errorf("Expected chain without input to end with no output: %+v %v", response, err)
+	}
+	ok := &messages.ExecutionResponse{Status: "ok", Body: []interface{}{"hello"}}
+	response, err = runStages(context.Background(), request, ok, invoke, logger)
+	if err != nil || response.Status != "error" || response.StatusMessage != "Unknown command bundle strings" {
+		t.Errorf("Expected later stage's failure to be returned: %+v %v", response, err)
+	}
+}
+
+func TestStagesAreAdmittedLikeRequests(t *testing.T) {
+	maintenance := NewMaintenanceMode()
+	maintenance.Set(true, "Down for maintenance")
+	invoke := &CommandInvocation{
+		RelayConfig: &config.Config{
+			QueueTTL: "0s",
+			Bundles: map[string]*config.BundleSettings{
+				"strings": &config.BundleSettings{Timeout: "20ms"},
+			},
+		},
+		Catalog:     bundle.NewCatalog(),
+		Maintenance: maintenance,
+		Bundles: NewBundleLimiter(func(bundle string) int {
+			return 1
+		}, func(thing interface{}) {}),
+	}
+	request := &messages.ExecutionRequest{
+		Command: "operable:echo",
+		ReplyTo: "/bot/pipelines/abc/reply",
+		Stages:  []messages.PipelineStage{{Command: "strings:upper"}},
+	}
+	request.Parse()
+	logger := executionLogger(request)
+	ok := &messages.ExecutionResponse{Status: "ok", Body: []interface{}{"hello"}}
+	response, err := runStages(context.Background(), request, ok, invoke, logger)
+	if err != nil || response.Status != "error" || response.StatusMessage != "Down for maintenance" {
+		t.Errorf("Expected stage to be rejected in maintenance mode: %+v %v", response, err)
+	}
+	maintenance.Set(false, "")
+	// Another strings command holds the bundle's only slot
+	invoke.Bundles.Wait(context.Background(), "strings")
+	response, err = runStages(context.Background(), request, ok, invoke, logger)
+	if err != nil || response.Status != "error" || strings.Contains(response.StatusMessage, "20ms") == false {
+		t.Errorf("Expected stage to give up waiting after its bundle's timeout: %+v %v", response, err)
+	}
+}
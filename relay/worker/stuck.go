@@ -0,0 +1,180 @@
+package worker
+
+import (
+	"bytes"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/operable/go-relay/relay/messages"
+	"github.com/operable/go-relay/relay/metrics"
+	"runtime/pprof"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StuckMessage is returned to Cog for commands abandoned because
+// their worker was stuck
+const StuckMessage = "Command stopped responding and was abandoned by Relay"
+
+var recycledWorkers = metrics.NewCounter("relay_stuck_workers_recycled_total",
+	"Execution workers replaced because they were stuck executing a command.")
+
+// activeRequest is the request an execution worker is running and
+// how long it may run before the worker is considered stuck
+type activeRequest struct {
+	invoke *CommandInvocation
+	record RequestRecord
+	limit  time.Duration
+}
+
+func (ew *executionWorker) setActive(active *activeRequest) {
+	ew.lock.Lock()
+	defer ew.lock.Unlock()
+	ew.active = active
+}
+
+func (ew *executionWorker) currentRequest() *activeRequest {
+	ew.lock.Lock()
+	defer ew.lock.Unlock()
+	return ew.active
+}
+
+// States of an attempt at running an invocation
+const (
+	invocationRunning int32 = iota
+	invocationAbandoned
+	invocationFinished
+)
+
+// heldSlot is capacity, such as a bundle or execution slot, held by a
+// running invocation
+type heldSlot struct {
+	once    sync.Once
+	release func()
+}
+
+func (slot *heldSlot) free() {
+	slot.once.Do(slot.release)
+}
+
+// begin readies invoke for another attempt at running it
+func (invoke *CommandInvocation) begin() {
+	invoke.holdLock.Lock()
+	defer invoke.holdLock.Unlock()
+	invoke.held = nil
+	atomic.StoreInt32(&invoke.state, invocationRunning)
+}
+
+// hold records capacity invoke's worker holds so it's freed if invoke
+// is abandoned while its worker is stuck. Returns the func the worker
+// calls to free it, which does nothing if it was already freed.
+func (invoke *CommandInvocation) hold(release func()) func() {
+	slot := &heldSlot{release: release}
+	invoke.holdLock.Lock()
+	abandoned := invoke.isAbandoned()
+	if abandoned == false {
+		invoke.held = append(invoke.held, slot)
+	}
+	invoke.holdLock.Unlock()
+	if abandoned == true {
+		slot.free()
+	}
+	return slot.free
+}
+
+// abandon marks invoke as given up on so its worker, should it ever
+// finish, doesn't answer Cog a second time, and frees the capacity the
+// worker holds. Returns false if invoke already finished or was
+// already abandoned.
+func (invoke *CommandInvocation) abandon() bool {
+	invoke.holdLock.Lock()
+	if atomic.CompareAndSwapInt32(&invoke.state, invocationRunning, invocationAbandoned) == false {
+		invoke.holdLock.Unlock()
+		return false
+	}
+	held := invoke.held
+	invoke.held = nil
+	invoke.holdLock.Unlock()
+	for _, slot := range held {
+		slot.free()
+	}
+	return true
+}
+
+// finish marks invoke's attempt as over so it can no longer be
+// abandoned. Returns false if it already was.
+func (invoke *CommandInvocation) finish() bool {
+	return atomic.CompareAndSwapInt32(&invoke.state, invocationRunning, invocationFinished)
+}
+
+func (invoke *CommandInvocation) isAbandoned() bool {
+	return atomic.LoadInt32(&invoke.state) == invocationAbandoned
+}
+
+// stuckLimit returns how long a request may run before its worker is
+// considered stuck. Chained stages each add their bundle's execution
+// timeout. Zero means never.
+func stuckLimit(request *messages.ExecutionRequest, invoke *CommandInvocation) time.Duration {
+	if invoke.RelayConfig == nil || invoke.RelayConfig.StuckWorkerMultiple <= 0 {
+		return 0
+	}
+	timeout := invoke.RelayConfig.ExecutionTimeoutFor(request.BundleName())
+	for _, stage := range request.Stages {
+		stageTimeout := invoke.RelayConfig.ExecutionTimeoutFor(stage.BundleName())
+		if stageTimeout <= 0 {
+			return 0
+		}
+		timeout += stageTimeout
+	}
+	return timeout * time.Duration(invoke.RelayConfig.StuckWorkerMultiple)
+}
+
+// RecycleStuck replaces workers which have been running a request for
+// longer than its limit. Their requests are failed back to Cog, the
+// capacity they hold is freed and the stuck goroutines are abandoned;
+// they exit if their request ever finishes. Returns the number of
+// workers recycled.
+func (s *Supervisor) RecycleStuck(now time.Time) int {
+	stuck := []*activeRequest{}
+	s.lock.Lock()
+	for id, status := range s.workers {
+		if status.Busy == false || status.executor == nil {
+			continue
+		}
+		active := status.executor.currentRequest()
+		if active == nil || active.limit <= 0 || now.Sub(status.BusySince) <= active.limit {
+			continue
+		}
+		log.Errorf("Execution worker %d has been running %s (%s) for %v. Recycling it.",
+			id, active.record.Command, active.record.CorrelationID, now.Sub(status.BusySince))
+		delete(s.workers, id)
+		// Stop doesn't wait for abandoned goroutines
+		status.set.running.Done()
+		stuck = append(stuck, active)
+		if s.current.stopped == false {
+			s.nextID++
+			s.startWorker(s.nextID, now)
+		}
+	}
+	s.lock.Unlock()
+	if len(stuck) == 0 {
+		return 0
+	}
+	var dump bytes.Buffer
+	pprof.Lookup("goroutine").WriteTo(&dump, 2)
+	log.Errorf("Goroutines while recycling stuck workers:\n%s", dump.String())
+	for _, active := range stuck {
+		recycledWorkers.Inc()
+		if active.invoke.abandon() == false {
+			continue
+		}
+		response := &messages.ExecutionResponse{
+			CorrelationID: active.record.CorrelationID,
+			Status:        "error",
+			StatusMessage: fmt.Sprintf("%s after %v", StuckMessage, active.limit),
+		}
+		sendResponse(active.invoke, active.record.ReplyTo, response, active.record.Encoding)
+		active.invoke.Journal.End(active.record.CorrelationID)
+	}
+	return len(stuck)
+}
@@ -0,0 +1,367 @@
+package worker
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+)
+
+// WorkerStatus describes the liveness of a single execution worker
+type WorkerStatus struct {
+	ID        int       `json:"id"`
+	Alive     bool      `json:"alive"`
+	Busy      bool      `json:"busy"`
+	BusySince time.Time `json:"busy_since,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	Restarts  int       `json:"restarts"`
+	LastCrash string    `json:"last_crash,omitempty"`
+	executor  *executionWorker
+	set       *workerSet
+}
+
+// Supervisor runs a pool of execution workers. Workers which crash
+// are replaced so the Relay keeps its full execution capacity. The
+// pool can be resized, and started again after being stopped.
+type Supervisor struct {
+	queue   chan interface{}
+	bgQueue chan interface{}
+	retire  chan struct{}
+	handle  func(*executionWorker, interface{})
+	lock    sync.Mutex
+	current *workerSet
+	workers map[int]*WorkerStatus
+	nextID  int
+	leaving int
+}
+
+// workerSet is the workers started since the pool was last stopped.
+// Stopping ends the set, so workers of an earlier set which are still
+// finishing a request don't hold up a restarted pool. Draining the
+// set makes its workers exit instead of taking another request.
+type workerSet struct {
+	quit     chan struct{}
+	drain    chan struct{}
+	running  sync.WaitGroup
+	stopped  bool
+	draining bool
+}
+
+func newWorkerSet() *workerSet {
+	return &workerSet{
+		quit:  make(chan struct{}),
+		drain: make(chan struct{}),
+	}
+}
+
+// Handler executes a queued request in place of Relay's own execution
+// pipeline. It is responsible for publishing a response through
+// invoke.Publisher.
+type Handler func(invoke *CommandInvocation)
+
+// NewSupervisor creates a Supervisor whose workers execute requests
+// read from queue
+func NewSupervisor(queue chan interface{}) *Supervisor {
+	return &Supervisor{
+		queue:   queue,
+		retire:  make(chan struct{}),
+		handle:  (*executionWorker).process,
+		current: newWorkerSet(),
+		workers: make(map[int]*WorkerStatus),
+	}
+}
+
+// SetHandler makes workers pass requests to handler instead of
+// executing them. Must be called before Start.
+func (s *Supervisor) SetHandler(handler Handler) {
+	s.handle = func(ew *executionWorker, thing interface{}) {
+		invoke, ok := invocationFor(thing)
+		if ok == false {
+			log.Error("Dropping improperly queued request.")
+			return
+		}
+		if invoke.InFlight != nil {
+			defer invoke.InFlight.Done()
+		}
+		handler(invoke)
+	}
+}
+
+// SetBackgroundQueue makes workers also run requests read from
+// background, but only while no request is waiting in the main queue,
+// so background work can't starve interactive commands. Must be
+// called before Start.
+func (s *Supervisor) SetBackgroundQueue(background chan interface{}) {
+	s.bgQueue = background
+}
+
+// Queued returns the number of requests waiting in both queues
+func (s *Supervisor) Queued() int {
+	return len(s.queue) + len(s.bgQueue)
+}
+
+// Start launches count workers. Starting a stopped pool forgets the
+// workers which were stopped.
+func (s *Supervisor) Start(count int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.start(count)
+}
+
+// start launches count workers. Must be called with the lock held.
+func (s *Supervisor) start(count int) {
+	if s.current.stopped == true {
+		s.current = newWorkerSet()
+		for id, status := range s.workers {
+			if status.Alive == false {
+				delete(s.workers, id)
+			}
+		}
+	}
+	for i := 0; i < count; i++ {
+		s.nextID++
+		s.startWorker(s.nextID, time.Now())
+	}
+}
+
+// Resize starts or retires workers until count are alive. Busy workers
+// aren't retired, so the pool may stay larger until they finish.
+// Returns the number of live workers.
+func (s *Supervisor) Resize(count int) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	alive, _ := s.load()
+	if count > alive {
+		s.start(count - alive)
+		return count
+	}
+	return alive - s.retireIdle(alive-count)
+}
+
+// Drain stops workers taking new requests, waits up to timeout for
+// busy workers to finish the ones they're executing and then stops
+// the pool. Requests still queued are left for whoever reads the
+// queue next. Returns false if workers were still busy when the
+// timeout expired.
+func (s *Supervisor) Drain(timeout time.Duration) bool {
+	s.lock.Lock()
+	if s.current.draining == false {
+		s.current.draining = true
+		close(s.current.drain)
+	}
+	s.lock.Unlock()
+	return s.Stop(timeout)
+}
+
+// Stop tells workers to exit once they finish their current request
+// and waits up to timeout for them to do so. Returns false if workers
+// were still running when the timeout expired.
+func (s *Supervisor) Stop(timeout time.Duration) bool {
+	s.lock.Lock()
+	set := s.current
+	if set.stopped == false {
+		set.stopped = true
+		close(set.quit)
+	}
+	s.lock.Unlock()
+	done := make(chan struct{})
+	go func() {
+		set.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Retire stops up to count idle workers. Busy workers are left alone.
+// Returns the number of workers retired.
+func (s *Supervisor) Retire(count int) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.retireIdle(count)
+}
+
+// retireIdle signals up to count idle workers to exit. Workers
+// signalled but not yet gone are no longer counted as alive. Must be
+// called with the lock held.
+func (s *Supervisor) retireIdle(count int) int {
+	retired := 0
+	defer func() {
+		s.leaving += retired
+	}()
+	for i := 0; i < count; i++ {
+		select {
+		case s.retire <- struct{}{}:
+			retired++
+		default:
+			// No idle workers are waiting for requests
+			return retired
+		}
+	}
+	return retired
+}
+
+// Load returns the number of live workers and how many of them are
+// executing requests
+func (s *Supervisor) Load() (int, int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.load()
+}
+
+// load counts live and busy workers. Must be called with the lock
+// held.
+func (s *Supervisor) load() (int, int) {
+	alive := -s.leaving
+	busy := 0
+	for _, status := range s.workers {
+		if status.Alive == true {
+			alive++
+		}
+		if status.Busy == true {
+			busy++
+		}
+	}
+	return alive, busy
+}
+
+// Status returns the state of every worker, sorted by ID
+func (s *Supervisor) Status() []WorkerStatus {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	statuses := []WorkerStatus{}
+	for _, status := range s.workers {
+		statuses = append(statuses, *status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].ID < statuses[j].ID
+	})
+	return statuses
+}
+
+// startWorker starts a worker in the current set. Must be called with
+// the lock held.
+func (s *Supervisor) startWorker(id int, now time.Time) {
+	s.workers[id] = &WorkerStatus{
+		ID:        id,
+		Alive:     true,
+		StartedAt: now,
+		set:       s.current,
+	}
+	s.current.running.Add(1)
+	go s.runWorker(id, s.current)
+}
+
+func (s *Supervisor) runWorker(id int, set *workerSet) {
+	defer s.workerExited(id, set)
+	ew := &executionWorker{}
+	s.lock.Lock()
+	s.workers[id].executor = ew
+	s.lock.Unlock()
+	for {
+		var thing interface{}
+		select {
+		case <-set.drain:
+			return
+		default:
+		}
+		select {
+		case thing = <-s.queue:
+		default:
+			select {
+			case <-set.drain:
+				return
+			case <-set.quit:
+				return
+			case <-s.retire:
+				s.retired(id)
+				return
+			case thing = <-s.queue:
+			case thing = <-s.bgQueue:
+			}
+		}
+		s.setBusy(id, true)
+		s.handle(ew, thing)
+		if s.setBusy(id, false) == false {
+			// Recycled while stuck and already replaced
+			return
+		}
+	}
+}
+
+// retired records that a worker took a retirement signal and is
+// exiting
+func (s *Supervisor) retired(id int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.leaving--
+	if status := s.workers[id]; status != nil {
+		status.Alive = false
+	}
+}
+
+// setBusy records whether a worker is executing a request. Returns
+// false if the worker has been recycled.
+func (s *Supervisor) setBusy(id int, busy bool) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	status := s.workers[id]
+	if status == nil {
+		return false
+	}
+	status.Busy = busy
+	status.BusySince = time.Time{}
+	if busy == true {
+		status.BusySince = time.Now()
+	}
+	return true
+}
+
+// workerExited records a worker's exit and starts a replacement if it
+// crashed. The replacement is added to the WaitGroup before the
+// crashed worker is removed so Stop never sees a transient zero count.
+// Retired workers and workers of earlier sets are forgotten.
+func (s *Supervisor) workerExited(id int, set *workerSet) {
+	crash := recover()
+	s.lock.Lock()
+	status := s.workers[id]
+	if status == nil {
+		// Recycled workers were replaced, and stopped being waited
+		// for, when they were recycled
+		s.lock.Unlock()
+		if crash != nil {
+			log.Errorf("Recycled execution worker %d crashed: %v.", id, crash)
+		}
+		return
+	}
+	status.Alive = false
+	status.Busy = false
+	status.BusySince = time.Time{}
+	replace := false
+	if crash != nil {
+		status.LastCrash = fmt.Sprintf("%v", crash)
+		log.Errorf("Execution worker %d crashed: %v.\n%s", id, crash, debug.Stack())
+		if set.stopped == false {
+			replace = true
+			status.Alive = true
+			status.Restarts++
+			status.StartedAt = time.Now()
+			set.running.Add(1)
+		}
+	}
+	if replace == false && (set.stopped == false || set != s.current) {
+		delete(s.workers, id)
+	}
+	s.lock.Unlock()
+	set.running.Done()
+	if replace == true {
+		log.Infof("Replaced crashed execution worker %d.", id)
+		go s.runWorker(id, set)
+	}
+}
@@ -0,0 +1,438 @@
+package worker
+
+import (
+	"encoding/json"
+	"github.com/operable/go-relay/relay/config"
+	"github.com/operable/go-relay/relay/messages"
+	"golang.org/x/net/context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSupervisorReplacesCrashedWorkers(t *testing.T) {
+	queue := make(chan interface{})
+	supervisor := NewSupervisor(queue)
+	var handled sync.WaitGroup
+	supervisor.handle = func(ew *executionWorker, thing interface{}) {
+		defer handled.Done()
+		if thing == "crash" {
+			panic("boom")
+		}
+	}
+	supervisor.Start(1)
+	handled.Add(2)
+	queue <- "crash"
+	queue <- "ok"
+	handled.Wait()
+	statuses := supervisor.Status()
+	if len(statuses) != 1 {
+		t.Fatalf("Unexpected worker count: %d", len(statuses))
+	}
+	if status := statuses[0]; status.Alive == false || status.Restarts != 1 || status.LastCrash != "boom" {
+		t.Errorf("Unexpected worker status: %+v", status)
+	}
+	if supervisor.Stop(time.Second) == false {
+		t.Error("Expected workers to exit")
+	}
+	if supervisor.Status()[0].Alive == true {
+		t.Error("Expected stopped worker to be reported dead")
+	}
+}
+
+func TestSupervisorResize(t *testing.T) {
+	queue := make(chan interface{})
+	supervisor := NewSupervisor(queue)
+	if alive := supervisor.Resize(3); alive != 3 {
+		t.Errorf("Expected pool to grow to 3 workers: %d", alive)
+	}
+	// Only workers waiting for requests can be retired
+	deadline := time.Now().Add(time.Second)
+	for alive, _ := supervisor.Load(); alive != 1 && time.Now().Before(deadline); alive, _ = supervisor.Load() {
+		supervisor.Resize(1)
+		time.Sleep(10 * time.Millisecond)
+	}
+	for time.Now().Before(deadline) && len(supervisor.Status()) != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	if statuses := supervisor.Status(); len(statuses) != 1 {
+		t.Errorf("Expected pool to shrink to 1 worker: %+v", statuses)
+	}
+	supervisor.Stop(time.Second)
+}
+
+func TestSupervisorConcurrentResize(t *testing.T) {
+	queue := make(chan interface{})
+	supervisor := NewSupervisor(queue)
+	var resizing sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		resizing.Add(1)
+		go func() {
+			defer resizing.Done()
+			supervisor.Resize(4)
+		}()
+	}
+	resizing.Wait()
+	if alive, _ := supervisor.Load(); alive != 4 {
+		t.Errorf("Expected concurrent resizes not to overshoot: %d", alive)
+	}
+	supervisor.Stop(time.Second)
+}
+
+func TestSupervisorDrain(t *testing.T) {
+	queue := make(chan interface{}, 2)
+	supervisor := NewSupervisor(queue)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handled := make(chan interface{}, 2)
+	supervisor.handle = func(ew *executionWorker, thing interface{}) {
+		if thing == "busy" {
+			close(started)
+			<-release
+		}
+		handled <- thing
+	}
+	supervisor.Start(1)
+	queue <- "busy"
+	<-started
+	queue <- "queued"
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+	}()
+	if supervisor.Drain(time.Second) == false {
+		t.Fatal("Expected busy worker to finish")
+	}
+	if thing := <-handled; thing != "busy" {
+		t.Errorf("Unexpected request: %v", thing)
+	}
+	if len(handled) != 0 || len(queue) != 1 {
+		t.Errorf("Expected drained pool not to take new requests: %d handled, %d queued", len(handled), len(queue))
+	}
+}
+
+func TestSupervisorRestart(t *testing.T) {
+	queue := make(chan interface{})
+	supervisor := NewSupervisor(queue)
+	handled := make(chan interface{}, 1)
+	supervisor.handle = func(ew *executionWorker, thing interface{}) {
+		handled <- thing
+	}
+	supervisor.Start(2)
+	if supervisor.Stop(time.Second) == false {
+		t.Fatal("Expected workers to exit")
+	}
+	supervisor.Start(1)
+	statuses := supervisor.Status()
+	if len(statuses) != 1 || statuses[0].Alive == false {
+		t.Fatalf("Expected only the restarted worker: %+v", statuses)
+	}
+	queue <- "after-restart"
+	select {
+	case thing := <-handled:
+		if thing != "after-restart" {
+			t.Errorf("Unexpected request: %v", thing)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Restarted pool never ran a request")
+	}
+	if supervisor.Stop(time.Second) == false {
+		t.Error("Expected restarted workers to exit")
+	}
+}
+
+func TestSupervisorReleasesInFlightOnCrash(t *testing.T) {
+	queue := make(chan interface{})
+	supervisor := NewSupervisor(queue)
+	supervisor.Start(1)
+	var inFlight sync.WaitGroup
+	inFlight.Add(1)
+	// The reply topic is too short for ExecutionRequest.Parse, which panics
+	invoke := &CommandInvocation{
+		InFlight: &inFlight,
+		Payload:  []byte(`{"command":"test:echo","reply_to":"test/replies"}`),
+	}
+	queue <- context.WithValue(context.Background(), "invoke", invoke)
+	done := make(chan struct{})
+	go func() {
+		inFlight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("In-flight request was never released")
+	}
+	supervisor.Stop(time.Second)
+}
+
+func TestSupervisorRespondsToCrashedRequests(t *testing.T) {
+	queue := make(chan interface{})
+	supervisor := NewSupervisor(queue)
+	supervisor.Start(1)
+	recorder := &responseRecorder{}
+	var inFlight sync.WaitGroup
+	inFlight.Add(1)
+	// Without engines executing the command panics
+	invoke := &CommandInvocation{
+		RelayConfig: &config.Config{QueueTTL: "0s"},
+		Publisher:   recorder,
+		InFlight:    &inFlight,
+		Payload: []byte(`{"command":"test:echo","reply_to":"/bot/pipelines/abc/reply",` +
+			`"room":{},"requestor":{},"user":{},"cog_env":{},"args":[],"options":{}}`),
+	}
+	queue <- context.WithValue(context.Background(), "invoke", invoke)
+	inFlight.Wait()
+	if len(recorder.responses) != 1 || recorder.responses[0].Status != "error" ||
+		strings.HasPrefix(recorder.responses[0].StatusMessage, "Relay crashed") == false {
+		t.Errorf("Expected crashed request to be failed: %+v", recorder.responses)
+	}
+	// In-flight accounting is released before the Supervisor notices
+	deadline := time.Now().Add(time.Second)
+	for supervisor.Status()[0].Restarts != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if statuses := supervisor.Status(); len(statuses) != 1 || statuses[0].Restarts != 1 {
+		t.Errorf("Expected crashed worker to be replaced: %+v", statuses)
+	}
+	supervisor.Stop(time.Second)
+}
+
+func TestSupervisorRespondsToRequestsCrashingBeforeExecution(t *testing.T) {
+	queue := make(chan interface{})
+	supervisor := NewSupervisor(queue)
+	supervisor.Start(1)
+	recorder := &responseRecorder{}
+	var inFlight sync.WaitGroup
+	inFlight.Add(1)
+	// A BundleLimiter without a limit function panics
+	invoke := &CommandInvocation{
+		RelayConfig: &config.Config{},
+		Bundles:     &BundleLimiter{},
+		Publisher:   recorder,
+		InFlight:    &inFlight,
+		Payload: []byte(`{"command":"test:echo","reply_to":"/bot/pipelines/abc/reply","correlation_id":"abc",` +
+			`"room":{},"requestor":{},"user":{},"cog_env":{},"args":[],"options":{}}`),
+	}
+	queue <- context.WithValue(context.Background(), "invoke", invoke)
+	inFlight.Wait()
+	if len(recorder.responses) != 1 || recorder.topics[0] != "/bot/pipelines/abc/reply" ||
+		recorder.responses[0].CorrelationID != "abc" || recorder.responses[0].Status != "error" {
+		t.Errorf("Expected request which crashed before executing to be failed: %+v", recorder.responses)
+	}
+	supervisor.Stop(time.Second)
+}
+
+func TestSupervisorCustomHandler(t *testing.T) {
+	queue := make(chan interface{})
+	supervisor := NewSupervisor(queue)
+	handled := make(chan string, 1)
+	supervisor.SetHandler(func(invoke *CommandInvocation) {
+		handled <- invoke.Topic
+	})
+	supervisor.Start(1)
+	var inFlight sync.WaitGroup
+	inFlight.Add(1)
+	invoke := &CommandInvocation{
+		InFlight: &inFlight,
+		Topic:    "/bot/commands/abc",
+	}
+	queue <- context.WithValue(context.Background(), "invoke", invoke)
+	select {
+	case topic := <-handled:
+		if topic != "/bot/commands/abc" {
+			t.Errorf("Unexpected request: %s", topic)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Handler was never called")
+	}
+	inFlight.Wait()
+	supervisor.Stop(time.Second)
+}
+
+func TestSupervisorRecyclesStuckWorkers(t *testing.T) {
+	queue := make(chan interface{})
+	supervisor := NewSupervisor(queue)
+	recorder := &responseRecorder{}
+	release := make(chan struct{})
+	started := make(chan struct{})
+	finished := make(chan struct{})
+	supervisor.handle = func(ew *executionWorker, thing interface{}) {
+		invoke := &CommandInvocation{Publisher: recorder}
+		ew.setActive(&activeRequest{
+			invoke: invoke,
+			record: RequestRecord{CorrelationID: "wedged", ReplyTo: "/bot/pipelines/abc/reply",
+				Encoding: messages.EncodingJSON},
+			limit: time.Second,
+		})
+		close(started)
+		<-release
+		publishResponse(invoke, "/bot/pipelines/abc/reply", &messages.ExecutionResponse{CorrelationID: "wedged"},
+			messages.EncodingJSON)
+		close(finished)
+	}
+	supervisor.Start(1)
+	queue <- "wedge"
+	<-started
+	if recycled := supervisor.RecycleStuck(time.Now()); recycled != 0 {
+		t.Errorf("Recycled a worker before its limit: %d", recycled)
+	}
+	if recycled := supervisor.RecycleStuck(time.Now().Add(time.Minute)); recycled != 1 {
+		t.Fatalf("Expected one stuck worker to be recycled: %d", recycled)
+	}
+	if len(recorder.responses) != 1 || recorder.responses[0].CorrelationID != "wedged" ||
+		recorder.responses[0].Status != "error" {
+		t.Errorf("Expected stuck request to be failed: %+v", recorder.responses)
+	}
+	statuses := supervisor.Status()
+	if len(statuses) != 1 || statuses[0].ID == 1 || statuses[0].Busy == true {
+		t.Errorf("Expected an idle replacement worker: %+v", statuses)
+	}
+	close(release)
+	<-finished
+	if len(recorder.responses) != 1 {
+		t.Errorf("Expected abandoned request's late response to be dropped: %+v", recorder.responses)
+	}
+	if supervisor.Stop(time.Second) == false {
+		t.Error("Expected workers to exit")
+	}
+}
+
+// wedgingPublisher blocks publishing its first message until release
+// is closed
+type wedgingPublisher struct {
+	lock      sync.Mutex
+	wedged    chan struct{}
+	release   chan struct{}
+	unwedged  chan struct{}
+	published chan string
+}
+
+func (wp *wedgingPublisher) Publish(topic string, message []byte) error {
+	wp.lock.Lock()
+	first := wp.wedged != nil
+	wedged := wp.wedged
+	wp.wedged = nil
+	wp.lock.Unlock()
+	if first == true {
+		close(wedged)
+		<-wp.release
+		defer close(wp.unwedged)
+	}
+	var response messages.ExecutionResponse
+	json.Unmarshal(message, &response)
+	wp.published <- response.CorrelationID
+	return nil
+}
+
+func TestSupervisorRecyclingFreesHeldCapacity(t *testing.T) {
+	queue := make(chan interface{}, 4)
+	supervisor := NewSupervisor(queue)
+	supervisor.Start(1)
+	publisher := &wedgingPublisher{
+		wedged:    make(chan struct{}),
+		release:   make(chan struct{}),
+		unwedged:  make(chan struct{}),
+		published: make(chan string, 4),
+	}
+	wedged := publisher.wedged
+	bundles := NewBundleLimiter(func(bundle string) int {
+		return 1
+	}, func(thing interface{}) {
+		queue <- thing
+	})
+	limiter := NewConcurrencyLimiter(false, 1, 1)
+	relayConfig := &config.Config{QueueTTL: "0s", ExecutionTimeout: "1s", StuckWorkerMultiple: 1}
+	var inFlight sync.WaitGroup
+	// Requests arriving while shutting down are answered without
+	// needing engines, so the wedged publisher wedges the first
+	for _, id := range []string{"wedged", "next"} {
+		inFlight.Add(1)
+		invoke := &CommandInvocation{
+			RelayConfig: relayConfig,
+			Publisher:   publisher,
+			InFlight:    &inFlight,
+			Bundles:     bundles,
+			Limiter:     limiter,
+			Shutdown:    true,
+			Payload: []byte(`{"command":"test:echo","reply_to":"/bot/pipelines/abc/reply","correlation_id":"` + id +
+				`","room":{},"requestor":{},"user":{},"cog_env":{},"args":[],"options":{}}`),
+		}
+		queue <- context.WithValue(context.Background(), "invoke", invoke)
+		if id == "wedged" {
+			<-wedged
+		}
+	}
+	if recycled := supervisor.RecycleStuck(time.Now().Add(time.Minute)); recycled != 1 {
+		t.Fatalf("Expected one stuck worker to be recycled: %d", recycled)
+	}
+	answered := map[string]bool{}
+	for len(answered) < 2 {
+		select {
+		case id := <-publisher.published:
+			answered[id] = true
+		case <-time.After(time.Second):
+			t.Fatalf("Expected the next request of the bundle to run: %v", answered)
+		}
+	}
+	if answered["wedged"] == false || answered["next"] == false {
+		t.Errorf("Expected both requests to be answered: %v", answered)
+	}
+	done := make(chan struct{})
+	go func() {
+		inFlight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("Expected recycled request to stop being in flight")
+	}
+	if supervisor.Stop(time.Second) == false {
+		t.Error("Expected Stop not to wait for the recycled worker")
+	}
+	close(publisher.release)
+	<-publisher.unwedged
+	// The recycled worker must not free its capacity a second time once
+	// it finishes
+	time.Sleep(20 * time.Millisecond)
+	if status := limiter.Status(); status.InFlight != 0 {
+		t.Errorf("Expected no execution slots in use: %+v", status)
+	}
+	bundles.lock.Lock()
+	running := bundles.running["test"]
+	bundles.lock.Unlock()
+	if running != 0 {
+		t.Errorf("Expected no bundle slots in use: %d", running)
+	}
+}
+
+func TestSupervisorPrefersInteractiveRequests(t *testing.T) {
+	queue := make(chan interface{}, 4)
+	background := make(chan interface{}, 4)
+	supervisor := NewSupervisor(queue)
+	supervisor.SetBackgroundQueue(background)
+	var handled sync.WaitGroup
+	order := make(chan interface{}, 4)
+	supervisor.handle = func(ew *executionWorker, thing interface{}) {
+		defer handled.Done()
+		order <- thing
+	}
+	handled.Add(4)
+	background <- "report-1"
+	background <- "report-2"
+	queue <- "chat-1"
+	queue <- "chat-2"
+	if supervisor.Queued() != 4 {
+		t.Errorf("Expected 4 queued requests: %d", supervisor.Queued())
+	}
+	supervisor.Start(1)
+	handled.Wait()
+	for _, expected := range []string{"chat-1", "chat-2"} {
+		if thing := <-order; thing != expected {
+			t.Errorf("Expected %s before background requests: %v", expected, thing)
+		}
+	}
+	supervisor.Stop(time.Second)
+}
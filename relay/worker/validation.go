@@ -0,0 +1,50 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/operable/go-relay/relay/messages"
+	"github.com/operable/go-relay/relay/metrics"
+)
+
+var rejectedRequests = metrics.NewCounter("relay_requests_rejected_total",
+	"Execution requests rejected because they failed schema validation.")
+
+// rejectRequest answers a request which failed validation with an
+// error response whose body is the validation error, so Cog can report
+// exactly what was wrong. Requests without a usable reply_to can only
+// be logged.
+func rejectRequest(verr *messages.ValidationError, encoding string, invoke *CommandInvocation) {
+	rejectedRequests.Inc()
+	if verr.ReplyTo == "" {
+		log.Errorf("Ignoring execution request on %s: %s.", invoke.Topic, verr)
+		return
+	}
+	log.Warnf("Rejected execution request on %s: %s.", invoke.Topic, verr)
+	response := &messages.ExecutionResponse{
+		Status:        "error",
+		StatusMessage: verr.Error(),
+		Body:          verr,
+	}
+	publishResponse(invoke, verr.ReplyTo, response, encoding)
+}
+
+// rejectSealedRequest answers a sealed request which couldn't be
+// decrypted with an error response if its envelope says where to send
+// one. Otherwise it can only be logged.
+func rejectSealedRequest(payload []byte, err error, invoke *CommandInvocation) {
+	envelope := messages.SealedEnvelope{}
+	json.Unmarshal(payload, &envelope)
+	if envelope.ReplyTo == "" {
+		log.Errorf("Ignoring execution request on %s which couldn't be decrypted: %s.", invoke.Topic, err)
+		return
+	}
+	log.Warnf("Rejected execution request on %s which couldn't be decrypted: %s.", invoke.Topic, err)
+	response := &messages.ExecutionResponse{
+		CorrelationID: envelope.CorrelationID,
+		Status:        "error",
+		StatusMessage: fmt.Sprintf("Relay couldn't decrypt the request: %s", err),
+	}
+	publishResponse(invoke, envelope.ReplyTo, response, messages.EncodingJSON)
+}
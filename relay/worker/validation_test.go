@@ -0,0 +1,76 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/operable/go-relay/relay/config"
+	"github.com/operable/go-relay/relay/messages"
+	"golang.org/x/net/context"
+	"strings"
+	"testing"
+)
+
+type responseRecorder struct {
+	topics    []string
+	responses []messages.ExecutionResponse
+}
+
+func (rr *responseRecorder) Publish(topic string, message []byte) error {
+	var response messages.ExecutionResponse
+	json.Unmarshal(message, &response)
+	rr.topics = append(rr.topics, topic)
+	rr.responses = append(rr.responses, response)
+	return nil
+}
+
+func TestRejectRequest(t *testing.T) {
+	recorder := &responseRecorder{}
+	invoke := &CommandInvocation{Publisher: recorder, Topic: "/bot/commands/relay-1/operable/echo"}
+	before := rejectedRequests.Value()
+	rejectRequest(messages.NewValidationError("args", "must be an array", ""), messages.EncodingJSON, invoke)
+	if len(recorder.responses) != 0 {
+		t.Error("Expected request without reply_to to only be logged")
+	}
+	rejectRequest(messages.NewValidationError("args", "must be an array", "/bot/pipelines/abc/reply"), messages.EncodingJSON, invoke)
+	if len(recorder.responses) != 1 || recorder.topics[0] != "/bot/pipelines/abc/reply" {
+		t.Fatalf("Expected one error response: %v", recorder.topics)
+	}
+	response := recorder.responses[0]
+	body, _ := response.Body.(map[string]interface{})
+	if response.Status != "error" || body["field"] != "args" || body["schema_version"] != float64(1) {
+		t.Errorf("Unexpected error response: %+v", response)
+	}
+	if rejectedRequests.Value() != before+2 {
+		t.Error("Expected rejected requests to be counted")
+	}
+}
+
+func TestRejectSealedRequest(t *testing.T) {
+	recorder := &responseRecorder{}
+	cogSealer, _ := messages.NewSealer("relay-1", bytes.Repeat([]byte{7}, 32), "")
+	relaySealer, _ := messages.NewSealer("relay-1", bytes.Repeat([]byte{8}, 32), "")
+	sealed, _ := cogSealer.Seal([]byte(`{"command":"operable:echo"}`))
+	invoke := &CommandInvocation{
+		RelayConfig: &config.Config{},
+		Publisher:   recorder,
+		Sealer:      relaySealer,
+		Topic:       "/bot/commands/relay-1/operable/echo",
+	}
+	worker := &executionWorker{}
+	invoke.Payload, _ = json.Marshal(messages.SealedEnvelope{Sealed: sealed})
+	worker.process(context.WithValue(context.Background(), "invoke", invoke))
+	if len(recorder.responses) != 0 {
+		t.Error("Expected request without reply_to to only be logged")
+	}
+	invoke.Payload, _ = json.Marshal(messages.SealedEnvelope{Sealed: sealed, ReplyTo: "/bot/pipelines/abc/reply",
+		CorrelationID: "from-cog"})
+	worker.process(context.WithValue(context.Background(), "invoke", invoke))
+	if len(recorder.responses) != 1 || recorder.topics[0] != "/bot/pipelines/abc/reply" {
+		t.Fatalf("Expected one error response: %v", recorder.topics)
+	}
+	response := recorder.responses[0]
+	if response.Status != "error" || response.CorrelationID != "from-cog" ||
+		strings.HasPrefix(response.StatusMessage, "Relay couldn't decrypt the request") == false {
+		t.Errorf("Unexpected response: %+v", response)
+	}
+}